@@ -3,11 +3,15 @@
 package add
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/ha1tch/plus3/internal/stdio"
 	"github.com/ha1tch/plus3/pkg/diskimg"
 )
 
@@ -27,6 +31,19 @@ const (
 	TypeScreen
 	// TypeRaw indicates data without special handling
 	TypeRaw
+	// TypeTape indicates a TAP image whose files are all added
+	TypeTape
+	// TypeImage indicates a host picture to be converted to a SCREEN$
+	TypeImage
+	// TypeArray indicates a CSV or JSON file to be stored as a BASIC array
+	TypeArray
+	// TypeText indicates a host text file converted to CP/M conventions
+	TypeText
+	// TypeHobeta indicates a single TR-DOS file in a Hobeta (.$x) container
+	TypeHobeta
+	// TypeAmsdos indicates a CPC file with an AMSDOS header, converted to
+	// PLUS3DOS
+	TypeAmsdos
 )
 
 // AddOptions configures the Add operation
@@ -36,6 +53,17 @@ type AddOptions struct {
 	LoadAddr uint16 // Load address for CODE files
 	Force    bool   // Allow overwriting existing files
 	Quiet    bool   // Suppress non-error output
+	Fidelity bool   // Keep the FDC status of rewritten sectors
+	Backup   bool   // Keep the previous image as <disk>.bak
+	Journal  bool   // Record the change in <disk>.journal for undo
+	Sanitise bool   // Make names that are not valid CP/M names valid
+
+	Dither diskimg.Dither        // Dithering for pictures converted to SCREEN$
+	Clash  diskimg.ClashStrategy // How a converted picture's cell colours are chosen
+	Var    string                // Array variable, as a or a$
+
+	CR      bool // End text lines with CR rather than CRLF
+	Charset bool // Map UTF-8 text to the Spectrum character set
 }
 
 // DefaultAddOptions returns default options for Add
@@ -46,6 +74,15 @@ func DefaultAddOptions() *AddOptions {
 		LoadAddr: 32768, // Standard default address
 		Force:    false,
 		Quiet:    false,
+		Fidelity: false,
+		Backup:   false,
+		Journal:  false,
+		Sanitise: false,
+		Dither:   diskimg.DitherNone,
+		Clash:    diskimg.ClashBestPair,
+		Var:      "",
+		CR:       false,
+		Charset:  false,
 	}
 }
 
@@ -59,9 +96,15 @@ func determineFileType(path string) FileType {
 		return TypeCode
 	case ".scr":
 		return TypeScreen
-	default:
-		return TypeRaw
+	case ".tap":
+		return TypeTape
+	case ".png", ".bmp", ".jpg", ".jpeg", ".gif":
+		return TypeImage
+	}
+	if len(ext) == 3 && ext[1] == '$' {
+		return TypeHobeta
 	}
+	return TypeRaw
 }
 
 // Add imports a file into the disk image
@@ -86,37 +129,54 @@ func Add(diskPath string, filePath string, opts *AddOptions) error {
 	}
 
 	// Validate disk exists
-	if _, err := os.Stat(diskPath); os.IsNotExist(err) {
-		return fmt.Errorf("disk image does not exist: %w", err)
+	if err := stdio.Exists(diskPath); err != nil {
+		return err
 	}
 
 	// Open disk image
-	disk, err := diskimg.LoadFromFile(diskPath)
+	disk, err := stdio.LoadDisk(diskPath, &diskimg.LoadOptions{Fidelity: opts.Fidelity})
 	if err != nil {
 		return fmt.Errorf("failed to open disk: %w", err)
 	}
+	if err := stdio.StartJournal(disk, diskPath, opts.Journal); err != nil {
+		return err
+	}
+	disk.SetSanitiseNames(opts.Sanitise)
 
 	// Determine file type if auto
 	fileType := opts.FileType
 	if fileType == TypeAuto {
 		fileType = determineFileType(filePath)
+		if (fileType == TypeCode || fileType == TypeRaw) && hasAmsdosHeader(filePath) {
+			fileType = TypeAmsdos
+		}
 	}
 
-	// Check if file already exists unless force is true
-	if !opts.Force {
-		dir, err := disk.GetDirectory()
-		if err != nil {
-			return fmt.Errorf("failed to read directory: %w", err)
+	// A file of the same name is an error unless forced, when it is deleted
+	// first: in a transaction with the import, so a failed import leaves it
+	// as it was. A tape's files are numbered rather than replace one already
+	// there; a Hobeta file's name comes from its header, so addHobeta checks
+	// it.
+	tx := disk.Begin()
+	defer tx.Rollback()
+	if fileType != TypeTape && fileType != TypeHobeta {
+		ext := ""
+		switch fileType {
+		case TypeBasic, TypeBasicText:
+			ext = "BAS"
+		case TypeCode:
+			ext = "BIN"
+		case TypeScreen, TypeImage:
+			ext = "SCR"
+		case TypeArray:
+			ext = "DAT"
 		}
-
-		destName := strings.ToUpper(filepath.Base(filePath))
-		for i := range dir {
-			if dir[i].IsUnused() {
-				continue
-			}
-			if strings.ToUpper(dir[i].GetFilename()) == destName {
-				return fmt.Errorf("file already exists: %s (use force to overwrite)", destName)
-			}
+		destName := diskimg.HostName(filePath, ext)
+		if opts.Sanitise {
+			destName = diskimg.SanitiseFilename(destName)
+		}
+		if err := replace(tx.DiskImage, destName, opts.Force); err != nil {
+			return err
 		}
 	}
 
@@ -129,48 +189,163 @@ func Add(diskPath string, filePath string, opts *AddOptions) error {
 		// tokenising, which will not run on the +3. Warn but proceed as asked.
 		if !opts.Quiet {
 			if data, rerr := os.ReadFile(filePath); rerr == nil && len(data) > 0 && !diskimg.LooksTokenised(data) && looksLikeText(data) {
-				fmt.Fprintf(os.Stderr,
-					"Warning: %s does not look like tokenised BASIC; -t basic stores it "+
-						"verbatim. If this is plain-text source, use -t basictext.\n", filepath.Base(filePath))
+				slog.Warn(fmt.Sprintf("%s does not look like tokenised BASIC; -t basic stores it "+
+					"verbatim. If this is plain-text source, use -t basictext.", filepath.Base(filePath)))
 			}
 		}
-		importErr = disk.ImportBasicProgram(filePath, opts.Line)
+		importErr = tx.ImportBasicProgram(filePath, opts.Line)
 	case TypeBasicText:
 		// Advisory: if the input already parses as tokenised BASIC, the user
 		// likely meant -t basic (store verbatim) rather than -t basictext
 		// (tokenise). Warn but proceed as asked.
 		if !opts.Quiet {
 			if data, rerr := os.ReadFile(filePath); rerr == nil && diskimg.LooksTokenised(data) {
-				fmt.Fprintf(os.Stderr,
-					"Warning: %s already looks like tokenised BASIC, but -t basictext will "+
-						"tokenise it again. Did you mean -t basic?\n", filepath.Base(filePath))
+				slog.Warn(fmt.Sprintf("%s already looks like tokenised BASIC, but -t basictext will "+
+					"tokenise it again. Did you mean -t basic?", filepath.Base(filePath)))
 			}
 		}
-		importErr = disk.ImportBasicText(filePath, opts.Line)
+		importErr = tx.ImportBasicText(filePath, opts.Line)
 	case TypeCode:
-		importErr = disk.ImportCode(filePath, opts.LoadAddr)
+		importErr = tx.ImportCode(filePath, opts.LoadAddr)
 	case TypeScreen:
-		importErr = disk.ImportScreen(filePath)
+		importErr = tx.ImportScreen(filePath)
+	case TypeImage:
+		importErr = tx.ImportImage(filePath, &diskimg.ScreenEncodeOptions{Dither: opts.Dither, Clash: opts.Clash})
+	case TypeArray:
+		importErr = addArray(tx.DiskImage, filePath, opts.Var)
+	case TypeText:
+		importErr = tx.ImportText(filePath, &diskimg.TextOptions{CR: opts.CR, Charset: opts.Charset})
+	case TypeHobeta:
+		importErr = addHobeta(tx.DiskImage, diskPath, filePath, opts)
+	case TypeAmsdos:
+		importErr = tx.ImportAmsdos(filePath)
+	case TypeTape:
+		importErr = addTape(tx.DiskImage, diskPath, filePath, opts)
 	default:
-		importErr = disk.ImportRaw(filePath)
+		importErr = tx.ImportRaw(filePath)
 	}
 
 	if importErr != nil {
 		return fmt.Errorf("failed to import file: %w", importErr)
 	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
 
 	// Save disk changes
-	if err := disk.SaveToFile(diskPath); err != nil {
+	if err := stdio.SaveDiskWithOptions(disk, diskPath, &diskimg.SaveOptions{Backup: opts.Backup}); err != nil {
 		return fmt.Errorf("failed to save disk: %w", err)
 	}
+	if err := stdio.Journal(disk, diskPath, "add "+filepath.Base(filePath)); err != nil {
+		return err
+	}
 
 	if !opts.Quiet {
-		fmt.Printf("Added %s to disk image\n", filepath.Base(filePath))
+		fmt.Fprintf(stdio.Status(diskPath), "Added %s to disk image\n", filepath.Base(filePath))
 	}
 
 	return nil
 }
 
+// addTape adds every file of a TAP image and reports what became of each
+// block
+func addTape(disk *diskimg.DiskImage, diskPath, filePath string, opts *AddOptions) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	report, err := disk.ImportTAP(f)
+	if opts.Quiet {
+		return err
+	}
+	for _, b := range report {
+		kind := "data"
+		if b.Header {
+			kind = fmt.Sprintf("header %q", b.Name)
+		}
+		switch {
+		case b.Err != nil:
+			slog.Warn(fmt.Sprintf("block %d (%s, %d bytes) skipped: %v", b.Index, kind, b.Bytes, b.Err))
+		case b.File != "":
+			fmt.Fprintf(stdio.Status(diskPath), "block %d (%s, %d bytes) -> %s\n", b.Index, kind, b.Bytes, b.File)
+		}
+	}
+	return err
+}
+
+// addHobeta adds the TR-DOS file in a Hobeta file, under the name its header
+// gives it
+func addHobeta(disk *diskimg.DiskImage, diskPath, filePath string, opts *AddOptions) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	f, err := diskimg.LoadHobeta(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if err := replace(disk, f.Plus3Name(), opts.Force); err != nil {
+		return err
+	}
+	name, err := disk.ImportHobeta(bytes.NewReader(data))
+	if err == nil && !opts.Quiet {
+		fmt.Fprintf(stdio.Status(diskPath), "%s -> %s\n", filepath.Base(filePath), name)
+	}
+	return err
+}
+
+// replace deletes the file name from the disk if force is set, or returns an
+// error if the file exists and force is not set
+func replace(disk *diskimg.DiskImage, name string, force bool) error {
+	if _, err := disk.StatFile(name); err != nil {
+		return nil
+	}
+	if !force {
+		return fmt.Errorf("%w: %s (use force to overwrite)", diskimg.ErrFileExists, name)
+	}
+	if err := disk.DeleteFile(name); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", name, err)
+	}
+	return nil
+}
+
+// addArray stores a CSV or JSON file, by its extension, as the array v
+func addArray(disk *diskimg.DiskImage, filePath, v string) error {
+	if v == "" {
+		return fmt.Errorf("an array needs a variable name (use --var, as a or a$)")
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	var a *diskimg.Array
+	if strings.EqualFold(filepath.Ext(filePath), ".json") {
+		a, err = diskimg.ArrayFromJSON(v, data)
+	} else {
+		a, err = diskimg.ArrayFromCSV(v, bytes.NewReader(data))
+	}
+	if err != nil {
+		return err
+	}
+	return disk.ImportArray(diskimg.HostName(filePath, "DAT"), a)
+}
+
+// hasAmsdosHeader reports whether the host file starts with an AMSDOS header
+func hasAmsdosHeader(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	h := make([]byte, diskimg.HeaderSize)
+	if _, err := io.ReadFull(f, h); err != nil {
+		return false
+	}
+	_, err = diskimg.ParseAmsdosHeader(h)
+	return err == nil
+}
+
 // looksLikeText reports whether data is plausibly plain-text BASIC source: it
 // begins with an ASCII digit (a line number) and is predominantly printable
 // ASCII. Used only to decide whether to show an advisory warning.
@@ -0,0 +1,122 @@
+// file: cmd/add/files.go
+
+package add
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ha1tch/plus3/internal/stdio"
+	"github.com/ha1tch/plus3/pkg/diskimg"
+)
+
+// AddFiles imports several files into the disk image, each as Add would with
+// the same options, and saves the disk once. Files stored as they are, with or
+// without a header, can be added together: BASIC, BASIC text, CODE, SCREEN$
+// and raw files. A file that cannot be added is reported on standard error and
+// skipped, and counted in the error returned
+func AddFiles(diskPath string, filePaths []string, opts *AddOptions) error {
+	if opts == nil {
+		opts = DefaultAddOptions()
+	}
+	if err := stdio.Exists(diskPath); err != nil {
+		return err
+	}
+	disk, err := stdio.LoadDisk(diskPath, &diskimg.LoadOptions{Fidelity: opts.Fidelity})
+	if err != nil {
+		return fmt.Errorf("failed to open disk: %w", err)
+	}
+	if err := stdio.StartJournal(disk, diskPath, opts.Journal); err != nil {
+		return err
+	}
+	disk.SetSanitiseNames(opts.Sanitise)
+
+	failed := 0
+	var specs []diskimg.ImportSpec
+	var hostPaths []string
+	for _, p := range filePaths {
+		spec, err := importSpec(p, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", p, err)
+			failed++
+			continue
+		}
+		specs = append(specs, spec)
+		hostPaths = append(hostPaths, p)
+	}
+	result, _ := disk.ImportFiles(specs)
+	for i, r := range result.Files {
+		switch {
+		case r.Err != nil:
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", hostPaths[i], r.Err)
+			failed++
+		case !opts.Quiet:
+			fmt.Fprintf(stdio.Status(diskPath), "Added %s as %s (%d bytes)\n", filepath.Base(hostPaths[i]), r.Name, r.Size)
+		}
+	}
+
+	if result.Imported > 0 {
+		if err := stdio.SaveDiskWithOptions(disk, diskPath, &diskimg.SaveOptions{Backup: opts.Backup}); err != nil {
+			return fmt.Errorf("failed to save disk: %w", err)
+		}
+		if err := stdio.Journal(disk, diskPath, fmt.Sprintf("add %d files", result.Imported)); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d file(s) could not be added", failed, len(filePaths))
+	}
+	return nil
+}
+
+// importSpec returns how a host file is imported by AddFiles: under the name
+// and with the header Add would give it
+func importSpec(filePath string, opts *AddOptions) (diskimg.ImportSpec, error) {
+	spec := diskimg.ImportSpec{HostPath: filePath, Replace: opts.Force}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return spec, err
+	}
+	if info.Size() > 8*1024*1024 { // +3DOS 8MB limit
+		return spec, fmt.Errorf("%w: %d bytes, +3DOS allows 8MB", diskimg.ErrFileTooLarge, info.Size())
+	}
+
+	fileType := opts.FileType
+	if fileType == TypeAuto {
+		fileType = determineFileType(filePath)
+		if (fileType == TypeCode || fileType == TypeRaw) && hasAmsdosHeader(filePath) {
+			fileType = TypeAmsdos
+		}
+	}
+	switch fileType {
+	case TypeBasic:
+		spec.Name = diskimg.HostName(filePath, "BAS")
+		spec.Options = &diskimg.ImportOptions{AddHeader: true, FileType: diskimg.FileTypeProgram, Line: opts.Line}
+	case TypeBasicText:
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return spec, err
+		}
+		tokenised, err := diskimg.TokeniseBasic(string(data))
+		if err != nil {
+			return spec, fmt.Errorf("tokenise BASIC source: %w", err)
+		}
+		spec.Name, spec.Data = diskimg.HostName(filePath, "BAS"), tokenised
+		spec.Options = &diskimg.ImportOptions{AddHeader: true, FileType: diskimg.FileTypeProgram, Line: opts.Line}
+	case TypeCode:
+		spec.Name = diskimg.HostName(filePath, "BIN")
+		spec.Options = &diskimg.ImportOptions{AddHeader: true, FileType: diskimg.FileTypeCode, LoadAddr: opts.LoadAddr}
+	case TypeScreen:
+		if info.Size() != 6912 {
+			return spec, fmt.Errorf("%w: a SCREEN$ is 6912 bytes", diskimg.ErrWrongFileType)
+		}
+		spec.Name = diskimg.HostName(filePath, "SCR")
+		spec.Options = &diskimg.ImportOptions{AddHeader: true, FileType: diskimg.FileTypeCode, LoadAddr: 16384}
+	case TypeRaw:
+		spec.Name = diskimg.HostName(filePath, "")
+	default:
+		return spec, fmt.Errorf("%w: this type of file is added on its own", diskimg.ErrUnsupported)
+	}
+	return spec, nil
+}
@@ -0,0 +1,197 @@
+// file: cmd/batch/batch.go
+
+package batch
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ha1tch/plus3/internal/stdio"
+)
+
+// Runner runs one command, given as the arguments after "plus3"
+type Runner func(args []string) error
+
+// BatchOptions configures the batch operation
+type BatchOptions struct {
+	Commands []string // Command lines run before the script's
+	Quiet    bool     // Suppress non-error output
+}
+
+// DefaultBatchOptions returns default options for Batch
+func DefaultBatchOptions() *BatchOptions {
+	return &BatchOptions{
+		Commands: nil,
+		Quiet:    false,
+	}
+}
+
+// Batch runs opts.Commands, then the commands of the script at scriptPath,
+// one a line, in a session: each disk image is loaded once, kept in memory
+// between the commands, and saved once when all have run. Blank lines and
+// lines starting with # are skipped. The first command that fails stops the
+// batch, and nothing is saved. scriptPath may be "" for no script, or "-" for
+// standard input
+func Batch(scriptPath string, run Runner, opts *BatchOptions) error {
+	if opts == nil {
+		opts = DefaultBatchOptions()
+	}
+	lines := opts.Commands
+	if scriptPath != "" {
+		var data []byte
+		var err error
+		if stdio.IsStd(scriptPath) {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(scriptPath)
+		}
+		if err != nil {
+			return err
+		}
+		lines = append(lines, strings.Split(string(data), "\n")...)
+	}
+
+	stdio.BeginSession()
+	for n, line := range lines {
+		args, err := Split(line)
+		if err == nil && len(args) > 0 {
+			err = run(args)
+		}
+		if err != nil {
+			stdio.EndSession(false)
+			return fmt.Errorf("command %d (%s): %w", n+1, strings.TrimSpace(line), err)
+		}
+	}
+	return endSession(opts.Quiet)
+}
+
+// ShellOptions configures the shell operation
+type ShellOptions struct {
+	Prompt string // Printed before each command
+	Quiet  bool   // Suppress non-error output
+}
+
+// DefaultShellOptions returns default options for Shell
+func DefaultShellOptions() *ShellOptions {
+	return &ShellOptions{
+		Prompt: "plus3> ",
+		Quiet:  false,
+	}
+}
+
+// Shell reads commands from r, one a line, and runs them in a session as
+// Batch does, going on after a command that fails. "save" saves the images
+// changed so far, "exit" (or the end of the input) saves them and leaves,
+// and "abort" leaves without saving
+func Shell(r io.Reader, w io.Writer, run Runner, opts *ShellOptions) error {
+	if opts == nil {
+		opts = DefaultShellOptions()
+	}
+	stdio.BeginSession()
+	scanner := bufio.NewScanner(r)
+	for {
+		fmt.Fprint(w, opts.Prompt)
+		if !scanner.Scan() {
+			break
+		}
+		args, err := Split(scanner.Text())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		switch args[0] {
+		case "exit", "quit":
+			return endSession(opts.Quiet)
+		case "abort":
+			return stdio.EndSession(false)
+		case "save":
+			if err := endSession(opts.Quiet); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+			stdio.BeginSession()
+		default:
+			if err := run(args); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+		}
+	}
+	fmt.Fprintln(w)
+	if err := scanner.Err(); err != nil {
+		stdio.EndSession(false)
+		return err
+	}
+	return endSession(opts.Quiet)
+}
+
+// endSession saves the images the session changed and reports them
+func endSession(quiet bool) error {
+	saves := stdio.Saves()
+	if err := stdio.EndSession(true); err != nil {
+		return err
+	}
+	if !quiet {
+		for _, path := range saves {
+			fmt.Fprintf(stdio.Status(path), "Saved %s\n", path)
+		}
+	}
+	return nil
+}
+
+// Split splits a command line into words at spaces and tabs, as a shell
+// does: single quotes keep everything up to the next one, double quotes
+// everything but a backslash-escaped quote or backslash, and a backslash
+// outside quotes the next character
+func Split(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		case c == '#' && !inWord:
+			return words, nil
+		case c == '\\' && i+1 < len(line):
+			i++
+			word.WriteByte(line[i])
+		case c == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated ' quote")
+			}
+			word.WriteString(line[i+1 : i+1+end])
+			i += end + 1
+		case c == '"':
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) && (line[i+1] == '"' || line[i+1] == '\\') {
+					i++
+				}
+				word.WriteByte(line[i])
+			}
+			if i == len(line) {
+				return nil, errors.New(`unterminated " quote`)
+			}
+		default:
+			word.WriteByte(c)
+		}
+		inWord = true
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
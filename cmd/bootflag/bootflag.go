@@ -0,0 +1,84 @@
+// file: cmd/bootflag/bootflag.go
+
+package bootflag
+
+import (
+	"fmt"
+
+	"github.com/ha1tch/plus3/internal/stdio"
+	"github.com/ha1tch/plus3/pkg/diskimg"
+)
+
+// BootflagOptions configures Bootflag
+type BootflagOptions struct {
+	On      bool // Make the disk bootable
+	Off     bool // Make the disk not bootable
+	Quiet   bool // Suppress non-error output
+	Backup  bool // Keep the previous image as <disk>.bak
+	Journal bool // Record the change in <disk>.journal for undo
+}
+
+// DefaultBootflagOptions returns default options for Bootflag
+func DefaultBootflagOptions() *BootflagOptions {
+	return &BootflagOptions{
+		On:      false,
+		Off:     false,
+		Quiet:   false,
+		Backup:  false,
+		Journal: false,
+	}
+}
+
+// Bootflag shows whether a disk is bootable, or with On or Off sets its boot
+// sector checksum to make it so, keeping the rest of the boot sector
+func Bootflag(diskPath string, opts *BootflagOptions) error {
+	if opts == nil {
+		opts = DefaultBootflagOptions()
+	}
+	if opts.On && opts.Off {
+		return fmt.Errorf("on and off cannot be used together")
+	}
+	if err := stdio.Exists(diskPath); err != nil {
+		return err
+	}
+
+	disk, err := stdio.LoadDisk(diskPath, nil)
+	if err != nil {
+		return fmt.Errorf("failed to open disk: %w", err)
+	}
+	if !opts.On && !opts.Off {
+		fmt.Printf("%s: %s\n", diskPath, state(disk.Bootable()))
+		return nil
+	}
+
+	if err := stdio.StartJournal(disk, diskPath, opts.Journal); err != nil {
+		return err
+	}
+	was := disk.Bootable()
+	if err := disk.SetBootable(opts.On); err != nil {
+		return fmt.Errorf("failed to set boot checksum: %w", err)
+	}
+
+	if err := stdio.SaveDiskWithOptions(disk, diskPath, &diskimg.SaveOptions{Backup: opts.Backup}); err != nil {
+		return fmt.Errorf("failed to save disk: %w", err)
+	}
+	if err := stdio.Journal(disk, diskPath, "bootflag "+state(opts.On)); err != nil {
+		return err
+	}
+	if !opts.Quiet {
+		change := "now"
+		if was == opts.On {
+			change = "already"
+		}
+		fmt.Fprintf(stdio.Status(diskPath), "%s is %s %s\n", diskPath, change, state(opts.On))
+	}
+	return nil
+}
+
+// state describes a disk as bootable or not
+func state(bootable bool) string {
+	if bootable {
+		return "bootable"
+	}
+	return "not bootable"
+}
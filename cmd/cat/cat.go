@@ -0,0 +1,65 @@
+// file: cmd/cat/cat.go
+
+package cat
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/ha1tch/plus3/internal/stdio"
+	"github.com/ha1tch/plus3/pkg/diskimg"
+)
+
+// CatOptions configures the cat operation
+type CatOptions struct {
+	List        bool // List a BASIC program as text
+	StripHeader bool // Leave out the PLUS3DOS header
+}
+
+// DefaultCatOptions returns default options for Cat
+func DefaultCatOptions() *CatOptions {
+	return &CatOptions{
+		List:        false,
+		StripHeader: false,
+	}
+}
+
+// Cat writes a file on the disk image to w: its bytes as stored, or with
+// List, the listing of a BASIC program
+func Cat(diskPath, filename string, w io.Writer, opts *CatOptions) error {
+	if opts == nil {
+		opts = DefaultCatOptions()
+	}
+	filename = diskimg.NormaliseFilename(filename)
+
+	if err := stdio.Exists(diskPath); err != nil {
+		return err
+	}
+	disk, err := stdio.OpenDisk(diskPath, nil)
+	if err != nil {
+		return fmt.Errorf("failed to open disk: %w", err)
+	}
+	defer disk.Close()
+
+	if opts.List {
+		text, err := disk.ReadBasicText(filename)
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %w", filename, err)
+		}
+		_, err = io.WriteString(w, text)
+		return err
+	}
+
+	data, err := fs.ReadFile(disk, filename)
+	if err != nil {
+		return err
+	}
+	if opts.StripHeader {
+		if fi, err := disk.StatFile(filename); err == nil && fi.Headered() {
+			data = data[diskimg.HeaderSize:]
+		}
+	}
+	_, err = w.Write(data)
+	return err
+}
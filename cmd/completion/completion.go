@@ -0,0 +1,383 @@
+// file: cmd/completion/completion.go
+
+package completion
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/ha1tch/plus3/internal/stdio"
+	"github.com/ha1tch/plus3/pkg/diskimg"
+)
+
+// Directives written instead of candidates when the shell should complete
+// from the host filesystem itself.
+const (
+	directiveFiles = ":files"
+	directiveDirs  = ":dirs"
+)
+
+// argKind is what a positional argument names.
+type argKind int
+
+const (
+	argHostFile argKind = iota // a file on the host (disk image, input file)
+	argDiskFile                // a file inside the disk image given as the first argument
+	argShell                   // a shell name
+	argName                    // a name that is not completed
+	argHostDir                 // a directory on the host
+)
+
+// flagSpec describes one flag of a command.
+type flagSpec struct {
+	name   string   // without dashes
+	value  bool     // takes a value
+	values []string // fixed set of values, if any
+	dir    bool     // value is a host directory
+}
+
+// commandSpec describes the flags and positional arguments of a command.
+type commandSpec struct {
+	flags    []flagSpec
+	args     []argKind
+	variadic bool // the last argument repeats
+}
+
+// globalFlags are accepted by every command (see logFlags in cmd/log.go).
+var globalFlags = []flagSpec{{name: "verbose"}, {name: "debug"}}
+
+// commands mirrors the flag sets built in cmd/main.go; keep the two in step.
+var commands = map[string]commandSpec{
+	"create": {
+		flags: []flagSpec{
+			{name: "format", value: true, values: []string{"3dos", "720k", "cpc-data", "cpc-system", "pcw180", "pcw720"}},
+			{name: "container", value: true, values: []string{"standard", "extended"}},
+			{name: "spec", value: true},
+			{name: "interleave", value: true}, {name: "skew", value: true},
+			{name: "label", value: true}, {name: "boot"}, {name: "datestamps"}, {name: "force"}, {name: "quiet"},
+		},
+		args: []argKind{argHostFile},
+	},
+	"add": {
+		flags: []flagSpec{
+			{name: "type", value: true, values: []string{"auto", "basic", "basictext", "code", "screen", "image", "array", "text", "hobeta", "amsdos", "raw", "tap"}},
+			{name: "t", value: true, values: []string{"auto", "basic", "basictext", "code", "screen", "image", "array", "text", "hobeta", "amsdos", "raw", "tap"}},
+			{name: "tokenize"},
+			{name: "line", value: true},
+			{name: "load-addr", value: true},
+			{name: "dither", value: true, values: []string{"none", "ordered", "diffusion"}},
+			{name: "clash", value: true, values: []string{"best", "popular"}},
+			{name: "var", value: true}, {name: "cr"}, {name: "charset"}, {name: "sanitise"},
+			{name: "force"}, {name: "quiet"}, {name: "fidelity"}, {name: "backup"}, {name: "journal"},
+		},
+		args:     []argKind{argHostFile, argHostFile},
+		variadic: true,
+	},
+	"list": {
+		flags: []flagSpec{
+			{name: "sort", value: true, values: []string{"name", "size", "type"}},
+			{name: "reverse"}, {name: "show-deleted"}, {name: "show-system"},
+			{name: "json"}, {name: "long"},
+			{name: "pattern", value: true},
+			{name: "format", value: true, values: []string{"dos", "ls", "cpm", "csv", "basic"}},
+		},
+		args: []argKind{argHostFile, argName},
+	},
+	"info": {
+		flags: []flagSpec{{name: "json"}, {name: "validate"}, {name: "level", value: true, values: []string{"basic", "strict", "paranoid"}}, {name: "verbose"}, {name: "show-deleted"}, {name: "salvage"}, {name: "file", value: true}},
+		args:  []argKind{argHostFile},
+	},
+	"export-json": {
+		flags: []flagSpec{{name: "compact"}, {name: "salvage"}},
+		args:  []argKind{argHostFile},
+	},
+	"import-json": {
+		flags: []flagSpec{{name: "force"}, {name: "quiet"}},
+		args:  []argKind{argHostFile, argHostFile},
+	},
+	"extract": {
+		flags: []flagSpec{
+			{name: "strip-header"},
+			{name: "output-dir", value: true, dir: true},
+			{name: "o", value: true, dir: true},
+			{name: "overwrite"}, {name: "quiet"}, {name: "basic"}, {name: "as-text"},
+			{name: "png", value: true}, {name: "gif", value: true}, {name: "scale", value: true},
+			{name: "array", value: true, values: []string{"csv", "json"}},
+			{name: "text"}, {name: "charset"}, {name: "hobeta"}, {name: "amsdos"}, {name: "locoscript"},
+			{name: "all"}, {name: "jobs", value: true},
+		},
+		args: []argKind{argHostFile, argDiskFile},
+	},
+	"cat": {
+		flags: []flagSpec{{name: "list"}, {name: "strip-header"}},
+		args:  []argKind{argHostFile, argDiskFile},
+	},
+	"header": {
+		flags: []flagSpec{
+			{name: "set-line", value: true}, {name: "set-load-addr", value: true},
+			{name: "set-type", value: true, values: []string{"basic", "numeric", "char", "code"}},
+			{name: "fix-checksum"}, {name: "strip"}, {name: "add"}, {name: "quiet"}, {name: "fidelity"},
+			{name: "backup"}, {name: "journal"},
+		},
+		args: []argKind{argHostFile, argDiskFile},
+	},
+	"delete": {
+		flags: []flagSpec{
+			{name: "force"}, {name: "quiet"}, {name: "no-recycle"}, {name: "scrub"}, {name: "fidelity"},
+			{name: "backup"}, {name: "journal"},
+		},
+		args: []argKind{argHostFile, argDiskFile},
+	},
+	"copy": {
+		flags: []flagSpec{{name: "as", value: true}, {name: "force"}, {name: "quiet"}, {name: "backup"}, {name: "journal"}},
+		args:  []argKind{argHostFile, argHostFile, argDiskFile},
+	},
+	"merge": {
+		flags: []flagSpec{{name: "force"}, {name: "quiet"}, {name: "backup"}, {name: "journal"}},
+		args:  []argKind{argHostFile, argHostFile},
+	},
+	"undo": {
+		flags: []flagSpec{{name: "quiet"}, {name: "backup"}},
+		args:  []argKind{argHostFile},
+	},
+	"convert": {
+		flags: []flagSpec{
+			{name: "container", value: true, values: []string{"standard", "extended"}},
+			{name: "force"}, {name: "quiet"},
+		},
+		args: []argKind{argHostFile, argHostFile},
+	},
+	"makeboot": {
+		flags: []flagSpec{
+			{name: "screen", value: true}, {name: "code", value: true}, {name: "exec", value: true},
+			{name: "force"}, {name: "quiet"},
+		},
+		args: []argKind{argHostFile},
+	},
+	"bootflag": {
+		flags: []flagSpec{{name: "on"}, {name: "off"}, {name: "quiet"}, {name: "backup"}, {name: "journal"}},
+		args:  []argKind{argHostFile},
+	},
+	"repair": {
+		flags: []flagSpec{
+			{name: "code", value: true, values: []string{"boot-checksum", "dir-duplicate", "alloc-block-range", "track-short", "track-concealed"}},
+			{name: "quiet"}, {name: "backup"}, {name: "journal"},
+		},
+		args: []argKind{argHostFile},
+	},
+	"partitions": {
+		flags: []flagSpec{{name: "json"}},
+		args:  []argKind{argHostFile},
+	},
+	"serve-dav": {
+		flags: []flagSpec{{name: "listen", value: true}, {name: "read-only"}, {name: "quiet"}},
+		args:  []argKind{argHostFile},
+	},
+	"web": {
+		flags: []flagSpec{{name: "listen", value: true}, {name: "quiet"}},
+		args:  []argKind{argHostDir},
+	},
+	"batch": {
+		flags: []flagSpec{{name: "e", value: true}, {name: "quiet"}},
+		args:  []argKind{argHostFile},
+	},
+	"shell": {
+		flags: []flagSpec{{name: "prompt", value: true}, {name: "quiet"}},
+	},
+	"daemon": {
+		flags: []flagSpec{{name: "listen", value: true}, {name: "root", value: true, dir: true}, {name: "quiet"}},
+	},
+	"pipeline run": {
+		flags: []flagSpec{
+			{name: "output-dir", value: true, dir: true},
+			{name: "o", value: true, dir: true},
+			{name: "quiet"},
+		},
+		args:     []argKind{argHostFile, argHostFile},
+		variadic: true,
+	},
+	"set list": {
+		flags:    []flagSpec{{name: "json"}},
+		args:     []argKind{argHostFile},
+		variadic: true,
+	},
+	"set add": {
+		flags: []flagSpec{
+			{name: "type", value: true, values: []string{"auto", "basic", "code", "raw"}},
+			{name: "t", value: true, values: []string{"auto", "basic", "code", "raw"}},
+			{name: "line", value: true},
+			{name: "load-addr", value: true},
+			{name: "quiet"},
+		},
+		args:     []argKind{argHostFile, argHostFile},
+		variadic: true,
+	},
+	"set extract": {
+		flags: []flagSpec{
+			{name: "strip-header"},
+			{name: "output-dir", value: true, dir: true},
+			{name: "o", value: true, dir: true},
+			{name: "overwrite"}, {name: "quiet"},
+		},
+		args:     []argKind{argName, argHostFile},
+		variadic: true,
+	},
+	"completion": {
+		args: []argKind{argShell},
+	},
+}
+
+// groups lists the commands that take a subcommand, with their subcommands.
+var groups = map[string][]string{
+	"pipeline": {"run"},
+	"set":      {"add", "extract", "list"},
+}
+
+// Complete writes the completion candidates for a partial command line, one
+// per line. words are the arguments after "plus3"; the last is the word being
+// completed (possibly empty). When the shell should complete host paths
+// instead, a single ":files" or ":dirs" directive is written.
+func Complete(words []string, w io.Writer) error {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	cur := words[len(words)-1]
+	prev := words[:len(words)-1]
+
+	if len(prev) == 0 {
+		names := make([]string, 0, len(commands)+len(groups))
+		for name := range commands {
+			if !strings.Contains(name, " ") {
+				names = append(names, name)
+			}
+		}
+		for name := range groups {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return emit(w, names, cur)
+	}
+
+	name, rest := prev[0], prev[1:]
+	if subs, ok := groups[name]; ok {
+		if len(rest) == 0 {
+			return emit(w, subs, cur)
+		}
+		name, rest = name+" "+rest[0], rest[1:]
+	}
+	spec, ok := commands[name]
+	if !ok {
+		return nil
+	}
+
+	// Walk the words already typed, separating flags (and their values) from
+	// positional arguments.
+	var positionals []string
+	var pending *flagSpec
+	for _, word := range rest {
+		if pending != nil {
+			pending = nil
+			continue
+		}
+		if strings.HasPrefix(word, "-") && word != stdio.Path {
+			if f := spec.flag(word); f != nil && f.value && !strings.Contains(word, "=") {
+				pending = f
+			}
+			continue
+		}
+		positionals = append(positionals, word)
+	}
+
+	switch {
+	case pending != nil:
+		if pending.dir {
+			return emit(w, []string{directiveDirs}, "")
+		}
+		if pending.values != nil {
+			return emit(w, pending.values, cur)
+		}
+		return nil
+	case strings.HasPrefix(cur, "-") && cur != stdio.Path:
+		var flags []string
+		for _, f := range append(spec.flags, globalFlags...) {
+			if len(f.name) == 1 {
+				flags = append(flags, "-"+f.name)
+			} else {
+				flags = append(flags, "--"+f.name)
+			}
+		}
+		return emit(w, flags, cur)
+	}
+
+	n := len(positionals)
+	if n >= len(spec.args) {
+		if !spec.variadic {
+			return nil
+		}
+		n = len(spec.args) - 1
+	}
+	switch spec.args[n] {
+	case argDiskFile:
+		return emit(w, diskFiles(positionals[0]), strings.ToUpper(cur))
+	case argShell:
+		return emit(w, []string{"bash", "fish", "zsh"}, cur)
+	case argName:
+		return nil
+	case argHostDir:
+		return emit(w, []string{directiveDirs}, "")
+	default:
+		return emit(w, []string{directiveFiles}, "")
+	}
+}
+
+// flag returns the spec for a typed flag ("-o", "--sort", "--sort=size").
+func (c commandSpec) flag(word string) *flagSpec {
+	name := strings.TrimLeft(word, "-")
+	if i := strings.Index(name, "="); i >= 0 {
+		name = name[:i]
+	}
+	for i := range c.flags {
+		if c.flags[i].name == name {
+			return &c.flags[i]
+		}
+	}
+	return nil
+}
+
+// diskFiles returns the names of the files in a disk image, or nil if it
+// cannot be read. Completion must never fail noisily, so errors are dropped.
+func diskFiles(diskPath string) []string {
+	if stdio.IsStd(diskPath) {
+		return nil
+	}
+	disk, err := diskimg.LoadFromFile(diskPath)
+	if err != nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for entry := range disk.Files(&diskimg.FilesOptions{System: true}) {
+		name := entry.GetFilename()
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// emit writes the candidates that start with prefix, one per line.
+func emit(w io.Writer, candidates []string, prefix string) error {
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) {
+			if _, err := fmt.Fprintln(w, c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+// file: cmd/completion/scripts.go
+
+package completion
+
+import "fmt"
+
+// The scripts below hand the command line to "plus3 __complete", which knows
+// the commands, flags and disk image contents, and only fall back to the
+// shell's own path completion when told to with a directive.
+
+const bashScript = `# bash completion for plus3
+# Load with: source <(plus3 completion bash)
+_plus3() {
+    local cur="${COMP_WORDS[COMP_CWORD]}"
+    local IFS=$'\n'
+    local out
+    out=($(plus3 __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+    case "${out[0]}" in
+    :files) COMPREPLY=($(compgen -f -- "$cur")) ;;
+    :dirs) COMPREPLY=($(compgen -d -- "$cur")) ;;
+    *) COMPREPLY=("${out[@]}") ;;
+    esac
+}
+complete -o filenames -F _plus3 plus3
+`
+
+const zshScript = `#compdef plus3
+# zsh completion for plus3
+# Load with: source <(plus3 completion zsh)
+_plus3() {
+    local -a out
+    out=("${(@f)$(plus3 __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+    case "${out[1]}" in
+    :files) _files ;;
+    :dirs) _files -/ ;;
+    *) compadd -U -- "${out[@]}" ;;
+    esac
+}
+compdef _plus3 plus3
+`
+
+const fishScript = `# fish completion for plus3
+# Load with: plus3 completion fish | source
+function __plus3_complete
+    set -l words (commandline -opc) (commandline -ct)
+    set -l out (plus3 __complete $words[2..-1] 2>/dev/null)
+    switch "$out[1]"
+        case :files
+            __fish_complete_path (commandline -ct)
+        case :dirs
+            __fish_complete_directories (commandline -ct)
+        case '*'
+            printf '%s\n' $out
+    end
+end
+complete -c plus3 -f -a '(__plus3_complete)'
+`
+
+// Script returns the completion script for shell ("bash", "zsh" or "fish").
+func Script(shell string) (string, error) {
+	switch shell {
+	case "bash":
+		return bashScript, nil
+	case "zsh":
+		return zshScript, nil
+	case "fish":
+		return fishScript, nil
+	}
+	return "", fmt.Errorf("unsupported shell %q (want bash, zsh or fish)", shell)
+}
@@ -0,0 +1,87 @@
+// file: cmd/convert/convert.go
+
+package convert
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ha1tch/plus3/internal/stdio"
+	"github.com/ha1tch/plus3/pkg/diskimg"
+)
+
+// ConvertOptions configures the image conversion
+type ConvertOptions struct {
+	Container *diskimg.Container // .dsk container to write; nil keeps the input's
+	Force     bool               // Overwrite existing output file
+	Quiet     bool               // Suppress non-error output
+}
+
+// DefaultConvertOptions returns default options for Convert
+func DefaultConvertOptions() *ConvertOptions {
+	return &ConvertOptions{
+		Container: nil,
+		Force:     false,
+		Quiet:     false,
+	}
+}
+
+// Convert rewrites a disk image in another encoding: a raw sector image for an
+// output path ending in ".img", an HFE image for ".hfe", a TR-DOS disk or
+// archive of its files for ".trd" or ".scl", a TZX or TAP tape of its headered
+// files for ".tzx" or ".tap", otherwise a .dsk container. A TZX tape is read
+// as a disk holding its files, and a 48K .z80 or .sna snapshot as a disk that
+// loads it. Either path may be "-" for standard input or output; standard
+// output gets a .dsk.
+func Convert(inPath, outPath string, opts *ConvertOptions) error {
+	// Validate options
+	if opts == nil {
+		opts = DefaultConvertOptions()
+	}
+
+	// Validate input exists and output does not
+	if err := stdio.Exists(inPath); err != nil {
+		return err
+	}
+	if !opts.Force && !stdio.IsStd(outPath) {
+		if _, err := os.Stat(outPath); err == nil {
+			return fmt.Errorf("%w: %s (use force to overwrite)", diskimg.ErrFileExists, outPath)
+		}
+	}
+
+	// Open disk image
+	disk, err := stdio.LoadDisk(inPath, nil)
+	if err != nil {
+		return fmt.Errorf("failed to open disk: %w", err)
+	}
+	if opts.Container != nil {
+		disk.SetContainer(*opts.Container)
+	}
+
+	// Save in the new encoding
+	if err := stdio.SaveDisk(disk, outPath); err != nil {
+		return fmt.Errorf("failed to save disk image: %w", err)
+	}
+
+	if !opts.Quiet {
+		kind := disk.Container().String() + " .dsk"
+		switch {
+		case stdio.IsRaw(outPath):
+			kind = "raw"
+		case stdio.IsHFE(outPath):
+			kind = "HFE"
+		case stdio.IsTRDOS(outPath):
+			kind = "TR-DOS"
+		case stdio.IsTZX(outPath):
+			kind = "TZX"
+		case stdio.IsTAP(outPath):
+			kind = "TAP"
+		case stdio.IsWAV(outPath):
+			kind = "WAV"
+		}
+		fmt.Fprintf(stdio.Status(outPath), "Converted %s to %s %s image (%s format)\n",
+			inPath, outPath, kind, disk.Spec().Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,172 @@
+// file: cmd/copy/copy.go
+
+package copy
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ha1tch/plus3/internal/stdio"
+	"github.com/ha1tch/plus3/pkg/diskimg"
+)
+
+// CopyOptions configures copying a file between disk images
+type CopyOptions struct {
+	NewName string // Name on the destination disk (default: the same name)
+	Force   bool   // Replace a file of that name on the destination
+	Quiet   bool   // Suppress non-error output
+	Backup  bool   // Keep the previous destination image as <disk>.bak
+	Journal bool   // Record the change in the destination's journal for undo
+}
+
+// DefaultCopyOptions returns default options for Copy
+func DefaultCopyOptions() *CopyOptions {
+	return &CopyOptions{
+		NewName: "",
+		Force:   false,
+		Quiet:   false,
+		Backup:  false,
+		Journal: false,
+	}
+}
+
+// MergeOptions configures merging the files of one disk image into another
+type MergeOptions struct {
+	Force   bool // Replace files of the same name on the destination
+	Quiet   bool // Suppress non-error output
+	Backup  bool // Keep the previous destination image as <disk>.bak
+	Journal bool // Record the change in the destination's journal for undo
+}
+
+// DefaultMergeOptions returns default options for Merge
+func DefaultMergeOptions() *MergeOptions {
+	return &MergeOptions{
+		Force:   false,
+		Quiet:   false,
+		Backup:  false,
+		Journal: false,
+	}
+}
+
+// loadPair loads the source and destination disks. The same path gives the
+// same disk, so a file can be copied within one image.
+func loadPair(srcPath, dstPath string) (*diskimg.DiskImage, *diskimg.DiskImage, error) {
+	if stdio.IsStd(srcPath) && stdio.IsStd(dstPath) {
+		return nil, nil, fmt.Errorf("only one disk image can be streamed through standard input")
+	}
+	for _, path := range []string{srcPath, dstPath} {
+		if err := stdio.Exists(path); err != nil {
+			return nil, nil, err
+		}
+	}
+	src, err := stdio.LoadDisk(srcPath, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open disk %s: %w", srcPath, err)
+	}
+	if dstPath == srcPath {
+		return src, src, nil
+	}
+	dst, err := stdio.LoadDisk(dstPath, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open disk %s: %w", dstPath, err)
+	}
+	return src, dst, nil
+}
+
+// Copy copies a file from one disk image to another, or within one image
+// under a new name, keeping its header, size and attributes.
+func Copy(srcPath, dstPath, filename string, opts *CopyOptions) error {
+	if opts == nil {
+		opts = DefaultCopyOptions()
+	}
+	filename = diskimg.NormaliseFilename(filename)
+	if filename == "" {
+		return fmt.Errorf("filename cannot be empty")
+	}
+	src, dst, err := loadPair(srcPath, dstPath)
+	if err != nil {
+		return err
+	}
+	if err := stdio.StartJournal(dst, dstPath, opts.Journal); err != nil {
+		return err
+	}
+
+	err = diskimg.CopyFile(src, dst, filename, &diskimg.CopyOptions{NewName: opts.NewName, Overwrite: opts.Force})
+	if errors.Is(err, diskimg.ErrFileExists) {
+		return fmt.Errorf("%w (use force to replace)", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy %s: %w", filename, err)
+	}
+	if err := stdio.SaveDiskWithOptions(dst, dstPath, &diskimg.SaveOptions{Backup: opts.Backup}); err != nil {
+		return fmt.Errorf("failed to save disk: %w", err)
+	}
+	if err := stdio.Journal(dst, dstPath, "copy "+filename); err != nil {
+		return err
+	}
+
+	if !opts.Quiet {
+		if newName := diskimg.NormaliseFilename(opts.NewName); newName != "" && newName != filename {
+			fmt.Fprintf(stdio.Status(dstPath), "Copied %s as %s\n", filename, newName)
+		} else {
+			fmt.Fprintf(stdio.Status(dstPath), "Copied %s\n", filename)
+		}
+	}
+	return nil
+}
+
+// Merge copies every file of one disk image into another. Files whose names
+// are already on the destination are skipped unless forced.
+func Merge(srcPath, dstPath string, opts *MergeOptions) error {
+	if opts == nil {
+		opts = DefaultMergeOptions()
+	}
+	if srcPath == dstPath {
+		return fmt.Errorf("cannot merge a disk image into itself")
+	}
+	src, dst, err := loadPair(srcPath, dstPath)
+	if err != nil {
+		return err
+	}
+	if err := stdio.StartJournal(dst, dstPath, opts.Journal); err != nil {
+		return err
+	}
+
+	// Copy into a transaction, so a failure part way leaves dst untouched.
+	tx := dst.Begin()
+	copied, skipped := 0, 0
+	for e := range src.Files(&diskimg.FilesOptions{System: true}) {
+		name := e.GetFilename()
+		err := diskimg.CopyFile(src, tx.DiskImage, name, &diskimg.CopyOptions{Overwrite: opts.Force})
+		if errors.Is(err, diskimg.ErrFileExists) {
+			skipped++
+			if !opts.Quiet {
+				fmt.Fprintf(stdio.Status(dstPath), "Skipped %s (already on the destination)\n", name)
+			}
+			continue
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to copy %s: %w", name, err)
+		}
+		copied++
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if err := stdio.SaveDiskWithOptions(dst, dstPath, &diskimg.SaveOptions{Backup: opts.Backup}); err != nil {
+		return fmt.Errorf("failed to save disk: %w", err)
+	}
+	if err := stdio.Journal(dst, dstPath, "merge "+srcPath); err != nil {
+		return err
+	}
+
+	if !opts.Quiet {
+		msg := fmt.Sprintf("Merged %d file(s)", copied)
+		if skipped > 0 {
+			msg += fmt.Sprintf(", %d skipped", skipped)
+		}
+		fmt.Fprintln(stdio.Status(dstPath), msg)
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/ha1tch/plus3/internal/stdio"
 	"github.com/ha1tch/plus3/pkg/diskimg"
 )
 
@@ -20,78 +21,142 @@ const (
 	FormatCPCData
 	// FormatCPCSystem CPC system format
 	FormatCPCSystem
+	// FormatPlus3DS double-sided 80-track (720K) +3DOS format
+	FormatPlus3DS
+	// FormatPCW180 Amstrad PCW 8256/8512 single-sided format
+	FormatPCW180
+	// FormatPCW720 Amstrad PCW 9512 double-sided 80-track format
+	FormatPCW720
 )
 
+// ParseFormat returns the format named on the command line: 3dos (or +3),
+// 720k, cpc-data, cpc-system, pcw180 or pcw720.
+func ParseFormat(name string) (FormatType, error) {
+	switch name {
+	case "3dos", "+3":
+		return Format3DOS, nil
+	case "720k":
+		return FormatPlus3DS, nil
+	case "cpc-data":
+		return FormatCPCData, nil
+	case "cpc-system":
+		return FormatCPCSystem, nil
+	case "pcw180":
+		return FormatPCW180, nil
+	case "pcw720":
+		return FormatPCW720, nil
+	}
+	return Format3DOS, fmt.Errorf("unknown disk format %q", name)
+}
+
 // CreateOptions configures the disk creation
 type CreateOptions struct {
-	Format FormatType // Disk format to use
-	Label  string     // Optional disk label
-	Boot   bool       // Create bootable disk
-	Force  bool       // Overwrite existing file
-	Quiet  bool       // Suppress non-error output
+	Format     FormatType        // Disk format to use
+	Spec       *diskimg.DiskSpec // Custom geometry; overrides Format when set
+	Interleave int               // Physical positions between consecutive sector IDs (0 = sequential)
+	Skew       int               // Positions the first sector moves on per track
+	Container  diskimg.Container // DSK container variant to write
+	Label      string            // Optional disk label
+	Boot       bool              // Create bootable disk
+	Datestamps bool              // Stamp files with CP/M Plus datestamps
+	Force      bool              // Overwrite existing file
+	Quiet      bool              // Suppress non-error output
 }
 
 // DefaultCreateOptions returns default options for Create
 func DefaultCreateOptions() *CreateOptions {
 	return &CreateOptions{
-		Format: Format3DOS,
-		Label:  "",
-		Boot:   false,
-		Force:  false,
-		Quiet:  false,
+		Format:    Format3DOS,
+		Container: diskimg.ContainerStandard,
+		Label:     "",
+		Boot:      false,
+		Force:     false,
+		Quiet:     false,
+	}
+}
+
+// diskSpec returns the geometry of the disk to create: Spec if set, otherwise
+// the preset for Format.
+func (opts *CreateOptions) diskSpec() diskimg.DiskSpec {
+	if opts.Spec != nil {
+		return *opts.Spec
+	}
+	switch opts.Format {
+	case FormatCPCData:
+		return diskimg.SpecCPCData
+	case FormatCPCSystem:
+		return diskimg.SpecCPCSystem
+	case FormatPlus3DS:
+		return diskimg.SpecPlus3DS
+	case FormatPCW180:
+		return diskimg.SpecPCW180
+	case FormatPCW720:
+		return diskimg.SpecPCW720
 	}
+	return diskimg.SpecPlus3
 }
 
-// Create creates a new disk image
+// Create creates a new disk image. An outPath of "-" writes the image to
+// standard output.
 func Create(outPath string, opts *CreateOptions) error {
 	// Validate options
 	if opts == nil {
 		opts = DefaultCreateOptions()
 	}
 
+	spec := opts.diskSpec()
+	cpc := spec == diskimg.SpecCPCData || spec == diskimg.SpecCPCSystem
+
+	// The +3 boot sector would overwrite CPC boot code or the CPC directory
+	if opts.Boot && cpc {
+		return fmt.Errorf("bootable disks are only supported in the 3DOS formats")
+	}
+
+	// A raw image has no sector IDs, so a 180K one always reads back as +3
+	if cpc && stdio.IsRaw(outPath) {
+		return fmt.Errorf("CPC formats cannot be stored as raw images")
+	}
+
 	// Clean and validate path
 	outPath = filepath.Clean(outPath)
 
 	// Check if file exists
-	if !opts.Force {
+	if !opts.Force && !stdio.IsStd(outPath) {
 		if _, err := os.Stat(outPath); err == nil {
-			return fmt.Errorf("file already exists: %s (use force to overwrite)", outPath)
+			return fmt.Errorf("%w: %s (use force to overwrite)", diskimg.ErrFileExists, outPath)
 		}
 	}
 
 	// Ensure directory exists
-	if dir := filepath.Dir(outPath); dir != "." {
+	if dir := filepath.Dir(outPath); dir != "." && !stdio.IsStd(outPath) {
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			return fmt.Errorf("failed to create directory: %w", err)
 		}
 	}
 
 	// Create new disk image
-	disk := diskimg.NewDiskImage()
-	if disk == nil {
-		return fmt.Errorf("failed to create disk image")
+	spec.Interleave, spec.Skew = opts.Interleave, opts.Skew
+	disk, err := diskimg.NewDiskImageWithSpec(spec)
+	if err != nil {
+		return fmt.Errorf("failed to create disk image: %w", err)
 	}
+	disk.SetContainer(opts.Container)
 
-	// Apply format-specific settings
-	switch opts.Format {
-	case FormatCPCData:
-		disk.DiskType = 2 // CPC data-only format
-	case FormatCPCSystem:
-		disk.DiskType = 1 // CPC system format
-	default:
-		disk.DiskType = 0 // Standard +3DOS format
+	// Initialize disk directory
+	if err := disk.InitializeDirectory(); err != nil {
+		return fmt.Errorf("failed to initialize directory: %w", err)
 	}
 
 	// Set disk label if provided
 	if opts.Label != "" {
-		if err := setDiskLabel(disk, opts.Label); err != nil {
+		if err := disk.SetLabel(opts.Label); err != nil {
 			return fmt.Errorf("failed to set disk label: %w", err)
 		}
 	}
-
-	// Initialize disk directory
-	if err := disk.InitializeDirectory(); err != nil {
-		return fmt.Errorf("failed to initialize directory: %w", err)
+	if opts.Datestamps {
+		if err := disk.EnableDatestamps(); err != nil {
+			return fmt.Errorf("failed to enable datestamps: %w", err)
+		}
 	}
 
 	// Set up boot sector if requested
@@ -102,70 +167,63 @@ func Create(outPath string, opts *CreateOptions) error {
 	}
 
 	// Save disk image
-	if err := disk.SaveToFile(outPath); err != nil {
-		// Clean up partial file on error
-		os.Remove(outPath)
-		return fmt.Errorf("failed to save disk image: %w", err)
-	}
+	if stdio.IsStd(outPath) {
+		// A pipe cannot be cleaned up afterwards, so verify before writing.
+		data, err := stdio.Encode(disk, outPath)
+		if err != nil {
+			return fmt.Errorf("failed to save disk image: %w", err)
+		}
+		if err := verifyImage(data); err != nil {
+			return fmt.Errorf("disk image verification failed: %w", err)
+		}
+		if _, err := os.Stdout.Write(data); err != nil {
+			return fmt.Errorf("failed to write disk image: %w", err)
+		}
+	} else {
+		if err := stdio.SaveDisk(disk, outPath); err != nil {
+			// Clean up partial file on error
+			os.Remove(outPath)
+			return fmt.Errorf("failed to save disk image: %w", err)
+		}
 
-	// Verify the created image
-	if err := verifyDiskImage(outPath); err != nil {
-		// Clean up invalid file
-		os.Remove(outPath)
-		return fmt.Errorf("disk image verification failed: %w", err)
+		// Verify the created image
+		if err := verifyDiskImage(outPath); err != nil {
+			// Clean up invalid file
+			os.Remove(outPath)
+			return fmt.Errorf("disk image verification failed: %w", err)
+		}
 	}
 
 	if !opts.Quiet {
 		format := "3DOS"
-		switch opts.Format {
-		case FormatCPCData:
+		switch {
+		case opts.Spec != nil:
+			format = fmt.Sprintf("%s (%d tracks, %d side(s), %d x %d-byte sectors)",
+				spec.Name, spec.TracksPerSide, spec.Sides, spec.SectorsPerTrack, spec.SectorSize)
+		case opts.Format == FormatCPCData:
 			format = "CPC data"
-		case FormatCPCSystem:
+		case opts.Format == FormatCPCSystem:
 			format = "CPC system"
+		case opts.Format == FormatPlus3DS:
+			format = "3DOS 720K"
+		case opts.Format == FormatPCW180:
+			format = "PCW 180K"
+		case opts.Format == FormatPCW720:
+			format = "PCW 720K"
 		}
-		fmt.Printf("Created %s format disk image: %s\n", format, outPath)
+		out := stdio.Status(outPath)
+		fmt.Fprintf(out, "Created %s format disk image: %s\n", format, outPath)
 		if opts.Boot {
-			fmt.Println("Disk is bootable")
+			fmt.Fprintln(out, "Disk is bootable")
 		}
 		if opts.Label != "" {
-			fmt.Printf("Disk label: %s\n", opts.Label)
+			fmt.Fprintf(out, "Disk label: %s\n", disk.GetLabel())
 		}
 	}
 
 	return nil
 }
 
-// setDiskLabel sets the disk volume label
-func setDiskLabel(disk *diskimg.DiskImage, label string) error {
-	if len(label) > 11 { // 8.3 format maximum
-		return fmt.Errorf("disk label too long (maximum 11 characters)")
-	}
-
-	dir, err := disk.GetDirectory()
-	if err != nil {
-		return err
-	}
-
-	// Create volume label entry
-	entry := diskimg.DirectoryEntry{
-		Status: 0x00,
-	}
-	copy(entry.Name[:], label)
-
-	// Set volume label attribute
-	attrs := &diskimg.FileAttributes{
-		System:   true,
-		ReadOnly: true,
-	}
-	attrs.ApplyToDirectoryEntry(&entry)
-
-	_ = dir
-	_ = entry
-	// Volume label write-back is not yet wired through the directory flush path;
-	// it is optional for a functional +3 disk and is skipped here.
-	return nil
-}
-
 // setupBootSector prepares a bootable disk
 func setupBootSector(disk *diskimg.DiskImage) error {
 	// Get first sector
@@ -174,27 +232,34 @@ func setupBootSector(disk *diskimg.DiskImage) error {
 		return err
 	}
 
-	// Clear boot sector
+	// Clear boot sector, keeping the disk specification of a non-standard
+	// format (a disk type of 0-3 in byte 0)
+	hasSpec := sector[0] <= 3
 	for i := range sector {
+		if hasSpec && i < 10 {
+			continue
+		}
 		sector[i] = 0
 	}
 
 	// Set disk parameters in boot sector
-	sector[0] = 0  // Standard +3DOS format
-	sector[1] = 0  // Single sided
-	sector[2] = 40 // Tracks per side
-	sector[3] = 9  // Sectors per track
-	sector[4] = 2  // Sector size (512 = 2^(7+2))
-	sector[5] = 1  // Reserved tracks
-	sector[6] = 3  // Block size (1K = 2^(7+3))
-	sector[7] = 2  // Directory blocks
+	if !hasSpec {
+		sector[0] = 0  // Standard +3DOS format
+		sector[1] = 0  // Single sided
+		sector[2] = 40 // Tracks per side
+		sector[3] = 9  // Sectors per track
+		sector[4] = 2  // Sector size (512 = 2^(7+2))
+		sector[5] = 1  // Reserved tracks
+		sector[6] = 3  // Block size (1K = 2^(7+3))
+		sector[7] = 2  // Directory blocks
+	}
 
 	// Calculate checksum
 	var sum byte
 	for i := 0; i < 255; i++ {
 		sum += sector[i]
 	}
-	sector[255] = byte(3 - sum) // Make sum == 3
+	sector[255] = disk.Spec().BootChecksum() - sum // Make sum == 3 (or the PCW checksum)
 
 	// Write boot sector back
 	return disk.SetSectorData(0, 0, 0, sector)
@@ -202,8 +267,17 @@ func setupBootSector(disk *diskimg.DiskImage) error {
 
 // verifyDiskImage checks if the created image is valid
 func verifyDiskImage(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return verifyImage(data)
+}
+
+// verifyImage checks that a serialised disk image loads and passes validation
+func verifyImage(data []byte) error {
 	// Try to load the disk image
-	disk, err := diskimg.LoadFromFile(path)
+	disk, err := stdio.Decode(data, nil)
 	if err != nil {
 		return err
 	}
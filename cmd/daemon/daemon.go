@@ -0,0 +1,413 @@
+// file: cmd/daemon/daemon.go
+
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ha1tch/plus3/cmd/create"
+	"github.com/ha1tch/plus3/cmd/list"
+	"github.com/ha1tch/plus3/internal/stdio"
+	"github.com/ha1tch/plus3/pkg/diskimg"
+)
+
+// maxRequestSize caps a request body: an 8 MB file, base64-encoded, and the
+// rest of the request.
+const maxRequestSize = 12 * 1024 * 1024
+
+// DaemonOptions configures the JSON API daemon
+type DaemonOptions struct {
+	Listen string // unix:///path/to.sock, or host:port for TCP
+	Root   string // Directory the images must lie in; required for TCP
+	Quiet  bool   // Suppress non-error output
+}
+
+// DefaultDaemonOptions returns default options for Serve
+func DefaultDaemonOptions() *DaemonOptions {
+	return &DaemonOptions{
+		Listen: "unix:///tmp/plus3.sock",
+		Root:   "",
+		Quiet:  false,
+	}
+}
+
+// Serve runs the JSON API until the server fails. Each operation is a POST
+// of a JSON object naming the disk image; see doc/MANUAL.md for the
+// operations and their fields. Images stay loaded between requests and are
+// reloaded if the file changes underneath. With a root, image paths are
+// taken relative to it and may not lead outside it; a TCP address, which any
+// local user or the network can reach, is refused without one.
+func Serve(opts *DaemonOptions) error {
+	if opts == nil {
+		opts = DefaultDaemonOptions()
+	}
+	d := &daemon{images: map[string]*cachedImage{}}
+	if opts.Root != "" {
+		root, err := filepath.Abs(opts.Root)
+		if err == nil {
+			root, err = filepath.EvalSymlinks(root)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid root: %w", err)
+		}
+		d.root = root
+	} else if !strings.HasPrefix(opts.Listen, "unix://") {
+		return errors.New("a TCP address needs --root to confine the images served")
+	}
+	ln, err := listen(opts.Listen)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /create", d.handle(d.create))
+	mux.HandleFunc("POST /list", d.handle(d.list))
+	mux.HandleFunc("POST /add", d.handle(d.add))
+	mux.HandleFunc("POST /extract", d.handle(d.extract))
+	mux.HandleFunc("POST /delete", d.handle(d.delete))
+	mux.HandleFunc("POST /check", d.handle(d.check))
+	mux.HandleFunc("POST /close", d.handle(d.close))
+
+	if !opts.Quiet {
+		fmt.Printf("Listening on %s\n", opts.Listen)
+	}
+	return http.Serve(ln, mux)
+}
+
+// listen opens a Unix socket for a unix:// address, replacing a socket left
+// by a daemon that did not shut down, or a TCP port otherwise.
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix://")
+	if !ok {
+		return net.Listen("tcp", strings.TrimPrefix(addr, "tcp://"))
+	}
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	return net.Listen("unix", path)
+}
+
+// daemon holds the open images. Requests are handled one at a time.
+type daemon struct {
+	mu     sync.Mutex
+	root   string // absolute, symlinks resolved; empty for no confinement
+	images map[string]*cachedImage
+}
+
+// cachedImage is a loaded image and the modification time of its file when
+// it was loaded or last saved.
+type cachedImage struct {
+	disk    *diskimg.DiskImage
+	modTime time.Time
+}
+
+// request is the body of every operation; each uses the fields it needs.
+// Image is cleaned, and joined to the root if there is one, so the cache has
+// one entry per image however it is named.
+type request struct {
+	Image       string `json:"image"`
+	Name        string `json:"name,omitempty"`
+	Data        []byte `json:"data,omitempty"` // base64 in JSON
+	Type        string `json:"type,omitempty"` // add: code, basic, basictext, screen or raw
+	LoadAddr    uint16 `json:"load_addr,omitempty"`
+	Line        uint16 `json:"line,omitempty"`
+	Format      string `json:"format,omitempty"`
+	Boot        bool   `json:"boot,omitempty"`
+	Interleave  int    `json:"interleave,omitempty"`
+	Skew        int    `json:"skew,omitempty"`
+	Long        bool   `json:"long,omitempty"`
+	System      bool   `json:"system,omitempty"`
+	Pattern     string `json:"pattern,omitempty"`
+	StripHeader bool   `json:"strip_header,omitempty"`
+	Force       bool   `json:"force,omitempty"`
+}
+
+// handle decodes the request, runs op and writes its result, or an error
+// object, as JSON.
+func (d *daemon) handle(op func(*request) (any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req request
+		dec := json.NewDecoder(io.LimitReader(r.Body, maxRequestSize))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(&req)
+		if err == nil && req.Image == "" {
+			err = errors.New("image is required")
+		}
+		if err == nil {
+			req.Image, err = d.resolve(req.Image)
+		}
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		d.mu.Lock()
+		result, err := op(&req)
+		d.mu.Unlock()
+		if err != nil {
+			slog.Info("request failed", "op", r.URL.Path, "image", req.Image, "err", err)
+			writeJSON(w, errorStatus(err), map[string]string{"error": err.Error()})
+			return
+		}
+		slog.Info("request", "op", r.URL.Path, "image", req.Image)
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// errorStatus maps a library error to an HTTP status.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, diskimg.ErrFileNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, diskimg.ErrFileExists):
+		return http.StatusConflict
+	case errors.Is(err, diskimg.ErrReadOnly):
+		return http.StatusForbidden
+	case errors.Is(err, diskimg.ErrDiskFull), errors.Is(err, diskimg.ErrDirectoryFull):
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// resolve cleans an image path and, if the daemon has a root, joins a
+// relative path to it and rejects one whose file, with symbolic links in its
+// directory followed, lies outside it.
+func (d *daemon) resolve(path string) (string, error) {
+	if d.root == "" {
+		return filepath.Clean(path), nil
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(d.root, path)
+	}
+	path = filepath.Clean(path)
+	file := imageFile(path)
+	dir, err := filepath.EvalSymlinks(filepath.Dir(file))
+	if err != nil {
+		return "", fmt.Errorf("image outside the root: %s", path)
+	}
+	if info, err := os.Lstat(file); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if file, err = filepath.EvalSymlinks(file); err != nil {
+			return "", fmt.Errorf("image outside the root: %s", path)
+		}
+		dir = filepath.Dir(file)
+	}
+	rel, err := filepath.Rel(d.root, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("image outside the root: %s", path)
+	}
+	return path, nil
+}
+
+// imageFile returns the host file holding the image at path.
+func imageFile(path string) string {
+	if image, _, ok := stdio.SplitHDF(path); ok {
+		return image
+	}
+	archive, _, _ := stdio.SplitZip(path)
+	return archive
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(imageFile(path))
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// load returns the image at path, from the cache unless its file has changed.
+func (d *daemon) load(path string) (*diskimg.DiskImage, error) {
+	if stdio.IsStd(path) {
+		return nil, errors.New("the daemon cannot read standard input")
+	}
+	if c, ok := d.images[path]; ok && c.modTime.Equal(modTime(path)) {
+		return c.disk, nil
+	}
+	if err := stdio.Exists(path); err != nil {
+		return nil, err
+	}
+	disk, err := stdio.LoadDisk(path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open disk: %w", err)
+	}
+	d.images[path] = &cachedImage{disk: disk, modTime: modTime(path)}
+	return disk, nil
+}
+
+// save writes the image at path back to its file. If that fails the cached
+// copy, which no longer matches the file, is dropped.
+func (d *daemon) save(path string, disk *diskimg.DiskImage) error {
+	if err := stdio.SaveDisk(disk, path); err != nil {
+		delete(d.images, path)
+		return fmt.Errorf("failed to save disk: %w", err)
+	}
+	d.images[path] = &cachedImage{disk: disk, modTime: modTime(path)}
+	return nil
+}
+
+func (d *daemon) create(req *request) (any, error) {
+	if req.Format == "" {
+		req.Format = "3dos"
+	}
+	format, err := create.ParseFormat(req.Format)
+	if err != nil {
+		return nil, err
+	}
+	opts := create.DefaultCreateOptions()
+	opts.Format, opts.Boot, opts.Force, opts.Quiet = format, req.Boot, req.Force, true
+	opts.Interleave, opts.Skew = req.Interleave, req.Skew
+	if err := create.Create(req.Image, opts); err != nil {
+		return nil, err
+	}
+	delete(d.images, req.Image)
+	return map[string]string{"image": req.Image}, nil
+}
+
+func (d *daemon) list(req *request) (any, error) {
+	disk, err := d.load(req.Image)
+	if err != nil {
+		return nil, err
+	}
+	opts := list.DefaultListOptions()
+	opts.Long, opts.ShowSystem = req.Long, req.System
+	if req.Pattern != "" {
+		opts.Pattern = req.Pattern
+	}
+	files, err := list.Files(disk, opts)
+	if err != nil {
+		return nil, err
+	}
+	if files == nil {
+		files = []list.FileEntry{}
+	}
+	return map[string]any{"image": req.Image, "files": files}, nil
+}
+
+// add stores data as a file. type code and screen add a CODE header, basic a
+// BASIC one (basictext tokenises plain-text source first); raw or no type
+// stores the data as it is.
+func (d *daemon) add(req *request) (any, error) {
+	name := diskimg.NormaliseFilename(req.Name)
+	if name == "" {
+		return nil, errors.New("name is required")
+	}
+	disk, err := d.load(req.Image)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := disk.Stat(name); err == nil && !req.Force {
+		return nil, fmt.Errorf("%w: %s (use force to overwrite)", diskimg.ErrFileExists, name)
+	}
+
+	data := req.Data
+	opts := &diskimg.ImportOptions{AddHeader: true, LoadAddr: req.LoadAddr, Line: req.Line}
+	switch req.Type {
+	case "code":
+		opts.FileType = diskimg.FileTypeCode
+	case "screen":
+		opts.FileType, opts.LoadAddr = diskimg.FileTypeCode, 16384
+	case "basic":
+		opts.FileType = diskimg.FileTypeProgram
+	case "basictext", "basic-text":
+		opts.FileType = diskimg.FileTypeProgram
+		if data, err = diskimg.TokeniseBasic(string(req.Data)); err != nil {
+			return nil, fmt.Errorf("tokenise BASIC source: %w", err)
+		}
+	case "", "raw":
+		opts = nil
+	default:
+		return nil, fmt.Errorf("unknown file type %q", req.Type)
+	}
+	if err := disk.ImportData(name, data, opts); err != nil {
+		return nil, err
+	}
+	if err := d.save(req.Image, disk); err != nil {
+		return nil, err
+	}
+	size, _ := disk.FileSize(name)
+	return map[string]any{"image": req.Image, "name": name, "size": size}, nil
+}
+
+func (d *daemon) extract(req *request) (any, error) {
+	disk, err := d.load(req.Image)
+	if err != nil {
+		return nil, err
+	}
+	name := diskimg.NormaliseFilename(req.Name)
+	f, err := disk.Open(name)
+	if req.StripHeader {
+		f, err = disk.HeaderlessFS().Open(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"image": req.Image, "name": name, "data": data}, nil
+}
+
+// delete removes a file. A read-only file needs force, as with the delete
+// command.
+func (d *daemon) delete(req *request) (any, error) {
+	disk, err := d.load(req.Image)
+	if err != nil {
+		return nil, err
+	}
+	name := diskimg.NormaliseFilename(req.Name)
+	info, err := disk.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&0200 == 0 && !req.Force {
+		return nil, fmt.Errorf("%w: %s (use force to delete)", diskimg.ErrReadOnly, name)
+	}
+	if err := disk.DeleteFile(name); err != nil {
+		return nil, err
+	}
+	if err := d.save(req.Image, disk); err != nil {
+		return nil, err
+	}
+	return map[string]string{"image": req.Image, "name": name}, nil
+}
+
+// check runs the consistency checks of info --validate. A disk that fails
+// them is a successful request with valid false.
+func (d *daemon) check(req *request) (any, error) {
+	disk, err := d.load(req.Image)
+	if err != nil {
+		return nil, err
+	}
+	report := disk.Check()
+	result := map[string]any{"image": req.Image, "valid": true, "findings": report.Findings}
+	if err := report.Err(); err != nil {
+		result["valid"], result["error"] = false, err.Error()
+	}
+	return result, nil
+}
+
+// close drops an image from the cache.
+func (d *daemon) close(req *request) (any, error) {
+	delete(d.images, req.Image)
+	return map[string]string{"image": req.Image}, nil
+}
@@ -4,9 +4,9 @@ package delete
 
 import (
 	"fmt"
-	"os"
 	"strings"
 
+	"github.com/ha1tch/plus3/internal/stdio"
 	"github.com/ha1tch/plus3/pkg/diskimg"
 )
 
@@ -15,6 +15,10 @@ type DeleteOptions struct {
 	Force     bool // Skip confirmation
 	Quiet     bool // Suppress non-error output
 	NoRecycle bool // Don't preserve deleted file info
+	Scrub     bool // Fill the file's blocks with 0xE5 filler
+	Fidelity  bool // Keep the FDC status of rewritten sectors
+	Backup    bool // Keep the previous image as <disk>.bak
+	Journal   bool // Record the change in <disk>.journal for undo
 }
 
 // DefaultDeleteOptions returns default options for Delete
@@ -23,6 +27,10 @@ func DefaultDeleteOptions() *DeleteOptions {
 		Force:     false,
 		Quiet:     false,
 		NoRecycle: false,
+		Scrub:     false,
+		Fidelity:  false,
+		Backup:    false,
+		Journal:   false,
 	}
 }
 
@@ -34,21 +42,28 @@ func Delete(diskPath string, filename string, opts *DeleteOptions) error {
 	}
 
 	// Normalize filename
-	filename = strings.ToUpper(strings.TrimSpace(filename))
+	filename = diskimg.NormaliseFilename(filename)
 	if filename == "" {
 		return fmt.Errorf("filename cannot be empty")
 	}
 
 	// Validate disk exists
-	if _, err := os.Stat(diskPath); os.IsNotExist(err) {
-		return fmt.Errorf("disk image does not exist: %w", err)
+	if err := stdio.Exists(diskPath); err != nil {
+		return err
+	}
+	// Standard input carries the disk image, so it cannot answer the prompt.
+	if stdio.IsStd(diskPath) && !opts.Force {
+		return fmt.Errorf("deleting from a streamed disk image requires force (there is no confirmation prompt)")
 	}
 
 	// Open disk image
-	disk, err := diskimg.LoadFromFile(diskPath)
+	disk, err := stdio.LoadDisk(diskPath, &diskimg.LoadOptions{Fidelity: opts.Fidelity})
 	if err != nil {
 		return fmt.Errorf("failed to open disk: %w", err)
 	}
+	if err := stdio.StartJournal(disk, diskPath, opts.Journal); err != nil {
+		return err
+	}
 
 	// Verify the file exists and check read-only status.
 	dir, err := disk.GetDirectory()
@@ -57,7 +72,7 @@ func Delete(diskPath string, filename string, opts *DeleteOptions) error {
 	}
 	var entry *diskimg.DirectoryEntry
 	for i := range dir {
-		if dir[i].IsUnused() {
+		if !dir[i].IsFile() {
 			continue
 		}
 		if strings.EqualFold(dir[i].GetFilename(), filename) {
@@ -66,14 +81,14 @@ func Delete(diskPath string, filename string, opts *DeleteOptions) error {
 		}
 	}
 	if entry == nil {
-		return fmt.Errorf("file not found: %s", filename)
+		return fmt.Errorf("%w: %s", diskimg.ErrFileNotFound, filename)
 	}
 
 	// Verify file is not read-only unless forced.
 	attrs := &diskimg.FileAttributes{}
 	attrs.ReadFromDirectoryEntry(entry)
 	if attrs.ReadOnly && !opts.Force {
-		return fmt.Errorf("file is read-only: %s (use force to delete)", filename)
+		return fmt.Errorf("%w: %s (use force to delete)", diskimg.ErrReadOnly, filename)
 	}
 
 	// Confirm deletion unless forced.
@@ -90,17 +105,20 @@ func Delete(diskPath string, filename string, opts *DeleteOptions) error {
 	}
 
 	// Perform deletion (frees blocks, marks the entry unused, flushes directory).
-	if deleteErr := disk.DeleteFile(filename); deleteErr != nil {
+	if deleteErr := disk.DeleteFileWithOptions(filename, &diskimg.DeleteOptions{Scrub: opts.Scrub}); deleteErr != nil {
 		return fmt.Errorf("failed to delete file: %w", deleteErr)
 	}
 
 	// Save disk changes
-	if err := disk.SaveToFile(diskPath); err != nil {
+	if err := stdio.SaveDiskWithOptions(disk, diskPath, &diskimg.SaveOptions{Backup: opts.Backup}); err != nil {
 		return fmt.Errorf("failed to save disk: %w", err)
 	}
+	if err := stdio.Journal(disk, diskPath, "delete "+filename); err != nil {
+		return err
+	}
 
 	if !opts.Quiet {
-		fmt.Printf("Deleted %s\n", filename)
+		fmt.Fprintf(stdio.Status(diskPath), "Deleted %s\n", filename)
 	}
 
 	return nil
@@ -0,0 +1,253 @@
+// file: cmd/diskset/diskset.go
+
+package diskset
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ha1tch/plus3/internal/stdio"
+	"github.com/ha1tch/plus3/pkg/diskimg"
+)
+
+// ListOptions configures the set listing
+type ListOptions struct {
+	JSON bool // Output in JSON format
+}
+
+// DefaultListOptions returns default options for List
+func DefaultListOptions() *ListOptions {
+	return &ListOptions{
+		JSON: false,
+	}
+}
+
+// AddOptions configures adding a file to a set
+type AddOptions struct {
+	FileType string // code, basic, raw or auto (by extension)
+	Line     uint16 // Line number for BASIC programs
+	LoadAddr uint16 // Load address for CODE files
+	Quiet    bool   // Suppress non-error output
+}
+
+// DefaultAddOptions returns default options for Add
+func DefaultAddOptions() *AddOptions {
+	return &AddOptions{
+		FileType: "auto",
+		Line:     10,
+		LoadAddr: 32768,
+		Quiet:    false,
+	}
+}
+
+// ExtractOptions configures extracting a file from a set
+type ExtractOptions struct {
+	StripHeader bool   // Remove PLUS3DOS header if present
+	OutputDir   string // Directory to extract files to
+	Overwrite   bool   // Allow overwriting existing files
+	Quiet       bool   // Suppress non-error output
+}
+
+// DefaultExtractOptions returns default options for Extract
+func DefaultExtractOptions() *ExtractOptions {
+	return &ExtractOptions{
+		StripHeader: false,
+		OutputDir:   "",
+		Overwrite:   false,
+		Quiet:       false,
+	}
+}
+
+// FileInfo describes one file of a disk set
+type FileInfo struct {
+	Name  string     `json:"name"`
+	Size  int        `json:"size"`
+	Parts []PartInfo `json:"parts"`
+}
+
+// PartInfo describes the part of a file on one disk
+type PartInfo struct {
+	Disk string `json:"disk"`
+	Name string `json:"name"`
+	Size int    `json:"size"`
+}
+
+// loadSet loads the disks of a set, in order.
+func loadSet(diskPaths []string) (*diskimg.DiskSet, error) {
+	set := diskimg.NewDiskSet()
+	for _, path := range diskPaths {
+		if stdio.IsStd(path) {
+			return nil, fmt.Errorf("a disk set cannot be read from standard input")
+		}
+		if err := stdio.Exists(path); err != nil {
+			return nil, err
+		}
+		disk, err := stdio.LoadDisk(path, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open disk %s: %w", path, err)
+		}
+		set.Disks = append(set.Disks, disk)
+	}
+	return set, nil
+}
+
+// List prints the files of a disk set, showing which disks hold the parts of
+// files split across disks.
+func List(diskPaths []string, opts *ListOptions) error {
+	if opts == nil {
+		opts = DefaultListOptions()
+	}
+	set, err := loadSet(diskPaths)
+	if err != nil {
+		return err
+	}
+
+	var files []FileInfo
+	for _, f := range set.Files() {
+		info := FileInfo{Name: f.Name, Size: f.Size()}
+		for _, p := range f.Parts {
+			info.Parts = append(info.Parts, PartInfo{Disk: diskPaths[p.Disk], Name: p.Name, Size: p.Size})
+		}
+		files = append(files, info)
+	}
+
+	if opts.JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(files)
+	}
+
+	fmt.Printf("\n Disk set of %d disk(s)\n\n", len(diskPaths))
+	total := 0
+	for _, f := range files {
+		disks := make([]string, len(f.Parts))
+		for i, p := range f.Parts {
+			disks[i] = filepath.Base(p.Disk)
+		}
+		fmt.Printf("  %-12s %10d  %s\n", f.Name, f.Size, strings.Join(disks, ", "))
+		total += f.Size
+	}
+	fmt.Printf("\n    %d File(s)    %10d bytes\n", len(files), total)
+	return nil
+}
+
+// Add stores a host file in a disk set, splitting it across the disks if no
+// single disk has room for it. The file is named as the add command names it.
+func Add(filePath string, diskPaths []string, opts *AddOptions) error {
+	if opts == nil {
+		opts = DefaultAddOptions()
+	}
+	set, err := loadSet(diskPaths)
+	if err != nil {
+		return err
+	}
+
+	fileType := opts.FileType
+	if fileType == "auto" {
+		switch strings.ToLower(filepath.Ext(filePath)) {
+		case ".bas":
+			fileType = "basic"
+		case ".bin":
+			fileType = "code"
+		default:
+			fileType = "raw"
+		}
+	}
+	base := filepath.Base(filePath)
+	stem := strings.TrimSuffix(base, filepath.Ext(base))
+	if len(stem) > 8 {
+		stem = stem[:8]
+	}
+	var name string
+	var importOpts *diskimg.ImportOptions
+	switch fileType {
+	case "basic":
+		name = stem + ".BAS"
+		importOpts = &diskimg.ImportOptions{AddHeader: true, FileType: diskimg.FileTypeProgram, Line: opts.Line}
+	case "code":
+		name = stem + ".BIN"
+		importOpts = &diskimg.ImportOptions{AddHeader: true, FileType: diskimg.FileTypeCode, LoadAddr: opts.LoadAddr}
+	case "raw":
+		name = base
+		if len(name) > 12 {
+			name = name[:12]
+		}
+	default:
+		return fmt.Errorf("unknown file type %q", opts.FileType)
+	}
+
+	parts, err := set.ImportFile(filePath, name, importOpts)
+	if err != nil {
+		return fmt.Errorf("failed to import file: %w", err)
+	}
+	for i, disk := range set.Disks {
+		if !disk.Modified {
+			continue
+		}
+		if err := stdio.SaveDisk(disk, diskPaths[i]); err != nil {
+			return fmt.Errorf("failed to save disk %s: %w", diskPaths[i], err)
+		}
+	}
+
+	if !opts.Quiet {
+		if len(parts) == 1 && parts[0].Name == diskimg.NormaliseFilename(name) {
+			fmt.Printf("Added %s to %s\n", base, diskPaths[parts[0].Disk])
+			return nil
+		}
+		fmt.Printf("Added %s in %d parts:\n", base, len(parts))
+		for _, p := range parts {
+			fmt.Printf("  %-12s %8d bytes  %s\n", p.Name, p.Size, diskPaths[p.Disk])
+		}
+	}
+	return nil
+}
+
+// Extract copies a file of a disk set to the host filesystem, joining the
+// parts of a file split across disks.
+func Extract(filename string, diskPaths []string, opts *ExtractOptions) error {
+	if opts == nil {
+		opts = DefaultExtractOptions()
+	}
+	filename = diskimg.NormaliseFilename(filename)
+	if filename == "" {
+		return fmt.Errorf("filename cannot be empty")
+	}
+	set, err := loadSet(diskPaths)
+	if err != nil {
+		return err
+	}
+
+	if opts.OutputDir != "" {
+		if err := os.MkdirAll(opts.OutputDir, 0755); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
+	outPath := filepath.Join(opts.OutputDir, filename)
+	if !opts.Overwrite {
+		if _, err := os.Stat(outPath); err == nil {
+			return fmt.Errorf("output %w: %s (use overwrite to replace)", diskimg.ErrFileExists, outPath)
+		}
+	}
+
+	data, err := set.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	if opts.StripHeader && len(data) >= diskimg.HeaderSize {
+		h := &diskimg.Plus3DosHeader{}
+		if h.FromBytes(data[:diskimg.HeaderSize]) == nil && h.Validate() == nil {
+			data = data[diskimg.HeaderSize:]
+		}
+	}
+	if err := os.WriteFile(outPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", outPath, err)
+	}
+
+	if !opts.Quiet {
+		fmt.Printf("Extracted %s to %s\n", filename, outPath)
+	}
+	return nil
+}
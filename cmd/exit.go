@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+
+	"github.com/ha1tch/plus3/pkg/diskimg"
+)
+
+// Exit status codes, so scripts can branch on the kind of failure instead of
+// parsing the message on standard error. They are part of the CLI contract:
+// do not renumber them.
+const (
+	exitOK         = 0
+	exitError      = 1 // any failure not covered below
+	exitUsage      = 2 // bad command line (the flag package also exits 2)
+	exitNotFound   = 3 // disk image, host file or file on the disk does not exist
+	exitValidation = 4 // the disk check found a problem
+	exitDiskFull   = 5 // no free blocks or directory entries
+	exitNameClash  = 6 // the target file already exists
+	exitIO         = 7 // reading or writing a host file failed
+	exitCorrupt    = 8 // the disk image cannot be parsed
+)
+
+// usageError marks an error in the command line itself.
+type usageError struct{ error }
+
+// exitCode maps an error returned by a command to its exit status, using the
+// sentinel errors of pkg/diskimg and the standard library.
+func exitCode(err error) int {
+	var usage usageError
+	var pathErr *fs.PathError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &usage), errors.Is(err, diskimg.ErrInvalidSpec):
+		return exitUsage
+	case errors.Is(err, diskimg.ErrCorruptImage):
+		return exitCorrupt
+	case errors.Is(err, diskimg.ErrCheckFailed):
+		return exitValidation
+	case errors.Is(err, diskimg.ErrDiskFull), errors.Is(err, diskimg.ErrDirectoryFull):
+		return exitDiskFull
+	case errors.Is(err, diskimg.ErrFileExists), errors.Is(err, fs.ErrExist):
+		return exitNameClash
+	case errors.Is(err, diskimg.ErrFileNotFound), errors.Is(err, fs.ErrNotExist):
+		return exitNotFound
+	case errors.As(err, &pathErr), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.ErrShortWrite):
+		return exitIO
+	}
+	return exitError
+}
+
+// hint returns advice to print after the message of an error a user can
+// do something about, or "".
+func hint(err error) string {
+	var sectorErr *diskimg.SectorError
+	switch {
+	case errors.Is(err, diskimg.ErrDiskFull), errors.Is(err, diskimg.ErrDirectoryFull):
+		return "delete some files from the disk, or create a larger one (create --format 720k)"
+	case errors.Is(err, diskimg.ErrFileNotFound):
+		return `"plus3 list <disk>" shows the files on the disk`
+	case errors.Is(err, diskimg.ErrInvalidFilename):
+		return "+3DOS names have up to 8 characters, optionally a dot and up to 3 more; add --sanitise makes them so"
+	case errors.Is(err, diskimg.ErrFileTooLarge):
+		return "split it across a disk set with \"plus3 set add\""
+	case errors.As(err, &sectorErr), errors.Is(err, diskimg.ErrCorruptImage):
+		return `the image is damaged; "plus3 info --salvage --validate <disk>" shows where`
+	}
+	return ""
+}
@@ -0,0 +1,53 @@
+// file: cmd/exportjson/exportjson.go
+
+package exportjson
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/ha1tch/plus3/internal/stdio"
+	"github.com/ha1tch/plus3/pkg/diskimg"
+)
+
+// ExportJSONOptions configures the export-json operation
+type ExportJSONOptions struct {
+	Compact bool // One line of JSON rather than indented
+	Salvage bool // Load a damaged image, concealing track errors
+}
+
+// DefaultExportJSONOptions returns default options for ExportJSON
+func DefaultExportJSONOptions() *ExportJSONOptions {
+	return &ExportJSONOptions{
+		Compact: false,
+		Salvage: false,
+	}
+}
+
+// ExportJSON writes the whole structure of a disk image to w as JSON: its
+// container header, tracks and sectors with their data, directory, files and
+// headers, and validation findings
+func ExportJSON(diskPath string, w io.Writer, opts *ExportJSONOptions) error {
+	if opts == nil {
+		opts = DefaultExportJSONOptions()
+	}
+	if err := stdio.Exists(diskPath); err != nil {
+		return err
+	}
+	disk, err := stdio.OpenDisk(diskPath, &diskimg.LoadOptions{Salvage: opts.Salvage})
+	if err != nil {
+		return fmt.Errorf("failed to open disk: %w", err)
+	}
+	defer disk.Close()
+
+	dump, err := disk.Dump()
+	if err != nil {
+		return fmt.Errorf("failed to dump disk: %w", err)
+	}
+	encoder := json.NewEncoder(w)
+	if !opts.Compact {
+		encoder.SetIndent("", "  ")
+	}
+	return encoder.Encode(dump)
+}
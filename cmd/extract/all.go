@@ -0,0 +1,95 @@
+// file: cmd/extract/all.go
+
+package extract
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/ha1tch/plus3/internal/stdio"
+	"github.com/ha1tch/plus3/pkg/diskimg"
+)
+
+// ExtractAll extracts every file on the disk image into opts.OutputDir, each
+// as Extract would without a conversion flag. The image is read once, and up
+// to opts.Jobs files are extracted at once, each from its own snapshot of it.
+// What became of each file is reported in directory order once all are done;
+// a file that cannot be extracted is counted in the error returned
+func ExtractAll(diskPath string, opts *ExtractOptions) error {
+	if opts == nil {
+		opts = DefaultExtractOptions()
+	}
+	if err := stdio.Exists(diskPath); err != nil {
+		return err
+	}
+	if opts.OutputDir != "" {
+		if err := os.MkdirAll(opts.OutputDir, 0755); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
+	disk, err := stdio.LoadDisk(diskPath, nil)
+	if err != nil {
+		return fmt.Errorf("failed to open disk: %w", err)
+	}
+
+	// Files of the same name in different user areas would be written to the
+	// same host file; the first is extracted.
+	var names []string
+	for e := range disk.Files(&diskimg.FilesOptions{System: true}) {
+		if name := diskimg.NormaliseFilename(e.GetFilename()); !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	errs := make([]error, len(names))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range max(min(opts.Jobs, len(names)), 1) {
+		wg.Add(1)
+		// A snapshot shares the track data but has its own directory, so
+		// the workers do not race on the disk's state.
+		go func(disk *diskimg.DiskImage) {
+			defer wg.Done()
+			for i := range next {
+				errs[i] = extractOne(disk, names[i], opts)
+			}
+		}(disk.Snapshot())
+	}
+	for i := range names {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	failed := 0
+	for i, name := range names {
+		switch {
+		case errs[i] != nil:
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", name, errs[i])
+			failed++
+		case !opts.Quiet:
+			fmt.Printf("Extracted %s to %s\n", name, filepath.Join(opts.OutputDir, name))
+		}
+	}
+	if !opts.Quiet {
+		fmt.Printf("%d of %d file(s) extracted\n", len(names)-failed, len(names))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d file(s) could not be extracted", failed, len(names))
+	}
+	return nil
+}
+
+// extractOne extracts one file for ExtractAll
+func extractOne(disk *diskimg.DiskImage, name string, opts *ExtractOptions) error {
+	outPath := filepath.Join(opts.OutputDir, name)
+	if !opts.Overwrite {
+		if _, err := os.Stat(outPath); err == nil {
+			return fmt.Errorf("output %w: %s (use overwrite to replace)", diskimg.ErrFileExists, outPath)
+		}
+	}
+	return extractFile(disk, name, outPath, opts)
+}
@@ -3,11 +3,18 @@
 package extract
 
 import (
+	"bytes"
 	"fmt"
+	"image"
+	"image/gif"
+	"image/png"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 
+	"github.com/ha1tch/plus3/internal/stdio"
 	"github.com/ha1tch/plus3/pkg/diskimg"
 )
 
@@ -19,6 +26,16 @@ type ExtractOptions struct {
 	Quiet       bool   // Suppress non-error output
 	PreserveCAS bool   // Preserve Sinclair BASIC encoding
 	Basic       bool   // Detokenise a BASIC program to readable text
+	PNG         string // Render a SCREEN$ to this PNG file instead
+	GIF         string // Render a SCREEN$ to this GIF file, animating FLASH
+	Scale       int    // Pixel scale for PNG or GIF output
+	Array       string // Convert an array to "csv" or "json"
+	Text        bool   // Convert a text file's line endings and soft EOF
+	Charset     bool   // With Text, map the Spectrum character set to UTF-8
+	Hobeta      bool   // Write the file as a Hobeta (.$x) file
+	Amsdos      bool   // Write the file with an AMSDOS header for the CPC
+	LocoScript  *bool  // Convert a LocoScript document to text: always, never, or if nil by content
+	Jobs        int    // Files ExtractAll extracts at once
 }
 
 // DefaultExtractOptions returns default options for Extract
@@ -30,6 +47,16 @@ func DefaultExtractOptions() *ExtractOptions {
 		Quiet:       false,
 		PreserveCAS: false,
 		Basic:       false,
+		PNG:         "",
+		GIF:         "",
+		Scale:       1,
+		Array:       "",
+		Text:        false,
+		Charset:     false,
+		Hobeta:      false,
+		Amsdos:      false,
+		LocoScript:  nil,
+		Jobs:        runtime.NumCPU(),
 	}
 }
 
@@ -41,14 +68,14 @@ func Extract(diskPath string, filename string, opts *ExtractOptions) error {
 	}
 
 	// Normalize filename
-	filename = strings.ToUpper(strings.TrimSpace(filename))
+	filename = diskimg.NormaliseFilename(filename)
 	if filename == "" {
 		return fmt.Errorf("filename cannot be empty")
 	}
 
 	// Validate disk exists
-	if _, err := os.Stat(diskPath); os.IsNotExist(err) {
-		return fmt.Errorf("disk image does not exist: %w", err)
+	if err := stdio.Exists(diskPath); err != nil {
+		return err
 	}
 
 	// Validate/create output directory
@@ -63,16 +90,22 @@ func Extract(diskPath string, filename string, opts *ExtractOptions) error {
 	if opts.OutputDir != "" {
 		outPath = filepath.Join(opts.OutputDir, filename)
 	}
+	if opts.PNG != "" {
+		outPath = opts.PNG
+	}
+	if opts.GIF != "" {
+		outPath = opts.GIF
+	}
 
 	// Check if output file exists
 	if !opts.Overwrite {
 		if _, err := os.Stat(outPath); err == nil {
-			return fmt.Errorf("output file already exists: %s (use overwrite to replace)", outPath)
+			return fmt.Errorf("output %w: %s (use overwrite to replace)", diskimg.ErrFileExists, outPath)
 		}
 	}
 
 	// Open disk image
-	disk, err := diskimg.LoadFromFile(diskPath)
+	disk, err := stdio.LoadDisk(diskPath, nil)
 	if err != nil {
 		return fmt.Errorf("failed to open disk: %w", err)
 	}
@@ -85,7 +118,7 @@ func Extract(diskPath string, filename string, opts *ExtractOptions) error {
 
 	found := false
 	for i := range dir {
-		if dir[i].IsUnused() {
+		if !dir[i].IsFile() {
 			continue
 		}
 		if strings.EqualFold(dir[i].GetFilename(), filename) {
@@ -94,7 +127,106 @@ func Extract(diskPath string, filename string, opts *ExtractOptions) error {
 		}
 	}
 	if !found {
-		return fmt.Errorf("file not found: %s", filename)
+		return fmt.Errorf("%w: %s", diskimg.ErrFileNotFound, filename)
+	}
+
+	// --png: render a SCREEN$ as a true-colour PNG rather than copying its bytes.
+	if opts.PNG != "" {
+		img, err := disk.ReadScreen(filename)
+		if err != nil {
+			return fmt.Errorf("failed to render %s: %w", filename, err)
+		}
+		if err := writePNG(outPath, diskimg.ScaleScreen(img, opts.Scale)); err != nil {
+			os.Remove(outPath)
+			return fmt.Errorf("failed to write %s: %w", outPath, err)
+		}
+		if !opts.Quiet {
+			fmt.Printf("Rendered %s to %s\n", filename, outPath)
+		}
+		return nil
+	}
+
+	// --gif: render a SCREEN$ with its FLASH cells animated.
+	if opts.GIF != "" {
+		anim, err := disk.ReadScreenGIF(filename, opts.Scale)
+		if err != nil {
+			return fmt.Errorf("failed to render %s: %w", filename, err)
+		}
+		if err := writeGIF(outPath, anim); err != nil {
+			os.Remove(outPath)
+			return fmt.Errorf("failed to write %s: %w", outPath, err)
+		}
+		if !opts.Quiet {
+			fmt.Printf("Rendered %s to %s\n", filename, outPath)
+		}
+		return nil
+	}
+
+	// --array: convert a numeric or character array to CSV or JSON, written
+	// like --basic's text: to stdout, or to <name>.csv or <name>.json with -o.
+	if opts.Array != "" {
+		a, err := disk.ReadArray(filename)
+		if err != nil {
+			return fmt.Errorf("failed to read array %s: %w", filename, err)
+		}
+		var buf bytes.Buffer
+		switch opts.Array {
+		case "json":
+			js, err := a.MarshalJSON()
+			if err != nil {
+				return err
+			}
+			buf.Write(append(js, '\n'))
+		case "csv":
+			if err := a.WriteCSV(&buf); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown array format %q", opts.Array)
+		}
+		if opts.OutputDir == "" {
+			_, err := os.Stdout.Write(buf.Bytes())
+			return err
+		}
+		hostPath := filepath.Join(opts.OutputDir, filename+"."+opts.Array)
+		if !opts.Overwrite {
+			if _, err := os.Stat(hostPath); err == nil {
+				return fmt.Errorf("output %w: %s (use overwrite to replace)", diskimg.ErrFileExists, hostPath)
+			}
+		}
+		if err := os.WriteFile(hostPath, buf.Bytes(), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", hostPath, err)
+		}
+		if !opts.Quiet {
+			fmt.Printf("Converted %s to %s\n", filename, hostPath)
+		}
+		return nil
+	}
+
+	// --hobeta: write a TR-DOS file in a Hobeta container, named as is usual
+	// for one: the TR-DOS name and type, as GAME.$C.
+	if opts.Hobeta {
+		f, err := disk.ExportHobeta(filename)
+		if err != nil {
+			return fmt.Errorf("failed to convert %s: %w", filename, err)
+		}
+		hostPath := filepath.Join(opts.OutputDir, f.HobetaName())
+		if !opts.Overwrite {
+			if _, err := os.Stat(hostPath); err == nil {
+				return fmt.Errorf("output %w: %s (use overwrite to replace)", diskimg.ErrFileExists, hostPath)
+			}
+		}
+		var buf bytes.Buffer
+		if err := f.SaveHobeta(&buf); err != nil {
+			return fmt.Errorf("failed to convert %s: %w", filename, err)
+		}
+		if err := os.WriteFile(hostPath, buf.Bytes(), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", hostPath, err)
+		}
+		if !opts.Quiet {
+			fmt.Printf("Extracted %s to %s\n", filename, hostPath)
+		}
+		return nil
 	}
 
 	// --basic: detokenise the BASIC program to readable text. By default the text
@@ -112,7 +244,7 @@ func Extract(diskPath string, filename string, opts *ExtractOptions) error {
 		txtPath := filepath.Join(opts.OutputDir, filename+".txt")
 		if !opts.Overwrite {
 			if _, err := os.Stat(txtPath); err == nil {
-				return fmt.Errorf("output file already exists: %s (use overwrite to replace)", txtPath)
+				return fmt.Errorf("output %w: %s (use overwrite to replace)", diskimg.ErrFileExists, txtPath)
 			}
 		}
 		if err := os.WriteFile(txtPath, []byte(text), 0644); err != nil {
@@ -129,35 +261,80 @@ func Extract(diskPath string, filename string, opts *ExtractOptions) error {
 	// --basic was not given). This is advisory only - the extraction proceeds
 	// exactly as asked - but it nudges the user toward --basic if a readable
 	// listing was what they wanted.
-	ext := strings.ToLower(filepath.Ext(filename))
 	if !opts.Quiet && disk.IsBasicProgram(filename) {
-		fmt.Fprintf(os.Stderr,
-			"Warning: %s is a tokenised BASIC program; extracting it as bytes. "+
-				"Use --basic to detokenise it to readable text.\n", filename)
+		slog.Warn(fmt.Sprintf("%s is a tokenised BASIC program; extracting it as bytes. "+
+			"Use --basic to detokenise it to readable text.", filename))
 	}
 
-	// Extract based on file extension.
-	var extractErr error
+	if err := extractFile(disk, filename, outPath, opts); err != nil {
+		return err
+	}
+	if !opts.Quiet {
+		fmt.Printf("Extracted %s to %s\n", filename, outPath)
+	}
+
+	return nil
+}
 
+// extractFile writes a file to outPath, converted by its extension and opts,
+// removing what it wrote if it fails
+func extractFile(disk *diskimg.DiskImage, filename, outPath string, opts *ExtractOptions) error {
+	var err error
+	ext := strings.ToLower(filepath.Ext(filename))
 	switch {
+	case opts.Text:
+		err = disk.ExportText(filename, outPath, &diskimg.TextOptions{Charset: opts.Charset})
+	case opts.Amsdos:
+		err = disk.ExportAmsdos(filename, outPath)
+	case locoScript(disk, filename, opts):
+		err = disk.ExportLocoScript(filename, outPath)
 	case ext == ".bas" && !opts.PreserveCAS:
-		extractErr = disk.ExtractBasic(filename, outPath)
+		err = disk.ExtractBasic(filename, outPath)
 	case ext == ".scr":
-		extractErr = disk.ExportScreen(filename, outPath)
+		err = disk.ExportScreen(filename, outPath)
 	default:
 		// Generic file export (CODE/binary and anything else).
-		extractErr = disk.ExportFile(filename, outPath, opts.StripHeader)
+		err = disk.ExportFile(filename, outPath, opts.StripHeader)
 	}
-
-	if extractErr != nil {
+	if err != nil {
 		// Clean up partial output file on error
 		os.Remove(outPath)
-		return fmt.Errorf("failed to extract file: %w", extractErr)
+		return fmt.Errorf("failed to extract file: %w", err)
 	}
+	return nil
+}
 
-	if !opts.Quiet {
-		fmt.Printf("Extracted %s to %s\n", filename, outPath)
+// locoScript reports whether the file is to be converted from LocoScript:
+// as opts.LocoScript says, or if it is unset, if it is a LocoScript document
+func locoScript(disk *diskimg.DiskImage, filename string, opts *ExtractOptions) bool {
+	if opts.LocoScript != nil {
+		return *opts.LocoScript
 	}
+	return !opts.StripHeader && disk.IsLocoScriptFile(filename)
+}
 
-	return nil
+// writePNG encodes img to a new file at path
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeGIF encodes anim to a new file at path
+func writeGIF(path string, anim *gif.GIF) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := gif.EncodeAll(f, anim); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
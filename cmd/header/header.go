@@ -0,0 +1,210 @@
+// file: cmd/header/header.go
+
+package header
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ha1tch/plus3/internal/stdio"
+	"github.com/ha1tch/plus3/pkg/diskimg"
+)
+
+// HeaderOptions configures the header operation. With none of the editing
+// options set, Header only prints the header.
+type HeaderOptions struct {
+	Line        *uint16 // New LINE of a BASIC program, or nil
+	LoadAddr    *uint16 // New load address of a CODE file, or nil
+	Type        *byte   // New file type (diskimg.FileTypeProgram, ...), or nil
+	FixChecksum bool    // Recompute the checksum
+	Strip       bool    // Remove the header, leaving the data
+	Add         bool    // Give a headerless file a header
+	Quiet       bool    // Suppress non-error output
+	Fidelity    bool    // Keep the FDC status of rewritten sectors
+	Backup      bool    // Keep the previous image as <disk>.bak
+	Journal     bool    // Record the change in <disk>.journal for undo
+}
+
+// DefaultHeaderOptions returns default options for Header
+func DefaultHeaderOptions() *HeaderOptions {
+	return &HeaderOptions{
+		Line:        nil,
+		LoadAddr:    nil,
+		Type:        nil,
+		FixChecksum: false,
+		Strip:       false,
+		Add:         false,
+		Quiet:       false,
+		Fidelity:    false,
+		Backup:      false,
+		Journal:     false,
+	}
+}
+
+// editing reports whether opts change the header rather than only print it
+func (opts *HeaderOptions) editing() bool {
+	return opts.Line != nil || opts.LoadAddr != nil || opts.Type != nil || opts.FixChecksum || opts.Strip || opts.Add
+}
+
+// Header prints the PLUS3DOS header of a file on the disk image to w, or
+// edits it in place as opts say and prints the result
+func Header(diskPath, filename string, w io.Writer, opts *HeaderOptions) error {
+	if opts == nil {
+		opts = DefaultHeaderOptions()
+	}
+	filename = diskimg.NormaliseFilename(filename)
+	if filename == "" {
+		return fmt.Errorf("filename cannot be empty")
+	}
+	if opts.Strip && (opts.Add || opts.FixChecksum || opts.Line != nil || opts.LoadAddr != nil || opts.Type != nil) {
+		return fmt.Errorf("strip cannot be combined with other header changes")
+	}
+	if err := stdio.Exists(diskPath); err != nil {
+		return err
+	}
+
+	if !opts.editing() {
+		disk, err := stdio.OpenDisk(diskPath, nil)
+		if err != nil {
+			return fmt.Errorf("failed to open disk: %w", err)
+		}
+		defer disk.Close()
+		h, err := disk.ReadRawHeader(filename)
+		if err != nil {
+			return err
+		}
+		printHeader(w, filename, h)
+		return nil
+	}
+
+	disk, err := stdio.LoadDisk(diskPath, &diskimg.LoadOptions{Fidelity: opts.Fidelity})
+	if err != nil {
+		return fmt.Errorf("failed to open disk: %w", err)
+	}
+	if err := stdio.StartJournal(disk, diskPath, opts.Journal); err != nil {
+		return err
+	}
+	if err := edit(disk, filename, opts); err != nil {
+		return err
+	}
+	if err := stdio.SaveDiskWithOptions(disk, diskPath, &diskimg.SaveOptions{Backup: opts.Backup}); err != nil {
+		return fmt.Errorf("failed to save disk: %w", err)
+	}
+	if err := stdio.Journal(disk, diskPath, "header "+filename); err != nil {
+		return err
+	}
+
+	if !opts.Quiet {
+		// The header goes with the other status messages, clear of a disk
+		// image written to standard output.
+		h, err := disk.ReadRawHeader(filename)
+		if err != nil {
+			return err
+		}
+		printHeader(stdio.Status(diskPath), filename, h)
+	}
+	return nil
+}
+
+// edit makes the changes opts ask for to the header of filename
+func edit(disk *diskimg.DiskImage, filename string, opts *HeaderOptions) error {
+	if opts.Strip {
+		return disk.StripHeader(filename)
+	}
+
+	h, err := disk.ReadRawHeader(filename)
+	if err != nil {
+		return err
+	}
+	if opts.Add {
+		if h != nil {
+			return fmt.Errorf("%w: %s already has a PLUS3DOS header", diskimg.ErrInvalidHeader, filename)
+		}
+		imp := &diskimg.ImportOptions{FileType: diskimg.FileTypeCode, Line: 0x8000, LoadAddr: 32768}
+		if opts.Type != nil {
+			imp.FileType = *opts.Type
+		}
+		if opts.Line != nil {
+			imp.Line = *opts.Line
+		}
+		if opts.LoadAddr != nil {
+			imp.LoadAddr = *opts.LoadAddr
+		}
+		if err := checkParams(imp.FileType, opts); err != nil {
+			return err
+		}
+		return disk.AddHeader(filename, imp)
+	}
+	if h == nil {
+		return fmt.Errorf("%w: %s has no PLUS3DOS header (use add to give it one)", diskimg.ErrInvalidHeader, filename)
+	}
+
+	fileType, length, param1, param2 := h.GetBasicHeader()
+	if opts.Type != nil && *opts.Type != fileType {
+		fileType = *opts.Type
+		if fileType == diskimg.FileTypeProgram {
+			param1, param2 = 0x8000, length // no autostart, no variables
+		}
+	}
+	if err := checkParams(fileType, opts); err != nil {
+		return err
+	}
+	if opts.Line != nil {
+		param1 = *opts.Line
+	}
+	if opts.LoadAddr != nil {
+		param1 = *opts.LoadAddr
+	}
+	if err := h.SetBasicHeader(fileType, length, param1, param2); err != nil {
+		return err
+	}
+	return disk.WriteHeader(filename, h)
+}
+
+// checkParams rejects a LINE for a file that is not a program, or a load
+// address for one that is not CODE
+func checkParams(fileType byte, opts *HeaderOptions) error {
+	if opts.Line != nil && fileType != diskimg.FileTypeProgram {
+		return fmt.Errorf("set-line applies only to a BASIC program")
+	}
+	if opts.LoadAddr != nil && fileType != diskimg.FileTypeCode {
+		return fmt.Errorf("set-load-addr applies only to a CODE file")
+	}
+	return nil
+}
+
+// printHeader writes the fields of h, or says there is no header
+func printHeader(w io.Writer, filename string, h *diskimg.Plus3DosHeader) {
+	fmt.Fprintf(w, "File:        %s\n", filename)
+	if h == nil {
+		fmt.Fprintf(w, "Header:      none\n")
+		return
+	}
+	fileType, length, param1, param2 := h.GetBasicHeader()
+	fmt.Fprintf(w, "Type:        %s (%d)\n", h.GetFileType(), fileType)
+	fmt.Fprintf(w, "Length:      %d\n", length)
+	switch fileType {
+	case diskimg.FileTypeProgram:
+		if param1 < 0x8000 {
+			fmt.Fprintf(w, "LINE:        %d\n", param1)
+		} else {
+			fmt.Fprintf(w, "LINE:        none\n")
+		}
+		fmt.Fprintf(w, "Program:     %d (variables %d)\n", param2, int(length)-int(param2))
+	case diskimg.FileTypeNumericArray, diskimg.FileTypeCharArray:
+		name := string(rune('a' + param1&0x1F - 1))
+		if fileType == diskimg.FileTypeCharArray {
+			name += "$"
+		}
+		fmt.Fprintf(w, "Variable:    %s() (%#02x)\n", name, param1)
+	case diskimg.FileTypeCode:
+		fmt.Fprintf(w, "Load addr:   %d (%#04x)\n", param1, param1)
+	}
+	fmt.Fprintf(w, "File length: %d\n", h.FileLength)
+	fmt.Fprintf(w, "Issue:       %d, version %d\n", h.Issue, h.Version)
+	if h.ChecksumValid() {
+		fmt.Fprintf(w, "Checksum:    %#02x (valid)\n", h.Checksum)
+	} else {
+		fmt.Fprintf(w, "Checksum:    %#02x (INVALID)\n", h.Checksum)
+	}
+}
@@ -0,0 +1,68 @@
+// file: cmd/importjson/importjson.go
+
+package importjson
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ha1tch/plus3/internal/stdio"
+	"github.com/ha1tch/plus3/pkg/diskimg"
+)
+
+// ImportJSONOptions configures the import-json operation
+type ImportJSONOptions struct {
+	Force bool // Overwrite existing output file
+	Quiet bool // Suppress non-error output
+}
+
+// DefaultImportJSONOptions returns default options for ImportJSON
+func DefaultImportJSONOptions() *ImportJSONOptions {
+	return &ImportJSONOptions{
+		Force: false,
+		Quiet: false,
+	}
+}
+
+// ImportJSON rebuilds a disk image from the JSON dump export-json writes and
+// saves it to outPath, in any encoding SaveDisk writes. jsonPath may be "-"
+// for standard input
+func ImportJSON(jsonPath, outPath string, opts *ImportJSONOptions) error {
+	if opts == nil {
+		opts = DefaultImportJSONOptions()
+	}
+	if !opts.Force && !stdio.IsStd(outPath) {
+		if _, err := os.Stat(outPath); err == nil {
+			return fmt.Errorf("%w: %s (use force to overwrite)", diskimg.ErrFileExists, outPath)
+		}
+	}
+
+	var data []byte
+	var err error
+	if stdio.IsStd(jsonPath) {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(jsonPath)
+	}
+	if err != nil {
+		return err
+	}
+	var dump diskimg.ImageDump
+	if err := json.Unmarshal(data, &dump); err != nil {
+		return fmt.Errorf("%w: %s is not a disk image dump: %v", diskimg.ErrCorruptImage, jsonPath, err)
+	}
+	disk, err := diskimg.LoadDump(&dump, nil)
+	if err != nil {
+		return fmt.Errorf("failed to rebuild disk: %w", err)
+	}
+
+	if err := stdio.SaveDisk(disk, outPath); err != nil {
+		return fmt.Errorf("failed to save disk image: %w", err)
+	}
+	if !opts.Quiet {
+		fmt.Fprintf(stdio.Status(outPath), "Rebuilt %s from %s (%s format)\n", outPath, jsonPath, disk.Spec().Name)
+	}
+	return nil
+}
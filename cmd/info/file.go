@@ -0,0 +1,260 @@
+// file: cmd/info/file.go
+
+package info
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ha1tch/plus3/pkg/diskimg"
+)
+
+// FileDetail represents everything known about one file in a structured format
+type FileDetail struct {
+	Path       string          `json:"path"`
+	Name       string          `json:"name"`
+	User       int             `json:"user"`
+	Size       int64           `json:"size"`
+	Records    int             `json:"records"`
+	Attributes []string        `json:"attributes"`
+	Header     *HeaderDetail   `json:"header,omitempty"`
+	Extents    []ExtentDetail  `json:"extents"`
+	Blocks     []BlockLocation `json:"blocks"`
+}
+
+// HeaderDetail holds the fields of a file's PLUS3DOS header
+type HeaderDetail struct {
+	Type        string `json:"type"`
+	TypeCode    byte   `json:"type_code"`
+	Issue       byte   `json:"issue"`
+	Version     byte   `json:"version"`
+	FileLength  uint32 `json:"file_length"`
+	DataLength  uint16 `json:"data_length"`
+	Param1      uint16 `json:"param1"`
+	Param2      uint16 `json:"param2"`
+	Line        *int   `json:"line,omitempty"`
+	LoadAddress *int   `json:"load_address,omitempty"`
+	Checksum    byte   `json:"checksum"`
+	ChecksumOK  bool   `json:"checksum_ok"`
+	Expected    byte   `json:"expected_checksum"`
+}
+
+// ExtentDetail describes one directory entry of the file
+type ExtentDetail struct {
+	Entry     int   `json:"entry"`
+	Extent    int   `json:"extent"`
+	Records   int   `json:"records"`
+	LastBytes int   `json:"last_record_bytes"`
+	Blocks    []int `json:"blocks"`
+}
+
+// BlockLocation gives the physical sectors of one allocation block
+type BlockLocation struct {
+	Block   int              `json:"block"`
+	Sectors []SectorLocation `json:"sectors"`
+}
+
+// SectorLocation is a sector by track, side and sector ID
+type SectorLocation struct {
+	Track  int `json:"track"`
+	Side   int `json:"side"`
+	Sector int `json:"sector"`
+}
+
+// fileInfo displays everything known about one file on the disk
+func fileInfo(disk *diskimg.DiskImage, diskPath, name string, opts *InfoOptions) error {
+	fi, err := disk.StatFile(name)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	extents, err := disk.FileExtents(name)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+
+	detail := &FileDetail{
+		Path:       diskPath,
+		Name:       fi.Name,
+		User:       fi.User,
+		Size:       fi.Size,
+		Records:    fi.Records,
+		Attributes: attributeNames(fi.Attributes),
+		Extents:    []ExtentDetail{},
+		Blocks:     []BlockLocation{},
+	}
+	for _, e := range extents {
+		detail.Extents = append(detail.Extents, ExtentDetail{
+			Entry:     e.Entry,
+			Extent:    e.Number,
+			Records:   e.Records,
+			LastBytes: e.LastBytes,
+			Blocks:    e.Blocks,
+		})
+	}
+	spec := disk.Spec()
+	for _, b := range fi.Blocks {
+		loc := BlockLocation{Block: b}
+		for n := range spec.SectorsPerBlock() {
+			track, sector, side := spec.BlockSector(b, n)
+			loc.Sectors = append(loc.Sectors, SectorLocation{Track: track, Side: side, Sector: spec.FirstSectorID + sector})
+		}
+		detail.Blocks = append(detail.Blocks, loc)
+	}
+	if len(fi.Blocks) > 0 {
+		// Read the header from the first block rather than taking StatFile's,
+		// which leaves out a header with a bad checksum.
+		if data, err := disk.ReadBlock(fi.Blocks[0]); err == nil {
+			detail.Header = headerDetail(data)
+		}
+	}
+
+	if opts.JSON {
+		return outputJSON(detail)
+	}
+	outputFileText(detail)
+	return nil
+}
+
+// headerDetail returns the PLUS3DOS header at the start of data, or nil if
+// there is none
+func headerDetail(data []byte) *HeaderDetail {
+	var h diskimg.Plus3DosHeader
+	if err := h.FromBytes(data); err != nil || !bytes.Equal(h.Signature[:], []byte(diskimg.HeaderSignature)) {
+		return nil
+	}
+	fileType, length, param1, param2 := h.GetBasicHeader()
+	d := &HeaderDetail{
+		Type:       h.GetFileType(),
+		TypeCode:   fileType,
+		Issue:      h.Issue,
+		Version:    h.Version,
+		FileLength: h.FileLength,
+		DataLength: length,
+		Param1:     param1,
+		Param2:     param2,
+		Checksum:   h.Checksum,
+		ChecksumOK: !errors.Is(h.Validate(), diskimg.ErrInvalidChecksum),
+	}
+	switch fileType {
+	case diskimg.FileTypeProgram:
+		if param1 < 0x8000 {
+			line := int(param1)
+			d.Line = &line
+		}
+	case diskimg.FileTypeCode:
+		load := int(param1)
+		d.LoadAddress = &load
+	}
+	h.UpdateChecksum()
+	d.Expected = h.Checksum
+	return d
+}
+
+// attributeNames lists the attribute bits set on a file
+func attributeNames(a diskimg.FileAttributes) []string {
+	names := []string{}
+	for _, attr := range []struct {
+		set  bool
+		name string
+	}{
+		{a.ReadOnly, "read-only"}, {a.System, "system"}, {a.Archived, "archived"},
+		{a.UserF1, "f1"}, {a.UserF2, "f2"}, {a.UserF3, "f3"}, {a.UserF4, "f4"},
+	} {
+		if attr.set {
+			names = append(names, attr.name)
+		}
+	}
+	return names
+}
+
+// outputFileText writes the details of a file in human-readable format
+func outputFileText(d *FileDetail) {
+	fmt.Printf("Disk Image: %s\n\n", d.Path)
+	fmt.Printf("File:       %s\n", d.Name)
+	fmt.Printf("User:       %d\n", d.User)
+	fmt.Printf("Size:       %d bytes (%d records)\n", d.Size, d.Records)
+	if len(d.Attributes) > 0 {
+		fmt.Printf("Attributes: %s\n", strings.Join(d.Attributes, ", "))
+	} else {
+		fmt.Printf("Attributes: none\n")
+	}
+
+	if h := d.Header; h != nil {
+		fmt.Printf("\nPLUS3DOS Header:\n")
+		fmt.Printf("Type:       %s (%d)\n", h.Type, h.TypeCode)
+		fmt.Printf("Issue:      %d.%d\n", h.Issue, h.Version)
+		fmt.Printf("Length:     %d bytes, %d with the header\n", h.DataLength, h.FileLength)
+		fmt.Printf("Params:     %d, %d\n", h.Param1, h.Param2)
+		if h.Line != nil {
+			fmt.Printf("LINE:       %d\n", *h.Line)
+		}
+		if h.LoadAddress != nil {
+			fmt.Printf("Load Addr:  %d\n", *h.LoadAddress)
+		}
+		if h.ChecksumOK {
+			fmt.Printf("Checksum:   0x%02x (valid)\n", h.Checksum)
+		} else {
+			fmt.Printf("Checksum:   0x%02x (bad, should be 0x%02x)\n", h.Checksum, h.Expected)
+		}
+	} else {
+		fmt.Printf("Header:     none\n")
+	}
+
+	fmt.Printf("\nDirectory Entries:\n")
+	for _, e := range d.Extents {
+		fmt.Printf("- entry %d: extent %d, %d records", e.Entry, e.Extent, e.Records)
+		if e.LastBytes > 0 {
+			fmt.Printf(" (%d bytes in the last)", e.LastBytes)
+		}
+		fmt.Printf(", blocks %s\n", blockList(e.Blocks))
+	}
+
+	fmt.Printf("\nBlocks:\n")
+	for _, b := range d.Blocks {
+		fmt.Printf("- block %d: %s\n", b.Block, sectorRanges(b.Sectors))
+	}
+}
+
+// blockList formats block numbers, runs of consecutive blocks as ranges
+func blockList(blocks []int) string {
+	if len(blocks) == 0 {
+		return "none"
+	}
+	var parts []string
+	for i := 0; i < len(blocks); {
+		j := i
+		for j+1 < len(blocks) && blocks[j+1] == blocks[j]+1 {
+			j++
+		}
+		if j > i {
+			parts = append(parts, fmt.Sprintf("%d-%d", blocks[i], blocks[j]))
+		} else {
+			parts = append(parts, fmt.Sprint(blocks[i]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ", ")
+}
+
+// sectorRanges formats a block's sectors by track and side, with the range of
+// sector IDs on each
+func sectorRanges(sectors []SectorLocation) string {
+	var parts []string
+	for i := 0; i < len(sectors); {
+		j := i
+		for j+1 < len(sectors) && sectors[j+1].Track == sectors[i].Track && sectors[j+1].Side == sectors[i].Side &&
+			sectors[j+1].Sector == sectors[j].Sector+1 {
+			j++
+		}
+		s := sectors[i]
+		ids := fmt.Sprintf("sector %d", s.Sector)
+		if j > i {
+			ids = fmt.Sprintf("sectors %d-%d", s.Sector, sectors[j].Sector)
+		}
+		parts = append(parts, fmt.Sprintf("track %d side %d, %s", s.Track, s.Side, ids))
+		i = j + 1
+	}
+	return strings.Join(parts, "; ")
+}
@@ -6,30 +6,49 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/ha1tch/plus3/internal/stdio"
 	"github.com/ha1tch/plus3/pkg/diskimg"
 )
 
 // DiskInfo represents disk information in a structured format
 type DiskInfo struct {
-	Path       string    `json:"path"`
-	Format     string    `json:"format"`
-	Files      int       `json:"files"`
-	UsedSpace  int64     `json:"used_space"`
-	FreeSpace  int64     `json:"free_space"`
-	TotalSpace int64     `json:"total_space"`
-	Modified   time.Time `json:"modified_time,omitempty"`
-	Validation []string  `json:"validation_issues,omitempty"`
+	Path       string                    `json:"path"`
+	Format     string                    `json:"format"`
+	Label      string                    `json:"label,omitempty"`
+	Files      int                       `json:"files"`
+	UsedSpace  int64                     `json:"used_space"`
+	FreeSpace  int64                     `json:"free_space"`
+	TotalSpace int64                     `json:"total_space"`
+	Space      diskimg.SpaceInfo         `json:"space"`
+	Spec       diskimg.DiskSpecification `json:"specification"`
+	Datestamps string                    `json:"datestamps,omitempty"`
+	CPM        diskimg.CPMSystem         `json:"cpm"`
+	Modified   time.Time                 `json:"modified_time,omitempty"`
+	Validation []diskimg.Finding         `json:"validation_issues,omitempty"`
+	Concealed  []TrackConcealment        `json:"concealed_errors,omitempty"`
+}
+
+// TrackConcealment reports the errors concealed on one track by a salvage load
+type TrackConcealment struct {
+	Track        int `json:"track"`
+	Side         int `json:"side"`
+	BadSignature int `json:"bad_signature"`
+	ShortReads   int `json:"short_reads"`
 }
 
 // InfoOptions configures the information display
 type InfoOptions struct {
-	JSON        bool // Output in JSON format
-	Verbose     bool // Show additional details
-	Validate    bool // Perform disk validation
-	Quiet       bool // Suppress non-error output
-	ShowDeleted bool // Include information about deleted files
+	JSON        bool                    // Output in JSON format
+	Verbose     bool                    // Show additional details
+	Validate    bool                    // Perform disk validation
+	Level       diskimg.ValidationLevel // How deep validation goes: basic, strict or paranoid
+	Quiet       bool                    // Suppress non-error output
+	ShowDeleted bool                    // Include information about deleted files
+	Salvage     bool                    // Load a damaged image, concealing track errors
+	File        string                  // Show everything known about this file instead
 }
 
 // DefaultInfoOptions returns default options for Info
@@ -38,8 +57,11 @@ func DefaultInfoOptions() *InfoOptions {
 		JSON:        false,
 		Verbose:     false,
 		Validate:    true,
+		Level:       diskimg.ValidationStrict,
 		Quiet:       false,
 		ShowDeleted: false,
+		Salvage:     false,
+		File:        "",
 	}
 }
 
@@ -51,48 +73,65 @@ func Info(diskPath string, opts *InfoOptions) error {
 	}
 
 	// Validate disk exists
-	if _, err := os.Stat(diskPath); os.IsNotExist(err) {
-		return fmt.Errorf("disk image does not exist: %w", err)
+	if err := stdio.Exists(diskPath); err != nil {
+		return err
 	}
 
 	// Open disk image
-	disk, err := diskimg.LoadFromFile(diskPath)
+	disk, err := stdio.OpenDisk(diskPath, &diskimg.LoadOptions{Salvage: opts.Salvage})
 	if err != nil {
 		return fmt.Errorf("failed to open disk: %w", err)
 	}
+	defer disk.Close()
+	if opts.File != "" {
+		return fileInfo(disk, diskPath, opts.File, opts)
+	}
 
 	// Get disk information
+	spec := disk.Spec()
+	space := disk.SpaceInfo()
 	info := &DiskInfo{
 		Path:       diskPath,
 		Format:     "+3DOS",
-		TotalSpace: int64(diskimg.TracksPerSide * diskimg.SectorsPerTrack * diskimg.BytesPerSector),
+		Label:      disk.GetLabel(),
+		UsedSpace:  space.Used.Bytes,
+		FreeSpace:  space.Free.Bytes,
+		TotalSpace: space.Total.Bytes,
+		Space:      space,
+		Datestamps: disk.Datestamps(),
+		CPM:        disk.CPMSystem(),
 	}
-
-	// Get directory information
-	dir, err := disk.GetDirectory()
-	if err != nil {
-		return fmt.Errorf("failed to read directory: %w", err)
+	if info.Spec, err = disk.Specification(); err != nil {
+		return fmt.Errorf("failed to read disk specification: %w", err)
 	}
-
-	// Calculate file and space information
-	for _, entry := range dir {
-		if !entry.IsUnused() && entry.GetFilename() != "" {
-			info.Files++
-			info.UsedSpace += int64(entry.RecordCount) * 128 // Convert records to bytes
-		}
+	if spec.Name != diskimg.SpecPlus3.Name {
+		info.Format += " " + spec.Name
 	}
 
-	info.FreeSpace = info.TotalSpace - info.UsedSpace
+	// Get directory information
+	for range disk.Files(&diskimg.FilesOptions{System: true}) {
+		info.Files++
+	}
 
 	// Get file modification time
-	if stat, err := os.Stat(diskPath); err == nil {
+	if stat, err := os.Stat(diskPath); err == nil && !stdio.IsStd(diskPath) {
 		info.Modified = stat.ModTime()
 	}
 
 	// Perform validation if requested
 	if opts.Validate {
-		if err := disk.DiskCheck(); err != nil {
-			info.Validation = append(info.Validation, err.Error())
+		for _, f := range disk.CheckWithOptions(&diskimg.CheckOptions{Level: opts.Level}).Findings {
+			if f.Code != "track-concealed" { // listed under Concealed
+				info.Validation = append(info.Validation, f)
+			}
+		}
+		for _, tc := range disk.Concealments() {
+			info.Concealed = append(info.Concealed, TrackConcealment{
+				Track:        tc.Track,
+				Side:         tc.Side,
+				BadSignature: tc.BadSignature,
+				ShortReads:   tc.ShortReads,
+			})
 		}
 	}
 
@@ -100,24 +139,37 @@ func Info(diskPath string, opts *InfoOptions) error {
 	if opts.JSON {
 		return outputJSON(info)
 	}
-	return outputText(info, opts)
+	return outputText(info, spec, opts)
+}
+
+// hasProblems reports whether validation found any warning or error.
+func (info *DiskInfo) hasProblems() bool {
+	for _, f := range info.Validation {
+		if f.Severity != diskimg.SeverityInfo {
+			return true
+		}
+	}
+	return false
 }
 
 // outputJSON writes disk information in JSON format
-func outputJSON(info *DiskInfo) error {
+func outputJSON(info any) error {
 	encoder := json.NewEncoder(os.Stdout)
 	encoder.SetIndent("", "  ")
 	return encoder.Encode(info)
 }
 
 // outputText writes disk information in human-readable format
-func outputText(info *DiskInfo, opts *InfoOptions) error {
-	if opts.Quiet && len(info.Validation) == 0 {
+func outputText(info *DiskInfo, spec diskimg.DiskSpec, opts *InfoOptions) error {
+	if opts.Quiet && len(info.Concealed) == 0 && !info.hasProblems() {
 		return nil
 	}
 
 	fmt.Printf("Disk Image: %s\n\n", info.Path)
 	fmt.Printf("Format:     %s\n", info.Format)
+	if info.Label != "" {
+		fmt.Printf("Label:      %s\n", info.Label)
+	}
 	fmt.Printf("Files:      %d\n", info.Files)
 	fmt.Printf("Used:       %dK\n", info.UsedSpace/1024)
 	fmt.Printf("Free:       %dK\n", info.FreeSpace/1024)
@@ -126,21 +178,62 @@ func outputText(info *DiskInfo, opts *InfoOptions) error {
 	if !info.Modified.IsZero() {
 		fmt.Printf("Modified:   %s\n", info.Modified.Format(time.RFC1123))
 	}
+	switch info.Datestamps {
+	case diskimg.StampsCPM3:
+		fmt.Printf("Datestamps: CP/M Plus\n")
+	case diskimg.StampsDateStamper:
+		fmt.Printf("Datestamps: DateStamper\n")
+	}
+	if info.CPM.System {
+		fmt.Printf("CP/M:       Plus system disk, %d program(s)", len(info.CPM.COMFiles))
+		if len(info.CPM.EMSFiles) > 0 {
+			fmt.Printf(", system file %s", strings.Join(info.CPM.EMSFiles, ", "))
+		}
+		fmt.Println()
+	}
 
 	if opts.Verbose {
 		fmt.Printf("\nDisk Parameters:\n")
-		fmt.Printf("Tracks:     %d\n", diskimg.TracksPerSide)
-		fmt.Printf("Sectors:    %d per track\n", diskimg.SectorsPerTrack)
-		fmt.Printf("Sides:      %d\n", diskimg.SidesPerDisk)
-		fmt.Printf("Sector Size: %d bytes\n", diskimg.BytesPerSector)
+		fmt.Printf("Tracks:     %d\n", spec.TracksPerSide)
+		fmt.Printf("Sectors:    %d per track\n", spec.SectorsPerTrack)
+		fmt.Printf("Sides:      %d\n", spec.Sides)
+		fmt.Printf("Sector Size: %d bytes\n", spec.SectorSize)
+		fmt.Printf("Block Size: %d bytes\n", info.Space.BlockSize)
+		fmt.Printf("Reserved:   %dK (system tracks, %d bytes in use)\n", info.Space.Reserved.Bytes/1024, info.Space.System.Bytes)
+		fmt.Printf("Directory:  %dK (%d blocks, %d entries, %d free)\n", info.Space.Directory.Bytes/1024, info.Space.Directory.Blocks,
+			info.Space.Entries, info.Space.FreeEntries)
+		fmt.Printf("Used:       %d blocks\n", info.Space.Used.Blocks)
+		fmt.Printf("Free:       %d blocks\n", info.Space.Free.Blocks)
+		fmt.Printf("Gaps:       %#02x read/write, %#02x format\n", info.Spec.GapRW, info.Spec.GapFormat)
+		fmt.Printf("Bootable:   %s\n", yesNo(info.Spec.Bootable))
+		fmt.Printf("Spec Bytes: % X\n", info.Spec.Serialize())
+	}
+
+	if len(info.Concealed) > 0 {
+		fmt.Printf("\nConcealed errors (salvage load):\n")
+		total := 0
+		for _, tc := range info.Concealed {
+			fmt.Printf("- track %d side %d: %d bad signature, %d short reads\n",
+				tc.Track, tc.Side, tc.BadSignature, tc.ShortReads)
+			total += tc.BadSignature + tc.ShortReads
+		}
+		fmt.Printf("  %d error(s) concealed on %d track(s)\n", total, len(info.Concealed))
 	}
 
 	if len(info.Validation) > 0 {
-		fmt.Printf("\nWarnings:\n")
-		for _, warning := range info.Validation {
-			fmt.Printf("- %s\n", warning)
+		fmt.Printf("\nFindings:\n")
+		for _, f := range info.Validation {
+			fmt.Printf("- %s\n", f)
 		}
 	}
 
 	return nil
 }
+
+// yesNo formats a flag for the text output
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,119 @@
+// file: cmd/list/csv.go
+
+package list
+
+import (
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ha1tch/plus3/internal/stdio"
+)
+
+// csvHeader names the columns of the CSV catalogue
+var csvHeader = []string{
+	"disk", "name", "user", "size", "type", "header_type", "line", "load_address",
+	"data_length", "attributes", "sha1",
+}
+
+// ListCSV writes the catalogues of the disk images at diskPaths to w as CSV,
+// one row per file under a single header row. A ZIP archive without a named
+// image contributes every image in it. A disk that cannot be read is reported
+// on standard error and skipped, and counted in the error returned
+func ListCSV(diskPaths []string, w io.Writer, opts *ListOptions) error {
+	if opts == nil {
+		opts = DefaultListOptions()
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+
+	var paths []string
+	for _, p := range diskPaths {
+		if archive, member, ok := stdio.SplitZip(p); ok && member == "" {
+			images, err := stdio.ArchiveImages(archive)
+			if err == nil && len(images) != 1 {
+				for _, name := range images {
+					paths = append(paths, archive+":"+name)
+				}
+				continue
+			}
+		}
+		paths = append(paths, p)
+	}
+
+	failed := 0
+	for _, p := range paths {
+		rows, err := csvRows(p, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", p, err)
+			failed++
+			continue
+		}
+		if err := cw.WriteAll(rows); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d disk image(s) could not be read", failed, len(paths))
+	}
+	return nil
+}
+
+// csvRows returns the CSV rows of the files on one disk image
+func csvRows(diskPath string, opts *ListOptions) ([][]string, error) {
+	if err := stdio.Exists(diskPath); err != nil {
+		return nil, err
+	}
+	disk, err := stdio.OpenDisk(diskPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open disk: %w", err)
+	}
+	defer disk.Close()
+
+	long := *opts
+	long.Long = true
+	files, err := Files(disk, &long)
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]string
+	for _, f := range files {
+		row := []string{diskPath, f.Name, "", strconv.Itoa(f.Size), f.Type, "", "", "", "", strings.Join(f.Attributes, " "), ""}
+		if f.HeaderType != "-" {
+			row[5] = f.HeaderType
+		}
+		// A deleted file cannot be read; StatFile would find a live file of
+		// the same name.
+		if info, err := disk.StatFile(f.Name); err == nil && !slices.Contains(f.Attributes, "deleted") {
+			row[2] = strconv.Itoa(info.User)
+			if info.Line >= 0 {
+				row[6] = strconv.Itoa(info.Line)
+			}
+			if info.LoadAddress >= 0 {
+				row[7] = strconv.Itoa(info.LoadAddress)
+			}
+			if info.Headered() {
+				row[8] = strconv.Itoa(int(info.DataLength))
+			}
+			if data, err := fs.ReadFile(disk, f.Name); err == nil {
+				sum := sha1.Sum(data)
+				row[10] = hex.EncodeToString(sum[:])
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
@@ -3,6 +3,7 @@
 package list
 
 import (
+	"cmp"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -11,6 +12,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ha1tch/plus3/internal/stdio"
 	"github.com/ha1tch/plus3/pkg/diskimg"
 )
 
@@ -20,16 +22,28 @@ type FileEntry struct {
 	Size       int       `json:"size"`
 	Type       string    `json:"type"`
 	Attributes []string  `json:"attributes"`
+	Created    time.Time `json:"created,omitempty"`
 	Modified   time.Time `json:"modified,omitempty"`
+
+	// Long-listing details, filled in only with --long or --format basic.
+	Records      int    `json:"records,omitempty"`       // 128-byte records in the directory
+	HeaderType   string `json:"header_type,omitempty"`   // PLUS3DOS header file type
+	Param        string `json:"param,omitempty"`         // LINE, load address or array name
+	HeaderLength int    `json:"header_length,omitempty"` // data length the PLUS3DOS header gives
+
+	line, load int  // a program's LINE and a code file's load address, -1 if none
+	headered   bool // the file has a PLUS3DOS header
 }
 
 // Format defines the listing output format
 type Format int
 
 const (
-	FormatLS  Format = iota // Unix ls-style format
-	FormatCPM               // Traditional CPM format
-	FormatDOS               // DOS dir-style format
+	FormatLS    Format = iota // Unix ls-style format
+	FormatCPM                 // Traditional CPM format
+	FormatDOS                 // DOS dir-style format
+	FormatCSV                 // One CSV row per file, for spreadsheets
+	FormatBasic               // PLUS3DOS header details, as +3 BASIC sees each file
 )
 
 // ListOptions configures the directory listing
@@ -72,40 +86,44 @@ func List(diskPath string, opts *ListOptions) error {
 	opts.DiskPath = diskPath
 
 	// Validate disk exists
-	if _, err := os.Stat(diskPath); os.IsNotExist(err) {
-		return fmt.Errorf("disk image does not exist: %w", err)
+	if err := stdio.Exists(diskPath); err != nil {
+		return err
+	}
+
+	if opts.Format == FormatCSV && !opts.JSON {
+		return ListCSV([]string{diskPath}, os.Stdout, opts)
+	}
+
+	// An archive without a single disk image: list the images it holds.
+	if archive, member, ok := stdio.SplitZip(diskPath); ok && member == "" {
+		images, err := stdio.ArchiveImages(archive)
+		if err != nil {
+			return err
+		}
+		if len(images) != 1 {
+			return listArchive(archive, images, opts)
+		}
 	}
 
 	// Open disk image
-	disk, err := diskimg.LoadFromFile(diskPath)
+	disk, err := stdio.OpenDisk(diskPath, nil)
 	if err != nil {
 		return fmt.Errorf("failed to open disk: %w", err)
 	}
+	defer disk.Close()
 
-	// Get directory
-	dir, err := disk.GetDirectory()
+	files, err := Files(disk, opts)
 	if err != nil {
-		return fmt.Errorf("failed to read directory: %w", err)
+		return err
 	}
 
-	// Collect file entries
-	var files []FileEntry
-	for _, entry := range dir {
-		if shouldIncludeFile(&entry, opts) {
-			file := fileEntryFromDirEntry(&entry)
-			if matchesPattern(file.Name, opts.Pattern) {
-				files = append(files, file)
-			}
-		}
-	}
-
-	// Sort files
-	sortFiles(files, opts)
-
 	// Output listing
 	if opts.JSON {
 		return outputJSON(files)
 	}
+	if opts.Long {
+		return outputLong(files, opts)
+	}
 
 	switch opts.Format {
 	case FormatLS:
@@ -113,28 +131,40 @@ func List(diskPath string, opts *ListOptions) error {
 	case FormatCPM:
 		return outputCPM(files, opts)
 	case FormatDOS:
-		return outputDOS(files, opts)
+		return outputDOS(files, disk.SpaceInfo(), opts)
+	case FormatBasic:
+		return outputBasic(files, opts)
 	default:
 		return fmt.Errorf("unknown format specified")
 	}
 }
 
-func shouldIncludeFile(entry *diskimg.DirectoryEntry, opts *ListOptions) bool {
-	if entry.IsUnused() {
-		return false
-	}
-	if entry.IsDeleted() && !opts.ShowDeleted {
-		return false
+// Files returns the listing of a loaded disk: the files opts selects, in the
+// order it asks for, with the long-listing details if opts.Long is set or the
+// format is FormatBasic.
+func Files(disk *diskimg.DiskImage, opts *ListOptions) ([]FileEntry, error) {
+	if opts == nil {
+		opts = DefaultListOptions()
 	}
-
-	attrs := &diskimg.FileAttributes{}
-	attrs.ReadFromDirectoryEntry(entry)
-
-	if attrs.System && !opts.ShowSystem {
-		return false
+	var files []FileEntry
+	for entry := range disk.Files(&diskimg.FilesOptions{System: opts.ShowSystem, Deleted: opts.ShowDeleted}) {
+		file := fileEntryFromDirEntry(&entry)
+		// A deleted file cannot be opened; StatFile would find a live file of
+		// the same name.
+		if info, err := disk.StatFile(entry.GetFilename()); err == nil && !entry.IsDeleted() {
+			file.Size = int(info.Size)
+			file.Created, file.Modified = info.Stamps.Created, info.Stamps.Modified
+			if opts.Long || opts.Format == FormatBasic {
+				file.Records = info.Records
+				addLongDetails(info, &file)
+			}
+		}
+		if matchesPattern(file.Name, opts.Pattern) {
+			files = append(files, file)
+		}
 	}
-
-	return true
+	sortFiles(files, opts)
+	return files, nil
 }
 
 func fileEntryFromDirEntry(entry *diskimg.DirectoryEntry) FileEntry {
@@ -151,15 +181,59 @@ func fileEntryFromDirEntry(entry *diskimg.DirectoryEntry) FileEntry {
 	if attrs.Archived {
 		attrList = append(attrList, "archived")
 	}
+	if entry.IsDeleted() {
+		attrList = append(attrList, "deleted")
+	}
 
 	return FileEntry{
 		Name:       entry.GetFilename(),
-		Size:       int(entry.RecordCount) * 128, // Convert records to bytes
 		Type:       determineFileType(entry),
 		Attributes: attrList,
+		line:       -1,
+		load:       -1,
+	}
+}
+
+// addLongDetails fills in the long-listing fields of file: for a headered
+// file, the PLUS3DOS header type and its LINE, load address or array variable,
+// and for a CP/M program, CP/M.
+func addLongDetails(info diskimg.FileInfo, file *FileEntry) {
+	file.HeaderType = "-"
+	file.Param = "-"
+	file.line, file.load = info.Line, info.LoadAddress
+	if strings.HasSuffix(info.Name, ".COM") {
+		file.HeaderType = "CP/M" // a program, never headered
+	}
+	if !info.Headered() {
+		return
+	}
+	file.HeaderLength, file.headered = int(info.DataLength), true
+	switch info.HeaderType {
+	case diskimg.FileTypeProgram:
+		file.HeaderType = "Program"
+		if info.Line >= 0 {
+			file.Param = fmt.Sprintf("LINE %d", info.Line)
+		}
+	case diskimg.FileTypeNumericArray:
+		_, _, name, _ := info.Header.GetBasicHeader()
+		file.HeaderType = "Num array"
+		file.Param = fmt.Sprintf("DIM %c()", arrayName(name))
+	case diskimg.FileTypeCharArray:
+		_, _, name, _ := info.Header.GetBasicHeader()
+		file.HeaderType = "Char array"
+		file.Param = fmt.Sprintf("DIM %c$()", arrayName(name))
+	case diskimg.FileTypeCode:
+		file.HeaderType = "Code"
+		file.Param = fmt.Sprintf("%d", info.LoadAddress)
 	}
 }
 
+// arrayName extracts the variable letter from an array header's name byte,
+// whose low five bits give the letter (1 = a).
+func arrayName(param uint16) rune {
+	return rune('a' + int(param&0x1F) - 1)
+}
+
 func determineFileType(entry *diskimg.DirectoryEntry) string {
 	ext := strings.ToUpper(filepath.Ext(entry.GetFilename()))
 	switch ext {
@@ -201,6 +275,24 @@ func sortFiles(files []FileEntry, opts *ListOptions) {
 	sort.Slice(files, less)
 }
 
+// listArchive prints the disk images inside a ZIP archive.
+func listArchive(archive string, images []string, opts *ListOptions) error {
+	if opts.JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(images)
+	}
+	fmt.Printf("\n Disk images in %s\n\n", archive)
+	for _, name := range images {
+		fmt.Printf("  %s\n", name)
+	}
+	fmt.Printf("\n    %d image(s)\n", len(images))
+	if len(images) > 0 {
+		fmt.Printf("\nUse \"plus3 list %s IMAGE\" or %s:IMAGE as the disk image path.\n", archive, archive)
+	}
+	return nil
+}
+
 func outputJSON(files []FileEntry) error {
 	encoder := json.NewEncoder(os.Stdout)
 	encoder.SetIndent("", "  ")
@@ -218,6 +310,85 @@ func outputLS(files []FileEntry, opts *ListOptions) error {
 	return nil
 }
 
+// outputLong writes one aligned row per file with the header type, LINE or
+// load address, record count and attribute flags. A Modified column is added
+// only when at least one file carries a datestamp.
+func outputLong(files []FileEntry, opts *ListOptions) error {
+	if len(files) == 0 {
+		if !opts.Quiet {
+			fmt.Println("No files found")
+		}
+		return nil
+	}
+
+	dated := false
+	for _, f := range files {
+		if !f.Modified.IsZero() {
+			dated = true
+			break
+		}
+	}
+
+	w := os.Stdout
+	header := fmt.Sprintf("%-12s  %-10s  %-10s  %8s  %4s  %-3s", "Name", "Type", "Line/Addr", "Bytes", "Recs", "Att")
+	if dated {
+		header += "  Modified"
+	}
+	fmt.Fprintln(w, header)
+	for _, f := range files {
+		row := fmt.Sprintf("%-12s  %-10s  %-10s  %8d  %4d  %-3s",
+			f.Name, f.HeaderType, f.Param, f.Size, f.Records, attributeFlags(f.Attributes))
+		if dated && !f.Modified.IsZero() {
+			row += "  " + f.Modified.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintln(w, row)
+	}
+	return nil
+}
+
+// outputBasic writes one aligned row per file with what its PLUS3DOS header
+// says, as +3 BASIC loads it: the type, LINE, load address and data length,
+// beside the size the directory gives. '-' marks what a file does not have.
+func outputBasic(files []FileEntry, opts *ListOptions) error {
+	if len(files) == 0 {
+		if !opts.Quiet {
+			fmt.Println("No files found")
+		}
+		return nil
+	}
+	dash := func(n int, ok bool) string {
+		if !ok {
+			return "-"
+		}
+		return fmt.Sprintf("%d", n)
+	}
+	w := os.Stdout
+	fmt.Fprintf(w, "%-12s  %-10s  %5s  %5s  %7s  %8s\n", "Name", "Type", "LINE", "Addr", "Hdr len", "Dir size")
+	for _, f := range files {
+		fmt.Fprintf(w, "%-12s  %-10s  %5s  %5s  %7s  %8d\n",
+			f.Name, cmp.Or(f.HeaderType, "-"), dash(f.line, f.line >= 0), dash(f.load, f.load >= 0),
+			dash(f.HeaderLength, f.headered), f.Records*128)
+	}
+	return nil
+}
+
+// attributeFlags renders the attribute list as fixed-position flags: R
+// (read-only), S (system) and A (archived), with '-' for a clear attribute.
+func attributeFlags(attrs []string) string {
+	flags := []byte("---")
+	for _, a := range attrs {
+		switch a {
+		case "read-only":
+			flags[0] = 'R'
+		case "system":
+			flags[1] = 'S'
+		case "archived":
+			flags[2] = 'A'
+		}
+	}
+	return string(flags)
+}
+
 func outputCPM(files []FileEntry, opts *ListOptions) error {
 	if len(files) == 0 {
 		if !opts.Quiet {
@@ -249,7 +420,7 @@ func outputCPM(files []FileEntry, opts *ListOptions) error {
 	return nil
 }
 
-func outputDOS(files []FileEntry, opts *ListOptions) error {
+func outputDOS(files []FileEntry, space diskimg.SpaceInfo, opts *ListOptions) error {
 	if len(files) == 0 {
 		if !opts.Quiet {
 			fmt.Printf(" Directory of %s\n\n", opts.DiskPath)
@@ -311,7 +482,7 @@ func outputDOS(files []FileEntry, opts *ListOptions) error {
 		totalFiles, formatWithCommas(int(totalBytes)))
 	if !opts.ShowSystem {
 		fmt.Printf("                %14s bytes free\n",
-			formatWithCommas(180*1024-int(totalBytes)))
+			formatWithCommas(int(space.Free.Bytes)))
 	}
 
 	return nil
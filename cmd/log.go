@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ha1tch/plus3/pkg/diskimg"
+)
+
+// logFlags removes the global --verbose and --debug flags from args,
+// wherever they appear before a "--", and returns the log level they select:
+// warnings only by default, Info with --verbose, Debug with --debug. The
+// hidden __complete command gets its words untouched.
+func logFlags(args []string) ([]string, slog.Level) {
+	level := slog.LevelWarn
+	if len(args) > 0 && args[0] == "__complete" {
+		return args, level
+	}
+	rest := make([]string, 0, len(args))
+	for i, arg := range args {
+		switch arg {
+		case "--verbose", "-verbose":
+			level = min(level, slog.LevelInfo)
+			continue
+		case "--debug", "-debug":
+			level = slog.LevelDebug
+			continue
+		case "--":
+			return append(rest, args[i:]...), level
+		}
+		rest = append(rest, arg)
+	}
+	return rest, level
+}
+
+// setupLogging sends the CLI's log, and the library's operation traces, to w
+// at the given level.
+func setupLogging(w io.Writer, level slog.Level) {
+	slog.SetDefault(slog.New(&logHandler{w: w, level: level, mu: new(sync.Mutex)}))
+	diskimg.SetLogger(slog.Default())
+}
+
+// logHandler writes log records as plain lines: warnings and errors with the
+// same prefixes as the CLI's other messages, attributes as key=value.
+type logHandler struct {
+	w     io.Writer
+	level slog.Level
+	attrs []slog.Attr
+	mu    *sync.Mutex
+}
+
+func (h *logHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *logHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	switch {
+	case r.Level >= slog.LevelError:
+		b.WriteString("Error: ")
+	case r.Level >= slog.LevelWarn:
+		b.WriteString("Warning: ")
+	case r.Level < slog.LevelInfo:
+		b.WriteString("debug: ")
+	}
+	b.WriteString(r.Message)
+	attr := func(a slog.Attr) bool {
+		v := a.Value.Resolve().String()
+		if v == "" || strings.ContainsAny(v, " \"=") {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(&b, " %s=%s", a.Key, v)
+		return true
+	}
+	for _, a := range h.attrs {
+		attr(a)
+	}
+	r.Attrs(attr)
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = append(slices.Clip(h.attrs), attrs...)
+	return &c
+}
+
+// WithGroup ignores the group: the CLI's records are flat.
+func (h *logHandler) WithGroup(string) slog.Handler {
+	return h
+}
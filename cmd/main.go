@@ -4,27 +4,53 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
+	"strconv"
 
 	"github.com/ha1tch/plus3/cmd/add"
+	"github.com/ha1tch/plus3/cmd/batch"
+	"github.com/ha1tch/plus3/cmd/bootflag"
+	"github.com/ha1tch/plus3/cmd/cat"
+	"github.com/ha1tch/plus3/cmd/completion"
+	"github.com/ha1tch/plus3/cmd/convert"
+	"github.com/ha1tch/plus3/cmd/copy"
 	"github.com/ha1tch/plus3/cmd/create"
+	"github.com/ha1tch/plus3/cmd/daemon"
 	"github.com/ha1tch/plus3/cmd/delete"
+	"github.com/ha1tch/plus3/cmd/diskset"
+	"github.com/ha1tch/plus3/cmd/exportjson"
 	"github.com/ha1tch/plus3/cmd/extract"
+	"github.com/ha1tch/plus3/cmd/header"
+	"github.com/ha1tch/plus3/cmd/importjson"
 	"github.com/ha1tch/plus3/cmd/info"
 	"github.com/ha1tch/plus3/cmd/list"
+	"github.com/ha1tch/plus3/cmd/makeboot"
+	"github.com/ha1tch/plus3/cmd/partitions"
+	"github.com/ha1tch/plus3/cmd/pipeline"
+	"github.com/ha1tch/plus3/cmd/repair"
+	"github.com/ha1tch/plus3/cmd/servedav"
+	"github.com/ha1tch/plus3/cmd/undo"
+	"github.com/ha1tch/plus3/cmd/web"
+	"github.com/ha1tch/plus3/internal/stdio"
 	"github.com/ha1tch/plus3/internal/version"
+	"github.com/ha1tch/plus3/pkg/diskimg"
 )
 
 func main() {
-	if len(os.Args) < 2 {
+	all, level := logFlags(os.Args[1:])
+	setupLogging(os.Stderr, level)
+	if len(all) < 1 {
 		usage()
 		os.Exit(0)
 	}
 
-	cmd := os.Args[1]
-	args := os.Args[2:]
+	cmd := all[0]
+	args := all[1:]
 
 	switch cmd {
 	case "-h", "--help", "help":
@@ -35,30 +61,95 @@ func main() {
 		return
 	}
 
-	var err error
+	err := runCommand(cmd, args)
+	if errors.Is(err, errUnknownCommand) {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", cmd)
+		usage()
+		os.Exit(exitUsage)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if h := hint(err); h != "" {
+			fmt.Fprintf(os.Stderr, "Hint: %s\n", h)
+		}
+		os.Exit(exitCode(err))
+	}
+}
+
+// errUnknownCommand is returned by runCommand for a command it does not know.
+var errUnknownCommand = errors.New("unknown command")
+
+// sessionCommands are the commands that cannot be run by batch or shell: they
+// run on until stopped, work on their own, or undo a saved change.
+var sessionCommands = map[string]bool{
+	"batch": true, "shell": true, "undo": true, "partitions": true, "pipeline": true,
+	"serve-dav": true, "web": true, "daemon": true, "completion": true, "__complete": true,
+}
+
+// runCommand runs one command with its arguments.
+func runCommand(cmd string, args []string) error {
+	if stdio.InSession() && sessionCommands[cmd] {
+		return usageError{fmt.Errorf("%s cannot be run by batch or shell", cmd)}
+	}
 	switch cmd {
+	case "batch":
+		return runBatch(args)
+	case "shell":
+		return runShell(args)
 	case "create":
-		err = runCreate(args)
+		return runCreate(args)
 	case "add":
-		err = runAdd(args)
+		return runAdd(args)
 	case "delete":
-		err = runDelete(args)
+		return runDelete(args)
 	case "extract":
-		err = runExtract(args)
+		return runExtract(args)
+	case "cat":
+		return runCat(args)
+	case "header":
+		return runHeader(args)
 	case "list":
-		err = runList(args)
+		return runList(args)
 	case "info":
-		err = runInfo(args)
-	default:
-		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", cmd)
-		usage()
-		os.Exit(1)
+		return runInfo(args)
+	case "export-json":
+		return runExportJSON(args)
+	case "import-json":
+		return runImportJSON(args)
+	case "copy":
+		return runCopy(args)
+	case "merge":
+		return runMerge(args)
+	case "undo":
+		return runUndo(args)
+	case "convert":
+		return runConvert(args)
+	case "makeboot":
+		return runMakeBoot(args)
+	case "bootflag":
+		return runBootflag(args)
+	case "repair":
+		return runRepair(args)
+	case "partitions":
+		return runPartitions(args)
+	case "pipeline":
+		return runPipeline(args)
+	case "set":
+		return runSet(args)
+	case "serve-dav":
+		return runServeDav(args)
+	case "web":
+		return runWeb(args)
+	case "daemon":
+		return runDaemon(args)
+	case "completion":
+		return runCompletion(args)
+	case "__complete":
+		// Hidden: called by the completion scripts.
+		return completion.Complete(args, os.Stdout)
 	}
+	return errUnknownCommand
 
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
-	}
 }
 
 func usage() {
@@ -69,24 +160,60 @@ Usage:
 
 Commands:
   create   [flags] <disk.dsk>            Create a new +3DOS disk image
-  add      [flags] <disk.dsk> <file>     Add a file to a disk image
+  add      [flags] <disk.dsk> <file...>  Add files to a disk image
   list     [flags] <disk.dsk>            List the contents of a disk image
+  list     [flags] <archive.zip> [image] List a disk image inside a ZIP archive
   info     [flags] <disk.dsk>            Display information about a disk image
+  export-json [flags] <disk.dsk>         Dump the whole structure of a disk image as JSON
+  import-json [flags] <disk.json> <out>  Rebuild a disk image from its JSON dump
   extract  [flags] <disk.dsk> <name>     Extract a file from a disk image
+  extract  --all [flags] <disk.dsk>      Extract every file from a disk image
+  cat      [flags] <disk.dsk> <name>     Print a file, or list a BASIC program
+  header   [flags] <disk.dsk> <name>     Show or edit a file's +3DOS header
   delete   [flags] <disk.dsk> <name>     Delete a file from a disk image
+  copy     [flags] <from.dsk> <to.dsk> <name>
+                                         Copy a file from one disk image to another
+  merge    [flags] <from.dsk> <to.dsk>   Copy every file of one disk image into another
+  undo     [flags] <disk.dsk>            Revert the last journaled change to a disk image
+  convert  [flags] <in> <out>            Convert between .dsk, .img, .hfe, .trd, .scl, .tzx and .tap,
+                                         a disk or tape to .wav audio, or a .z80 or .sna snapshot to a disk
+  makeboot [flags] <disk.dsk>            Create a disk that loads and runs code by itself
+  bootflag [--on|--off] <disk.dsk>       Show or set whether a disk's boot sector is bootable
+  repair   [flags] <disk.dsk>            Fix what the disk check found
+  partitions [flags] <image.hdf>         List the partitions of a +3e hard disk image
+  pipeline run [flags] <pipeline.yaml> <disk.dsk...>
+                                         Run a named pipeline over disk images
+  set list [flags] <disk.dsk...>         List the files of a multi-disk set
+  set add [flags] <file> <disk.dsk...>   Add a file to a set, split across disks if needed
+  set extract [flags] <name> <disk.dsk...>
+                                         Extract a file from a set, joining its parts
+  batch    [flags] [script]              Run several commands, saving each disk image once
+  shell    [flags]                       Run commands typed one at a time, saving each disk image once
+  serve-dav [flags] <disk.dsk>           Serve a disk image over WebDAV
+  web      [flags] <directory>           Browse a collection of disk images in a web browser
+  daemon   [flags]                       Serve a JSON API for creating and editing disk images
+  completion <bash|zsh|fish>             Print a shell completion script
 
 Other:
   plus3 --version                        Show the version
   plus3 <command> -h                     Show flags for a command
 
+Global flags, accepted anywhere on the command line:
+  --verbose                              Log what is being done to standard error
+  --debug                                Also log the library's detailed traces
+
 Run "plus3 <command> -h" for the flags accepted by each command.
 `, version.Version)
 }
 
+// flagErrorHandling is how a command's FlagSet handles a parse error: batch
+// and shell run many commands, and must go on after one.
+var flagErrorHandling = flag.ExitOnError
+
 // newFlagSet builds a FlagSet that, on -h or a parse error, prints a one-line
 // usage for the subcommand followed by its flag defaults.
 func newFlagSet(name, argSpec string) *flag.FlagSet {
-	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs := flag.NewFlagSet(name, flagErrorHandling)
 	fs.Usage = func() {
 		fmt.Fprintf(fs.Output(), "Usage: plus3 %s [flags] %s\n\nFlags:\n", name, argSpec)
 		fs.PrintDefaults()
@@ -123,16 +250,34 @@ func parseInterleaved(fs *flag.FlagSet, args []string) error {
 func requireArgs(fs *flag.FlagSet, n int) error {
 	if fs.NArg() != n {
 		fs.Usage()
-		return fmt.Errorf("expected %d argument(s), got %d", n, fs.NArg())
+		return usageError{fmt.Errorf("expected %d argument(s), got %d", n, fs.NArg())}
 	}
 	return nil
 }
 
+// isFlagSet reports whether the flag was given on the command line.
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
 func runCreate(args []string) error {
 	opts := create.DefaultCreateOptions()
+	format, container, spec := "3dos", "standard", ""
 	fs := newFlagSet("create", "<disk.dsk>")
+	fs.StringVar(&format, "format", format, "Disk format (3dos, 720k, cpc-data, cpc-system, pcw180, pcw720)")
+	fs.StringVar(&spec, "spec", spec, "Custom geometry: tracks,sides,sectors,size[,reserved,block,dirblocks[,gaprw,gapformat]]")
+	fs.IntVar(&opts.Interleave, "interleave", opts.Interleave, "Sector interleave: positions between consecutive sector IDs (0 = sequential)")
+	fs.IntVar(&opts.Skew, "skew", opts.Skew, "Sector skew: positions the first sector moves on per track")
+	fs.StringVar(&container, "container", container, "DSK container (standard, extended)")
 	fs.StringVar(&opts.Label, "label", opts.Label, "Disk label (max 11 characters)")
 	fs.BoolVar(&opts.Boot, "boot", opts.Boot, "Create a bootable disk")
+	fs.BoolVar(&opts.Datestamps, "datestamps", opts.Datestamps, "Stamp files with CP/M Plus creation and update dates")
 	fs.BoolVar(&opts.Force, "force", opts.Force, "Overwrite existing files")
 	fs.BoolVar(&opts.Quiet, "quiet", opts.Quiet, "Suppress non-error output")
 	if err := parseInterleaved(fs, args); err != nil {
@@ -141,25 +286,65 @@ func runCreate(args []string) error {
 	if err := requireArgs(fs, 1); err != nil {
 		return err
 	}
+	var err error
+	if opts.Format, err = create.ParseFormat(format); err != nil {
+		return usageError{err}
+	}
+	if spec != "" {
+		if isFlagSet(fs, "format") {
+			return usageError{fmt.Errorf("--spec and --format cannot be combined")}
+		}
+		s, err := diskimg.ParseDiskSpec(spec)
+		if err != nil {
+			return usageError{err}
+		}
+		opts.Spec = &s
+	}
+	switch container {
+	case "standard", "dsk":
+		opts.Container = diskimg.ContainerStandard
+	case "extended", "edsk":
+		opts.Container = diskimg.ContainerExtended
+	default:
+		return usageError{fmt.Errorf("unknown container %q", container)}
+	}
 	return create.Create(fs.Arg(0), opts)
 }
 
 func runAdd(args []string) error {
 	opts := add.DefaultAddOptions()
-	var ftype string
-	fs := newFlagSet("add", "<disk.dsk> <file>")
+	var ftype, dither, clash string
+	var tokenize bool
+	fs := newFlagSet("add", "<disk.dsk> <file...>")
 	// -t and --type are equivalent.
-	fs.StringVar(&ftype, "type", "auto", "File type (basic, basictext, code, screen, raw, auto)")
+	fs.StringVar(&ftype, "type", "auto", "File type (basic, basictext, code, screen, image, array, text, hobeta, amsdos, raw, tap, auto)")
 	fs.StringVar(&ftype, "t", "auto", "File type (shorthand for --type)")
+	fs.BoolVar(&tokenize, "tokenize", false, "Tokenise a plain-text BASIC listing (same as --type basictext)")
 	fs.Func("line", "Line number for BASIC programs", uint16Flag(&opts.Line))
 	fs.Func("load-addr", "Load address for CODE files", uint16Flag(&opts.LoadAddr))
+	fs.StringVar(&dither, "dither", "none", "Dithering for a converted picture (none, ordered, diffusion)")
+	fs.StringVar(&clash, "clash", "best", "How a converted picture's cell colours are chosen (best, popular)")
+	fs.StringVar(&opts.Var, "var", opts.Var, "Array variable for --type array, as a or a$")
+	fs.BoolVar(&opts.CR, "cr", opts.CR, "End lines with CR rather than CRLF for --type text")
+	fs.BoolVar(&opts.Charset, "charset", opts.Charset, "Map UTF-8 to the Spectrum character set for --type text")
 	fs.BoolVar(&opts.Force, "force", opts.Force, "Overwrite existing files")
 	fs.BoolVar(&opts.Quiet, "quiet", opts.Quiet, "Suppress non-error output")
+	fs.BoolVar(&opts.Fidelity, "fidelity", opts.Fidelity, "Keep copy-protection FDC status of rewritten sectors")
+	fs.BoolVar(&opts.Backup, "backup", opts.Backup, "Keep the previous image as <disk>.bak")
+	fs.BoolVar(&opts.Journal, "journal", opts.Journal, "Record the change in <disk>.journal for undo")
+	fs.BoolVar(&opts.Sanitise, "sanitise", opts.Sanitise, "Make file names that are not valid CP/M names valid rather than fail")
 	if err := parseInterleaved(fs, args); err != nil {
 		return err
 	}
-	if err := requireArgs(fs, 2); err != nil {
-		return err
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return usageError{fmt.Errorf("expected a disk image and at least one file, got %d argument(s)", fs.NArg())}
+	}
+	if tokenize {
+		if ftype != "auto" && ftype != "basictext" && ftype != "basic-text" {
+			return usageError{fmt.Errorf("--tokenize cannot be combined with --type %s", ftype)}
+		}
+		ftype = "basictext"
 	}
 	switch ftype {
 	case "basic":
@@ -172,9 +357,45 @@ func runAdd(args []string) error {
 		opts.FileType = add.TypeScreen
 	case "raw":
 		opts.FileType = add.TypeRaw
+	case "tap", "tape":
+		opts.FileType = add.TypeTape
+	case "image":
+		opts.FileType = add.TypeImage
+	case "array":
+		opts.FileType = add.TypeArray
+		if opts.Var == "" {
+			return usageError{fmt.Errorf("--type array needs --var, as a or a$")}
+		}
+	case "text":
+		opts.FileType = add.TypeText
+	case "hobeta":
+		opts.FileType = add.TypeHobeta
+	case "amsdos":
+		opts.FileType = add.TypeAmsdos
 	default:
 		opts.FileType = add.TypeAuto
 	}
+	switch dither {
+	case "none":
+		opts.Dither = diskimg.DitherNone
+	case "ordered":
+		opts.Dither = diskimg.DitherOrdered
+	case "diffusion":
+		opts.Dither = diskimg.DitherDiffusion
+	default:
+		return usageError{fmt.Errorf("unknown dither %q", dither)}
+	}
+	switch clash {
+	case "best":
+		opts.Clash = diskimg.ClashBestPair
+	case "popular":
+		opts.Clash = diskimg.ClashPopular
+	default:
+		return usageError{fmt.Errorf("unknown clash strategy %q", clash)}
+	}
+	if fs.NArg() > 2 {
+		return add.AddFiles(fs.Arg(0), fs.Args()[1:], opts)
+	}
 	return add.Add(fs.Arg(0), fs.Arg(1), opts)
 }
 
@@ -184,6 +405,10 @@ func runDelete(args []string) error {
 	fs.BoolVar(&opts.Force, "force", opts.Force, "Skip confirmation")
 	fs.BoolVar(&opts.Quiet, "quiet", opts.Quiet, "Suppress non-error output")
 	fs.BoolVar(&opts.NoRecycle, "no-recycle", opts.NoRecycle, "Don't preserve deleted file info")
+	fs.BoolVar(&opts.Scrub, "scrub", opts.Scrub, "Also fill the file's blocks with 0xE5, leaving none of its data on the disk")
+	fs.BoolVar(&opts.Fidelity, "fidelity", opts.Fidelity, "Keep copy-protection FDC status of rewritten sectors")
+	fs.BoolVar(&opts.Backup, "backup", opts.Backup, "Keep the previous image as <disk>.bak")
+	fs.BoolVar(&opts.Journal, "journal", opts.Journal, "Record the change in <disk>.journal for undo")
 	if err := parseInterleaved(fs, args); err != nil {
 		return err
 	}
@@ -193,9 +418,114 @@ func runDelete(args []string) error {
 	return delete.Delete(fs.Arg(0), fs.Arg(1), opts)
 }
 
+func runCopy(args []string) error {
+	opts := copy.DefaultCopyOptions()
+	fs := newFlagSet("copy", "<from.dsk> <to.dsk> <name>")
+	fs.StringVar(&opts.NewName, "as", opts.NewName, "Name of the copy (default: the same name)")
+	fs.BoolVar(&opts.Force, "force", opts.Force, "Replace a file of that name on the destination")
+	fs.BoolVar(&opts.Quiet, "quiet", opts.Quiet, "Suppress non-error output")
+	fs.BoolVar(&opts.Backup, "backup", opts.Backup, "Keep the previous destination image as <disk>.bak")
+	fs.BoolVar(&opts.Journal, "journal", opts.Journal, "Record the change in the destination's journal for undo")
+	if err := parseInterleaved(fs, args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs, 3); err != nil {
+		return err
+	}
+	return copy.Copy(fs.Arg(0), fs.Arg(1), fs.Arg(2), opts)
+}
+
+func runMerge(args []string) error {
+	opts := copy.DefaultMergeOptions()
+	fs := newFlagSet("merge", "<from.dsk> <to.dsk>")
+	fs.BoolVar(&opts.Force, "force", opts.Force, "Replace files of the same name on the destination")
+	fs.BoolVar(&opts.Quiet, "quiet", opts.Quiet, "Suppress non-error output")
+	fs.BoolVar(&opts.Backup, "backup", opts.Backup, "Keep the previous destination image as <disk>.bak")
+	fs.BoolVar(&opts.Journal, "journal", opts.Journal, "Record the change in the destination's journal for undo")
+	if err := parseInterleaved(fs, args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs, 2); err != nil {
+		return err
+	}
+	return copy.Merge(fs.Arg(0), fs.Arg(1), opts)
+}
+
+func runUndo(args []string) error {
+	opts := undo.DefaultUndoOptions()
+	fs := newFlagSet("undo", "<disk.dsk>")
+	fs.BoolVar(&opts.Quiet, "quiet", opts.Quiet, "Suppress non-error output")
+	fs.BoolVar(&opts.Backup, "backup", opts.Backup, "Keep the previous image as <disk>.bak")
+	if err := parseInterleaved(fs, args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	return undo.Undo(fs.Arg(0), opts)
+}
+
+func runConvert(args []string) error {
+	opts := convert.DefaultConvertOptions()
+	var container string
+	fs := newFlagSet("convert", "<in> <out>")
+	fs.StringVar(&container, "container", "", "DSK container for .dsk output (standard, extended; default keeps the input's)")
+	fs.BoolVar(&opts.Force, "force", opts.Force, "Overwrite existing files")
+	fs.BoolVar(&opts.Quiet, "quiet", opts.Quiet, "Suppress non-error output")
+	if err := parseInterleaved(fs, args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs, 2); err != nil {
+		return err
+	}
+	switch container {
+	case "":
+	case "standard", "dsk":
+		c := diskimg.ContainerStandard
+		opts.Container = &c
+	case "extended", "edsk":
+		c := diskimg.ContainerExtended
+		opts.Container = &c
+	default:
+		return usageError{fmt.Errorf("unknown container %q", container)}
+	}
+	return convert.Convert(fs.Arg(0), fs.Arg(1), opts)
+}
+
+func runMakeBoot(args []string) error {
+	opts := makeboot.DefaultMakeBootOptions()
+	fs := newFlagSet("makeboot", "<disk.dsk>")
+	fs.StringVar(&opts.Screen, "screen", opts.Screen, "SCREEN$ file to show while the code loads")
+	fs.Func("code", "Code file and load address, as main.bin,32768 (repeatable)", func(s string) error {
+		c, err := makeboot.ParseCodeFile(s)
+		if err != nil {
+			return err
+		}
+		opts.Code = append(opts.Code, c)
+		return nil
+	})
+	fs.Func("exec", "Address to call (default: the first code file's load address)", uint16Flag(&opts.Exec))
+	fs.BoolVar(&opts.Force, "force", opts.Force, "Overwrite existing files")
+	fs.BoolVar(&opts.Quiet, "quiet", opts.Quiet, "Suppress non-error output")
+	if err := parseInterleaved(fs, args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	if len(opts.Code) == 0 {
+		fs.Usage()
+		return usageError{fmt.Errorf("--code is required")}
+	}
+	return makeboot.MakeBoot(fs.Arg(0), opts)
+}
+
 func runExtract(args []string) error {
 	opts := extract.DefaultExtractOptions()
+	var all bool
 	fs := newFlagSet("extract", "<disk.dsk> <name>")
+	fs.BoolVar(&all, "all", false, "Extract every file on the disk (then give no name)")
+	fs.IntVar(&opts.Jobs, "jobs", opts.Jobs, "Files extracted at once with --all")
 	fs.BoolVar(&opts.StripHeader, "strip-header", opts.StripHeader, "Remove +3DOS header if present")
 	// -o and --output-dir are equivalent.
 	fs.StringVar(&opts.OutputDir, "output-dir", opts.OutputDir, "Directory to extract files to")
@@ -203,19 +533,124 @@ func runExtract(args []string) error {
 	fs.BoolVar(&opts.Overwrite, "overwrite", opts.Overwrite, "Allow overwriting existing files")
 	fs.BoolVar(&opts.Quiet, "quiet", opts.Quiet, "Suppress non-error output")
 	fs.BoolVar(&opts.Basic, "basic", opts.Basic, "Detokenise a BASIC program to readable text (stdout, or <name>.txt with -o)")
+	fs.BoolVar(&opts.Basic, "as-text", opts.Basic, "Detokenise a BASIC program to readable text (same as --basic)")
+	fs.StringVar(&opts.PNG, "png", opts.PNG, "Render a SCREEN$ to this PNG file")
+	fs.StringVar(&opts.GIF, "gif", opts.GIF, "Render a SCREEN$ to this GIF file, animating FLASH")
+	fs.IntVar(&opts.Scale, "scale", opts.Scale, "Pixel scale for --png or --gif, such as 2 for 512x384")
+	fs.StringVar(&opts.Array, "array", opts.Array, "Convert an array to csv or json (stdout, or <name>.<format> with -o)")
+	fs.BoolVar(&opts.Text, "text", opts.Text, "Convert a text file's line endings and soft EOF for the host")
+	fs.BoolVar(&opts.Charset, "charset", opts.Charset, "With --text, map the Spectrum character set to UTF-8")
+	fs.BoolVar(&opts.Hobeta, "hobeta", opts.Hobeta, "Write the file as a Hobeta file, named as NAME.$C")
+	fs.BoolVar(&opts.Amsdos, "amsdos", opts.Amsdos, "Write the file with an AMSDOS header in place of its +3DOS one")
+	fs.BoolFunc("locoscript", "Convert a LocoScript document to text (default: if it is one; =false to copy its bytes)", func(s string) error {
+		v, err := strconv.ParseBool(s)
+		opts.LocoScript = &v
+		return err
+	})
 	if err := parseInterleaved(fs, args); err != nil {
 		return err
 	}
-	if err := requireArgs(fs, 2); err != nil {
+	if all {
+		if err := requireArgs(fs, 1); err != nil {
+			return err
+		}
+		if opts.PNG != "" || opts.GIF != "" || opts.Basic || opts.Array != "" || opts.Hobeta {
+			return usageError{fmt.Errorf("--all cannot be combined with --png, --gif, --basic, --array or --hobeta")}
+		}
+		if opts.Jobs < 1 {
+			return usageError{fmt.Errorf("--jobs must be at least 1")}
+		}
+	} else if err := requireArgs(fs, 2); err != nil {
 		return err
 	}
+	modes := 0
+	for _, set := range []bool{opts.PNG != "", opts.GIF != "", opts.Basic, opts.Array != "", opts.Text, opts.Hobeta, opts.Amsdos, opts.LocoScript != nil && *opts.LocoScript} {
+		if set {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return usageError{fmt.Errorf("only one of --png, --gif, --basic, --array, --text, --hobeta, --amsdos and --locoscript can be given")}
+	}
+	if opts.Array != "" && opts.Array != "csv" && opts.Array != "json" {
+		return usageError{fmt.Errorf("unknown array format %q", opts.Array)}
+	}
+	if opts.Scale < 1 {
+		return usageError{fmt.Errorf("--scale must be at least 1")}
+	}
+	if all {
+		return extract.ExtractAll(fs.Arg(0), opts)
+	}
 	return extract.Extract(fs.Arg(0), fs.Arg(1), opts)
 }
 
+func runCat(args []string) error {
+	opts := cat.DefaultCatOptions()
+	fs := newFlagSet("cat", "<disk.dsk> <name>")
+	fs.BoolVar(&opts.List, "list", opts.List, "List a BASIC program as text")
+	fs.BoolVar(&opts.StripHeader, "strip-header", opts.StripHeader, "Leave out the +3DOS header")
+	if err := parseInterleaved(fs, args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs, 2); err != nil {
+		return err
+	}
+	return cat.Cat(fs.Arg(0), fs.Arg(1), os.Stdout, opts)
+}
+
+func runHeader(args []string) error {
+	opts := header.DefaultHeaderOptions()
+	var ftype string
+	fs := newFlagSet("header", "<disk.dsk> <name>")
+	fs.Func("set-line", "Set the LINE of a BASIC program (32768 for none)", func(s string) error {
+		opts.Line = new(uint16)
+		return uint16Flag(opts.Line)(s)
+	})
+	fs.Func("set-load-addr", "Set the load address of a CODE file", func(s string) error {
+		opts.LoadAddr = new(uint16)
+		return uint16Flag(opts.LoadAddr)(s)
+	})
+	fs.StringVar(&ftype, "set-type", "", "Set the file type (basic, numeric, char, code)")
+	fs.BoolVar(&opts.FixChecksum, "fix-checksum", opts.FixChecksum, "Recompute the header checksum")
+	fs.BoolVar(&opts.Strip, "strip", opts.Strip, "Remove the header, keeping the data")
+	fs.BoolVar(&opts.Add, "add", opts.Add, "Give a headerless file a header (CODE at 32768 unless set)")
+	fs.BoolVar(&opts.Quiet, "quiet", opts.Quiet, "Suppress non-error output")
+	fs.BoolVar(&opts.Fidelity, "fidelity", opts.Fidelity, "Keep copy-protection FDC status of rewritten sectors")
+	fs.BoolVar(&opts.Backup, "backup", opts.Backup, "Keep the previous image as <disk>.bak")
+	fs.BoolVar(&opts.Journal, "journal", opts.Journal, "Record the change in <disk>.journal for undo")
+	if err := parseInterleaved(fs, args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs, 2); err != nil {
+		return err
+	}
+	var t byte
+	switch ftype {
+	case "":
+	case "basic":
+		t = diskimg.FileTypeProgram
+	case "numeric":
+		t = diskimg.FileTypeNumericArray
+	case "char":
+		t = diskimg.FileTypeCharArray
+	case "code":
+		t = diskimg.FileTypeCode
+	default:
+		return usageError{fmt.Errorf("unknown file type %q", ftype)}
+	}
+	if ftype != "" {
+		opts.Type = &t
+	}
+	if opts.Strip && (opts.Add || opts.FixChecksum || opts.Line != nil || opts.LoadAddr != nil || opts.Type != nil) {
+		return usageError{fmt.Errorf("--strip cannot be combined with other header changes")}
+	}
+	return header.Header(fs.Arg(0), fs.Arg(1), os.Stdout, opts)
+}
+
 func runList(args []string) error {
 	opts := list.DefaultListOptions()
 	var format string
-	fs := newFlagSet("list", "<disk.dsk>")
+	fs := newFlagSet("list", "<disk.dsk> | <archive.zip> [image]")
 	fs.StringVar(&opts.Sort, "sort", opts.Sort, "Sort order (name, size, type)")
 	fs.BoolVar(&opts.Reverse, "reverse", opts.Reverse, "Reverse sort order")
 	fs.BoolVar(&opts.ShowDeleted, "show-deleted", opts.ShowDeleted, "Include deleted files in the listing")
@@ -223,11 +658,25 @@ func runList(args []string) error {
 	fs.BoolVar(&opts.JSON, "json", opts.JSON, "Output in JSON format")
 	fs.BoolVar(&opts.Long, "long", opts.Long, "Show detailed information")
 	fs.StringVar(&opts.Pattern, "pattern", opts.Pattern, "Filter files by name pattern (e.g., '*.BAS')")
-	fs.StringVar(&format, "format", "dos", "Output format (options: 'ls', 'cpm', 'dos')")
+	fs.StringVar(&format, "format", "dos", "Output format (options: 'ls', 'cpm', 'dos', 'csv', 'basic')")
 	if err := parseInterleaved(fs, args); err != nil {
 		return err
 	}
-	if err := requireArgs(fs, 1); err != nil {
+	diskPath := fs.Arg(0)
+	_, _, zip := stdio.SplitZip(diskPath)
+	if format == "csv" && !opts.JSON && fs.NArg() > 0 && !(zip && fs.NArg() == 2) {
+		// A catalogue of every disk image given, in one table.
+		opts.Format = list.FormatCSV
+		return list.ListCSV(fs.Args(), os.Stdout, opts)
+	}
+	if fs.NArg() == 2 {
+		// "list collection.zip game.dsk" is "list collection.zip:game.dsk".
+		if _, _, ok := stdio.SplitZip(diskPath); !ok {
+			fs.Usage()
+			return usageError{fmt.Errorf("a second argument names a disk image inside a .zip archive")}
+		}
+		diskPath += ":" + fs.Arg(1)
+	} else if err := requireArgs(fs, 1); err != nil {
 		return err
 	}
 	switch format {
@@ -235,10 +684,14 @@ func runList(args []string) error {
 		opts.Format = list.FormatLS
 	case "cpm":
 		opts.Format = list.FormatCPM
+	case "csv":
+		opts.Format = list.FormatCSV
+	case "basic":
+		opts.Format = list.FormatBasic
 	default:
 		opts.Format = list.FormatDOS
 	}
-	return list.List(fs.Arg(0), opts)
+	return list.List(diskPath, opts)
 }
 
 func runInfo(args []string) error {
@@ -246,17 +699,233 @@ func runInfo(args []string) error {
 	fs := newFlagSet("info", "<disk.dsk>")
 	fs.BoolVar(&opts.JSON, "json", opts.JSON, "Output in JSON format")
 	fs.BoolVar(&opts.Validate, "validate", opts.Validate, "Perform disk validation")
+	fs.TextVar(&opts.Level, "level", opts.Level, "Validation depth (options: 'basic', 'strict', 'paranoid')")
 	fs.BoolVar(&opts.Verbose, "verbose", opts.Verbose, "Show additional details")
 	fs.BoolVar(&opts.ShowDeleted, "show-deleted", opts.ShowDeleted, "Include information about deleted files")
+	fs.BoolVar(&opts.Salvage, "salvage", opts.Salvage, "Load a damaged image, concealing bad tracks (reported by --validate)")
+	fs.StringVar(&opts.File, "file", opts.File, "Show everything known about one file: entries, blocks, header")
 	if err := parseInterleaved(fs, args); err != nil {
 		return err
 	}
 	if err := requireArgs(fs, 1); err != nil {
 		return err
 	}
+	// The global --verbose is taken before the command sees it.
+	opts.Verbose = opts.Verbose || slog.Default().Enabled(context.Background(), slog.LevelInfo)
 	return info.Info(fs.Arg(0), opts)
 }
 
+func runExportJSON(args []string) error {
+	opts := exportjson.DefaultExportJSONOptions()
+	fs := newFlagSet("export-json", "<disk.dsk>")
+	fs.BoolVar(&opts.Compact, "compact", opts.Compact, "Write the JSON on one line, not indented")
+	fs.BoolVar(&opts.Salvage, "salvage", opts.Salvage, "Load a damaged image, concealing bad tracks (reported in the findings)")
+	if err := parseInterleaved(fs, args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	return exportjson.ExportJSON(fs.Arg(0), os.Stdout, opts)
+}
+
+func runImportJSON(args []string) error {
+	opts := importjson.DefaultImportJSONOptions()
+	fs := newFlagSet("import-json", "<disk.json> <out>")
+	fs.BoolVar(&opts.Force, "force", opts.Force, "Overwrite existing files")
+	fs.BoolVar(&opts.Quiet, "quiet", opts.Quiet, "Suppress non-error output")
+	if err := parseInterleaved(fs, args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs, 2); err != nil {
+		return err
+	}
+	return importjson.ImportJSON(fs.Arg(0), fs.Arg(1), opts)
+}
+
+func runBootflag(args []string) error {
+	opts := bootflag.DefaultBootflagOptions()
+	fs := newFlagSet("bootflag", "<disk.dsk>")
+	fs.BoolVar(&opts.On, "on", opts.On, "Make the disk bootable")
+	fs.BoolVar(&opts.Off, "off", opts.Off, "Make the disk not bootable")
+	fs.BoolVar(&opts.Quiet, "quiet", opts.Quiet, "Suppress non-error output")
+	fs.BoolVar(&opts.Backup, "backup", opts.Backup, "Keep the previous image as <disk>.bak")
+	fs.BoolVar(&opts.Journal, "journal", opts.Journal, "Record the change in <disk>.journal for undo")
+	if err := parseInterleaved(fs, args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	return bootflag.Bootflag(fs.Arg(0), opts)
+}
+
+func runRepair(args []string) error {
+	opts := repair.DefaultRepairOptions()
+	fs := newFlagSet("repair", "<disk.dsk>")
+	fs.Func("code", "Finding code to repair (repeatable; default: every warning and error)", func(s string) error {
+		opts.Codes = append(opts.Codes, s)
+		return nil
+	})
+	fs.BoolVar(&opts.Quiet, "quiet", opts.Quiet, "Suppress non-error output")
+	fs.BoolVar(&opts.Backup, "backup", opts.Backup, "Keep the previous image as <disk>.bak")
+	fs.BoolVar(&opts.Journal, "journal", opts.Journal, "Record the change in <disk>.journal for undo")
+	if err := parseInterleaved(fs, args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	return repair.Repair(fs.Arg(0), opts)
+}
+
+func runPartitions(args []string) error {
+	opts := partitions.DefaultPartitionsOptions()
+	fs := newFlagSet("partitions", "<image.hdf>")
+	fs.BoolVar(&opts.JSON, "json", opts.JSON, "Output in JSON format")
+	if err := parseInterleaved(fs, args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	return partitions.Partitions(fs.Arg(0), opts)
+}
+
+func runPipeline(args []string) error {
+	if len(args) == 0 || args[0] != "run" {
+		return usageError{fmt.Errorf("usage: plus3 pipeline run [flags] <pipeline.yaml> <disk.dsk...>")}
+	}
+	opts := pipeline.DefaultPipelineOptions()
+	fs := newFlagSet("pipeline run", "<pipeline.yaml> <disk.dsk...>")
+	fs.StringVar(&opts.OutputDir, "o", opts.OutputDir, "Output directory (overrides the pipeline's output)")
+	fs.StringVar(&opts.OutputDir, "output-dir", opts.OutputDir, "Output directory (overrides the pipeline's output)")
+	fs.BoolVar(&opts.Quiet, "quiet", opts.Quiet, "Suppress non-error output")
+	if err := parseInterleaved(fs, args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return usageError{fmt.Errorf("expected a pipeline file and at least one disk image, got %d argument(s)", fs.NArg())}
+	}
+	return pipeline.Run(fs.Arg(0), fs.Args()[1:], opts)
+}
+
+func runSet(args []string) error {
+	if len(args) == 0 {
+		return usageError{fmt.Errorf("usage: plus3 set <list|add|extract> [flags] ...")}
+	}
+	switch args[0] {
+	case "list":
+		opts := diskset.DefaultListOptions()
+		fs := newFlagSet("set list", "<disk.dsk...>")
+		fs.BoolVar(&opts.JSON, "json", opts.JSON, "Output in JSON format")
+		if err := parseInterleaved(fs, args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() < 1 {
+			fs.Usage()
+			return usageError{fmt.Errorf("expected at least one disk image")}
+		}
+		return diskset.List(fs.Args(), opts)
+	case "add":
+		opts := diskset.DefaultAddOptions()
+		fs := newFlagSet("set add", "<file> <disk.dsk...>")
+		// -t and --type are equivalent.
+		fs.StringVar(&opts.FileType, "type", opts.FileType, "File type (basic, code, raw, auto)")
+		fs.StringVar(&opts.FileType, "t", opts.FileType, "File type (shorthand for --type)")
+		fs.Func("line", "Line number for BASIC programs", uint16Flag(&opts.Line))
+		fs.Func("load-addr", "Load address for CODE files", uint16Flag(&opts.LoadAddr))
+		fs.BoolVar(&opts.Quiet, "quiet", opts.Quiet, "Suppress non-error output")
+		if err := parseInterleaved(fs, args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() < 2 {
+			fs.Usage()
+			return usageError{fmt.Errorf("expected a file and at least one disk image, got %d argument(s)", fs.NArg())}
+		}
+		return diskset.Add(fs.Arg(0), fs.Args()[1:], opts)
+	case "extract":
+		opts := diskset.DefaultExtractOptions()
+		fs := newFlagSet("set extract", "<name> <disk.dsk...>")
+		fs.BoolVar(&opts.StripHeader, "strip-header", opts.StripHeader, "Remove +3DOS header if present")
+		// -o and --output-dir are equivalent.
+		fs.StringVar(&opts.OutputDir, "output-dir", opts.OutputDir, "Directory to extract files to")
+		fs.StringVar(&opts.OutputDir, "o", opts.OutputDir, "Directory to extract files to (shorthand for --output-dir)")
+		fs.BoolVar(&opts.Overwrite, "overwrite", opts.Overwrite, "Allow overwriting existing files")
+		fs.BoolVar(&opts.Quiet, "quiet", opts.Quiet, "Suppress non-error output")
+		if err := parseInterleaved(fs, args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() < 2 {
+			fs.Usage()
+			return usageError{fmt.Errorf("expected a file name and at least one disk image, got %d argument(s)", fs.NArg())}
+		}
+		return diskset.Extract(fs.Arg(0), fs.Args()[1:], opts)
+	}
+	return usageError{fmt.Errorf("unknown set command %q (use list, add or extract)", args[0])}
+}
+
+func runServeDav(args []string) error {
+	opts := servedav.DefaultServeOptions()
+	fs := newFlagSet("serve-dav", "<disk.dsk>")
+	fs.StringVar(&opts.Listen, "listen", opts.Listen, "Address to listen on (host:port)")
+	fs.BoolVar(&opts.ReadOnly, "read-only", opts.ReadOnly, "Refuse changes to the disk")
+	fs.BoolVar(&opts.Quiet, "quiet", opts.Quiet, "Suppress non-error output")
+	if err := parseInterleaved(fs, args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	return servedav.Serve(fs.Arg(0), opts)
+}
+
+func runWeb(args []string) error {
+	opts := web.DefaultWebOptions()
+	fs := newFlagSet("web", "<directory>")
+	fs.StringVar(&opts.Listen, "listen", opts.Listen, "Address to listen on (host:port)")
+	fs.BoolVar(&opts.Quiet, "quiet", opts.Quiet, "Suppress non-error output")
+	if err := parseInterleaved(fs, args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	return web.Serve(fs.Arg(0), opts)
+}
+
+func runDaemon(args []string) error {
+	opts := daemon.DefaultDaemonOptions()
+	fs := newFlagSet("daemon", "")
+	fs.StringVar(&opts.Listen, "listen", opts.Listen, "Address to listen on (unix:///path/to.sock or host:port)")
+	fs.StringVar(&opts.Root, "root", opts.Root, "Directory the images must lie in (required for a TCP address)")
+	fs.BoolVar(&opts.Quiet, "quiet", opts.Quiet, "Suppress non-error output")
+	if err := parseInterleaved(fs, args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs, 0); err != nil {
+		return err
+	}
+	return daemon.Serve(opts)
+}
+
+func runCompletion(args []string) error {
+	fs := newFlagSet("completion", "<bash|zsh|fish>")
+	if err := parseInterleaved(fs, args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	script, err := completion.Script(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Print(script)
+	return nil
+}
+
 // uint16Flag returns a flag.Func handler that parses a uint16 (decimal, or 0x
 // hex) into the target.
 func uint16Flag(target *uint16) func(string) error {
@@ -278,3 +947,52 @@ func uint16Flag(target *uint16) func(string) error {
 		return nil
 	}
 }
+
+// sessionRunner runs a command of a batch or shell, which reports its own
+// usage errors rather than exit on them.
+func sessionRunner(args []string) error {
+	err := runCommand(args[0], args[1:])
+	switch {
+	case err == errUnknownCommand:
+		return usageError{fmt.Errorf("unknown command %q", args[0])}
+	case errors.Is(err, flag.ErrHelp):
+		return nil
+	}
+	return err
+}
+
+func runBatch(args []string) error {
+	opts := batch.DefaultBatchOptions()
+	fs := newFlagSet("batch", "[script]")
+	fs.Func("e", "Run a command line before the script (repeatable)", func(s string) error {
+		opts.Commands = append(opts.Commands, s)
+		return nil
+	})
+	fs.BoolVar(&opts.Quiet, "quiet", opts.Quiet, "Suppress non-error output")
+	if err := parseInterleaved(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 || fs.NArg() == 0 && len(opts.Commands) == 0 {
+		fs.Usage()
+		return usageError{fmt.Errorf("expected a script or -e commands")}
+	}
+	flagErrorHandling = flag.ContinueOnError
+	defer func() { flagErrorHandling = flag.ExitOnError }()
+	return batch.Batch(fs.Arg(0), sessionRunner, opts)
+}
+
+func runShell(args []string) error {
+	opts := batch.DefaultShellOptions()
+	fs := newFlagSet("shell", "")
+	fs.StringVar(&opts.Prompt, "prompt", opts.Prompt, "Prompt printed before each command")
+	fs.BoolVar(&opts.Quiet, "quiet", opts.Quiet, "Suppress non-error output")
+	if err := parseInterleaved(fs, args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs, 0); err != nil {
+		return err
+	}
+	flagErrorHandling = flag.ContinueOnError
+	defer func() { flagErrorHandling = flag.ExitOnError }()
+	return batch.Shell(os.Stdin, os.Stdout, sessionRunner, opts)
+}
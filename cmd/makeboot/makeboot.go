@@ -0,0 +1,127 @@
+// file: cmd/makeboot/makeboot.go
+
+package makeboot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ha1tch/plus3/internal/stdio"
+	"github.com/ha1tch/plus3/pkg/diskimg"
+)
+
+// CodeFile is a host code file and the address it loads at
+type CodeFile struct {
+	Path     string
+	LoadAddr uint16
+}
+
+// MakeBootOptions configures MakeBoot
+type MakeBootOptions struct {
+	Screen string     // Host SCREEN$ file shown while the code loads
+	Code   []CodeFile // Host code files, loaded in order
+	Exec   uint16     // Address to call; the first code file's load address if 0
+	Force  bool       // Overwrite existing file
+	Quiet  bool       // Suppress non-error output
+}
+
+// DefaultMakeBootOptions returns default options for MakeBoot
+func DefaultMakeBootOptions() *MakeBootOptions {
+	return &MakeBootOptions{
+		Exec:  0,
+		Force: false,
+		Quiet: false,
+	}
+}
+
+// ParseCodeFile parses a --code value: a host path and a load address, as in
+// "main.bin,32768" or "main.bin,0x8000"
+func ParseCodeFile(s string) (CodeFile, error) {
+	path, addr, ok := strings.Cut(s, ",")
+	if !ok || path == "" {
+		return CodeFile{}, fmt.Errorf("code file %q: want <file>,<load address>", s)
+	}
+	n, err := strconv.ParseUint(addr, 0, 16)
+	if err != nil {
+		return CodeFile{}, fmt.Errorf("code file %q: invalid load address: %w", s, err)
+	}
+	return CodeFile{Path: path, LoadAddr: uint16(n)}, nil
+}
+
+// MakeBoot creates a +3 disk that runs a program by itself: the screen and
+// code files with PLUS3DOS headers, and a BASIC loader named DISK that loads
+// them and calls the code, which the +3 Loader menu option runs.
+func MakeBoot(outPath string, opts *MakeBootOptions) error {
+	if opts == nil {
+		opts = DefaultMakeBootOptions()
+	}
+	if len(opts.Code) == 0 {
+		return fmt.Errorf("no code file to load")
+	}
+	if !opts.Force && !stdio.IsStd(outPath) {
+		if _, err := os.Stat(outPath); err == nil {
+			return fmt.Errorf("%w: %s (use force to overwrite)", diskimg.ErrFileExists, outPath)
+		}
+	}
+
+	disk, err := diskimg.NewDiskImageWithSpec(diskimg.SpecPlus3)
+	if err != nil {
+		return fmt.Errorf("failed to create disk image: %w", err)
+	}
+	if err := disk.InitializeDirectory(); err != nil {
+		return fmt.Errorf("failed to initialize directory: %w", err)
+	}
+
+	autorun := &diskimg.AutorunOptions{Exec: opts.Exec}
+	if opts.Screen != "" {
+		if err := disk.ImportScreen(opts.Screen); err != nil {
+			return fmt.Errorf("failed to import %s: %w", opts.Screen, err)
+		}
+		autorun.Screen = diskName(opts.Screen, "SCR")
+	}
+	for _, c := range opts.Code {
+		name := diskName(c.Path, "BIN")
+		if slices.Contains(autorun.Code, name) {
+			return fmt.Errorf("%w: %s and another code file are both %s", diskimg.ErrFileExists, c.Path, name)
+		}
+		if err := disk.ImportCode(c.Path, c.LoadAddr); err != nil {
+			return fmt.Errorf("failed to import %s: %w", c.Path, err)
+		}
+		autorun.Code = append(autorun.Code, name)
+	}
+	if err := disk.WriteAutorun(autorun); err != nil {
+		return fmt.Errorf("failed to write loader: %w", err)
+	}
+
+	if err := stdio.SaveDisk(disk, outPath); err != nil {
+		return fmt.Errorf("failed to save disk image: %w", err)
+	}
+
+	if !opts.Quiet {
+		out := stdio.Status(outPath)
+		fmt.Fprintf(out, "Created autorun disk image: %s\n", outPath)
+		if autorun.Screen != "" {
+			fmt.Fprintf(out, "  %s (screen)\n", autorun.Screen)
+		}
+		for i, name := range autorun.Code {
+			fmt.Fprintf(out, "  %s (code at %d)\n", name, opts.Code[i].LoadAddr)
+		}
+		fmt.Fprintln(out, `Run it with the +3 Loader or LOAD "DISK"`)
+	}
+	return nil
+}
+
+// diskName returns the name ImportCode or ImportScreen gives the host file at
+// path: its first eight characters, upper-cased, with extension ext
+func diskName(path, ext string) string {
+	base := filepath.Base(path)
+	name := strings.TrimSuffix(base, filepath.Ext(base))
+	if len(name) > 8 {
+		name = name[:8]
+	}
+	return strings.ToUpper(name) + "." + ext
+}
@@ -0,0 +1,80 @@
+// file: cmd/partitions/partitions.go
+
+package partitions
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/ha1tch/plus3/pkg/diskimg"
+)
+
+// PartitionsOptions configures the partition listing
+type PartitionsOptions struct {
+	JSON bool // Output in JSON format
+}
+
+// DefaultPartitionsOptions returns default options for Partitions
+func DefaultPartitionsOptions() *PartitionsOptions {
+	return &PartitionsOptions{
+		JSON: false,
+	}
+}
+
+// PartitionInfo describes one IDEDOS partition
+type PartitionInfo struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Start int    `json:"start_sector"`
+	Size  int    `json:"size"`
+}
+
+// Partitions lists the IDEDOS partitions of a +3e hard disk image (.hdf).
+// Files in a +3DOS partition are reached by the other commands with a disk
+// path of "image.hdf:NAME".
+func Partitions(imagePath string, opts *PartitionsOptions) error {
+	if opts == nil {
+		opts = DefaultPartitionsOptions()
+	}
+
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return fmt.Errorf("failed to open hard disk image: %w", err)
+	}
+	defer f.Close()
+	h, err := diskimg.LoadHDF(f)
+	if err != nil {
+		return fmt.Errorf("failed to open hard disk image: %w", err)
+	}
+	parts, err := h.Partitions()
+	if err != nil {
+		return fmt.Errorf("failed to read partition table: %w", err)
+	}
+
+	infos := make([]PartitionInfo, len(parts))
+	for i, p := range parts {
+		infos[i] = PartitionInfo{
+			Index: p.Index,
+			Name:  p.Name,
+			Type:  p.TypeName(),
+			Start: p.StartSector,
+			Size:  p.Sectors * h.SectorSize(),
+		}
+	}
+
+	if opts.JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(infos)
+	}
+
+	fmt.Printf("Hard disk image: %s (%d-byte sectors)\n\n", imagePath, h.SectorSize())
+	fmt.Printf("  #  %-16s  %-8s  %10s  %9s\n", "Name", "Type", "Start", "Size")
+	for _, p := range infos {
+		fmt.Printf("%3d  %-16s  %-8s  %10d  %8dK\n", p.Index, p.Name, p.Type, p.Start, p.Size/1024)
+	}
+	fmt.Printf("\nUse %s:NAME as the disk image to work with a +3DOS partition.\n", imagePath)
+	return nil
+}
@@ -0,0 +1,376 @@
+// file: cmd/pipeline/pipeline.go
+
+package pipeline
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ha1tch/plus3/pkg/diskimg"
+)
+
+// PipelineOptions configures a pipeline run
+type PipelineOptions struct {
+	OutputDir string // Overrides the pipeline's output directory
+	Quiet     bool   // Suppress non-error output
+}
+
+// DefaultPipelineOptions returns default options for Run
+func DefaultPipelineOptions() *PipelineOptions {
+	return &PipelineOptions{
+		OutputDir: "",
+		Quiet:     false,
+	}
+}
+
+// CatalogRecord is the catalog entry produced for one input image
+type CatalogRecord struct {
+	Input     string        `json:"input"`
+	Output    string        `json:"output,omitempty"`
+	Container string        `json:"container"`
+	Tracks    int           `json:"tracks"`
+	Sides     int           `json:"sides"`
+	Concealed int           `json:"concealed_errors,omitempty"`
+	Hash      string        `json:"hash,omitempty"`
+	Files     []CatalogFile `json:"files,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// CatalogFile describes one file in a catalogued image
+type CatalogFile struct {
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	Header string `json:"header,omitempty"`
+}
+
+// job is the state of one input image as it moves through the steps.
+type job struct {
+	path      string
+	disk      *diskimg.DiskImage
+	container diskimg.Container // container the output is written in
+	concealed int               // errors concealed by the salvage load
+	repaired  bool              // a repair step accepted the concealed errors
+	changed   bool              // a step transformed the image; write it out
+	record    *CatalogRecord
+	opts      *PipelineOptions
+}
+
+// stepFunc runs one pipeline step against a job.
+type stepFunc func(j *job, s Step) error
+
+// steps is the registry of step implementations, by name.
+var steps = map[string]stepFunc{
+	"detect":    stepDetect,
+	"check":     stepCheck,
+	"repair":    stepRepair,
+	"convert":   stepConvert,
+	"normalize": stepNormalize,
+	"hash":      stepHash,
+	"catalog":   stepCatalog,
+}
+
+// Run applies the pipeline defined in specPath to each input image in turn.
+// A failing input is reported and the remaining inputs are still processed.
+func Run(specPath string, inputs []string, opts *PipelineOptions) error {
+	if opts == nil {
+		opts = DefaultPipelineOptions()
+	}
+
+	spec, err := LoadSpec(specPath)
+	if err != nil {
+		return fmt.Errorf("failed to load pipeline: %w", err)
+	}
+	for _, s := range spec.Steps {
+		if _, ok := steps[s.Name]; !ok {
+			return fmt.Errorf("pipeline line %d: unknown step %q", s.Line, s.Name)
+		}
+	}
+	if opts.OutputDir == "" {
+		opts.OutputDir = spec.Output
+	}
+
+	name := spec.Name
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(specPath), filepath.Ext(specPath))
+	}
+
+	var records []*CatalogRecord
+	failed := 0
+	for _, input := range inputs {
+		if !opts.Quiet {
+			fmt.Printf("%s: %s\n", name, input)
+		}
+		record, err := runJob(spec, input, opts)
+		records = append(records, record)
+		if err != nil {
+			record.Error = err.Error()
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", input, err)
+			failed++
+		}
+	}
+
+	if catalogPath := catalogFile(spec, opts); catalogPath != "" {
+		if err := writeCatalog(catalogPath, records); err != nil {
+			return fmt.Errorf("failed to write catalog: %w", err)
+		}
+		if !opts.Quiet {
+			fmt.Printf("Wrote catalog %s\n", catalogPath)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d input(s) failed", failed, len(inputs))
+	}
+	return nil
+}
+
+// runJob loads one input and runs every step against it, writing the result
+// to the output directory if any step transformed the image.
+func runJob(spec *Spec, input string, opts *PipelineOptions) (*CatalogRecord, error) {
+	record := &CatalogRecord{Input: input}
+
+	// Always load in salvage mode so a damaged image can reach a repair step;
+	// without one, the concealed errors stop the pipeline below.
+	disk, err := diskimg.LoadFromFileWithOptions(input, &diskimg.LoadOptions{Salvage: true})
+	if err != nil {
+		return record, fmt.Errorf("failed to open disk: %w", err)
+	}
+	j := &job{
+		path:      input,
+		disk:      disk,
+		container: disk.Container(),
+		record:    record,
+		opts:      opts,
+	}
+	for _, tc := range disk.Concealments() {
+		j.concealed += tc.Total()
+	}
+	record.Container = j.container.String()
+	record.Tracks = int(disk.Header.TracksNum)
+	record.Sides = int(disk.Header.SidesNum)
+	record.Concealed = j.concealed
+
+	for _, s := range spec.Steps {
+		if j.concealed > 0 && !j.repaired && s.Name != "detect" && s.Name != "repair" {
+			return record, fmt.Errorf("image is damaged (%d concealed errors); add a repair step to accept them", j.concealed)
+		}
+		if err := steps[s.Name](j, s); err != nil {
+			return record, fmt.Errorf("%s: %w", s.Name, err)
+		}
+	}
+
+	if j.changed {
+		if err := j.writeOutput(); err != nil {
+			return record, err
+		}
+	}
+	return record, nil
+}
+
+// logf prints a step's progress line unless the run is quiet.
+func (j *job) logf(step, format string, args ...any) {
+	if !j.opts.Quiet {
+		fmt.Printf("  %-9s %s\n", step, fmt.Sprintf(format, args...))
+	}
+}
+
+// writeOutput saves the transformed image into the output directory, under the
+// input's base name, in the job's target container.
+func (j *job) writeOutput() error {
+	if j.opts.OutputDir == "" {
+		return fmt.Errorf("pipeline transforms the image but no output directory is set (output: or -o)")
+	}
+	if err := os.MkdirAll(j.opts.OutputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	base := strings.TrimSuffix(filepath.Base(j.path), filepath.Ext(j.path))
+	out := filepath.Join(j.opts.OutputDir, base+".dsk")
+
+	inAbs, _ := filepath.Abs(j.path)
+	outAbs, _ := filepath.Abs(out)
+	if inAbs == outAbs {
+		return fmt.Errorf("output %s would overwrite the input", out)
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := j.disk.SaveContainer(f, j.container); err != nil {
+		os.Remove(out)
+		return fmt.Errorf("failed to save disk: %w", err)
+	}
+	j.record.Output = out
+	j.record.Container = j.container.String()
+	j.logf("write", "%s (%s container)", out, j.container)
+	return nil
+}
+
+// stepDetect reports the container, geometry and file count of the image.
+func stepDetect(j *job, s Step) error {
+	files := 0
+	for range j.disk.Files(&diskimg.FilesOptions{System: true}) {
+		files++
+	}
+	state := "clean"
+	if j.concealed > 0 {
+		state = fmt.Sprintf("damaged, %d concealed errors", j.concealed)
+	}
+	j.logf(s.Name, "%s container, %s format, %d tracks, %d side(s), %d file(s), %s",
+		j.container, j.disk.Spec().Name, j.disk.Header.TracksNum, j.disk.Header.SidesNum, files, state)
+	return nil
+}
+
+// stepCheck runs the structural disk check at the step's level, logs its
+// warnings and fails the job on an error finding.
+func stepCheck(j *job, s Step) error {
+	var level diskimg.ValidationLevel
+	if err := level.UnmarshalText([]byte(strings.ToLower(s.Param("level", "strict")))); err != nil {
+		return err
+	}
+	report := j.disk.CheckWithOptions(&diskimg.CheckOptions{Level: level})
+	if err := report.Err(); err != nil {
+		return err
+	}
+	for _, f := range report.Findings {
+		if f.Severity == diskimg.SeverityWarning && f.Code != "track-concealed" {
+			j.logf(s.Name, "%s", f)
+		}
+	}
+	j.logf(s.Name, "ok")
+	return nil
+}
+
+// stepRepair repairs what it can of the disk check's findings, accepting the
+// errors concealed by the salvage load, so the repaired image can continue
+// through the pipeline and be written out.
+func stepRepair(j *job, s Step) error {
+	j.repaired = true
+	fixed, err := j.disk.Repair(nil, diskimg.RepairOptions{})
+	if err != nil {
+		return err
+	}
+	if len(fixed) == 0 {
+		j.logf(s.Name, "nothing to repair")
+		return nil
+	}
+	j.changed = true
+	for _, f := range fixed {
+		j.logf(s.Name, "repaired %s", f)
+	}
+	return nil
+}
+
+// stepConvert selects the output container: "edsk" (extended) or "dsk"
+// (standard).
+func stepConvert(j *job, s Step) error {
+	switch strings.ToLower(s.Param("to", "edsk")) {
+	case "edsk", "extended":
+		j.container = diskimg.ContainerExtended
+	case "dsk", "standard":
+		j.container = diskimg.ContainerStandard
+	default:
+		return fmt.Errorf("unknown container %q (want edsk or dsk)", s.Param("to", ""))
+	}
+	j.changed = true
+	j.logf(s.Name, "to %s container", j.container)
+	return nil
+}
+
+// stepNormalize rewrites the directory in canonical form (free slots as 0xE5
+// filler) and stamps the creator field, so equivalent images serialise to
+// identical bytes.
+func stepNormalize(j *job, s Step) error {
+	if err := j.disk.FlushDirectory(); err != nil {
+		return err
+	}
+	j.disk.Header.Creator = [14]byte{}
+	copy(j.disk.Header.Creator[:], "plus3")
+	j.changed = true
+	j.logf(s.Name, "directory and creator normalised")
+	return nil
+}
+
+// stepHash hashes the image as it will be written (in the target container).
+func stepHash(j *job, s Step) error {
+	algorithm := strings.ToLower(s.Param("algorithm", "sha256"))
+	var h hash.Hash
+	switch algorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha1":
+		h = sha1.New()
+	case "md5":
+		h = md5.New()
+	default:
+		return fmt.Errorf("unknown hash algorithm %q (want sha256, sha1 or md5)", algorithm)
+	}
+	var buf bytes.Buffer
+	if err := j.disk.SaveContainer(&buf, j.container); err != nil {
+		return err
+	}
+	h.Write(buf.Bytes())
+	j.record.Hash = algorithm + ":" + hex.EncodeToString(h.Sum(nil))
+	j.logf(s.Name, "%s", j.record.Hash)
+	return nil
+}
+
+// stepCatalog records the image's files in its catalog entry. The catalog of
+// all inputs is written once the run completes.
+func stepCatalog(j *job, s Step) error {
+	catalog, err := j.disk.Catalog()
+	if err != nil {
+		return err
+	}
+	j.record.Files = nil
+	for _, fi := range catalog {
+		file := CatalogFile{Name: fi.Name, Size: int(fi.Size)}
+		if fi.Headered() {
+			file.Header = fi.Header.GetFileType()
+		}
+		j.record.Files = append(j.record.Files, file)
+	}
+	j.logf(s.Name, "%d file(s)", len(j.record.Files))
+	return nil
+}
+
+// catalogFile returns the path the catalog is written to: the first catalog
+// step's file, relative to the output directory. It is empty if the pipeline
+// has no catalog step.
+func catalogFile(spec *Spec, opts *PipelineOptions) string {
+	for _, s := range spec.Steps {
+		if s.Name != "catalog" {
+			continue
+		}
+		path := s.Param("file", "catalog.json")
+		if opts.OutputDir != "" && !filepath.IsAbs(path) {
+			path = filepath.Join(opts.OutputDir, path)
+		}
+		return path
+	}
+	return ""
+}
+
+// writeCatalog writes the catalog records as indented JSON.
+func writeCatalog(path string, records []*CatalogRecord) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
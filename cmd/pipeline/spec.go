@@ -0,0 +1,185 @@
+// file: cmd/pipeline/spec.go
+
+package pipeline
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Step is one entry in a pipeline's step list.
+type Step struct {
+	Name   string            // step name, e.g. "convert"
+	Arg    string            // inline argument ("- convert: edsk"), if any
+	Params map[string]string // nested key/value parameters, if any
+	Line   int               // line number in the pipeline file, for errors
+}
+
+// Param returns the named parameter, falling back to the inline argument and
+// then to def.
+func (s Step) Param(name, def string) string {
+	if v, ok := s.Params[name]; ok && v != "" {
+		return v
+	}
+	if s.Arg != "" {
+		return s.Arg
+	}
+	return def
+}
+
+// Spec is a parsed pipeline definition.
+type Spec struct {
+	Name        string
+	Description string
+	Output      string // directory that transformed images are written to
+	Steps       []Step
+}
+
+// LoadSpec reads and parses a pipeline file.
+func LoadSpec(path string) (*Spec, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	spec, err := ParseSpec(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return spec, nil
+}
+
+// ParseSpec parses a pipeline definition. The format is the small subset of
+// YAML a pipeline needs (there is deliberately no YAML dependency): top-level
+// "key: value" pairs, and a "steps:" list whose items are a bare step name, a
+// "name: argument" pair, or a "name:" followed by indented "key: value"
+// parameters. The items may be indented under "steps:" or level with it.
+// Comments start with '#'; values may be quoted.
+//
+//	name: preservation
+//	output: archive/
+//	steps:
+//	  - detect
+//	  - convert: edsk
+//	  - hash:
+//	      algorithm: sha256
+func ParseSpec(r io.Reader) (*Spec, error) {
+	spec := &Spec{}
+	inSteps := false
+	stepIndent := -1
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := stripComment(sc.Text())
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		text := strings.TrimSpace(line)
+		isItem := strings.HasPrefix(text, "- ") || text == "-"
+
+		if indent == 0 && !(inSteps && isItem) {
+			key, value, ok := splitKey(text)
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected \"key: value\"", n)
+			}
+			inSteps = false
+			switch key {
+			case "name":
+				spec.Name = value
+			case "description":
+				spec.Description = value
+			case "output":
+				spec.Output = value
+			case "steps":
+				if value != "" {
+					return nil, fmt.Errorf("line %d: steps must be a list", n)
+				}
+				inSteps = true
+			default:
+				return nil, fmt.Errorf("line %d: unknown key %q", n, key)
+			}
+			continue
+		}
+
+		if !inSteps {
+			return nil, fmt.Errorf("line %d: unexpected indentation", n)
+		}
+		if isItem {
+			item := strings.TrimSpace(strings.TrimPrefix(text, "-"))
+			if item == "" {
+				return nil, fmt.Errorf("line %d: empty step", n)
+			}
+			step := Step{Line: n}
+			if key, value, ok := splitKey(item); ok {
+				step.Name, step.Arg = key, value
+			} else {
+				step.Name = unquote(item)
+			}
+			spec.Steps = append(spec.Steps, step)
+			stepIndent = indent
+			continue
+		}
+		// A parameter line belongs to the most recent step.
+		if len(spec.Steps) == 0 || indent <= stepIndent {
+			return nil, fmt.Errorf("line %d: parameter outside a step", n)
+		}
+		key, value, ok := splitKey(text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", n)
+		}
+		step := &spec.Steps[len(spec.Steps)-1]
+		if step.Params == nil {
+			step.Params = make(map[string]string)
+		}
+		step.Params[key] = value
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(spec.Steps) == 0 {
+		return nil, fmt.Errorf("pipeline has no steps")
+	}
+	return spec, nil
+}
+
+// splitKey splits "key: value" (or "key:") into its parts.
+func splitKey(text string) (key, value string, ok bool) {
+	i := strings.Index(text, ":")
+	if i <= 0 {
+		return "", "", false
+	}
+	key = strings.TrimSpace(text[:i])
+	if strings.ContainsAny(key, " \"'") {
+		return "", "", false
+	}
+	return key, unquote(strings.TrimSpace(text[i+1:])), true
+}
+
+// stripComment removes a trailing '#' comment that is not inside quotes.
+func stripComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#' && (i == 0 || line[i-1] == ' '):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// unquote removes matching surrounding single or double quotes.
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
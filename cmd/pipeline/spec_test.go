@@ -0,0 +1,99 @@
+package pipeline
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A pipeline file parses into its keys and steps, whether the step items are
+// indented under "steps:" or level with it.
+func TestParseSpec(t *testing.T) {
+	want := &Spec{
+		Name:        "preservation",
+		Description: "archive # copy",
+		Output:      "archive/",
+		Steps: []Step{
+			{Name: "detect"},
+			{Name: "convert", Arg: "edsk"},
+			{Name: "hash", Params: map[string]string{"algorithm": "sha256"}},
+			{Name: "catalog", Params: map[string]string{"file": "my catalog.json"}},
+		},
+	}
+	for name, text := range map[string]string{
+		"indented": `# Ingest pipeline
+name: preservation
+description: "archive # copy"
+output: 'archive/'   # trailing comment
+steps:
+  - detect
+  - convert: edsk
+  - hash:
+      algorithm: sha256   # default
+  - catalog:
+      file: "my catalog.json"
+`,
+		"level": `name: preservation
+description: "archive # copy"
+output: archive/
+steps:
+- detect
+
+- convert: edsk
+- hash:
+    algorithm: sha256
+- catalog:
+    file: 'my catalog.json'
+`,
+	} {
+		spec, err := ParseSpec(strings.NewReader(text))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		for i := range spec.Steps {
+			spec.Steps[i].Line = 0
+		}
+		if !reflect.DeepEqual(spec, want) {
+			t.Errorf("%s: parsed %+v, want %+v", name, spec, want)
+		}
+	}
+}
+
+// Malformed pipelines are rejected with the line at fault.
+func TestParseSpecErrors(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		err  string
+	}{
+		{"name: x\n", "pipeline has no steps"},
+		{"steps: detect\n", "line 1: steps must be a list"},
+		{"colour: red\nsteps:\n  - detect\n", `line 1: unknown key "colour"`},
+		{"just text\n", `line 1: expected "key: value"`},
+		{"name: x\n  - detect\n", "line 2: unexpected indentation"},
+		{"steps:\n  -\n", "line 2: empty step"},
+		{"steps:\n  algorithm: sha256\n", "line 2: parameter outside a step"},
+		{"steps:\n  - hash:\n  algorithm: sha256\n", "line 3: parameter outside a step"},
+		{"steps:\n  - hash:\n      not a pair\n", `line 3: expected "key: value"`},
+		{"steps:\n- detect\nname: x\n- check\n", `line 4: expected "key: value"`},
+	} {
+		_, err := ParseSpec(strings.NewReader(tc.text))
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("ParseSpec(%q) = %v, want %q", tc.text, err, tc.err)
+		}
+	}
+}
+
+// A step parameter falls back to the inline argument, then to the default.
+func TestStepParam(t *testing.T) {
+	s := Step{Name: "hash", Arg: "md5", Params: map[string]string{"file": "out.json"}}
+	if got := s.Param("file", "x"); got != "out.json" {
+		t.Errorf("Param(file) = %q", got)
+	}
+	if got := s.Param("algorithm", "sha256"); got != "md5" {
+		t.Errorf("Param(algorithm) = %q, want the inline argument", got)
+	}
+	if got := (Step{}).Param("algorithm", "sha256"); got != "sha256" {
+		t.Errorf("Param with no argument = %q, want the default", got)
+	}
+}
@@ -0,0 +1,84 @@
+// file: cmd/repair/repair.go
+
+package repair
+
+import (
+	"fmt"
+
+	"github.com/ha1tch/plus3/internal/stdio"
+	"github.com/ha1tch/plus3/pkg/diskimg"
+)
+
+// RepairOptions configures Repair
+type RepairOptions struct {
+	Codes   []string // Finding codes to repair; empty for every warning and error
+	Quiet   bool     // Suppress non-error output
+	Backup  bool     // Keep the previous image as <disk>.bak
+	Journal bool     // Record the change in <disk>.journal for undo
+}
+
+// DefaultRepairOptions returns default options for Repair
+func DefaultRepairOptions() *RepairOptions {
+	return &RepairOptions{
+		Codes:   nil,
+		Quiet:   false,
+		Backup:  false,
+		Journal: false,
+	}
+}
+
+// Repair checks a disk, fixes the findings selected by Codes that the library
+// can fix, and saves it. The disk is loaded in salvage mode so a damaged image
+// can be repaired; it is only saved once its concealed errors are accepted
+func Repair(diskPath string, opts *RepairOptions) error {
+	if opts == nil {
+		opts = DefaultRepairOptions()
+	}
+	if err := stdio.Exists(diskPath); err != nil {
+		return err
+	}
+
+	disk, err := stdio.LoadDisk(diskPath, &diskimg.LoadOptions{Salvage: true})
+	if err != nil {
+		return fmt.Errorf("failed to open disk: %w", err)
+	}
+	if err := stdio.StartJournal(disk, diskPath, opts.Journal); err != nil {
+		return err
+	}
+	fixed, err := disk.Repair(disk.Check(), diskimg.RepairOptions{Codes: opts.Codes})
+	if err != nil {
+		return fmt.Errorf("failed to repair disk: %w", err)
+	}
+	if concealed := concealedErrors(disk); concealed > 0 {
+		return fmt.Errorf("image is damaged (%d concealed errors); repair track-concealed to accept them", concealed)
+	}
+	if len(fixed) == 0 {
+		if !opts.Quiet {
+			fmt.Fprintf(stdio.Status(diskPath), "%s: nothing to repair\n", diskPath)
+		}
+		return nil
+	}
+
+	if err := stdio.SaveDiskWithOptions(disk, diskPath, &diskimg.SaveOptions{Backup: opts.Backup}); err != nil {
+		return fmt.Errorf("failed to save disk: %w", err)
+	}
+	if err := stdio.Journal(disk, diskPath, "repair"); err != nil {
+		return err
+	}
+	if !opts.Quiet {
+		for _, f := range fixed {
+			fmt.Fprintf(stdio.Status(diskPath), "repaired %s\n", f)
+		}
+	}
+	return nil
+}
+
+// concealedErrors counts the errors a salvage load concealed that are not yet
+// accepted
+func concealedErrors(disk *diskimg.DiskImage) int {
+	n := 0
+	for _, tc := range disk.Concealments() {
+		n += tc.Total()
+	}
+	return n
+}
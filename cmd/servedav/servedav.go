@@ -0,0 +1,458 @@
+// file: cmd/servedav/servedav.go
+
+package servedav
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ha1tch/plus3/internal/stdio"
+	"github.com/ha1tch/plus3/pkg/diskimg"
+)
+
+// maxPutSize caps the body of a PUT, as ImportFile caps a host file.
+const maxPutSize = 8 * 1024 * 1024
+
+// ServeOptions configures serving a disk image over WebDAV
+type ServeOptions struct {
+	Listen   string // Address to listen on, host:port
+	ReadOnly bool   // Refuse requests that change the disk
+	Quiet    bool   // Suppress non-error output
+}
+
+// DefaultServeOptions returns default options for Serve
+func DefaultServeOptions() *ServeOptions {
+	return &ServeOptions{
+		Listen:   ":8080",
+		ReadOnly: false,
+		Quiet:    false,
+	}
+}
+
+// Serve exposes the files of a disk image over WebDAV until the server fails.
+// The disk is one flat directory. Each change is saved to the image as soon
+// as it is made. A disk inside a ZIP archive is served read-only.
+func Serve(diskPath string, opts *ServeOptions) error {
+	if opts == nil {
+		opts = DefaultServeOptions()
+	}
+	if stdio.IsStd(diskPath) {
+		return fmt.Errorf("serve-dav needs a disk image file, not standard input")
+	}
+	if err := stdio.Exists(diskPath); err != nil {
+		return err
+	}
+	disk, err := stdio.LoadDisk(diskPath, nil)
+	if err != nil {
+		return fmt.Errorf("failed to open disk: %w", err)
+	}
+	h := &handler{
+		disk:     disk,
+		path:     diskPath,
+		readOnly: opts.ReadOnly,
+		quiet:    opts.Quiet,
+		modTime:  time.Now(),
+	}
+	if _, _, ok := stdio.SplitZip(diskPath); ok {
+		h.readOnly = true
+	}
+	image := diskPath
+	if i, _, ok := stdio.SplitHDF(diskPath); ok {
+		image = i
+	}
+	if info, err := os.Stat(image); err == nil {
+		h.modTime = info.ModTime()
+	}
+
+	if !opts.Quiet {
+		mode := ""
+		if h.readOnly {
+			mode = " (read-only)"
+		}
+		fmt.Printf("Serving %s over WebDAV on %s%s\n", diskPath, opts.Listen, mode)
+	}
+	return http.ListenAndServe(opts.Listen, h)
+}
+
+// handler answers WebDAV requests for one disk image. Requests are handled
+// one at a time.
+type handler struct {
+	mu       sync.Mutex
+	disk     *diskimg.DiskImage
+	path     string
+	readOnly bool
+	quiet    bool
+	modTime  time.Time // the disk's last change, reported for every file
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	name, ok := resourceName(r.URL.Path)
+	if !ok {
+		http.Error(w, "the disk has no subdirectories", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "OPTIONS":
+		w.Header().Set("DAV", "1, 2")
+		w.Header().Set("MS-Author-Via", "DAV")
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, MOVE, COPY, PROPFIND, PROPPATCH, LOCK, UNLOCK")
+		return
+	case "GET", "HEAD":
+		h.get(w, r, name)
+		return
+	case "PROPFIND":
+		h.propfind(w, r, name)
+		return
+	case "PROPPATCH":
+		h.proppatch(w, r, name)
+		return
+	case "LOCK":
+		h.lock(w, r)
+		return
+	case "UNLOCK":
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case "MKCOL":
+		http.Error(w, "the disk has no subdirectories", http.StatusMethodNotAllowed)
+		return
+	case "PUT", "DELETE", "MOVE", "COPY":
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.readOnly {
+		http.Error(w, "the disk is served read-only", http.StatusForbidden)
+		return
+	}
+	if name == "" {
+		http.Error(w, "the root directory cannot be changed", http.StatusForbidden)
+		return
+	}
+	var status int
+	var err error
+	switch r.Method {
+	case "PUT":
+		status, err = h.put(r, name)
+	case "DELETE":
+		status, err = h.delete(name)
+	default:
+		status, err = h.moveOrCopy(r, name)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.WriteHeader(status)
+}
+
+// resourceName returns the disk file named by a request path, "" for the
+// root. ok is false for a path below the root's files.
+func resourceName(p string) (name string, ok bool) {
+	name = strings.TrimPrefix(path.Clean("/"+p), "/")
+	return diskimg.NormaliseFilename(name), !strings.Contains(name, "/")
+}
+
+func (h *handler) get(w http.ResponseWriter, r *http.Request, name string) {
+	if name == "" {
+		http.Error(w, "use a WebDAV client to list the disk", http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := fs.ReadFile(h.disk, name)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	http.ServeContent(w, r, name, h.modTime, bytes.NewReader(data))
+}
+
+// put stores the request body as the named file.
+func (h *handler) put(r *http.Request, name string) (int, error) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxPutSize+1))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	if len(data) > maxPutSize {
+		return http.StatusRequestEntityTooLarge, fmt.Errorf("file too large for +3DOS (max 8MB)")
+	}
+	status := http.StatusNoContent
+	if _, err := h.disk.Stat(name); err != nil {
+		status = http.StatusCreated
+	}
+	if err := h.disk.WriteFile(name, data); err != nil {
+		return errorStatus(err), err
+	}
+	return h.save(status, "Wrote %s (%d bytes)", name, len(data))
+}
+
+// delete removes the named file. A read-only file is refused, as delete
+// refuses it without force.
+func (h *handler) delete(name string) (int, error) {
+	info, err := h.disk.Stat(name)
+	if err != nil {
+		return errorStatus(err), err
+	}
+	if info.Mode()&0200 == 0 {
+		return http.StatusForbidden, fmt.Errorf("%w: %s", diskimg.ErrReadOnly, name)
+	}
+	if err := h.disk.DeleteFile(name); err != nil {
+		return errorStatus(err), err
+	}
+	return h.save(http.StatusNoContent, "Deleted %s", name)
+}
+
+// moveOrCopy renames or copies the named file to the request's Destination,
+// replacing a file there unless the Overwrite header is F.
+func (h *handler) moveOrCopy(r *http.Request, name string) (int, error) {
+	if _, err := h.disk.Stat(name); err != nil {
+		return errorStatus(err), err
+	}
+	u, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || u.Path == "" {
+		return http.StatusBadRequest, fmt.Errorf("missing or invalid Destination header")
+	}
+	dest, ok := resourceName(u.Path)
+	if !ok || dest == "" {
+		return http.StatusConflict, fmt.Errorf("destination must be a file in the root directory")
+	}
+	if dest == name {
+		return http.StatusForbidden, fmt.Errorf("source and destination are the same file")
+	}
+	status := http.StatusCreated
+	if _, err := h.disk.Stat(dest); err == nil {
+		if r.Header.Get("Overwrite") == "F" {
+			return http.StatusPreconditionFailed, fmt.Errorf("%w: %s", diskimg.ErrFileExists, dest)
+		}
+		status = http.StatusNoContent
+	}
+
+	if r.Method == "COPY" {
+		err = diskimg.CopyFile(h.disk, h.disk, name, &diskimg.CopyOptions{NewName: dest, Overwrite: true})
+		if err != nil {
+			return errorStatus(err), err
+		}
+		return h.save(status, "Copied %s to %s", name, dest)
+	}
+	// Replace dest in a transaction, so it survives a rename that fails.
+	tx := h.disk.Begin()
+	if status == http.StatusNoContent {
+		if err := tx.DeleteFile(dest); err != nil {
+			tx.Rollback()
+			return errorStatus(err), err
+		}
+	}
+	if err := tx.RenameFile(name, dest); err != nil {
+		tx.Rollback()
+		return errorStatus(err), err
+	}
+	if err := tx.Commit(); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return h.save(status, "Renamed %s to %s", name, dest)
+}
+
+// save writes the disk image back and reports the change, returning status
+// if it was saved. A failed save is a server error, or 507 for a full disk,
+// since the change did not reach the image.
+func (h *handler) save(status int, format string, args ...any) (int, error) {
+	if err := stdio.SaveDisk(h.disk, h.path); err != nil {
+		if errors.Is(err, diskimg.ErrDiskFull) {
+			return http.StatusInsufficientStorage, fmt.Errorf("failed to save disk: %w", err)
+		}
+		return http.StatusInternalServerError, fmt.Errorf("failed to save disk: %w", err)
+	}
+	h.modTime = time.Now()
+	if !h.quiet {
+		fmt.Printf(format+"\n", args...)
+	}
+	return status, nil
+}
+
+// errorStatus maps a library error to an HTTP status.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, diskimg.ErrFileNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, diskimg.ErrInvalidFilename), errors.Is(err, diskimg.ErrReadOnly):
+		return http.StatusForbidden
+	case errors.Is(err, diskimg.ErrFileExists):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, diskimg.ErrDiskFull), errors.Is(err, diskimg.ErrDirectoryFull):
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusInternalServerError
+}
+
+// multistatus is the body of a 207 Multi-Status response.
+type multistatus struct {
+	XMLName   xml.Name   `xml:"D:multistatus"`
+	DAV       string     `xml:"xmlns:D,attr"`
+	Responses []response `xml:"D:response"`
+}
+
+type response struct {
+	Href     string     `xml:"D:href"`
+	Propstat []propstat `xml:"D:propstat"`
+}
+
+type propstat struct {
+	Prop   prop   `xml:"D:prop"`
+	Status string `xml:"D:status"`
+}
+
+type prop struct {
+	DisplayName   string        `xml:"D:displayname,omitempty"`
+	ResourceType  *resourceType `xml:"D:resourcetype,omitempty"`
+	ContentLength string        `xml:"D:getcontentlength,omitempty"`
+	LastModified  string        `xml:"D:getlastmodified,omitempty"`
+	Other         []anyProp
+}
+
+type resourceType struct {
+	Collection *struct{} `xml:"D:collection,omitempty"`
+}
+
+// anyProp is a property named by the client, answered without a value.
+type anyProp struct {
+	XMLName xml.Name
+}
+
+// propfind lists the properties of the root directory and, unless Depth is
+// 0, of every file; or of one file. Every property is returned whatever the
+// request asks for.
+func (h *handler) propfind(w http.ResponseWriter, r *http.Request, name string) {
+	var infos []fs.FileInfo
+	if name == "" {
+		infos = append(infos, nil)
+	}
+	if name == "" && r.Header.Get("Depth") != "0" {
+		entries, err := h.disk.ReadDir(".")
+		if err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		for _, e := range entries {
+			info, err := e.Info()
+			if err != nil {
+				http.Error(w, err.Error(), errorStatus(err))
+				return
+			}
+			infos = append(infos, info)
+		}
+	}
+	if name != "" {
+		info, err := h.disk.Stat(name)
+		if err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		infos = append(infos, info)
+	}
+
+	ms := multistatus{DAV: "DAV:"}
+	modified := h.modTime.UTC().Format(http.TimeFormat)
+	for _, info := range infos {
+		if info == nil {
+			ms.Responses = append(ms.Responses, response{Href: "/", Propstat: []propstat{{
+				Prop:   prop{DisplayName: "/", ResourceType: &resourceType{Collection: &struct{}{}}, LastModified: modified},
+				Status: "HTTP/1.1 200 OK",
+			}}})
+			continue
+		}
+		ms.Responses = append(ms.Responses, response{Href: "/" + url.PathEscape(info.Name()), Propstat: []propstat{{
+			Prop: prop{
+				DisplayName:   info.Name(),
+				ResourceType:  &resourceType{},
+				ContentLength: fmt.Sprint(info.Size()),
+				LastModified:  modified,
+			},
+			Status: "HTTP/1.1 200 OK",
+		}}})
+	}
+	writeMultistatus(w, ms)
+}
+
+// proppatch accepts, and discards, any property the client sets; the disk
+// has nowhere to keep them. Clients such as Windows set file times this way
+// after a write and treat a refusal as a failed copy.
+func (h *handler) proppatch(w http.ResponseWriter, r *http.Request, name string) {
+	if name != "" {
+		if _, err := h.disk.Stat(name); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	}
+	var names []anyProp
+	d := xml.NewDecoder(r.Body)
+	depth, inProp := 0, -1
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if t.Name.Local == "prop" && t.Name.Space == "DAV:" {
+				inProp = depth
+			} else if depth == inProp+1 && inProp > 0 {
+				names = append(names, anyProp{XMLName: t.Name})
+			}
+		case xml.EndElement:
+			if depth == inProp {
+				inProp = -1
+			}
+			depth--
+		}
+	}
+	writeMultistatus(w, multistatus{DAV: "DAV:", Responses: []response{{
+		Href:     "/" + url.PathEscape(name),
+		Propstat: []propstat{{Prop: prop{Other: names}, Status: "HTTP/1.1 200 OK"}},
+	}}})
+}
+
+// lock grants every lock request. Locks are not enforced: requests are
+// handled one at a time and the disk has a single writer, but Finder and
+// Windows only mount a share read-write if it answers LOCK.
+func (h *handler) lock(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("If")
+	if token == "" {
+		b := make([]byte, 16)
+		rand.Read(b)
+		token = "opaquelocktoken:" + hex.EncodeToString(b)
+	} else {
+		// A refresh names the lock in the If header: (<token>).
+		token = strings.Trim(token, "()<> ")
+	}
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.Header().Set("Lock-Token", "<"+token+">")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>
+<D:prop xmlns:D="DAV:"><D:lockdiscovery><D:activelock>`+
+		`<D:locktype><D:write/></D:locktype><D:lockscope><D:exclusive/></D:lockscope>`+
+		`<D:depth>0</D:depth><D:timeout>Second-3600</D:timeout>`+
+		`<D:locktoken><D:href>%s</D:href></D:locktoken>`+
+		`</D:activelock></D:lockdiscovery></D:prop>`, token)
+}
+
+func writeMultistatus(w http.ResponseWriter, ms multistatus) {
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(ms)
+}
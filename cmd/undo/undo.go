@@ -0,0 +1,76 @@
+// file: cmd/undo/undo.go
+
+package undo
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/ha1tch/plus3/internal/stdio"
+	"github.com/ha1tch/plus3/pkg/diskimg"
+)
+
+// UndoOptions configures the Undo operation
+type UndoOptions struct {
+	Quiet  bool // Suppress non-error output
+	Backup bool // Keep the previous image as <disk>.bak
+}
+
+// DefaultUndoOptions returns default options for Undo
+func DefaultUndoOptions() *UndoOptions {
+	return &UndoOptions{
+		Quiet:  false,
+		Backup: false,
+	}
+}
+
+// Undo reverts the most recent operation in the journal of the disk image at
+// diskPath and removes it from the journal. For a +3e hard disk image the
+// operation may be on any of its partitions. The files the operation changed
+// must not have been changed since by an operation that was not journaled.
+func Undo(diskPath string, opts *UndoOptions) error {
+	if opts == nil {
+		opts = DefaultUndoOptions()
+	}
+	journal, _, ok := stdio.JournalPath(diskPath)
+	if !ok {
+		return fmt.Errorf("%s cannot have a journal", diskPath)
+	}
+	entries, err := diskimg.ReadJournal(journal)
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	if len(entries) == 0 {
+		return errors.New("nothing to undo")
+	}
+	last := entries[len(entries)-1]
+
+	if image, _, ok := stdio.SplitHDF(diskPath); ok {
+		diskPath = image
+		if last.Partition != "" {
+			diskPath += ":" + last.Partition
+		}
+	}
+	if err := stdio.Exists(diskPath); err != nil {
+		return err
+	}
+	disk, err := stdio.LoadDisk(diskPath, nil)
+	if err != nil {
+		return fmt.Errorf("failed to open disk: %w", err)
+	}
+	if err := disk.Revert(last.Changes); err != nil {
+		return fmt.Errorf("failed to undo %s: %w", last.Operation, err)
+	}
+	if err := stdio.SaveDiskWithOptions(disk, diskPath, &diskimg.SaveOptions{Backup: opts.Backup}); err != nil {
+		return fmt.Errorf("failed to save disk: %w", err)
+	}
+	if err := diskimg.WriteJournal(journal, entries[:len(entries)-1]); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+
+	if !opts.Quiet {
+		fmt.Printf("Undid %s on %s\n", last.Operation, filepath.Base(diskPath))
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+// file: cmd/web/web.go
+
+package web
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"image/png"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/ha1tch/plus3/internal/stdio"
+	"github.com/ha1tch/plus3/pkg/diskimg"
+)
+
+// WebOptions configures the web interface
+type WebOptions struct {
+	Listen string // Address to listen on, host:port
+	Quiet  bool   // Suppress non-error output
+}
+
+// DefaultWebOptions returns default options for Serve
+func DefaultWebOptions() *WebOptions {
+	return &WebOptions{
+		Listen: ":8080",
+		Quiet:  false,
+	}
+}
+
+// Serve runs a read-only web interface to the disk images under dir: it lists
+// them, shows their directories, previews SCREEN$ files and BASIC programs,
+// and downloads files. Images inside ZIP archives are included.
+func Serve(dir string, opts *WebOptions) error {
+	if opts == nil {
+		opts = DefaultWebOptions()
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	s := &server{root: dir}
+	if _, err := s.scan(); err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", s.index)
+	mux.HandleFunc("GET /disk", s.disk)
+	mux.HandleFunc("GET /file", s.file)
+	mux.HandleFunc("GET /screen", s.screen)
+	mux.HandleFunc("GET /basic", s.basic)
+
+	if !opts.Quiet {
+		fmt.Printf("Serving %d disk image(s) from %s on %s\n", len(s.images), dir, opts.Listen)
+	}
+	return http.ListenAndServe(opts.Listen, mux)
+}
+
+// server holds the collection being served. images is the result of the last
+// scan; only the images in it are opened.
+type server struct {
+	root   string
+	mu     sync.Mutex
+	images []string // paths relative to root; "a.zip:b.dsk" inside archives
+}
+
+// scan finds the disk images under the root, including those in ZIP
+// archives, and remembers them.
+func (s *server) scan() ([]string, error) {
+	fsys := os.DirFS(s.root)
+	images, err := diskimg.FindImages(fsys)
+	if err != nil {
+		return nil, err
+	}
+	err = fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.EqualFold(filepath.Ext(p), ".zip") {
+			return err
+		}
+		members, err := stdio.ArchiveImages(filepath.Join(s.root, p))
+		if err != nil {
+			return nil // not every ZIP holds disk images
+		}
+		for _, m := range members {
+			images = append(images, p+":"+m)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for disk images: %w", err)
+	}
+	slices.Sort(images)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.images = images
+	return images, nil
+}
+
+// load opens an image found by the last scan. Close it when done.
+func (s *server) load(image string) (*diskimg.DiskImage, error) {
+	s.mu.Lock()
+	known := slices.Contains(s.images, image)
+	s.mu.Unlock()
+	if !known {
+		return nil, fs.ErrNotExist
+	}
+	return stdio.OpenDisk(filepath.Join(s.root, image), nil)
+}
+
+// diskFile is a row of the directory page.
+type diskFile struct {
+	Name     string
+	Size     int
+	Type     string
+	Headered bool
+	Screen   bool
+	Basic    bool
+}
+
+func (s *server) index(w http.ResponseWriter, r *http.Request) {
+	images, err := s.scan()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	render(w, indexPage, struct {
+		Root   string
+		Images []string
+	}{s.root, images})
+}
+
+func (s *server) disk(w http.ResponseWriter, r *http.Request) {
+	image := r.URL.Query().Get("disk")
+	di, err := s.load(image)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	defer di.Close()
+	catalog, err := di.Catalog()
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	slices.SortFunc(catalog, func(a, b diskimg.FileInfo) int { return strings.Compare(a.Name, b.Name) })
+	var files []diskFile
+	for _, fi := range catalog {
+		f := diskFile{Name: fi.Name, Size: int(fi.Size), Type: "-"}
+		if fi.Headered() {
+			f.Headered = true
+			switch fi.HeaderType {
+			case diskimg.FileTypeProgram:
+				f.Type, f.Basic = "Program", true
+			case diskimg.FileTypeNumericArray:
+				f.Type = "Num array"
+			case diskimg.FileTypeCharArray:
+				f.Type = "Char array"
+			case diskimg.FileTypeCode:
+				f.Type, f.Screen = "Code", fi.DataLength == diskimg.ScreenSize
+			}
+		} else {
+			f.Screen = f.Size == diskimg.ScreenSize && strings.HasSuffix(f.Name, ".SCR")
+		}
+		files = append(files, f)
+	}
+	render(w, diskPage, struct {
+		Disk  string
+		Files []diskFile
+	}{image, files})
+}
+
+// file downloads a file, with its PLUS3DOS header unless strip is set.
+func (s *server) file(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	di, err := s.load(q.Get("disk"))
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	defer di.Close()
+	var fsys fs.FS = di
+	if q.Get("strip") != "" {
+		fsys = di.HeaderlessFS()
+	}
+	data, err := fs.ReadFile(fsys, q.Get("file"))
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", q.Get("file")))
+	w.Write(data)
+}
+
+// screen renders a SCREEN$ file as a PNG.
+func (s *server) screen(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	di, err := s.load(q.Get("disk"))
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	defer di.Close()
+	img, err := di.ReadScreen(q.Get("file"))
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	png.Encode(w, img)
+}
+
+// basic shows a BASIC program as a text listing.
+func (s *server) basic(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	di, err := s.load(q.Get("disk"))
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	defer di.Close()
+	text, err := di.ReadBasicText(q.Get("file"))
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, text)
+}
+
+// httpError reports err as 404 for a missing disk or file, 400 otherwise.
+func httpError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, diskimg.ErrFileNotFound) {
+		status = http.StatusNotFound
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func render(w http.ResponseWriter, t *template.Template, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := t.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+const pageHead = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{block "title" .}}{{end}} - plus3</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.2em 1em; text-align: left; }
+tr:nth-child(even) { background: #eee; }
+td.num { text-align: right; }
+img.screen { image-rendering: pixelated; width: 512px; }
+</style></head><body>
+`
+
+var indexPage = template.Must(template.New("index").Parse(pageHead + `{{define "title"}}Disk images{{end}}
+<h1>Disk images in {{.Root}}</h1>
+{{if .Images}}<ul>
+{{range .Images}}<li><a href="/disk?disk={{.}}">{{.}}</a></li>
+{{end}}</ul>{{else}}<p>No disk images found.</p>{{end}}
+</body></html>
+`))
+
+var diskPage = template.Must(template.New("disk").Parse(pageHead + `{{define "title"}}{{.Disk}}{{end}}
+<p><a href="/">All disk images</a></p>
+<h1>{{.Disk}}</h1>
+{{$disk := .Disk}}
+{{if .Files}}<table>
+<tr><th>Name</th><th>Type</th><th>Bytes</th><th></th></tr>
+{{range .Files}}<tr><td>{{.Name}}</td><td>{{.Type}}</td><td class="num">{{.Size}}</td><td>
+<a href="/file?disk={{$disk}}&amp;file={{.Name}}">download</a>
+{{if .Headered}}<a href="/file?disk={{$disk}}&amp;file={{.Name}}&amp;strip=1">without header</a>{{end}}
+{{if .Basic}}<a href="/basic?disk={{$disk}}&amp;file={{.Name}}">listing</a>{{end}}
+</td></tr>
+{{if .Screen}}<tr><td colspan="4"><img class="screen" src="/screen?disk={{$disk}}&amp;file={{.Name}}" alt="{{.Name}}"></td></tr>
+{{end}}{{end}}</table>{{else}}<p>The disk is empty.</p>{{end}}
+</body></html>
+`))
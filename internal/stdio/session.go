@@ -0,0 +1,137 @@
+// file: internal/stdio/session.go
+
+package stdio
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/ha1tch/plus3/pkg/diskimg"
+)
+
+// A session keeps the disk images a run of commands works on in memory
+// between them, as the batch and shell commands do: LoadDisk and OpenDisk
+// return the image already loaded from a path, and SaveDisk keeps the image
+// rather than write it. EndSession writes each changed image once, then the
+// journal entries held back until then. Standard input and output are never
+// kept. Images are keyed by sessionKey, so two names for one image share it.
+type session struct {
+	disks map[string]*sessionDisk
+	keys  []string // in the order first loaded or saved
+}
+
+// sessionDisk is a disk image kept by a session.
+type sessionDisk struct {
+	path    string // the path the image was first named by
+	disk    *diskimg.DiskImage
+	changed bool
+	backup  bool                   // a command asked for a .bak of the image
+	journal []diskimg.JournalEntry // entries to append once the image is saved
+	logged  int                    // the disk's changes already in journal
+}
+
+// current is the session in progress, if any.
+var current *session
+
+// BeginSession starts keeping disk images in memory between commands until
+// EndSession.
+func BeginSession() {
+	current = &session{disks: map[string]*sessionDisk{}}
+}
+
+// InSession reports whether a session is in progress.
+func InSession() bool {
+	return current != nil
+}
+
+// EndSession ends the session, first writing each image it changed and its
+// journal if save is set. An image that cannot be written does not stop the
+// others; the errors are joined.
+func EndSession(save bool) error {
+	s := current
+	current = nil
+	if s == nil || !save {
+		return nil
+	}
+	var errs []error
+	for _, key := range s.keys {
+		sd := s.disks[key]
+		if !sd.changed {
+			continue
+		}
+		path := sd.path
+		if err := SaveDiskWithOptions(sd.disk, path, &diskimg.SaveOptions{Backup: sd.backup}); err != nil {
+			errs = append(errs, fmt.Errorf("failed to save %s: %w", path, err))
+			continue
+		}
+		journal, _, _ := JournalPath(path)
+		for _, entry := range sd.journal {
+			if err := diskimg.AppendJournal(journal, entry); err != nil {
+				errs = append(errs, fmt.Errorf("failed to write journal: %w", err))
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Saves returns the paths of the images the session has changed, in the
+// order they were first loaded or saved.
+func Saves() []string {
+	if current == nil {
+		return nil
+	}
+	var paths []string
+	for _, key := range current.keys {
+		if sd := current.disks[key]; sd.changed {
+			paths = append(paths, sd.path)
+		}
+	}
+	return paths
+}
+
+// sessionKey returns the key a session keeps the image at path under: the
+// absolute, cleaned path of its host file followed by any partition or
+// archive member, so that "d.dsk" and "./d.dsk" name the same image.
+func sessionKey(path string) string {
+	file := path
+	if image, _, ok := SplitHDF(path); ok {
+		file = image
+	} else if archive, _, ok := SplitZip(path); ok {
+		file = archive
+	}
+	suffix := path[len(file):]
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	return file + suffix
+}
+
+// kept returns the image the session keeps for path, if there is one.
+func kept(path string) *sessionDisk {
+	if current == nil || IsStd(path) {
+		return nil
+	}
+	return current.disks[sessionKey(path)]
+}
+
+// keep adds the image at path to the session, if one is in progress.
+func keep(path string, disk *diskimg.DiskImage) *sessionDisk {
+	if current == nil || IsStd(path) {
+		return nil
+	}
+	key := sessionKey(path)
+	sd, ok := current.disks[key]
+	if !ok {
+		sd = &sessionDisk{path: path}
+		current.disks[key] = sd
+		current.keys = append(current.keys, key)
+	}
+	if sd.disk != disk {
+		sd.disk, sd.logged = disk, 0
+	}
+	slog.Debug("keeping disk image in the session", "path", path)
+	return sd
+}
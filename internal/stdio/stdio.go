@@ -0,0 +1,579 @@
+// Package stdio lets the commands accept "-" as a disk image path, meaning the
+// image is read from standard input or written to standard output. This makes
+// plus3 usable in shell pipelines, e.g. "plus3 create - | gzip > disk.dsk.gz".
+//
+// It also picks the image encoding: raw sector images are recognised by their
+// size when read, and written for paths ending in ".img"; HFE images are
+// written (but not read) for paths ending in ".hfe". TR-DOS .trd and .scl
+// images are converted file by file to and from a +3 disk, and Opus Discovery
+// images are read the same way. A partition of a +3e hard disk image is named
+// "card.hdf:NAME" and is read and written in place. A disk image inside a ZIP
+// archive is named "collection.zip:game.dsk" and can be read but not written.
+package stdio
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ha1tch/plus3/pkg/diskimg"
+)
+
+// Path is the disk image path that names standard input or output.
+const Path = "-"
+
+// MaxImageSize caps how much is read from standard input. A stream cannot be
+// sized up front, so without a cap a wrong pipe (a tape, a log) would be read
+// into memory in full. The largest extended .dsk (204 track entries of at most
+// 0xFF00 bytes) is a little under 13 MB.
+const MaxImageSize = 16 << 20
+
+// IsStd reports whether path names standard input or output.
+func IsStd(path string) bool {
+	return path == Path
+}
+
+// Exists reports whether a disk image path can be opened: standard input
+// always can, a host file must exist.
+func Exists(path string) error {
+	if IsStd(path) || kept(path) != nil {
+		return nil
+	}
+	if image, _, ok := SplitHDF(path); ok {
+		path = image
+	}
+	if archive, _, ok := SplitZip(path); ok {
+		path = archive
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return fmt.Errorf("disk image does not exist: %w", err)
+	}
+	return nil
+}
+
+// IsRaw reports whether path names a raw sector image, which is written
+// without a .dsk container.
+func IsRaw(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".img")
+}
+
+// SplitHDF splits a path naming a partition of a +3e hard disk image,
+// "card.hdf:NAME", into the image path and the partition name. The name may
+// be left out ("card.hdf") for the first +3DOS partition. ok is false for a
+// path that does not name an .hdf image.
+func SplitHDF(path string) (image, partition string, ok bool) {
+	image = path
+	if i := strings.LastIndexByte(path, ':'); i > 0 && strings.EqualFold(filepath.Ext(path[:i]), ".hdf") {
+		image, partition = path[:i], path[i+1:]
+	}
+	return image, partition, strings.EqualFold(filepath.Ext(image), ".hdf")
+}
+
+// SplitZip splits a path naming a disk image inside a ZIP archive,
+// "collection.zip:game.dsk", into the archive path and the member name. The
+// member may be left out if the archive holds a single disk image. ok is false
+// for a path that does not name a .zip archive.
+func SplitZip(path string) (archive, member string, ok bool) {
+	archive = path
+	if i := strings.Index(strings.ToLower(path), ".zip:"); i > 0 {
+		archive, member = path[:i+4], path[i+5:]
+	}
+	return archive, member, strings.EqualFold(filepath.Ext(archive), ".zip")
+}
+
+// ArchiveImages returns the disk images inside the ZIP archive at path.
+func ArchiveImages(path string) ([]string, error) {
+	archive, _, _ := SplitZip(path)
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer zr.Close()
+	return diskimg.FindImages(zr)
+}
+
+// readZipMember reads the disk image named by a "collection.zip:game.dsk"
+// path. The member is matched by its path in the archive or, failing that, by
+// its base name, ignoring case.
+func readZipMember(path string) ([]byte, error) {
+	archive, member, _ := SplitZip(path)
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer zr.Close()
+	images, err := diskimg.FindImages(zr)
+	if err != nil {
+		return nil, err
+	}
+	if member == "" {
+		if len(images) != 1 {
+			return nil, fmt.Errorf("%s holds %d disk images; name one as %s:NAME", archive, len(images), archive)
+		}
+		member = images[0]
+	}
+	var found []string
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if f.Name == member {
+			found = []string{f.Name}
+			break
+		}
+		if strings.EqualFold(f.Name, member) || strings.EqualFold(filepath.Base(f.Name), member) {
+			found = append(found, f.Name)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("%w: %s in %s", diskimg.ErrFileNotFound, member, archive)
+	case 1:
+		return fs.ReadFile(zr, found[0])
+	}
+	return nil, fmt.Errorf("%s matches %d files in %s; give its full path", member, len(found), archive)
+}
+
+// IsHFE reports whether path names an HFE image for HxC and Gotek drives.
+func IsHFE(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".hfe")
+}
+
+// IsTRDOS reports whether path names a TR-DOS .trd or .scl image.
+func IsTRDOS(path string) bool {
+	return trdosExt(path) != ""
+}
+
+// IsTZX reports whether path names a TZX tape image.
+func IsTZX(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".tzx")
+}
+
+// IsTAP reports whether path names a TAP tape image.
+func IsTAP(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".tap")
+}
+
+// IsWAV reports whether path names a WAV audio file.
+func IsWAV(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".wav")
+}
+
+// IsSnapshot reports whether path names a .z80 or .sna snapshot.
+func IsSnapshot(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".z80", ".sna":
+		return true
+	}
+	return false
+}
+
+// trdosExt returns the TR-DOS image type named by path's extension: "trd",
+// "scl" or "".
+func trdosExt(path string) string {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".trd", ".scl":
+		return ext[1:]
+	}
+	return ""
+}
+
+// LoadDisk loads a disk image from path, or from standard input if path is "-".
+// In a session, an image already loaded from path is returned as it is.
+func LoadDisk(path string, opts *diskimg.LoadOptions) (*diskimg.DiskImage, error) {
+	if sd := kept(path); sd != nil {
+		return sd.disk, nil
+	}
+	disk, err := loadDisk(path, opts)
+	if err == nil {
+		keep(path, disk)
+	}
+	return disk, err
+}
+
+// loadDisk is LoadDisk outside a session.
+func loadDisk(path string, opts *diskimg.LoadOptions) (*diskimg.DiskImage, error) {
+	if _, _, ok := SplitHDF(path); ok {
+		h, p, err := loadPartition(path)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("loading partition", "path", path)
+		return h.OpenPartition(p)
+	}
+	var data []byte
+	var err error
+	if _, _, ok := SplitZip(path); ok {
+		data, err = readZipMember(path)
+	} else if IsStd(path) {
+		data, err = ReadStdin()
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("loading disk image", "path", path, "bytes", len(data))
+	name := path
+	if _, member, ok := SplitZip(path); ok {
+		name = member
+	}
+	if IsSnapshot(name) {
+		return fromSnapshot(data, strings.TrimSuffix(filepath.Base(name), filepath.Ext(name)))
+	}
+	return Decode(data, opts)
+}
+
+// OpenDisk is LoadDisk for a command that only reads the disk: a .dsk file is
+// mapped read-only with diskimg.OpenMapped, so only the tracks used are read
+// from it, straight from the page cache. Close the disk when done with it. In a
+// session the image is loaded, and kept, as LoadDisk does.
+func OpenDisk(path string, opts *diskimg.LoadOptions) (*diskimg.DiskImage, error) {
+	_, _, hdf := SplitHDF(path)
+	_, _, zip := SplitZip(path)
+	if IsStd(path) || hdf || zip || InSession() || !isDSK(path) {
+		return LoadDisk(path, opts)
+	}
+	slog.Info("mapping disk image", "path", path)
+	return diskimg.OpenMapped(path, false, opts)
+}
+
+// isDSK reports whether the file at path starts with a .dsk container
+// signature.
+func isDSK(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	sig := make([]byte, 8)
+	if _, err := io.ReadFull(f, sig); err != nil {
+		return false
+	}
+	return string(sig) == "MV - CPC" || string(sig) == "EXTENDED"
+}
+
+// Decode loads a disk image from its bytes: a .dsk container, or a raw sector
+// image if the data has no container signature and the size of one. Opus
+// Discovery and TR-DOS disks and TZX tapes are read as +3 disks holding their
+// files.
+func Decode(data []byte, opts *diskimg.LoadOptions) (*diskimg.DiskImage, error) {
+	if bytes.HasPrefix(data, []byte("HXCPICFE")) {
+		return nil, fmt.Errorf("%w: HFE images can be written but not read", diskimg.ErrCorruptImage)
+	}
+	if bytes.HasPrefix(data, []byte("SINCLAIR")) {
+		t, err := diskimg.LoadSCL(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return fromTRDOS(t)
+	}
+	if bytes.HasPrefix(data, []byte("ZXTape!")) {
+		files, err := diskimg.LoadTZX(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return fromTape(files)
+	}
+	dsk := bytes.HasPrefix(data, []byte("MV - CPC")) || bytes.HasPrefix(data, []byte("EXTENDED"))
+	if !dsk {
+		if diskimg.IsOpusImage(data) {
+			o, err := diskimg.LoadOpus(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			return fromOpus(o)
+		}
+		if _, err := diskimg.RawSpec(data); err == nil {
+			return diskimg.LoadRaw(bytes.NewReader(data))
+		}
+		if t, err := diskimg.LoadTRD(bytes.NewReader(data)); err == nil {
+			return fromTRDOS(t)
+		}
+	}
+	return diskimg.LoadWithOptions(bytes.NewReader(data), opts)
+}
+
+// fromTRDOS copies the files of a TR-DOS disk onto a new +3 disk.
+func fromTRDOS(t *diskimg.TRDOSImage) (*diskimg.DiskImage, error) {
+	sizes := make([]int, len(t.Files))
+	for i := range t.Files {
+		sizes[i] = len(t.Files[i].Contents())
+	}
+	disk, err := diskimg.NewDiskImageWithSpec(specFor(sizes))
+	if err != nil {
+		return nil, err
+	}
+	if err := disk.ImportTRDOS(t); err != nil {
+		return nil, err
+	}
+	return disk, nil
+}
+
+// fromOpus copies the files of an Opus Discovery disk onto a new +3 disk.
+func fromOpus(o *diskimg.OpusImage) (*diskimg.DiskImage, error) {
+	sizes := make([]int, len(o.Files))
+	for i := range o.Files {
+		sizes[i] = len(o.Files[i].Data)
+	}
+	disk, err := diskimg.NewDiskImageWithSpec(specFor(sizes))
+	if err != nil {
+		return nil, err
+	}
+	if err := disk.ImportOpus(o); err != nil {
+		return nil, err
+	}
+	return disk, nil
+}
+
+// fromTape copies the files of a tape onto a new +3 disk.
+func fromTape(files []diskimg.TapeFile) (*diskimg.DiskImage, error) {
+	sizes := make([]int, len(files))
+	for i := range files {
+		sizes[i] = len(files[i].Data)
+	}
+	disk, err := diskimg.NewDiskImageWithSpec(specFor(sizes))
+	if err != nil {
+		return nil, err
+	}
+	if err := disk.ImportTape(files); err != nil {
+		return nil, err
+	}
+	return disk, nil
+}
+
+// fromSnapshot writes a snapshot onto a new +3 disk as a program that loads
+// it, named after name.
+func fromSnapshot(data []byte, name string) (*diskimg.DiskImage, error) {
+	disk, err := diskimg.NewDiskImageWithSpec(diskimg.SpecPlus3)
+	if err != nil {
+		return nil, err
+	}
+	if err := disk.ImportSnapshot(bytes.NewReader(data), &diskimg.SnapshotOptions{Name: name}); err != nil {
+		return nil, err
+	}
+	return disk, nil
+}
+
+// specFor returns the +3 format for files of the given sizes: 720K if they do
+// not fit on a 180K disk.
+func specFor(sizes []int) diskimg.DiskSpec {
+	spec := diskimg.SpecPlus3
+	total := 0
+	for _, n := range sizes {
+		// Round each file, with its +3DOS header, up to whole blocks.
+		total += (n + diskimg.HeaderSize + spec.BlockSize - 1) / spec.BlockSize * spec.BlockSize
+	}
+	if total > (spec.TotalBlocks()-spec.DirBlocks)*spec.BlockSize || len(sizes) > spec.DirEntries() {
+		return diskimg.SpecPlus3DS
+	}
+	return spec
+}
+
+// Encode serialises a disk image for path: a raw sector image for a ".img"
+// path, an HFE image for a ".hfe" path, a TR-DOS image of the disk's files for
+// a ".trd" or ".scl" path, a TZX or TAP tape of its headered files for a
+// ".tzx" or ".tap" path, the audio of that tape for a ".wav" path, otherwise a
+// .dsk in the image's container.
+func Encode(disk *diskimg.DiskImage, path string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch {
+	case IsRaw(path):
+		err = disk.SaveRaw(&buf)
+	case IsHFE(path):
+		err = disk.SaveHFE(&buf)
+	case IsTRDOS(path):
+		var t *diskimg.TRDOSImage
+		if t, err = disk.ExportTRDOS(); err != nil {
+			break
+		}
+		if trdosExt(path) == "scl" {
+			err = t.SaveSCL(&buf)
+		} else {
+			err = t.SaveTRD(&buf)
+		}
+	case IsTZX(path):
+		var files []diskimg.TapeFile
+		if files, err = disk.ExportTape(); err == nil {
+			err = diskimg.SaveTZX(&buf, files)
+		}
+	case IsTAP(path):
+		err = disk.ExportAllToTAP(&buf)
+	case IsWAV(path):
+		var files []diskimg.TapeFile
+		if files, err = disk.ExportTape(); err == nil {
+			err = diskimg.SaveWAV(&buf, files, nil)
+		}
+	default:
+		err = disk.Save(&buf)
+	}
+	return buf.Bytes(), err
+}
+
+// ReadStdin reads a whole disk image from standard input, refusing a terminal,
+// an empty stream, and anything larger than MaxImageSize.
+func ReadStdin() ([]byte, error) {
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		return nil, errors.New("standard input is a terminal; pipe a disk image in or give a file path")
+	}
+	data, err := io.ReadAll(io.LimitReader(os.Stdin, MaxImageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read standard input: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, errors.New("no disk image on standard input")
+	}
+	if len(data) > MaxImageSize {
+		return nil, fmt.Errorf("standard input exceeds %d bytes; not a disk image", MaxImageSize)
+	}
+	return data, nil
+}
+
+// SaveDisk writes a disk image to path, or to standard output if path is "-".
+// A ".img" path gets a raw sector image and a ".hfe" path an HFE image. Files
+// are replaced atomically, so a failed save leaves the old image intact.
+func SaveDisk(disk *diskimg.DiskImage, path string) error {
+	return SaveDiskWithOptions(disk, path, nil)
+}
+
+// SaveDiskWithOptions is SaveDisk with options; opts.Backup keeps the image
+// being replaced (the whole .hdf file for a partition) as a .bak file. In a
+// session the image is kept to be written by EndSession.
+func SaveDiskWithOptions(disk *diskimg.DiskImage, path string, opts *diskimg.SaveOptions) error {
+	if _, _, ok := SplitZip(path); ok {
+		return fmt.Errorf("%w: disk images inside a ZIP archive cannot be written", diskimg.ErrReadOnly)
+	}
+	if sd := keep(path, disk); sd != nil {
+		sd.changed = true
+		sd.backup = sd.backup || opts != nil && opts.Backup
+		return nil
+	}
+	if image, _, ok := SplitHDF(path); ok {
+		// The partition is written back into the rest of the image.
+		h, p, err := loadPartition(path)
+		if err != nil {
+			return err
+		}
+		if err := h.StorePartition(p, disk); err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := h.Save(&buf); err != nil {
+			return err
+		}
+		slog.Info("saving partition", "path", path, "bytes", buf.Len())
+		return diskimg.WriteFileAtomic(image, buf.Bytes(), opts)
+	}
+	// Serialise first so a failure does not leave half an image behind.
+	data, err := Encode(disk, path)
+	if err != nil {
+		return err
+	}
+	slog.Info("saving disk image", "path", path, "bytes", len(data))
+	if IsStd(path) {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return diskimg.WriteFileAtomic(path, data, opts)
+}
+
+// JournalPath returns the journal of the disk image at path: <image>.journal
+// beside the host file, which for a +3e partition is the .hdf file's journal,
+// and the partition's name. ok is false for standard input and for images in
+// ZIP archives, which have no journal.
+func JournalPath(path string) (journal, partition string, ok bool) {
+	if IsStd(path) {
+		return "", "", false
+	}
+	if image, partition, ok := SplitHDF(path); ok {
+		return image + ".journal", partition, true
+	}
+	if _, _, ok := SplitZip(path); ok {
+		return "", "", false
+	}
+	return path + ".journal", "", true
+}
+
+// StartJournal starts recording the changes to disk, loaded from path, if
+// they are to be journaled: when enable is set, or when the image already has
+// a journal.
+func StartJournal(disk *diskimg.DiskImage, path string, enable bool) error {
+	journal, _, ok := JournalPath(path)
+	if !ok {
+		if enable {
+			return fmt.Errorf("%s cannot have a journal", path)
+		}
+		return nil
+	}
+	if _, err := os.Stat(journal); enable || err == nil {
+		disk.RecordChanges()
+	}
+	return nil
+}
+
+// Journal appends the changes recorded on disk, saved to path, to its journal
+// as operation. It does nothing if no changes were recorded. In a session the
+// entry, of the changes since the last, is held back until EndSession saves
+// the image.
+func Journal(disk *diskimg.DiskImage, path, operation string) error {
+	changes := disk.Changes()
+	sd := kept(path)
+	if sd != nil {
+		changes = slices.Clone(changes[min(sd.logged, len(changes)):])
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	journal, partition, _ := JournalPath(path)
+	entry := diskimg.JournalEntry{
+		Time:      time.Now().UTC(),
+		Operation: operation,
+		Partition: partition,
+		Changes:   changes,
+	}
+	if sd != nil {
+		sd.journal = append(sd.journal, entry)
+		sd.logged += len(changes)
+		return nil
+	}
+	if err := diskimg.AppendJournal(journal, entry); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	return nil
+}
+
+// loadPartition reads the .hdf image named by path and finds the partition
+// it names; see SplitHDF.
+func loadPartition(path string) (*diskimg.HDFImage, diskimg.Partition, error) {
+	image, name, _ := SplitHDF(path)
+	f, err := os.Open(image)
+	if err != nil {
+		return nil, diskimg.Partition{}, err
+	}
+	defer f.Close()
+	h, err := diskimg.LoadHDF(f)
+	if err != nil {
+		return nil, diskimg.Partition{}, err
+	}
+	p, err := h.FindPartition(name)
+	return h, p, err
+}
+
+// Status returns where a command's progress messages should go: standard
+// error when the disk image itself is being written to standard output.
+func Status(path string) io.Writer {
+	if IsStd(path) {
+		return os.Stderr
+	}
+	return os.Stdout
+}
@@ -3,36 +3,48 @@
 package diskimg
 
 import (
-	"errors"
 	"fmt"
-
-	"github.com/ha1tch/plus3/internal"
+	"io/fs"
 )
 
 // SectorAllocation tracks the allocation status of disk sectors
 type SectorAllocation struct {
-	allocated []bool // true if sector is allocated
-	sectorMap *internal.SectorMap
+	allocated []bool   // true if sector is allocated
+	spec      DiskSpec // geometry the sectors are numbered in
 }
 
-// newSectorAllocation creates a new sector allocation tracker
-func newSectorAllocation(totalSectors int) *SectorAllocation {
+// newSectorAllocation creates a new sector allocation tracker for the
+// geometry of spec
+func newSectorAllocation(spec DiskSpec) *SectorAllocation {
 	return &SectorAllocation{
-		allocated: make([]bool, totalSectors),
-		sectorMap: internal.NewSectorMap(),
+		allocated: make([]bool, spec.TracksPerSide*spec.SectorsPerTrack*spec.Sides),
+		spec:      spec,
+	}
+}
+
+// trackBounds returns the first and last sector numbers of a track; the
+// sectors of side 0 are numbered before those of side 1
+func (sa *SectorAllocation) trackBounds(track, side int) (start, end int, err error) {
+	if track < 0 || track >= sa.spec.TracksPerSide {
+		return 0, 0, fmt.Errorf("%w: %d out of range (0-%d)", ErrInvalidTrack, track, sa.spec.TracksPerSide-1)
+	}
+	if side < 0 || side >= sa.spec.Sides {
+		return 0, 0, fmt.Errorf("%w: %d out of range (0-%d)", ErrInvalidSide, side, sa.spec.Sides-1)
 	}
+	start = (side*sa.spec.TracksPerSide + track) * sa.spec.SectorsPerTrack
+	return start, start + sa.spec.SectorsPerTrack - 1, nil
 }
 
 // AllocateSectors marks a range of sectors as allocated
 func (sa *SectorAllocation) AllocateSectors(start, count int) error {
 	if start < 0 || start+count > len(sa.allocated) {
-		return errors.New("sector range out of bounds")
+		return fmt.Errorf("%w: sector range %d+%d out of bounds", ErrInvalidSector, start, count)
 	}
 
 	// Check if any sectors in range are already allocated
 	for i := start; i < start+count; i++ {
 		if sa.allocated[i] {
-			return fmt.Errorf("sector %d already allocated", i)
+			return fmt.Errorf("%w: sector %d already allocated", ErrCorruptImage, i)
 		}
 	}
 
@@ -47,7 +59,7 @@ func (sa *SectorAllocation) AllocateSectors(start, count int) error {
 // FreeSectors marks a range of sectors as free
 func (sa *SectorAllocation) FreeSectors(start, count int) error {
 	if start < 0 || start+count > len(sa.allocated) {
-		return errors.New("sector range out of bounds")
+		return fmt.Errorf("%w: sector range %d+%d out of bounds", ErrInvalidSector, start, count)
 	}
 
 	for i := start; i < start+count; i++ {
@@ -60,10 +72,10 @@ func (sa *SectorAllocation) FreeSectors(start, count int) error {
 // FindFreeSectors looks for a contiguous range of free sectors
 func (sa *SectorAllocation) FindFreeSectors(count int) (int, error) {
 	if count <= 0 {
-		return 0, errors.New("invalid sector count requested")
+		return 0, fmt.Errorf("%w: invalid sector count %d requested", fs.ErrInvalid, count)
 	}
 	if count > len(sa.allocated) {
-		return 0, errors.New("requested sector count exceeds disk size")
+		return 0, fmt.Errorf("%w: %d sectors requested", ErrDiskFull, count)
 	}
 
 	start := 0
@@ -83,13 +95,13 @@ func (sa *SectorAllocation) FindFreeSectors(count int) (int, error) {
 		}
 	}
 
-	return 0, errors.New("not enough contiguous free sectors available")
+	return 0, fmt.Errorf("%w: not enough contiguous free sectors", ErrDiskFull)
 }
 
 // IsSectorAllocated checks if a specific sector is allocated
 func (sa *SectorAllocation) IsSectorAllocated(sector int) (bool, error) {
 	if sector < 0 || sector >= len(sa.allocated) {
-		return false, errors.New("sector number out of range")
+		return false, fmt.Errorf("%w: %d", ErrInvalidSector, sector)
 	}
 	return sa.allocated[sector], nil
 }
@@ -107,7 +119,7 @@ func (sa *SectorAllocation) GetFreeSpace() int {
 
 // GetTrackAllocation returns allocation status for all sectors in a track
 func (sa *SectorAllocation) GetTrackAllocation(track, side int) ([]bool, error) {
-	start, end, err := sa.sectorMap.GetTrackBounds(track, side)
+	start, end, err := sa.trackBounds(track, side)
 	if err != nil {
 		return nil, err
 	}
@@ -122,7 +134,7 @@ func (sa *SectorAllocation) GetTrackAllocation(track, side int) ([]bool, error)
 
 // AllocateTrack marks all sectors in a track as allocated
 func (sa *SectorAllocation) AllocateTrack(track, side int) error {
-	start, end, err := sa.sectorMap.GetTrackBounds(track, side)
+	start, end, err := sa.trackBounds(track, side)
 	if err != nil {
 		return err
 	}
@@ -132,7 +144,7 @@ func (sa *SectorAllocation) AllocateTrack(track, side int) error {
 
 // FreeTrack marks all sectors in a track as free
 func (sa *SectorAllocation) FreeTrack(track, side int) error {
-	start, end, err := sa.sectorMap.GetTrackBounds(track, side)
+	start, end, err := sa.trackBounds(track, side)
 	if err != nil {
 		return err
 	}
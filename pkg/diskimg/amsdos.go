@@ -0,0 +1,185 @@
+// file: pkg/diskimg/amsdos.go
+
+package diskimg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// AMSDOS, the CPC's disk system, puts a 128-byte header of its own in front
+// of BASIC and binary files, where +3DOS puts a PLUS3DOS one. Its file types
+// are in bits 1-3 of the type byte; bit 0 marks a protected file.
+const (
+	AmsdosTypeBasic  = 0
+	AmsdosTypeBinary = 2
+	AmsdosTypeScreen = 4
+	AmsdosTypeASCII  = 6
+)
+
+// AmsdosHeader is the part of an AMSDOS header that describes the file.
+type AmsdosHeader struct {
+	User      byte
+	Name      string // 8.3, as GAME.BIN
+	Type      byte   // AmsdosTypeBinary, ...; bit 0 set if protected
+	LoadAddr  uint16
+	EntryAddr uint16
+	Length    int // of the data after the header
+}
+
+// amsdosChecksum returns the sum of the header's first 67 bytes, which
+// AMSDOS stores after them.
+func amsdosChecksum(h []byte) uint16 {
+	var sum uint16
+	for _, b := range h[:0x43] {
+		sum += uint16(b)
+	}
+	return sum
+}
+
+// ParseAmsdosHeader reads the AMSDOS header at the start of data. Data that
+// does not start with one, as its checksum tells, returns ErrInvalidHeader.
+func ParseAmsdosHeader(data []byte) (*AmsdosHeader, error) {
+	if len(data) < HeaderSize {
+		return nil, fmt.Errorf("%w: data too short for an AMSDOS header", ErrInvalidHeader)
+	}
+	le := binary.LittleEndian
+	sum := amsdosChecksum(data)
+	if sum == 0 || le.Uint16(data[0x43:]) != sum {
+		return nil, fmt.Errorf("%w: no AMSDOS header", ErrInvalidHeader)
+	}
+	name := strings.TrimRight(string(data[1:9]), " ")
+	if ext := strings.TrimRight(string(data[9:12]), " "); ext != "" {
+		name += "." + ext
+	}
+	return &AmsdosHeader{
+		User:      data[0],
+		Name:      name,
+		Type:      data[0x12],
+		LoadAddr:  le.Uint16(data[0x15:]),
+		EntryAddr: le.Uint16(data[0x1A:]),
+		Length:    int(data[0x40]) | int(data[0x41])<<8 | int(data[0x42])<<16,
+	}, nil
+}
+
+// Bytes returns the header as AMSDOS writes it.
+func (h *AmsdosHeader) Bytes() []byte {
+	le := binary.LittleEndian
+	b := make([]byte, HeaderSize)
+	b[0] = h.User
+	name, ext, _ := strings.Cut(strings.ToUpper(h.Name), ".")
+	copy(b[1:12], fmt.Sprintf("%-8.8s%-3.3s", name, ext))
+	b[0x12] = h.Type
+	le.PutUint16(b[0x15:], h.LoadAddr)
+	b[0x17] = 0xFF // first block
+	le.PutUint16(b[0x18:], uint16(h.Length))
+	le.PutUint16(b[0x1A:], h.EntryAddr)
+	b[0x40], b[0x41], b[0x42] = byte(h.Length), byte(h.Length>>8), byte(h.Length>>16)
+	le.PutUint16(b[0x43:], amsdosChecksum(b))
+	return b
+}
+
+// isCode reports whether the file is binary or a screen, which +3 BASIC
+// loads as CODE. Locomotive BASIC and text have no +3 counterpart.
+func (h *AmsdosHeader) isCode() bool {
+	t := h.Type &^ 1
+	return t == AmsdosTypeBinary || t == AmsdosTypeScreen
+}
+
+// AmsdosToPlus3 returns data, a file that starts with an AMSDOS header, with
+// a PLUS3DOS header in its place: a binary or screen file becomes CODE loading
+// at the same address, its entry address kept as the header's second
+// parameter. Other data, Locomotive BASIC and text included, is returned as
+// it is.
+func AmsdosToPlus3(data []byte) []byte {
+	h, err := ParseAmsdosHeader(data)
+	if err != nil || !h.isCode() {
+		return data
+	}
+	body := data[HeaderSize:]
+	if h.Length < len(body) {
+		body = body[:h.Length]
+	}
+	header, err := importHeader(len(body), &ImportOptions{FileType: FileTypeCode, LoadAddr: h.LoadAddr})
+	if err != nil {
+		return data
+	}
+	binary.LittleEndian.PutUint16(header.HeaderData[5:7], h.EntryAddr)
+	header.UpdateChecksum()
+	return append(header.toBytes(), body...)
+}
+
+// Plus3ToAmsdos returns data, a file that starts with a PLUS3DOS header, with
+// an AMSDOS header for name in its place, undoing AmsdosToPlus3: CODE becomes
+// a binary file loading at the same address and entered at the header's
+// second parameter, or at the load address if that is +3DOS's usual 32768.
+// Other data, +3 BASIC and arrays included, is returned as it is.
+func Plus3ToAmsdos(name string, data []byte) []byte {
+	if len(data) < HeaderSize {
+		return data
+	}
+	h := &Plus3DosHeader{}
+	if h.FromBytes(data) != nil || h.Validate() != nil {
+		return data
+	}
+	fileType, length, loadAddr, _ := h.GetBasicHeader()
+	if fileType != FileTypeCode {
+		return data
+	}
+	body := data[HeaderSize:]
+	if int(length) < len(body) {
+		body = body[:length]
+	}
+	entry := binary.LittleEndian.Uint16(h.HeaderData[5:7])
+	if entry == 0x8000 {
+		entry = loadAddr
+	}
+	a := &AmsdosHeader{Name: name, Type: AmsdosTypeBinary, LoadAddr: loadAddr, EntryAddr: entry, Length: len(body)}
+	return append(a.Bytes(), body...)
+}
+
+// convertHeader returns the data of a file moving from a disk of format from
+// to one of format to with its header converted, if one format is a CPC
+// format and the other not.
+func convertHeader(from, to DiskSpec, name string, data []byte) []byte {
+	switch {
+	case from.isCPC() == to.isCPC():
+		return data
+	case to.isCPC():
+		return Plus3ToAmsdos(name, data)
+	}
+	return AmsdosToPlus3(data)
+}
+
+// ImportAmsdos stores a host file that starts with an AMSDOS header under its
+// host name (8.3, upper-cased), with the header converted by AmsdosToPlus3
+// unless the disk is in a CPC format.
+func (di *DiskImage) ImportAmsdos(hostPath string) error {
+	data, err := os.ReadFile(hostPath)
+	if err != nil {
+		return err
+	}
+	if !di.spec.isCPC() {
+		data = AmsdosToPlus3(data)
+	}
+	return di.ImportData(HostName(hostPath, ""), data, nil)
+}
+
+// ExportAmsdos writes a file on the disk to the host with an AMSDOS header,
+// converted by Plus3ToAmsdos from a PLUS3DOS one, for use on a CPC or in its
+// emulators. A file without a header AMSDOS can take is written as it is.
+func (di *DiskImage) ExportAmsdos(diskPath, hostPath string) error {
+	f, err := di.OpenFile(diskPath, os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, f.size)
+	n, err := f.ReadAt(data, 0)
+	f.Close()
+	if n < len(data) {
+		return fileError("export", diskPath, err)
+	}
+	return os.WriteFile(hostPath, Plus3ToAmsdos(f.entry.GetFilename(), data), 0644)
+}
@@ -0,0 +1,115 @@
+package diskimg
+
+import (
+	"bytes"
+	"io/fs"
+	"testing"
+)
+
+// sampleAmsdos returns a binary file as a CPC stores it: an AMSDOS header,
+// then the data padded to a whole record.
+func sampleAmsdos(body []byte) []byte {
+	h := &AmsdosHeader{Name: "GAME.BIN", Type: AmsdosTypeBinary, LoadAddr: 0x4000, EntryAddr: 0x4010, Length: len(body)}
+	data := append(h.Bytes(), body...)
+	for len(data)%128 != 0 {
+		data = append(data, 0x1A)
+	}
+	return data
+}
+
+func TestAmsdosHeader(t *testing.T) {
+	data := sampleAmsdos([]byte("code"))
+	if sum := uint16(data[0x43]) | uint16(data[0x44])<<8; sum != amsdosChecksum(data) {
+		t.Errorf("checksum %#04x, want %#04x", sum, amsdosChecksum(data))
+	}
+	if string(data[1:12]) != "GAME    BIN" || data[0x17] != 0xFF {
+		t.Errorf("name %q, first block flag %#02x", data[1:12], data[0x17])
+	}
+	h, err := ParseAmsdosHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := AmsdosHeader{Name: "GAME.BIN", Type: AmsdosTypeBinary, LoadAddr: 0x4000, EntryAddr: 0x4010, Length: 4}
+	if *h != want {
+		t.Errorf("ParseAmsdosHeader = %+v, want %+v", *h, want)
+	}
+
+	if _, err := ParseAmsdosHeader(make([]byte, 128)); err == nil {
+		t.Error("zeros parsed as an AMSDOS header")
+	}
+	plus3, _ := importHeader(4, &ImportOptions{FileType: FileTypeCode, LoadAddr: 0x4000})
+	if _, err := ParseAmsdosHeader(append(plus3.toBytes(), "code"...)); err == nil {
+		t.Error("a PLUS3DOS header parsed as an AMSDOS one")
+	}
+}
+
+// A binary file keeps its load and entry addresses through AmsdosToPlus3 and
+// back; BASIC is left alone.
+func TestAmsdosPlus3RoundTrip(t *testing.T) {
+	body := []byte{0xF3, 0xC3, 0x10, 0x40}
+	p := AmsdosToPlus3(sampleAmsdos(body))
+	h := &Plus3DosHeader{}
+	if err := h.FromBytes(p); err != nil || h.Validate() != nil {
+		t.Fatalf("no valid PLUS3DOS header: %v", err)
+	}
+	if ft, length, load, _ := h.GetBasicHeader(); ft != FileTypeCode || length != 4 || load != 0x4000 {
+		t.Errorf("header type %d, length %d, load address %#x", ft, length, load)
+	}
+	if entry := uint16(h.HeaderData[5]) | uint16(h.HeaderData[6])<<8; entry != 0x4010 {
+		t.Errorf("entry address %#x, want 0x4010", entry)
+	}
+	if !bytes.Equal(p[HeaderSize:], body) {
+		t.Errorf("body %x, want %x", p[HeaderSize:], body)
+	}
+
+	back := Plus3ToAmsdos("GAME.BIN", p)
+	if !bytes.Equal(back, sampleAmsdos(body)[:HeaderSize+len(body)]) {
+		t.Errorf("back to AMSDOS:\n%x\nwant\n%x", back[:0x45], sampleAmsdos(body)[:0x45])
+	}
+
+	basic := &AmsdosHeader{Name: "DISC.BAS", Type: AmsdosTypeBasic, LoadAddr: 0x170, Length: 3}
+	data := append(basic.Bytes(), 1, 2, 3)
+	if got := AmsdosToPlus3(data); !bytes.Equal(got, data) {
+		t.Error("Locomotive BASIC was converted")
+	}
+}
+
+// Copying between a CPC disk and a +3 one converts the header; copying
+// between +3 disks does not.
+func TestCopyFileConvertsAmsdos(t *testing.T) {
+	cpc := newSpecImage(t, SpecCPCData)
+	plus3 := newSpecImage(t, SpecPlus3)
+	body := bytes.Repeat([]byte{0x55}, 200)
+	if err := cpc.WriteFile("GAME.BIN", sampleAmsdos(body)); err != nil {
+		t.Fatal(err)
+	}
+	if err := CopyFile(cpc, plus3, "GAME.BIN", nil); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := plus3.StatFile("GAME.BIN")
+	if err != nil || !fi.Headered() || fi.LoadAddress != 0x4000 || fi.Size != int64(HeaderSize+len(body)) {
+		t.Fatalf("on the +3 disk: %+v, %v", fi, err)
+	}
+
+	if err := CopyFile(plus3, cpc, "GAME.BIN", &CopyOptions{NewName: "BACK.BIN"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := fs.ReadFile(cpc, "BACK.BIN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := ParseAmsdosHeader(data)
+	if err != nil || h.LoadAddr != 0x4000 || h.EntryAddr != 0x4010 || h.Name != "BACK.BIN" {
+		t.Fatalf("on the CPC disk: %+v, %v", h, err)
+	}
+
+	other := newSpecImage(t, SpecPlus3)
+	if err := CopyFile(plus3, other, "GAME.BIN", nil); err != nil {
+		t.Fatal(err)
+	}
+	a, _ := fs.ReadFile(plus3, "GAME.BIN")
+	b, _ := fs.ReadFile(other, "GAME.BIN")
+	if !bytes.Equal(a, b) {
+		t.Error("copy between +3 disks changed the file")
+	}
+}
@@ -0,0 +1,440 @@
+// file: pkg/diskimg/array.go
+
+package diskimg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Array is a BASIC array as SAVE "name" DATA a() stores it.
+type Array struct {
+	Name    string    // "a" for a numeric array, "a$" for a character array
+	Dims    []int     // the size of each dimension; for a$ the last is the string length
+	Numbers []float64 // a numeric array's elements, the last subscript varying fastest
+	Chars   []byte    // a character array's elements, in the same order
+}
+
+// IsString reports whether a is a character array.
+func (a *Array) IsString() bool {
+	return strings.HasSuffix(a.Name, "$")
+}
+
+// FileType returns FileTypeCharArray or FileTypeNumericArray.
+func (a *Array) FileType() byte {
+	if a.IsString() {
+		return FileTypeCharArray
+	}
+	return FileTypeNumericArray
+}
+
+// varByte returns the name as the header stores it: the letter's low five
+// bits, with 0x80 set for an array and 0x40 for a character array.
+func (a *Array) varByte() (byte, error) {
+	letter := strings.TrimSuffix(strings.ToLower(a.Name), "$")
+	if len(letter) != 1 || letter[0] < 'a' || letter[0] > 'z' {
+		return 0, fmt.Errorf("%w: array name %q: want a letter, as a or a$", ErrUnsupported, a.Name)
+	}
+	b := 0x80 | letter[0]&0x1F
+	if a.IsString() {
+		b |= 0x40
+	}
+	return b, nil
+}
+
+// arrayName returns the name a header's variable byte stands for.
+func arrayName(fileType, b byte) string {
+	name := string(rune(0x60 | b&0x1F))
+	if fileType == FileTypeCharArray {
+		name += "$"
+	}
+	return name
+}
+
+// Encode returns the array's bytes as SAVE DATA writes them: the number of
+// dimensions, each size as a 16-bit word, then the elements, five bytes each
+// for a numeric array.
+func (a *Array) Encode() ([]byte, error) {
+	if _, err := a.varByte(); err != nil {
+		return nil, err
+	}
+	if len(a.Dims) == 0 || len(a.Dims) > 255 {
+		return nil, fmt.Errorf("%w: array %s has %d dimensions", ErrUnsupported, a.Name, len(a.Dims))
+	}
+	count := 1
+	data := []byte{byte(len(a.Dims))}
+	for _, d := range a.Dims {
+		if d < 1 || d > 0xFFFF {
+			return nil, fmt.Errorf("%w: array %s: dimension of %d", ErrUnsupported, a.Name, d)
+		}
+		count *= d
+		if count > 0xFFFF {
+			return nil, fmt.Errorf("%w: array %s is too large", ErrFileTooLarge, a.Name)
+		}
+		data = binary.LittleEndian.AppendUint16(data, uint16(d))
+	}
+
+	if a.IsString() {
+		if len(a.Chars) != count {
+			return nil, fmt.Errorf("array %s: %d characters for %d elements", a.Name, len(a.Chars), count)
+		}
+		data = append(data, a.Chars...)
+	} else {
+		if len(a.Numbers) != count {
+			return nil, fmt.Errorf("array %s: %d numbers for %d elements", a.Name, len(a.Numbers), count)
+		}
+		for _, v := range a.Numbers {
+			f, err := EncodeFloat(v)
+			if err != nil {
+				return nil, fmt.Errorf("array %s: %w", a.Name, err)
+			}
+			data = append(data, f[:]...)
+		}
+	}
+	if len(data) > 0xFFFF {
+		return nil, fmt.Errorf("%w: array %s is %d bytes", ErrFileTooLarge, a.Name, len(data))
+	}
+	return data, nil
+}
+
+// DecodeArray decodes the bytes of a saved array, as Encode writes them.
+// fileType and varByte are the header's file type and variable name.
+func DecodeArray(data []byte, fileType, varByte byte) (*Array, error) {
+	if fileType != FileTypeNumericArray && fileType != FileTypeCharArray {
+		return nil, fmt.Errorf("%w: file type %d is not an array", ErrWrongFileType, fileType)
+	}
+	a := &Array{Name: arrayName(fileType, varByte)}
+	if len(data) < 1 || data[0] == 0 || len(data) < 1+2*int(data[0]) {
+		return nil, fmt.Errorf("%w: array %s: truncated dimensions", ErrWrongFileType, a.Name)
+	}
+	count := 1
+	for i := 0; i < int(data[0]); i++ {
+		d := int(binary.LittleEndian.Uint16(data[1+2*i:]))
+		a.Dims = append(a.Dims, d)
+		if count *= d; count > len(data) {
+			return nil, fmt.Errorf("%w: array %s: %d bytes for dimensions %v", ErrWrongFileType, a.Name, len(data), a.Dims)
+		}
+	}
+	elems := data[1+2*len(a.Dims):]
+
+	size := 5
+	if a.IsString() {
+		size = 1
+	}
+	if count == 0 || len(elems) < count*size {
+		return nil, fmt.Errorf("%w: array %s: %d bytes for %d elements", ErrWrongFileType, a.Name, len(elems), count)
+	}
+	if a.IsString() {
+		a.Chars = bytes.Clone(elems[:count])
+		return a, nil
+	}
+	for i := 0; i < count; i++ {
+		a.Numbers = append(a.Numbers, DecodeFloat([5]byte(elems[5*i:])))
+	}
+	return a, nil
+}
+
+// EncodeFloat returns v in the Spectrum's five-byte form: integers from
+// -65535 to 65535 in the short form (0, sign byte, 16-bit value, 0), other
+// numbers as an exponent byte and a 32-bit mantissa whose top bit holds the
+// sign. Numbers too small to represent become 0.
+func EncodeFloat(v float64) ([5]byte, error) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return [5]byte{}, fmt.Errorf("%w: %v is not a number BASIC can hold", ErrUnsupported, v)
+	}
+	if v == math.Trunc(v) && v >= -65535 && v <= 65535 {
+		var sign byte
+		if v < 0 {
+			sign = 0xFF
+		}
+		n := uint16(int32(v)) // two's complement for a negative value
+		return [5]byte{0, sign, byte(n), byte(n >> 8), 0}, nil
+	}
+
+	frac, exp := math.Frexp(math.Abs(v)) // 0.5 <= frac < 1
+	m := math.Round(frac * (1 << 32))
+	if m >= 1<<32 {
+		m, exp = 1<<31, exp+1
+	}
+	if exp < -127 {
+		return [5]byte{}, nil
+	}
+	if exp > 127 {
+		return [5]byte{}, fmt.Errorf("%w: %v is too large for BASIC", ErrUnsupported, v)
+	}
+	mant := uint32(m) &^ 0x80000000
+	if v < 0 {
+		mant |= 0x80000000
+	}
+	var b [5]byte
+	b[0] = byte(exp + 128)
+	binary.BigEndian.PutUint32(b[1:], mant)
+	return b, nil
+}
+
+// DecodeFloat returns the number held in the Spectrum's five-byte form.
+func DecodeFloat(b [5]byte) float64 {
+	if b[0] == 0 {
+		n := float64(binary.LittleEndian.Uint16(b[2:]))
+		if b[1] == 0xFF {
+			n -= 65536
+		}
+		return n
+	}
+	mant := binary.BigEndian.Uint32(b[1:])
+	v := math.Ldexp(float64(mant|0x80000000)/(1<<32), int(b[0])-128)
+	if mant&0x80000000 != 0 {
+		v = -v
+	}
+	return v
+}
+
+// formatFloat returns the shortest decimal that encodes to the same five
+// bytes as v, so 0.1 read back from a file prints as 0.1.
+func formatFloat(v float64) string {
+	want, _ := EncodeFloat(v)
+	for prec := 1; prec < 17; prec++ {
+		s := strconv.FormatFloat(v, 'g', prec, 64)
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			if got, _ := EncodeFloat(f); got == want {
+				return s
+			}
+		}
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// ImportArray stores a as a file on the disk with a PLUS3DOS header that
+// LOAD "name" DATA reads.
+func (di *DiskImage) ImportArray(diskPath string, a *Array) error {
+	data, err := a.Encode()
+	if err != nil {
+		return err
+	}
+	b, _ := a.varByte()
+	return di.ImportData(diskPath, data, &ImportOptions{AddHeader: true, FileType: a.FileType(), VarName: b})
+}
+
+// ReadArray decodes an array file on the disk, which must have a PLUS3DOS
+// header of a numeric or character array.
+func (di *DiskImage) ReadArray(diskPath string) (*Array, error) {
+	f, err := di.OpenFile(diskPath, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if !f.isHeadered {
+		return nil, fmt.Errorf("%w: %s has no PLUS3DOS header; not an array", ErrWrongFileType, diskPath)
+	}
+	ftype, length, name, _ := f.header.GetBasicHeader()
+	if ftype != FileTypeNumericArray && ftype != FileTypeCharArray {
+		return nil, fmt.Errorf("%w: %s is not an array (file type %d)", ErrWrongFileType, diskPath, ftype)
+	}
+	if _, err := f.Seek(HeaderSize, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(f, int64(length)))
+	if err != nil {
+		return nil, err
+	}
+	return DecodeArray(data, ftype, byte(name))
+}
+
+// elemDims returns the dimensions of the array's elements as a host sees
+// them: a character array's last dimension is folded into its strings.
+func (a *Array) elemDims() []int {
+	if a.IsString() {
+		return a.Dims[:len(a.Dims)-1]
+	}
+	return a.Dims
+}
+
+// elem returns element i for JSON or CSV: a number, or a string of a
+// character array's last dimension.
+func (a *Array) elem(i int) any {
+	if a.IsString() {
+		n := a.Dims[len(a.Dims)-1]
+		return string(a.Chars[i*n : (i+1)*n])
+	}
+	return json.Number(formatFloat(a.Numbers[i]))
+}
+
+// MarshalJSON encodes the elements as nested JSON arrays, one level for each
+// dimension: numbers for a numeric array, strings for a character array. A
+// character array of one dimension is a single string.
+func (a *Array) MarshalJSON() ([]byte, error) {
+	dims := a.elemDims()
+	var nest func(d, i int) any
+	nest = func(d, i int) any {
+		if d == len(dims) {
+			return a.elem(i)
+		}
+		stride := 1
+		for _, n := range dims[d+1:] {
+			stride *= n
+		}
+		out := make([]any, dims[d])
+		for j := range out {
+			out[j] = nest(d+1, i+j*stride)
+		}
+		return out
+	}
+	return json.Marshal(nest(0, 0))
+}
+
+// WriteCSV writes the elements as CSV: a row for each element of an array of
+// one dimension, or for each row of an array of two. A character array of
+// one dimension is a single cell; one of three is rows of strings.
+func (a *Array) WriteCSV(w io.Writer) error {
+	dims := a.elemDims()
+	rows, cols := 1, 1
+	switch len(dims) {
+	case 0:
+	case 1:
+		rows = dims[0]
+	case 2:
+		rows, cols = dims[0], dims[1]
+	default:
+		return fmt.Errorf("%w: array %s has too many dimensions for CSV", ErrUnsupported, a.Name)
+	}
+	cw := csv.NewWriter(w)
+	for r := 0; r < rows; r++ {
+		var record []string
+		for c := 0; c < cols; c++ {
+			record = append(record, fmt.Sprint(a.elem(r*cols+c)))
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ArrayFromJSON builds the array name from JSON as MarshalJSON writes it.
+// The strings of a character array are padded with spaces to the longest.
+func ArrayFromJSON(name string, data []byte) (*Array, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil, fmt.Errorf("array %s: %w", name, err)
+	}
+
+	a := &Array{Name: name}
+	var dims []int
+	for x := v; ; {
+		list, ok := x.([]any)
+		if !ok {
+			break
+		}
+		if len(list) == 0 {
+			return nil, fmt.Errorf("array %s: empty dimension", name)
+		}
+		dims = append(dims, len(list))
+		x = list[0]
+	}
+	var leaves []any
+	var walk func(x any, depth int) error
+	walk = func(x any, depth int) error {
+		if depth == len(dims) {
+			leaves = append(leaves, x)
+			return nil
+		}
+		list, ok := x.([]any)
+		if !ok || len(list) != dims[depth] {
+			return fmt.Errorf("array %s: rows of different lengths", name)
+		}
+		for _, y := range list {
+			if err := walk(y, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(v, 0); err != nil {
+		return nil, err
+	}
+	return a.fill(dims, leaves)
+}
+
+// ArrayFromCSV builds the array name from CSV as WriteCSV writes it. A file
+// of a single column gives an array of one dimension.
+func ArrayFromCSV(name string, r io.Reader) (*Array, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 0
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("array %s: %w", name, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("array %s: no rows", name)
+	}
+	var dims []int
+	var leaves []any
+	for _, rec := range records {
+		for _, cell := range rec {
+			leaves = append(leaves, cell)
+		}
+	}
+	a := &Array{Name: name}
+	switch cols := len(records[0]); {
+	case a.IsString() && len(leaves) == 1:
+	case cols == 1:
+		dims = []int{len(records)}
+	default:
+		dims = []int{len(records), cols}
+	}
+	return a.fill(dims, leaves)
+}
+
+// fill sets the array's dimensions and elements from the host's: numbers
+// for a numeric array, given as JSON numbers or text, or strings.
+func (a *Array) fill(dims []int, leaves []any) (*Array, error) {
+	if _, err := a.varByte(); err != nil {
+		return nil, err
+	}
+	a.Dims = dims
+	if !a.IsString() {
+		if len(dims) == 0 {
+			return nil, fmt.Errorf("array %s: want a list of numbers", a.Name)
+		}
+		for _, x := range leaves {
+			s, ok := x.(string)
+			if n, isNum := x.(json.Number); isNum {
+				s, ok = n.String(), true
+			}
+			v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if !ok || err != nil {
+				return nil, fmt.Errorf("array %s: %v is not a number", a.Name, x)
+			}
+			a.Numbers = append(a.Numbers, v)
+		}
+		return a, nil
+	}
+
+	width := 1
+	for _, x := range leaves {
+		s, ok := x.(string)
+		if !ok {
+			return nil, fmt.Errorf("array %s: %v is not a string", a.Name, x)
+		}
+		width = max(width, len(s))
+	}
+	a.Dims = append(a.Dims, width)
+	for _, x := range leaves {
+		s := x.(string)
+		a.Chars = append(a.Chars, s...)
+		a.Chars = append(a.Chars, bytes.Repeat([]byte{' '}, width-len(s))...)
+	}
+	return a, nil
+}
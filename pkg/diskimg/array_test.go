@@ -0,0 +1,130 @@
+package diskimg
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// EncodeFloat writes numbers as the Spectrum holds them, and DecodeFloat
+// reads them back.
+func TestEncodeFloat(t *testing.T) {
+	for _, tc := range []struct {
+		v    float64
+		want [5]byte
+	}{
+		{0, [5]byte{0, 0, 0, 0, 0}},
+		{1, [5]byte{0, 0, 1, 0, 0}},
+		{-1, [5]byte{0, 0xFF, 0xFF, 0xFF, 0}},
+		{65535, [5]byte{0, 0, 0xFF, 0xFF, 0}},
+		{-65535, [5]byte{0, 0xFF, 1, 0, 0}},
+		{0.5, [5]byte{0x80, 0, 0, 0, 0}},
+		{-0.5, [5]byte{0x80, 0x80, 0, 0, 0}},
+		{0.1, [5]byte{0x7D, 0x4C, 0xCC, 0xCC, 0xCD}},
+		{100000, [5]byte{0x91, 0x43, 0x50, 0, 0}},
+		{-65536, [5]byte{0x91, 0x80, 0, 0, 0}},
+	} {
+		got, err := EncodeFloat(tc.v)
+		if err != nil || got != tc.want {
+			t.Errorf("EncodeFloat(%v) = % X, %v; want % X", tc.v, got, err, tc.want)
+			continue
+		}
+		if back := DecodeFloat(got); formatFloat(back) != formatFloat(tc.v) {
+			t.Errorf("DecodeFloat(% X) = %v, want %v", got, back, tc.v)
+		}
+	}
+	if s := formatFloat(DecodeFloat([5]byte{0x7D, 0x4C, 0xCC, 0xCC, 0xCD})); s != "0.1" {
+		t.Errorf("0.1 read back prints as %s", s)
+	}
+	if _, err := EncodeFloat(1e40); err == nil {
+		t.Error("EncodeFloat(1e40) succeeded")
+	}
+}
+
+// Arrays go onto the disk with an array header and come back from JSON and
+// CSV as they went in.
+func TestArrayRoundTrip(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	for _, tc := range []struct {
+		name, json, csv string
+		dims            []int
+		varByte         byte
+	}{
+		{"a", `[1,2.5,-3]`, "1\n2.5\n-3\n", []int{3}, 0x81},
+		{"m", `[[1,2],[3,0.1],[5,6]]`, "1,2\n3,0.1\n5,6\n", []int{3, 2}, 0x8D},
+		{"b$", `["one  ","two  ","three"]`, "one  \ntwo  \nthree\n", []int{3, 5}, 0xC2},
+		{"t$", `"title"`, "title\n", []int{5}, 0xD4},
+	} {
+		a, err := ArrayFromJSON(tc.name, []byte(tc.json))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if err := di.ImportArray("ARR.DAT", a); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		header, err := di.ReadHeader("ARR.DAT")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ftype, _, name, _ := header.GetBasicHeader(); ftype != a.FileType() || name != uint16(tc.varByte) {
+			t.Errorf("%s: header type %d, name %#02x; want %d, %#02x", tc.name, ftype, name, a.FileType(), tc.varByte)
+		}
+		back, err := di.ReadArray("ARR.DAT")
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if back.Name != tc.name || !slices.Equal(back.Dims, tc.dims) {
+			t.Errorf("%s: read back as %s%v, want dimensions %v", tc.name, back.Name, back.Dims, tc.dims)
+		}
+		js, err := back.MarshalJSON()
+		if err != nil || string(js) != tc.json {
+			t.Errorf("%s: JSON = %s, %v; want %s", tc.name, js, err, tc.json)
+		}
+		var buf bytes.Buffer
+		if err := back.WriteCSV(&buf); err != nil || buf.String() != tc.csv {
+			t.Errorf("%s: CSV = %q, %v; want %q", tc.name, buf.String(), err, tc.csv)
+		}
+		fromCSV, err := ArrayFromCSV(tc.name, strings.NewReader(tc.csv))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		want, _ := a.Encode()
+		if got, err := fromCSV.Encode(); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%s: from CSV encodes as % X, %v; want % X", tc.name, got, err, want)
+		}
+	}
+}
+
+// Malformed input and files that are not arrays are refused.
+func TestArrayErrors(t *testing.T) {
+	for _, tc := range []struct{ name, json string }{
+		{"a", `[[1,2],[3]]`},
+		{"a", `["x"]`},
+		{"a", `5`},
+		{"a$", `[1]`},
+		{"ab", `[1]`},
+		{"a", `[]`},
+	} {
+		if _, err := ArrayFromJSON(tc.name, []byte(tc.json)); err == nil {
+			t.Errorf("ArrayFromJSON(%s, %s) succeeded", tc.name, tc.json)
+		}
+	}
+	if _, err := ArrayFromCSV("a", strings.NewReader("1,2\n3\n")); err == nil {
+		t.Error("ArrayFromCSV of ragged rows succeeded")
+	}
+	if _, err := DecodeArray([]byte{2, 3, 0}, FileTypeNumericArray, 0x81); err == nil {
+		t.Error("DecodeArray of truncated dimensions succeeded")
+	}
+	if _, err := DecodeArray([]byte{1, 0xFF, 0xFF, 1, 2}, FileTypeCharArray, 0xC1); err == nil {
+		t.Error("DecodeArray of truncated elements succeeded")
+	}
+
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.ImportData("CODE.BIN", []byte{1, 2, 3}, &ImportOptions{AddHeader: true, FileType: FileTypeCode}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := di.ReadArray("CODE.BIN"); err == nil {
+		t.Error("ReadArray of a CODE file succeeded")
+	}
+}
@@ -0,0 +1,71 @@
+// file: pkg/diskimg/autorun.go
+
+package diskimg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AutorunOptions describes the loader WriteAutorun writes.
+type AutorunOptions struct {
+	Screen string   // SCREEN$ file to show while the code loads; "" for none
+	Code   []string // CODE files to load, in order, each where its header says
+	Exec   uint16   // address to call; the first code file's load address if 0
+}
+
+// WriteAutorun writes DISK, a BASIC program that starts itself at line 10 and
+// loads the files opts names, which must already be on the disk with
+// PLUS3DOS CODE headers, and then calls Exec. The +3 Loader menu option and
+// LOAD "DISK" both run it. If the code all loads at 24576 or above, the
+// program first moves RAMTOP below it with CLEAR.
+func (di *DiskImage) WriteAutorun(opts *AutorunOptions) error {
+	if opts == nil || len(opts.Code) == 0 {
+		return fmt.Errorf("%w: an autorun loader needs a code file", ErrUnsupported)
+	}
+	lowest, exec := 0x10000, int(opts.Exec)
+	var code []string
+	for _, name := range opts.Code {
+		fi, err := di.StatFile(name)
+		if err != nil {
+			return err
+		}
+		if fi.LoadAddress < 0 {
+			return &FileError{Op: "autorun", Name: fi.Name, Err: fmt.Errorf("%w: not a CODE file", ErrWrongFileType)}
+		}
+		code = append(code, fi.Name)
+		lowest = min(lowest, fi.LoadAddress)
+		if exec == 0 {
+			exec = fi.LoadAddress
+		}
+	}
+
+	var lines []string
+	if lowest >= 0x6000 {
+		lines = append(lines, fmt.Sprintf("CLEAR %d", lowest-1))
+	}
+	if opts.Screen != "" {
+		fi, err := di.StatFile(opts.Screen)
+		if err != nil {
+			return err
+		}
+		if fi.LoadAddress != 0x4000 || fi.DataLength != ScreenSize {
+			return &FileError{Op: "autorun", Name: fi.Name, Err: fmt.Errorf("%w: not a SCREEN$", ErrWrongFileType)}
+		}
+		lines = append(lines, fmt.Sprintf("LOAD %q SCREEN$", fi.Name))
+	}
+	for _, name := range code {
+		lines = append(lines, fmt.Sprintf("LOAD %q CODE", name))
+	}
+	lines = append(lines, fmt.Sprintf("RANDOMIZE USR %d", exec))
+
+	var src strings.Builder
+	for i, line := range lines {
+		fmt.Fprintf(&src, "%d %s\n", 10*(i+1), line)
+	}
+	prog, err := TokeniseBasic(src.String())
+	if err != nil {
+		return err
+	}
+	return di.ImportData("DISK", prog, &ImportOptions{AddHeader: true, FileType: FileTypeProgram, Line: 10})
+}
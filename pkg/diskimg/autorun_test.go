@@ -0,0 +1,57 @@
+package diskimg
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// The autorun loader loads the screen and code it is given and calls the
+// code, setting RAMTOP below it, and refuses files that are not CODE.
+func TestWriteAutorun(t *testing.T) {
+	di := NewDiskImage()
+	code := func(name string, addr uint16, n int) {
+		t.Helper()
+		if err := di.ImportData(name, make([]byte, n), &ImportOptions{AddHeader: true, FileType: FileTypeCode, LoadAddr: addr}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	code("TITLE.SCR", 0x4000, ScreenSize)
+	code("MAIN.BIN", 0x8000, 1000)
+	code("MUSIC.BIN", 0xC000, 100)
+	code("LOW.BIN", 0x5E00, 10)
+
+	for _, tc := range []struct {
+		opts AutorunOptions
+		want string
+	}{
+		{AutorunOptions{Screen: "title.scr", Code: []string{"main.bin", "MUSIC.BIN"}},
+			`10 CLEAR 32767 20 LOAD "TITLE.SCR" SCREEN$ 30 LOAD "MAIN.BIN" CODE 40 LOAD "MUSIC.BIN" CODE 50 RANDOMIZE USR 32768`},
+		{AutorunOptions{Code: []string{"LOW.BIN", "MAIN.BIN"}, Exec: 32800},
+			`10 LOAD "LOW.BIN" CODE 20 LOAD "MAIN.BIN" CODE 30 RANDOMIZE USR 32800`},
+	} {
+		if err := di.WriteAutorun(&tc.opts); err != nil {
+			t.Fatalf("WriteAutorun(%+v): %v", tc.opts, err)
+		}
+		text, err := di.ReadBasicText("DISK")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(strings.Fields(text), " "); got != tc.want {
+			t.Errorf("DISK is %q, want %q", got, tc.want)
+		}
+		if fi, err := di.StatFile("DISK"); err != nil || fi.Line != 10 {
+			t.Errorf("DISK autostart %d, %v", fi.Line, err)
+		}
+	}
+
+	if err := di.WriteAutorun(&AutorunOptions{Screen: "MAIN.BIN", Code: []string{"MAIN.BIN"}}); !errors.Is(err, ErrWrongFileType) {
+		t.Errorf("WriteAutorun with a code file as the screen = %v, want ErrWrongFileType", err)
+	}
+	if err := di.WriteAutorun(&AutorunOptions{Code: []string{"DISK"}}); !errors.Is(err, ErrWrongFileType) {
+		t.Errorf("WriteAutorun with a program as code = %v, want ErrWrongFileType", err)
+	}
+	if err := di.WriteAutorun(&AutorunOptions{Code: []string{"NONE.BIN"}}); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("WriteAutorun with a missing file = %v, want ErrFileNotFound", err)
+	}
+}
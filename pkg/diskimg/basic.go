@@ -3,25 +3,29 @@ package diskimg
 import (
 	"fmt"
 	"io"
+	"os"
+	"strings"
+	"sync"
 
 	zbasic "github.com/ha1tch/zentools/pkg/basic"
 )
 
 // ReadBasicText reads a BASIC program file from the disk and returns its
-// detokenised text. The file must have a PLUS3DOS header identifying it as a
-// BASIC program (file type 0); the 128-byte header is skipped before decoding.
+// listing, as ListBasic gives it. The file must have a PLUS3DOS header
+// identifying it as a BASIC program (file type 0); the 128-byte header is
+// skipped before decoding.
 func (di *DiskImage) ReadBasicText(diskPath string) (string, error) {
-	f, err := di.OpenFile(diskPath, false)
+	f, err := di.OpenFile(diskPath, os.O_RDONLY)
 	if err != nil {
 		return "", err
 	}
 	defer f.Close()
 
 	if !f.isHeadered {
-		return "", fmt.Errorf("%s has no PLUS3DOS header; not a BASIC program", diskPath)
+		return "", fmt.Errorf("%w: %s has no PLUS3DOS header; not a BASIC program", ErrWrongFileType, diskPath)
 	}
 	if ftype, _, _, _ := f.header.GetBasicHeader(); ftype != FileTypeProgram {
-		return "", fmt.Errorf("%s is not a BASIC program (file type %d)", diskPath, ftype)
+		return "", fmt.Errorf("%w: %s is not a BASIC program (file type %d)", ErrWrongFileType, diskPath, ftype)
 	}
 
 	if _, err := f.Seek(HeaderSize, io.SeekStart); err != nil {
@@ -31,7 +35,7 @@ func (di *DiskImage) ReadBasicText(diskPath string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return DetokeniseBasic(body)
+	return ListBasic(body)
 }
 
 // DetokeniseBasic converts a tokenised Sinclair BASIC program (the raw program
@@ -42,3 +46,90 @@ func (di *DiskImage) ReadBasicText(diskPath string) (string, error) {
 func DetokeniseBasic(prog []byte) (string, error) {
 	return zbasic.Detokenise(prog)
 }
+
+// ListBasic renders a tokenised program as a listing, as DetokeniseBasic does,
+// but with each character that has no plain-text form written as an escape, in
+// the zmakebas convention: \a to \s for the UDGs, a backslash and two of
+// " '.:" (the left and right halves) for a block graphic, \* for the
+// copyright sign, \\ for a backslash and \{n} for any other byte, such as a
+// colour control code and each of its arguments. The 128K tokens SPECTRUM and
+// PLAY are listed as keywords, and numbers by the digits that precede their
+// hidden five-byte form.
+func ListBasic(prog []byte) (string, error) {
+	var out strings.Builder
+	for i := 0; i+4 <= len(prog); {
+		line := int(prog[i])<<8 | int(prog[i+1])
+		length := int(prog[i+2]) | int(prog[i+3])<<8
+		i += 4
+		if i+length > len(prog) {
+			return "", fmt.Errorf("%w: line %d claims %d bytes but only %d remain", ErrWrongFileType, line, length, len(prog)-i)
+		}
+		fmt.Fprintf(&out, "%d %s\n", line, listLine(prog[i:i+length]))
+		i += length
+	}
+	return out.String(), nil
+}
+
+// listLine renders the text of a program line, up to and including its 0x0D.
+func listLine(text []byte) string {
+	var b strings.Builder
+	for j := 0; j < len(text); j++ {
+		switch c := text[j]; {
+		case c == 0x0D:
+		case c == 0x0E:
+			j += 5 // the hidden form of the number just listed
+		case c >= 0xA3:
+			b.WriteString(keywords()[c])
+			b.WriteByte(' ')
+		case c >= 0x90:
+			b.WriteString("\\" + string(rune('a'+c-0x90)))
+		case c >= 0x80:
+			b.WriteString("\\" + string([]byte{quadrants(c&0x02, c&0x08), quadrants(c&0x01, c&0x04)}))
+		case c == 0x7F:
+			b.WriteString("\\*")
+		case c == '\\':
+			b.WriteString("\\\\")
+		case c >= 0x20:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "\\{%d}", c)
+			// INK to OVER take one argument byte, AT and TAB two.
+			args := 0
+			if c >= 0x10 && c <= 0x15 {
+				args = 1
+			} else if c == 0x16 || c == 0x17 {
+				args = 2
+			}
+			for ; args > 0 && j+1 < len(text)-1; args-- {
+				j++
+				fmt.Fprintf(&b, "\\{%d}", text[j])
+			}
+		}
+	}
+	return strings.TrimRight(b.String(), " ")
+}
+
+// quadrants returns the character for one half of a block graphic, given
+// whether its top and bottom quadrants are set.
+func quadrants(top, bottom byte) byte {
+	switch {
+	case top != 0 && bottom != 0:
+		return ':'
+	case top != 0:
+		return '\''
+	case bottom != 0:
+		return '.'
+	}
+	return ' '
+}
+
+// keywords returns the keyword of each token, 0xA3-0xFF, as DetokeniseBasic
+// spells it, so that the listing and the detokeniser agree.
+var keywords = sync.OnceValue(func() map[byte]string {
+	kw := make(map[byte]string)
+	for c := 0xA3; c <= 0xFF; c++ {
+		text, _ := DetokeniseBasic([]byte{0, 1, 2, 0, byte(c), 0x0D})
+		kw[byte(c)] = strings.TrimSpace(strings.TrimPrefix(text, "1 "))
+	}
+	return kw
+})
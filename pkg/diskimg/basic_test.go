@@ -74,3 +74,21 @@ func TestDetokenise128Tokens(t *testing.T) {
 		t.Errorf("PLAY (0xA4) not decoded: %q", got)
 	}
 }
+
+// TestListBasicEscapes checks that a listing escapes UDGs, block graphics,
+// colour controls and their arguments, and lists numbers by their digits.
+func TestListBasicEscapes(t *testing.T) {
+	text := []byte{0xF5, '"', 0x90, 0x94, 0x8F, 0x80, 0x87, 0x7F, '\\', '"', ';', 0x10, '2', 0xAC, '1', 0x0E, 0, 0, 1, 0, 0, ',', 0x16, 0x05, 0x03, 0xA3, 0x0D}
+	prog := append([]byte{0x00, 0x0A, byte(len(text)), 0x00}, text...)
+	got, err := ListBasic(prog)
+	if err != nil {
+		t.Fatalf("ListBasic: %v", err)
+	}
+	want := `10 PRINT "\a\e\::\  \':\*\\";\{16}\{50}AT 1,\{22}\{5}\{3}SPECTRUM` + "\n"
+	if got != want {
+		t.Errorf("ListBasic = %q, want %q", got, want)
+	}
+	if _, err := ListBasic(prog[:10]); err == nil {
+		t.Error("ListBasic of a truncated line succeeded")
+	}
+}
@@ -0,0 +1,83 @@
+// file: pkg/diskimg/batch.go
+
+package diskimg
+
+import "errors"
+
+// ImportSpec is one file of a batch import (see ImportFiles): a host file, or
+// data, to store under a name on the disk.
+type ImportSpec struct {
+	Name     string         // the name on the disk
+	HostPath string         // the host file to import, unless Data is set
+	Data     []byte         // the file's contents
+	Options  *ImportOptions // a PLUS3DOS header to add; nil for none
+	Replace  bool           // replace a file of the same name rather than fail
+}
+
+// ImportResult is what became of one ImportSpec: the file's size on the disk,
+// header included, or why it could not be imported.
+type ImportResult struct {
+	Name string
+	Size int
+	Err  error
+}
+
+// BatchResult reports a batch import file by file, in the order given.
+type BatchResult struct {
+	Files    []ImportResult
+	Imported int
+	Failed   int
+}
+
+// ImportFiles imports many files into the disk in memory, for the caller to
+// save once after. Each file is imported whole or not at all: one that fails,
+// as on a full disk or a name already used, leaves the disk as it was and the
+// rest are still imported. The error joins the failures, which the result
+// also reports file by file.
+func (di *DiskImage) ImportFiles(specs []ImportSpec) (BatchResult, error) {
+	var result BatchResult
+	var errs []error
+	for _, spec := range specs {
+		var r ImportResult
+		r.Name, r.Size, r.Err = di.importSpec(spec)
+		if r.Err != nil {
+			r.Err = fileError("import", r.Name, r.Err)
+			errs = append(errs, r.Err)
+			result.Failed++
+		} else {
+			result.Imported++
+		}
+		result.Files = append(result.Files, r)
+	}
+	return result, errors.Join(errs...)
+}
+
+// importSpec imports one file of a batch in a transaction, committed only if
+// the whole file was imported, and returns its name and size on the disk.
+func (di *DiskImage) importSpec(spec ImportSpec) (string, int, error) {
+	name, err := di.diskName(spec.Name)
+	if err != nil {
+		return spec.Name, 0, err
+	}
+	if !spec.Replace {
+		if _, err := di.directory.FindFile(name); err == nil {
+			return name, 0, ErrFileExists
+		}
+	}
+	tx := di.Begin()
+	if spec.Data != nil {
+		err = tx.ImportData(name, spec.Data, spec.Options)
+	} else {
+		err = tx.ImportFile(spec.HostPath, name, spec.Options)
+	}
+	if err != nil {
+		tx.Rollback()
+		return name, 0, err
+	}
+	size, err := tx.FileSize(name)
+	if err != nil {
+		tx.Rollback()
+		return name, 0, err
+	}
+	return name, size, tx.Commit()
+}
@@ -0,0 +1,56 @@
+package diskimg
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Files are imported from data and host files alike; one that fails leaves
+// nothing behind and the rest are imported.
+func TestImportFiles(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.WriteFile("OLD.DAT", []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	host := filepath.Join(t.TempDir(), "code.bin")
+	if err := os.WriteFile(host, []byte("code"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	free := di.fileSpace()
+
+	result, err := di.ImportFiles([]ImportSpec{
+		{Name: "GAME.BIN", HostPath: host, Options: &ImportOptions{AddHeader: true, FileType: FileTypeCode, LoadAddr: 0x8000}},
+		{Name: "OLD.DAT", Data: []byte("new")},
+		{Name: "HUGE.DAT", Data: make([]byte, free+1024)},
+		{Name: "OLD.DAT", Data: []byte("replaced"), Replace: true},
+		{Name: "BAD*.DAT", Data: []byte("x")},
+	})
+	if result.Imported != 2 || result.Failed != 3 || len(result.Files) != 5 {
+		t.Fatalf("%d imported, %d failed, %d results", result.Imported, result.Failed, len(result.Files))
+	}
+	if r := result.Files[0]; r.Err != nil || r.Size != HeaderSize+4 {
+		t.Errorf("GAME.BIN: %d bytes, %v", r.Size, r.Err)
+	}
+	if !errors.Is(result.Files[1].Err, ErrFileExists) || !errors.Is(err, ErrFileExists) {
+		t.Errorf("OLD.DAT without Replace: %v", result.Files[1].Err)
+	}
+	if !errors.Is(result.Files[4].Err, ErrInvalidFilename) {
+		t.Errorf("BAD*.DAT: %v", result.Files[4].Err)
+	}
+	if _, err := di.StatFile("HUGE.DAT"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("the file that did not fit is on the disk: %v", err)
+	}
+	if got, _ := fs.ReadFile(di, "OLD.DAT"); string(got) != "replaced" {
+		t.Errorf("OLD.DAT holds %q", got)
+	}
+	if h, _ := di.ReadHeader("GAME.BIN"); h == nil || h.HeaderData[0] != FileTypeCode {
+		t.Error("GAME.BIN has no CODE header")
+	}
+	if got, _ := fs.ReadFile(reload(t, di), "GAME.BIN"); !bytes.Equal(got[HeaderSize:], []byte("code")) {
+		t.Errorf("GAME.BIN after a reload holds %q", got)
+	}
+}
@@ -0,0 +1,100 @@
+// file: pkg/diskimg/block.go
+
+package diskimg
+
+import "fmt"
+
+// Allocation returns the disk's block allocator, for tools that place blocks
+// themselves: defragmenters, recovery tools, emulators. It records which
+// blocks are in use in memory only; the directory says which blocks each
+// file has (see SetFileBlocks), and loading a disk rebuilds the allocation
+// from it.
+func (di *DiskImage) Allocation() *FileAllocation {
+	return di.fileAlloc
+}
+
+// ReadBlock returns the data of an allocation block, read from its sectors.
+func (di *DiskImage) ReadBlock(block int) ([]byte, error) {
+	if err := di.checkBlock(block); err != nil {
+		return nil, err
+	}
+	spec := di.spec
+	data := make([]byte, spec.BlockSize)
+	off := 0
+	for n := range spec.SectorsPerBlock() {
+		track, sector, side := spec.BlockSector(block, n)
+		size, err := di.ReadSectorInto(data[off:], track, sector, side)
+		if err != nil {
+			return nil, err
+		}
+		off += size
+	}
+	return data[:off], nil
+}
+
+// WriteBlock writes a whole allocation block, data being BlockSize bytes.
+func (di *DiskImage) WriteBlock(block int, data []byte) error {
+	if err := di.checkBlock(block); err != nil {
+		return err
+	}
+	spec := di.spec
+	if len(data) != spec.BlockSize {
+		return fmt.Errorf("%w: %d bytes for a %d-byte block", ErrInvalidSectorSize, len(data), spec.BlockSize)
+	}
+	for n := range spec.SectorsPerBlock() {
+		track, sector, side := spec.BlockSector(block, n)
+		if err := di.SetSectorData(track, sector, side, data[n*spec.SectorSize:(n+1)*spec.SectorSize]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetFileBlocks replaces the blocks of a file, in each of its directory
+// entries, and flushes the directory. blocks must be as many as FileBlocks
+// returns and hold the file's data already: SetFileBlocks moves no data and
+// leaves the allocation to the caller.
+func (di *DiskImage) SetFileBlocks(filename string, blocks []int) error {
+	first, err := di.directory.FindFile(filename)
+	if err != nil {
+		return fileError("move", filename, err)
+	}
+	extents := di.directory.fileExtents(first)
+	wide := di.spec.WideBlockPointers()
+	n := 0
+	for _, e := range extents {
+		n += len(e.blockPointers(wide))
+	}
+	if len(blocks) != n {
+		return fileError("move", filename, fmt.Errorf("%w: %d blocks for a file of %d", ErrInvalidBlock, len(blocks), n))
+	}
+	for _, b := range blocks {
+		if b < di.spec.DirBlocks {
+			return fileError("move", filename, fmt.Errorf("%w: %d is a directory block", ErrInvalidBlock, b))
+		}
+		if err := di.checkBlock(b); err != nil {
+			return fileError("move", filename, err)
+		}
+	}
+	for _, e := range extents {
+		var slots []int
+		for _, b := range e.blockSlots(wide) {
+			if b != 0 {
+				b, blocks = blocks[0], blocks[1:]
+			}
+			slots = append(slots, b)
+		}
+		e.setBlockPointers(slots, wide)
+	}
+	logger.Debug("moved file", "name", first.GetFilename())
+	di.Modified = true
+	return di.FlushDirectory()
+}
+
+// checkBlock returns ErrInvalidBlock for a block outside the disk.
+func (di *DiskImage) checkBlock(block int) error {
+	if block < 0 || block >= di.spec.TotalBlocks() {
+		return fmt.Errorf("%w: %d", ErrInvalidBlock, block)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package diskimg
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"testing"
+)
+
+// A file can be moved block by block through the public block API, as a
+// defragmenter would, and reads back the same after a reload.
+func TestMoveFileBlocks(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	data := bytes.Repeat([]byte("0123456789"), 3000)
+	if err := di.writeRecords("DATA.BIN", data); err != nil {
+		t.Fatal(err)
+	}
+	old, err := di.FileBlocks("DATA.BIN")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alloc := di.Allocation()
+	var moved []int
+	for b := di.Spec().TotalBlocks() - 1; len(moved) < len(old); b-- {
+		if alloc.IsFree(b) {
+			moved = append(moved, b)
+		}
+	}
+	if err := alloc.AllocateBlocks(moved); err != nil {
+		t.Fatal(err)
+	}
+	for i, b := range old {
+		block, err := di.ReadBlock(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := di.WriteBlock(moved[i], block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := di.SetFileBlocks("DATA.BIN", moved[:1]); !errors.Is(err, ErrInvalidBlock) {
+		t.Errorf("SetFileBlocks with too few blocks: err = %v, want ErrInvalidBlock", err)
+	}
+	if err := di.SetFileBlocks("DATA.BIN", moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := alloc.FreeBlocks(old); err != nil {
+		t.Fatal(err)
+	}
+	if err := alloc.FreeBlocks([]int{0}); !errors.Is(err, ErrInvalidBlock) {
+		t.Errorf("freeing a directory block: err = %v, want ErrInvalidBlock", err)
+	}
+
+	di = reload(t, di)
+	if got, _ := fs.ReadFile(di, "DATA.BIN"); !bytes.Equal(got, data) {
+		t.Error("DATA.BIN changed when moved")
+	}
+	if got, _ := di.FileBlocks("DATA.BIN"); got[0] != moved[0] {
+		t.Errorf("DATA.BIN starts at block %d, want %d", got[0], moved[0])
+	}
+	if err := di.DiskCheck(); err != nil {
+		t.Errorf("DiskCheck: %v", err)
+	}
+}
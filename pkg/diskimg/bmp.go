@@ -0,0 +1,126 @@
+// file: pkg/diskimg/bmp.go
+
+package diskimg
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+)
+
+// The standard library has no BMP decoder, so ImportImage registers this one:
+// uncompressed Windows bitmaps of 1, 4, 8, 24 or 32 bits per pixel, the kind
+// paint programs save.
+func init() {
+	image.RegisterFormat("bmp", "BM", decodeBMP, decodeBMPConfig)
+}
+
+var errBMP = errors.New("unsupported or corrupt BMP")
+
+// bmpHeader is the part of the file and info headers decodeBMP needs.
+type bmpHeader struct {
+	offset   int // of the pixel data
+	width    int
+	height   int
+	bpp      int
+	palette  color.Palette
+	topDown  bool
+	rowBytes int
+}
+
+func readBMPHeader(data []byte) (*bmpHeader, error) {
+	if len(data) < 54 || string(data[:2]) != "BM" {
+		return nil, errBMP
+	}
+	le := binary.LittleEndian
+	infoSize := int(le.Uint32(data[14:]))
+	h := &bmpHeader{
+		offset: int(le.Uint32(data[10:])),
+		width:  int(int32(le.Uint32(data[18:]))),
+		height: int(int32(le.Uint32(data[22:]))),
+		bpp:    int(le.Uint16(data[28:])),
+	}
+	// Compression 3 (bit fields) is accepted for 32-bit images, which paint
+	// programs write with the usual BGRA masks.
+	compression := le.Uint32(data[30:])
+	if infoSize < 40 || compression != 0 && !(compression == 3 && h.bpp == 32) {
+		return nil, errBMP
+	}
+	if h.height < 0 {
+		h.height, h.topDown = -h.height, true
+	}
+	if h.width <= 0 || h.height <= 0 || h.width > 1<<14 || h.height > 1<<14 {
+		return nil, errBMP
+	}
+	switch h.bpp {
+	case 1, 4, 8:
+		n := int(le.Uint32(data[46:]))
+		if n == 0 || n > 1<<h.bpp {
+			n = 1 << h.bpp
+		}
+		start := 14 + infoSize
+		if start+4*n > len(data) {
+			return nil, errBMP
+		}
+		for i := 0; i < n; i++ {
+			p := data[start+4*i:]
+			h.palette = append(h.palette, color.RGBA{p[2], p[1], p[0], 0xFF})
+		}
+	case 24, 32:
+	default:
+		return nil, errBMP
+	}
+	h.rowBytes = (h.width*h.bpp + 31) / 32 * 4
+	if h.offset < 0 || h.offset+h.rowBytes*h.height > len(data) {
+		return nil, errBMP
+	}
+	return h, nil
+}
+
+func decodeBMPConfig(r io.Reader) (image.Config, error) {
+	data := make([]byte, 54)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return image.Config{}, err
+	}
+	le := binary.LittleEndian
+	width, height := int(int32(le.Uint32(data[18:]))), int(int32(le.Uint32(data[22:])))
+	return image.Config{ColorModel: color.RGBAModel, Width: width, Height: max(height, -height)}, nil
+}
+
+func decodeBMP(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	h, err := readBMPHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewRGBA(image.Rect(0, 0, h.width, h.height))
+	for y := 0; y < h.height; y++ {
+		row := h.height - 1 - y
+		if h.topDown {
+			row = y
+		}
+		line := data[h.offset+row*h.rowBytes:]
+		for x := 0; x < h.width; x++ {
+			var c color.Color
+			switch h.bpp {
+			case 24, 32:
+				p := line[x*h.bpp/8:]
+				c = color.RGBA{p[2], p[1], p[0], 0xFF}
+			default:
+				bit := x * h.bpp
+				i := int(line[bit/8]>>(8-h.bpp-bit%8)) & (1<<h.bpp - 1)
+				if i >= len(h.palette) {
+					return nil, errBMP
+				}
+				c = h.palette[i]
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img, nil
+}
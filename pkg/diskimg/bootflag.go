@@ -0,0 +1,45 @@
+// file: pkg/diskimg/bootflag.go
+
+package diskimg
+
+import "fmt"
+
+// bootChecksumByte is the boot sector byte set to give the sector its
+// checksum; the disk specification ends before it and boot code starts after.
+const bootChecksumByte = 15
+
+// Bootable reports whether the boot sector adds up to the format's
+// BootChecksum, so a +3 or PCW runs its code at start-up.
+func (di *DiskImage) Bootable() bool {
+	return di.ValidateBootSector() == nil
+}
+
+// SetBootable makes the disk bootable or not by setting byte 15 of the boot
+// sector, so the sector adds up to the format's BootChecksum or to one more.
+// No other byte changes, so the disk specification and any boot code are
+// kept. A disk already as asked is left unchanged. CPC formats and IDEDOS
+// partitions have no boot sector to mark.
+func (di *DiskImage) SetBootable(on bool) error {
+	if di.spec.isCPC() || di.spec.Name == idedosSpecName {
+		return fmt.Errorf("%w: a %s disk has no boot sector checksum", ErrUnsupported, di.spec.Name)
+	}
+	if di.Bootable() == on {
+		return nil
+	}
+	boot, err := di.GetSectorData(0, 0, 0)
+	if err != nil {
+		return err
+	}
+	var sum byte
+	for i, b := range boot {
+		if i != bootChecksumByte {
+			sum += b
+		}
+	}
+	boot[bootChecksumByte] = di.spec.BootChecksum() - sum
+	if !on {
+		boot[bootChecksumByte]++
+	}
+	logger.Debug("set boot checksum", "bootable", on, "byte", boot[bootChecksumByte])
+	return di.SetSectorData(0, 0, 0, boot)
+}
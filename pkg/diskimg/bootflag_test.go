@@ -0,0 +1,52 @@
+package diskimg
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// SetBootable changes only the checksum byte, and Check reports either state
+// as information.
+func TestSetBootable(t *testing.T) {
+	for _, spec := range []DiskSpec{SpecPlus3, SpecPCW180} {
+		di := newSpecImage(t, spec)
+		boot, _ := di.GetSectorData(0, 0, 0)
+		copy(boot, spec.Specification().Serialize())
+		copy(boot[16:], "\xF3\xC3\x00\x80")
+		if err := di.SetSectorData(0, 0, 0, boot); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, on := range []bool{true, true, false, false, true} {
+			if err := di.SetBootable(on); err != nil {
+				t.Fatalf("%s: SetBootable(%v): %v", spec.Name, on, err)
+			}
+			if di.Bootable() != on {
+				t.Errorf("%s: SetBootable(%v) left Bootable %v", spec.Name, on, !on)
+			}
+			got, _ := di.GetSectorData(0, 0, 0)
+			if !bytes.Equal(got[:bootChecksumByte], boot[:bootChecksumByte]) || !bytes.Equal(got[bootChecksumByte+1:], boot[bootChecksumByte+1:]) {
+				t.Errorf("%s: SetBootable(%v) changed more than the checksum byte", spec.Name, on)
+			}
+			r := di.Check()
+			if r.Count(SeverityWarning) != 0 || !r.OK() {
+				t.Errorf("%s: bootable %v: %v", spec.Name, on, r.Findings)
+			}
+			want := "boot-checksum"
+			if on {
+				want = "boot-bootable"
+			}
+			if len(r.WithCode(want)) != 1 {
+				t.Errorf("%s: no %s finding in %v", spec.Name, want, r.Findings)
+			}
+		}
+		if !reload(t, di).Bootable() {
+			t.Errorf("%s: not bootable after a reload", spec.Name)
+		}
+	}
+
+	if err := newSpecImage(t, SpecCPCData).SetBootable(true); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("CPC data disk: err = %v, want ErrUnsupported", err)
+	}
+}
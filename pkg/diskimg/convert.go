@@ -3,10 +3,15 @@
 package diskimg
 
 import (
-	"errors"
+	"cmp"
+	"fmt"
 	"io"
+	"os"
+	"slices"
+	"strconv"
 
 	"github.com/ha1tch/zentools/pkg/tap"
+	"github.com/ha1tch/zentools/pkg/tzx"
 )
 
 // ConvertTAPtoDisk converts a single-file TAP image (a header block followed by
@@ -37,16 +42,16 @@ func (di *DiskImage) ConvertTAPtoDisk(r io.Reader, diskPath string) error {
 		}
 	}
 	if header == nil {
-		return errors.New("no TAP header block found")
+		return fmt.Errorf("%w: no header block found", ErrInvalidTape)
 	}
 	if data == nil {
-		return errors.New("TAP header block has no following data block")
+		return fmt.Errorf("%w: header block has no following data block", ErrInvalidTape)
 	}
 	if !header.ChecksumOK {
-		return errors.New("TAP header block checksum mismatch")
+		return fmt.Errorf("%w: header block: %w", ErrInvalidTape, ErrInvalidChecksum)
 	}
 	if !data.ChecksumOK {
-		return errors.New("TAP data block checksum mismatch")
+		return fmt.Errorf("%w: data block: %w", ErrInvalidTape, ErrInvalidChecksum)
 	}
 
 	// Build the +3DOS header from the TAP header fields.
@@ -57,7 +62,7 @@ func (di *DiskImage) ConvertTAPtoDisk(r io.Reader, diskPath string) error {
 	case tap.TypeCode:
 		err = plus3Header.SetBasicHeader(FileTypeCode, header.DataLength, header.Param1, 0)
 	default:
-		return errors.New("unsupported TAP file type")
+		return fmt.Errorf("%w: TAP file type", ErrUnsupported)
 	}
 	if err != nil {
 		return err
@@ -68,7 +73,10 @@ func (di *DiskImage) ConvertTAPtoDisk(r io.Reader, diskPath string) error {
 	plus3Header.FileLength = uint32(HeaderSize) + uint32(len(data.Data))
 	plus3Header.UpdateChecksum()
 
-	f, err := di.OpenFile(diskPath, true)
+	if err := di.checkSpace("import", diskPath, HeaderSize+len(data.Data)); err != nil {
+		return err
+	}
+	f, err := di.OpenFile(diskPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
 	if err != nil {
 		return err
 	}
@@ -83,18 +91,88 @@ func (di *DiskImage) ConvertTAPtoDisk(r io.Reader, diskPath string) error {
 	return nil
 }
 
+// TapeBlock reports what ImportTAP did with one block of a tape.
+type TapeBlock struct {
+	Index  int    // position on the tape, from 0
+	Header bool   // a standard header block
+	Name   string // a header's tape name
+	Bytes  int    // length of the payload, without flag and checksum
+	File   string // the disk file the block went into, "" if none
+	Err    error  // why the block was skipped, nil if it was not
+}
+
+// ImportTAP copies every file of a TAP image onto the disk and reports what
+// became of each block. A header and the data block after it become a file as
+// in ImportTape; a data block without a header is copied as it is to a
+// headerless BLOCKnnn.DAT, nnn its index. Damaged blocks, a header with no
+// data block after it and a data block whose header is damaged are skipped,
+// the reason in their Err. The error returned is for a tape that cannot be
+// parsed or a file the disk cannot take. The directory is flushed.
+func (di *DiskImage) ImportTAP(r io.Reader) ([]TapeBlock, error) {
+	image, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := tap.Decode(image)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTape, err)
+	}
+
+	report := make([]TapeBlock, len(blocks))
+	for i, b := range blocks {
+		report[i] = TapeBlock{Index: i, Header: b.IsHeader, Name: b.Name, Bytes: len(b.Data)}
+		if !b.ChecksumOK {
+			report[i].Err = fmt.Errorf("%w: %w", ErrInvalidTape, ErrInvalidChecksum)
+		}
+	}
+	used := make(map[string]bool)
+	for i := 0; i < len(blocks); i++ {
+		b, rep := &blocks[i], &report[i]
+		hasData := i+1 < len(blocks) && !blocks[i+1].IsHeader
+		switch {
+		case rep.Err != nil:
+			if b.IsHeader && hasData {
+				i++
+				report[i].Err = fmt.Errorf("%w: the header of the block is damaged", ErrInvalidTape)
+			}
+		case !b.IsHeader:
+			rep.File, err = di.writeTapeFile(plus3Name(fmt.Sprintf("BLOCK%03d", i)), "DAT", nil, b.Data, used)
+		case !hasData:
+			rep.Err = fmt.Errorf("%w: no data block follows the header", ErrInvalidTape)
+		default:
+			i++
+			data := &blocks[i]
+			switch {
+			case report[i].Err != nil:
+				rep.Err = fmt.Errorf("%w: the data block is damaged", ErrInvalidTape)
+			case b.Type > FileTypeCode || int(b.DataLength) > len(data.Data):
+				rep.Err = fmt.Errorf("%w: invalid header", ErrInvalidTape)
+				report[i].Err = rep.Err
+			default:
+				f := TapeFile{Name: b.Name, Type: b.Type, Param1: b.Param1, Param2: b.Param2, Data: data.Data[:b.DataLength]}
+				rep.File, err = di.importTapeFile(&f, used)
+				report[i].File = rep.File
+			}
+		}
+		if err != nil {
+			return report, fmt.Errorf("tape block %d: %w", rep.Index, err)
+		}
+	}
+	return report, di.FlushDirectory()
+}
+
 // ConvertDiskToTAP converts a headered +3DOS file at diskPath into a TAP image
 // (header block plus data block) written to w. TAP encoding, including the
 // header layout and both block checksums, is delegated to zentools/pkg/tap.
 func (di *DiskImage) ConvertDiskToTAP(diskPath string, w io.Writer) error {
-	f, err := di.OpenFile(diskPath, false)
+	f, err := di.OpenFile(diskPath, os.O_RDONLY)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
 	if !f.isHeadered {
-		return errors.New("file has no header")
+		return fmt.Errorf("%w: file has no PLUS3DOS header", ErrInvalidHeader)
 	}
 
 	fileType, length, param1, param2 := f.header.GetBasicHeader()
@@ -119,7 +197,7 @@ func (di *DiskImage) ConvertDiskToTAP(diskPath string, w io.Writer) error {
 		// param1 is the load address.
 		image = tap.EncodeCode(name, data, param1)
 	default:
-		return errors.New("unsupported +3DOS file type for TAP conversion")
+		return fmt.Errorf("%w: +3DOS file type for TAP conversion", ErrUnsupported)
 	}
 
 	_ = param2 // program length is recomputed by EncodeProgram from the data
@@ -136,3 +214,314 @@ func trimName(name []byte) string {
 	}
 	return string(name[:end])
 }
+
+// TapeFile is a file on a tape: a standard header block and the data block
+// after it.
+type TapeFile struct {
+	Name   string // up to 10 characters
+	Type   byte   // tape file type: FileTypeProgram ... FileTypeCode
+	Param1 uint16 // LINE for a program, load address for code
+	Param2 uint16 // program length without variables
+	Data   []byte // file contents, without the tape header
+}
+
+// TZX block IDs with a body of their own length. Every other block the TZX
+// 1.20 format defines has a fixed size or a length field (see tzxBlockSize).
+const (
+	tzxStandard = 0x10 // standard speed data: a TAP block
+	tzxTurbo    = 0x11 // turbo speed data: a TAP block with its own timings
+	tzxPure     = 0x14 // pure data: a TAP block without pilot or sync
+)
+
+// LoadTZX reads the files of a TZX tape image: each standard header followed
+// by its data, from standard speed, turbo speed or pure data blocks. Data
+// blocks without a header, as custom loaders use, are skipped, as are tones,
+// pulses, pauses and the other blocks that carry no data. Blocks are parsed
+// with zentools/pkg/tap once unwrapped.
+func LoadTZX(r io.Reader) ([]TapeFile, error) {
+	image, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TZX image: %w", err)
+	}
+	if len(image) < 10 || string(image[:8]) != "ZXTape!\x1a" {
+		return nil, fmt.Errorf("%w: no TZX signature", ErrInvalidTape)
+	}
+
+	// Gather the data blocks as a TAP image.
+	var tapImage []byte
+	for pos := 10; pos < len(image); {
+		id := image[pos]
+		size, data, err := tzxBlockSize(id, image[pos+1:])
+		if err != nil {
+			return nil, fmt.Errorf("%w: TZX block %#02x at offset %d: %w", ErrInvalidTape, id, pos, err)
+		}
+		switch id {
+		case tzxStandard, tzxTurbo, tzxPure:
+			block := image[pos+1+size-data : pos+1+size]
+			if len(block) > 0xFFFF {
+				return nil, fmt.Errorf("%w: TZX block at offset %d is %d bytes", ErrInvalidTape, pos, len(block))
+			}
+			tapImage = append(tapImage, byte(len(block)), byte(len(block)>>8))
+			tapImage = append(tapImage, block...)
+		}
+		pos += 1 + size
+	}
+	blocks, err := tap.Decode(tapImage)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTape, err)
+	}
+
+	var files []TapeFile
+	for i := 0; i < len(blocks); i++ {
+		header := &blocks[i]
+		if !header.IsHeader {
+			logger.Debug("skipped headerless tape block", "bytes", len(header.Data))
+			continue
+		}
+		if i+1 == len(blocks) || blocks[i+1].IsHeader {
+			return nil, fmt.Errorf("%w: header block %q has no following data block", ErrInvalidTape, header.Name)
+		}
+		i++
+		data := &blocks[i]
+		if !header.ChecksumOK || !data.ChecksumOK {
+			return nil, fmt.Errorf("%w: %q: %w", ErrInvalidTape, header.Name, ErrInvalidChecksum)
+		}
+		if header.Type > FileTypeCode || int(header.DataLength) > len(data.Data) {
+			return nil, fmt.Errorf("%w: %q has an invalid header", ErrInvalidTape, header.Name)
+		}
+		files = append(files, TapeFile{
+			Name:   header.Name,
+			Type:   header.Type,
+			Param1: header.Param1,
+			Param2: header.Param2,
+			Data:   data.Data[:header.DataLength],
+		})
+	}
+	return files, nil
+}
+
+// tzxBlockSize returns the size of the body of a TZX block with the given ID,
+// b holding the rest of the image, and for a data block the size of the TAP
+// block at the end of the body.
+func tzxBlockSize(id byte, b []byte) (size, data int, err error) {
+	// The fixed part of each block, which holds any length field.
+	fixed := map[byte]int{
+		tzxStandard: 4, tzxTurbo: 18, 0x12: 4, 0x13: 1, tzxPure: 10, 0x15: 8,
+		0x18: 4, 0x19: 4, 0x20: 2, 0x21: 1, 0x22: 0, 0x23: 2, 0x24: 2, 0x25: 0,
+		0x26: 2, 0x27: 0, 0x28: 2, 0x2A: 4, 0x2B: 5, 0x30: 1, 0x31: 2, 0x32: 2,
+		0x33: 1, 0x35: 20, 0x5A: 9,
+	}
+	n, ok := fixed[id]
+	if !ok {
+		n = 4 // blocks added after TZX 1.10 start with a 32-bit length
+	}
+	if len(b) < n {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	u16 := func(at int) int { return int(b[at]) | int(b[at+1])<<8 }
+	u24 := func(at int) int { return u16(at) | int(b[at+2])<<16 }
+
+	switch id {
+	case tzxStandard:
+		data = u16(2)
+	case tzxTurbo:
+		data = u24(15)
+	case tzxPure:
+		data = u24(7)
+	case 0x15:
+		n += u24(5)
+	case 0x13:
+		n += 2 * int(b[0])
+	case 0x21, 0x30:
+		n += int(b[0])
+	case 0x31:
+		n += int(b[1])
+	case 0x33:
+		n += 3 * int(b[0])
+	case 0x26:
+		n += 2 * u16(0)
+	case 0x28, 0x32:
+		n += u16(0)
+	case 0x35:
+		n += u24(16) | int(b[19])<<24
+	case 0x12, 0x20, 0x22, 0x23, 0x24, 0x25, 0x27, 0x2A, 0x2B, 0x5A:
+	default: // 0x18, 0x19 and unknown blocks
+		n += u24(0) | int(b[3])<<24
+	}
+	if n+data > len(b) {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	return n + data, data, nil
+}
+
+// SaveTZX writes files as a TZX tape image of standard speed blocks, each
+// file a header block and a data block. TAP encoding is delegated to
+// zentools/pkg/tap and the TZX wrapping to zentools/pkg/tzx.
+func SaveTZX(w io.Writer, files []TapeFile) error {
+	tapImage, err := encodeTAP(files)
+	if err != nil {
+		return err
+	}
+	image, err := tzx.EncodeFromTAP(tapImage, tzx.EncodeOptions{})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(image)
+	return err
+}
+
+// ExportAllToTAP writes the disk's headered files to w as a TAP image, BASIC
+// programs first (see ExportTape), for loading on a 48K or 128K Spectrum.
+func (di *DiskImage) ExportAllToTAP(w io.Writer) error {
+	files, err := di.ExportTape()
+	if err != nil {
+		return err
+	}
+	image, err := encodeTAP(files)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(image)
+	return err
+}
+
+// encodeTAP returns files as a TAP image, each a header block and a data
+// block.
+func encodeTAP(files []TapeFile) ([]byte, error) {
+	var image []byte
+	for _, f := range files {
+		switch f.Type {
+		case FileTypeProgram:
+			image = append(image, tap.EncodeProgram(f.Name, f.Data, f.Param1)...)
+		case FileTypeCode:
+			image = append(image, tap.EncodeCode(f.Name, f.Data, f.Param1)...)
+		case FileTypeNumericArray, FileTypeCharArray:
+			// pkg/tap encodes programs and code only: retype a code header,
+			// whose block is length, flag, type and the rest, and re-sum it.
+			b := tap.EncodeCode(f.Name, f.Data, f.Param1)
+			b[3] = f.Type
+			b[20] = 0
+			for _, c := range b[2:20] {
+				b[20] ^= c
+			}
+			image = append(image, b...)
+		default:
+			return nil, fmt.Errorf("%w: %s: tape file type %d", ErrUnsupported, f.Name, f.Type)
+		}
+	}
+	return image, nil
+}
+
+// ImportTape copies tape files onto the disk, converting each tape header to
+// a PLUS3DOS header. Programs are named NAME.BAS, code NAME.BIN and arrays
+// NAME.DAT; a name already on the disk or used twice gets a number in place
+// of its last characters.
+func (di *DiskImage) ImportTape(files []TapeFile) error {
+	used := make(map[string]bool)
+	for i := range files {
+		if _, err := di.importTapeFile(&files[i], used); err != nil {
+			return err
+		}
+	}
+	return di.FlushDirectory()
+}
+
+// importTapeFile writes f to the disk with a PLUS3DOS header and returns the
+// name it was given, which is added to used.
+func (di *DiskImage) importTapeFile(f *TapeFile, used map[string]bool) (string, error) {
+	header := NewPlus3DosHeader()
+	param1, ext := f.Param1, "DAT"
+	switch f.Type {
+	case FileTypeProgram:
+		ext = "BAS"
+	case FileTypeCode:
+		ext = "BIN"
+	default:
+		param1 = f.Param1 >> 8 // the tape header keeps the array name in the high byte
+	}
+	if err := header.SetBasicHeader(f.Type, uint16(len(f.Data)), param1, f.Param2); err != nil {
+		return "", fmt.Errorf("%s: %w", f.Name, err)
+	}
+	header.FileLength = uint32(HeaderSize) + uint32(len(f.Data))
+	header.UpdateChecksum()
+	return di.writeTapeFile(plus3Name(f.Name), ext, header, f.Data, used)
+}
+
+// writeTapeFile writes data, after header unless it is nil, to a new file
+// named base.ext, numbered if that name is on the disk or in used, and
+// returns the name, which is added to used.
+func (di *DiskImage) writeTapeFile(base, ext string, header *Plus3DosHeader, data []byte, used map[string]bool) (string, error) {
+	taken := func(name string) bool {
+		_, err := di.directory.FindFile(name)
+		return used[name] || err == nil
+	}
+	name := base + "." + ext
+	for n := 2; taken(name); n++ {
+		suffix := strconv.Itoa(n)
+		name = base[:min(len(base), 8-len(suffix))] + suffix + "." + ext
+	}
+	size := len(data)
+	if header != nil {
+		size += HeaderSize
+	}
+	if err := di.checkSpace("import", name, size); err != nil {
+		return "", err
+	}
+	used[name] = true
+
+	dst, err := di.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return "", err
+	}
+	if header != nil {
+		if _, err := dst.Write(header.toBytes()); err != nil {
+			return "", err
+		}
+	}
+	if _, err := dst.Write(data); err != nil {
+		return "", err
+	}
+	return name, dst.Close()
+}
+
+// ExportTape returns the disk's headered files as tape files, named by their
+// CP/M names without the extension: the BASIC programs first, so that a loader
+// comes before the code it loads, then the other files, each in directory
+// order. Files without a PLUS3DOS header are left out.
+func (di *DiskImage) ExportTape() ([]TapeFile, error) {
+	var files []TapeFile
+	for e := range di.Files(&FilesOptions{System: true}) {
+		src, err := di.OpenFile(e.GetFilename(), os.O_RDONLY)
+		if err != nil {
+			return nil, err
+		}
+		if !src.isHeadered {
+			src.Close()
+			logger.Debug("skipped headerless file", "name", e.GetFilename())
+			continue
+		}
+		fileType, length, param1, param2 := src.header.GetBasicHeader()
+		data := make([]byte, length)
+		n, err := src.ReadAt(data, HeaderSize)
+		src.Close()
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("%s: %w", e.GetFilename(), err)
+		}
+		data = data[:n]
+		if fileType != FileTypeProgram && fileType != FileTypeCode {
+			param1 <<= 8 // the array name goes in the high byte
+		}
+		files = append(files, TapeFile{
+			Name:   trimName(e.Name[:]),
+			Type:   fileType,
+			Param1: param1,
+			Param2: param2,
+			Data:   data,
+		})
+	}
+	slices.SortStableFunc(files, func(a, b TapeFile) int {
+		// FileTypeProgram is 0; every other type ranks 1.
+		return cmp.Compare(min(int(a.Type), 1), min(int(b.Type), 1))
+	})
+	return files, nil
+}
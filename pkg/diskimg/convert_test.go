@@ -4,8 +4,11 @@ package diskimg
 
 import (
 	"bytes"
+	"errors"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 
 	"github.com/ha1tch/zentools/pkg/tap"
@@ -113,3 +116,170 @@ func TestTAPDiskRoundTrip(t *testing.T) {
 		t.Errorf("round-trip data mismatch: got %d bytes, want %d", len(blocks[1].Data), len(payload))
 	}
 }
+
+// TestTZXToDisk reads a tape mixing standard speed, turbo and pure data blocks
+// with blocks carrying no data, imports it, and writes the disk back as TZX.
+func TestTZXToDisk(t *testing.T) {
+	program := []byte{0x00, 0x0A, 0x02, 0x00, 0xF9, 0x0D} // 10 RANDOMIZE
+	code := bytes.Repeat([]byte{0xC9}, 300)
+	screen := bytes.Repeat([]byte{0x55}, 100)
+
+	// TAP blocks: each is a two-byte length then flag, payload and checksum.
+	var tapImage []byte
+	tapImage = append(tapImage, tap.EncodeProgram("Manic Miner", program, 10)...)
+	tapImage = append(tapImage, tap.EncodeCode("code", code, 0x8000)...)
+	tapImage = append(tapImage, tap.EncodeCode("code", screen, 0x4000)...)
+	blocks := tapBlocks(tapImage)
+
+	tzx := []byte("ZXTape!\x1a\x01\x14")
+	tzx = append(tzx, 0x30, 4, 'T', 'e', 's', 't') // text description
+	tzx = append(tzx, 0x21, 1, 'G')                // group start
+	for i, b := range blocks {
+		switch i {
+		case 2: // turbo speed, timings as standard
+			tzx = append(tzx, 0x11, 0x78, 0x08, 0x9B, 0x02, 0xDF, 0x02, 0x57, 0x03, 0xAE, 0x06, 0x7F, 0x1F, 8,
+				0xE8, 0x03, byte(len(b)), byte(len(b)>>8), 0)
+		case 3: // pure data
+			tzx = append(tzx, 0x14, 0x57, 0x03, 0xAE, 0x06, 8, 0xE8, 0x03, byte(len(b)), byte(len(b)>>8), 0)
+		default:
+			tzx = append(tzx, 0x10, 0xE8, 0x03, byte(len(b)), byte(len(b)>>8))
+		}
+		tzx = append(tzx, b...)
+	}
+	tzx = append(tzx, 0x10, 0xE8, 0x03, 4, 0, 0xFF, 1, 2, 0xFC) // headerless
+	tzx = append(tzx, 0x22, 0x20, 0, 0)                         // group end, stop the tape
+
+	files, err := LoadTZX(bytes.NewReader(tzx))
+	if err != nil {
+		t.Fatalf("LoadTZX: %v", err)
+	}
+	if len(files) != 3 || files[0].Name != "Manic Mine" || files[0].Param1 != 10 ||
+		!bytes.Equal(files[1].Data, code) || files[2].Param1 != 0x4000 {
+		t.Fatalf("LoadTZX = %+v", files)
+	}
+
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.ImportTape(files); err != nil {
+		t.Fatalf("ImportTape: %v", err)
+	}
+	for _, name := range []string{"MANIC_MI.BAS", "CODE.BIN", "CODE2.BIN"} {
+		if _, err := di.StatFile(name); err != nil {
+			t.Errorf("StatFile(%s): %v", name, err)
+		}
+	}
+
+	exported, err := di.ExportTape()
+	if err != nil {
+		t.Fatalf("ExportTape: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := SaveTZX(&buf, exported); err != nil {
+		t.Fatalf("SaveTZX: %v", err)
+	}
+	again, err := LoadTZX(&buf)
+	if err != nil {
+		t.Fatalf("LoadTZX of SaveTZX output: %v", err)
+	}
+	if len(again) != 3 || again[0].Name != "MANIC_MI" || !bytes.Equal(again[0].Data, program) ||
+		again[0].Param1 != 10 || !bytes.Equal(again[2].Data, screen) || again[2].Param1 != 0x4000 {
+		t.Errorf("round trip = %+v", again)
+	}
+
+	if _, err := LoadTZX(bytes.NewReader(tzx[:len(tzx)-10])); !errors.Is(err, ErrInvalidTape) {
+		t.Errorf("truncated tape: %v, want ErrInvalidTape", err)
+	}
+}
+
+// tapBlocks splits a TAP image into its blocks, without their lengths.
+func tapBlocks(image []byte) [][]byte {
+	var blocks [][]byte
+	for len(image) > 0 {
+		n := int(image[0]) | int(image[1])<<8
+		blocks = append(blocks, image[2:2+n])
+		image = image[2+n:]
+	}
+	return blocks
+}
+
+// TestImportTAP imports a whole tape: two files of the same name, one already
+// on the disk, a headerless block and a damaged file.
+func TestImportTAP(t *testing.T) {
+	code := bytes.Repeat([]byte{0xC9}, 300)
+	var tape []byte
+	tape = append(tape, tap.EncodeProgram("loader", []byte{0x00, 0x0A, 0x02, 0x00, 0xF9, 0x0D}, 10)...)
+	tape = append(tape, tap.EncodeCode("code", code, 0x8000)...)
+	tape = append(tape, tap.EncodeCode("code", code[:10], 0x4000)...)
+	tape = append(tape, 5, 0, 0xFF, 1, 2, 3, 0xFF) // headerless
+	damaged := tap.EncodeCode("bad", code, 0x6000)
+	damaged[len(damaged)-1] ^= 1
+	tape = append(tape, damaged...)
+
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.WriteFile("CODE.BIN", []byte("keep")); err != nil {
+		t.Fatal(err)
+	}
+	report, err := di.ImportTAP(bytes.NewReader(tape))
+	if err != nil {
+		t.Fatalf("ImportTAP: %v", err)
+	}
+	var files []string
+	for _, b := range report {
+		files = append(files, b.File)
+	}
+	want := []string{"LOADER.BAS", "LOADER.BAS", "CODE2.BIN", "CODE2.BIN", "CODE3.BIN", "CODE3.BIN", "BLOCK006.DAT", "", ""}
+	if !slices.Equal(files, want) {
+		t.Errorf("files = %q, want %q", files, want)
+	}
+	if !errors.Is(report[7].Err, ErrInvalidTape) || !errors.Is(report[8].Err, ErrInvalidChecksum) {
+		t.Errorf("damaged file: %v, %v", report[7].Err, report[8].Err)
+	}
+
+	if got, err := fs.ReadFile(di, "CODE.BIN"); err != nil || string(got) != "keep" {
+		t.Errorf("CODE.BIN = %q, %v", got, err)
+	}
+	if fi, err := di.StatFile("CODE3.BIN"); err != nil || fi.LoadAddress != 0x4000 || fi.DataLength != 10 {
+		t.Errorf("CODE3.BIN: %+v, %v", fi, err)
+	}
+	if got, err := fs.ReadFile(di, "BLOCK006.DAT"); err != nil || !bytes.Equal(got, []byte{1, 2, 3}) {
+		t.Errorf("BLOCK006.DAT = % x, %v", got, err)
+	}
+}
+
+// TestExportAllToTAP writes a disk holding code, an array and a loader as a
+// tape, the loader first.
+func TestExportAllToTAP(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	tape := []TapeFile{
+		{Name: "code", Type: FileTypeCode, Param1: 0x8000, Param2: 0x8000, Data: bytes.Repeat([]byte{0xC9}, 200)},
+		{Name: "scores", Type: FileTypeNumericArray, Param1: 0x8100 | 'a', Param2: 0x8000, Data: make([]byte, 18)},
+		{Name: "loader", Type: FileTypeProgram, Param1: 10, Param2: 6, Data: []byte{0x00, 0x0A, 0x02, 0x00, 0xF9, 0x0D}},
+	}
+	if err := di.ImportTape(tape); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.WriteFile("NOTES.TXT", []byte("headerless")); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := di.ExportAllToTAP(&buf); err != nil {
+		t.Fatalf("ExportAllToTAP: %v", err)
+	}
+	blocks, err := tap.Decode(buf.Bytes())
+	if err != nil {
+		t.Fatalf("the TAP does not decode: %v", err)
+	}
+	if len(blocks) != 6 {
+		t.Fatalf("%d blocks, want 6", len(blocks))
+	}
+	for i, want := range []int{2, 0, 1} {
+		h, data := blocks[2*i], blocks[2*i+1]
+		if !h.IsHeader || !h.ChecksumOK || !data.ChecksumOK {
+			t.Fatalf("file %d: header %v, checksums %v %v", i, h.IsHeader, h.ChecksumOK, data.ChecksumOK)
+		}
+		f := tape[want]
+		if h.Type != f.Type || h.Param1&0xFF00 != f.Param1&0xFF00 || !bytes.Equal(data.Data, f.Data) {
+			t.Errorf("file %d = %s type %d param1 %#x, want %s", i, h.Name, h.Type, h.Param1, f.Name)
+		}
+	}
+}
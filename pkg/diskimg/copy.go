@@ -0,0 +1,82 @@
+// file: pkg/diskimg/copy.go
+
+package diskimg
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// CopyOptions configures CopyFile.
+type CopyOptions struct {
+	NewName   string // name on the destination disk; the source name if empty
+	Overwrite bool   // replace a file of that name on the destination
+}
+
+// CopyFile copies a file from src to dst without going through the host
+// filesystem: its data with any PLUS3DOS header, its exact size and its
+// attributes. The disks may have different formats; between a CPC format and
+// another, a CODE file's PLUS3DOS header becomes an AMSDOS one or back, as
+// Plus3ToAmsdos and AmsdosToPlus3 convert them. src and dst may be the same
+// disk if the copy has a new name.
+func CopyFile(src, dst *DiskImage, name string, opts *CopyOptions) error {
+	if opts == nil {
+		opts = &CopyOptions{}
+	}
+	f, err := src.OpenFile(name, os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, f.size)
+	if n, err := f.ReadAt(data, 0); n < len(data) {
+		return fmt.Errorf("%s: %w", f.entry.GetFilename(), err)
+	}
+	// Keep the attribute bits before dst changes, in case it is src.
+	attrName, attrExt := f.entry.Name, f.entry.Extension
+
+	newName := opts.NewName
+	if strings.TrimSpace(newName) == "" {
+		newName = f.entry.GetFilename()
+	}
+	if newName, err = dst.diskName(newName); err != nil {
+		return err
+	}
+	data = convertHeader(src.spec, dst.spec, newName, data)
+	existing, err := dst.directory.FindFile(newName)
+	if err == nil {
+		if src == dst && existing == f.entry {
+			return fmt.Errorf("%w: cannot copy %s onto itself", fs.ErrInvalid, newName)
+		}
+		if !opts.Overwrite {
+			return &FileError{Op: "copy", Name: newName, Err: ErrFileExists}
+		}
+	}
+	if err := dst.checkSpace("copy", newName, len(data)); err != nil {
+		return err
+	}
+	if existing != nil {
+		if err := dst.DeleteFile(newName); err != nil {
+			return err
+		}
+	}
+	if err := dst.writeRecords(newName, data); err != nil {
+		return err
+	}
+
+	first, err := dst.directory.FindFile(newName)
+	if err != nil {
+		return err
+	}
+	for _, e := range dst.directory.fileExtents(first) {
+		for i := range e.Name {
+			e.Name[i] |= attrName[i] & 0x80
+		}
+		for i := range e.Extension {
+			e.Extension[i] |= attrExt[i] & 0x80
+		}
+	}
+	dst.Modified = true
+	return dst.FlushDirectory()
+}
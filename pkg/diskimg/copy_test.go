@@ -0,0 +1,60 @@
+package diskimg
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// CopyFile copies a file's data, header, size and attributes between disks
+// of different formats, and within one disk under a new name.
+func TestCopyFile(t *testing.T) {
+	src := newSpecImage(t, SpecPlus3)
+	header, _ := importHeader(60000, &ImportOptions{AddHeader: true, FileType: FileTypeCode, LoadAddr: 24576})
+	data := append(header.toBytes(), bytes.Repeat([]byte{0xA5, 0x5A, 0x00}, 20000)...)
+	if err := src.writeRecords("GAME.BIN", data); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := src.directory.FindFile("GAME.BIN")
+	first.SetAttributes(true, false, true)
+
+	dst := newSpecImage(t, SpecPlus3DS)
+	if err := CopyFile(src, dst, "game.bin", nil); err != nil {
+		t.Fatalf("CopyFile: %v", err)
+	}
+	if err := CopyFile(src, dst, "GAME.BIN", nil); !errors.Is(err, ErrFileExists) {
+		t.Errorf("second CopyFile: err = %v, want ErrFileExists", err)
+	}
+	if err := CopyFile(src, dst, "GAME.BIN", &CopyOptions{Overwrite: true}); err != nil {
+		t.Errorf("CopyFile with Overwrite: %v", err)
+	}
+
+	dst = reload(t, dst)
+	h, err := dst.ReadHeader("GAME.BIN")
+	if err != nil || h == nil || h.FileLength != uint32(len(data)) {
+		t.Fatalf("ReadHeader = %+v, %v", h, err)
+	}
+	if size, _ := dst.FileSize("GAME.BIN"); size != len(data) {
+		t.Errorf("FileSize = %d, want %d", size, len(data))
+	}
+	if got, _ := dst.readRecords("GAME.BIN"); !bytes.Equal(got[:len(data)], data) {
+		t.Error("copied data differs")
+	}
+	copied, _ := dst.directory.FindFile("GAME.BIN")
+	if readOnly, _, system := copied.GetAttributes(); !readOnly || !system {
+		t.Error("attributes were not copied")
+	}
+
+	if err := CopyFile(src, src, "GAME.BIN", nil); err == nil {
+		t.Error("CopyFile onto itself succeeded")
+	}
+	if err := CopyFile(src, src, "GAME.BIN", &CopyOptions{NewName: "backup.bin"}); err != nil {
+		t.Fatalf("CopyFile to a new name: %v", err)
+	}
+	if size, _ := src.FileSize("BACKUP.BIN"); size != len(data) {
+		t.Errorf("FileSize(BACKUP.BIN) = %d, want %d", size, len(data))
+	}
+	if err := CopyFile(src, src, "GAME.BIN", &CopyOptions{NewName: "GAME2.BIN"}); !errors.Is(err, ErrDiskFull) {
+		t.Errorf("CopyFile to a full disk: err = %v, want ErrDiskFull", err)
+	}
+}
@@ -0,0 +1,59 @@
+// file: pkg/diskimg/cpmsystem.go
+
+package diskimg
+
+import (
+	"bytes"
+	"strings"
+)
+
+// CPMSystem describes the CP/M Plus system on a disk, as CPMSystem finds it.
+type CPMSystem struct {
+	System   bool     `json:"system"`              // a CP/M Plus system disk
+	EMSFiles []string `json:"ems_files,omitempty"` // the CP/M Plus system files, as J14CPM3.EMS
+	COMFiles []string `json:"com_files,omitempty"` // CP/M programs
+}
+
+// isCPMProgram reports whether a file is a CP/M program, which CP/M loads at
+// 0x100 as it is: its first record is code, never a PLUS3DOS header.
+func isCPMProgram(name string) bool {
+	return strings.HasSuffix(NormaliseFilename(name), ".COM")
+}
+
+// CPMSystem reports whether the disk is a CP/M Plus system disk: one with
+// system tracks and a CP/M Plus system file (.EMS, or .EMT on some PCWs), or a
+// bootable one with CP/M programs (.COM files).
+func (di *DiskImage) CPMSystem() CPMSystem {
+	var cs CPMSystem
+	for e := range di.Files(&FilesOptions{System: true}) {
+		name := e.GetFilename()
+		switch {
+		case strings.HasSuffix(name, ".EMS"), strings.HasSuffix(name, ".EMT"):
+			cs.EMSFiles = append(cs.EMSFiles, name)
+		case isCPMProgram(name):
+			cs.COMFiles = append(cs.COMFiles, name)
+		}
+	}
+	if di.spec.ReservedTracks > 0 {
+		cs.System = len(cs.EMSFiles) > 0 || len(cs.COMFiles) > 0 && di.ValidateBootSector() == nil
+	}
+	return cs
+}
+
+// systemSpace returns the space of the system tracks' sectors that hold
+// something, a disk specification, boot loader or CP/M itself, rather than one byte throughout,
+// as a freshly formatted sector does.
+func (di *DiskImage) systemSpace() Space {
+	spec := di.spec
+	var used int64
+	for t := 0; t < spec.ReservedTracks; t++ {
+		track, side := spec.PhysicalTrack(t)
+		for s := 0; s < spec.SectorsPerTrack; s++ {
+			data, err := di.GetSectorData(track, s, side)
+			if err == nil && len(data) > 0 && bytes.Count(data, data[:1]) != len(data) {
+				used += int64(len(data))
+			}
+		}
+	}
+	return Space{Blocks: int(used / int64(spec.BlockSize)), Bytes: used}
+}
@@ -0,0 +1,47 @@
+package diskimg
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCPMSystem(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	if cs := di.CPMSystem(); cs.System {
+		t.Errorf("blank disk: %+v", cs)
+	}
+	for _, name := range []string{"J14CPM3.EMS", "PIP.COM", "README.TXT"} {
+		if err := di.WriteFile(name, []byte{0xC3, 0, 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cs := di.CPMSystem()
+	if !cs.System || len(cs.EMSFiles) != 1 || len(cs.COMFiles) != 1 || cs.COMFiles[0] != "PIP.COM" {
+		t.Errorf("CPMSystem = %+v", cs)
+	}
+}
+
+// A .COM file is a CP/M program, loaded as it is: a first record that looks
+// like a PLUS3DOS header is still code.
+func TestCOMFileHasNoHeader(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.ImportData("TOOL.COM", []byte("code"), &ImportOptions{AddHeader: true, FileType: FileTypeCode, LoadAddr: 0x100}); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := di.StatFile("TOOL.COM")
+	if err != nil || fi.Headered() || fi.Size != HeaderSize+4 {
+		t.Errorf("StatFile = %+v, %v; want a headerless file of 132 bytes", fi, err)
+	}
+}
+
+func TestSystemSpace(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	base := di.SpaceInfo().System.Bytes
+	loader := bytes.Repeat([]byte{0x00, 0xC9}, 256)
+	if err := di.SetSectorData(0, 1, 0, loader); err != nil {
+		t.Fatal(err)
+	}
+	if got := di.SpaceInfo().System.Bytes; got != base+512 {
+		t.Errorf("System = %d bytes, want %d", got, base+512)
+	}
+}
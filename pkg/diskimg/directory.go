@@ -3,9 +3,11 @@ package diskimg
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
 	"fmt"
+	"slices"
+	"sort"
 	"strings"
+	"unicode/utf8"
 )
 
 // DirectoryEntry represents a single directory entry in +3DOS format
@@ -14,7 +16,7 @@ type DirectoryEntry struct {
 	Name             [8]byte  // F0-F7: file name (padded with spaces)
 	Extension        [3]byte  // E0-E2: extension (padded; high bits are attributes)
 	Extent           byte     // Xl: extent number low byte
-	Reserved1        byte     // Bc: byte count (last record byte count)
+	Reserved1        byte     // Bc: bytes used in the last record (0 = all 128)
 	Reserved2        byte     // Xh: extent number high byte
 	RecordCount      byte     // Rc: number of 128-byte records in this extent
 	AllocationBlocks [16]byte // Al: block numbers used by this extent
@@ -22,32 +24,30 @@ type DirectoryEntry struct {
 
 // This struct is exactly 32 bytes, matching the CP/M directory entry layout.
 
-// SetAttributes sets file attributes using constants from fileattr.go
+// SetAttributes sets file attributes in the high bits of the extension
+// characters, t1 (read-only) and t2 (system). CP/M leaves system files out of
+// the catalogue, so hidden and system are the same attribute.
 func (de *DirectoryEntry) SetAttributes(readOnly, hidden, system bool) {
-	var attr byte
+	de.Extension[0] &= 0x7F
 	if readOnly {
-		attr |= AttrReadOnly
+		de.Extension[0] |= 0x80
 	}
-	if hidden {
-		attr |= AttrHidden
+	de.Extension[1] &= 0x7F
+	if hidden || system {
+		de.Extension[1] |= 0x80
 	}
-	if system {
-		attr |= AttrSystem
-	}
-	de.Reserved1 = (de.Reserved1 & 0xD8) | attr
 }
 
-// GetAttributes retrieves file attributes using constants from fileattr.go
+// GetAttributes retrieves file attributes (see SetAttributes).
 func (de *DirectoryEntry) GetAttributes() (readOnly, hidden, system bool) {
-	return (de.Reserved1 & AttrReadOnly) != 0,
-		(de.Reserved1 & AttrHidden) != 0,
-		(de.Reserved1 & AttrSystem) != 0
+	system = de.Extension[1]&0x80 != 0
+	return de.Extension[0]&0x80 != 0, system, system
 }
 
 // Load reads directory entries from raw disk data
 func (d *Directory) Load(data []byte) error {
 	if len(data)%32 != 0 {
-		return errors.New("invalid directory size: must be a multiple of 32 bytes")
+		return fmt.Errorf("%w: directory size must be a multiple of 32 bytes", ErrCorruptImage)
 	}
 	numEntries := len(data) / 32
 	d.Entries = make([]DirectoryEntry, numEntries)
@@ -58,6 +58,8 @@ func (d *Directory) Load(data []byte) error {
 			return fmt.Errorf("failed to read directory entry %d: %w", i, err)
 		}
 	}
+	d.migrate()
+	d.Reindex()
 	return nil
 }
 
@@ -65,9 +67,9 @@ func (d *Directory) Load(data []byte) error {
 func (d *Directory) Save() ([]byte, error) {
 	var buffer bytes.Buffer
 	for i, entry := range d.Entries {
-		// An empty slot (status 0x00 with no name, or already 0xE5) is written as a
-		// full 0xE5 entry, the CP/M unused-entry marker.
-		if entry.isFree() {
+		// A free entry is written 0xE5 throughout, unless it keeps the name
+		// of a file deleted from it.
+		if entry.IsUnused() && !entry.IsDeleted() {
 			filler := make([]byte, DirectoryEntrySize)
 			for j := range filler {
 				filler[j] = 0xE5
@@ -82,16 +84,10 @@ func (d *Directory) Save() ([]byte, error) {
 	return buffer.Bytes(), nil
 }
 
-// FindEntryByName searches for a directory entry by its name
+// FindEntryByName searches for a directory entry by its name, as FindFile
+// does.
 func (d *Directory) FindEntryByName(name string) (*DirectoryEntry, error) {
-	name = strings.ToUpper(name)
-	for i := range d.Entries {
-		entryName := strings.TrimSpace(string(d.Entries[i].Name[:]))
-		if entryName == name {
-			return &d.Entries[i], nil
-		}
-	}
-	return nil, errors.New("file not found")
+	return d.FindFile(name)
 }
 
 // DeleteEntry marks a directory entry as deleted
@@ -107,87 +103,345 @@ func (d *Directory) DeleteEntry(name string) error {
 // Directory is a wrapper for managing directory entries
 type Directory struct {
 	Entries []DirectoryEntry
+
+	// index maps each upper-cased file name to the indices of the entries
+	// holding a file of that name, of any user; nil until a lookup builds it.
+	index map[string][]int
 }
 
-// FindFile searches for a file by name in the directory
+// Reindex drops the name index FindFile looks files up in, to be rebuilt by
+// the next lookup. Code that changes Entries other than through the
+// Directory's methods calls it after.
+func (d *Directory) Reindex() {
+	d.index = nil
+}
+
+// lookup returns the indices of the entries holding a file named key, upper
+// case. An index that has a stale entry under key is rebuilt.
+func (d *Directory) lookup(key string) []int {
+	if d.index != nil {
+		for _, i := range d.index[key] {
+			if i >= len(d.Entries) || !d.Entries[i].IsFile() || !d.Entries[i].nameIs(key) {
+				d.index = nil
+				break
+			}
+		}
+	}
+	if d.index == nil {
+		d.index = make(map[string][]int)
+		for i := range d.Entries {
+			d.indexEntry(i)
+		}
+	}
+	return d.index[key]
+}
+
+// indexEntry adds the i-th entry to the index, if there is one and the entry
+// holds a file.
+func (d *Directory) indexEntry(i int) {
+	if d.index == nil || !d.Entries[i].IsFile() {
+		return
+	}
+	key := NormaliseFilename(d.Entries[i].GetFilename())
+	if !slices.Contains(d.index[key], i) {
+		d.index[key] = append(d.index[key], i)
+	}
+}
+
+// FindFile searches for a file by name in the directory. For a file with more
+// than one extent it returns the first.
 func (d *Directory) FindFile(filename string) (*DirectoryEntry, error) {
-	target := strings.ToUpper(strings.TrimSpace(filename))
+	target := NormaliseFilename(filename)
+	var found *DirectoryEntry
+	for _, i := range d.lookup(target) {
+		if e := &d.Entries[i]; found == nil || e.extentNumber() < found.extentNumber() {
+			found = e
+		}
+	}
+	if found == nil {
+		return nil, &FileError{Op: "find", Name: target, Err: ErrFileNotFound}
+	}
+	return found, nil
+}
+
+// RenameFile gives a file a new name, in every one of its extents. The
+// attribute bits in the high bits of the name and extension characters are
+// kept. The new name must be a valid 8.3 name not used by another file.
+func (d *Directory) RenameFile(oldName, newName string) error {
+	if err := validateFilename(newName); err != nil {
+		return err
+	}
+	first, err := d.FindFile(oldName)
+	if err != nil {
+		return err
+	}
+	if other, err := d.FindFile(newName); err == nil && other.Status == first.Status && other != first {
+		return &FileError{Op: "rename", Name: NormaliseFilename(newName), Err: ErrFileExists}
+	}
+	name, ext := splitFilename(newName)
+	for _, e := range d.fileExtents(first) {
+		for i := range e.Name {
+			e.Name[i] = e.Name[i]&0x80 | name[i]
+		}
+		for i := range e.Extension {
+			e.Extension[i] = e.Extension[i]&0x80 | ext[i]
+		}
+	}
+	d.Reindex()
+	return nil
+}
+
+// validateFilename checks that name is an 8.3 file name: a name of one to
+// eight characters and an optional extension of up to three, printable and
+// free of the characters CP/M gives a meaning to. The error says which rule
+// the name breaks.
+func validateFilename(name string) error {
+	base, ext, _ := strings.Cut(name, ".")
+	var reason string
+	switch {
+	case base == "":
+		reason = "no name before the extension"
+	case len(base) > 8:
+		reason = fmt.Sprintf("name %q is longer than 8 characters", base)
+	case len(ext) > 3 && !strings.Contains(ext, "."):
+		reason = fmt.Sprintf("extension %q is longer than 3 characters", ext)
+	default:
+		if i := strings.IndexFunc(base+ext, notCPM); i >= 0 {
+			r, _ := utf8.DecodeRuneInString((base + ext)[i:])
+			reason = fmt.Sprintf("%q is not allowed in a CP/M name", r)
+		}
+	}
+	if reason != "" {
+		return &FileError{Op: "validate", Name: name, Err: fmt.Errorf("%w: %s", ErrInvalidFilename, reason)}
+	}
+	return nil
+}
+
+// notCPM reports whether r cannot be part of a CP/M file name or extension:
+// a control character, space, or one of the characters CP/M gives a meaning
+// to.
+func notCPM(r rune) bool {
+	return r <= ' ' || r > '~' || strings.ContainsRune(`<>.,;:=?*[]`, r)
+}
+
+// fileExtents returns the entries of the file whose first entry is first, in
+// extent order: every entry with the same user number and name.
+func (d *Directory) fileExtents(first *DirectoryEntry) []*DirectoryEntry {
+	var name, other [12]byte
+	n := first.appendFilename(name[:0])
+	var extents []*DirectoryEntry
+	for _, i := range d.lookup(NormaliseFilename(string(n))) {
+		e := &d.Entries[i]
+		if e.Status == first.Status && bytes.Equal(e.appendFilename(other[:0]), n) {
+			extents = append(extents, e)
+		}
+	}
+	sort.SliceStable(extents, func(i, j int) bool {
+		return extents[i].extentNumber() < extents[j].extentNumber()
+	})
+	return extents
+}
+
+// addExtent adds an entry for a further extent of the file whose first entry
+// is first. The new entry has the same user number, name and attributes, and
+// no blocks.
+func (d *Directory) addExtent(first *DirectoryEntry) (*DirectoryEntry, error) {
 	for i := range d.Entries {
 		if d.Entries[i].IsUnused() {
-			continue
-		}
-		if strings.EqualFold(d.Entries[i].GetFilename(), target) {
+			d.Entries[i] = *first
+			d.Entries[i].RecordCount = 0
+			d.Entries[i].AllocationBlocks = [16]byte{}
+			d.indexEntry(i)
 			return &d.Entries[i], nil
 		}
 	}
-	return nil, fmt.Errorf("file %s not found", filename)
+	return nil, ErrDirectoryFull
 }
 
 // AddFile adds a new file entry to the directory
 func (d *Directory) AddFile(entry DirectoryEntry) error {
 	for i := range d.Entries {
-		if d.Entries[i].isFree() {
+		if d.Entries[i].IsUnused() {
 			d.Entries[i] = entry
 			d.Entries[i].Status = 0x00 // user 0 (default user area)
+			d.indexEntry(i)
 			return nil
 		}
 	}
-	return fmt.Errorf("no free directory entry slots available")
+	return ErrDirectoryFull
+}
+
+// blockPointers returns the block numbers in the entry's allocation field:
+// sixteen 8-bit pointers, or eight 16-bit little-endian ones when wide (a disk
+// of more than 256 blocks). Zero pointers mark unused slots and are omitted.
+func (de *DirectoryEntry) blockPointers(wide bool) []int {
+	var blocks []int
+	for _, b := range de.blockSlots(wide) {
+		if b != 0 {
+			blocks = append(blocks, b)
+		}
+	}
+	return blocks
+}
+
+// blockSlots returns every pointer in the entry's allocation field, in order,
+// zeros included: a zero before the last block is a hole in a sparse file.
+func (de *DirectoryEntry) blockSlots(wide bool) []int {
+	if wide {
+		slots := make([]int, len(de.AllocationBlocks)/2)
+		for i := range slots {
+			slots[i] = int(de.AllocationBlocks[2*i]) | int(de.AllocationBlocks[2*i+1])<<8
+		}
+		return slots
+	}
+	slots := make([]int, len(de.AllocationBlocks))
+	for i, b := range de.AllocationBlocks {
+		slots[i] = int(b)
+	}
+	return slots
+}
+
+// setBlockPointers stores blocks in the entry's allocation field (see
+// blockPointers), clearing the unused slots. It returns how many blocks fit.
+func (de *DirectoryEntry) setBlockPointers(blocks []int, wide bool) int {
+	de.AllocationBlocks = [16]byte{}
+	if wide {
+		n := min(len(blocks), len(de.AllocationBlocks)/2)
+		for i, b := range blocks[:n] {
+			de.AllocationBlocks[2*i] = byte(b)
+			de.AllocationBlocks[2*i+1] = byte(b >> 8)
+		}
+		return n
+	}
+	n := min(len(blocks), len(de.AllocationBlocks))
+	for i, b := range blocks[:n] {
+		de.AllocationBlocks[i] = byte(b)
+	}
+	return n
+}
+
+// extentNumber returns the entry's logical extent number, from its Xl and Xh
+// bytes.
+func (de *DirectoryEntry) extentNumber() int {
+	return int(de.Reserved2&0x3F)<<5 | int(de.Extent&0x1F)
+}
+
+// setExtentNumber stores n in the entry's Xl and Xh bytes.
+func (de *DirectoryEntry) setExtentNumber(n int) {
+	de.Extent = byte(n & 0x1F)
+	de.Reserved2 = byte(n >> 5 & 0x3F)
 }
 
-// IsUnused reports whether this directory entry is empty (CP/M marks empty and
-// deleted entries alike with status 0xE5).
+// IsUnused reports whether this directory entry is free for a file: its
+// status is 0xE5, the CP/M marker of an entry never used or deleted. An entry
+// with a user number, 0-15, holds a file.
 func (de *DirectoryEntry) IsUnused() bool {
 	return de.Status == 0xE5
 }
 
-// IsDeleted reports whether this entry has been deleted. In CP/M a deleted entry
-// uses the same 0xE5 marker as an unused one, so the two are indistinguishable.
+// IsDeleted reports whether this entry is free but still names the file
+// deleted from it, as CP/M leaves an entry it deletes. An entry never used is
+// 0xE5 throughout.
 func (de *DirectoryEntry) IsDeleted() bool {
-	return de.Status == 0xE5
+	return de.Status == 0xE5 && de.Name[0] != 0xE5 && !de.blankName()
+}
+
+// IsFile reports whether this entry holds a file: it is in use, and is not a
+// CP/M Plus directory label or datestamp entry.
+func (de *DirectoryEntry) IsFile() bool {
+	return de.Status < statusLabel
 }
 
 // GetFilename returns the file name as "NAME.EXT", trimmed of padding spaces and
 // with the high (attribute) bits of each character stripped.
 func (de *DirectoryEntry) GetFilename() string {
-	name := make([]byte, 0, 12)
-	for _, b := range de.Name {
-		b &= 0x7F // strip attribute bit
-		if b == ' ' || b == 0 {
-			continue
+	var name [12]byte
+	return string(de.appendFilename(name[:0]))
+}
+
+// appendFilename appends the file name, as GetFilename returns it, to b.
+func (de *DirectoryEntry) appendFilename(b []byte) []byte {
+	for _, c := range de.Name {
+		c &= 0x7F // strip attribute bit
+		if c != ' ' && c != 0 {
+			b = append(b, c)
 		}
-		name = append(name, b)
 	}
-	ext := make([]byte, 0, 3)
-	for _, b := range de.Extension {
-		b &= 0x7F
-		if b == ' ' || b == 0 {
-			continue
+	dot := len(b)
+	b = append(b, '.')
+	for _, c := range de.Extension {
+		c &= 0x7F
+		if c != ' ' && c != 0 {
+			b = append(b, c)
 		}
-		ext = append(ext, b)
 	}
-	if len(ext) == 0 {
-		return string(name)
+	if len(b) == dot+1 {
+		return b[:dot]
 	}
-	return string(name) + "." + string(ext)
+	return b
 }
 
-// isFree reports whether this entry is an empty/reusable slot: either the CP/M
-// unused marker (0xE5) or an uninitialised zero entry with no name. A real file
-// in user area 0 has status 0x00 but a non-blank name and is NOT free.
-func (de *DirectoryEntry) isFree() bool {
-	if de.Status == 0xE5 {
-		return true
+// nameIs reports whether the entry's file name is key, upper case, ignoring
+// the case of the name, without building it.
+func (de *DirectoryEntry) nameIs(key string) bool {
+	var name [12]byte
+	n := de.appendFilename(name[:0])
+	if len(n) != len(key) {
+		return false
 	}
-	if de.Status == 0x00 {
-		blank := true
-		for _, b := range de.Name {
-			if b != 0 && b != ' ' {
-				blank = false
-				break
-			}
+	for i, c := range n {
+		if 'a' <= c && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		if c != key[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// blankName reports whether the entry's name is all spaces or zeros.
+func (de *DirectoryEntry) blankName() bool {
+	for _, b := range de.Name {
+		if b != 0 && b != ' ' {
+			return false
+		}
+	}
+	return true
+}
+
+// unusedEntry returns a directory entry never used: 0xE5 throughout, as a
+// freshly formatted disk has it.
+func unusedEntry() DirectoryEntry {
+	var e DirectoryEntry
+	binary.Read(bytes.NewReader(bytes.Repeat([]byte{0xE5}, DirectoryEntrySize)), binary.LittleEndian, &e)
+	return e
+}
+
+// emptyEntries returns n directory entries, all never used.
+func emptyEntries(n int) []DirectoryEntry {
+	entries := make([]DirectoryEntry, n)
+	for i := range entries {
+		entries[i] = unusedEntry()
+	}
+	return entries
+}
+
+// migrate frees the entries that earlier versions of plus3 wrote for an empty
+// slot, status 0x00 with a blank name, taken to be free until then, and
+// returns how many there were. An entry of user 0 holds a file; an empty one
+// is 0xE5.
+func (d *Directory) migrate() int {
+	n := 0
+	for i := range d.Entries {
+		if e := &d.Entries[i]; e.Status == 0x00 && e.blankName() {
+			*e = unusedEntry()
+			n++
 		}
-		return blank
 	}
-	return false
+	if n > 0 {
+		logger.Debug("freed empty directory entries of user 0", "entries", n)
+		d.Reindex()
+	}
+	return n
 }
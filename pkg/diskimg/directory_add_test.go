@@ -1,6 +1,9 @@
 package diskimg
 
-import "testing"
+import (
+	"errors"
+	"testing"
+)
 
 // These tests pin down the behaviour of the directory add operation: placement in
 // the first free slot, user-area-0 enforcement, no overwrite of an existing file,
@@ -106,8 +109,8 @@ func TestAddFile_FullDirectoryErrors(t *testing.T) {
 			d := &Directory{Entries: make([]DirectoryEntry, 2)}
 			d.Entries[0] = namedEntry("ONE")
 			d.Entries[1] = namedEntry("TWO")
-			if err := f.add(d, namedEntry("THREE")); err == nil {
-				t.Error("expected error on full directory, got nil")
+			if err := f.add(d, namedEntry("THREE")); !errors.Is(err, ErrDirectoryFull) {
+				t.Errorf("full directory: err = %v, want ErrDirectoryFull", err)
 			}
 		})
 	}
@@ -5,35 +5,50 @@ package diskimg
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
 	"fmt"
-	"strings"
+	"os"
 )
 
-// Constants for +3DOS directory handling
+// Constants for +3DOS directory handling. The track and size constants
+// describe the standard +3 format; in general the directory occupies the first
+// DirBlocks allocation blocks of the disk's DiskSpec.
 const (
 	DirectoryTrack         = 1  // Directory track (XDPB OFF=1: track 0 is the reserved system track)
 	DirectoryStartSector   = 0  // First data sector index of the directory within the track
 	DirectorySizeInSectors = 4  // Directory occupies 4 sectors
 	DirectoryEntrySize     = 32 // Size of a single directory entry in bytes
-	MaxDirectoryEntries    = 64 // +3 standard format: 2K dir / 32 bytes = 64 entries
+
+	// Deprecated: the directory holds DiskSpec.DirEntries entries, 64 on a
+	// standard +3 disk and 256 on a 720k one.
+	MaxDirectoryEntries = 64
 )
 
+// directorySectors returns the number of sectors holding the directory.
+func (di *DiskImage) directorySectors() int {
+	return di.spec.DirBlocks * di.spec.SectorsPerBlock()
+}
+
+// directorySector returns the physical location of the n-th directory sector.
+func (di *DiskImage) directorySector(n int) (track, sector, side int) {
+	spb := di.spec.SectorsPerBlock()
+	return di.spec.BlockSector(n/spb, n%spb)
+}
+
 // readDirectory reads all directory sectors from the disk
 func (di *DiskImage) readDirectory() ([]byte, error) {
 	// Allocate buffer for directory data
-	dirData := make([]byte, DirectorySizeInSectors*BytesPerSector)
+	size := di.spec.SectorSize
+	dirData := make([]byte, di.directorySectors()*size)
 
 	// Read each sector
-	for sector := 0; sector < DirectorySizeInSectors; sector++ {
-		sectorData, err := di.GetSectorData(DirectoryTrack, sector+DirectoryStartSector, 0)
+	for n := 0; n < di.directorySectors(); n++ {
+		sectorData, err := di.GetSectorData(di.directorySector(n))
 		if err != nil {
-			return nil, fmt.Errorf("failed to read directory sector %d: %w", sector, err)
+			return nil, fmt.Errorf("failed to read directory sector %d: %w", n, err)
 		}
 
 		// Copy sector data into buffer
-		offset := sector * BytesPerSector
-		copy(dirData[offset:], sectorData)
+		copy(dirData[n*size:], sectorData)
 	}
 
 	return dirData, nil
@@ -41,18 +56,18 @@ func (di *DiskImage) readDirectory() ([]byte, error) {
 
 // writeDirectory writes directory data back to disk
 func (di *DiskImage) writeDirectory(dirData []byte) error {
-	if len(dirData) > DirectorySizeInSectors*BytesPerSector {
-		return errors.New("directory data exceeds maximum size")
+	size := di.spec.SectorSize
+	if len(dirData) > di.directorySectors()*size {
+		return fmt.Errorf("%w: directory data exceeds maximum size", ErrCorruptImage)
 	}
 
 	// Write each sector
-	for sector := 0; sector < DirectorySizeInSectors; sector++ {
-		offset := sector * BytesPerSector
-		sectorData := dirData[offset : offset+BytesPerSector]
+	for n := 0; n < di.directorySectors(); n++ {
+		sectorData := dirData[n*size : (n+1)*size]
 
-		err := di.SetSectorData(DirectoryTrack, sector+DirectoryStartSector, 0, sectorData)
-		if err != nil {
-			return fmt.Errorf("failed to write directory sector %d: %w", sector, err)
+		track, sector, side := di.directorySector(n)
+		if err := di.SetSectorData(track, sector, side, sectorData); err != nil {
+			return fmt.Errorf("failed to write directory sector %d: %w", n, err)
 		}
 	}
 
@@ -62,7 +77,7 @@ func (di *DiskImage) writeDirectory(dirData []byte) error {
 // InitializeDirectory creates an empty directory on the disk
 func (di *DiskImage) InitializeDirectory() error {
 	// Create empty directory data
-	dirData := make([]byte, DirectorySizeInSectors*BytesPerSector)
+	dirData := make([]byte, di.directorySectors()*di.spec.SectorSize)
 	for i := range dirData {
 		dirData[i] = 0xE5 // Mark all entries as deleted
 	}
@@ -76,7 +91,8 @@ func (di *DiskImage) InitializeDirectory() error {
 	return nil
 }
 
-// GetDirectory returns the directory data as a slice of entries
+// GetDirectory returns the directory data as a slice of entries, as they are
+// on the disk: a free entry keeps what is left of a file deleted from it.
 func (di *DiskImage) GetDirectory() ([]DirectoryEntry, error) {
 	dirData, err := di.readDirectory()
 	if err != nil {
@@ -84,16 +100,9 @@ func (di *DiskImage) GetDirectory() ([]DirectoryEntry, error) {
 	}
 
 	// Parse directory entries
-	entries := make([]DirectoryEntry, MaxDirectoryEntries)
-	for i := 0; i < MaxDirectoryEntries; i++ {
+	entries := make([]DirectoryEntry, len(dirData)/DirectoryEntrySize)
+	for i := range entries {
 		offset := i * DirectoryEntrySize
-		if dirData[offset] == 0xE5 {
-			// Unused/deleted entry - preserve the 0xE5 marker so callers can
-			// identify free slots (IsUnused) and reuse them.
-			entries[i].Status = 0xE5
-			continue
-		}
-
 		entryData := dirData[offset : offset+DirectoryEntrySize]
 		entry := DirectoryEntry{}
 		err := binary.Read(bytes.NewReader(entryData), binary.LittleEndian, &entry)
@@ -107,14 +116,15 @@ func (di *DiskImage) GetDirectory() ([]DirectoryEntry, error) {
 }
 
 // FlushDirectory serializes the in-memory directory and writes it to the
-// directory sectors (track 1). Empty entries are stored with the 0xE5 marker.
+// directory sectors (track 1 on a +3 disk). Empty entries are stored with the
+// 0xE5 marker.
 func (di *DiskImage) FlushDirectory() error {
 	dirData, err := di.directory.Save()
 	if err != nil {
 		return err
 	}
 	// Pad/trim to the directory area size and ensure empty entries are 0xE5.
-	want := DirectorySizeInSectors * BytesPerSector
+	want := di.directorySectors() * di.spec.SectorSize
 	if len(dirData) < want {
 		pad := make([]byte, want-len(dirData))
 		for i := range pad {
@@ -127,39 +137,146 @@ func (di *DiskImage) FlushDirectory() error {
 	return di.writeDirectory(dirData)
 }
 
-// DeleteFile removes a file from the disk: it frees the file's allocation blocks,
-// marks its directory entry unused (0xE5), and flushes the directory to disk.
-func (di *DiskImage) DeleteFile(filename string) error {
-	idx := -1
-	for i := range di.directory.Entries {
-		e := &di.directory.Entries[i]
-		if e.IsUnused() {
-			continue
-		}
-		if strings.EqualFold(e.GetFilename(), strings.ToUpper(strings.TrimSpace(filename))) {
-			idx = i
-			break
+// RenameFile renames a file on the disk (see Directory.RenameFile) and
+// flushes the directory to disk.
+func (di *DiskImage) RenameFile(oldName, newName string) error {
+	first, err := di.directory.FindFile(oldName)
+	if err != nil {
+		return fileError("rename", oldName, err)
+	}
+	name := first.GetFilename()
+	if newName, err = di.diskName(newName); err != nil {
+		return fileError("rename", newName, err)
+	}
+	if err := di.directory.RenameFile(oldName, newName); err != nil {
+		return fileError("rename", oldName, err)
+	}
+	di.record(Change{Op: ChangeRename, Name: name, NewName: first.GetFilename()})
+	logger.Debug("renamed file", "name", name, "new_name", first.GetFilename())
+	di.Modified = true
+	return di.FlushDirectory()
+}
+
+// FileEntries returns the first directory entry of each file on the disk, in
+// directory order. A file larger than one entry can address has further
+// entries, one per extent, which are left out; FileRecords gives the size of
+// the whole file.
+func (di *DiskImage) FileEntries() ([]DirectoryEntry, error) {
+	dir, err := di.GetDirectory()
+	if err != nil {
+		return nil, err
+	}
+	var files []DirectoryEntry
+	for _, e := range dir {
+		if e.IsFile() && e.extentNumber() <= di.spec.ExtentMask() {
+			files = append(files, e)
 		}
 	}
-	if idx < 0 {
-		return fmt.Errorf("file not found: %s", filename)
+	return files, nil
+}
+
+// FileRecords returns the number of 128-byte records in a file, over all its
+// extents.
+func (di *DiskImage) FileRecords(filename string) (int, error) {
+	first, err := di.directory.FindFile(filename)
+	if err != nil {
+		return 0, err
+	}
+	return di.fileRecords(di.directory.fileExtents(first)), nil
+}
+
+// FileSize returns the exact length of a file in bytes: the length in its
+// PLUS3DOS header if it has one, otherwise its records less the unused bytes
+// of the last record when the directory records them (CP/M 3).
+func (di *DiskImage) FileSize(filename string) (int, error) {
+	f, err := di.OpenFile(filename, os.O_RDONLY)
+	if err != nil {
+		return 0, err
 	}
+	return int(f.size), nil
+}
 
-	// Free the allocation blocks listed in the entry.
-	entry := &di.directory.Entries[idx]
+// FileBlocks returns the allocation blocks of a file, over all its extents, in
+// order. The directory stores them as 8-bit numbers, or as 16-bit ones on a
+// disk of more than 256 blocks (see DiskSpec.WideBlockPointers).
+func (di *DiskImage) FileBlocks(filename string) ([]int, error) {
+	first, err := di.directory.FindFile(filename)
+	if err != nil {
+		return nil, err
+	}
 	var blocks []int
-	for _, b := range entry.AllocationBlocks {
-		if b != 0 {
-			blocks = append(blocks, int(b))
-		}
+	for _, e := range di.directory.fileExtents(first) {
+		blocks = append(blocks, e.blockPointers(di.spec.WideBlockPointers())...)
+	}
+	return blocks, nil
+}
+
+// DeleteOptions configures DeleteFileWithOptions.
+type DeleteOptions struct {
+	// Scrub fills the file's blocks with the 0xE5 format filler, so none of
+	// its data is left on the disk, for an image to be published. A block
+	// another file also claims is left as it is.
+	Scrub bool
+}
+
+// DeleteFile removes a file from the disk: it frees the file's allocation blocks,
+// marks its directory entries unused (0xE5), and flushes the directory to disk.
+// Its data is left in the blocks; DeleteFileWithOptions can scrub it.
+func (di *DiskImage) DeleteFile(filename string) error {
+	return di.DeleteFileWithOptions(filename, nil)
+}
+
+// DeleteFileWithOptions removes a file from the disk as DeleteFile does, with
+// options; nil gives DeleteFile's.
+func (di *DiskImage) DeleteFileWithOptions(filename string, opts *DeleteOptions) error {
+	if opts == nil {
+		opts = &DeleteOptions{}
 	}
-	if di.fileAlloc != nil && len(blocks) > 0 {
-		_ = di.fileAlloc.FreeBlocks(blocks)
+	first, err := di.directory.FindFile(filename)
+	if err != nil {
+		return fileError("delete", filename, err)
+	}
+	if di.recording {
+		c, err := di.fileChange(ChangeDelete, first)
+		if err != nil {
+			return err
+		}
+		di.record(c)
 	}
+	logger.Debug("deleting file", "name", first.GetFilename(), "scrub", opts.Scrub)
 
-	// Mark the entry unused.
-	di.directory.Entries[idx] = DirectoryEntry{Status: 0xE5}
+	var freed []int
+	for _, e := range di.directory.fileExtents(first) {
+		freed = append(freed, e.blockPointers(di.spec.WideBlockPointers())...)
+		*e = DirectoryEntry{Status: 0xE5}
+	}
+	// Free every extent's blocks, but not a block another file also claims.
+	// Only these blocks are freed: a file open for writing holds blocks the
+	// directory does not list yet.
+	if di.fileAlloc != nil {
+		di.fileAlloc.freeUnclaimed(freed, di.directory.Entries)
+	}
+	if opts.Scrub {
+		if err := di.scrubBlocks(freed); err != nil {
+			return fileError("delete", filename, err)
+		}
+	}
 
 	di.Modified = true
 	return di.FlushDirectory()
 }
+
+// scrubBlocks fills the freed blocks of a deleted file with the format
+// filler, leaving any that a file still in the directory claims.
+func (di *DiskImage) scrubBlocks(blocks []int) error {
+	filler := bytes.Repeat([]byte{0xE5}, di.spec.BlockSize)
+	for _, b := range blocks {
+		if !di.fileAlloc.IsFree(b) {
+			continue
+		}
+		if err := di.WriteBlock(b, filler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+package diskimg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"testing"
+)
+
+// Renaming a file renames every extent, keeps the attribute bits and is
+// written to the directory on disk.
+func TestRenameFile(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.writeRecords("OLD.DAT", make([]byte, 40000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.writeRecords("OTHER.DAT", make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := di.directory.FindFile("OLD.DAT")
+	first.SetAttributes(true, false, false)
+
+	for _, name := range []string{"", "TOOLONGNAME.DAT", "A.LONG", "BAD*.DAT", "A B.DAT"} {
+		if err := di.RenameFile("OLD.DAT", name); !errors.Is(err, ErrInvalidFilename) {
+			t.Errorf("RenameFile to %q: err = %v, want ErrInvalidFilename", name, err)
+		}
+	}
+	if err := di.RenameFile("OLD.DAT", "other.dat"); !errors.Is(err, ErrFileExists) {
+		t.Errorf("RenameFile to an existing name: err = %v, want ErrFileExists", err)
+	}
+	if err := di.RenameFile("MISSING", "NEW"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("RenameFile of a missing file: err = %v, want ErrFileNotFound", err)
+	}
+
+	if err := di.RenameFile("old.dat", "new.bin"); err != nil {
+		t.Fatalf("RenameFile: %v", err)
+	}
+	di = reload(t, di)
+	if _, err := di.FileSize("OLD.DAT"); !errors.Is(err, ErrFileNotFound) {
+		t.Error("the old name is still on the disk")
+	}
+	if size, err := di.FileSize("NEW.BIN"); err != nil || size != 40000 {
+		t.Errorf("FileSize(NEW.BIN) = %d, %v; want 40000", size, err)
+	}
+	first, _ = di.directory.FindFile("NEW.BIN")
+	extents := di.directory.fileExtents(first)
+	if len(extents) != 3 {
+		t.Errorf("%d extents renamed, want 3", len(extents))
+	}
+	if readOnly, _, _ := first.GetAttributes(); !readOnly {
+		t.Error("the read-only attribute was lost")
+	}
+}
+
+// The name index follows files written, deleted, rewritten and renamed, and
+// entries changed directly once reindexed.
+func TestDirectoryIndex(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	find := func(name string) int {
+		t.Helper()
+		e, err := di.directory.FindFile(name)
+		if err != nil {
+			return 0
+		}
+		return len(di.directory.fileExtents(e))
+	}
+	if err := di.writeRecords("BIG.DAT", make([]byte, 40000)); err != nil {
+		t.Fatal(err)
+	}
+	if n := find("big.dat"); n != 3 {
+		t.Fatalf("BIG.DAT has %d extents, want 3", n)
+	}
+	if err := di.DeleteFile("BIG.DAT"); err != nil {
+		t.Fatal(err)
+	}
+	if n := find("BIG.DAT"); n != 0 {
+		t.Errorf("deleted BIG.DAT found with %d extents", n)
+	}
+	// The same entries again, under the same name.
+	if err := di.writeRecords("BIG.DAT", make([]byte, 40000)); err != nil {
+		t.Fatal(err)
+	}
+	if n := find("BIG.DAT"); n != 3 {
+		t.Errorf("rewritten BIG.DAT has %d extents, want 3", n)
+	}
+	if err := di.RenameFile("BIG.DAT", "SMALL.DAT"); err != nil {
+		t.Fatal(err)
+	}
+	if find("BIG.DAT") != 0 || find("SMALL.DAT") != 3 {
+		t.Errorf("after the rename: BIG.DAT %d extents, SMALL.DAT %d", find("BIG.DAT"), find("SMALL.DAT"))
+	}
+
+	// A transaction's copy has its own index.
+	tx := di.snapshot()
+	if err := tx.RenameFile("SMALL.DAT", "TX.DAT"); err != nil {
+		t.Fatal(err)
+	}
+	if find("SMALL.DAT") != 3 {
+		t.Error("renaming in a snapshot renamed the original")
+	}
+
+	e := &di.directory.Entries[0]
+	copy(e.Name[:], "DIRECT  ")
+	di.directory.Reindex()
+	if _, err := di.directory.FindFile("DIRECT.DAT"); err != nil {
+		t.Errorf("entry changed directly: %v", err)
+	}
+}
+
+// An entry of user 0-15 holds a file and one of status 0xE5 is free, keeping
+// the name of a deleted file; the empty user 0 entries earlier versions
+// wrote are freed on loading.
+func TestEntryStatus(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	for _, e := range di.directory.Entries {
+		if !e.IsUnused() || e.IsDeleted() {
+			t.Fatalf("a new disk has entry %+v", e)
+		}
+	}
+	if err := di.writeRecords("KEEP.BIN", []byte("keep")); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.writeRecords("GONE.BIN", []byte("gone")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Delete GONE.BIN as CP/M does, and zero the rest of the first sector.
+	track, sector, side := di.directorySector(0)
+	data, err := di.GetSectorData(track, sector, side)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[DirectoryEntrySize] = 0xE5
+	clear(data[2*DirectoryEntrySize:])
+	if err := di.SetSectorData(track, sector, side, data); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, f := range di.Check().Findings {
+		found = found || f.Code == "dir-legacy-free"
+	}
+	if !found {
+		t.Error("Check does not report the empty user 0 entries")
+	}
+
+	di.loadDirectory()
+	var names []string
+	for e := range di.Files(&FilesOptions{Deleted: true}) {
+		names = append(names, fmt.Sprintf("%s %v", e.GetFilename(), e.IsDeleted()))
+	}
+	if want := []string{"KEEP.BIN false", "GONE.BIN true"}; !slices.Equal(names, want) {
+		t.Errorf("files = %q, want %q", names, want)
+	}
+	if n := di.fileSpace() / di.spec.BlockSize; n != di.fileAlloc.GetFreeBlocks() {
+		t.Errorf("space for %d blocks, want the %d free", n, di.fileAlloc.GetFreeBlocks())
+	}
+
+	// The deleted file's name survives saving.
+	if e := reload(t, di).directory.Entries[1]; !e.IsDeleted() || e.GetFilename() != "GONE.BIN" {
+		t.Errorf("entry 1 = %q (deleted %v), want GONE.BIN deleted", e.GetFilename(), e.IsDeleted())
+	}
+}
+
+// Deleting leaves a file's data in its blocks unless it is scrubbed, which
+// fills them with 0xE5 but spares a block another file claims.
+func TestDeleteScrub(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	secret := bytes.Repeat([]byte{0x42}, 3000)
+	for _, name := range []string{"KEEP.BIN", "PLAIN.BIN", "SECRET.BIN"} {
+		if err := di.writeRecords(name, secret); err != nil {
+			t.Fatal(err)
+		}
+	}
+	holds := func(blocks []int) bool {
+		for _, b := range blocks {
+			if data, _ := di.ReadBlock(b); bytes.Contains(data, []byte{0x42}) {
+				return true
+			}
+		}
+		return false
+	}
+
+	plain, _ := di.FileBlocks("PLAIN.BIN")
+	if err := di.DeleteFile("PLAIN.BIN"); err != nil {
+		t.Fatal(err)
+	}
+	if !holds(plain) {
+		t.Error("DeleteFile scrubbed the data")
+	}
+
+	// Cross-link SECRET.BIN's last block to KEEP.BIN.
+	blocks, _ := di.FileBlocks("SECRET.BIN")
+	keep, _ := di.FileBlocks("KEEP.BIN")
+	shared := keep[len(keep)-1]
+	if err := di.SetFileBlocks("SECRET.BIN", append(blocks[:len(blocks)-1:len(blocks)-1], shared)); err != nil {
+		t.Fatal(err)
+	}
+	free := di.SpaceInfo().Free.Blocks
+	if err := di.DeleteFileWithOptions("SECRET.BIN", &DeleteOptions{Scrub: true}); err != nil {
+		t.Fatal(err)
+	}
+	if holds(blocks[:len(blocks)-1]) {
+		t.Error("scrubbed blocks still hold the data")
+	}
+	if data, _ := di.ReadBlock(shared); !bytes.Contains(data, []byte{0x42}) {
+		t.Error("the block KEEP.BIN shares was scrubbed")
+	}
+	if got := di.SpaceInfo().Free.Blocks; got <= free {
+		t.Errorf("%d blocks free after the delete, %d before", got, free)
+	}
+	if _, err := di.StatFile("SECRET.BIN"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("SECRET.BIN: %v", err)
+	}
+}
+
+// Deleting a file frees the blocks of every extent, in the block and the
+// sector allocation, so the space can be used again at once.
+func TestDeleteFreesBlocks(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	free, sectors := di.SpaceInfo().Free.Blocks, di.allocation.GetFreeSpace()
+
+	// Three extents, the last with a block number past the end of the disk
+	// ahead of its real ones.
+	if err := di.writeRecords("BIG.DAT", make([]byte, 40000)); err != nil {
+		t.Fatal(err)
+	}
+	first, err := di.directory.FindFile("BIG.DAT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	extents := di.directory.fileExtents(first)
+	if len(extents) != 3 {
+		t.Fatalf("BIG.DAT has %d extents, want 3", len(extents))
+	}
+	e := extents[2]
+	copy(e.AllocationBlocks[1:], e.AllocationBlocks[:15])
+	e.AllocationBlocks[0] = 250
+	if err := di.DeleteFile("BIG.DAT"); err != nil {
+		t.Fatal(err)
+	}
+	if got := di.SpaceInfo().Free.Blocks; got != free {
+		t.Errorf("%d blocks free after the delete, want %d", got, free)
+	}
+	if got := di.allocation.GetFreeSpace(); got != sectors {
+		t.Errorf("%d sectors free after the delete, want %d", got, sectors)
+	}
+
+	// The space is used again, and the disk still checks clean.
+	for i := 0; i < 4; i++ {
+		if err := di.writeRecords("FILL.DAT", make([]byte, free*BlockSize)); err != nil {
+			t.Fatalf("filling the disk again (round %d): %v", i, err)
+		}
+		if err := di.DeleteFile("FILL.DAT"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r := reload(t, di).Check(); !r.OK() {
+		t.Errorf("after delete and reuse: %v", r.Findings)
+	}
+}
+
+// Deleting a file leaves the blocks of a file still open for writing, which
+// the directory does not list until it is closed, in use.
+func TestDeleteKeepsOpenFileBlocks(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.writeRecords("OLD.BIN", make([]byte, 3000)); err != nil {
+		t.Fatal(err)
+	}
+	f, err := di.OpenFile("NEW.BIN", os.O_WRONLY|os.O_CREATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte{0x11}, 8000)
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.DeleteFile("OLD.BIN"); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.WriteFile("OTHER.BIN", bytes.Repeat([]byte{0x22}, 8000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := di.OpenFile("NEW.BIN", os.O_RDONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(r); !bytes.Equal(got, data) {
+		t.Error("NEW.BIN does not read back what was written to it")
+	}
+	if r := di.Check(); !r.OK() {
+		t.Errorf("after the delete: %v", r.Findings)
+	}
+}
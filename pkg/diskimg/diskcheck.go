@@ -1,109 +1,342 @@
 package diskimg
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"strings"
 )
 
-// DiskCheck performs a consistency check for a +3DOS disk image.
+// ValidationLevel selects how much of the disk Check examines. Each level
+// runs the checks of the ones before it.
+type ValidationLevel int
+
+const (
+	ValidationBasic    ValidationLevel = iota // the boot sector, directory and block allocation, as DiskCheck
+	ValidationStrict                          // also every track, as loaded; Check's default
+	ValidationParanoid                        // also every file's header checksum and block chain
+)
+
+// String returns "basic", "strict" or "paranoid".
+func (l ValidationLevel) String() string {
+	switch l {
+	case ValidationBasic:
+		return "basic"
+	case ValidationStrict:
+		return "strict"
+	case ValidationParanoid:
+		return "paranoid"
+	}
+	return fmt.Sprintf("ValidationLevel(%d)", int(l))
+}
+
+// MarshalText encodes the level by name.
+func (l ValidationLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText decodes a level encoded by MarshalText.
+func (l *ValidationLevel) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "basic":
+		*l = ValidationBasic
+	case "strict":
+		*l = ValidationStrict
+	case "paranoid":
+		*l = ValidationParanoid
+	default:
+		return fmt.Errorf("unknown validation level %q (want basic, strict or paranoid)", text)
+	}
+	return nil
+}
+
+// CheckOptions configures CheckWithOptions.
+type CheckOptions struct {
+	Level ValidationLevel // the zero value is ValidationBasic
+}
+
+// DiskCheck performs a consistency check for a +3DOS disk image. It returns
+// the first error finding of a basic Check, wrapping ErrCheckFailed.
 func (di *DiskImage) DiskCheck() error {
-	if err := di.checkBootSector(); err != nil {
-		return fmt.Errorf("boot sector check failed: %w", err)
+	return di.check(ValidationBasic).Err()
+}
+
+// Check runs the consistency checks of DiskCheck and reports every finding,
+// graded, together with the errors concealed by a salvage load.
+func (di *DiskImage) Check() *ValidationReport {
+	return di.CheckWithOptions(nil)
+}
+
+// CheckWithOptions runs Check at the level opts selects; nil gives Check's.
+// A basic check reads only the boot sector and directory, for fast triage;
+// a paranoid one also reads every file.
+func (di *DiskImage) CheckWithOptions(opts *CheckOptions) *ValidationReport {
+	if opts == nil {
+		opts = &CheckOptions{Level: ValidationStrict}
 	}
-	if err := di.checkDirectoryEntries(); err != nil {
-		return fmt.Errorf("directory entries check failed: %w", err)
+	return di.check(opts.Level)
+}
+
+// check runs the checks of a level.
+func (di *DiskImage) check(level ValidationLevel) *ValidationReport {
+	r := &ValidationReport{}
+	di.checkBootSector(r)
+	di.checkDirectoryEntries(r)
+	di.checkSectorAllocation(r)
+	if level >= ValidationStrict {
+		di.checkTracks(r)
+		for _, tc := range di.Concealments() {
+			f := r.add(SeverityWarning, CategoryTrack, "track-concealed",
+				fmt.Errorf("salvage load concealed %d bad track information block(s) and %d short read(s)", tc.BadSignature, tc.ShortReads))
+			f.Track, f.Side = tc.Track, tc.Side
+		}
 	}
-	if err := di.checkSectorAllocation(); err != nil {
-		return fmt.Errorf("sector allocation check failed: %w", err)
+	if level >= ValidationParanoid {
+		di.checkFiles(r)
+	}
+	return r
+}
+
+// locate sets the location of a finding from the SectorError in err, if any.
+func (f *Finding) locate(err error) {
+	var se *SectorError
+	if errors.As(err, &se) {
+		f.Track, f.Side, f.Sector = se.Track, se.Side, se.Sector
 	}
-	return nil
 }
 
 // checkBootSector validates the boot sector.
-func (di *DiskImage) checkBootSector() error {
+func (di *DiskImage) checkBootSector(r *ValidationReport) {
 	bootSector, err := di.GetSectorData(0, 0, 0)
 	if err != nil {
-		return fmt.Errorf("failed to read boot sector: %w", err)
+		r.add(SeverityError, CategoryBoot, "boot-unreadable", fmt.Errorf("failed to read boot sector: %w", err)).locate(err)
+		return
 	}
 
 	// Per the +3DOS DD_LOGIN algorithm, a standard +3 disk logs on via the
 	// built-in default XDPB and does NOT carry a populated disk-specification
-	// sector; the spec sector is left as format filler (0xE5). The boot-sector
-	// checksum (bytes must sum to 3 mod 256) applies ONLY to a bootable disk,
-	// identified by a valid disk-type byte (0..3) in byte 0. A standard data
-	// disk (byte 0 = 0xE5 filler) is not bootable and must not be checksummed,
-	// otherwise every normal data disk would be wrongly rejected.
+	// sector; the spec sector is left as format filler (0xE5). Any other format
+	// is identified by a disk-type byte (0..3) in byte 0 followed by its
+	// geometry, which must match the image. The boot-sector checksum (bytes
+	// summing to 3 mod 256) only marks the disk as bootable and is not required,
+	// so whether it is set is information, whatever the sector holds.
+	if di.spec.isCPC() {
+		return // CPC formats: the first sector holds code or the directory
+	}
+	if di.spec.Name == idedosSpecName {
+		return // IDEDOS partitions keep their XDPB in the partition table
+	}
+	if di.Bootable() {
+		f := r.add(SeverityInfo, CategoryBoot, "boot-bootable", errors.New("the boot sector is bootable"))
+		f.Track, f.Side, f.Sector = 0, 0, 0
+	}
 	if bootSector[0] > 3 {
-		return nil // not a bootable spec sector (format filler) - nothing to check
+		return // not a spec sector (format filler) - nothing to check
 	}
-
-	sum := 0
-	for i, b := range bootSector {
-		if i == 15 {
-			continue
-		}
-		sum += int(b)
+	if !di.Bootable() && hasBootCode(bootSector) {
+		f := r.add(SeverityInfo, CategoryBoot, "boot-checksum",
+			fmt.Errorf("%w: the boot sector holds code but its checksum does not make it bootable", ErrInvalidChecksum))
+		f.Track, f.Side, f.Sector = 0, 0, 0
 	}
-	if (sum+int(bootSector[15]))%256 != 3 {
-		return errors.New("boot sector checksum validation failed")
+
+	want := di.spec.Specification().Serialize()
+	if bootSector[1]&0x03 != want[1]&0x03 || bootSector[2] != want[2] ||
+		bootSector[3] != want[3] || bootSector[4] != want[4] {
+		f := r.add(SeverityError, CategoryBoot, "boot-spec-mismatch", fmt.Errorf("%w: %d tracks, %d sectors, side mode %d does not match the %s image geometry", ErrInvalidSpec,
+			bootSector[2], bootSector[3], bootSector[1]&0x03, di.spec.Name))
+		f.Track, f.Side, f.Sector = 0, 0, 0
 	}
-	return nil
 }
 
 // checkDirectoryEntries validates the directory structure.
-func (di *DiskImage) checkDirectoryEntries() error {
+func (di *DiskImage) checkDirectoryEntries(r *ValidationReport) {
 	dirData, err := di.readDirectory()
 	if err != nil {
-		return fmt.Errorf("failed to read directory: %w", err)
+		r.add(SeverityError, CategoryDirectory, "dir-unreadable", fmt.Errorf("failed to read directory: %w", err)).locate(err)
+		return
 	}
 
-	for i := 0; i < MaxDirectoryEntries; i++ {
+	seen := make(map[string]int)
+	for i := 0; i < len(dirData)/DirectoryEntrySize; i++ {
 		offset := i * DirectoryEntrySize
 		entryData := dirData[offset : offset+DirectoryEntrySize]
-		if entryData[0] == 0xE5 || entryData[0] == 0x00 {
+		if entryData[0] == 0xE5 || entryData[0] >= 0x20 {
+			continue // free, or a label or timestamps
+		}
+		if entryData[0] == 0x00 && len(bytes.Trim(entryData[1:9], " \x00")) == 0 {
+			f := r.add(SeverityInfo, CategoryDirectory, "dir-legacy-free",
+				errors.New("empty entry of user 0, as earlier versions of plus3 wrote; freed when the directory is next written"))
+			f.Entry = i
 			continue
 		}
+		if first, ok := seen[extentKey(entryData)]; ok {
+			f := r.add(SeverityWarning, CategoryDirectory, "dir-duplicate",
+				fmt.Errorf("%w: duplicate of directory entry %d", ErrCorruptImage, first))
+			f.Entry = i
+		} else {
+			seen[extentKey(entryData)] = i
+		}
 
 		entry := DirectoryEntry{}
-		copy(entry.Name[:], entryData[:8])
-		copy(entry.Extension[:], entryData[8:11])
 		entry.Status = entryData[0]
-		entry.RecordCount = entryData[12]
+		copy(entry.Name[:], entryData[1:9])
+		copy(entry.Extension[:], entryData[9:12])
+		entry.RecordCount = entryData[15]
 		// Add other fields based on +3DOS directory entry structure...
 
 		if !isValidFilename(entry.Name[:], entry.Extension[:]) {
-			return fmt.Errorf("invalid filename: %s.%s", entry.Name, entry.Extension)
+			f := r.add(SeverityWarning, CategoryDirectory, "dir-bad-name",
+				fmt.Errorf("%w: %q", ErrInvalidFilename, entry.GetFilename()))
+			f.Entry = i
 		}
 	}
-	return nil
 }
 
-// checkSectorAllocation ensures no overlapping or invalid sectors.
-func (di *DiskImage) checkSectorAllocation() error {
-	bitmap := make([]bool, di.TotalSectors())
+// checkSectorAllocation ensures no block is allocated twice or lies outside
+// the data area.
+func (di *DiskImage) checkSectorAllocation(r *ValidationReport) {
+	used := make([]bool, di.spec.TotalBlocks())
+	for b := 0; b < di.spec.DirBlocks; b++ {
+		used[b] = true // the directory
+	}
 
-	for _, entry := range di.directory.Entries {
-		if entry.Status == 0xE5 || entry.Status == 0x00 {
+	for i, entry := range di.directory.Entries {
+		if entry.Status == 0xE5 || entry.Status >= 0x20 {
 			continue
 		}
-		for _, block := range entry.AllocationBlocks {
-			if block == 0x00 {
-				break
+		for _, block := range entry.blockPointers(di.spec.WideBlockPointers()) {
+			if block >= len(used) {
+				f := r.add(SeverityError, CategoryAllocation, "alloc-block-range",
+					fmt.Errorf("%w: %s: invalid block %d", ErrCorruptImage, entry.GetFilename(), block))
+				f.Entry = i
+				continue
+			}
+			if used[block] {
+				f := r.add(SeverityError, CategoryAllocation, "alloc-block-shared",
+					fmt.Errorf("%w: %s: block %d allocated multiple times", ErrCorruptImage, entry.GetFilename(), block))
+				f.Entry = i
+			}
+			used[block] = true
+		}
+	}
+}
+
+// checkTracks reports tracks that cannot be read, and tracks holding less
+// data than their sector information list describes.
+func (di *DiskImage) checkTracks(r *ValidationReport) {
+	sides := max(int(di.Header.SidesNum), 1)
+	for idx := range di.Tracks {
+		td, err := di.track(idx)
+		if err != nil {
+			r.add(SeverityError, CategoryTrack, "track-unreadable", err).locate(err)
+			continue
+		}
+		if need := trackDataSize(td); need > len(td) {
+			f := r.add(SeverityWarning, CategoryTrack, "track-short",
+				fmt.Errorf("%w: the track holds %d of the %d bytes its sectors need", ErrCorruptImage, len(td), need))
+			f.Track, f.Side = idx/sides, idx%sides
+		}
+	}
+}
+
+// checkFiles follows each file's chain of extents and blocks, reading every
+// block, and verifies the checksum of its PLUS3DOS header.
+func (di *DiskImage) checkFiles(r *ValidationReport) {
+	per := di.spec.ExtentMask() + 1
+	for first := range di.Files(&FilesOptions{System: true}) {
+		name := first.GetFilename()
+		extents := di.directory.fileExtents(&first)
+		entry := di.entryIndex(extents[0])
+		var blocks []int
+		for n, e := range extents {
+			i := di.entryIndex(e)
+			if n > 0 && e.extentNumber()/per != n {
+				f := r.add(SeverityError, CategoryFile, "file-extent-missing",
+					fmt.Errorf("%w: %s: extent %d follows extent %d", ErrCorruptImage, name, e.extentNumber(), extents[n-1].extentNumber()))
+				f.Entry = i
+			}
+			ptrs := e.blockPointers(di.spec.WideBlockPointers())
+			records := di.fileRecords([]*DirectoryEntry{e})
+			if need := (records*128 + di.spec.BlockSize - 1) / di.spec.BlockSize; len(ptrs) != need {
+				f := r.add(SeverityWarning, CategoryFile, "file-block-count",
+					fmt.Errorf("%w: %s: extent %d has %d blocks for %d records", ErrCorruptImage, name, e.extentNumber(), len(ptrs), records))
+				f.Entry = i
+			}
+			blocks = append(blocks, ptrs...)
+		}
+
+		for n, b := range blocks {
+			if di.checkBlock(b) != nil {
+				continue // reported as alloc-block-range
+			}
+			data, err := di.ReadBlock(b)
+			if err != nil {
+				f := r.add(SeverityError, CategoryFile, "file-block-unreadable",
+					fmt.Errorf("%s: block %d: %w", name, b, err))
+				f.locate(err)
+				f.Entry = entry
+				continue
 			}
-			sector := int(block)
-			if sector >= len(bitmap) {
-				return fmt.Errorf("invalid sector: %d", sector)
+			if n > 0 || isCPMProgram(name) || !bytes.HasPrefix(data, []byte(HeaderSignature)) {
+				continue
 			}
-			if bitmap[sector] {
-				return fmt.Errorf("sector %d allocated multiple times", sector)
+			var h Plus3DosHeader
+			if err := h.FromBytes(data); err != nil {
+				continue
+			}
+			if err := h.Validate(); errors.Is(err, ErrInvalidChecksum) {
+				f := r.add(SeverityWarning, CategoryFile, "file-header-checksum",
+					fmt.Errorf("%w: %s: the PLUS3DOS header checksum is wrong, so the file reads as headerless", ErrInvalidChecksum, name))
+				f.Entry = entry
+			} else if err != nil {
+				f := r.add(SeverityWarning, CategoryFile, "file-header-invalid", fmt.Errorf("%s: %w", name, err))
+				f.Entry = entry
 			}
-			bitmap[sector] = true
 		}
 	}
-	return nil
 }
 
-// isValidFilename validates filenames.
+// trackDataSize returns the length of a track block with all the sector data
+// its information list describes, or 0 for an absent or unparsable track.
+func trackDataSize(td []byte) int {
+	ti, err := parseTrackInfo(td)
+	if err != nil || len(ti.SectorInfo) == 0 {
+		return 0
+	}
+	off, size := ti.sectorOffset(len(ti.SectorInfo) - 1)
+	return off + size
+}
+
+// extentKey identifies the file extent a directory entry holds: its user,
+// name and type without attribute bits, and extent number.
+func extentKey(entry []byte) string {
+	key := []byte{entry[0], entry[12], entry[14]}
+	for _, b := range entry[1:12] {
+		key = append(key, b&0x7F)
+	}
+	return string(key)
+}
+
+// hasBootCode reports whether the part of a boot sector after the disk
+// specification holds anything other than uniform filler.
+func hasBootCode(boot []byte) bool {
+	for _, b := range boot[16:] {
+		if b != boot[16] {
+			return true
+		}
+	}
+	return false
+}
+
+// isValidFilename reports whether the name and extension of a directory
+// entry, attribute bits aside, are printable and free of the characters CP/M
+// gives a meaning to.
 func isValidFilename(name []byte, ext []byte) bool {
+	for _, b := range append(append([]byte(nil), name...), ext...) {
+		c := b & 0x7F
+		if c < ' ' || c > '~' || strings.IndexByte("<>.,;:=?*[]", c) >= 0 {
+			return false
+		}
+	}
 	return len(name) <= 8 && len(ext) <= 3
 }
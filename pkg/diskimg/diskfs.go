@@ -0,0 +1,238 @@
+// file: pkg/diskimg/diskfs.go
+
+package diskimg
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A DiskImage is a read-only fs.FS of its files, so the standard library's
+// tooling (fs.WalkDir, fs.ReadFile, http.FS, testing/fstest) works on it
+// directly. The disk has one directory, ".", holding the files of every user
+// area; a name used in more than one user area is only seen once. Files are
+// read as stored, PLUS3DOS header included; HeaderlessFS gives the view with
+// headers stripped.
+var (
+	_ fs.ReadDirFS = (*DiskImage)(nil)
+	_ fs.StatFS    = (*DiskImage)(nil)
+)
+
+// diskFS is the fs.FS view of a disk image.
+type diskFS struct {
+	disk  *DiskImage
+	strip bool // strip PLUS3DOS headers
+}
+
+// Open implements fs.FS, reading files with their PLUS3DOS headers.
+func (di *DiskImage) Open(name string) (fs.File, error) {
+	return diskFS{disk: di}.Open(name)
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (di *DiskImage) ReadDir(name string) ([]fs.DirEntry, error) {
+	return diskFS{disk: di}.ReadDir(name)
+}
+
+// Stat implements fs.StatFS.
+func (di *DiskImage) Stat(name string) (fs.FileInfo, error) {
+	return diskFS{disk: di}.Stat(name)
+}
+
+// HeaderlessFS returns the disk's files as an fs.FS (also an fs.ReadDirFS and
+// fs.StatFS) in which headered files are read without their PLUS3DOS header,
+// as extract --strip-header writes them.
+func (di *DiskImage) HeaderlessFS() fs.FS {
+	return diskFS{disk: di, strip: true}
+}
+
+// WriteFile writes data to the named file, creating it or replacing its
+// contents, and flushes the directory: the write side of the fs.FS view, as
+// os.WriteFile is of os.DirFS. data is stored as given, PLUS3DOS header and
+// all. The disk is left unchanged if the data does not fit.
+func (di *DiskImage) WriteFile(name string, data []byte) error {
+	name, err := di.diskName(name)
+	if err != nil {
+		return fileError("write", name, err)
+	}
+	if err := di.checkSpace("write", name, len(data)); err != nil {
+		return err
+	}
+	f, err := di.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		return fileError("write", name, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	di.Modified = true
+	return di.FlushDirectory()
+}
+
+func (d diskFS) Open(name string) (fs.File, error) {
+	if name == "." {
+		entries, err := d.ReadDir(".")
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &fsDir{info: dirInfo{}, entries: entries}, nil
+	}
+	info, err := d.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := d.read(info.entry.GetFilename())
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &fsFile{info: info, Reader: bytes.NewReader(data)}, nil
+}
+
+func (d diskFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if name != "." {
+		if !fs.ValidPath(name) {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+		}
+		if _, err := d.stat("readdir", name); err == nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+		}
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	var entries []fs.DirEntry
+	for _, name := range d.names() {
+		info, err := d.stat("readdir", name)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	return entries, nil
+}
+
+func (d diskFS) Stat(name string) (fs.FileInfo, error) {
+	if name == "." {
+		return dirInfo{}, nil
+	}
+	return d.stat("stat", name)
+}
+
+// names returns the names of the files on the disk, in lexical order.
+func (d diskFS) names() []string {
+	var names []string
+	for e := range d.disk.Files(&FilesOptions{System: true}) {
+		if name := e.GetFilename(); fs.ValidPath(name) && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// stat returns the FileInfo of a file on the disk. Names match exactly, as
+// ReadDir lists them.
+func (d diskFS) stat(op, name string) (fileInfo, error) {
+	if !fs.ValidPath(name) || strings.Contains(name, "/") {
+		return fileInfo{}, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	e, err := d.disk.directory.FindFile(name)
+	if err != nil || e.GetFilename() != name || e.Status > 15 {
+		return fileInfo{}, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	data, err := d.read(name)
+	if err != nil {
+		return fileInfo{}, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return fileInfo{entry: *e, size: int64(len(data))}, nil
+}
+
+// read returns the contents of a file, without its header if d strips them.
+func (d diskFS) read(name string) ([]byte, error) {
+	f, err := d.disk.OpenFile(name, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, f.size)
+	if n, err := f.ReadAt(data, 0); n < len(data) {
+		return nil, err
+	}
+	if d.strip && f.isHeadered && len(data) >= HeaderSize {
+		data = data[HeaderSize:]
+	}
+	return data, nil
+}
+
+// fileInfo describes a file of a disk image. Sys returns its first
+// DirectoryEntry.
+type fileInfo struct {
+	entry DirectoryEntry
+	size  int64
+}
+
+func (fi fileInfo) Name() string       { return fi.entry.GetFilename() }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) ModTime() time.Time { return time.Time{} }
+func (fi fileInfo) IsDir() bool        { return false }
+func (fi fileInfo) Sys() any           { return fi.entry }
+
+func (fi fileInfo) Mode() fs.FileMode {
+	if readOnly, _, _ := fi.entry.GetAttributes(); readOnly {
+		return 0444
+	}
+	return 0644
+}
+
+// dirInfo describes the disk's one directory.
+type dirInfo struct{}
+
+func (dirInfo) Name() string       { return "." }
+func (dirInfo) Size() int64        { return 0 }
+func (dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0555 }
+func (dirInfo) ModTime() time.Time { return time.Time{} }
+func (dirInfo) IsDir() bool        { return true }
+func (dirInfo) Sys() any           { return nil }
+
+// fsFile is an open file of a disk image.
+type fsFile struct {
+	info fileInfo
+	*bytes.Reader
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *fsFile) Close() error               { return nil }
+
+// fsDir is the open directory of a disk image.
+type fsDir struct {
+	info    dirInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *fsDir) Close() error               { return nil }
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: ".", Err: fs.ErrInvalid}
+}
+
+// ReadDir implements fs.ReadDirFile.
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	rest = rest[:min(n, len(rest))]
+	d.offset += len(rest)
+	return rest, nil
+}
@@ -0,0 +1,71 @@
+package diskimg
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+// A disk image is an fs.FS of its files, with and without their headers.
+func TestDiskFS(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	header, _ := importHeader(20000, &ImportOptions{AddHeader: true, FileType: FileTypeCode, LoadAddr: 32768})
+	body := bytes.Repeat([]byte("code"), 5000)
+	if err := di.writeRecords("GAME.BIN", append(header.toBytes(), body...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.writeRecords("NOTES.TXT", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fstest.TestFS(di, "GAME.BIN", "NOTES.TXT"); err != nil {
+		t.Errorf("raw view: %v", err)
+	}
+	if err := fstest.TestFS(di.HeaderlessFS(), "GAME.BIN", "NOTES.TXT"); err != nil {
+		t.Errorf("headerless view: %v", err)
+	}
+
+	if data, err := fs.ReadFile(di, "GAME.BIN"); err != nil || len(data) != HeaderSize+len(body) {
+		t.Errorf("raw GAME.BIN: %d bytes, %v", len(data), err)
+	}
+	if data, err := fs.ReadFile(di.HeaderlessFS(), "GAME.BIN"); err != nil || !bytes.Equal(data, body) {
+		t.Errorf("headerless GAME.BIN: %d bytes, %v", len(data), err)
+	}
+	if data, _ := fs.ReadFile(di.HeaderlessFS(), "NOTES.TXT"); string(data) != "hello" {
+		t.Errorf("headerless NOTES.TXT = %q", data)
+	}
+	if _, err := fs.Stat(di, "MISSING"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat of a missing file: err = %v, want fs.ErrNotExist", err)
+	}
+}
+
+// WriteFile creates and replaces files, and leaves the disk alone when the
+// data does not fit.
+func TestDiskWriteFile(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.WriteFile("notes.txt", bytes.Repeat([]byte("a"), 30000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.WriteFile("NOTES.TXT", []byte("short")); err != nil {
+		t.Fatal(err)
+	}
+	di = reload(t, di)
+	if data, err := fs.ReadFile(di, "NOTES.TXT"); err != nil || string(data) != "short" {
+		t.Errorf("NOTES.TXT = %q, %v", data, err)
+	}
+	if blocks, _ := di.FileBlocks("NOTES.TXT"); len(blocks) != 1 {
+		t.Errorf("replaced file has %d blocks, want 1", len(blocks))
+	}
+
+	if err := di.WriteFile("BIG.DAT", make([]byte, 200000)); !errors.Is(err, ErrDiskFull) {
+		t.Errorf("WriteFile of too much data: err = %v, want ErrDiskFull", err)
+	}
+	if _, err := di.Stat("BIG.DAT"); err == nil {
+		t.Error("a file that did not fit was created")
+	}
+	if err := di.WriteFile("._NOTES", nil); !errors.Is(err, ErrInvalidFilename) {
+		t.Errorf("WriteFile with an invalid name: err = %v, want ErrInvalidFilename", err)
+	}
+}
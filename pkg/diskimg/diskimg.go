@@ -3,7 +3,8 @@
 package diskimg
 
 import (
-	"github.com/ha1tch/plus3/internal"
+	"bytes"
+	"fmt"
 )
 
 const (
@@ -32,17 +33,30 @@ type DiskImage struct {
 	Header   DiskHeader
 	Tracks   [][]byte // raw track data (track info block + sector data) per track
 	Modified bool
-	DiskType uint8 // intended CP/M format: 0=+3 standard, 1=CPC system, 2=CPC data
+	DiskType uint8 // +3DOS disk type of the format: 0=+3 standard, 1=CPC system, 2=CPC data, 3=double-sided
 
+	spec       DiskSpec // geometry and CP/M layout
 	directory  Directory
 	allocation *SectorAllocation
 	fileAlloc  *FileAllocation
-	sectorMap  *internal.SectorMap
+
+	concealments []TrackConcealment // errors concealed by a salvage load
+	fidelity     bool               // keep FDC status of rewritten sectors (LoadOptions.Fidelity)
+	sanitise     bool               // make invalid file names valid (SetSanitiseNames)
+
+	lazy      *lazyTracks // where tracks not yet read are (LoadLazy)
+	shared    []bool      // tracks a snapshot may share, copied before a write (Snapshot)
+	recording bool        // record changes (RecordChanges)
+	changes   []Change    // changes recorded so far
+	dirty     []bool      // tracks changed since the disk was loaded from or saved to origin
+	origin    *fileOrigin // the file the disk was loaded from or last saved to
+
+	progress ProgressFunc // where long operations report progress (SetProgress)
 }
 
 // TotalSectors returns the total number of sectors on the disk.
 func (di *DiskImage) TotalSectors() int {
-	return int(di.Header.TracksNum) * int(di.Header.SidesNum) * SectorsPerTrack
+	return int(di.Header.TracksNum) * int(di.Header.SidesNum) * di.spec.SectorsPerTrack
 }
 
 // NewDiskImage initializes a new, formatted, blank +3 disk image with standard
@@ -50,96 +64,228 @@ func (di *DiskImage) TotalSectors() int {
 // nine 512-byte sectors filled with the format filler byte (0xE5), so the disk
 // is immediately usable - matching what a real +3 format produces.
 func NewDiskImage() *DiskImage {
+	di, _ := NewDiskImageWithSpec(SpecPlus3)
+	return di
+}
+
+// NewDiskImageWithSpec initializes a new, formatted, blank disk image in the
+// given format.
+func NewDiskImageWithSpec(spec DiskSpec) (*DiskImage, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
 	di := &DiskImage{
-		sectorMap: internal.NewSectorMap(),
-		directory: Directory{Entries: make([]DirectoryEntry, MaxDirectoryEntries)},
+		DiskType:  spec.diskType(),
+		spec:      spec,
+		directory: Directory{Entries: emptyEntries(spec.DirEntries())},
 	}
-	di.Header.TracksNum = TracksPerSide
-	di.Header.SidesNum = 1
-	di.Header.TrackSize = uint16(SectorsPerTrack*BytesPerSector + 256)
-	copy(di.Header.Signature[:], "MV - CPCEMU Disk-File\r\nDisk-Info\r\n")
+	di.Header.TracksNum = uint8(spec.TracksPerSide)
+	di.Header.SidesNum = uint8(spec.Sides)
+	di.Header.TrackSize = uint16(spec.TrackSize())
+	di.SetContainer(ContainerStandard)
 	copy(di.Header.Creator[:], "plus3")
-	di.allocation = newSectorAllocation(di.TotalSectors())
+	di.allocation = newSectorAllocation(spec)
 	di.fileAlloc = newFileAllocation(di)
 
 	// Format every track: build the track info block + 0xE5-filled sectors.
-	trackBytes := 256 + SectorsPerTrack*BytesPerSector
-	di.Tracks = make([][]byte, int(di.Header.TracksNum)*int(di.Header.SidesNum))
+	// Tracks are stored in container order, the sides of each track together.
+	di.Tracks = make([][]byte, spec.TotalTracks())
 	for t := range di.Tracks {
-		block := make([]byte, trackBytes)
-		// Track information block.
-		copy(block[0:], "Track-Info\r\n")
-		block[0x10] = byte(t % int(di.Header.TracksNum)) // track number
-		block[0x11] = byte(t / int(di.Header.TracksNum)) // side number
-		block[0x14] = 2                                  // sector size code (512)
-		block[0x15] = SectorsPerTrack                    // sectors per track
-		block[0x16] = 0x4E                               // gap3 length (78)
-		block[0x17] = 0xE5                               // filler byte
-		// Sector information list (8 bytes per sector), IDs R=1..9.
-		for sct := 0; sct < SectorsPerTrack; sct++ {
-			si := 0x18 + sct*8
-			block[si+0] = byte(t % int(di.Header.TracksNum)) // C
-			block[si+1] = byte(t / int(di.Header.TracksNum)) // H
-			block[si+2] = byte(sct + 1)                      // R (sector ID, from 1)
-			block[si+3] = 2                                  // N (512)
-			block[si+6] = byte(BytesPerSector & 0xFF)        // actual length lo
-			block[si+7] = byte(BytesPerSector >> 8)          // actual length hi
-		}
-		// Fill sector data area with the format filler (0xE5).
-		for i := 256; i < trackBytes; i++ {
-			block[i] = 0xE5
+		di.Tracks[t] = formatTrack(spec, t/spec.Sides, t%spec.Sides)
+	}
+
+	// A standard +3 disk logs on with the built-in default and CPC disks by
+	// their sector IDs; any other format is identified by a disk specification
+	// at the start of the boot sector. The disk is not bootable: the boot
+	// sector checksum is left unset.
+	if spec.usesSpecSector() {
+		td, off, _, _, err := di.locateSector(0, 0, 0)
+		if err != nil {
+			return nil, err
 		}
-		di.Tracks[t] = block
+		copy(td[off:], spec.Specification().Serialize())
 	}
-	return di
+	return di, nil
 }
 
-// trackIndex returns the index into di.Tracks for a given track and side.
+// formatTrack builds a freshly formatted track block: the track information
+// block followed by the sectors, filled with the format filler byte.
+func formatTrack(spec DiskSpec, track, side int) []byte {
+	trackBytes := spec.TrackSize()
+	sizeCode := sectorSizeCode(spec.SectorSize)
+	block := make([]byte, trackBytes)
+	// Track information block.
+	copy(block[0:], "Track-Info\r\n")
+	block[0x10] = byte(track)                // track number
+	block[0x11] = byte(side)                 // side number
+	block[0x14] = sizeCode                   // sector size code (2 = 512)
+	block[0x15] = byte(spec.SectorsPerTrack) // sectors per track
+	block[0x16] = byte(spec.GapFormat)       // gap3 length
+	block[0x17] = 0xE5                       // filler byte
+	// Sector information list (8 bytes per sector), IDs from the format's first
+	// in the order of the format's interleave.
+	ids := spec.sectorOrder(track)
+	for sct := 0; sct < spec.SectorsPerTrack; sct++ {
+		si := 0x18 + sct*8
+		block[si+0] = byte(track)                         // C
+		block[si+1] = byte(side)                          // H
+		block[si+2] = byte(spec.FirstSectorID + ids[sct]) // R (sector ID)
+		block[si+3] = sizeCode                            // N
+		block[si+6] = byte(spec.SectorSize & 0xFF)        // actual length lo
+		block[si+7] = byte(spec.SectorSize >> 8)          // actual length hi
+	}
+	// Fill sector data area with the format filler (0xE5).
+	for i := 256; i < trackBytes; i++ {
+		block[i] = 0xE5
+	}
+	return block
+}
+
+// sectorSizeCode returns the FDC size code N for a sector size (128 << N).
+func sectorSizeCode(size int) byte {
+	var n byte
+	for 128<<n < size {
+		n++
+	}
+	return n
+}
+
+// trackIndex returns the index into di.Tracks for a given track and side. A
+// .dsk container stores both sides of a track before moving to the next one.
 func (di *DiskImage) trackIndex(track, side int) int {
-	return side*int(di.Header.TracksNum) + track
+	return track*int(di.Header.SidesNum) + side
 }
 
-// GetSectorData retrieves the 512-byte data for a track/sector/side.
-// Sector data follows the 256-byte track information block in each track.
+// GetSectorData retrieves the data of a track/sector/side (512 bytes on a +3
+// disk). Sector data follows the 256-byte track information block in each
+// track. sector is the 0-based logical sector: the one whose ID (R) is the
+// format's first sector ID plus sector, wherever the track's interleave puts
+// it, or the sector at that position if the track has no such ID. Each
+// sector's size comes from the track's sector information list, so tracks may
+// mix sector sizes. ReadSectorInto reads a sector without allocating, and
+// SectorView without copying.
 func (di *DiskImage) GetSectorData(track, sector, side int) ([]byte, error) {
-	if track < 0 || track >= int(di.Header.TracksNum) ||
-		sector < 0 || sector >= SectorsPerTrack ||
-		side < 0 || side >= int(di.Header.SidesNum) {
-		return nil, ErrInvalidSector
+	td, off, size, _, err := di.locateSector(track, sector, side)
+	if err != nil {
+		return nil, sectorError("read", track, side, sector, err)
 	}
-	idx := di.trackIndex(track, side)
-	if idx >= len(di.Tracks) || di.Tracks[idx] == nil {
-		return nil, ErrInvalidSector
+	out := make([]byte, size)
+	copy(out, td[off:off+size])
+	return out, nil
+}
+
+// GetSectorCopies returns every copy of a sector's data stored in the image:
+// several for a weak sector (see SectorInfo.Copies), otherwise one.
+func (di *DiskImage) GetSectorCopies(track, sector, side int) ([][]byte, error) {
+	td, off, size, copies, err := di.locateSector(track, sector, side)
+	if err != nil {
+		return nil, sectorError("read", track, side, sector, err)
 	}
-	off := 256 + sector*BytesPerSector
-	td := di.Tracks[idx]
-	if off+BytesPerSector > len(td) {
-		return nil, ErrInvalidSector
+	out := make([][]byte, copies)
+	for c := range out {
+		out[c] = append([]byte(nil), td[off+c*size:off+(c+1)*size]...)
 	}
-	out := make([]byte, BytesPerSector)
-	copy(out, td[off:off+BytesPerSector])
 	return out, nil
 }
 
-// SetSectorData writes 512 bytes into a track/sector/side, marking the disk modified.
+// SetSectorData writes a whole sector into a track/sector/side, marking the
+// disk modified. The data must be the size of that sector. Every copy of a
+// weak sector is overwritten. Unless the image was loaded with
+// LoadOptions.Fidelity, changing a sector's data also clears its FDC status
+// bytes, as the FDC leaves a rewritten sector readable.
 func (di *DiskImage) SetSectorData(track, sector, side int, data []byte) error {
-	if len(data) != BytesPerSector {
-		return ErrInvalidSectorSize
+	if track >= 0 && track < int(di.Header.TracksNum) && side >= 0 && side < int(di.Header.SidesNum) {
+		if idx := di.trackIndex(track, side); idx < len(di.Tracks) {
+			if err := di.loadTrack(idx); err != nil {
+				return sectorError("write", track, side, sector, err)
+			}
+			di.ownTrack(idx)
+			if di.Tracks[idx] == nil {
+				// An absent track (extended container) is formatted on first write.
+				di.Tracks[idx] = formatTrack(di.spec, track, side)
+				di.markDirty(idx)
+			}
+		}
+	}
+	td, pos, off, size, copies, err := di.locate(track, sector, side, true)
+	if err != nil {
+		return sectorError("write", track, side, sector, err)
+	}
+	if len(data) != size {
+		return sectorError("write", track, side, sector, fmt.Errorf("%w: %d bytes, want %d", ErrInvalidSectorSize, len(data), size))
+	}
+	changed := false
+	for c := 0; c < copies; c++ {
+		dst := td[off+c*size : off+(c+1)*size]
+		changed = changed || !bytes.Equal(dst, data)
+		copy(dst, data)
+	}
+	if changed {
+		if !di.fidelity {
+			status := td[0x18+pos*8+4:]
+			status[0], status[1] = 0, 0
+		}
+		di.markDirty(di.trackIndex(track, side))
 	}
-	if track < 0 || track >= int(di.Header.TracksNum) ||
-		sector < 0 || sector >= SectorsPerTrack ||
+	di.Modified = true
+	return nil
+}
+
+// locateSector returns the track block holding a logical sector, the offset
+// and size of the sector's data within it, and the number of copies stored one
+// after another from that offset.
+func (di *DiskImage) locateSector(track, sector, side int) (td []byte, off, size, copies int, err error) {
+	td, _, off, size, copies, err = di.locate(track, sector, side, true)
+	return td, off, size, copies, err
+}
+
+// locate finds a sector by logical number (byID) or by physical position on
+// the track; see locateSector. It also returns the sector's position on the
+// track, which indexes its entry in the sector information list.
+func (di *DiskImage) locate(track, sector, side int, byID bool) (td []byte, pos, off, size, copies int, err error) {
+	if track < 0 || track >= int(di.Header.TracksNum) || sector < 0 ||
 		side < 0 || side >= int(di.Header.SidesNum) {
-		return ErrInvalidSector
+		return nil, 0, 0, 0, 0, ErrInvalidSector
 	}
 	idx := di.trackIndex(track, side)
 	if idx >= len(di.Tracks) {
-		return ErrInvalidSector
+		return nil, 0, 0, 0, 0, ErrInvalidSector
 	}
-	if di.Tracks[idx] == nil {
-		di.Tracks[idx] = make([]byte, di.Header.TrackSize)
+	if td, err = di.track(idx); err != nil {
+		return nil, 0, 0, 0, 0, err
 	}
-	off := 256 + sector*BytesPerSector
-	copy(di.Tracks[idx][off:off+BytesPerSector], data)
-	di.Modified = true
-	return nil
+	if td == nil {
+		return nil, 0, 0, 0, 0, ErrInvalidSector
+	}
+	// Decode the track information on the stack: locate runs for every
+	// sector read and written.
+	var sectors [29]SectorInfo
+	ti, err := decodeTrackInfo(td, sectors[:0])
+	if err != nil {
+		return nil, 0, 0, 0, 0, ErrInvalidSector
+	}
+	pos = sector
+	if byID {
+		if i := ti.sectorIndex(di.spec.FirstSectorID + sector); i >= 0 {
+			pos = i
+		}
+	}
+	if pos >= len(ti.SectorInfo) {
+		return nil, 0, 0, 0, 0, ErrInvalidSector
+	}
+	off, size = ti.sectorOffset(pos)
+	if off+size > len(td) {
+		return nil, 0, 0, 0, 0, ErrInvalidSector // the image holds less than the sector
+	}
+	copies = ti.SectorInfo[pos].Copies()
+	return td, pos, off, size / copies, copies, nil
+}
+
+// sectorAt returns the data of the sector at a physical position on a track.
+func (di *DiskImage) sectorAt(track, pos, side int) ([]byte, error) {
+	td, _, off, size, _, err := di.locate(track, pos, side, false)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), td[off:off+size]...), nil
 }
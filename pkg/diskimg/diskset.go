@@ -0,0 +1,281 @@
+// file: pkg/diskimg/diskset.go
+
+package diskimg
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Software too large for one disk ships as a set of disks. A DiskSet treats
+// the disks as one collection of files: a file that fits on no single disk
+// is split into parts, stored in order across the disks and joined again on
+// read. Part n of "NAME.EXT" is named "NAME$nn.EXT" (the name cut to five
+// characters), so each part is an ordinary file that +3DOS can copy. The first
+// part starts with the file's PLUS3DOS header, which records the length of
+// the whole file.
+const maxSetParts = 99
+
+// DiskSet is an ordered set of disks holding one collection of files.
+type DiskSet struct {
+	Disks []*DiskImage
+}
+
+// SetFile is a file of a disk set.
+type SetFile struct {
+	Name  string    // logical file name
+	Parts []SetPart // the disks holding the file, one part for an unsplit file
+}
+
+// SetPart is the piece of a file stored on one disk of a set.
+type SetPart struct {
+	Disk int    // index of the disk in the set
+	Name string // file name on that disk
+	Size int    // bytes stored on that disk
+}
+
+// Spanned reports whether the file is split across disks.
+func (f SetFile) Spanned() bool {
+	return len(f.Parts) > 1 || f.Parts[0].Name != f.Name
+}
+
+// Size returns the number of bytes stored for the file.
+func (f SetFile) Size() int {
+	n := 0
+	for _, p := range f.Parts {
+		n += p.Size
+	}
+	return n
+}
+
+// NewDiskSet returns a set of the given disks, in order.
+func NewDiskSet(disks ...*DiskImage) *DiskSet {
+	return &DiskSet{Disks: disks}
+}
+
+// partName returns the name of part n (from 1) of a spanned file.
+func partName(name string, n int) string {
+	stem, ext, _ := strings.Cut(NormaliseFilename(name), ".")
+	if len(stem) > 5 {
+		stem = stem[:5]
+	}
+	if ext == "" {
+		return fmt.Sprintf("%s$%02d", stem, n)
+	}
+	return fmt.Sprintf("%s$%02d.%s", stem, n, ext)
+}
+
+// parsePartName splits a part name into the logical file name and part
+// number; ok is false for the name of an ordinary file.
+func parsePartName(name string) (file string, n int, ok bool) {
+	stem, ext, dotted := strings.Cut(name, ".")
+	i := strings.LastIndexByte(stem, '$')
+	if i < 1 || len(stem)-i != 3 {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(stem[i+1:])
+	if err != nil || n < 1 {
+		return "", 0, false
+	}
+	file = stem[:i]
+	if dotted {
+		file += "." + ext
+	}
+	return file, n, true
+}
+
+// Files returns the files of the set in the order they are first found,
+// with the parts of spanned files gathered under their logical name.
+func (s *DiskSet) Files() []SetFile {
+	var files []SetFile
+	index := make(map[string]int)
+	for d, di := range s.Disks {
+		for e := range di.Files(&FilesOptions{System: true}) {
+			name := e.GetFilename()
+			part := SetPart{Disk: d, Name: name, Size: di.fileRecords(di.directory.fileExtents(&e)) * 128}
+			// Not ReadHeader: closing the file would store the header's length,
+			// which for the first part of a spanned file is the whole file's.
+			if f, err := di.OpenFile(name, os.O_RDONLY); err == nil && f.isHeadered && int(f.header.FileLength) <= part.Size {
+				part.Size = int(f.header.FileLength)
+			}
+			key := name
+			if file, _, ok := parsePartName(name); ok {
+				key = file
+			}
+			if i, ok := index[key]; ok && key != name {
+				files[i].Parts = append(files[i].Parts, part)
+				continue
+			}
+			index[key] = len(files)
+			files = append(files, SetFile{Name: key, Parts: []SetPart{part}})
+		}
+	}
+	return files
+}
+
+// findFile returns the set file with the given name (case-insensitive).
+func (s *DiskSet) findFile(name string) (SetFile, error) {
+	for _, f := range s.Files() {
+		if NormaliseFilename(f.Name) == NormaliseFilename(name) {
+			return f, nil
+		}
+	}
+	return SetFile{}, &FileError{Op: "find", Name: NormaliseFilename(name), Err: ErrFileNotFound}
+}
+
+// ReadFile returns the contents of a file of the set, including its PLUS3DOS
+// header, joining the parts of a spanned file in order.
+func (s *DiskSet) ReadFile(name string) ([]byte, error) {
+	f, err := s.findFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	for i, p := range f.Parts {
+		if f.Spanned() {
+			if _, n, _ := parsePartName(p.Name); n != i+1 {
+				return nil, fmt.Errorf("%w: part %d of %s", ErrFileNotFound, i+1, f.Name)
+			}
+		}
+		part, err := s.Disks[p.Disk].readRecords(p.Name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p.Name, err)
+		}
+		data = append(data, part[:min(p.Size, len(part))]...)
+	}
+	// Only the first part has a header; it gives the length of the whole file.
+	h := &Plus3DosHeader{}
+	if len(data) >= HeaderSize && h.FromBytes(data[:HeaderSize]) == nil && h.Validate() == nil {
+		if int(h.FileLength) > len(data) {
+			if f.Spanned() {
+				return nil, fmt.Errorf("%w: part %d of %s", ErrFileNotFound, len(f.Parts)+1, f.Name)
+			}
+			return data, nil
+		}
+		data = data[:h.FileLength]
+	}
+	return data, nil
+}
+
+// WriteFile stores a file in the set. It goes on the first disk with room for
+// it; if no disk has, it is split across the disks with free space, in order.
+// It returns the parts written.
+func (s *DiskSet) WriteFile(name string, data []byte) ([]SetPart, error) {
+	name = NormaliseFilename(name)
+	if _, err := s.findFile(name); err == nil {
+		return nil, &FileError{Op: "add", Name: name, Err: ErrFileExists}
+	}
+	for d, di := range s.Disks {
+		if di.fileSpace() >= len(data) {
+			if err := di.writeRecords(name, data); err != nil {
+				return nil, err
+			}
+			return []SetPart{{Disk: d, Name: name, Size: len(data)}}, nil
+		}
+	}
+
+	// Split: plan every part before writing anything.
+	var parts []SetPart
+	left := len(data)
+	for d, di := range s.Disks {
+		if left == 0 {
+			break
+		}
+		if n := min(di.fileSpace(), left); n > 0 {
+			parts = append(parts, SetPart{Disk: d, Name: partName(name, len(parts)+1), Size: n})
+			left -= n
+		}
+	}
+	if left > 0 {
+		return nil, fmt.Errorf("%w: %s needs %d more bytes than the set has free", ErrDiskFull, name, left)
+	}
+	if len(parts) > maxSetParts {
+		return nil, fmt.Errorf("%w: %s would need more than %d parts", ErrFileTooLarge, name, maxSetParts)
+	}
+	off := 0
+	for _, p := range parts {
+		if err := s.Disks[p.Disk].writeRecords(p.Name, data[off:off+p.Size]); err != nil {
+			return nil, err
+		}
+		off += p.Size
+	}
+	return parts, nil
+}
+
+// ImportFile stores a host file in the set under diskPath, with a PLUS3DOS
+// header if opts asks for one (see DiskImage.ImportFile).
+func (s *DiskSet) ImportFile(hostPath, diskPath string, opts *ImportOptions) ([]SetPart, error) {
+	data, err := os.ReadFile(hostPath)
+	if err != nil {
+		return nil, err
+	}
+	if opts != nil && opts.AddHeader {
+		header, err := importHeader(len(data), opts)
+		if err != nil {
+			return nil, err
+		}
+		data = append(header.toBytes(), data...)
+	}
+	return s.WriteFile(diskPath, data)
+}
+
+// DeleteFile removes a file, and every part of a spanned file, from the set.
+func (s *DiskSet) DeleteFile(name string) error {
+	f, err := s.findFile(name)
+	if err != nil {
+		return err
+	}
+	for _, p := range f.Parts {
+		if err := s.Disks[p.Disk].DeleteFile(p.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileSpace returns the number of bytes a new file can hold on the disk: the
+// free blocks that the free directory entries, one per extent, can address.
+func (di *DiskImage) fileSpace() int {
+	entries := 0
+	for i := range di.directory.Entries {
+		if di.directory.Entries[i].IsUnused() {
+			entries++
+		}
+	}
+	return min(di.fileAlloc.GetFreeBlocks(), entries*di.spec.entryBlocks()) * di.spec.BlockSize
+}
+
+// writeRecords creates a file holding data exactly, whether or not it starts
+// with a PLUS3DOS header, and flushes the directory.
+func (di *DiskImage) writeRecords(name string, data []byte) error {
+	f, err := di.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return di.FlushDirectory()
+}
+
+// readRecords returns every record of a file, ignoring the length in any
+// PLUS3DOS header (which, in the first part of a spanned file, is the length
+// of the whole file).
+func (di *DiskImage) readRecords(name string) ([]byte, error) {
+	f, err := di.OpenFile(name, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	f.size = int64(di.fileRecords(f.extents)) * 128
+	data := make([]byte, f.size)
+	n, err := f.ReadAt(data, 0)
+	if n < len(data) {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,82 @@
+package diskimg
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// A file too large for any one disk of a set is split across the disks and
+// joined again on read; deleting it removes every part.
+func TestDiskSetSpanning(t *testing.T) {
+	a, b := NewDiskImage(), NewDiskImage()
+	set := NewDiskSet(a, b)
+
+	small := bytes.Repeat([]byte{0x11}, 3000)
+	if _, err := set.WriteFile("small.dat", small); err != nil {
+		t.Fatalf("WriteFile small: %v", err)
+	}
+	body := bytes.Repeat([]byte("spanned "), 31250)
+	header, err := importHeader(len(body), &ImportOptions{AddHeader: true, FileType: FileTypeCode, LoadAddr: 32768})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := append(header.toBytes(), body...)
+	parts, err := set.WriteFile("game.bin", data)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if len(parts) != 2 || parts[0].Name != "GAME$01.BIN" || parts[0].Disk != 0 ||
+		parts[1].Name != "GAME$02.BIN" || parts[1].Disk != 1 {
+		t.Fatalf("parts = %+v", parts)
+	}
+	if _, err := set.WriteFile("GAME.BIN", data); !errors.Is(err, ErrFileExists) {
+		t.Errorf("second WriteFile: err = %v, want ErrFileExists", err)
+	}
+
+	files := set.Files()
+	if len(files) != 2 || files[0].Spanned() || !files[1].Spanned() || files[1].Name != "GAME.BIN" {
+		t.Fatalf("files = %+v", files)
+	}
+	got, err := set.ReadFile("game.bin")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("ReadFile returned %d bytes, want the %d written", len(got), len(data))
+	}
+	if got, _ := set.ReadFile("SMALL.DAT"); !bytes.Equal(got[:len(small)], small) {
+		t.Error("unsplit file differs")
+	}
+
+	// Without the disk holding the second part the file is incomplete.
+	if _, err := NewDiskSet(a).ReadFile("GAME.BIN"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("ReadFile of an incomplete set: err = %v, want ErrFileNotFound", err)
+	}
+
+	if err := set.DeleteFile("GAME.BIN"); err != nil {
+		t.Fatalf("DeleteFile: %v", err)
+	}
+	if files := set.Files(); len(files) != 1 {
+		t.Errorf("after DeleteFile, files = %+v", files)
+	}
+}
+
+func TestPartName(t *testing.T) {
+	for _, tc := range []struct{ name, part, back string }{
+		{"GAME.BIN", "GAME$01.BIN", "GAME.BIN"},
+		{"LONGNAME.DAT", "LONGN$01.DAT", "LONGN.DAT"},
+		{"NOEXT", "NOEXT$01", "NOEXT"},
+	} {
+		part := partName(tc.name, 1)
+		if part != tc.part {
+			t.Errorf("partName(%q) = %q, want %q", tc.name, part, tc.part)
+		}
+		if back, n, ok := parsePartName(part); !ok || n != 1 || back != tc.back {
+			t.Errorf("parsePartName(%q) = %q, %d, %v", part, back, n, ok)
+		}
+	}
+	if _, _, ok := parsePartName("PRICE$5.TXT"); ok {
+		t.Error("PRICE$5.TXT parsed as a part name")
+	}
+}
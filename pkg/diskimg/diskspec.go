@@ -0,0 +1,437 @@
+// file: pkg/diskimg/diskspec.go
+
+package diskimg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Sidedness values, as in byte 1 of the +3DOS disk specification: how logical
+// tracks are laid out on a double-sided disk.
+const (
+	SidesSingle     = 0 // single-sided
+	SidesAlternate  = 1 // logical tracks alternate between side 0 and side 1
+	SidesSuccessive = 2 // all of side 0, then all of side 1
+)
+
+// DiskSpec describes the geometry and CP/M layout of a disk format. Every disk
+// image carries one; the package-level constants (TracksPerSide,
+// SectorsPerTrack, ...) describe the standard +3 format, SpecPlus3.
+type DiskSpec struct {
+	Name            string // short name, e.g. "+3"
+	Sides           int    // physical sides, 1 or 2
+	TracksPerSide   int    // logical tracks on each side
+	SectorsPerTrack int
+	SectorSize      int // bytes per sector
+	FirstSectorID   int // ID (R) of the first sector on each track
+	Sidedness       int // SidesSingle, SidesAlternate or SidesSuccessive
+	ReservedTracks  int // system tracks before the directory
+	BlockSize       int // allocation block size in bytes
+	DirBlocks       int // allocation blocks occupied by the directory
+	GapRW           int // gap length for reads and writes
+	GapFormat       int // gap length when formatting
+	Interleave      int // physical positions between consecutive sector IDs; 0 or 1 is sequential
+	Skew            int // positions the first sector ID moves on from one track to the next
+}
+
+// SpecPlus3 is the standard +3 format: single-sided, 40 tracks of nine 512-byte
+// sectors, one reserved track, 1K blocks and a 64-entry directory.
+var SpecPlus3 = DiskSpec{
+	Name:            "+3",
+	Sides:           1,
+	TracksPerSide:   TracksPerSide,
+	SectorsPerTrack: SectorsPerTrack,
+	SectorSize:      BytesPerSector,
+	FirstSectorID:   1,
+	Sidedness:       SidesSingle,
+	ReservedTracks:  1,
+	BlockSize:       BlockSize,
+	DirBlocks:       2,
+	GapRW:           0x2A,
+	GapFormat:       0x52,
+}
+
+// SpecPlus3DS is the double-sided, 80-track (720K) format used with 3.5"
+// drives on the +3 and PCW: sides alternate, 2K blocks and a 256-entry
+// directory.
+var SpecPlus3DS = DiskSpec{
+	Name:            "720k",
+	Sides:           2,
+	TracksPerSide:   80,
+	SectorsPerTrack: 9,
+	SectorSize:      512,
+	FirstSectorID:   1,
+	Sidedness:       SidesAlternate,
+	ReservedTracks:  1,
+	BlockSize:       2048,
+	DirBlocks:       4,
+	GapRW:           0x2A,
+	GapFormat:       0x52,
+}
+
+// SpecCPCSystem is the Amstrad CPC system (vendor) format: the +3 geometry
+// with sector IDs from 0x41 and two reserved tracks holding the CP/M boot
+// code, leaving 171 blocks.
+var SpecCPCSystem = DiskSpec{
+	Name:            "cpc-system",
+	Sides:           1,
+	TracksPerSide:   40,
+	SectorsPerTrack: 9,
+	SectorSize:      512,
+	FirstSectorID:   0x41,
+	Sidedness:       SidesSingle,
+	ReservedTracks:  2,
+	BlockSize:       1024,
+	DirBlocks:       2,
+	GapRW:           0x2A,
+	GapFormat:       0x52,
+}
+
+// SpecCPCData is the Amstrad CPC data-only format: sector IDs from 0xC1 and no
+// reserved tracks, so the directory starts on track 0 and there are 180
+// blocks.
+var SpecCPCData = DiskSpec{
+	Name:            "cpc-data",
+	Sides:           1,
+	TracksPerSide:   40,
+	SectorsPerTrack: 9,
+	SectorSize:      512,
+	FirstSectorID:   0xC1,
+	Sidedness:       SidesSingle,
+	ReservedTracks:  0,
+	BlockSize:       1024,
+	DirBlocks:       2,
+	GapRW:           0x2A,
+	GapFormat:       0x52,
+}
+
+// SpecPCW180 is the Amstrad PCW 8256/8512 single-sided 3" (CF2) format. Its
+// layout is that of the +3 format, but a PCW has no built-in default: every
+// PCW disk carries the disk specification in its boot record, and a bootable
+// one is marked by its boot sector checksum (see BootChecksum).
+var SpecPCW180 = DiskSpec{
+	Name:            "pcw180",
+	Sides:           1,
+	TracksPerSide:   40,
+	SectorsPerTrack: 9,
+	SectorSize:      512,
+	FirstSectorID:   1,
+	Sidedness:       SidesSingle,
+	ReservedTracks:  1,
+	BlockSize:       1024,
+	DirBlocks:       2,
+	GapRW:           0x2A,
+	GapFormat:       0x52,
+}
+
+// SpecPCW720 is the Amstrad PCW double-sided 80-track (CF2DD) format of the
+// PCW 8512's second drive and the PCW 9512: the 720K layout, with 2K blocks
+// and a 256-entry directory.
+var SpecPCW720 = DiskSpec{
+	Name:            "pcw720",
+	Sides:           2,
+	TracksPerSide:   80,
+	SectorsPerTrack: 9,
+	SectorSize:      512,
+	FirstSectorID:   1,
+	Sidedness:       SidesAlternate,
+	ReservedTracks:  1,
+	BlockSize:       2048,
+	DirBlocks:       4,
+	GapRW:           0x2A,
+	GapFormat:       0x52,
+}
+
+// knownSpecs are the formats recognised when an image is loaded. The PCW
+// formats share their layout with the +3 ones, which come first; a PCW disk
+// is told apart by its boot record (see withBootRecord).
+var knownSpecs = []DiskSpec{SpecPlus3, SpecPlus3DS, SpecCPCSystem, SpecCPCData, SpecPCW180, SpecPCW720}
+
+// Validate checks that the specification describes a usable format.
+func (s DiskSpec) Validate() error {
+	switch {
+	case s.Sides < 1 || s.Sides > 2:
+		return fmt.Errorf("%w: number of sides: %d", ErrInvalidSpec, s.Sides)
+	case s.Sides == 1 && s.Sidedness != SidesSingle,
+		s.Sides == 2 && s.Sidedness != SidesAlternate && s.Sidedness != SidesSuccessive:
+		return fmt.Errorf("%w: sidedness %d does not match %d side(s)", ErrInvalidSpec, s.Sidedness, s.Sides)
+	case s.TracksPerSide < 1 || s.TracksPerSide > 255:
+		return fmt.Errorf("%w: number of tracks: %d", ErrInvalidSpec, s.TracksPerSide)
+	case s.SectorsPerTrack < 1 || s.SectorsPerTrack > 29:
+		return fmt.Errorf("%w: sectors per track: %d", ErrInvalidSpec, s.SectorsPerTrack)
+	case s.SectorSize < 128 || s.SectorSize > 8192 || s.SectorSize&(s.SectorSize-1) != 0:
+		return fmt.Errorf("%w: sector size: %d", ErrInvalidSpec, s.SectorSize)
+	case s.FirstSectorID < 0 || s.FirstSectorID+s.SectorsPerTrack > 256:
+		return fmt.Errorf("%w: first sector ID: %#x", ErrInvalidSpec, s.FirstSectorID)
+	case s.BlockSize < 1024 || s.BlockSize > 16384 || s.BlockSize&(s.BlockSize-1) != 0 || s.BlockSize < s.SectorSize:
+		return fmt.Errorf("%w: block size: %d", ErrInvalidSpec, s.BlockSize)
+	case s.ReservedTracks < 0 || s.ReservedTracks >= s.TotalTracks():
+		return fmt.Errorf("%w: reserved track count: %d", ErrInvalidSpec, s.ReservedTracks)
+	case s.DirBlocks < 1 || s.DirBlocks > 16 || s.DirBlocks >= s.TotalBlocks():
+		return fmt.Errorf("%w: directory block count: %d (1 to 16)", ErrInvalidSpec, s.DirBlocks)
+	case s.GapRW < 1 || s.GapRW > 255 || s.GapFormat < 1 || s.GapFormat > 255:
+		return fmt.Errorf("%w: gap lengths: %d, %d", ErrInvalidSpec, s.GapRW, s.GapFormat)
+	case s.Interleave < 0 || s.Interleave >= s.SectorsPerTrack:
+		return fmt.Errorf("%w: interleave: %d", ErrInvalidSpec, s.Interleave)
+	case s.Skew < 0 || s.Skew >= s.SectorsPerTrack:
+		return fmt.Errorf("%w: skew: %d", ErrInvalidSpec, s.Skew)
+	}
+	return nil
+}
+
+// TotalTracks returns the number of logical tracks on both sides.
+func (s DiskSpec) TotalTracks() int {
+	return s.Sides * s.TracksPerSide
+}
+
+// TrackSize returns the size of a track block in a .dsk image: the 256-byte
+// track information block plus the sector data.
+func (s DiskSpec) TrackSize() int {
+	return 256 + s.SectorsPerTrack*s.SectorSize
+}
+
+// SectorsPerBlock returns the number of sectors in an allocation block.
+func (s DiskSpec) SectorsPerBlock() int {
+	return s.BlockSize / s.SectorSize
+}
+
+// TotalBlocks returns the number of allocation blocks in the data area (the
+// CP/M DSM value plus one), including the directory blocks.
+func (s DiskSpec) TotalBlocks() int {
+	dataSectors := (s.TotalTracks() - s.ReservedTracks) * s.SectorsPerTrack
+	return dataSectors / s.SectorsPerBlock()
+}
+
+// DirEntries returns the number of 32-byte directory entries: the capacity of
+// the directory, which fills its DirBlocks blocks.
+func (s DiskSpec) DirEntries() int {
+	return s.DirBlocks * s.BlockSize / DirectoryEntrySize
+}
+
+// WideBlockPointers reports whether directory entries hold 16-bit block
+// numbers (eight per entry) rather than 8-bit ones (sixteen per entry). CP/M
+// switches to 16-bit pointers once a disk has more than 256 blocks.
+func (s DiskSpec) WideBlockPointers() bool {
+	return s.TotalBlocks() > 256
+}
+
+// entryBlocks returns the number of block pointers in a directory entry.
+func (s DiskSpec) entryBlocks() int {
+	if s.WideBlockPointers() {
+		return 8
+	}
+	return 16
+}
+
+// ExtentMask returns the CP/M extent mask (EXM): a directory entry addresses
+// EXM+1 logical extents of 16K, so a file larger than that needs an entry for
+// each further EXM+1 extents.
+func (s DiskSpec) ExtentMask() int {
+	return max(s.entryBlocks()*s.BlockSize/16384-1, 0)
+}
+
+// PhysicalTrack maps a logical track number to its physical track and side.
+func (s DiskSpec) PhysicalTrack(logical int) (track, side int) {
+	switch s.Sidedness {
+	case SidesAlternate:
+		return logical / 2, logical % 2
+	case SidesSuccessive:
+		return logical % s.TracksPerSide, logical / s.TracksPerSide
+	}
+	return logical, 0
+}
+
+// BlockSector returns the physical location of the n-th sector of an
+// allocation block. Blocks are numbered from the start of the data area,
+// which follows the reserved tracks.
+func (s DiskSpec) BlockSector(block, n int) (track, sector, side int) {
+	linear := block*s.SectorsPerBlock() + n
+	track, side = s.PhysicalTrack(s.ReservedTracks + linear/s.SectorsPerTrack)
+	return track, linear % s.SectorsPerTrack, side
+}
+
+// diskType returns the +3DOS disk type: 0 for the +3 format, 1 for CPC
+// system, 2 for CPC data and 3 for double-sided formats.
+func (s DiskSpec) diskType() byte {
+	switch {
+	case s.Sides == 2:
+		return 3
+	case s.FirstSectorID == SpecCPCSystem.FirstSectorID:
+		return 1
+	case s.FirstSectorID == SpecCPCData.FirstSectorID:
+		return 2
+	}
+	return 0
+}
+
+// isCPC reports whether the format is one of the CPC formats, which are
+// recognised by their sector IDs rather than a disk specification.
+func (s DiskSpec) isCPC() bool {
+	t := s.diskType()
+	return t == 1 || t == 2
+}
+
+// usesSpecSector reports whether a disk in this format identifies itself with
+// a disk specification in its boot sector. The standard +3 format logs on by
+// default.
+func (s DiskSpec) usesSpecSector() bool {
+	s.Interleave, s.Skew = 0, 0 // the sector order is not part of the specification
+	return s != SpecPlus3 && !s.isCPC()
+}
+
+// BootChecksum returns the value the bytes of a bootable disk's boot sector
+// add up to (modulo 256): 255 for a PCW 8256/8512 disk, 1 for a PCW 9512 one
+// and 3 for a +3 disk.
+func (s DiskSpec) BootChecksum() byte {
+	switch s.Name {
+	case SpecPCW180.Name:
+		return 0xFF
+	case SpecPCW720.Name:
+		return 1
+	}
+	return 3
+}
+
+// withBootRecord returns the PCW format with the layout of s if boot, the
+// whole first sector, is a PCW boot record: a disk specification and a PCW
+// boot checksum. Otherwise it returns s.
+func withBootRecord(s DiskSpec, boot []byte) DiskSpec {
+	if len(boot) < 10 || boot[0] > 3 {
+		return s
+	}
+	var sum byte
+	for _, b := range boot {
+		sum += b
+	}
+	for _, pcw := range []DiskSpec{SpecPCW180, SpecPCW720} {
+		named := s
+		named.Name = pcw.Name
+		if named == pcw && sum == pcw.BootChecksum() {
+			return pcw
+		}
+	}
+	return s
+}
+
+// sectorOrder returns the logical sector (ID minus the first ID) at each
+// physical position of a freshly formatted track. Consecutive sectors are
+// placed Interleave positions apart, moving on to the next free position when
+// one is taken, starting Skew positions further on for each track.
+func (s DiskSpec) sectorOrder(track int) []int {
+	n := s.SectorsPerTrack
+	order := make([]int, n)
+	taken := make([]bool, n)
+	pos := track * s.Skew % n
+	for logical := 0; logical < n; logical++ {
+		for taken[pos] {
+			pos = (pos + 1) % n
+		}
+		order[pos], taken[pos] = logical, true
+		pos = (pos + max(s.Interleave, 1)) % n
+	}
+	return order
+}
+
+// specForGeometry picks the format of a loaded image from the geometry in its
+// disc information block and a sector ID from its first track. Physical images
+// often carry a few tracks beyond the format (40-45 on a 40-track disk), which
+// are kept but not used by CP/M. As on the CPC, only the top two bits of the
+// sector ID are compared, so the format is found whatever the sector order.
+func specForGeometry(tracks, sides, sectorID int) (DiskSpec, error) {
+	for _, s := range knownSpecs {
+		if sides == s.Sides && sectorID&0xC0 == s.FirstSectorID&0xC0 &&
+			tracks >= s.TracksPerSide && tracks <= s.TracksPerSide+MaxTracksPerSide-TracksPerSide {
+			return s, nil
+		}
+	}
+	return DiskSpec{}, fmt.Errorf("%w: unsupported geometry: %d tracks, %d side(s), sector ID %#x",
+		ErrCorruptImage, tracks, sides, sectorID)
+}
+
+// specFromBootSector parses a +3DOS disk specification at the start of a boot
+// sector. It reports false if the sector holds none or the specification does
+// not describe a usable format.
+func specFromBootSector(boot []byte) (DiskSpec, bool) {
+	ds, err := ParseDiskSpecification(boot)
+	if err != nil {
+		return DiskSpec{}, false
+	}
+	s, err := ds.DiskSpec()
+	return s, err == nil
+}
+
+// withPresetName returns the preset matching s in everything but its name, or
+// s itself if there is none.
+func withPresetName(s DiskSpec) DiskSpec {
+	for _, k := range knownSpecs {
+		named := s
+		named.Name = k.Name
+		if named == k {
+			return k
+		}
+	}
+	return s
+}
+
+// ParseDiskSpec parses a format given by preset name ("+3", "720k",
+// "cpc-system", "cpc-data", "pcw180", "pcw720") or as comma-separated numbers:
+//
+//	tracks,sides,sectors,size[,reserved,block,dirblocks[,gaprw,gapformat]]
+//
+// Omitted values default to one reserved track, 1K blocks (2K if the disk
+// would have more than 256), a directory of two blocks (four with 2K blocks)
+// and the +3 gap lengths. Double-sided formats alternate sides.
+func ParseDiskSpec(text string) (DiskSpec, error) {
+	for _, k := range knownSpecs {
+		if strings.EqualFold(text, k.Name) {
+			return k, nil
+		}
+	}
+	fields := strings.Split(text, ",")
+	if n := len(fields); n != 4 && n != 7 && n != 9 {
+		return DiskSpec{}, fmt.Errorf("%w %q: want a preset name or 4, 7 or 9 numbers", ErrInvalidSpec, text)
+	}
+	v := make([]int, len(fields))
+	for i, f := range fields {
+		n, err := strconv.ParseInt(strings.TrimSpace(f), 0, 0)
+		if err != nil {
+			return DiskSpec{}, fmt.Errorf("%w %q: %w", ErrInvalidSpec, text, err)
+		}
+		v[i] = int(n)
+	}
+	s := DiskSpec{
+		Name:            "custom",
+		TracksPerSide:   v[0],
+		Sides:           v[1],
+		SectorsPerTrack: v[2],
+		SectorSize:      v[3],
+		FirstSectorID:   1,
+		ReservedTracks:  1,
+		BlockSize:       1024,
+		DirBlocks:       2,
+		GapRW:           SpecPlus3.GapRW,
+		GapFormat:       SpecPlus3.GapFormat,
+	}
+	if s.Sides == 2 {
+		s.Sidedness = SidesAlternate
+	}
+	if len(v) >= 7 {
+		s.ReservedTracks, s.BlockSize, s.DirBlocks = v[4], v[5], v[6]
+	} else if s.SectorSize > 0 && s.SectorSize <= s.BlockSize && s.TotalBlocks() > 256 {
+		s.BlockSize, s.DirBlocks = 2048, 4
+	}
+	if len(v) == 9 {
+		s.GapRW, s.GapFormat = v[7], v[8]
+	}
+	if err := s.Validate(); err != nil {
+		return DiskSpec{}, fmt.Errorf("%q: %w", text, err)
+	}
+	return withPresetName(s), nil
+}
+
+// Spec returns the format of the disk image.
+func (di *DiskImage) Spec() DiskSpec {
+	return di.spec
+}
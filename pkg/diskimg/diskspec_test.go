@@ -0,0 +1,443 @@
+package diskimg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"testing"
+)
+
+// newSpecImage returns a blank image in the given format.
+func newSpecImage(t *testing.T, spec DiskSpec) *DiskImage {
+	t.Helper()
+	di, err := NewDiskImageWithSpec(spec)
+	if err != nil {
+		t.Fatalf("NewDiskImageWithSpec(%s): %v", spec.Name, err)
+	}
+	if err := di.InitializeDirectory(); err != nil {
+		t.Fatalf("InitializeDirectory: %v", err)
+	}
+	return di
+}
+
+func TestSpecPlus3DSGeometry(t *testing.T) {
+	s := SpecPlus3DS
+	if err := s.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if got := s.TotalBlocks(); got != 357 {
+		t.Errorf("TotalBlocks = %d, want 357", got)
+	}
+	if got := s.DirEntries(); got != 256 {
+		t.Errorf("DirEntries = %d, want 256", got)
+	}
+	if !s.WideBlockPointers() || SpecPlus3.WideBlockPointers() {
+		t.Error("only the 720K format should use 16-bit block pointers")
+	}
+	// Logical track 1 (the first after the reserved track) is cylinder 0 side 1.
+	if track, sector, side := s.BlockSector(0, 0); track != 0 || sector != 0 || side != 1 {
+		t.Errorf("BlockSector(0, 0) = %d/%d/%d, want track 0 sector 0 side 1", track, sector, side)
+	}
+	want := []byte{0x03, 0x81, 0x50, 0x09, 0x02, 0x01, 0x04, 0x04, 0x2A, 0x52}
+	if got := s.Specification().Serialize(); !bytes.Equal(got, want) {
+		t.Errorf("Specification().Serialize() = % x, want % x", got, want)
+	}
+}
+
+// Both sides of a cylinder are stored together in the container.
+func TestDoubleSidedTrackInterleave(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3DS)
+	if err := di.SetSectorData(0, 0, 1, bytes.Repeat([]byte{0x42}, 512)); err != nil {
+		t.Fatalf("SetSectorData: %v", err)
+	}
+	if got := di.Tracks[1][256]; got != 0x42 {
+		t.Errorf("cylinder 0 side 1 not at container index 1 (got %#x)", got)
+	}
+	if di.Tracks[1][0x11] != 1 || di.Tracks[2][0x10] != 1 {
+		t.Error("track information blocks do not follow the interleaved order")
+	}
+}
+
+// A file written to a 720K disk survives a save and reload, and the directory
+// records it with 16-bit block pointers clear of the directory blocks.
+func TestPlus3DSRoundTrip(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3DS)
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	f, err := di.OpenFile("DATA.BIN", os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := di.Save(&buf); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	loaded, err := Load(&buf)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if loaded.Spec() != SpecPlus3DS {
+		t.Fatalf("loaded spec = %s, want %s", loaded.Spec().Name, SpecPlus3DS.Name)
+	}
+	if err := loaded.DiskCheck(); err != nil {
+		t.Errorf("DiskCheck: %v", err)
+	}
+
+	entry, err := loaded.directory.FindFile("DATA.BIN")
+	if err != nil {
+		t.Fatalf("FindFile: %v", err)
+	}
+	blocks := entry.blockPointers(true)
+	if len(blocks) != 5 || blocks[0] < SpecPlus3DS.DirBlocks {
+		t.Errorf("blocks = %v, want 5 blocks after the directory", blocks)
+	}
+
+	rf, err := loaded.OpenFile("DATA.BIN", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	got, err := io.ReadAll(io.LimitReader(rf, int64(len(data))))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("file contents changed across save and load")
+	}
+}
+
+// The CPC formats differ from +3 in their sector IDs and reserved tracks, and
+// are recognised again from the sector IDs when loaded.
+func TestCPCFormats(t *testing.T) {
+	tests := []struct {
+		spec     DiskSpec
+		firstID  byte
+		dirTrack int
+		blocks   int
+	}{
+		{SpecCPCSystem, 0x41, 2, 171},
+		{SpecCPCData, 0xC1, 0, 180},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec.Name, func(t *testing.T) {
+			di := newSpecImage(t, tt.spec)
+			if got := di.Tracks[0][0x18+2]; got != tt.firstID {
+				t.Errorf("first sector ID = %#x, want %#x", got, tt.firstID)
+			}
+			if got := tt.spec.TotalBlocks(); got != tt.blocks {
+				t.Errorf("TotalBlocks = %d, want %d", got, tt.blocks)
+			}
+			if track, _, _ := tt.spec.BlockSector(0, 0); track != tt.dirTrack {
+				t.Errorf("directory on track %d, want %d", track, tt.dirTrack)
+			}
+
+			var buf bytes.Buffer
+			if err := di.Save(&buf); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+			loaded, err := Load(&buf)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if loaded.Spec() != tt.spec {
+				t.Errorf("loaded spec = %s, want %s", loaded.Spec().Name, tt.spec.Name)
+			}
+			if err := loaded.DiskCheck(); err != nil {
+				t.Errorf("DiskCheck: %v", err)
+			}
+		})
+	}
+}
+
+// A format described only by the disk specification in its boot sector (here
+// 80 single-sided tracks with 2K blocks, which matches no preset) is picked
+// up on load, from a .dsk or a raw image, and its files can be read back.
+func TestBootSectorSpec(t *testing.T) {
+	spec := DiskSpec{
+		Name: "custom", Sides: 1, TracksPerSide: 80, SectorsPerTrack: 9, SectorSize: 512,
+		FirstSectorID: 1, Sidedness: SidesSingle, ReservedTracks: 1, BlockSize: 2048, DirBlocks: 2,
+		GapRW: 0x2A, GapFormat: 0x52,
+	}
+	di := newSpecImage(t, spec)
+	data := bytes.Repeat([]byte("boot spec "), 1000)
+	f, err := di.OpenFile("DATA.BIN", os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	var dsk, raw bytes.Buffer
+	if err := di.Save(&dsk); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := di.SaveRaw(&raw); err != nil {
+		t.Fatalf("SaveRaw: %v", err)
+	}
+	for name, load := range map[string]func() (*DiskImage, error){
+		"dsk": func() (*DiskImage, error) { return Load(&dsk) },
+		"raw": func() (*DiskImage, error) { return LoadRaw(&raw) },
+	} {
+		loaded, err := load()
+		if err != nil {
+			t.Fatalf("%s: load: %v", name, err)
+		}
+		if loaded.Spec() != spec {
+			t.Errorf("%s: spec = %+v, want %+v", name, loaded.Spec(), spec)
+		}
+		r, err := loaded.OpenFile("DATA.BIN", os.O_RDONLY)
+		if err != nil {
+			t.Fatalf("%s: OpenFile: %v", name, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: read: %v", name, err)
+		}
+		if !bytes.Equal(got[:len(data)], data) {
+			t.Errorf("%s: file contents differ", name)
+		}
+	}
+
+	// A specification matching a preset loads as that preset.
+	if s, ok := specFromBootSector(SpecPlus3DS.Specification().Serialize()); !ok || s.Name != SpecPlus3DS.Name {
+		t.Errorf("720K specification parsed as %+v, %v", s, ok)
+	}
+}
+
+func TestParseDiskSpec(t *testing.T) {
+	for text, want := range map[string]DiskSpec{
+		"720K":                          SpecPlus3DS,
+		"40,1,9,512":                    SpecPlus3,
+		"80,2,9,512,1,2048,4,0x2A,0x52": SpecPlus3DS,
+	} {
+		got, err := ParseDiskSpec(text)
+		if err != nil || got != want {
+			t.Errorf("ParseDiskSpec(%q) = %+v, %v; want %s", text, got, err, want.Name)
+		}
+	}
+
+	// 80 double-sided tracks would have more than 256 1K blocks.
+	got, err := ParseDiskSpec("80,2,10,512")
+	if err != nil {
+		t.Fatalf("ParseDiskSpec: %v", err)
+	}
+	if got.Name != "custom" || got.BlockSize != 2048 || got.Sidedness != SidesAlternate || got.TotalBlocks() != 397 {
+		t.Errorf("ParseDiskSpec(80,2,10,512) = %+v", got)
+	}
+
+	for _, text := range []string{"", "pcw", "80,2", "40,3,9,512", "40,1,9,500", "40,1,9,512,1,1024,200"} {
+		if _, err := ParseDiskSpec(text); err == nil {
+			t.Errorf("ParseDiskSpec(%q) succeeded", text)
+		}
+	}
+}
+
+// Sectors are formatted in the order of the interleave and skew, and are found
+// by their IDs when read, so files survive a save and reload.
+func TestInterleavedSectors(t *testing.T) {
+	spec := SpecPlus3
+	spec.Interleave, spec.Skew = 2, 1
+	if got, want := spec.sectorOrder(0), []int{0, 5, 1, 6, 2, 7, 3, 8, 4}; !slices.Equal(got, want) {
+		t.Errorf("sectorOrder(0) = %v, want %v", got, want)
+	}
+	if got, want := spec.sectorOrder(1), []int{4, 0, 5, 1, 6, 2, 7, 3, 8}; !slices.Equal(got, want) {
+		t.Errorf("sectorOrder(1) = %v, want %v", got, want)
+	}
+
+	di := newSpecImage(t, spec)
+	ti, err := di.GetTrackInfo(1, 0)
+	if err != nil {
+		t.Fatalf("GetTrackInfo: %v", err)
+	}
+	if err := ti.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+	if ti.SectorInfo[1].SectorID != 1 {
+		t.Errorf("track 1 position 1 has ID %d, want 1", ti.SectorInfo[1].SectorID)
+	}
+
+	data := bytes.Repeat([]byte("interleave"), 900)
+	f, err := di.OpenFile("DATA.BIN", os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := di.Save(&buf); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	// The first directory sector (ID 1 of track 1) is at position 1.
+	if di.Tracks[1][256+512] == 0xE5 {
+		t.Error("directory not written to the sector with ID 1")
+	}
+	loaded, err := Load(&buf)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	r, err := loaded.OpenFile("DATA.BIN", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(got[:len(data)], data) {
+		t.Error("file contents differ after a reload")
+	}
+}
+
+// Rewriting a sector of an interleaved track clears the FDC status of that
+// sector, found by its ID, and of no other.
+func TestInterleavedStatusClear(t *testing.T) {
+	spec := SpecPlus3
+	spec.Interleave = 2
+	di := newSpecImage(t, spec)
+	td := di.Tracks[di.trackIndex(5, 0)]
+	for pos := 0; pos < spec.SectorsPerTrack; pos++ {
+		td[0x18+pos*8+4], td[0x18+pos*8+5] = 0x20, 0x20
+	}
+
+	// Logical sector 1 (ID 2) is at position 2; position 1 holds ID 6.
+	if err := di.SetSectorData(5, 1, 0, make([]byte, 512)); err != nil {
+		t.Fatalf("SetSectorData: %v", err)
+	}
+	ti, err := di.GetTrackInfo(5, 0)
+	if err != nil {
+		t.Fatalf("GetTrackInfo: %v", err)
+	}
+	for pos, si := range ti.SectorInfo {
+		cleared := si.Status1 == 0 && si.Status2 == 0
+		if cleared != (si.SectorID == 2) {
+			t.Errorf("position %d (ID %d): status %#x %#x", pos, si.SectorID, si.Status1, si.Status2)
+		}
+	}
+}
+
+// A PCW disk carries its disk specification even in the +3 layout, and a
+// bootable one is recognised on load by its boot sector checksum.
+func TestPCWBootRecord(t *testing.T) {
+	for _, spec := range []DiskSpec{SpecPCW180, SpecPCW720} {
+		di := newSpecImage(t, spec)
+		boot, err := di.GetSectorData(0, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(boot[:10], spec.Specification().Serialize()) {
+			t.Errorf("%s: boot record starts % x, want % x", spec.Name, boot[:10], spec.Specification().Serialize())
+		}
+
+		// Unbootable, the disk is indistinguishable from the +3 format.
+		if got := reload(t, di).Spec().Name; got != withPresetName(spec).Name {
+			t.Errorf("%s: unbootable disk loaded as %s", spec.Name, got)
+		}
+
+		var sum byte
+		for _, b := range boot[:len(boot)-1] {
+			sum += b
+		}
+		boot[len(boot)-1] = spec.BootChecksum() - sum
+		if err := di.SetSectorData(0, 0, 0, boot); err != nil {
+			t.Fatal(err)
+		}
+		loaded := reload(t, di)
+		if loaded.Spec() != spec {
+			t.Errorf("bootable %s disk loaded as %s", spec.Name, loaded.Spec().Name)
+		}
+		if err := loaded.ValidateBootSector(); err != nil {
+			t.Errorf("%s: ValidateBootSector: %v", spec.Name, err)
+		}
+	}
+	if s, err := ParseDiskSpec("PCW720"); err != nil || s != SpecPCW720 {
+		t.Errorf("ParseDiskSpec(PCW720) = %v, %v", s.Name, err)
+	}
+}
+
+// reload saves a disk image and loads it again.
+func reload(t *testing.T, di *DiskImage) *DiskImage {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := di.Save(&buf); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	loaded, err := Load(&buf)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return loaded
+}
+
+// SpaceInfo divides the whole disk, counting files in whole blocks.
+func TestSpaceInfo(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.writeRecords("SMALL.TXT", []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	si := di.SpaceInfo()
+	if si.Total.Bytes != 184320 || si.Directory.Blocks != 2 || si.Used.Blocks != 1 || si.Free.Blocks != 172 {
+		t.Errorf("SpaceInfo = %+v", si)
+	}
+	if sum := si.Reserved.Bytes + si.Directory.Bytes + si.Used.Bytes + si.Free.Bytes; sum != si.Total.Bytes {
+		t.Errorf("parts add up to %d, want %d", sum, si.Total.Bytes)
+	}
+	if si.Reserved.Bytes != 9*512+512 { // the system track, and the sector past the last block
+		t.Errorf("reserved = %d bytes", si.Reserved.Bytes)
+	}
+}
+
+// The directory holds as many entries as its blocks do, however many that is,
+// from a new disk through a save and reload.
+func TestDirectoryCapacity(t *testing.T) {
+	for _, tc := range []struct {
+		spec    DiskSpec
+		entries int
+	}{
+		{SpecPlus3, 64},
+		{SpecCPCData, 64},
+		{SpecPlus3DS, 256},
+	} {
+		di := newSpecImage(t, tc.spec)
+		if n := tc.spec.DirEntries(); n != tc.entries {
+			t.Errorf("%s: DirEntries = %d, want %d", tc.spec.Name, n, tc.entries)
+		}
+		for i := 0; i < tc.entries; i++ {
+			if err := di.writeRecords(fmt.Sprintf("F%d.TXT", i), nil); err != nil {
+				t.Fatalf("%s: file %d: %v", tc.spec.Name, i, err)
+			}
+		}
+		if err := di.writeRecords("ONEMORE.TXT", nil); !errors.Is(err, ErrDirectoryFull) {
+			t.Errorf("%s: entry %d: err = %v, want ErrDirectoryFull", tc.spec.Name, tc.entries+1, err)
+		}
+		si := reload(t, di).SpaceInfo()
+		if si.Entries != tc.entries || si.FreeEntries != 0 {
+			t.Errorf("%s: reloaded with %d entries, %d free", tc.spec.Name, si.Entries, si.FreeEntries)
+		}
+	}
+
+	spec := SpecPlus3
+	spec.DirBlocks = 17
+	if err := spec.Validate(); !errors.Is(err, ErrInvalidSpec) {
+		t.Errorf("17 directory blocks: err = %v, want ErrInvalidSpec", err)
+	}
+}
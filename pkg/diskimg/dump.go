@@ -0,0 +1,321 @@
+// file: pkg/diskimg/dump.go
+
+package diskimg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// ImageDump is the whole of a disk image as Dump describes it, for archiving
+// and diffing as JSON. Byte slices encode as base64.
+type ImageDump struct {
+	Container string      `json:"container"` // "standard" or "extended"
+	Creator   string      `json:"creator"`
+	Tracks    int         `json:"tracks"` // per side
+	Sides     int         `json:"sides"`
+	Format    string      `json:"format"`      // the format's name, as "+3"
+	Header    []byte      `json:"disk_header"` // the disc information block, as Save writes it
+	TrackData []TrackDump `json:"track_data"`
+	Directory []EntryDump `json:"directory"`
+	Files     []FileDump  `json:"files"`
+	Findings  []Finding   `json:"findings"`
+}
+
+// TrackDump is one track of an ImageDump: its track information block and
+// sectors, in the order they are on the track.
+type TrackDump struct {
+	Track      int          `json:"track"`
+	Side       int          `json:"side"`
+	Absent     bool         `json:"absent,omitempty"` // unformatted, and left out of the image
+	SectorSize int          `json:"sector_size"`      // the size code: 128 << SectorSize bytes
+	Gap        int          `json:"gap"`
+	Filler     int          `json:"filler"`
+	Sectors    []SectorDump `json:"sectors"`
+	Info       []byte       `json:"info,omitempty"`     // the raw track information block
+	Trailing   []byte       `json:"trailing,omitempty"` // bytes after the last sector's data
+}
+
+// SectorDump is one sector of a TrackDump: its ID, FDC status and data. The
+// data of a weak sector holds every copy, one after another; that of a track
+// cut short holds what there is.
+type SectorDump struct {
+	Track   int    `json:"c"`
+	Side    int    `json:"h"`
+	ID      int    `json:"r"`
+	Size    int    `json:"n"`
+	Status1 int    `json:"st1"`
+	Status2 int    `json:"st2"`
+	Data    []byte `json:"data"`
+}
+
+// EntryDump is one directory entry of an ImageDump, decoded and raw. Name,
+// Extent, Records and Blocks are left out of an unused entry.
+type EntryDump struct {
+	Index   int    `json:"index"`
+	Status  int    `json:"status"` // user 0-15, 0x20 label, 0x21 datestamps, 0xE5 unused
+	Name    string `json:"name,omitempty"`
+	Extent  int    `json:"extent,omitempty"`
+	Records int    `json:"records,omitempty"`
+	Blocks  []int  `json:"blocks,omitempty"`
+	Raw     []byte `json:"raw"`
+}
+
+// FileDump is one file of an ImageDump, as StatFile describes it.
+type FileDump struct {
+	Name       string      `json:"name"`
+	User       int         `json:"user"`
+	Size       int64       `json:"size"`
+	Records    int         `json:"records"`
+	Extents    int         `json:"extents"`
+	Blocks     []int       `json:"blocks"`
+	Attributes []string    `json:"attributes,omitempty"`
+	Header     *HeaderDump `json:"header,omitempty"`
+	Error      string      `json:"error,omitempty"` // why the file cannot be read, if it cannot
+}
+
+// HeaderDump is the PLUS3DOS header of a FileDump, decoded and raw. A
+// header that fails validation is dumped too, with Valid false.
+type HeaderDump struct {
+	Type          int    `json:"type"`
+	Length        int    `json:"length"`
+	Param1        int    `json:"param1"`
+	Param2        int    `json:"param2"`
+	FileLength    int64  `json:"file_length"`
+	Issue         int    `json:"issue"`
+	Version       int    `json:"version"`
+	ChecksumValid bool   `json:"checksum_valid"`
+	Valid         bool   `json:"valid"`
+	Raw           []byte `json:"raw"`
+}
+
+// Dump returns the whole structure of the disk: the container's header, every
+// track's metadata and sector data, the directory, the files and their
+// headers, and the findings of Check, errors concealed by a salvage load among
+// them. A track that cannot be read is dumped as absent, and a file that
+// cannot be read with its error, both reported among the findings too.
+func (di *DiskImage) Dump() (*ImageDump, error) {
+	d := &ImageDump{
+		Container: di.Container().String(),
+		Creator:   strings.TrimRight(string(di.Header.Creator[:]), "\x00 "),
+		Tracks:    int(di.Header.TracksNum),
+		Sides:     int(di.Header.SidesNum),
+		Format:    di.spec.Name,
+	}
+	dib, _, err := di.containerLayout(di.Container())
+	if err != nil {
+		return nil, err
+	}
+	d.Header = dib
+
+	sides := max(d.Sides, 1)
+	for idx := range di.Tracks {
+		td := TrackDump{Track: idx / sides, Side: idx % sides, Sectors: []SectorDump{}}
+		block, err := di.track(idx)
+		ti, perr := parseTrackInfo(block)
+		if err != nil || block == nil || perr != nil {
+			td.Absent = true
+			d.TrackData = append(d.TrackData, td)
+			continue
+		}
+		td.SectorSize, td.Gap, td.Filler = int(ti.SectorSize), int(ti.GapLength), int(ti.FillerByte)
+		td.Info = block[:256]
+		end := 256
+		for n, si := range ti.SectorInfo {
+			off, size := ti.sectorOffset(n)
+			end = min(off+size, len(block))
+			td.Sectors = append(td.Sectors, SectorDump{
+				Track:   int(si.Track),
+				Side:    int(si.Side),
+				ID:      int(si.SectorID),
+				Size:    int(si.Size),
+				Status1: int(si.Status1),
+				Status2: int(si.Status2),
+				Data:    block[min(off, end):end],
+			})
+		}
+		if end < len(block) {
+			td.Trailing = block[end:]
+		}
+		d.TrackData = append(d.TrackData, td)
+	}
+
+	wide := di.spec.WideBlockPointers()
+	for i := range di.directory.Entries {
+		e := &di.directory.Entries[i]
+		var raw bytes.Buffer
+		binary.Write(&raw, binary.LittleEndian, e)
+		ed := EntryDump{Index: i, Status: int(e.Status), Raw: raw.Bytes()}
+		if !e.IsUnused() {
+			ed.Name = e.GetFilename()
+			ed.Extent = e.extentNumber()
+			ed.Records = int(e.RecordCount)
+			if e.IsFile() {
+				ed.Blocks = e.blockPointers(wide)
+			}
+		}
+		d.Directory = append(d.Directory, ed)
+	}
+
+	for e := range di.Files(&FilesOptions{System: true}) {
+		fi, err := di.StatFile(e.GetFilename())
+		if err != nil {
+			d.Files = append(d.Files, FileDump{Name: e.GetFilename(), User: int(e.Status), Error: err.Error()})
+			continue
+		}
+		fd := FileDump{
+			Name:    fi.Name,
+			User:    fi.User,
+			Size:    fi.Size,
+			Records: fi.Records,
+			Extents: fi.Extents,
+			Blocks:  fi.Blocks,
+		}
+		if fi.Attributes.ReadOnly {
+			fd.Attributes = append(fd.Attributes, "read-only")
+		}
+		if fi.Attributes.System {
+			fd.Attributes = append(fd.Attributes, "system")
+		}
+		if fi.Attributes.Archived {
+			fd.Attributes = append(fd.Attributes, "archived")
+		}
+		if h, err := di.ReadRawHeader(fi.Name); err == nil && h != nil {
+			fileType, length, param1, param2 := h.GetBasicHeader()
+			fd.Header = &HeaderDump{
+				Type:          int(fileType),
+				Length:        int(length),
+				Param1:        int(param1),
+				Param2:        int(param2),
+				FileLength:    int64(h.FileLength),
+				Issue:         int(h.Issue),
+				Version:       int(h.Version),
+				ChecksumValid: h.ChecksumValid(),
+				Valid:         h.Validate() == nil,
+				Raw:           h.toBytes(),
+			}
+		}
+		d.Files = append(d.Files, fd)
+	}
+
+	d.Findings = append([]Finding{}, di.Check().Findings...)
+	return d, nil
+}
+
+// LoadDump rebuilds a disk image from a dump, undoing Dump: saved, it is the
+// image the dump was made from, byte for byte. The dump may have been edited.
+// The container, creator, geometry, tracks and sectors come from their
+// decoded fields, over Info where a track has one, and a directory entry
+// whose raw bytes differ from what its sector holds replaces it. The disk
+// header, the files and the findings are derived, and ignored.
+func LoadDump(d *ImageDump, opts *LoadOptions) (*DiskImage, error) {
+	var c Container
+	switch d.Container {
+	case "standard", "":
+	case "extended":
+		c = ContainerExtended
+	default:
+		return nil, fmt.Errorf("%w: unknown container %q", ErrCorruptImage, d.Container)
+	}
+	sides := max(d.Sides, 1)
+	if d.Tracks*sides != len(d.TrackData) || len(d.TrackData) > 256-0x34 {
+		return nil, fmt.Errorf("%w: %d tracks of %d sides, but %d in the dump", ErrCorruptImage, d.Tracks, d.Sides, len(d.TrackData))
+	}
+
+	// Load an extended container of the tracks, which records the size of
+	// each, and set the dump's container after.
+	image := make([]byte, 256)
+	copy(image, ContainerExtended.signature())
+	copy(image[0x22:0x30], d.Creator)
+	image[0x30], image[0x31] = byte(d.Tracks), byte(d.Sides)
+	for i, td := range d.TrackData {
+		if td.Absent {
+			continue
+		}
+		block, err := td.block()
+		if err != nil {
+			return nil, fmt.Errorf("track %d side %d: %w", td.Track, td.Side, err)
+		}
+		block = append(block, make([]byte, (256-len(block)%256)%256)...)
+		if len(block) > 0xFF00 {
+			return nil, fmt.Errorf("%w: track %d side %d is %d bytes", ErrUnsupported, td.Track, td.Side, len(block))
+		}
+		image[0x34+i] = byte(len(block) / 256)
+		image = append(image, block...)
+	}
+	di, err := LoadWithOptions(bytes.NewReader(image), opts)
+	if err != nil {
+		return nil, err
+	}
+	di.SetContainer(c)
+
+	changed := false
+	for _, ed := range d.Directory {
+		if ed.Index < 0 || ed.Index >= len(di.directory.Entries) || len(ed.Raw) != 32 {
+			continue
+		}
+		e := &di.directory.Entries[ed.Index]
+		var raw bytes.Buffer
+		binary.Write(&raw, binary.LittleEndian, e)
+		if !bytes.Equal(raw.Bytes(), ed.Raw) {
+			binary.Read(bytes.NewReader(ed.Raw), binary.LittleEndian, e)
+			changed = true
+		}
+	}
+	if changed {
+		di.directory.Reindex()
+		if err := di.FlushDirectory(); err != nil {
+			return nil, err
+		}
+		di.fileAlloc.rebuild(di.directory.Entries)
+	}
+	di.Modified = false
+	return di, nil
+}
+
+// block returns the track block of td: its information block, from Info
+// with the decoded fields set over it, then the sectors' data.
+func (td *TrackDump) block() ([]byte, error) {
+	if len(td.Sectors) > 29 { // the sector information list fills the block
+		return nil, ErrInvalidSectorCount
+	}
+	block := make([]byte, 256)
+	if len(td.Info) == 256 {
+		copy(block, td.Info)
+	} else {
+		copy(block, "Track-Info\r\n")
+		block[0x10], block[0x11] = byte(td.Track), byte(td.Side)
+	}
+	block[0x14], block[0x15] = byte(td.SectorSize), byte(len(td.Sectors))
+	block[0x16], block[0x17] = byte(td.Gap), byte(td.Filler)
+	clear(block[0x18+8*len(td.Sectors):])
+	for i, s := range td.Sectors {
+		si := block[0x18+8*i:]
+		// Keep the data length the information block records if the data
+		// fits it: exactly, or short at the end of a track cut short.
+		recorded := SectorInfo{Size: si[3], ActualSize: binary.LittleEndian.Uint16(si[6:])}.DataSize()
+		fits := len(s.Data) == recorded || len(s.Data) < recorded && td.emptyAfter(i)
+		if len(td.Info) != 256 || s.Size != int(si[3]) || !fits {
+			binary.LittleEndian.PutUint16(si[6:], uint16(len(s.Data)))
+		}
+		si[0], si[1], si[2], si[3] = byte(s.Track), byte(s.Side), byte(s.ID), byte(s.Size)
+		si[4], si[5] = byte(s.Status1), byte(s.Status2)
+	}
+	for _, s := range td.Sectors {
+		block = append(block, s.Data...)
+	}
+	return append(block, td.Trailing...), nil
+}
+
+// emptyAfter reports whether the sectors after the i-th have no data, as on a
+// track cut short.
+func (td *TrackDump) emptyAfter(i int) bool {
+	for _, s := range td.Sectors[i+1:] {
+		if len(s.Data) > 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,173 @@
+package diskimg
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.ImportData("GAME.BIN", []byte("code"), &ImportOptions{AddHeader: true, FileType: FileTypeCode, LoadAddr: 0x8000}); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.WriteFile("RAW.DAT", []byte("raw")); err != nil {
+		t.Fatal(err)
+	}
+	d, err := di.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Container != "standard" || d.Tracks != 40 || d.Sides != 1 || d.Format != SpecPlus3.Name || len(d.Header) != 256 {
+		t.Errorf("container %q, %d tracks, %d sides, format %q, header %d bytes", d.Container, d.Tracks, d.Sides, d.Format, len(d.Header))
+	}
+	if len(d.TrackData) != 40 || len(d.TrackData[0].Sectors) != 9 {
+		t.Fatalf("%d tracks, %d sectors on track 0", len(d.TrackData), len(d.TrackData[0].Sectors))
+	}
+	boot, _ := di.GetSectorData(0, 0, 0)
+	if s := d.TrackData[0].Sectors[0]; s.ID != 1 || s.Size != 2 || !bytes.Equal(s.Data, boot) {
+		t.Errorf("first sector: ID %d, size %d, data matches %v", s.ID, s.Size, bytes.Equal(s.Data, boot))
+	}
+	if len(d.Directory) != SpecPlus3.DirEntries() || d.Directory[0].Name != "GAME.BIN" || len(d.Directory[0].Raw) != 32 {
+		t.Errorf("directory: %d entries, first %+v", len(d.Directory), d.Directory[0])
+	}
+
+	if len(d.Files) != 2 {
+		t.Fatalf("%d files, want 2", len(d.Files))
+	}
+	h := d.Files[0].Header
+	if h == nil || h.Type != FileTypeCode || h.Length != 4 || h.Param1 != 0x8000 || !h.Valid || len(h.Raw) != HeaderSize {
+		t.Errorf("GAME.BIN header %+v", h)
+	}
+	if d.Files[1].Header != nil {
+		t.Errorf("RAW.DAT has a header: %+v", d.Files[1].Header)
+	}
+	if len(d.Findings) != 0 {
+		t.Errorf("findings on a good disk: %v", d.Findings)
+	}
+
+	// The dump survives JSON, the data as base64.
+	b, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back ImageDump
+	if err := json.Unmarshal(b, &back); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(back.TrackData[0].Sectors[0].Data, boot) || back.Files[0].Header.Param1 != 0x8000 {
+		t.Error("the dump changed through JSON")
+	}
+}
+
+// A damaged directory entry is among the findings, which survive JSON too.
+func TestDumpFindings(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.WriteFile("GAME.BIN", []byte("code")); err != nil {
+		t.Fatal(err)
+	}
+	di.directory.Entries[0].AllocationBlocks[1] = 0xFF // past the end of the disk
+	d, err := di.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Findings) == 0 {
+		t.Fatal("no findings for a block past the end of the disk")
+	}
+	if len(d.Files) != 1 || d.Files[0].Error == "" {
+		t.Errorf("files %+v, want GAME.BIN with an error", d.Files)
+	}
+	b, _ := json.Marshal(d)
+	var back ImageDump
+	if err := json.Unmarshal(b, &back); err != nil {
+		t.Fatal(err)
+	}
+	if back.Findings[0].Severity != d.Findings[0].Severity || back.Findings[0].Code != d.Findings[0].Code {
+		t.Errorf("finding %+v through JSON is %+v", d.Findings[0], back.Findings[0])
+	}
+}
+
+// roundTrip returns the image LoadDump rebuilds from the dump of di, through
+// JSON.
+func roundTrip(t *testing.T, di *DiskImage) *DiskImage {
+	t.Helper()
+	d, err := di.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back ImageDump
+	if err := json.Unmarshal(b, &back); err != nil {
+		t.Fatal(err)
+	}
+	rebuilt, err := LoadDump(&back, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rebuilt
+}
+
+func saved(t *testing.T, di *DiskImage) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := di.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestLoadDump(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.ImportData("GAME.BIN", bytes.Repeat([]byte("code"), 700), &ImportOptions{AddHeader: true, FileType: FileTypeCode, LoadAddr: 0x8000}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := saved(t, roundTrip(t, di)), saved(t, di); !bytes.Equal(got, want) {
+		t.Error("standard image changed through the dump")
+	}
+
+	// An extended image with an absent track, a weak sector and odd status.
+	di.SetContainer(ContainerExtended)
+	di.Tracks[39] = nil
+	td := di.Tracks[20]
+	td[0x18+6], td[0x18+7] = 0x00, 0x06 // three copies of sector 1
+	td[0x18+5] = 0x20
+	di.Tracks[20] = append(td[:256+512], append(bytes.Repeat([]byte{0xAA}, 1024), td[256+512:]...)...)
+	if got, want := saved(t, roundTrip(t, di)), saved(t, di); !bytes.Equal(got, want) {
+		t.Error("extended image changed through the dump")
+	}
+}
+
+// Edits to a sector's data and to a directory entry are kept.
+func TestLoadDumpEdited(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.WriteFile("GAME.BIN", []byte("code")); err != nil {
+		t.Fatal(err)
+	}
+	d, err := di.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := d.Directory[0].Raw
+	copy(raw[1:9], "PLAY    ")
+	s := &d.TrackData[30].Sectors[4]
+	s.Data = bytes.Repeat([]byte{0x42}, len(s.Data))
+
+	rebuilt, err := LoadDump(d, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rebuilt.StatFile("PLAY.BIN"); err != nil {
+		t.Errorf("renamed entry: %v", err)
+	}
+	data, err := rebuilt.GetSectorData(30, 4, 0)
+	if err != nil || !bytes.Equal(data, s.Data) {
+		t.Errorf("edited sector: %x..., %v", data[:4], err)
+	}
+	if got, _ := fs.ReadFile(rebuilt, "PLAY.BIN"); string(got) != "code" {
+		t.Errorf("PLAY.BIN holds %q", got)
+	}
+}
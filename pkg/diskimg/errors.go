@@ -2,12 +2,18 @@
 
 package diskimg
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
+// Errors returned by the package, wrapped with the detail of what failed:
+// test for them with errors.Is.
 var (
 	ErrInvalidTrack          = errors.New("invalid track number")
 	ErrInvalidSide           = errors.New("invalid side number")
 	ErrInvalidSector         = errors.New("invalid sector number")
+	ErrInvalidBlock          = errors.New("invalid block number")
 	ErrInvalidSectorSize     = errors.New("invalid sector size")
 	ErrInvalidSectorCount    = errors.New("invalid sectors per track")
 	ErrInvalidTrackNum       = errors.New("track number mismatch")
@@ -21,4 +27,69 @@ var (
 	ErrFileExists            = errors.New("file already exists")
 	ErrInvalidHeader         = errors.New("invalid file header")
 	ErrInvalidChecksum       = errors.New("invalid checksum")
+	ErrCorruptImage          = errors.New("corrupt disk image")
+	ErrCheckFailed           = errors.New("disk check failed")
+	ErrTxDone                = errors.New("transaction already committed or rolled back")
+	ErrInvalidSpec           = errors.New("invalid disk specification")
+	ErrWrongFileType         = errors.New("wrong type of file")
+	ErrFileTooLarge          = errors.New("file too large")
+	ErrInvalidTape           = errors.New("invalid tape image")
+	ErrInvalidSnapshot       = errors.New("invalid snapshot")
+	ErrUnsupported           = errors.New("not supported")
 )
+
+// SectorError is an error reading or writing a sector, or loading a track,
+// of a disk image.
+type SectorError struct {
+	Op     string // "read", "write" or "load"
+	Track  int
+	Side   int
+	Sector int // logical sector number, -1 for the whole track
+	Err    error
+}
+
+func (e *SectorError) Error() string {
+	if e.Sector < 0 {
+		return fmt.Sprintf("%s track %d side %d: %v", e.Op, e.Track, e.Side, e.Err)
+	}
+	return fmt.Sprintf("%s track %d side %d sector %d: %v", e.Op, e.Track, e.Side, e.Sector, e.Err)
+}
+
+func (e *SectorError) Unwrap() error { return e.Err }
+
+// sectorError wraps err in a SectorError, unless it already is one.
+func sectorError(op string, track, side, sector int, err error) error {
+	var se *SectorError
+	if err == nil || errors.As(err, &se) {
+		return err
+	}
+	return &SectorError{Op: op, Track: track, Side: side, Sector: sector, Err: err}
+}
+
+// FileError is an error in an operation on a file on the disk (as opposed to
+// a host file, whose errors are *fs.PathError).
+type FileError struct {
+	Op   string // "open", "write", "delete", "rename", "import", "export", ...
+	Name string // the file on the disk
+	Err  error
+}
+
+func (e *FileError) Error() string { return e.Op + " " + e.Name + ": " + e.Err.Error() }
+
+func (e *FileError) Unwrap() error { return e.Err }
+
+// fileError wraps err in a FileError for op on the named file. A FileError
+// for the same file is relabelled with op; one for another file is kept.
+func fileError(op, name string, err error) error {
+	var fe *FileError
+	if err == nil {
+		return nil
+	}
+	if errors.As(err, &fe) {
+		if NormaliseFilename(fe.Name) != NormaliseFilename(name) {
+			return err
+		}
+		return &FileError{Op: op, Name: fe.Name, Err: fe.Err}
+	}
+	return &FileError{Op: op, Name: NormaliseFilename(name), Err: err}
+}
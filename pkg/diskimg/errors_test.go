@@ -0,0 +1,33 @@
+package diskimg
+
+import (
+	"errors"
+	"testing"
+)
+
+// Errors match the package's sentinels and carry where they happened.
+func TestErrorModel(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+
+	err := di.DeleteFile("nope.bin")
+	var fe *FileError
+	if !errors.As(err, &fe) || fe.Op != "delete" || fe.Name != "NOPE.BIN" || !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("DeleteFile of a missing file: %v", err)
+	}
+	if err := di.WriteFile("TOOLONGNAME.BIN", nil); !errors.Is(err, ErrInvalidFilename) || !errors.As(err, &fe) {
+		t.Errorf("WriteFile with a bad name: %v", err)
+	}
+
+	_, err = di.GetSectorData(2, 99, 0)
+	var se *SectorError
+	if !errors.As(err, &se) || se.Op != "read" || se.Track != 2 || se.Sector != 99 || !errors.Is(err, ErrInvalidSector) {
+		t.Errorf("GetSectorData of a missing sector: %v", err)
+	}
+
+	if err := di.ValidateFormat(); err != nil && !errors.Is(err, ErrCheckFailed) {
+		t.Errorf("ValidateFormat error %v does not match ErrCheckFailed", err)
+	}
+	if _, err := ParseDiskSpec("1,2"); !errors.Is(err, ErrInvalidSpec) {
+		t.Errorf("ParseDiskSpec: %v", err)
+	}
+}
@@ -3,14 +3,19 @@
 package diskimg
 
 import (
-	"errors"
 	"fmt"
 )
 
 const (
-	MaxBlocks      = 256 // Maximum number of blocks per file
-	BlocksPerDir   = 2   // Directory takes 2 blocks
-	ReservedBlocks = 1   // Boot sector block
+	BlocksPerDir = 2 // Directory takes 2 blocks on a standard +3 disk (see DiskSpec.DirBlocks)
+
+	// Deprecated: a file is limited only by the free space on the disk, its
+	// extents each taking a directory entry.
+	MaxBlocks = 256
+
+	// Deprecated: the reserved (boot) track precedes block 0, so no blocks are
+	// reserved for it; only the directory blocks are.
+	ReservedBlocks = 1
 )
 
 // FileAllocation handles file space allocation on disk
@@ -19,18 +24,20 @@ type FileAllocation struct {
 	allocation *SectorAllocation
 	blockMap   []int  // Maps block numbers to first sector number
 	freeBlocks []bool // Tracks which blocks are free
+	limit      int    // blocks from here on are reserved (see reserveBlocksFrom)
 }
 
 // newFileAllocation creates a new file allocation manager
 func newFileAllocation(disk *DiskImage) *FileAllocation {
-	sectorsPerBlock := BlockSize / BytesPerSector
-	totalBlocks := (disk.Header.TracksNum * disk.Header.SidesNum * uint8(SectorsPerTrack)) / uint8(sectorsPerBlock)
+	sectorsPerBlock := disk.spec.SectorsPerBlock()
+	totalBlocks := disk.spec.TotalBlocks()
 
 	fa := &FileAllocation{
 		disk:       disk,
 		allocation: disk.allocation,
 		blockMap:   make([]int, totalBlocks),
 		freeBlocks: make([]bool, totalBlocks),
+		limit:      totalBlocks,
 	}
 
 	// Initialize block map
@@ -39,24 +46,37 @@ func newFileAllocation(disk *DiskImage) *FileAllocation {
 		fa.freeBlocks[i] = true
 	}
 
-	// Mark system blocks as allocated
-	for i := 0; i < ReservedBlocks+BlocksPerDir; i++ {
+	// Mark the directory blocks as allocated, in the sector allocation too, as
+	// rebuild does.
+	for i := 0; i < disk.spec.DirBlocks; i++ {
 		fa.freeBlocks[i] = false
+		if fa.allocation != nil {
+			fa.allocation.AllocateSectors(fa.blockMap[i], sectorsPerBlock)
+		}
 	}
 
 	return fa
 }
 
+// reserveBlocksFrom keeps the blocks from n on out of use, for a disk whose
+// geometry holds more blocks than its file system may use.
+func (fa *FileAllocation) reserveBlocksFrom(n int) {
+	fa.limit = min(fa.limit, n)
+	for i := n; i < len(fa.freeBlocks); i++ {
+		fa.freeBlocks[i] = false
+	}
+}
+
 // AllocateFileSpace allocates blocks for a file
 func (fa *FileAllocation) AllocateFileSpace(size int) ([]int, error) {
-	blocksNeeded := (size + BlockSize - 1) / BlockSize
-	if blocksNeeded > MaxBlocks {
-		return nil, fmt.Errorf("file size exceeds maximum (%d blocks needed, max is %d)",
-			blocksNeeded, MaxBlocks)
+	blockSize := fa.disk.spec.BlockSize
+	blocksNeeded := (size + blockSize - 1) / blockSize
+	if blocksNeeded > fa.GetFreeBlocks() {
+		return nil, fmt.Errorf("%w: %d blocks needed, %d free", ErrDiskFull, blocksNeeded, fa.GetFreeBlocks())
 	}
 
 	blocks := make([]int, 0, blocksNeeded)
-	sectorsPerBlock := BlockSize / BytesPerSector
+	sectorsPerBlock := fa.disk.spec.SectorsPerBlock()
 
 	// Try to find contiguous blocks first
 	startBlock := fa.findContiguousBlocks(blocksNeeded)
@@ -83,7 +103,7 @@ func (fa *FileAllocation) AllocateFileSpace(size int) ([]int, error) {
 		block := fa.findFreeBlock()
 		if block < 0 {
 			fa.FreeBlocks(blocks) // Rollback
-			return nil, errors.New("no free blocks available")
+			return nil, ErrDiskFull
 		}
 
 		fa.freeBlocks[block] = false
@@ -99,13 +119,35 @@ func (fa *FileAllocation) AllocateFileSpace(size int) ([]int, error) {
 	return blocks, nil
 }
 
-// FreeBlocks releases allocated blocks
+// AllocateBlocks allocates the given blocks, which must be free.
+func (fa *FileAllocation) AllocateBlocks(blocks []int) error {
+	for _, block := range blocks {
+		if !fa.IsFree(block) {
+			return fmt.Errorf("%w: block %d is not free", ErrInvalidBlock, block)
+		}
+	}
+	sectorsPerBlock := fa.disk.spec.SectorsPerBlock()
+	for _, block := range blocks {
+		fa.freeBlocks[block] = false
+		if err := fa.allocation.AllocateSectors(fa.blockMap[block], sectorsPerBlock); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsFree reports whether a block is free for a file.
+func (fa *FileAllocation) IsFree(block int) bool {
+	return block >= 0 && block < len(fa.freeBlocks) && fa.freeBlocks[block]
+}
+
+// FreeBlocks releases allocated blocks. The directory blocks cannot be freed.
 func (fa *FileAllocation) FreeBlocks(blocks []int) error {
-	sectorsPerBlock := BlockSize / BytesPerSector
+	sectorsPerBlock := fa.disk.spec.SectorsPerBlock()
 
 	for _, block := range blocks {
-		if block >= len(fa.blockMap) {
-			return fmt.Errorf("invalid block number: %d", block)
+		if block < fa.disk.spec.DirBlocks || block >= len(fa.blockMap) {
+			return fmt.Errorf("%w: %d", ErrInvalidBlock, block)
 		}
 
 		fa.freeBlocks[block] = true
@@ -118,6 +160,27 @@ func (fa *FileAllocation) FreeBlocks(blocks []int) error {
 	return nil
 }
 
+// freeUnclaimed frees those of blocks that no file in entries claims,
+// skipping block numbers outside the data area.
+func (fa *FileAllocation) freeUnclaimed(blocks []int, entries []DirectoryEntry) {
+	claimed := make(map[int]bool)
+	for i := range entries {
+		if e := &entries[i]; e.IsFile() {
+			for _, b := range e.blockPointers(fa.disk.spec.WideBlockPointers()) {
+				claimed[b] = true
+			}
+		}
+	}
+	sectorsPerBlock := fa.disk.spec.SectorsPerBlock()
+	for _, b := range blocks {
+		if claimed[b] || b < fa.disk.spec.DirBlocks || b >= fa.limit {
+			continue
+		}
+		fa.freeBlocks[b] = true
+		fa.allocation.FreeSectors(fa.blockMap[b], sectorsPerBlock)
+	}
+}
+
 // findContiguousBlocks looks for a sequence of free blocks
 func (fa *FileAllocation) findContiguousBlocks(count int) int {
 	consecutive := 0
@@ -158,25 +221,44 @@ func (fa *FileAllocation) findFreeBlock() int {
 func (fa *FileAllocation) markUsedBlocks(entries []DirectoryEntry) {
 	for i := range entries {
 		e := &entries[i]
-		// Skip unused/deleted slots; only live files own blocks.
-		if e.IsUnused() || e.IsDeleted() {
+		// Skip unused/deleted slots, labels and datestamps; only live files
+		// own blocks.
+		if !e.IsFile() {
 			continue
 		}
-		for _, b := range e.AllocationBlocks {
-			// Block 0 is unused as a padding marker in the Al list (the data
-			// area never allocates the reserved/directory blocks to a file),
-			// so a zero entry means "no block here".
-			block := int(b)
-			if block == 0 {
-				continue
-			}
-			if block >= 0 && block < len(fa.freeBlocks) {
+		// Block 0 is unused as a padding marker in the Al list (the data area
+		// never allocates the directory blocks to a file), so blockPointers
+		// leaves zero entries out.
+		for _, block := range e.blockPointers(fa.disk.spec.WideBlockPointers()) {
+			if block < len(fa.freeBlocks) {
 				fa.freeBlocks[block] = false
 			}
 		}
 	}
 }
 
+// rebuild recomputes the free blocks from the directory entries alone, and
+// the sector allocation from the blocks in use.
+func (fa *FileAllocation) rebuild(entries []DirectoryEntry) {
+	for i := range fa.freeBlocks {
+		fa.freeBlocks[i] = i >= fa.disk.spec.DirBlocks && i < fa.limit
+	}
+	fa.markUsedBlocks(entries)
+
+	sa := fa.allocation
+	sa.ResetAllocation()
+	sectorsPerBlock := fa.disk.spec.SectorsPerBlock()
+	for block, free := range fa.freeBlocks {
+		if free {
+			continue
+		}
+		first := fa.blockMap[block]
+		for s := first; s < first+sectorsPerBlock && s < len(sa.allocated); s++ {
+			sa.allocated[s] = true
+		}
+	}
+}
+
 // GetFreeBlocks returns number of free blocks
 func (fa *FileAllocation) GetFreeBlocks() int {
 	count := 0
@@ -195,7 +277,7 @@ func (fa *FileAllocation) DefragmentFile(oldBlocks []int) ([]int, error) {
 	}
 
 	// Calculate total size
-	totalSize := len(oldBlocks) * BlockSize
+	totalSize := len(oldBlocks) * fa.disk.spec.BlockSize
 
 	// Try to find contiguous space
 	newBlocks, err := fa.AllocateFileSpace(totalSize)
@@ -204,32 +286,25 @@ func (fa *FileAllocation) DefragmentFile(oldBlocks []int) ([]int, error) {
 	}
 
 	// Copy blocks to new location
-	sectorsPerBlock := BlockSize / BytesPerSector
-
+	spec := fa.disk.spec
+	buf := getSectorBuffer(spec.SectorSize)
+	defer putSectorBuffer(buf)
 	for i, oldBlock := range oldBlocks {
 		newBlock := newBlocks[i]
 
 		// Copy each sector in the block
-		for s := 0; s < sectorsPerBlock; s++ {
-			oldSector := fa.blockMap[oldBlock] + s
-			newSector := fa.blockMap[newBlock] + s
-
+		for s := 0; s < spec.SectorsPerBlock(); s++ {
 			// Read old sector
-			data, err := fa.disk.GetSectorData(
-				oldSector/SectorsPerTrack,
-				oldSector%SectorsPerTrack,
-				0)
+			track, sector, side := spec.BlockSector(oldBlock, s)
+			n, err := fa.disk.ReadSectorInto(*buf, track, sector, side)
 			if err != nil {
 				fa.FreeBlocks(newBlocks) // Rollback
 				return nil, err
 			}
 
 			// Write to new sector
-			err = fa.disk.SetSectorData(
-				newSector/SectorsPerTrack,
-				newSector%SectorsPerTrack,
-				0,
-				data)
+			track, sector, side = spec.BlockSector(newBlock, s)
+			err = fa.disk.SetSectorData(track, sector, side, (*buf)[:n])
 			if err != nil {
 				fa.FreeBlocks(newBlocks) // Rollback
 				return nil, err
@@ -81,46 +81,32 @@ func (fa *FileAttributes) SetNameAttributes(attrs [8]byte) {
 	fa.UserF4 = (attrs[3] & AttrUserF4) != 0
 }
 
-// ApplyToDirectoryEntry applies attributes to a directory entry
+// ApplyToDirectoryEntry applies attributes to a directory entry: t1-t3 are
+// the high bits of the extension bytes, f1-f4 those of the first four name
+// bytes. The high bits of name bytes f5-f8 are left as they are.
 func (fa *FileAttributes) ApplyToDirectoryEntry(entry *DirectoryEntry) {
-	// Apply type attributes to extension bytes
-	typeAttrs := fa.GetTypeAttributes()
-	for i := range entry.Extension {
-		if (typeAttrs & (1 << uint(i))) != 0 {
-			entry.Extension[i] |= 0x80 // Set high bit
-		} else {
-			entry.Extension[i] &= 0x7F // Clear high bit
+	setHigh := func(b *byte, on bool) {
+		*b &= 0x7F
+		if on {
+			*b |= 0x80
 		}
 	}
-
-	// Apply name attributes
-	nameAttrs := fa.GetNameAttributes()
-	for i := range entry.Name {
-		if (nameAttrs[i] & 0x80) != 0 {
-			entry.Name[i] |= 0x80 // Set high bit
-		} else {
-			entry.Name[i] &= 0x7F // Clear high bit
-		}
+	for i, on := range []bool{fa.ReadOnly, fa.System, fa.Archived} {
+		setHigh(&entry.Extension[i], on)
+	}
+	for i, on := range []bool{fa.UserF1, fa.UserF2, fa.UserF3, fa.UserF4} {
+		setHigh(&entry.Name[i], on)
 	}
 }
 
-// ReadFromDirectoryEntry extracts attributes from a directory entry
+// ReadFromDirectoryEntry extracts attributes from a directory entry (see
+// ApplyToDirectoryEntry).
 func (fa *FileAttributes) ReadFromDirectoryEntry(entry *DirectoryEntry) {
-	// Extract type attributes from extension bytes
-	var typeAttrs byte
-	for i := range entry.Extension {
-		if (entry.Extension[i] & 0x80) != 0 {
-			typeAttrs |= 1 << uint(i)
-		}
-	}
-	fa.SetTypeAttributes(typeAttrs)
-
-	// Extract name attributes
-	var nameAttrs [8]byte
-	for i := range entry.Name {
-		if (entry.Name[i] & 0x80) != 0 {
-			nameAttrs[i] = 0x80
-		}
-	}
-	fa.SetNameAttributes(nameAttrs)
+	fa.ReadOnly = entry.Extension[0]&0x80 != 0
+	fa.System = entry.Extension[1]&0x80 != 0
+	fa.Archived = entry.Extension[2]&0x80 != 0
+	fa.UserF1 = entry.Name[0]&0x80 != 0
+	fa.UserF2 = entry.Name[1]&0x80 != 0
+	fa.UserF3 = entry.Name[2]&0x80 != 0
+	fa.UserF4 = entry.Name[3]&0x80 != 0
 }
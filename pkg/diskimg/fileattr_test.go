@@ -0,0 +1,32 @@
+package diskimg
+
+import "testing"
+
+// SetAttributes stores every attribute in each of a file's entries, and they
+// survive a reload.
+func TestSetAttributes(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.writeRecords("DATA.BIN", make([]byte, 20000)); err != nil {
+		t.Fatal(err)
+	}
+	want := FileAttributes{ReadOnly: true, Archived: true, UserF1: true, UserF4: true}
+	if err := di.SetAttributes("data.bin", want); err != nil {
+		t.Fatal(err)
+	}
+
+	di = reload(t, di)
+	first, err := di.directory.FindFile("DATA.BIN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, e := range di.directory.fileExtents(first) {
+		var got FileAttributes
+		got.ReadFromDirectoryEntry(e)
+		if got != want {
+			t.Errorf("extent %d attributes = %+v, want %+v", i, got, want)
+		}
+	}
+	if err := di.SetAttributes("NONE.BIN", want); err == nil {
+		t.Error("SetAttributes of a missing file succeeded")
+	}
+}
@@ -5,7 +5,6 @@ package diskimg
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
 	"fmt"
 )
 
@@ -50,7 +49,7 @@ func NewPlus3DosHeader() *Plus3DosHeader {
 // SetBasicHeader sets the BASIC-specific header data
 func (h *Plus3DosHeader) SetBasicHeader(fileType byte, length uint16, param1, param2 uint16) error {
 	if fileType > FileTypeCode {
-		return fmt.Errorf("invalid file type: %d", fileType)
+		return fmt.Errorf("%w: file type %d", ErrInvalidHeader, fileType)
 	}
 
 	h.HeaderData[0] = fileType
@@ -99,31 +98,31 @@ func (h *Plus3DosHeader) GetBasicHeader() (fileType byte, length uint16, param1,
 func (h *Plus3DosHeader) Validate() error {
 	// Check signature
 	if !bytes.Equal(h.Signature[:], []byte(HeaderSignature)) {
-		return errors.New("invalid PLUS3DOS header signature")
+		return fmt.Errorf("%w: bad PLUS3DOS signature", ErrInvalidHeader)
 	}
 
 	// Check soft-EOF
 	if h.SoftEOF != HeaderSoftEOF {
-		return errors.New("invalid soft-EOF marker")
+		return fmt.Errorf("%w: bad soft-EOF marker", ErrInvalidHeader)
 	}
 
 	// Check version compatibility
 	if h.Issue != HeaderIssue {
-		return fmt.Errorf("incompatible header issue number: %d", h.Issue)
+		return fmt.Errorf("%w: incompatible issue number %d", ErrInvalidHeader, h.Issue)
 	}
 	if h.Version > HeaderVersion {
-		return fmt.Errorf("incompatible header version: %d", h.Version)
+		return fmt.Errorf("%w: incompatible version %d", ErrInvalidHeader, h.Version)
 	}
 
 	// Validate file type
 	fileType := h.HeaderData[0]
 	if fileType > FileTypeCode {
-		return fmt.Errorf("invalid file type: %d", fileType)
+		return fmt.Errorf("%w: file type %d", ErrInvalidHeader, fileType)
 	}
 
 	// Verify checksum
 	if !h.verifyChecksum() {
-		return errors.New("header checksum verification failed")
+		return fmt.Errorf("%w: %w", ErrInvalidHeader, ErrInvalidChecksum)
 	}
 
 	return nil
@@ -159,7 +158,7 @@ func (h *Plus3DosHeader) toBytes() []byte {
 // FromBytes populates the header from a byte slice
 func (h *Plus3DosHeader) FromBytes(data []byte) error {
 	if len(data) < HeaderSize {
-		return errors.New("data too short for header")
+		return fmt.Errorf("%w: data too short for a header", ErrInvalidHeader)
 	}
 
 	buf := bytes.NewReader(data)
@@ -197,7 +196,7 @@ func (h *Plus3DosHeader) String() string {
 		info += fmt.Sprintf(", Program length: %d", param2)
 	case FileTypeNumericArray, FileTypeCharArray:
 		if param1 != 0 {
-			info += fmt.Sprintf(", Variable: %c", param1)
+			info += fmt.Sprintf(", Variable: %s()", arrayName(fileType, byte(param1)))
 		}
 	case FileTypeCode:
 		info += fmt.Sprintf(", Load address: %d", param1)
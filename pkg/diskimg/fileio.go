@@ -3,32 +3,72 @@
 package diskimg
 
 import (
-	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"os"
 	"strings"
+	"time"
 )
 
+// errWriteOnly is returned by reads of a file opened with os.O_WRONLY.
+var errWriteOnly = fmt.Errorf("%w: file not open for reading", fs.ErrPermission)
+
 // File represents an open file on the disk image
 type File struct {
 	disk       *DiskImage
-	entry      *DirectoryEntry
+	entry      *DirectoryEntry   // the first extent
+	extents    []*DirectoryEntry // every extent, in order
 	header     *Plus3DosHeader
 	blocks     []int
 	position   int64
 	size       int64
-	readOnly   bool
+	readOnly   bool // opened with os.O_RDONLY
+	writeOnly  bool // opened with os.O_WRONLY
+	append     bool // opened with os.O_APPEND
 	isHeadered bool
+	sparse     bool      // writes past the end leave holes (see SetSparse)
+	written    bool      // data was written; Close updates the entries
+	created    bool      // the file was created by opening it
+	modTime    time.Time // the modification stamp Close sets, if not now
 }
 
-// OpenFile opens or creates a file on the disk image
-func (di *DiskImage) OpenFile(filename string, createNew bool) (*File, error) {
-	fileEntry, err := di.directory.FindFile(filename)
-	if err != nil && !createNew {
-		return nil, err
+// OpenFile opens a file on the disk image. flag takes the os.OpenFile flags:
+// exactly one of os.O_RDONLY, os.O_WRONLY or os.O_RDWR, optionally ORed with
+// os.O_CREATE (create the file if it does not exist), os.O_EXCL (with
+// O_CREATE, fail if it does), os.O_TRUNC (free the file's blocks on opening
+// it for writing) and os.O_APPEND (write at the end of the file). A file with
+// the read-only attribute cannot be opened for writing. The file is positioned
+// at its start, PLUS3DOS header included.
+func (di *DiskImage) OpenFile(filename string, flag int) (*File, error) {
+	f, err := di.openFile(filename, flag)
+	if err != nil {
+		return nil, fileError("open", filename, err)
 	}
+	return f, nil
+}
 
-	if err != nil && createNew {
+func (di *DiskImage) openFile(filename string, flag int) (*File, error) {
+	access := flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR)
+	fileEntry, err := di.directory.FindFile(filename)
+	if err != nil && flag&os.O_CREATE != 0 {
+		// A new file's name must be valid, or is made so.
+		if filename, err = di.diskName(filename); err != nil {
+			return nil, err
+		}
+		fileEntry, err = di.directory.FindFile(filename)
+	}
+	existed := err == nil
+	switch {
+	case err != nil && flag&os.O_CREATE == 0:
+		return nil, err
+	case err == nil && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, ErrFileExists
+	case err == nil && access != os.O_RDONLY:
+		if readOnly, _, _ := fileEntry.GetAttributes(); readOnly {
+			return nil, ErrReadOnly
+		}
+	case err != nil:
 		// Create a new file. Split the filename into CP/M 8.3 form, space-padded.
 		name, ext := splitFilename(filename)
 		newEntry := DirectoryEntry{
@@ -45,45 +85,85 @@ func (di *DiskImage) OpenFile(filename string, createNew bool) (*File, error) {
 		}
 	}
 
+	if di.recording && access != os.O_RDONLY {
+		c := Change{Op: ChangeWrite, Name: fileEntry.GetFilename()}
+		if existed {
+			if c, err = di.fileChange(ChangeWrite, fileEntry); err != nil {
+				return nil, err
+			}
+			c.Existed = true
+		}
+		di.record(c)
+	}
+	if access != os.O_RDONLY {
+		logger.Debug("opened file for writing", "name", fileEntry.GetFilename(), "existed", existed)
+	}
+
 	// Create file struct
 	f := &File{
 		disk:     di,
 		entry:    fileEntry,
 		position: 0,
-		readOnly: false,
+		readOnly: access == os.O_RDONLY,
+		append:   flag&os.O_APPEND != 0,
+		created:  !existed,
 	}
 
 	// For an existing file, populate the block list and size from its directory
-	// entry so the read path knows where the data is and how much there is.
+	// entries so the read path knows where the data is and how much there is.
 	// (For a newly created file these stay empty until data is written.)
-	if fileEntry.RecordCount > 0 || fileEntry.AllocationBlocks[0] != 0 {
-		for _, b := range fileEntry.AllocationBlocks {
-			if b != 0 {
-				f.blocks = append(f.blocks, int(b))
+	// A block number outside the data area, or one naming a directory block,
+	// means a damaged entry; reading it would return another file's data. A
+	// zero pointer before the file's last block is a hole, kept in place.
+	f.extents = di.directory.fileExtents(fileEntry)
+	for _, e := range f.extents {
+		for _, b := range e.blockSlots(di.spec.WideBlockPointers()) {
+			if b != 0 && (b < di.spec.DirBlocks || b >= di.spec.TotalBlocks()) {
+				return nil, fmt.Errorf("%w: %s has invalid block %d", ErrCorruptImage, filename, b)
 			}
+			f.blocks = append(f.blocks, b)
 		}
-		f.size = int64(fileEntry.RecordCount) * 128
+	}
+	for len(f.blocks) > 0 && f.blocks[len(f.blocks)-1] == 0 {
+		f.blocks = f.blocks[:len(f.blocks)-1]
 	}
 
-	// Try to read header if it exists
+	if flag&os.O_TRUNC != 0 && !f.readOnly {
+		// The first extent stays, empty; the blocks are reused by the next
+		// allocation.
+		if err := f.Truncate(0); err != nil {
+			return nil, err
+		}
+		f.writeOnly = access == os.O_WRONLY
+		return f, nil
+	}
+
+	f.size = int64(di.fileRecords(f.extents)) * 128
+	if bc := int(f.extents[len(f.extents)-1].Reserved1); bc > 0 && bc < 128 && f.size > 0 {
+		f.size -= int64(128 - bc) // CP/M 3 records the bytes used in the last record
+	}
+	allocated := f.size
+
+	// Try to read header if it exists. A CP/M program has none: its first
+	// record is code.
 	headerData := make([]byte, HeaderSize)
 	n, err := f.ReadAt(headerData, 0)
-	if err == nil && n == HeaderSize {
+	if err == nil && n == HeaderSize && !isCPMProgram(fileEntry.GetFilename()) {
 		header := &Plus3DosHeader{}
 		if err := header.FromBytes(headerData); err == nil {
 			if err := header.Validate(); err == nil {
 				f.header = header
 				f.isHeadered = true
-				f.position = HeaderSize
 				// The PLUS3DOS header records the exact total file length
 				// (header + data); prefer it over the record-rounded size so
 				// reads and exports are byte-exact.
-				if header.FileLength > 0 {
+				if header.FileLength > 0 && int64(header.FileLength) <= (allocated+127)/128*128 {
 					f.size = int64(header.FileLength)
 				}
 			}
 		}
 	}
+	f.writeOnly = access == os.O_WRONLY
 
 	return f, nil
 }
@@ -91,7 +171,10 @@ func (di *DiskImage) OpenFile(filename string, createNew bool) (*File, error) {
 // Write implements io.Writer
 func (f *File) Write(p []byte) (n int, err error) {
 	if f.readOnly {
-		return 0, errors.New("file is read-only")
+		return 0, ErrReadOnly
+	}
+	if f.append {
+		f.position = f.size
 	}
 
 	return f.WriteAt(p, f.position)
@@ -100,64 +183,70 @@ func (f *File) Write(p []byte) (n int, err error) {
 // WriteAt implements io.WriterAt
 func (f *File) WriteAt(p []byte, off int64) (n int, err error) {
 	if f.readOnly {
-		return 0, errors.New("file is read-only")
+		return 0, ErrReadOnly
 	}
 
-	// Calculate required blocks
+	// Bytes between the end of the file and off read as zeros: clear what
+	// the file's last block held there, and allocate the blocks the write
+	// needs zero-filled.
+	spec := f.disk.spec
+	if off > f.size {
+		if err := f.zeroTail(off); err != nil {
+			return 0, err
+		}
+	}
 	endPos := off + int64(len(p))
+	lo, hi := int(off)/spec.BlockSize, (int(endPos)+spec.BlockSize-1)/spec.BlockSize
+	if len(p) == 0 {
+		hi = lo
+	}
+	if err := f.allocate(endPos, lo, hi); err != nil {
+		return 0, err
+	}
 	if endPos > f.size {
-		blocksNeeded := (int(endPos) + BlockSize - 1) / BlockSize
-		currentBlocks := len(f.blocks)
-
-		if blocksNeeded > currentBlocks {
-			// Allocate exactly the shortfall, in whole blocks. Sizing by the byte
-			// delta re-rounds on every incremental write and over-allocates.
-			extraBlocks := blocksNeeded - currentBlocks
-			newBlocks, err := f.disk.fileAlloc.AllocateFileSpace(extraBlocks * BlockSize)
-			if err != nil {
-				return 0, fmt.Errorf("failed to allocate space: %v", err)
-			}
-			f.blocks = append(f.blocks, newBlocks...)
-		}
 		f.size = endPos
 	}
 
 	// Write data to blocks
+	f.written = true
 	written := 0
+	buf := getSectorBuffer(spec.SectorSize)
+	defer putSectorBuffer(buf)
 	for written < len(p) {
-		blockIdx := int(off+int64(written)) / BlockSize
+		blockIdx := int(off+int64(written)) / spec.BlockSize
 		if blockIdx >= len(f.blocks) {
 			break
 		}
 
-		blockOffset := int(off+int64(written)) % BlockSize
-		blockRemaining := BlockSize - blockOffset
+		blockOffset := int(off+int64(written)) % spec.BlockSize
+		blockRemaining := spec.BlockSize - blockOffset
 		writeSize := min(len(p)-written, blockRemaining)
 
 		// Map the allocation block to a physical track/sector. Allocation blocks
-		// are numbered from the start of the data area (track 1, the reserved
-		// system track being track 0). Each block is two 512-byte sectors.
+		// are numbered from the start of the data area (track 1 on a +3, the
+		// reserved system track being track 0). Each +3 block is two 512-byte
+		// sectors.
 		block := f.blocks[blockIdx]
-		linearSector := block*SectorsPerBlock + blockOffset/BytesPerSector
-		track := DirectoryTrack + linearSector/SectorsPerTrack
-		sector := linearSector % SectorsPerTrack
+		track, sector, side := spec.BlockSector(block, blockOffset/spec.SectorSize)
 
-		// Sector writes must be full 512-byte sectors; for a partial write,
+		// Sector writes must be full sectors; for a partial write,
 		// read-modify-write the sector so surrounding bytes are preserved.
-		secOff := blockOffset % BytesPerSector
-		cur, err := f.disk.GetSectorData(track, sector, 0)
-		if err != nil {
-			cur = make([]byte, BytesPerSector)
-			for i := range cur {
-				cur[i] = 0xE5
-			}
-		}
+		secOff := blockOffset % spec.SectorSize
 		nWrite := writeSize
-		if secOff+nWrite > BytesPerSector {
-			nWrite = BytesPerSector - secOff
+		if secOff+nWrite > spec.SectorSize {
+			nWrite = spec.SectorSize - secOff
+		}
+		cur := p[written : written+nWrite]
+		if nWrite < spec.SectorSize {
+			cur = *buf
+			if _, err := f.disk.ReadSectorInto(cur, track, sector, side); err != nil {
+				for i := range cur {
+					cur[i] = 0xE5
+				}
+			}
+			copy(cur[secOff:secOff+nWrite], p[written:written+nWrite])
 		}
-		copy(cur[secOff:secOff+nWrite], p[written:written+nWrite])
-		if err = f.disk.SetSectorData(track, sector, 0, cur); err != nil {
+		if err := f.disk.SetSectorData(track, sector, side, cur); err != nil {
 			return written, err
 		}
 
@@ -177,37 +266,42 @@ func (f *File) Read(p []byte) (n int, err error) {
 
 // ReadAt implements io.ReaderAt
 func (f *File) ReadAt(p []byte, off int64) (n int, err error) {
+	if f.writeOnly {
+		return 0, errWriteOnly
+	}
 	if off >= f.size {
 		return 0, io.EOF
 	}
 
+	spec := f.disk.spec
 	toRead := min(len(p), int(f.size-off))
 	read := 0
 
 	for read < toRead {
-		blockIdx := int(off+int64(read)) / BlockSize
-		if blockIdx >= len(f.blocks) {
-			break
-		}
-
-		blockOffset := int(off+int64(read)) % BlockSize
-		blockRemaining := BlockSize - blockOffset
+		blockIdx := int(off+int64(read)) / spec.BlockSize
+		blockOffset := int(off+int64(read)) % spec.BlockSize
+		blockRemaining := spec.BlockSize - blockOffset
 		readSize := min(toRead-read, blockRemaining)
+		if blockIdx >= len(f.blocks) || f.blocks[blockIdx] == 0 {
+			// A hole, or the unallocated end of a sparse file.
+			clear(p[read : read+readSize])
+			read += readSize
+			continue
+		}
 
 		// Map the allocation block to a physical track/sector (see WriteAt).
 		block := f.blocks[blockIdx]
-		linearSector := block*SectorsPerBlock + blockOffset/BytesPerSector
-		track := DirectoryTrack + linearSector/SectorsPerTrack
-		sector := linearSector % SectorsPerTrack
+		track, sector, side := spec.BlockSector(block, blockOffset/spec.SectorSize)
 
-		data, err := f.disk.GetSectorData(track, sector, 0)
+		// The sector is copied straight from the track into p.
+		data, err := f.disk.SectorView(track, sector, side)
 		if err != nil {
 			return read, err
 		}
-		secOff := blockOffset % BytesPerSector
+		secOff := blockOffset % spec.SectorSize
 		nRead := readSize
-		if secOff+nRead > BytesPerSector {
-			nRead = BytesPerSector - secOff
+		if secOff+nRead > spec.SectorSize {
+			nRead = spec.SectorSize - secOff
 		}
 		copy(p[read:read+nRead], data[secOff:secOff+nRead])
 		read += nRead
@@ -230,18 +324,66 @@ func (f *File) Seek(offset int64, whence int) (int64, error) {
 	case io.SeekEnd:
 		abs = f.size + offset
 	default:
-		return 0, errors.New("invalid whence")
+		return 0, fmt.Errorf("%w: whence %d", fs.ErrInvalid, whence)
 	}
 	if abs < 0 {
-		return 0, errors.New("negative position")
+		return 0, fmt.Errorf("%w: negative position", fs.ErrInvalid)
 	}
 	f.position = abs
 	return abs, nil
 }
 
+// Truncate changes the size of the file, PLUS3DOS header included. Shrinking
+// frees the blocks and extents past the new end; growing fills the new space
+// with zeros, or with holes in a sparse file. The position is left as it is.
+// Close updates the directory entries and the header's file length; a file
+// cut inside its header loses the header.
+func (f *File) Truncate(size int64) error {
+	if f.readOnly {
+		return ErrReadOnly
+	}
+	if size < 0 {
+		return fmt.Errorf("%w: negative size", fs.ErrInvalid)
+	}
+	if size > f.size {
+		if err := f.zeroTail(size); err != nil {
+			return err
+		}
+		if err := f.allocate(size, 0, 0); err != nil {
+			return err
+		}
+		f.size = size
+		f.written = true
+		return nil
+	}
+
+	spec := f.disk.spec
+	keep := int((size + int64(spec.BlockSize) - 1) / int64(spec.BlockSize))
+	if keep < len(f.blocks) {
+		if err := f.disk.fileAlloc.FreeBlocks(allocated(f.blocks[keep:])); err != nil {
+			return err
+		}
+		f.blocks = f.blocks[:keep]
+	}
+	need := max((keep+spec.entryBlocks()-1)/spec.entryBlocks(), 1)
+	if need < len(f.extents) {
+		for _, e := range f.extents[need:] {
+			*e = DirectoryEntry{Status: 0xE5}
+		}
+		f.extents = f.extents[:need]
+	}
+	if f.isHeadered && size < HeaderSize {
+		f.header, f.isHeadered = nil, false
+	}
+	f.size = size
+	f.written = true
+	f.disk.Modified = true
+	return nil
+}
+
 // Close implements io.Closer
 func (f *File) Close() error {
-	if f.readOnly {
+	if f.readOnly || !f.written {
 		return nil
 	}
 
@@ -252,26 +394,170 @@ func (f *File) Close() error {
 		headerData := f.header.toBytes()
 		_, err := f.WriteAt(headerData, 0)
 		if err != nil {
-			return fmt.Errorf("failed to write header: %v", err)
+			return fmt.Errorf("failed to write header: %w", err)
+		}
+	}
+
+	// Update the directory entries. The CP/M Al field holds the block NUMBERS
+	// used by an extent, not the count: sixteen 8-bit numbers, or eight 16-bit
+	// ones on a disk of more than 256 blocks. Each entry's extent number is
+	// that of its last logical extent, and its record count the records in
+	// that logical extent; the last entry's Bc byte is the number of bytes
+	// used in the last record, 0 if all of it is.
+	spec := f.disk.spec
+	perEntry := spec.entryBlocks()
+	recordsPerEntry := perEntry * spec.BlockSize / 128
+	records := int((f.size + 127) / 128)
+	for i, e := range f.extents {
+		n := min(max(records-i*recordsPerEntry, 0), recordsPerEntry)
+		last := 0
+		if n > 0 {
+			last = (n - 1) / 128
+		}
+		e.setExtentNumber(i*(spec.ExtentMask()+1) + last)
+		e.RecordCount = uint8(n - last*128)
+		e.Reserved1 = 0
+		if i == len(f.extents)-1 {
+			e.Reserved1 = byte(f.size % 128)
 		}
+		e.setBlockPointers(f.blocks[min(i*perEntry, len(f.blocks)):min((i+1)*perEntry, len(f.blocks))], spec.WideBlockPointers())
 	}
 
-	// Update directory entry. The CP/M Al field holds the block NUMBERS used by
-	// this extent (up to 16 entries), not the count. For 1K blocks the numbers fit
-	// in a single byte per slot.
-	f.entry.RecordCount = uint8((f.size + 127) / 128) // 128-byte records in this extent
-	for i := range f.entry.AllocationBlocks {
-		f.entry.AllocationBlocks[i] = 0
+	// Stamp the file on a disk with CP/M Plus datestamps.
+	now := time.Now()
+	st := Stamps{Modified: f.modTime}
+	if st.Modified.IsZero() {
+		st.Modified = now
 	}
-	for i, blk := range f.blocks {
-		if i >= len(f.entry.AllocationBlocks) {
-			break
+	if f.created {
+		st.Created = now
+	}
+	f.disk.stampEntry(f.entry, st)
+	return nil
+}
+
+// SetSparse sets whether the file is sparse. Writing past the end of a sparse
+// file, or growing it with Truncate, leaves the blocks skipped unallocated:
+// holes, recorded as zero block pointers as CP/M does for random-access
+// files, that read as zeros and take a block when written. Otherwise the
+// skipped blocks are allocated filled with zeros. Holes in an existing file
+// are kept either way.
+func (f *File) SetSparse(sparse bool) {
+	f.sparse = sparse
+}
+
+// zeroTail zeros the bytes past the end of the file up to off, in the blocks
+// the file already has, so they read as zeros once the file is extended.
+func (f *File) zeroTail(off int64) error {
+	end := min(int(off), len(f.blocks)*f.disk.spec.BlockSize)
+	if int64(end) <= f.size {
+		return nil
+	}
+	_, err := f.WriteAt(make([]byte, end-int(f.size)), f.size)
+	return err
+}
+
+// allocate extends the file's block list to cover size bytes, and allocates
+// blocks, zero-filled, for the holes among blocks lo to hi and, unless the
+// file is sparse, for the blocks past its present end. The file is left as it
+// was if the disk is full.
+func (f *File) allocate(size int64, lo, hi int) error {
+	spec := f.disk.spec
+	current := len(f.blocks)
+	end := (int(f.size) + spec.BlockSize - 1) / spec.BlockSize
+	for n := (int(size) + spec.BlockSize - 1) / spec.BlockSize; len(f.blocks) < n; {
+		f.blocks = append(f.blocks, 0)
+	}
+	var holes []int
+	for i := lo; i < min(hi, current); i++ {
+		if f.blocks[i] == 0 {
+			holes = append(holes, i)
+		}
+	}
+	for i := current; i < len(f.blocks); i++ {
+		if i >= lo && i < hi || !f.sparse && i >= end {
+			holes = append(holes, i)
+		}
+	}
+
+	undo := func() {
+		for _, h := range holes {
+			f.blocks[h] = 0
+		}
+		f.blocks = f.blocks[:current]
+	}
+	var blocks []int
+	if len(holes) > 0 {
+		// Allocate exactly the blocks needed, in one go so that they are
+		// contiguous where the disk allows.
+		var err error
+		if blocks, err = f.disk.fileAlloc.AllocateFileSpace(len(holes) * spec.BlockSize); err != nil {
+			undo()
+			return fmt.Errorf("failed to allocate space: %w", err)
+		}
+		for i, h := range holes {
+			f.blocks[h] = blocks[i]
+		}
+	}
+	if err := f.addExtents(); err != nil {
+		f.disk.fileAlloc.FreeBlocks(blocks)
+		undo()
+		return err
+	}
+
+	zero := make([]byte, spec.SectorSize)
+	for _, b := range blocks {
+		for i := range spec.SectorsPerBlock() {
+			track, sector, side := spec.BlockSector(b, i)
+			if err := f.disk.SetSectorData(track, sector, side, zero); err != nil {
+				return err
+			}
 		}
-		f.entry.AllocationBlocks[i] = uint8(blk)
 	}
 	return nil
 }
 
+// allocated returns blocks without the holes.
+func allocated(blocks []int) []int {
+	var out []int
+	for _, b := range blocks {
+		if b != 0 {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// addExtents adds directory entries until the file has enough for its
+// blocks. A file that would outgrow the 2048 logical extents CP/M can number
+// is refused.
+func (f *File) addExtents() error {
+	spec := f.disk.spec
+	need := (len(f.blocks) + spec.entryBlocks() - 1) / spec.entryBlocks()
+	if need*(spec.ExtentMask()+1) > 2048 {
+		return fmt.Errorf("%w: file exceeds the CP/M maximum size", ErrDiskFull)
+	}
+	for len(f.extents) < need {
+		e, err := f.disk.directory.addExtent(f.entry)
+		if err != nil {
+			return err
+		}
+		f.extents = append(f.extents, e)
+	}
+	return nil
+}
+
+// fileRecords returns the number of 128-byte records held by the entries of
+// a file: each entry's record count plus the full logical extents before its
+// last one.
+func (di *DiskImage) fileRecords(extents []*DirectoryEntry) int {
+	records := 0
+	for _, e := range extents {
+		records += (e.extentNumber()&di.spec.ExtentMask())*128 + int(e.RecordCount)
+	}
+	return records
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -288,7 +574,7 @@ func splitFilename(filename string) (name [8]byte, ext [3]byte) {
 	for i := range ext {
 		ext[i] = ' '
 	}
-	fn := strings.ToUpper(filename)
+	fn := NormaliseFilename(filename)
 	dot := strings.LastIndex(fn, ".")
 	base := fn
 	var e string
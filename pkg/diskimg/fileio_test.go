@@ -0,0 +1,435 @@
+package diskimg
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+)
+
+// A file larger than one directory entry can address takes an entry per
+// extent; it reads back whole after a reload, and deleting it frees every
+// entry and block.
+func TestMultiExtentFile(t *testing.T) {
+	big := SpecPlus3
+	big.Name, big.BlockSize, big.DirBlocks = "2k-blocks", 2048, 1
+
+	for _, tc := range []struct {
+		spec    DiskSpec
+		size    int
+		extents []int // extent number of each entry
+		records []int // record count of each entry
+	}{
+		{SpecPlus3, 50000, []int{0, 1, 2, 3}, []int{128, 128, 128, 7}},
+		{SpecPlus3DS, 20000, []int{0, 1}, []int{128, 29}},
+		{big, 40000, []int{1, 2}, []int{128, 57}}, // EXM 1: two logical extents per entry
+	} {
+		t.Run(tc.spec.Name, func(t *testing.T) {
+			di := newSpecImage(t, tc.spec)
+			free := di.fileAlloc.GetFreeBlocks()
+			data := make([]byte, tc.size)
+			for i := range data {
+				data[i] = byte(i * 7)
+			}
+			if err := di.writeRecords("BIG.DAT", data); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+
+			di = reload(t, di)
+			first, err := di.directory.FindFile("BIG.DAT")
+			if err != nil {
+				t.Fatal(err)
+			}
+			extents := di.directory.fileExtents(first)
+			if len(extents) != len(tc.extents) {
+				t.Fatalf("%d entries, want %d", len(extents), len(tc.extents))
+			}
+			for i, e := range extents {
+				if e.extentNumber() != tc.extents[i] || int(e.RecordCount) != tc.records[i] {
+					t.Errorf("entry %d: extent %d, %d records; want %d, %d",
+						i, e.extentNumber(), e.RecordCount, tc.extents[i], tc.records[i])
+				}
+			}
+			if records, _ := di.FileRecords("BIG.DAT"); records != (tc.size+127)/128 {
+				t.Errorf("FileRecords = %d, want %d", records, (tc.size+127)/128)
+			}
+			if files, _ := di.FileEntries(); len(files) != 1 {
+				t.Errorf("FileEntries returned %d entries, want 1", len(files))
+			}
+
+			f, err := di.OpenFile("BIG.DAT", os.O_RDONLY)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got[:tc.size], data) {
+				t.Error("file data differs after reload")
+			}
+
+			if err := di.DeleteFile("BIG.DAT"); err != nil {
+				t.Fatalf("DeleteFile: %v", err)
+			}
+			if files, _ := di.FileEntries(); len(files) != 0 {
+				t.Errorf("after DeleteFile, %d entries remain", len(files))
+			}
+			if got := di.fileAlloc.GetFreeBlocks(); got != free {
+				t.Errorf("after DeleteFile, %d blocks free, want %d", got, free)
+			}
+		})
+	}
+}
+
+// Opening a file follows the block numbers in its entries, and refuses an
+// entry naming a block outside the data area.
+func TestOpenFileBlockChain(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	data := bytes.Repeat([]byte("chain"), 1000)
+	if err := di.writeRecords("CHAIN.DAT", data); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := di.directory.FindFile("CHAIN.DAT")
+	// Move the second block elsewhere on the disk.
+	old := int(first.AllocationBlocks[1])
+	for s := 0; s < SpecPlus3.SectorsPerBlock(); s++ {
+		sector, _ := di.GetSectorData(SpecPlus3.BlockSector(old, s))
+		track, sec, side := SpecPlus3.BlockSector(100, s)
+		if err := di.SetSectorData(track, sec, side, sector); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first.AllocationBlocks[1] = 100
+	if err := di.FlushDirectory(); err != nil {
+		t.Fatal(err)
+	}
+
+	di = reload(t, di)
+	f, err := di.OpenFile("CHAIN.DAT", os.O_RDONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(f); !bytes.Equal(got[:len(data)], data) {
+		t.Error("file read wrongly after its blocks were moved")
+	}
+
+	first, _ = di.directory.FindFile("CHAIN.DAT")
+	first.AllocationBlocks[2] = 1 // a directory block
+	if _, err := di.OpenFile("CHAIN.DAT", os.O_RDONLY); !errors.Is(err, ErrCorruptImage) {
+		t.Errorf("OpenFile with a directory block: err = %v, want ErrCorruptImage", err)
+	}
+}
+
+// Block numbers are stored in 8 bits on a disk of up to 256 blocks and in 16
+// bits on a larger one, where blocks past 255 must survive a reload.
+func TestBlockPointerWidth(t *testing.T) {
+	narrow := SpecPlus3
+	narrow.Name, narrow.TracksPerSide, narrow.SectorsPerTrack = "256-blocks", 65, 8
+	wide := narrow
+	wide.Name, wide.TracksPerSide = "260-blocks", 66
+	if narrow.TotalBlocks() != 256 || narrow.WideBlockPointers() || !wide.WideBlockPointers() {
+		t.Fatalf("%d blocks wide=%v, %d blocks wide=%v", narrow.TotalBlocks(), narrow.WideBlockPointers(),
+			wide.TotalBlocks(), wide.WideBlockPointers())
+	}
+
+	var e DirectoryEntry
+	if n := e.setBlockPointers([]int{300, 2}, true); n != 2 || e.AllocationBlocks[0] != 0x2C || e.AllocationBlocks[1] != 0x01 {
+		t.Errorf("16-bit pointers = % x", e.AllocationBlocks[:4])
+	}
+
+	di := newSpecImage(t, wide)
+	data := make([]byte, di.fileSpace())
+	for i := range data {
+		data[i] = byte(i / 1024)
+	}
+	if err := di.writeRecords("FULL.DAT", data); err != nil {
+		t.Fatal(err)
+	}
+	di = reload(t, di)
+	blocks, err := di.FileBlocks("FULL.DAT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := blocks[len(blocks)-1]; last != wide.TotalBlocks()-1 {
+		t.Errorf("last block = %d, want %d", last, wide.TotalBlocks()-1)
+	}
+	f, err := di.OpenFile("FULL.DAT", os.O_RDONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(f); !bytes.Equal(got, data) {
+		t.Error("file data differs after reload")
+	}
+}
+
+// A file's exact size comes from its PLUS3DOS header, or for a headerless
+// file from the bytes used in its last record; without either it is whole
+// records.
+func TestFileSize(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.writeRecords("RAW.DAT", make([]byte, 20000)); err != nil {
+		t.Fatal(err)
+	}
+	header, _ := importHeader(1000, &ImportOptions{AddHeader: true, FileType: FileTypeCode, LoadAddr: 32768})
+	if err := di.writeRecords("CODE.BIN", append(header.toBytes(), make([]byte, 1000)...)); err != nil {
+		t.Fatal(err)
+	}
+
+	di = reload(t, di)
+	for name, want := range map[string]int{"RAW.DAT": 20000, "CODE.BIN": 1128} {
+		if got, err := di.FileSize(name); err != nil || got != want {
+			t.Errorf("FileSize(%s) = %d, %v; want %d", name, got, err, want)
+		}
+	}
+
+	// A +3DOS disk leaves Bc at 0, so a headerless file is whole records.
+	first, _ := di.directory.FindFile("RAW.DAT")
+	last := di.directory.fileExtents(first)
+	last[len(last)-1].Reserved1 = 0
+	if got, _ := di.FileSize("RAW.DAT"); got != 20096 {
+		t.Errorf("FileSize without a byte count = %d, want 20096", got)
+	}
+}
+
+// OpenFile takes the os.OpenFile flags: O_EXCL refuses an existing file,
+// O_TRUNC frees its blocks for reuse, O_APPEND writes at the end, and a file
+// with the read-only attribute only opens for reading.
+func TestOpenFileFlags(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	free := di.fileAlloc.GetFreeBlocks()
+	if err := di.writeRecords("LOG.TXT", bytes.Repeat([]byte("x"), 50000)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := di.OpenFile("NEW.TXT", os.O_RDWR); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("open of a missing file: err = %v, want ErrFileNotFound", err)
+	}
+	if _, err := di.OpenFile("LOG.TXT", os.O_RDWR|os.O_CREATE|os.O_EXCL); !errors.Is(err, ErrFileExists) {
+		t.Errorf("O_EXCL open of an existing file: err = %v, want ErrFileExists", err)
+	}
+
+	f, err := di.OpenFile("LOG.TXT", os.O_WRONLY|os.O_TRUNC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("one\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Read(make([]byte, 1)); err == nil {
+		t.Error("read of a write-only file succeeded")
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := di.fileAlloc.GetFreeBlocks(); got != free-1 {
+		t.Errorf("%d blocks free after truncating, want %d", got, free-1)
+	}
+	if first, _ := di.directory.FindFile("LOG.TXT"); len(di.directory.fileExtents(first)) != 1 {
+		t.Error("truncating kept the extra extents")
+	}
+
+	f, err = di.OpenFile("LOG.TXT", os.O_WRONLY|os.O_APPEND)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Seek(0, io.SeekStart)
+	f.Write([]byte("two\n"))
+	f.Close()
+	if err := di.FlushDirectory(); err != nil {
+		t.Fatal(err)
+	}
+	di = reload(t, di)
+	f, err = di.OpenFile("LOG.TXT", os.O_RDONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(f); string(got) != "one\ntwo\n" {
+		t.Errorf("LOG.TXT = %q, want %q", got, "one\ntwo\n")
+	}
+	if _, err := f.Write([]byte("x")); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("write to an O_RDONLY file: err = %v, want ErrReadOnly", err)
+	}
+
+	first, _ := di.directory.FindFile("LOG.TXT")
+	first.SetAttributes(true, false, false)
+	if _, err := di.OpenFile("LOG.TXT", os.O_RDWR); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("write open of a read-only file: err = %v, want ErrReadOnly", err)
+	}
+	if _, err := di.OpenFile("LOG.TXT", os.O_RDONLY); err != nil {
+		t.Errorf("read open of a read-only file: %v", err)
+	}
+}
+
+// Truncate shrinks a file, freeing its surplus blocks and extents, and grows
+// one with zeros.
+func TestFileTruncate(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	free := di.fileAlloc.GetFreeBlocks()
+	data := bytes.Repeat([]byte("abcdefgh"), 6250)
+	if err := di.writeRecords("DATA.BIN", data); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := di.OpenFile("DATA.BIN", os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := di.fileAlloc.GetFreeBlocks(); got != free-1 {
+		t.Errorf("%d blocks free after truncating, want %d", got, free-1)
+	}
+	if first, _ := di.directory.FindFile("DATA.BIN"); len(di.directory.fileExtents(first)) != 1 {
+		t.Error("truncating kept the extra extents")
+	}
+	if err := di.FlushDirectory(); err != nil {
+		t.Fatal(err)
+	}
+	di = reload(t, di)
+	if got, _ := fs.ReadFile(di, "DATA.BIN"); !bytes.Equal(got, data[:1000]) {
+		t.Errorf("DATA.BIN is %d bytes after truncating, want the first 1000", len(got))
+	}
+
+	f, err = di.OpenFile("DATA.BIN", os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(3000); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	want := append(data[:1000:1000], make([]byte, 2000)...)
+	if got, _ := fs.ReadFile(di, "DATA.BIN"); !bytes.Equal(got, want) {
+		t.Error("growing did not fill the new space with zeros")
+	}
+
+	f, _ = di.OpenFile("DATA.BIN", os.O_RDONLY)
+	if err := f.Truncate(0); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("truncate of an O_RDONLY file: err = %v, want ErrReadOnly", err)
+	}
+}
+
+// Bytes skipped by a write past the end of a file read as zeros, whatever
+// the blocks held before; a sparse file leaves the skipped blocks as holes
+// until they are written, and keeps them across a reload.
+func TestSparseWrites(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	bs := di.spec.BlockSize
+	// Leave junk in the free blocks.
+	if err := di.writeRecords("JUNK.BIN", bytes.Repeat([]byte{0xFF}, 20*bs)); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.DeleteFile("JUNK.BIN"); err != nil {
+		t.Fatal(err)
+	}
+	free := di.fileAlloc.GetFreeBlocks()
+
+	f, err := di.OpenFile("GAP.BIN", os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("a"))
+	f.WriteAt([]byte("b"), int64(3*bs+5))
+	if err := f.Truncate(2); err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte("c"), 200)
+	f.Close()
+	want := make([]byte, 201)
+	want[0], want[200] = 'a', 'c'
+	if got, _ := fs.ReadFile(di, "GAP.BIN"); !bytes.Equal(got, want) {
+		t.Errorf("GAP.BIN = % x, want % x", got, want)
+	}
+
+	f, err = di.OpenFile("SPARSE.BIN", os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.SetSparse(true)
+	f.WriteAt([]byte("end"), int64(10*bs))
+	if err := f.Truncate(int64(12 * bs)); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if got := free - 1 - di.fileAlloc.GetFreeBlocks(); got != 1 {
+		t.Errorf("sparse file allocated %d blocks, want 1", got)
+	}
+	if err := di.FlushDirectory(); err != nil {
+		t.Fatal(err)
+	}
+
+	di = reload(t, di)
+	want = make([]byte, 12*bs)
+	copy(want[10*bs:], "end")
+	if got, _ := fs.ReadFile(di, "SPARSE.BIN"); !bytes.Equal(got, want) {
+		t.Errorf("SPARSE.BIN reads back wrong (%d bytes)", len(got))
+	}
+	f, err = di.OpenFile("SPARSE.BIN", os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte("mid"), int64(5*bs))
+	f.Close()
+	copy(want[5*bs:], "mid")
+	if got, _ := fs.ReadFile(di, "SPARSE.BIN"); !bytes.Equal(got, want) {
+		t.Error("writing into a hole changed the rest of the file")
+	}
+	if blocks, _ := di.FileBlocks("SPARSE.BIN"); len(blocks) != 2 {
+		t.Errorf("SPARSE.BIN has %d blocks, want 2", len(blocks))
+	}
+	if err := di.DiskCheck(); err != nil {
+		t.Errorf("DiskCheck: %v", err)
+	}
+}
+
+// Loading rebuilds the block and sector allocation from the directory, so a
+// loaded disk's free space matches the one saved and its files can be moved.
+func TestLoadRebuildsAllocation(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	data := bytes.Repeat([]byte("alloc"), 2000)
+	if err := di.writeRecords("DATA.BIN", data); err != nil {
+		t.Fatal(err)
+	}
+	free := di.fileAlloc.GetFreeBlocks()
+
+	di = reload(t, di)
+	if got := di.fileAlloc.GetFreeBlocks(); got != free {
+		t.Errorf("free blocks after load = %d, want %d", got, free)
+	}
+	entry, _ := di.directory.FindFile("DATA.BIN")
+	blocks := entry.blockPointers(false)
+	spb := SpecPlus3.SectorsPerBlock()
+	for _, b := range blocks {
+		if ok, _ := di.allocation.IsSectorAllocated(b * spb); !ok {
+			t.Errorf("sector of block %d not allocated after load", b)
+		}
+	}
+	spec := di.allocation.spec
+	if got, want := di.allocation.GetFreeSpace(), spec.TracksPerSide*spec.SectorsPerTrack-(SpecPlus3.TotalBlocks()-free)*spb; got != want {
+		t.Errorf("free sectors = %d, want %d", got, want)
+	}
+
+	moved, err := di.fileAlloc.DefragmentFile(blocks)
+	if err != nil {
+		t.Fatalf("DefragmentFile: %v", err)
+	}
+	if moved[0] == blocks[0] {
+		t.Errorf("DefragmentFile reused the file's own block %d", blocks[0])
+	}
+	if n := entry.setBlockPointers(moved, false); n != len(moved) {
+		t.Fatal("moved blocks do not fit the entry")
+	}
+	f, err := di.OpenFile("DATA.BIN", os.O_RDONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(f); !bytes.Equal(got[:len(data)], data) {
+		t.Error("file read wrongly after defragmenting")
+	}
+}
@@ -0,0 +1,74 @@
+// file: pkg/diskimg/filename.go
+
+package diskimg
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// NormaliseFilename returns name in the form file names on the disk are stored
+// and looked up in: without surrounding spaces, and with ASCII letters upper
+// case. Every function taking a file name normalises it, so names differing
+// only in case are the same file.
+func NormaliseFilename(name string) string {
+	return strings.Map(func(r rune) rune {
+		if 'a' <= r && r <= 'z' {
+			return r - ('a' - 'A')
+		}
+		return r
+	}, strings.TrimSpace(name))
+}
+
+// SanitiseFilename makes name a valid 8.3 file name: upper case, split at its
+// last dot, with the name cut to eight characters and the extension to three,
+// and the characters CP/M reserves, earlier dots among them, replaced by
+// underscores. A name left empty becomes FILE.
+func SanitiseFilename(name string) string {
+	name = NormaliseFilename(name)
+	base, ext := name, ""
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		base, ext = name[:i], name[i+1:]
+	}
+	base = plus3Name(base)
+	if ext = cpmChars(ext, 3); ext != "" {
+		return base + "." + ext
+	}
+	return base
+}
+
+// HostName returns the name the Import methods give a host file on the disk:
+// the first eight characters of its base name, upper-cased, and ext, or the
+// first three characters of the file's own extension if ext is "". The name
+// is not otherwise changed, so it may still be invalid.
+func HostName(hostPath, ext string) string {
+	base := filepath.Base(hostPath)
+	hostExt := filepath.Ext(base)
+	name := strings.TrimSuffix(base, hostExt)
+	if ext == "" {
+		ext = strings.TrimPrefix(hostExt, ".")
+	}
+	name, ext = name[:min(len(name), 8)], ext[:min(len(ext), 3)]
+	if ext == "" {
+		return NormaliseFilename(name)
+	}
+	return NormaliseFilename(name + "." + ext)
+}
+
+// SetSanitiseNames sets whether the names of files created or renamed on the
+// disk, and its label, are made valid with SanitiseFilename. Otherwise, as
+// by default, an invalid name is an ErrInvalidFilename error saying what is
+// wrong with it.
+func (di *DiskImage) SetSanitiseNames(sanitise bool) {
+	di.sanitise = sanitise
+}
+
+// diskName returns name normalised, to be given to a file on the disk:
+// sanitised if the disk sanitises names, otherwise checked to be valid.
+func (di *DiskImage) diskName(name string) (string, error) {
+	name = NormaliseFilename(name)
+	if di.sanitise {
+		return SanitiseFilename(name), nil
+	}
+	return name, validateFilename(name)
+}
@@ -0,0 +1,184 @@
+package diskimg
+
+import (
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateFilename(t *testing.T) {
+	for name, reason := range map[string]string{
+		"GAME.BIN":      "",
+		"game.bin":      "",
+		"README":        "",
+		".BIN":          "no name",
+		"LONGNAME1.BIN": "longer than 8",
+		"GAME.BINX":     "longer than 3",
+		"MY GAME.BIN":   `' '`,
+		"A.B.C":         `'.'`,
+		"GAME?.BIN":     `'?'`,
+		"SCORE.<1>":     `'<'`,
+		"CAFÉ.TXT":      `'É'`,
+	} {
+		err := validateFilename(name)
+		switch {
+		case reason == "" && err != nil:
+			t.Errorf("%q: %v", name, err)
+		case reason != "" && (!errors.Is(err, ErrInvalidFilename) || !strings.Contains(err.Error(), reason)):
+			t.Errorf("%q: err = %v, want ErrInvalidFilename saying %s", name, err, reason)
+		}
+	}
+}
+
+func TestSanitiseFilename(t *testing.T) {
+	for name, want := range map[string]string{
+		"game.bin":           "GAME.BIN",
+		"my game;1.bin":      "MY_GAME_.BIN",
+		"archive.tar.gz":     "ARCHIVE_.GZ",
+		"verylongname.text":  "VERYLONG.TEX",
+		".profile":           "FILE.PRO",
+		"README":             "README",
+		"a[1]=b":             "A_1__B",
+		"  spaced out.txt  ": "SPACED_O.TXT",
+		"SCORES.<1>":         "SCORES._1_",
+	} {
+		got := SanitiseFilename(name)
+		if got != want {
+			t.Errorf("SanitiseFilename(%q) = %q, want %q", name, got, want)
+		}
+		if err := validateFilename(got); err != nil {
+			t.Errorf("SanitiseFilename(%q) = %q: %v", name, got, err)
+		}
+	}
+}
+
+func TestHostName(t *testing.T) {
+	for _, c := range []struct{ path, ext, want string }{
+		{"dir/loader.bas", "", "LOADER.BAS"},
+		{"dir/loader_program.bin", "BIN", "LOADER_P.BIN"},
+		{"notes.text", "", "NOTES.TEX"},
+		{"title.png", "SCR", "TITLE.SCR"},
+		{"README", "", "README"},
+	} {
+		if got := HostName(c.path, c.ext); got != c.want {
+			t.Errorf("HostName(%q, %q) = %q, want %q", c.path, c.ext, got, c.want)
+		}
+	}
+}
+
+// A name that is not a valid CP/M name is refused wherever a file is named,
+// unless the disk sanitises names.
+func TestSanitiseNames(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.WriteFile("GAME.BIN", []byte("game")); err != nil {
+		t.Fatal(err)
+	}
+	for op, err := range map[string]error{
+		"create": func() error { _, err := di.OpenFile("MY GAME", os.O_RDWR|os.O_CREATE); return err }(),
+		"write":  di.WriteFile("A.B.C", nil),
+		"rename": di.RenameFile("GAME.BIN", "GAME*.BIN"),
+		"label":  di.SetLabel("DISK:1"),
+	} {
+		if !errors.Is(err, ErrInvalidFilename) {
+			t.Errorf("%s: err = %v, want ErrInvalidFilename", op, err)
+		}
+	}
+	if _, err := di.StatFile("MY GAME"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("the refused file was created: %v", err)
+	}
+
+	di.SetSanitiseNames(true)
+	if err := di.WriteFile("my game.bin", []byte("mine")); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.RenameFile("GAME.BIN", "GAME*.BIN"); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.SetLabel("DISK:1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"MY_GAME.BIN", "GAME_.BIN"} {
+		if _, err := di.StatFile(name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if label := di.GetLabel(); label != "DISK_1" {
+		t.Errorf("label = %q, want DISK_1", label)
+	}
+}
+
+func TestNormaliseFilename(t *testing.T) {
+	for name, want := range map[string]string{
+		" Game.Bin ": "GAME.BIN",
+		"GAME.BIN":   "GAME.BIN",
+		"café.txt":   "CAFé.TXT", // only ASCII letters change
+		"":           "",
+	} {
+		if got := NormaliseFilename(name); got != want {
+			t.Errorf("NormaliseFilename(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// Names are the same file whatever their case, however the file was named.
+func TestMixedCaseRoundTrip(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	host := t.TempDir() + "/MixedCase.txt"
+	if err := os.WriteFile(host, []byte("text"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.ImportRaw(host); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.WriteFile("Game.Bin", []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.WriteFile("gAME.bIN", []byte("two")); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for e := range di.Files(nil) {
+		names = append(names, e.GetFilename())
+	}
+	if want := []string{"MIXEDCAS.TXT", "GAME.BIN"}; !slices.Equal(names, want) {
+		t.Errorf("files = %q, want %q", names, want)
+	}
+	f, err := di.OpenFile("game.bin", os.O_RDONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := io.ReadAll(f); err != nil || string(data) != "two" {
+		t.Errorf("game.bin = %q, %v; want the second write", data, err)
+	}
+	if _, err := di.StatFile("mixedcas.txt"); err != nil {
+		t.Error(err)
+	}
+
+	if err := di.RenameFile("Game.bin", "new.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.RenameFile("NEW.BIN", "New.Bin"); err != nil {
+		t.Errorf("renaming a file to its own name in another case: %v", err)
+	}
+	var fe *FileError
+	if err := di.DeleteFile("game.BIN"); !errors.As(err, &fe) || fe.Name != "GAME.BIN" {
+		t.Errorf("deleting the old name: err = %v, want a FileError for GAME.BIN", err)
+	}
+	if err := di.DeleteFile("new.bin"); err != nil {
+		t.Error(err)
+	}
+
+	// A name stored in lower case, as some CP/M tools write them, is found
+	// by any case too.
+	e := &di.directory.Entries[0]
+	copy(e.Name[:], "mixedcas")
+	di.directory.Reindex()
+	for _, name := range []string{"MIXEDCAS.TXT", "mixedcas.txt", "MixedCas.TXT"} {
+		if _, err := di.StatFile(name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
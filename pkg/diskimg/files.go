@@ -0,0 +1,57 @@
+// file: pkg/diskimg/files.go
+
+package diskimg
+
+import "iter"
+
+// FilesOptions selects the files Files and Walk visit. Without options they
+// visit the files in use that do not have the system attribute.
+type FilesOptions struct {
+	System  bool // include files with the system attribute
+	Deleted bool // include deleted files whose directory entries keep a name
+}
+
+// Files returns an iterator over the first directory entry of each file, in
+// directory order: the files in every user area, not the directory label or
+// datestamp entries. A deleted file cannot be opened, but its entries are
+// intact until their slots are reused.
+func (di *DiskImage) Files(opts *FilesOptions) iter.Seq[DirectoryEntry] {
+	if opts == nil {
+		opts = &FilesOptions{}
+	}
+	return func(yield func(DirectoryEntry) bool) {
+		for _, e := range di.directory.Entries {
+			if di.visits(&e, opts) && !yield(e) {
+				return
+			}
+		}
+	}
+}
+
+// Walk calls fn with the first directory entry of each file Files visits,
+// stopping at the first error fn returns, which it returns.
+func (di *DiskImage) Walk(opts *FilesOptions, fn func(DirectoryEntry) error) error {
+	for e := range di.Files(opts) {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// visits reports whether Files visits entry e.
+func (di *DiskImage) visits(e *DirectoryEntry, opts *FilesOptions) bool {
+	if e.extentNumber() > di.spec.ExtentMask() {
+		return false
+	}
+	switch {
+	case e.IsDeleted():
+		if !opts.Deleted || validateFilename(e.GetFilename()) != nil {
+			return false
+		}
+	case e.IsUnused() || e.Status > 15:
+		return false
+	}
+	_, _, system := e.GetAttributes()
+	return opts.System || !system
+}
@@ -0,0 +1,47 @@
+package diskimg
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// Files visits each file once, leaving out system files and deleted files
+// unless asked for them; Walk stops at the first error.
+func TestFiles(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	for _, name := range []string{"A.BIN", "B.BIN", "SYS.COM", "OLD.TXT"} {
+		if err := di.writeRecords(name, make([]byte, 20000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := di.SetFileAttributes("SYS.COM", false, true); err != nil {
+		t.Fatal(err)
+	}
+	old, _ := di.directory.FindFile("OLD.TXT")
+	old.Status = 0xE5 // deleted by CP/M, which keeps the name
+
+	names := func(opts *FilesOptions) []string {
+		var names []string
+		for e := range di.Files(opts) {
+			names = append(names, e.GetFilename())
+		}
+		return names
+	}
+	if got := names(nil); !slices.Equal(got, []string{"A.BIN", "B.BIN"}) {
+		t.Errorf("Files(nil) = %v", got)
+	}
+	if got := names(&FilesOptions{System: true, Deleted: true}); !slices.Equal(got, []string{"A.BIN", "B.BIN", "SYS.COM", "OLD.TXT"}) {
+		t.Errorf("Files(system, deleted) = %v", got)
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	err := di.Walk(nil, func(e DirectoryEntry) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Errorf("Walk = %v after %d files, want stop after 1", err, n)
+	}
+}
@@ -0,0 +1,53 @@
+// file: pkg/diskimg/fsys.go
+
+package diskimg
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// imageExts are the extensions FindImages treats as disk images.
+var imageExts = []string{".dsk", ".edsk", ".img", ".trd", ".scl", ".opd", ".opu"}
+
+// LoadFromFS loads a DSK image, or a raw sector image, named name from fsys.
+// Any fs.FS will do; a ZIP archive opened with archive/zip is one, so zipped
+// collections can be read without unpacking them.
+func LoadFromFS(fsys fs.FS, name string, opts *LoadOptions) (*DiskImage, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte("MV - CPC")) && !bytes.HasPrefix(data, []byte("EXTENDED")) {
+		if _, err := RawSpec(data); err == nil {
+			return LoadRaw(bytes.NewReader(data))
+		}
+	}
+	return LoadWithOptions(bytes.NewReader(data), opts)
+}
+
+// FindImages returns the paths of the files in fsys whose extension marks
+// them as disk images, in lexical order.
+func FindImages(fsys fs.FS) ([]string, error) {
+	var names []string
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := strings.ToLower(path.Ext(p))
+		for _, e := range imageExts {
+			if !d.IsDir() && ext == e {
+				names = append(names, p)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for disk images: %w", err)
+	}
+	return names, nil
+}
@@ -0,0 +1,100 @@
+// file: pkg/diskimg/headeredit.go
+
+package diskimg
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// ReadRawHeader returns the named file's PLUS3DOS header as long as the file
+// begins with the signature, even if the header fails Validate, as one with a
+// bad checksum does: ReadHeader and the rest of the package treat such a file
+// as headerless. A file without a signature returns a nil header and a nil
+// error.
+func (di *DiskImage) ReadRawHeader(diskPath string) (*Plus3DosHeader, error) {
+	h, _, err := di.readRawHeader(diskPath)
+	return h, err
+}
+
+// readRawHeader returns the file's raw header, as ReadRawHeader does, and the
+// file's contents, header and all. A header's FileLength shorter than the
+// contents trims the padding of the file's last record.
+func (di *DiskImage) readRawHeader(diskPath string) (*Plus3DosHeader, []byte, error) {
+	f, err := di.OpenFile(diskPath, os.O_RDONLY)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	data := make([]byte, f.size)
+	if n, err := f.ReadAt(data, 0); n < len(data) {
+		return nil, nil, fileError("read", diskPath, err)
+	}
+	if len(data) < HeaderSize || !bytes.HasPrefix(data, []byte(HeaderSignature)) {
+		return nil, data, nil
+	}
+	h := &Plus3DosHeader{}
+	if err := h.FromBytes(data); err != nil {
+		return nil, nil, err
+	}
+	if n := int(h.FileLength); n >= HeaderSize && n < len(data) {
+		data = data[:n]
+	}
+	return h, data, nil
+}
+
+// ChecksumValid reports whether the header's checksum matches its other
+// bytes.
+func (h *Plus3DosHeader) ChecksumValid() bool {
+	return h.verifyChecksum()
+}
+
+// WriteHeader replaces the PLUS3DOS header of the named file with h, after
+// updating h's checksum, and flushes the directory. The file must have a
+// header, if only one ReadRawHeader finds; AddHeader gives it one.
+func (di *DiskImage) WriteHeader(diskPath string, h *Plus3DosHeader) error {
+	old, data, err := di.readRawHeader(diskPath)
+	if err != nil {
+		return err
+	}
+	if old == nil {
+		return fileError("write header", diskPath, fmt.Errorf("%w: file has no PLUS3DOS header", ErrInvalidHeader))
+	}
+	h.UpdateChecksum()
+	copy(data, h.toBytes())
+	return di.WriteFile(diskPath, data)
+}
+
+// AddHeader gives a headerless file a PLUS3DOS header made from opts, as
+// ImportData makes one for new data, and flushes the directory.
+func (di *DiskImage) AddHeader(diskPath string, opts *ImportOptions) error {
+	old, data, err := di.readRawHeader(diskPath)
+	if err != nil {
+		return err
+	}
+	if old != nil {
+		return fileError("add header", diskPath, fmt.Errorf("%w: file already has a PLUS3DOS header", ErrInvalidHeader))
+	}
+	if opts == nil {
+		opts = &ImportOptions{FileType: FileTypeCode}
+	}
+	h, err := importHeader(len(data), opts)
+	if err != nil {
+		return err
+	}
+	return di.WriteFile(diskPath, append(h.toBytes(), data...))
+}
+
+// StripHeader removes the PLUS3DOS header of the named file, if only one
+// ReadRawHeader finds, leaving its data, and flushes the directory.
+func (di *DiskImage) StripHeader(diskPath string) error {
+	h, data, err := di.readRawHeader(diskPath)
+	if err != nil {
+		return err
+	}
+	if h == nil {
+		return fileError("strip header", diskPath, fmt.Errorf("%w: file has no PLUS3DOS header", ErrInvalidHeader))
+	}
+	return di.WriteFile(diskPath, data[HeaderSize:])
+}
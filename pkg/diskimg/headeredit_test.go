@@ -0,0 +1,76 @@
+package diskimg
+
+import (
+	"bytes"
+	"io/fs"
+	"testing"
+)
+
+// A header with a bad checksum makes the file headerless to ReadHeader, but
+// ReadRawHeader still finds it and WriteHeader repairs it.
+func TestWriteHeaderFixesChecksum(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	body := bytes.Repeat([]byte{0xAA}, 300)
+	if err := di.ImportData("GAME.BIN", body, &ImportOptions{AddHeader: true, FileType: FileTypeCode, LoadAddr: 32768}); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := fs.ReadFile(di, "GAME.BIN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[127]++
+	if err := di.WriteFile("GAME.BIN", raw); err != nil {
+		t.Fatal(err)
+	}
+	if h, err := di.ReadHeader("GAME.BIN"); err != nil || h != nil {
+		t.Fatalf("ReadHeader = %v, %v; want no header", h, err)
+	}
+
+	h, err := di.ReadRawHeader("GAME.BIN")
+	if err != nil || h == nil {
+		t.Fatalf("ReadRawHeader = %v, %v", h, err)
+	}
+	if h.ChecksumValid() {
+		t.Error("ChecksumValid = true for a damaged checksum")
+	}
+	h.SetBasicHeader(FileTypeCode, 300, 24576, 0)
+	if err := di.WriteHeader("GAME.BIN", h); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := di.StatFile("GAME.BIN")
+	if err != nil || !fi.Headered() || fi.LoadAddress != 24576 {
+		t.Fatalf("after WriteHeader: %+v, %v", fi, err)
+	}
+	data, err := fs.ReadFile(di.HeaderlessFS(), "GAME.BIN")
+	if err != nil || !bytes.Equal(data, body) {
+		t.Errorf("data after WriteHeader: %d bytes, %v", len(data), err)
+	}
+}
+
+func TestStripAndAddHeader(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	body := []byte("10 PRINT")
+	if err := di.ImportData("PROG", body, &ImportOptions{AddHeader: true, FileType: FileTypeProgram, Line: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.AddHeader("PROG", nil); err == nil {
+		t.Error("AddHeader on a headered file succeeded")
+	}
+	if err := di.StripHeader("PROG"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := fs.ReadFile(di, "PROG"); err != nil || !bytes.Equal(data, body) {
+		t.Fatalf("after StripHeader: %q, %v", data, err)
+	}
+	if err := di.WriteHeader("PROG", NewPlus3DosHeader()); err == nil {
+		t.Error("WriteHeader on a headerless file succeeded")
+	}
+
+	if err := di.AddHeader("PROG", &ImportOptions{FileType: FileTypeProgram, Line: 20}); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := di.StatFile("PROG")
+	if err != nil || !fi.Headered() || fi.Line != 20 || fi.Size != int64(HeaderSize+len(body)) {
+		t.Errorf("after AddHeader: %+v, %v", fi, err)
+	}
+}
@@ -0,0 +1,255 @@
+// file: pkg/diskimg/hfe.go
+
+package diskimg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// HFE v1 is the bitstream image format of the HxC floppy emulator, read by
+// Gotek drives running FlashFloppy. Each track is stored as the MFM bitcells a
+// drive would see, so the sectors are encoded with their address marks, gaps
+// and CRCs rather than copied.
+const (
+	hfeBitRate       = 250 // kbit/s, double density
+	hfeRPM           = 300
+	hfeShugartDD     = 7     // GENERIC_SHUGART_DD_FLOPPYMODE
+	hfeTrackBytes    = 12500 // MFM bytes per side: 250 kbit/s for 200 ms, two cells a bit
+	hfeBlockSize     = 512
+	hfeMaxTrackBytes = hfeTrackBytes / 2 // data bytes that fit on one revolution
+)
+
+// SaveHFE writes the disk image as an HFE v1 image with MFM track encoding,
+// for HxC and Gotek/FlashFloppy drives. Absent tracks are written as
+// unformatted (gap filler only).
+func (di *DiskImage) SaveHFE(w io.Writer) error {
+	if err := di.FlushDirectory(); err != nil {
+		return err
+	}
+	cylinders, sides := int(di.Header.TracksNum), int(di.Header.SidesNum)
+
+	// Header (block 0).
+	header := make([]byte, hfeBlockSize)
+	for i := range header {
+		header[i] = 0xFF
+	}
+	copy(header, "HXCPICFE")
+	header[0x08] = 0 // format revision
+	header[0x09] = byte(cylinders)
+	header[0x0A] = byte(sides)
+	header[0x0B] = 0 // ISO/IBM MFM encoding
+	binary.LittleEndian.PutUint16(header[0x0C:], hfeBitRate)
+	binary.LittleEndian.PutUint16(header[0x0E:], hfeRPM)
+	header[0x10] = hfeShugartDD
+	header[0x11] = 1                                // unused
+	binary.LittleEndian.PutUint16(header[0x12:], 1) // track list in block 1
+
+	// Track list (block 1 onwards): block offset and byte length of each
+	// cylinder, both sides included.
+	listBlocks := (cylinders*4 + hfeBlockSize - 1) / hfeBlockSize
+	trackBlocks := (2*hfeTrackBytes + hfeBlockSize - 1) / hfeBlockSize
+	list := make([]byte, listBlocks*hfeBlockSize)
+	for i := range list {
+		list[i] = 0xFF
+	}
+	for c := 0; c < cylinders; c++ {
+		binary.LittleEndian.PutUint16(list[c*4:], uint16(1+listBlocks+c*trackBlocks))
+		binary.LittleEndian.PutUint16(list[c*4+2:], 2*hfeTrackBytes)
+	}
+	if _, err := w.Write(header); err != nil {
+		return fmt.Errorf("failed to write HFE header: %w", err)
+	}
+	if _, err := w.Write(list); err != nil {
+		return fmt.Errorf("failed to write HFE track list: %w", err)
+	}
+
+	// Track data: the two sides interleaved in 256-byte halves of each block,
+	// bits least significant first.
+	for c := 0; c < cylinders; c++ {
+		var cells [2][]byte
+		for s := 0; s < 2; s++ {
+			var err error
+			if s < sides {
+				cells[s], err = di.mfmTrack(c, s)
+			} else {
+				cells[s], err = mfmUnformatted(), nil
+			}
+			if err != nil {
+				return fmt.Errorf("track %d side %d: %w", c, s, err)
+			}
+			for i, b := range cells[s] {
+				cells[s][i] = bits.Reverse8(b)
+			}
+		}
+		block := make([]byte, trackBlocks*hfeBlockSize)
+		for i := 0; i < trackBlocks; i++ {
+			for s := 0; s < 2; s++ {
+				lo := i * 256
+				if lo < len(cells[s]) {
+					copy(block[i*hfeBlockSize+s*256:i*hfeBlockSize+(s+1)*256], cells[s][lo:])
+				}
+			}
+		}
+		if _, err := w.Write(block); err != nil {
+			return fmt.Errorf("failed to write HFE track data: %w", err)
+		}
+	}
+	return nil
+}
+
+// mfmTrack encodes one side of a cylinder in the IBM System/34 layout the +3
+// FDC writes: index gap and mark, then an ID field and a data field for each
+// sector, each preceded by three 0xA1 sync marks and followed by a CRC.
+func (di *DiskImage) mfmTrack(cylinder, side int) ([]byte, error) {
+	ti, err := di.GetTrackInfo(cylinder, side)
+	if err != nil {
+		return mfmUnformatted(), nil // absent track
+	}
+	gap3 := int(ti.GapLength)
+	if gap3 == 0 {
+		gap3 = 0x4E
+	}
+
+	// Shrink gap 3 if the sectors would not fit on one revolution.
+	size := 80 + 12 + 4 + 50
+	for _, si := range ti.SectorInfo {
+		size += 12 + 4 + 4 + 2 + 22 + 12 + 4 + (128 << min(int(si.Size), 6)) + 2
+	}
+	if free := hfeMaxTrackBytes - size; free < gap3*len(ti.SectorInfo) {
+		gap3 = max(free/max(len(ti.SectorInfo), 1), 0)
+		if gap3 < 1 {
+			return nil, fmt.Errorf("%w: sectors do not fit on an MFM track", ErrUnsupported)
+		}
+	}
+
+	m := &mfmWriter{}
+	m.fill(0x4E, 80) // gap 4a
+	m.fill(0x00, 12)
+	m.sync(0x5224, 3) // index address mark: C2 C2 C2 FC
+	m.byte(0xFC)
+	m.fill(0x4E, 50) // gap 1
+
+	for n, si := range ti.SectorInfo {
+		data, err := di.sectorAt(cylinder, n, side)
+		if err != nil {
+			return nil, err
+		}
+		// The data field is always 128 << N bytes on the disk.
+		field := make([]byte, 128<<min(int(si.Size), 6))
+		copy(field, data)
+
+		id := []byte{0xA1, 0xA1, 0xA1, 0xFE, si.Track, si.Side, si.SectorID, si.Size}
+		m.fill(0x00, 12)
+		m.sync(0x4489, 3)
+		m.bytes(id[3:])
+		m.crc(crc16(id), si.Status1&0x20 != 0 && si.Status2&0x20 == 0) // CRC error in ID
+		m.fill(0x4E, 22)                                               // gap 2
+
+		mark := byte(0xFB)
+		if si.Status2&0x40 != 0 {
+			mark = 0xF8 // deleted data
+		}
+		m.fill(0x00, 12)
+		m.sync(0x4489, 3)
+		m.byte(mark)
+		m.bytes(field)
+		m.crc(crc16(append([]byte{0xA1, 0xA1, 0xA1, mark}, field...)), si.Status2&0x20 != 0) // CRC error in data
+		m.fill(0x4E, gap3)
+	}
+
+	for len(m.cells) < hfeTrackBytes {
+		m.byte(0x4E) // gap 4b
+	}
+	return m.cells[:hfeTrackBytes], nil
+}
+
+// mfmUnformatted returns the cells of an unformatted track side.
+func mfmUnformatted() []byte {
+	m := &mfmWriter{}
+	m.fill(0x4E, hfeMaxTrackBytes)
+	return m.cells[:hfeTrackBytes]
+}
+
+// mfmWriter accumulates MFM bitcells, most significant first: each data bit
+// is preceded by a clock bit, set only between two zero data bits.
+type mfmWriter struct {
+	cells []byte
+	n     int  // cells written
+	prev  byte // previous data bit
+}
+
+// cell appends one bitcell.
+func (m *mfmWriter) cell(bit byte) {
+	if m.n%8 == 0 {
+		m.cells = append(m.cells, 0)
+	}
+	m.cells[len(m.cells)-1] |= bit << (7 - m.n%8)
+	m.n++
+}
+
+// byte appends the cells of one data byte.
+func (m *mfmWriter) byte(b byte) {
+	for i := 7; i >= 0; i-- {
+		d := b >> i & 1
+		clock := byte(0)
+		if m.prev == 0 && d == 0 {
+			clock = 1
+		}
+		m.cell(clock)
+		m.cell(d)
+		m.prev = d
+	}
+}
+
+func (m *mfmWriter) bytes(p []byte) {
+	for _, b := range p {
+		m.byte(b)
+	}
+}
+
+func (m *mfmWriter) fill(b byte, n int) {
+	for i := 0; i < n; i++ {
+		m.byte(b)
+	}
+}
+
+// sync appends a mark with a missing clock bit (0x4489 for 0xA1, 0x5224 for
+// 0xC2), which cannot occur in normally encoded data.
+func (m *mfmWriter) sync(pattern uint16, n int) {
+	for i := 0; i < n; i++ {
+		for b := 15; b >= 0; b-- {
+			m.cell(byte(pattern >> b & 1))
+		}
+	}
+	m.prev = byte(pattern & 1)
+}
+
+// crc appends a CRC, deliberately damaged if bad is set so a CRC error
+// recorded in the image is reproduced.
+func (m *mfmWriter) crc(crc uint16, bad bool) {
+	if bad {
+		crc ^= 0xFFFF
+	}
+	m.byte(byte(crc >> 8))
+	m.byte(byte(crc))
+}
+
+// crc16 computes the CRC-CCITT (polynomial 0x1021, initial 0xFFFF) used by
+// the FDC over the marks and contents of ID and data fields.
+func crc16(p []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range p {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,89 @@
+package diskimg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/bits"
+	"testing"
+)
+
+// hfeSide extracts the MFM cells of one side of a cylinder from an HFE image,
+// most significant bit first.
+func hfeSide(t *testing.T, image []byte, cylinder, side int) []byte {
+	t.Helper()
+	entry := image[hfeBlockSize+cylinder*4:]
+	off := int(binary.LittleEndian.Uint16(entry)) * hfeBlockSize
+	length := int(binary.LittleEndian.Uint16(entry[2:])) / 2
+	cells := make([]byte, 0, length)
+	for i := 0; len(cells) < length; i++ {
+		half := image[off+i*hfeBlockSize+side*256:][:256]
+		cells = append(cells, half[:min(256, length-len(cells))]...)
+	}
+	for i, b := range cells {
+		cells[i] = bits.Reverse8(b)
+	}
+	return cells
+}
+
+// mfmDecode finds each field that follows three 0x4489 sync marks and returns
+// its first n decoded bytes.
+func mfmDecode(cells []byte, n int) [][]byte {
+	cell := func(i int) uint16 { return uint16(cells[i/8]>>(7-i%8)) & 1 }
+	var fields [][]byte
+	total := len(cells) * 8
+	for i := 0; i+48+n*16 <= total; i++ {
+		var w uint64
+		for j := 0; j < 48; j++ {
+			w = w<<1 | uint64(cell(i+j))
+		}
+		if w != 0x448944894489 {
+			continue
+		}
+		field := make([]byte, n)
+		for k := range field {
+			for b := 0; b < 8; b++ {
+				field[k] = field[k]<<1 | byte(cell(i+48+k*16+b*2+1))
+			}
+		}
+		fields = append(fields, field)
+		i += 47
+	}
+	return fields
+}
+
+// Sectors written to an HFE image decode back from the MFM bitstream with
+// their IDs, data and valid CRCs.
+func TestSaveHFE(t *testing.T) {
+	di := NewDiskImage()
+	sector := bytes.Repeat([]byte{0x00, 0xFF, 0xA1, 0x4E}, BytesPerSector/4)
+	if err := di.SetSectorData(5, 2, 0, sector); err != nil {
+		t.Fatalf("SetSectorData: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := di.SaveHFE(&buf); err != nil {
+		t.Fatalf("SaveHFE: %v", err)
+	}
+	image := buf.Bytes()
+	if string(image[:8]) != "HXCPICFE" || image[9] != TracksPerSide || image[10] != 1 {
+		t.Fatalf("header = % x", image[:16])
+	}
+
+	// Each field is its mark, up to 512 bytes of contents and a CRC.
+	fields := mfmDecode(hfeSide(t, image, 5, 0), 1+BytesPerSector+2)
+	if len(fields) != 2*SectorsPerTrack {
+		t.Fatalf("found %d fields, want %d", len(fields), 2*SectorsPerTrack)
+	}
+	id, data := fields[4], fields[5]
+	if id[0] != 0xFE || id[1] != 5 || id[3] != 3 || id[4] != 2 {
+		t.Errorf("ID field = % x, want track 5 sector 3", id[:7])
+	}
+	if crc16(append([]byte{0xA1, 0xA1, 0xA1}, id[:7]...)) != 0 {
+		t.Error("ID field CRC does not check")
+	}
+	if data[0] != 0xFB || !bytes.Equal(data[1:1+BytesPerSector], sector) {
+		t.Error("data field does not hold the sector")
+	}
+	if crc16(append([]byte{0xA1, 0xA1, 0xA1}, data...)) != 0 {
+		t.Error("data field CRC does not check")
+	}
+}
@@ -0,0 +1,96 @@
+// file: pkg/diskimg/hobeta.go
+
+package diskimg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// A Hobeta file (.$B, .$C and so on) is a single TR-DOS file on its own: a
+// 17-byte header, the file's 14-byte catalogue entry with the sector count
+// widened to a word and a checksum, then the file's sectors.
+const hobetaHeaderSize = 17
+
+// hobetaChecksum returns the checksum of the first 15 header bytes.
+func hobetaChecksum(h []byte) uint16 {
+	var sum uint16
+	for i, b := range h[:15] {
+		sum += uint16(b)*257 + uint16(i)
+	}
+	return sum
+}
+
+// LoadHobeta reads a Hobeta file.
+func LoadHobeta(r io.Reader) (*TRDOSFile, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Hobeta file: %w", err)
+	}
+	if len(data) < hobetaHeaderSize {
+		return nil, fmt.Errorf("%w: Hobeta header truncated", ErrCorruptImage)
+	}
+	h := data[:hobetaHeaderSize]
+	if binary.LittleEndian.Uint16(h[15:]) != hobetaChecksum(h) {
+		return nil, fmt.Errorf("%w: Hobeta checksum mismatch", ErrCorruptImage)
+	}
+	f := trdosFileFromEntry(h)
+	size := int(h[14]) * TRDOSSectorSize
+	body := data[hobetaHeaderSize:]
+	if len(body) < size {
+		return nil, fmt.Errorf("%w: Hobeta file %q truncated", ErrCorruptImage, f.Name)
+	}
+	f.Data = append([]byte(nil), body[:size]...)
+	return &f, nil
+}
+
+// SaveHobeta writes the file as a Hobeta file.
+func (f *TRDOSFile) SaveHobeta(w io.Writer) error {
+	if err := f.check(); err != nil {
+		return err
+	}
+	h := make([]byte, hobetaHeaderSize)
+	copy(h, f.entry()[:13])
+	h[14] = byte(f.sectors())
+	binary.LittleEndian.PutUint16(h[15:], hobetaChecksum(h))
+	data := append(h, f.Data...)
+	data = append(data, make([]byte, f.sectors()*TRDOSSectorSize-len(f.Data))...)
+	_, err := w.Write(data)
+	return err
+}
+
+// HobetaName returns the host name a Hobeta file is conventionally given:
+// the TR-DOS name, then ".$" and the file type, as in GAME.$C.
+func (f *TRDOSFile) HobetaName() string {
+	return fmt.Sprintf("%s.$%c", plus3Name(f.Name), f.Type)
+}
+
+// ImportHobeta copies a Hobeta file onto the disk, mapping its header and
+// numbering a name already taken as ImportTRDOS does, and returns the name it
+// was given.
+func (di *DiskImage) ImportHobeta(r io.Reader) (string, error) {
+	f, err := LoadHobeta(r)
+	if err != nil {
+		return "", err
+	}
+	name, err := di.importTRDOSFile(f, make(map[string]bool))
+	if err != nil {
+		return "", err
+	}
+	return name, di.FlushDirectory()
+}
+
+// ExportHobeta returns a file on the disk as a TR-DOS file, mapping its
+// header as ExportTRDOS does, ready for SaveHobeta.
+func (di *DiskImage) ExportHobeta(name string) (*TRDOSFile, error) {
+	e, err := di.directory.FindFile(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := di.trdosFile(e)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
@@ -0,0 +1,62 @@
+package diskimg
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Hobeta files carry one TR-DOS file each, which ImportHobeta and
+// ExportHobeta map to and from a headered +3DOS file.
+func TestHobetaRoundTrip(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	for _, f := range sampleTRDOS().Files {
+		var buf bytes.Buffer
+		if err := f.SaveHobeta(&buf); err != nil {
+			t.Fatal(err)
+		}
+		h := buf.Bytes()
+		if len(h) != 17+len(f.Data) || h[14] != byte(len(f.Data)/256) {
+			t.Errorf("%s: Hobeta file of %d bytes, sector count %d", f.Name, len(h), h[14])
+		}
+		sum := uint16(105) // 0+1+...+14
+		for _, b := range h[:15] {
+			sum += 257 * uint16(b)
+		}
+		if got := uint16(h[15]) | uint16(h[16])<<8; got != sum {
+			t.Errorf("%s: checksum %#04x, want %#04x", f.Name, got, sum)
+		}
+
+		name, err := di.ImportHobeta(bytes.NewReader(h))
+		if err != nil {
+			t.Fatal(err)
+		}
+		back, err := di.ExportHobeta(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if back.Type != f.Type || back.Start != f.Start || back.Length != f.Length || !bytes.Equal(back.Data, f.Data) {
+			t.Errorf("%s: came back as %c start %d length %d", name, back.Type, back.Start, back.Length)
+		}
+	}
+	if fi, err := di.StatFile("BOOT.BAS"); err != nil || fi.Line != 10 {
+		t.Errorf("BOOT.BAS: %+v, %v; want LINE 10", fi, err)
+	}
+	if fi, err := di.StatFile("GAME.BIN"); err != nil || fi.LoadAddress != 0x8000 {
+		t.Errorf("GAME.BIN: load address %d, %v; want 32768", fi.LoadAddress, err)
+	}
+	if got := sampleTRDOS().Files[1].HobetaName(); got != "GAME.$C" {
+		t.Errorf("HobetaName = %q, want GAME.$C", got)
+	}
+
+	var buf bytes.Buffer
+	sampleTRDOS().Files[1].SaveHobeta(&buf)
+	bad := buf.Bytes()
+	bad[15]++
+	if _, err := LoadHobeta(bytes.NewReader(bad)); err == nil {
+		t.Error("LoadHobeta with a bad checksum succeeded")
+	}
+	bad[15]--
+	if _, err := LoadHobeta(bytes.NewReader(bad[:100])); err == nil {
+		t.Error("LoadHobeta of a truncated file succeeded")
+	}
+}
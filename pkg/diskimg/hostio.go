@@ -3,12 +3,9 @@
 package diskimg
 
 import (
-	"errors"
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
-	"strings"
 )
 
 // ImportOptions configures file import behavior
@@ -17,71 +14,160 @@ type ImportOptions struct {
 	FileType  byte   // BASIC/CODE/etc for header
 	LoadAddr  uint16 // Load address for CODE files
 	Line      uint16 // LINE parameter for BASIC
+	VarName   byte   // Variable name of an array, as the header stores it
 }
 
-// ImportFile imports a file from the host filesystem into the disk image
+// maxImportSize is the largest file +3DOS can hold, PLUS3DOS header included.
+const maxImportSize = 8 * 1024 * 1024
+
+// ImportFile imports a file from the host filesystem into the disk image. The
+// file is copied a block at a time, so memory use does not grow with its size,
+// after checking that it fits: within the 8MB +3DOS allows, header included,
+// and in the space free on the disk, counting that of a file of the same name
+// it replaces. A file that does not fit leaves the disk as it was.
 func (di *DiskImage) ImportFile(hostPath string, diskPath string, opts *ImportOptions) error {
-	// Open source file
+	diskPath, err := di.diskName(diskPath)
+	if err != nil {
+		return fileError("import", diskPath, err)
+	}
 	src, err := os.Open(hostPath)
 	if err != nil {
 		return err
 	}
 	defer src.Close()
-
-	// Get file size
 	info, err := src.Stat()
 	if err != nil {
 		return err
 	}
 
-	// Validate size
-	maxSize := 8 * 1024 * 1024 // 8MB max file size
-	if info.Size() > int64(maxSize) {
-		return errors.New("file too large for +3DOS (max 8MB)")
+	var header *Plus3DosHeader
+	total := info.Size()
+	if opts != nil && opts.AddHeader {
+		if header, err = importHeader(int(info.Size()), opts); err != nil {
+			return err
+		}
+		total += HeaderSize
+	}
+	if total > maxImportSize {
+		return fmt.Errorf("%w: %s is %d bytes, +3DOS allows 8MB", ErrFileTooLarge, hostPath, total)
+	}
+	if err := di.checkSpace("import", diskPath, int(total)); err != nil {
+		return err
 	}
 
-	// Create destination file
-	dst, err := di.OpenFile(diskPath, true)
+	dst, err := di.OpenFile(diskPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
 	if err != nil {
 		return err
 	}
 	defer dst.Close()
+	dst.modTime = info.ModTime() // stamped on a disk with datestamps
+	w := &progressWriter{w: dst, di: di, stage: StageImport, file: diskPath, total: total}
+	if header != nil {
+		if _, err := w.Write(header.toBytes()); err != nil {
+			return fileError("import", diskPath, err)
+		}
+	}
 
-	// Add header if requested
-	if opts != nil && opts.AddHeader {
-		header := NewPlus3DosHeader()
-		switch opts.FileType {
-		case FileTypeProgram:
-			err = header.SetBasicHeader(FileTypeProgram, uint16(info.Size()), opts.Line, uint16(info.Size()))
-		case FileTypeCode:
-			err = header.SetBasicHeader(FileTypeCode, uint16(info.Size()), opts.LoadAddr, 0)
-		default:
-			err = errors.New("unsupported file type for header")
+	// Copy no more than the size the header and the space check were for.
+	n, err := io.CopyBuffer(w, io.LimitReader(src, info.Size()), make([]byte, di.spec.BlockSize))
+	if err == nil && n < info.Size() {
+		err = fmt.Errorf("%w: %s shrank while being imported", io.ErrUnexpectedEOF, hostPath)
+	}
+	if err != nil {
+		return fileError("import", diskPath, err)
+	}
+	if w.done == 0 {
+		di.report(StageImport, diskPath, 0, 0)
+	}
+	return nil
+}
+
+// checkSpace returns an error, ErrDiskFull or ErrDirectoryFull, unless a file
+// of size bytes named name fits on the disk: in free blocks and in free
+// directory entries, one for each extent, counting those of the file of that
+// name it would replace. Writers check first, so a file that does not fit
+// leaves the disk as it was.
+func (di *DiskImage) checkSpace(op, name string, size int) error {
+	freeBlocks, freeEntries := di.spaceFor(name)
+	bs, per := di.spec.BlockSize, di.spec.entryBlocks()
+	blocks := (size + bs - 1) / bs
+	entries := max(1, (blocks+per-1)/per)
+	if blocks <= freeBlocks && entries <= freeEntries {
+		return nil
+	}
+	full := ErrDiskFull
+	if blocks <= freeBlocks {
+		full = ErrDirectoryFull
+	}
+	return fileError(op, name, fmt.Errorf("%w: needs %dK, %dK free; needs %d directory %s, %d free",
+		full, blocks*bs/1024, freeBlocks*bs/1024, entries, plural(entries, "entry", "entries"), freeEntries))
+}
+
+// spaceFor returns the blocks and directory entries a file named name can
+// take: those free, and those of the file of that name it would replace.
+func (di *DiskImage) spaceFor(name string) (blocks, entries int) {
+	blocks = di.fileAlloc.GetFreeBlocks()
+	for i := range di.directory.Entries {
+		if di.directory.Entries[i].IsUnused() {
+			entries++
 		}
-		if err != nil {
-			return err
+	}
+	if e, err := di.directory.FindFile(name); err == nil {
+		for _, x := range di.directory.fileExtents(e) {
+			entries++
+			blocks += len(allocated(x.blockSlots(di.spec.WideBlockPointers())))
 		}
+	}
+	return blocks, entries
+}
 
-		// The PLUS3DOS header's FileLength is the TOTAL on-disk length: the
-		// 128-byte header record plus the data. Set it and the checksum before
-		// writing, otherwise +3DOS sees a zero-length / invalid header.
-		header.FileLength = uint32(HeaderSize) + uint32(info.Size())
-		header.UpdateChecksum()
+// plural returns one if n is 1, otherwise many.
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
 
-		headerData := header.toBytes()
-		_, err = dst.Write(headerData)
+// ImportData stores data as a file on the disk, as ImportFile does for a host
+// file: with a PLUS3DOS header if opts asks for one. An existing file of that
+// name is replaced. The directory is flushed.
+func (di *DiskImage) ImportData(diskPath string, data []byte, opts *ImportOptions) error {
+	if opts != nil && opts.AddHeader {
+		header, err := importHeader(len(data), opts)
 		if err != nil {
 			return err
 		}
+		data = append(header.toBytes(), data...)
 	}
+	return di.WriteFile(diskPath, data)
+}
 
-	// Copy file data
-	_, err = io.Copy(dst, src)
+// importHeader returns the PLUS3DOS header ImportFile writes for a file of
+// size bytes.
+func importHeader(size int, opts *ImportOptions) (*Plus3DosHeader, error) {
+	header := NewPlus3DosHeader()
+	var err error
+	switch opts.FileType {
+	case FileTypeProgram:
+		err = header.SetBasicHeader(FileTypeProgram, uint16(size), opts.Line, uint16(size))
+	case FileTypeCode:
+		err = header.SetBasicHeader(FileTypeCode, uint16(size), opts.LoadAddr, 0)
+	case FileTypeNumericArray, FileTypeCharArray:
+		err = header.SetBasicHeader(opts.FileType, uint16(size), uint16(opts.VarName), 0)
+	default:
+		err = fmt.Errorf("%w: file type %d for a header", ErrUnsupported, opts.FileType)
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	// The PLUS3DOS header's FileLength is the TOTAL on-disk length: the
+	// 128-byte header record plus the data. Set it and the checksum before
+	// writing, otherwise +3DOS sees a zero-length / invalid header.
+	header.FileLength = uint32(HeaderSize) + uint32(size)
+	header.UpdateChecksum()
+	return header, nil
 }
 
 // ImportBasicProgram imports an already-tokenised BASIC program with the
@@ -89,14 +175,7 @@ func (di *DiskImage) ImportFile(hostPath string, diskPath string, opts *ImportOp
 // ImportBasicText (or the add command's source mode) to tokenise plain-text
 // BASIC source instead.
 func (di *DiskImage) ImportBasicProgram(hostPath string, line uint16) error {
-	// Determine destination filename
-	base := filepath.Base(hostPath)
-	ext := filepath.Ext(base)
-	name := strings.TrimSuffix(base, ext)
-	if len(name) > 8 {
-		name = name[:8]
-	}
-	diskPath := name + ".BAS"
+	diskPath := HostName(hostPath, "BAS")
 
 	opts := &ImportOptions{
 		AddHeader: true,
@@ -110,13 +189,7 @@ func (di *DiskImage) ImportBasicProgram(hostPath string, line uint16) error {
 // ImportBasicText tokenises plain-text BASIC source and imports it as a BASIC
 // program with the appropriate PLUS3DOS header.
 func (di *DiskImage) ImportBasicText(hostPath string, line uint16) error {
-	base := filepath.Base(hostPath)
-	ext := filepath.Ext(base)
-	name := strings.TrimSuffix(base, ext)
-	if len(name) > 8 {
-		name = name[:8]
-	}
-	diskPath := name + ".BAS"
+	diskPath := HostName(hostPath, "BAS")
 
 	data, err := os.ReadFile(hostPath)
 	if err != nil {
@@ -132,7 +205,10 @@ func (di *DiskImage) ImportBasicText(hostPath string, line uint16) error {
 // importBasicBytes writes already-tokenised BASIC bytes to the disk with a
 // PLUS3DOS BASIC header.
 func (di *DiskImage) importBasicBytes(diskPath string, data []byte, line uint16) error {
-	dst, err := di.OpenFile(diskPath, true)
+	if err := di.checkSpace("import", diskPath, HeaderSize+len(data)); err != nil {
+		return err
+	}
+	dst, err := di.OpenFile(diskPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
 	if err != nil {
 		return err
 	}
@@ -161,7 +237,7 @@ func (di *DiskImage) importBasicBytes(diskPath string, data []byte, line uint16)
 // authoritative type signal (the file's own header), used to warn when a BASIC
 // program is about to be extracted as raw bytes rather than detokenised.
 func (di *DiskImage) IsBasicProgram(diskPath string) bool {
-	f, err := di.OpenFile(diskPath, false)
+	f, err := di.OpenFile(diskPath, os.O_RDONLY)
 	if err != nil {
 		return false
 	}
@@ -169,16 +245,25 @@ func (di *DiskImage) IsBasicProgram(diskPath string) bool {
 	return f.isHeadered && f.header != nil && f.header.HeaderData[0] == FileTypeProgram
 }
 
+// ReadHeader returns the PLUS3DOS header of the named file on the disk. A file
+// without a (valid) header returns a nil header and a nil error; an error is
+// returned only if the file cannot be opened.
+func (di *DiskImage) ReadHeader(diskPath string) (*Plus3DosHeader, error) {
+	f, err := di.OpenFile(diskPath, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if !f.isHeadered {
+		return nil, nil
+	}
+	h := *f.header
+	return &h, nil
+}
+
 // ImportCode imports binary/CODE file with load address
 func (di *DiskImage) ImportCode(hostPath string, loadAddr uint16) error {
-	// Determine destination filename
-	base := filepath.Base(hostPath)
-	ext := filepath.Ext(base)
-	name := strings.TrimSuffix(base, ext)
-	if len(name) > 8 {
-		name = name[:8]
-	}
-	diskPath := name + ".BIN"
+	diskPath := HostName(hostPath, "BIN")
 
 	opts := &ImportOptions{
 		AddHeader: true,
@@ -197,17 +282,10 @@ func (di *DiskImage) ImportScreen(hostPath string) error {
 		return err
 	}
 	if info.Size() != 6912 {
-		return errors.New("invalid screen$ file size (must be 6912 bytes)")
+		return fmt.Errorf("%w: a SCREEN$ is 6912 bytes", ErrWrongFileType)
 	}
 
-	// Determine destination filename
-	base := filepath.Base(hostPath)
-	ext := filepath.Ext(base)
-	name := strings.TrimSuffix(base, ext)
-	if len(name) > 8 {
-		name = name[:8]
-	}
-	diskPath := name + ".SCR"
+	diskPath := HostName(hostPath, "SCR")
 
 	opts := &ImportOptions{
 		AddHeader: true,
@@ -220,16 +298,12 @@ func (di *DiskImage) ImportScreen(hostPath string) error {
 
 // ImportRaw imports a file without any header or conversion
 func (di *DiskImage) ImportRaw(hostPath string) error {
-	base := filepath.Base(hostPath)
-	if len(base) > 12 { // 8+1+3
-		base = base[:12]
-	}
-	return di.ImportFile(hostPath, base, nil)
+	return di.ImportFile(hostPath, HostName(hostPath, ""), nil)
 }
 
 // ExportFile exports a file from the disk image to the host filesystem
 func (di *DiskImage) ExportFile(diskPath, hostPath string, stripHeader bool) error {
-	src, err := di.OpenFile(diskPath, false)
+	src, err := di.OpenFile(diskPath, os.O_RDONLY)
 	if err != nil {
 		return err
 	}
@@ -240,33 +314,38 @@ func (di *DiskImage) ExportFile(diskPath, hostPath string, stripHeader bool) err
 		return err
 	}
 	defer dst.Close()
+	w := &progressWriter{w: dst, di: di, stage: StageExport, file: diskPath, total: src.size}
 
 	if stripHeader && src.isHeadered {
 		_, err = src.Seek(HeaderSize, io.SeekStart)
 		if err != nil {
 			return err
 		}
+		w.total -= HeaderSize
 	}
 
-	_, err = io.Copy(dst, src)
+	_, err = io.Copy(w, src)
+	if err == nil && w.done == 0 {
+		di.report(StageExport, diskPath, 0, 0)
+	}
 	return err
 }
 
 // ExportScreen exports a screen$ file, validating size and format
 func (di *DiskImage) ExportScreen(diskPath, hostPath string) error {
-	f, err := di.OpenFile(diskPath, false)
+	f, err := di.OpenFile(diskPath, os.O_RDONLY)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
 	if !f.isHeadered {
-		return errors.New("not a valid screen$ file (no header)")
+		return fileError("export", diskPath, fmt.Errorf("%w: not a SCREEN$ (no header)", ErrWrongFileType))
 	}
 
 	fileType, size, loadAddr, _ := f.header.GetBasicHeader()
 	if fileType != FileTypeCode || size != 6912 || loadAddr != 16384 {
-		return errors.New("not a valid screen$ file")
+		return fileError("export", diskPath, fmt.Errorf("%w: not a SCREEN$", ErrWrongFileType))
 	}
 
 	return di.ExportFile(diskPath, hostPath, true)
@@ -274,19 +353,19 @@ func (di *DiskImage) ExportScreen(diskPath, hostPath string) error {
 
 // ExtractBasic exports a BASIC program, stripping the header
 func (di *DiskImage) ExtractBasic(diskPath, hostPath string) error {
-	f, err := di.OpenFile(diskPath, false)
+	f, err := di.OpenFile(diskPath, os.O_RDONLY)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
 	if !f.isHeadered {
-		return errors.New("not a BASIC program (no header)")
+		return fileError("export", diskPath, fmt.Errorf("%w: not a BASIC program (no header)", ErrWrongFileType))
 	}
 
 	fileType, _, _, _ := f.header.GetBasicHeader()
 	if fileType != FileTypeProgram {
-		return errors.New("not a BASIC program")
+		return fileError("export", diskPath, fmt.Errorf("%w: not a BASIC program", ErrWrongFileType))
 	}
 
 	return di.ExportFile(diskPath, hostPath, true)
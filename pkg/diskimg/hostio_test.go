@@ -0,0 +1,101 @@
+package diskimg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// ImportFile checks that a file fits before it writes any of it, counting
+// the file it replaces, and copies it a block at a time.
+func TestImportFileStreaming(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	dir := t.TempDir()
+	host := func(name string, size int) string {
+		t.Helper()
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, bytes.Repeat([]byte{0x42}, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	free := di.fileSpace()
+	big := host("big.bin", free-HeaderSize)
+	var chunks []int64
+	di.SetProgress(func(e ProgressEvent) { chunks = append(chunks, e.Done) })
+	opts := &ImportOptions{AddHeader: true, FileType: FileTypeCode, LoadAddr: 32768}
+	if err := di.ImportFile(big, "BIG.BIN", opts); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(chunks); i++ {
+		if step := chunks[i] - chunks[i-1]; step > int64(di.spec.BlockSize) {
+			t.Fatalf("copied %d bytes at once, want at most a block", step)
+		}
+	}
+	di.SetProgress(nil)
+	if size, err := di.FileSize("BIG.BIN"); err != nil || size != free {
+		t.Errorf("BIG.BIN is %d bytes, %v; want %d", size, err, free)
+	}
+
+	// The disk is full, but the file can replace itself.
+	if err := di.ImportFile(big, "BIG.BIN", opts); err != nil {
+		t.Errorf("replacing a file that fills the disk: %v", err)
+	}
+	if err := di.ImportFile(host("one.bin", 1), "ONE.BIN", nil); !errors.Is(err, ErrDiskFull) {
+		t.Errorf("full disk: err = %v, want ErrDiskFull", err)
+	}
+	if _, err := di.StatFile("ONE.BIN"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("a file that does not fit was created: %v", err)
+	}
+
+	// The header counts towards the 8MB limit.
+	huge := host("huge.bin", maxImportSize-HeaderSize+1)
+	if err := newSpecImage(t, SpecPlus3).ImportFile(huge, "HUGE.BIN", opts); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("8MB with its header: err = %v, want ErrFileTooLarge", err)
+	}
+}
+
+// A file that does not fit, in blocks or in directory entries, is refused
+// before anything is written, with what it needs and what is free.
+func TestCheckSpace(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	save := func() []byte {
+		var buf bytes.Buffer
+		if err := di.Save(&buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	for i := 0; i < 63; i++ {
+		if err := di.ImportData(fmt.Sprintf("F%d.TXT", i), []byte("x"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := save()
+	free := di.SpaceInfo().Free.Blocks
+
+	// 20K takes two entries, one more than is free.
+	err := di.ImportData("BIG.BIN", make([]byte, 20*1024), nil)
+	want := fmt.Sprintf("needs 20K, %dK free; needs 2 directory entries, 1 free", free)
+	if !errors.Is(err, ErrDirectoryFull) || !strings.Contains(err.Error(), want) {
+		t.Errorf("20K file: err = %v, want %q", err, want)
+	}
+	err = di.ImportData("HUGE.BIN", make([]byte, 150*1024), &ImportOptions{AddHeader: true, FileType: FileTypeCode})
+	want = fmt.Sprintf("needs 151K, %dK free; needs 10 directory entries, 1 free", free)
+	if !errors.Is(err, ErrDiskFull) || !strings.Contains(err.Error(), want) {
+		t.Errorf("150K file: err = %v, want %q", err, want)
+	}
+	if !bytes.Equal(save(), before) {
+		t.Error("a file that does not fit changed the disk")
+	}
+
+	// Replacing a file counts the entry it frees.
+	if err := di.ImportData("F0.TXT", make([]byte, 20*1024), nil); err != nil {
+		t.Errorf("replacing F0.TXT with 20K: %v", err)
+	}
+}
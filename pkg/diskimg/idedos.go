@@ -0,0 +1,297 @@
+// file: pkg/diskimg/idedos.go
+
+package diskimg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"strings"
+)
+
+// +3e hard disks and CF cards are partitioned by IDEDOS and usually kept as
+// HDF images: a header ("RS-IDE", 0x1A, revision, flags, data offset) and the
+// drive's sectors. In a "halved" image each 512-byte sector is stored as the
+// 256 bytes an 8-bit interface sees. The first partition is the IDEDOS system
+// partition, named "PLUSIDEDOS", whose sectors hold the partition table: one
+// 64-byte entry per partition with its name, type, first and last cylinder
+// and head, and type-specific data (the drive geometry for the system
+// partition, the XDPB for a +3DOS partition).
+const (
+	hdfSignature      = "RS-IDE\x1a"
+	idedosEntrySize   = 64
+	idedosSystemName  = "PLUSIDEDOS"
+	idedosSpecName    = "idedos"
+	PartitionSystem   = 0x01
+	PartitionSwap     = 0x02
+	PartitionPlus3DOS = 0x03
+	PartitionBad      = 0xFE
+	PartitionFree     = 0xFF
+)
+
+// HDFImage is a +3e hard disk image.
+type HDFImage struct {
+	Revision byte // HDF revision: 0x10 or 0x11
+	Halved   bool // sectors hold 256 bytes (8-bit interface)
+
+	header []byte // header bytes, up to the data offset
+	data   []byte // the drive's sectors
+}
+
+// Partition is an entry of the IDEDOS partition table.
+type Partition struct {
+	Index       int    // position in the partition table
+	Name        string // up to 16 characters
+	Type        byte   // PartitionSystem, PartitionPlus3DOS, ...
+	StartSector int    // first logical sector of the partition
+	Sectors     int    // number of sectors
+	params      [32]byte
+}
+
+// TypeName returns a short description of the partition type.
+func (p Partition) TypeName() string {
+	switch p.Type {
+	case PartitionSystem:
+		return "system"
+	case PartitionSwap:
+		return "swap"
+	case PartitionPlus3DOS:
+		return "+3DOS"
+	case PartitionBad:
+		return "bad"
+	case PartitionFree:
+		return "free"
+	}
+	return fmt.Sprintf("type %#02x", p.Type)
+}
+
+// IsHDFImage reports whether data starts with an HDF header.
+func IsHDFImage(data []byte) bool {
+	return len(data) >= 11 && string(data[:7]) == hdfSignature
+}
+
+// LoadHDF reads an HDF hard disk image.
+func LoadHDF(r io.Reader) (*HDFImage, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HDF image: %w", err)
+	}
+	if !IsHDFImage(raw) {
+		return nil, fmt.Errorf("%w: not an HDF image", ErrCorruptImage)
+	}
+	off := int(binary.LittleEndian.Uint16(raw[9:]))
+	if off < 11 || off > len(raw) {
+		return nil, fmt.Errorf("%w: invalid HDF data offset %d", ErrCorruptImage, off)
+	}
+	return &HDFImage{
+		Revision: raw[7],
+		Halved:   raw[8]&0x01 != 0,
+		header:   raw[:off],
+		data:     raw[off:],
+	}, nil
+}
+
+// Save writes the HDF image.
+func (h *HDFImage) Save(w io.Writer) error {
+	if _, err := w.Write(h.header); err != nil {
+		return err
+	}
+	_, err := w.Write(h.data)
+	return err
+}
+
+// SectorSize returns the number of bytes stored per sector.
+func (h *HDFImage) SectorSize() int {
+	if h.Halved {
+		return 256
+	}
+	return 512
+}
+
+// Partitions reads the IDEDOS partition table, skipping unused entries.
+func (h *HDFImage) Partitions() ([]Partition, error) {
+	size := h.SectorSize()
+	if len(h.data) < size {
+		return nil, fmt.Errorf("%w: HDF image has no sectors", ErrCorruptImage)
+	}
+	first := h.data[:idedosEntrySize]
+	if strings.TrimRight(string(first[:16]), " ") != idedosSystemName || first[16] != PartitionSystem {
+		return nil, fmt.Errorf("%w: no IDEDOS partition table", ErrCorruptImage)
+	}
+	heads := int(first[34])
+	spt := int(first[35])
+	maxParts := int(binary.LittleEndian.Uint16(first[38:]))
+	if heads == 0 || spt == 0 {
+		return nil, fmt.Errorf("%w: invalid IDEDOS drive geometry", ErrCorruptImage)
+	}
+	lba := func(e []byte, at int) int {
+		return (int(binary.LittleEndian.Uint16(e[at:]))*heads + int(e[at+2])) * spt
+	}
+
+	var parts []Partition
+	for i := 0; i < maxParts && (i+1)*idedosEntrySize <= len(h.data); i++ {
+		e := h.data[i*idedosEntrySize : (i+1)*idedosEntrySize]
+		if e[16] == 0 {
+			continue // unused entry
+		}
+		p := Partition{
+			Index:       i,
+			Name:        strings.TrimRight(string(e[:16]), " \x00"),
+			Type:        e[16],
+			StartSector: lba(e, 17),
+			Sectors:     int(binary.LittleEndian.Uint32(e[23:])) + 1,
+		}
+		copy(p.params[:], e[32:])
+		if (p.StartSector+p.Sectors)*size > len(h.data) {
+			return nil, fmt.Errorf("%w: partition %q extends past the end of the image", ErrCorruptImage, p.Name)
+		}
+		parts = append(parts, p)
+	}
+	return parts, nil
+}
+
+// FindPartition returns the partition with the given name (case-insensitive),
+// or the first +3DOS partition if name is empty.
+func (h *HDFImage) FindPartition(name string) (Partition, error) {
+	parts, err := h.Partitions()
+	if err != nil {
+		return Partition{}, err
+	}
+	for _, p := range parts {
+		if name == "" && p.Type == PartitionPlus3DOS || name != "" && strings.EqualFold(p.Name, name) {
+			return p, nil
+		}
+	}
+	if name == "" {
+		return Partition{}, fmt.Errorf("%w: no +3DOS partition", ErrFileNotFound)
+	}
+	return Partition{}, fmt.Errorf("%w: partition %s", ErrFileNotFound, name)
+}
+
+// partitionSpec describes a +3DOS partition as a DiskSpec, from its XDPB.
+// The partition's sectors are grouped into larger virtual sectors and tracks
+// so that they fit the track model of DiskImage; CP/M only sees the linear
+// order of records, which is unchanged. It also returns the number of blocks
+// CP/M may use (DSM + 1).
+func (h *HDFImage) partitionSpec(p Partition) (DiskSpec, int, error) {
+	x := p.params[:]
+	spt := int(binary.LittleEndian.Uint16(x[0:])) // records per track
+	bsh := int(x[2])
+	dsm := int(binary.LittleEndian.Uint16(x[5:]))
+	al := int(x[9])<<8 | int(x[10])
+	off := int(binary.LittleEndian.Uint16(x[13:]))
+	if bsh < 3 || bsh > 7 || spt == 0 || al == 0 {
+		return DiskSpec{}, 0, fmt.Errorf("%w: partition %q has an invalid XDPB", ErrCorruptImage, p.Name)
+	}
+
+	blockSize := 128 << bsh
+	sectorSize := min(blockSize, 8192)
+	reserved := off * spt * 128 // bytes before block 0
+	if reserved%sectorSize != 0 {
+		sectorSize = h.SectorSize()
+	}
+	sectors := reserved/sectorSize + (dsm+1)*(blockSize/sectorSize)
+	if sectors*sectorSize > p.Sectors*h.SectorSize() {
+		return DiskSpec{}, 0, fmt.Errorf("%w: partition %q is smaller than its XDPB", ErrCorruptImage, p.Name)
+	}
+
+	// Prefer a geometry holding exactly the blocks CP/M uses; otherwise round
+	// up to whole tracks and keep the extra blocks out of use.
+	best := 0
+	for perTrack := 29; perTrack >= 1; perTrack-- {
+		if reserved/sectorSize%perTrack != 0 || (sectors+perTrack-1)/perTrack > 255 {
+			continue
+		}
+		if sectors%perTrack == 0 {
+			best = perTrack
+			break
+		}
+		if best == 0 {
+			best = perTrack
+		}
+	}
+	if best == 0 {
+		return DiskSpec{}, 0, fmt.Errorf("%w: partition %q is too large to open", ErrUnsupported, p.Name)
+	}
+	s := DiskSpec{
+		Name:            idedosSpecName,
+		Sides:           1,
+		TracksPerSide:   (sectors + best - 1) / best,
+		SectorsPerTrack: best,
+		SectorSize:      sectorSize,
+		FirstSectorID:   1,
+		Sidedness:       SidesSingle,
+		ReservedTracks:  reserved / sectorSize / best,
+		BlockSize:       blockSize,
+		DirBlocks:       bits.OnesCount16(uint16(al)),
+		GapRW:           SpecPlus3.GapRW,
+		GapFormat:       SpecPlus3.GapFormat,
+	}
+	if err := s.Validate(); err != nil {
+		return DiskSpec{}, 0, fmt.Errorf("partition %q: %w", p.Name, err)
+	}
+	if s.WideBlockPointers() != (dsm > 255) {
+		return DiskSpec{}, 0, fmt.Errorf("%w: partition %q cannot be opened: its size does not map to whole tracks", ErrUnsupported, p.Name)
+	}
+	return s, dsm + 1, nil
+}
+
+// OpenPartition returns a +3DOS partition as a disk image, so its files can
+// be listed, read and written like those of a floppy. Changes are copied back
+// with StorePartition.
+func (h *HDFImage) OpenPartition(p Partition) (*DiskImage, error) {
+	if p.Type != PartitionPlus3DOS {
+		return nil, fmt.Errorf("%w: partition %q is a %s partition, not +3DOS", ErrUnsupported, p.Name, p.TypeName())
+	}
+	spec, blocks, err := h.partitionSpec(p)
+	if err != nil {
+		return nil, err
+	}
+	di, err := NewDiskImageWithSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	part := h.partitionData(p)
+	for n := 0; n*spec.SectorSize < len(part) && n < spec.TotalTracks()*spec.SectorsPerTrack; n++ {
+		sector := make([]byte, spec.SectorSize)
+		copy(sector, part[n*spec.SectorSize:])
+		if err := di.SetSectorData(n/spec.SectorsPerTrack, n%spec.SectorsPerTrack, 0, sector); err != nil {
+			return nil, err
+		}
+	}
+	di.fileAlloc.reserveBlocksFrom(blocks)
+	di.loadDirectory()
+	di.Modified = false
+	return di, nil
+}
+
+// StorePartition copies a disk image opened with OpenPartition back into the
+// partition.
+func (h *HDFImage) StorePartition(p Partition, di *DiskImage) error {
+	spec, _, err := h.partitionSpec(p)
+	if err != nil {
+		return err
+	}
+	if di.Spec() != spec {
+		return fmt.Errorf("%w: disk image does not have the format of partition %q", ErrInvalidSpec, p.Name)
+	}
+	if err := di.FlushDirectory(); err != nil {
+		return err
+	}
+	part := h.partitionData(p)
+	for n := 0; n*spec.SectorSize < len(part) && n < spec.TotalTracks()*spec.SectorsPerTrack; n++ {
+		sector, err := di.GetSectorData(n/spec.SectorsPerTrack, n%spec.SectorsPerTrack, 0)
+		if err != nil {
+			return err
+		}
+		copy(part[n*spec.SectorSize:], sector)
+	}
+	return nil
+}
+
+// partitionData returns the bytes of a partition within the image.
+func (h *HDFImage) partitionData(p Partition) []byte {
+	size := h.SectorSize()
+	return h.data[p.StartSector*size : (p.StartSector+p.Sectors)*size]
+}
@@ -0,0 +1,123 @@
+package diskimg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"testing"
+)
+
+// sampleHDF returns a 1 MB HDF image (32 cylinders, 2 heads, 32 sectors)
+// with the IDEDOS system partition on the first track and an empty 240K
+// +3DOS partition, GAMES, with 2K blocks.
+func sampleHDF() []byte {
+	const dataOffset = 0x80
+	img := make([]byte, dataOffset+32*2*32*512)
+	copy(img, hdfSignature)
+	img[7] = 0x10
+	binary.LittleEndian.PutUint16(img[9:], dataOffset)
+	data := img[dataOffset:]
+
+	sys := data[0:idedosEntrySize]
+	copy(sys, "PLUSIDEDOS      ")
+	sys[16] = PartitionSystem
+	binary.LittleEndian.PutUint32(sys[23:], 31)
+	binary.LittleEndian.PutUint16(sys[32:], 32) // cylinders
+	sys[34], sys[35] = 2, 32                    // heads, sectors per track
+	binary.LittleEndian.PutUint16(sys[36:], 64) // sectors per cylinder
+	binary.LittleEndian.PutUint16(sys[38:], 8)  // partition entries
+
+	games := data[idedosEntrySize : 2*idedosEntrySize]
+	copy(games, "GAMES           ")
+	games[16] = PartitionPlus3DOS
+	games[19] = 1                                // start: cylinder 0 head 1
+	binary.LittleEndian.PutUint16(games[20:], 7) // end: cylinder 7 head 1
+	games[22] = 1
+	binary.LittleEndian.PutUint32(games[23:], 15*32-1)
+	xdpb := games[32:]
+	binary.LittleEndian.PutUint16(xdpb[0:], 128) // records per track
+	xdpb[2], xdpb[3], xdpb[4] = 4, 15, 1         // BSH, BLM, EXM
+	binary.LittleEndian.PutUint16(xdpb[5:], 119) // DSM
+	binary.LittleEndian.PutUint16(xdpb[7:], 127) // DRM
+	xdpb[9], xdpb[10] = 0xC0, 0x00               // AL0, AL1
+	xdpb[15], xdpb[16] = 2, 3                    // PSH, PHM
+
+	part := data[32*512 : 32*512+15*32*512]
+	for i := range part {
+		part[i] = 0xE5
+	}
+	return img
+}
+
+// A file written to a +3DOS partition is stored in the partition's sectors
+// and reads back from the saved image.
+func TestHDFPartition(t *testing.T) {
+	h, err := LoadHDF(bytes.NewReader(sampleHDF()))
+	if err != nil {
+		t.Fatalf("LoadHDF: %v", err)
+	}
+	parts, err := h.Partitions()
+	if err != nil {
+		t.Fatalf("Partitions: %v", err)
+	}
+	if len(parts) != 2 || parts[1].Name != "GAMES" || parts[1].StartSector != 32 || parts[1].Sectors != 480 {
+		t.Fatalf("partitions = %+v", parts)
+	}
+
+	p, err := h.FindPartition("")
+	if err != nil {
+		t.Fatalf("FindPartition: %v", err)
+	}
+	di, err := h.OpenPartition(p)
+	if err != nil {
+		t.Fatalf("OpenPartition: %v", err)
+	}
+	if got := di.Spec().TotalBlocks(); got != 120 {
+		t.Errorf("TotalBlocks = %d, want 120", got)
+	}
+	data := bytes.Repeat([]byte("+3e partition "), 700)
+	f, err := di.OpenFile("DATA.BIN", os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := h.StorePartition(p, di); err != nil {
+		t.Fatalf("StorePartition: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := h.Save(&buf); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if got := buf.Bytes()[0x80+32*512+1]; got != 'D' {
+		t.Errorf("partition's first directory entry starts %q, want DATA", got)
+	}
+	h, err = LoadHDF(&buf)
+	if err != nil {
+		t.Fatalf("LoadHDF: %v", err)
+	}
+	if di, err = h.OpenPartition(p); err != nil {
+		t.Fatalf("OpenPartition: %v", err)
+	}
+	r, err := di.OpenFile("DATA.BIN", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(got[:len(data)], data) {
+		t.Error("file contents differ after a reload")
+	}
+
+	if _, err := h.OpenPartition(parts[0]); err == nil {
+		t.Error("OpenPartition opened the system partition")
+	}
+}
@@ -0,0 +1,117 @@
+// file: pkg/diskimg/inplace.go
+
+package diskimg
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// fileOrigin is the image file a disk was loaded from or last saved to, as
+// it was then.
+type fileOrigin struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// setOrigin records filename as the disk's file, with no tracks dirty.
+func (di *DiskImage) setOrigin(filename string) {
+	di.dirty = nil
+	di.origin = nil
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return
+	}
+	if info, err := os.Stat(path); err == nil {
+		di.origin = &fileOrigin{path: path, size: info.Size(), modTime: info.ModTime()}
+	}
+}
+
+// markDirty records that the track at idx differs from the disk's file.
+func (di *DiskImage) markDirty(idx int) {
+	if len(di.dirty) != len(di.Tracks) {
+		di.dirty = append(di.dirty, make([]bool, len(di.Tracks)-len(di.dirty))...)
+	}
+	di.dirty[idx] = true
+}
+
+// saveInPlace writes the dirty tracks of the disk into filename, if it is
+// the disk's file, unchanged since it was loaded or saved, and laid out as
+// Save would write it. It reports false, having written nothing, otherwise.
+func (di *DiskImage) saveInPlace(filename string) (bool, error) {
+	path, err := filepath.Abs(filename)
+	if err != nil || di.origin == nil || di.origin.path != path {
+		return false, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != di.origin.size || !info.ModTime().Equal(di.origin.modTime) {
+		logger.Debug("cannot save in place: file changed since loaded", "path", path)
+		return false, nil
+	}
+	if err := di.FlushDirectory(); err != nil {
+		return false, err
+	}
+	c := di.Container()
+	dib, sizes, err := di.containerLayout(c)
+	if err != nil {
+		return false, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return false, nil
+	}
+	defer f.Close()
+	cur := make([]byte, len(dib))
+	if _, err := io.ReadFull(f, cur); err != nil || !sameLayout(cur, dib, c, len(sizes)) {
+		logger.Debug("cannot save in place: layout changed", "path", path)
+		return false, nil
+	}
+
+	dirty := func(i int) bool { return i < len(di.dirty) && di.dirty[i] && sizes[i] > 0 }
+	var done, total int64
+	for i, size := range sizes {
+		if dirty(i) {
+			total += int64(size)
+		}
+	}
+	off := int64(len(dib))
+	for i, size := range sizes {
+		if dirty(i) {
+			if _, err := f.WriteAt(di.containerTrack(i, size), off); err != nil {
+				return true, err
+			}
+			done += int64(size)
+			di.report(StageSave, "", done, total)
+		}
+		off += int64(size)
+	}
+	if total == 0 {
+		di.report(StageSave, "", 0, 0)
+	}
+	if err := f.Sync(); err != nil {
+		return true, err
+	}
+	if err := f.Close(); err != nil {
+		return true, err
+	}
+	logger.Debug("saved disk image in place", "path", path, "bytes", total)
+	di.setOrigin(path)
+	return true, nil
+}
+
+// sameLayout reports whether two disc information blocks of container c
+// place n tracks at the same offsets and sizes.
+func sameLayout(a, b []byte, c Container, n int) bool {
+	if !bytes.Equal(a[:8], b[:8]) || !bytes.Equal(a[0x30:0x32], b[0x30:0x32]) {
+		return false
+	}
+	if c == ContainerExtended {
+		return bytes.Equal(a[0x34:0x34+n], b[0x34:0x34+n])
+	}
+	return bytes.Equal(a[0x32:0x34], b[0x32:0x34])
+}
@@ -0,0 +1,85 @@
+package diskimg
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// An in-place save rewrites only the tracks that changed, and falls back to
+// replacing the file when the image's layout has changed.
+func TestSaveInPlace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "disk.dsk")
+	if err := newSpecImage(t, SpecPlus3).SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+	di, err := LoadFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mark the end of the last track behind the disk's back; an in-place
+	// save leaves it alone, a full one overwrites it.
+	mark := func() {
+		t.Helper()
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteAt([]byte{0x5A}, info.Size()-1); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	marked := func() bool {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data[len(data)-1] == 0x5A
+	}
+
+	mark()
+	if err := di.WriteFile("A.TXT", []byte("in place")); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.SaveToFileWithOptions(path, &SaveOptions{InPlace: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !marked() {
+		t.Error("an in-place save rewrote a track that had not changed")
+	}
+	loaded, err := LoadFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := fs.ReadFile(loaded, "A.TXT"); err != nil || string(data) != "in place" {
+		t.Errorf("after an in-place save, A.TXT = %q, %v", data, err)
+	}
+
+	mark()
+	di.SetContainer(ContainerExtended)
+	if err := di.WriteFile("B.TXT", []byte("full")); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.SaveToFileWithOptions(path, &SaveOptions{InPlace: true}); err != nil {
+		t.Fatal(err)
+	}
+	if marked() {
+		t.Error("a save with a new layout was made in place")
+	}
+	if loaded, err = LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if c := loaded.Container(); c != ContainerExtended {
+		t.Errorf("after a full save, the container is %v", c)
+	}
+}
@@ -2,6 +2,7 @@ package diskimg
 
 import (
 	"bytes"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"testing"
@@ -174,3 +175,62 @@ func firstBytes(b []byte) []byte {
 	}
 	return b
 }
+
+// ImportData stores bytes with the same header ImportFile would write.
+func TestImportData(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	code := bytes.Repeat([]byte{0xC9}, 300)
+	if err := di.ImportData("game.bin", code, &ImportOptions{AddHeader: true, FileType: FileTypeCode, LoadAddr: 32768}); err != nil {
+		t.Fatal(err)
+	}
+	h, err := di.ReadHeader("GAME.BIN")
+	if err != nil || h == nil {
+		t.Fatalf("ReadHeader = %v, %v", h, err)
+	}
+	if fileType, length, addr, _ := h.GetBasicHeader(); fileType != FileTypeCode || length != 300 || addr != 32768 {
+		t.Errorf("header: type %d, length %d, address %d", fileType, length, addr)
+	}
+	if data, _ := fs.ReadFile(di.HeaderlessFS(), "GAME.BIN"); !bytes.Equal(data, code) {
+		t.Error("file data differs")
+	}
+}
+
+// SaveToFile replaces the image through a temporary file, keeping its mode
+// and, when asked, the previous version as a .bak.
+func TestSaveToFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	diskPath := filepath.Join(dir, "disk.dsk")
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.SaveToFile(diskPath); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.ReadFile(diskPath)
+	if err := os.Chmod(diskPath, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := di.WriteFile("NEW.TXT", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.SaveToFileWithOptions(diskPath, &SaveOptions{Backup: true}); err != nil {
+		t.Fatal(err)
+	}
+	if backup, err := os.ReadFile(diskPath + ".bak"); err != nil || !bytes.Equal(backup, before) {
+		t.Errorf("backup differs from the previous image (%v)", err)
+	}
+	if info, _ := os.Stat(diskPath); info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+	if after, _ := LoadFromFile(diskPath); after == nil {
+		t.Error("saved image does not load")
+	} else if _, err := after.Stat("NEW.TXT"); err != nil {
+		t.Errorf("saved image: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("%d files in the directory, want the image and its backup", len(entries))
+	}
+
+	if err := WriteFileAtomic(filepath.Join(dir, "missing", "disk.dsk"), before, nil); err == nil {
+		t.Error("WriteFileAtomic into a missing directory succeeded")
+	}
+}
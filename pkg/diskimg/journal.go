@@ -0,0 +1,244 @@
+// file: pkg/diskimg/journal.go
+
+package diskimg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ChangeOp is the kind of change a Change records.
+type ChangeOp string
+
+const (
+	ChangeWrite  ChangeOp = "write"  // a file was created or written
+	ChangeDelete ChangeOp = "delete" // a file was deleted
+	ChangeRename ChangeOp = "rename" // a file was renamed
+	ChangeAttrib ChangeOp = "attrib" // a file's attributes were changed
+)
+
+// Change records one change to the files of a disk, with what Revert needs
+// to undo it.
+type Change struct {
+	Op         ChangeOp `json:"op"`
+	Name       string   `json:"name"`
+	NewName    string   `json:"new_name,omitempty"`   // rename: the new name
+	Existed    bool     `json:"existed,omitempty"`    // write: the file was already there
+	Data       []byte   `json:"data,omitempty"`       // write, delete: the previous contents
+	User       uint8    `json:"user,omitempty"`       // user area of the file
+	Attributes uint16   `json:"attributes,omitempty"` // previous attribute bits, see entryAttributes
+}
+
+// RecordChanges starts recording the changes made to the disk's files:
+// files opened for writing, deletions, renames and attribute changes. Changes
+// returns the record.
+func (di *DiskImage) RecordChanges() {
+	di.recording = true
+}
+
+// Changes returns the changes recorded since RecordChanges, oldest first.
+func (di *DiskImage) Changes() []Change {
+	return di.changes
+}
+
+// record adds c to the disk's changes if they are being recorded.
+func (di *DiskImage) record(c Change) {
+	if di.recording {
+		di.changes = append(di.changes, c)
+	}
+}
+
+// fileChange returns a Change of the file whose first entry is first,
+// holding its contents, user area and attributes as they are now.
+func (di *DiskImage) fileChange(op ChangeOp, first *DirectoryEntry) (Change, error) {
+	c := Change{Op: op, Name: first.GetFilename(), User: first.Status, Attributes: entryAttributes(first)}
+	f, err := di.OpenFile(c.Name, os.O_RDONLY)
+	if err != nil {
+		return c, err
+	}
+	c.Data = make([]byte, f.size)
+	if n, err := f.ReadAt(c.Data, 0); n < len(c.Data) {
+		return c, fmt.Errorf("%s: %w", c.Name, err)
+	}
+	return c, nil
+}
+
+// SetFileAttributes sets the read-only and system attributes of a file and
+// flushes the directory.
+func (di *DiskImage) SetFileAttributes(filename string, readOnly, system bool) error {
+	return di.setAttributes(filename, func(e *DirectoryEntry) { e.SetAttributes(readOnly, system, system) })
+}
+
+// SetAttributes sets every attribute of a file, in each of its directory
+// entries, and flushes the directory.
+func (di *DiskImage) SetAttributes(filename string, attrs FileAttributes) error {
+	return di.setAttributes(filename, attrs.ApplyToDirectoryEntry)
+}
+
+// setAttributes applies set to the directory entries of a file, recording
+// the attributes it had.
+func (di *DiskImage) setAttributes(filename string, set func(*DirectoryEntry)) error {
+	first, err := di.directory.FindFile(filename)
+	if err != nil {
+		return fileError("attrib", filename, err)
+	}
+	di.record(Change{Op: ChangeAttrib, Name: first.GetFilename(), Attributes: entryAttributes(first)})
+	for _, e := range di.directory.fileExtents(first) {
+		set(e)
+	}
+	di.Modified = true
+	return di.FlushDirectory()
+}
+
+// Revert undoes changes, newest first, as recorded by RecordChanges. The
+// files involved must be as the changes left them.
+func (di *DiskImage) Revert(changes []Change) error {
+	recording := di.recording
+	di.recording = false
+	defer func() { di.recording = recording }()
+
+	for i := len(changes) - 1; i >= 0; i-- {
+		c := changes[i]
+		logger.Debug("reverting change", "op", c.Op, "name", c.Name)
+		var err error
+		switch c.Op {
+		case ChangeWrite:
+			if _, findErr := di.directory.FindFile(c.Name); findErr == nil {
+				err = di.DeleteFile(c.Name)
+			}
+			if err == nil && c.Existed {
+				err = di.restoreFile(c)
+			}
+		case ChangeDelete:
+			err = di.restoreFile(c)
+		case ChangeRename:
+			err = di.RenameFile(c.NewName, c.Name)
+		case ChangeAttrib:
+			var first *DirectoryEntry
+			if first, err = di.directory.FindFile(c.Name); err == nil {
+				for _, e := range di.directory.fileExtents(first) {
+					setEntryAttributes(e, c.Attributes)
+				}
+				di.Modified = true
+				err = di.FlushDirectory()
+			}
+		default:
+			err = fmt.Errorf("%w: change %q", ErrUnsupported, c.Op)
+		}
+		if err != nil {
+			return fmt.Errorf("revert %s of %s: %w", c.Op, c.Name, err)
+		}
+	}
+	return nil
+}
+
+// restoreFile puts back a file as c recorded it.
+func (di *DiskImage) restoreFile(c Change) error {
+	if err := di.WriteFile(c.Name, c.Data); err != nil {
+		return err
+	}
+	first, err := di.directory.FindFile(c.Name)
+	if err != nil {
+		return err
+	}
+	for _, e := range di.directory.fileExtents(first) {
+		e.Status = c.User
+		setEntryAttributes(e, c.Attributes)
+	}
+	return di.FlushDirectory()
+}
+
+// entryAttributes returns the attribute bits of a directory entry: bit i is
+// the high bit of name byte i for i < 8, then of the extension bytes.
+func entryAttributes(e *DirectoryEntry) uint16 {
+	var a uint16
+	for i, b := range e.Name {
+		a |= uint16(b>>7) << i
+	}
+	for i, b := range e.Extension {
+		a |= uint16(b>>7) << (8 + i)
+	}
+	return a
+}
+
+// setEntryAttributes sets the attribute bits of a directory entry, as
+// entryAttributes returns them.
+func setEntryAttributes(e *DirectoryEntry, a uint16) {
+	for i := range e.Name {
+		e.Name[i] = e.Name[i]&0x7F | byte(a>>i&1)<<7
+	}
+	for i := range e.Extension {
+		e.Extension[i] = e.Extension[i]&0x7F | byte(a>>(8+i)&1)<<7
+	}
+}
+
+// JournalEntry is one operation in a journal: the changes a command made,
+// which Revert undoes.
+type JournalEntry struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`           // what was done, e.g. "add GAME.BIN"
+	Partition string    `json:"partition,omitempty"` // the +3e partition changed, for a hard disk image
+	Changes   []Change  `json:"changes"`
+}
+
+// ReadJournal reads the journal at path, one JSON entry per line, oldest
+// first. A missing journal is empty.
+func ReadJournal(path string) ([]JournalEntry, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []JournalEntry
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, len(data)+1)
+	for n := 1; sc.Scan(); n++ {
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		var e JournalEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
+
+// AppendJournal adds an entry to the end of the journal at path, creating it
+// if need be.
+func AppendJournal(path string, e JournalEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// WriteJournal replaces the journal at path with entries, atomically.
+func WriteJournal(path string, entries []JournalEntry) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return WriteFileAtomic(path, buf.Bytes(), nil)
+}
@@ -0,0 +1,89 @@
+package diskimg
+
+import (
+	"bytes"
+	"io/fs"
+	"path/filepath"
+	"testing"
+)
+
+// Revert undoes recorded writes, deletions, renames and attribute changes,
+// leaving the files as they were.
+func TestRevertChanges(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.WriteFile("OLD.TXT", []byte("old contents")); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.WriteFile("GONE.BIN", bytes.Repeat([]byte("gone"), 5000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.SetFileAttributes("GONE.BIN", true, false); err != nil {
+		t.Fatal(err)
+	}
+	free := di.fileSpace()
+
+	di.RecordChanges()
+	steps := []func() error{
+		func() error { return di.WriteFile("OLD.TXT", []byte("new")) },
+		func() error { return di.WriteFile("NEW.TXT", []byte("brand new")) },
+		func() error { return di.DeleteFile("GONE.BIN") },
+		func() error { return di.RenameFile("NEW.TXT", "RENAMED.TXT") },
+		func() error { return di.SetFileAttributes("OLD.TXT", true, true) },
+	}
+	for i, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+	if n := len(di.Changes()); n != len(steps) {
+		t.Fatalf("recorded %d changes, want %d", n, len(steps))
+	}
+
+	if err := di.Revert(di.Changes()); err != nil {
+		t.Fatal(err)
+	}
+	di = reload(t, di)
+	if data, _ := fs.ReadFile(di, "OLD.TXT"); string(data) != "old contents" {
+		t.Errorf("OLD.TXT = %q", data)
+	}
+	if data, _ := fs.ReadFile(di, "GONE.BIN"); !bytes.Equal(data, bytes.Repeat([]byte("gone"), 5000)) {
+		t.Errorf("GONE.BIN not restored: %d bytes", len(data))
+	}
+	if info, err := di.Stat("GONE.BIN"); err != nil || info.Mode()&0200 != 0 {
+		t.Errorf("GONE.BIN lost its read-only attribute: %v", err)
+	}
+	if info, err := di.Stat("OLD.TXT"); err != nil || info.Mode()&0200 == 0 {
+		t.Errorf("OLD.TXT is still read-only: %v", err)
+	}
+	for _, name := range []string{"NEW.TXT", "RENAMED.TXT"} {
+		if _, err := di.Stat(name); err == nil {
+			t.Errorf("%s is still on the disk", name)
+		}
+	}
+	if got := di.fileSpace(); got != free {
+		t.Errorf("%d bytes free after Revert, want %d", got, free)
+	}
+}
+
+func TestJournalFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "disk.dsk.journal")
+	if entries, err := ReadJournal(path); err != nil || len(entries) != 0 {
+		t.Fatalf("missing journal: %d entries, %v", len(entries), err)
+	}
+	for _, op := range []string{"add A", "delete B"} {
+		e := JournalEntry{Operation: op, Changes: []Change{{Op: ChangeDelete, Name: "B", Data: []byte{1, 2}}}}
+		if err := AppendJournal(path, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := ReadJournal(path)
+	if err != nil || len(entries) != 2 || entries[1].Operation != "delete B" || !bytes.Equal(entries[1].Changes[0].Data, []byte{1, 2}) {
+		t.Fatalf("ReadJournal = %+v, %v", entries, err)
+	}
+	if err := WriteJournal(path, entries[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := ReadJournal(path); len(entries) != 1 || entries[0].Operation != "add A" {
+		t.Errorf("after WriteJournal: %+v", entries)
+	}
+}
@@ -0,0 +1,92 @@
+// file: pkg/diskimg/label.go
+
+package diskimg
+
+import (
+	"strings"
+	"time"
+)
+
+// GetLabel returns the disk's volume label, as "NAME" or "NAME.EXT", or ""
+// if it has none.
+func (di *DiskImage) GetLabel() string {
+	if l := di.label(); l != nil {
+		return l.GetFilename()
+	}
+	return ""
+}
+
+// SetLabel sets the disk's volume label, stored as a CP/M Plus directory
+// label entry, and flushes the directory. The label is an 8.3 name; one of
+// up to 11 characters without a dot is split after the eighth. An empty
+// label removes it, unless the label also turns on datestamps, when it is
+// left unnamed.
+func (di *DiskImage) SetLabel(label string) error {
+	label = NormaliseFilename(label)
+	if label != "" {
+		if !strings.Contains(label, ".") && len(label) > 8 && len(label) <= 11 {
+			label = label[:8] + "." + label[8:]
+		}
+		var err error
+		if label, err = di.diskName(label); err != nil {
+			return fileError("label", label, err)
+		}
+	}
+
+	l := di.label()
+	switch {
+	case l == nil && label == "":
+		return nil
+	case l == nil:
+		i := di.freeEntry()
+		if i < 0 {
+			return fileError("label", label, ErrDirectoryFull)
+		}
+		l = &di.directory.Entries[i]
+		*l = newLabel(label)
+	case label == "" && l.Extent&^labelExists == 0:
+		*l = DirectoryEntry{Status: 0xE5}
+	default:
+		flags, raw := l.Extent, l.bytes()
+		*l = newLabel(label)
+		l.Extent = flags
+		created := l.bytes()
+		copy(created[24:28], raw[24:28]) // keep the creation stamp
+		l.setBytes(created)
+	}
+	logger.Debug("set label", "label", label)
+	di.Modified = true
+	return di.FlushDirectory()
+}
+
+// newLabel returns a directory label entry named name, stamped now.
+func newLabel(name string) DirectoryEntry {
+	l := DirectoryEntry{Status: statusLabel, Extent: labelExists}
+	l.Name, l.Extension = splitFilename(name)
+	raw := l.bytes()
+	now := time.Now()
+	encodeCPMStamp(raw[24:28], now)
+	encodeCPMStamp(raw[28:32], now)
+	l.setBytes(raw)
+	return l
+}
+
+// label returns the directory label entry, or nil if there is none.
+func (di *DiskImage) label() *DirectoryEntry {
+	for i := range di.directory.Entries {
+		if di.directory.Entries[i].Status == statusLabel {
+			return &di.directory.Entries[i]
+		}
+	}
+	return nil
+}
+
+// freeEntry returns the index of the first free directory entry, or -1.
+func (di *DiskImage) freeEntry() int {
+	for i := range di.directory.Entries {
+		if di.directory.Entries[i].IsUnused() {
+			return i
+		}
+	}
+	return -1
+}
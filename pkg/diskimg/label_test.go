@@ -0,0 +1,51 @@
+package diskimg
+
+import (
+	"errors"
+	"testing"
+)
+
+// A label is stored as a directory label entry beside the files, survives a
+// reload and keeps its name when datestamps are turned on.
+func TestLabel(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	if got := di.GetLabel(); got != "" {
+		t.Errorf("new disk label = %q", got)
+	}
+	if err := di.SetLabel("bad*name"); !errors.Is(err, ErrInvalidFilename) {
+		t.Errorf("SetLabel(bad*name) = %v, want ErrInvalidFilename", err)
+	}
+	if err := di.SetLabel("games"); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.writeRecords("GAME.BIN", []byte("game")); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.SetLabel("gamesdisk1"); err != nil {
+		t.Fatal(err)
+	}
+
+	di = reload(t, di)
+	if got := di.GetLabel(); got != "GAMESDIS.K1" {
+		t.Errorf("label = %q, want GAMESDIS.K1", got)
+	}
+	if files, _ := di.FileEntries(); len(files) != 1 {
+		t.Errorf("FileEntries = %d files, want 1", len(files))
+	}
+	if err := di.DiskCheck(); err != nil {
+		t.Errorf("DiskCheck: %v", err)
+	}
+
+	if err := di.EnableDatestamps(); err != nil {
+		t.Fatal(err)
+	}
+	if got := di.GetLabel(); got != "GAMESDIS.K1" {
+		t.Errorf("label after EnableDatestamps = %q", got)
+	}
+	if err := di.SetLabel(""); err != nil {
+		t.Fatal(err)
+	}
+	if di.label() == nil || di.GetLabel() != "" {
+		t.Error("clearing the label of a stamped disk removed the entry or kept the name")
+	}
+}
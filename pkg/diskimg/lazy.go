@@ -0,0 +1,106 @@
+// file: pkg/diskimg/lazy.go
+
+package diskimg
+
+import (
+	"io"
+	"os"
+	"slices"
+)
+
+// lazyTracks is where the tracks of a disk loaded with LoadLazy are in its
+// image, and which of them have been read into Tracks.
+type lazyTracks struct {
+	r       io.ReaderAt
+	closer  io.Closer // the file OpenLazy opened, closed by Close
+	mapped  *mapping  // the mapping OpenMapped made
+	size    int64     // image size in bytes
+	offsets []int64   // offset of each track block
+	sizes   []int     // size of each track block, 0 if absent
+	loaded  []bool
+	salvage bool
+}
+
+// LoadLazy reads a DSK image of size bytes from r, reading only the disc
+// information block and the tracks holding the boot sector and directory up
+// front. Other tracks are read from r when first used, so listing or
+// cataloguing a disk reads a few kilobytes of it. r must stay readable as long
+// as the disk is used. Tracks holds nil for a track not yet read; LoadTracks
+// reads the rest.
+func LoadLazy(r io.ReaderAt, size int64, opts *LoadOptions) (*DiskImage, error) {
+	return load(r, size, opts, true, nil)
+}
+
+// OpenLazy opens a DSK image file with LoadLazy. Close the disk when done
+// with it to close the file.
+func OpenLazy(filename string, opts *LoadOptions) (*DiskImage, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	di, err := LoadLazy(f, info.Size(), opts)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	di.lazy.closer = f
+	di.setOrigin(filename)
+	return di, nil
+}
+
+// Close closes the file of a disk opened with OpenLazy; tracks not yet read
+// can no longer be. A disk opened with OpenMapped is unmapped and cannot be
+// used at all. For any other disk Close does nothing.
+func (di *DiskImage) Close() error {
+	if di.lazy == nil || di.lazy.closer == nil {
+		return nil
+	}
+	err := di.lazy.closer.Close()
+	di.lazy.closer = nil
+	if di.lazy.mapped != nil {
+		// The tracks point into the mapping.
+		di.Tracks, di.lazy = nil, nil
+	}
+	return err
+}
+
+// LoadTracks reads every track of a lazily loaded disk not read yet, so
+// Tracks holds the whole image. For any other disk it does nothing.
+func (di *DiskImage) LoadTracks() error {
+	l := di.lazy
+	for i := range di.Tracks {
+		if l == nil || l.loaded[i] {
+			continue
+		}
+		if err := di.loadTrack(i); err != nil {
+			return err
+		}
+		di.report(StageLoad, "", l.offsets[i]+int64(l.sizes[i]), l.size)
+	}
+	return nil
+}
+
+// track returns the track block at idx, reading it first if need be. It is
+// nil for an absent track.
+func (di *DiskImage) track(idx int) ([]byte, error) {
+	if err := di.loadTrack(idx); err != nil {
+		return nil, err
+	}
+	return di.Tracks[idx], nil
+}
+
+// clone returns a copy of l for a snapshot, which reads its tracks itself.
+func (l *lazyTracks) clone() *lazyTracks {
+	if l == nil {
+		return nil
+	}
+	c := *l
+	c.closer = nil // the original's to close
+	c.loaded = slices.Clone(l.loaded)
+	return &c
+}
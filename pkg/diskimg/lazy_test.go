@@ -0,0 +1,65 @@
+package diskimg
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A lazily loaded disk reads its directory tracks up front and the rest on
+// demand, and reads and saves the same as a disk loaded whole.
+func TestLoadLazy(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	body := bytes.Repeat([]byte("0123456789"), 10000)
+	if err := di.WriteFile("BIG.DAT", body); err != nil {
+		t.Fatal(err)
+	}
+	var image bytes.Buffer
+	if err := di.Save(&image); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "disk.dsk")
+	if err := os.WriteFile(path, image.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lazy, err := OpenLazy(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lazy.Close()
+	loaded := func() int {
+		n := 0
+		for _, tr := range lazy.Tracks {
+			if tr != nil {
+				n++
+			}
+		}
+		return n
+	}
+	if size, err := lazy.FileSize("BIG.DAT"); err != nil || size != len(body) {
+		t.Fatalf("FileSize = %d, %v", size, err)
+	}
+	if n := loaded(); n > 2 {
+		t.Errorf("%d tracks read to list the disk, want at most 2", n)
+	}
+	if data, err := fs.ReadFile(lazy, "BIG.DAT"); err != nil || !bytes.Equal(data, body) {
+		t.Errorf("BIG.DAT: %d bytes, %v", len(data), err)
+	}
+
+	var saved bytes.Buffer
+	if err := lazy.Save(&saved); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved.Bytes(), image.Bytes()) {
+		t.Error("a lazily loaded disk saves differently")
+	}
+
+	short := image.Bytes()[:image.Len()-1000]
+	if _, err := LoadLazy(bytes.NewReader(short), int64(len(short)), nil); !errors.Is(err, ErrCorruptImage) {
+		t.Errorf("LoadLazy of a truncated image: err = %v, want ErrCorruptImage", err)
+	}
+}
@@ -0,0 +1,70 @@
+// file: pkg/diskimg/locoscript.go
+
+package diskimg
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// locoHeaderSize is the size of a LocoScript document's header, which holds
+// its layouts and settings rather than its text.
+const locoHeaderSize = 0x200
+
+// IsLocoScript reports whether data is a LocoScript document, as the word
+// processor of the Amstrad PCW and +3 writes one: it starts with DOC
+// (LocoScript 1) or JOY (LocoScript 2).
+func IsLocoScript(data []byte) bool {
+	if len(data) < locoHeaderSize {
+		return false
+	}
+	sig := string(data[:3])
+	return sig == "DOC" || sig == "JOY"
+}
+
+// LocoScriptToText returns the text of a LocoScript document, converted
+// minimally: printable ASCII is kept, CR ends a line and tab stays a tab, and
+// the formatting codes, bytes below 0x20 and above 0x7E, are dropped, as is
+// trailing space on each line. LocoScript's own characters above 0x7E, whose
+// codes differ between versions and printers, are lost with them.
+func LocoScriptToText(data []byte) ([]byte, error) {
+	if !IsLocoScript(data) {
+		return nil, fmt.Errorf("%w: not a LocoScript document", ErrWrongFileType)
+	}
+	var out, line []byte
+	for _, b := range data[locoHeaderSize:] {
+		switch {
+		case b == '\r':
+			out = append(append(out, bytes.TrimRight(line, " ")...), '\n')
+			line = line[:0]
+		case b == '\t' || b >= 0x20 && b < 0x7F:
+			line = append(line, b)
+		}
+	}
+	if line = bytes.TrimRight(line, " "); len(line) > 0 {
+		out = append(append(out, line...), '\n')
+	}
+	return out, nil
+}
+
+// ExportLocoScript writes a LocoScript document on the disk to the host as
+// text, converted with LocoScriptToText.
+func (di *DiskImage) ExportLocoScript(diskPath, hostPath string) error {
+	data, err := diskFS{disk: di}.read(diskPath)
+	if err != nil {
+		return err
+	}
+	text, err := LocoScriptToText(data)
+	if err != nil {
+		return fileError("export", diskPath, err)
+	}
+	return os.WriteFile(hostPath, text, 0644)
+}
+
+// IsLocoScriptFile reports whether the named file on the disk is a
+// LocoScript document.
+func (di *DiskImage) IsLocoScriptFile(diskPath string) bool {
+	data, err := diskFS{disk: di}.read(diskPath)
+	return err == nil && IsLocoScript(data)
+}
@@ -0,0 +1,49 @@
+package diskimg
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// sampleLocoScript returns a LocoScript 2 document holding body as its text.
+func sampleLocoScript(body string) []byte {
+	data := make([]byte, locoHeaderSize)
+	copy(data, "JOY")
+	copy(data[0x20:], "Standard layout") // header text is not part of the document
+	return append(data, body...)
+}
+
+func TestLocoScriptToText(t *testing.T) {
+	text, err := LocoScriptToText(sampleLocoScript("Dear Sir,  \r\x80\x02\tYours\x01 faithfully\r\x1A"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Dear Sir,\n\tYours faithfully\n"; string(text) != want {
+		t.Errorf("LocoScriptToText = %q, want %q", text, want)
+	}
+	if _, err := LocoScriptToText(bytes.Repeat([]byte("text"), 200)); err == nil {
+		t.Error("plain text converted as LocoScript")
+	}
+}
+
+func TestExportLocoScript(t *testing.T) {
+	di := newSpecImage(t, SpecPCW180)
+	if err := di.WriteFile("LETTER", sampleLocoScript("Hello\rPCW")); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.WriteFile("NOTES.TXT", []byte("Hello\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if !di.IsLocoScriptFile("LETTER") || di.IsLocoScriptFile("NOTES.TXT") {
+		t.Error("IsLocoScriptFile did not tell the document from the text file")
+	}
+	out := filepath.Join(t.TempDir(), "letter.txt")
+	if err := di.ExportLocoScript("LETTER", out); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(out); string(got) != "Hello\nPCW\n" {
+		t.Errorf("exported %q", got)
+	}
+}
@@ -0,0 +1,19 @@
+// file: pkg/diskimg/log.go
+
+package diskimg
+
+import "log/slog"
+
+// logger receives the package's operation traces.
+var logger = slog.New(slog.DiscardHandler)
+
+// SetLogger sends the package's operation traces to l: loads, saves and file
+// changes at Debug level, repairs made by a salvage load at Info, and
+// failures the package works around at Warn. A nil l discards them, which is
+// the default. Set it before using the package from more than one goroutine.
+func SetLogger(l *slog.Logger) {
+	if l == nil {
+		l = slog.New(slog.DiscardHandler)
+	}
+	logger = l
+}
@@ -0,0 +1,29 @@
+package diskimg
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// A logger set with SetLogger sees the operations on a disk.
+func TestSetLogger(t *testing.T) {
+	var buf bytes.Buffer
+	SetLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer SetLogger(nil)
+
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.WriteFile("A.TXT", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.DeleteFile("A.TXT"); err != nil {
+		t.Fatal(err)
+	}
+	reload(t, di)
+	for _, want := range []string{`msg="opened file for writing" name=A.TXT`, `msg="deleting file" name=A.TXT`, `msg="saved disk image"`, `msg="loaded disk image"`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("log has no %s:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// file: pkg/diskimg/mmap.go
+
+package diskimg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// mapping is a DSK image file mapped into memory.
+type mapping struct {
+	file     *os.File
+	data     []byte
+	writable bool
+}
+
+// OpenMapped opens a DSK image file mapped into memory, where the operating
+// system supports it. Tracks are read as OpenLazy reads them, but in place:
+// a sector read is a page-cache hit and no track is copied. With writable set,
+// writes go straight into the mapped file and only dirty the pages they
+// touch; Sync makes them durable. Without it the file is never changed, and a
+// track is copied before it is first written. Close the disk when done with
+// it; it cannot be used afterwards. The file must not be truncated while it
+// is mapped.
+//
+// Where mapping is not supported, a read-only image is opened with OpenLazy
+// and a writable one is read into memory and written back by Sync.
+func OpenMapped(filename string, writable bool, opts *LoadOptions) (*DiskImage, error) {
+	flag := os.O_RDONLY
+	if writable {
+		flag = os.O_RDWR
+	}
+	f, err := os.OpenFile(filename, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !canMap && !writable {
+		f.Close()
+		return OpenLazy(filename, opts)
+	}
+	data, err := mapFile(f, info.Size(), writable)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to map %s: %w", filename, err)
+	}
+	m := &mapping{file: f, data: data, writable: writable}
+	di, err := load(bytes.NewReader(data), int64(len(data)), opts, true, m)
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+	di.lazy.closer = m
+	di.setOrigin(filename)
+	return di, nil
+}
+
+// Close unmaps the image and closes its file.
+func (m *mapping) Close() error {
+	err := unmapFile(m.data)
+	m.data = nil
+	return errors.Join(err, m.file.Close())
+}
+
+// Sync writes the changes to a disk opened writable with OpenMapped back to
+// its file and flushes the file to stable storage. Changes that alter the
+// layout of the image (a track added, resized or reformatted in the
+// container) cannot be written in place; save such a disk with SaveToFile.
+func (di *DiskImage) Sync() error {
+	var m *mapping
+	if di.lazy != nil && di.lazy.closer != nil {
+		m = di.lazy.mapped
+	}
+	if m == nil || !m.writable {
+		return fmt.Errorf("%w: not a writable mapped disk image", ErrReadOnly)
+	}
+	if err := di.FlushDirectory(); err != nil {
+		return err
+	}
+	l := di.lazy
+	for i, block := range di.Tracks {
+		if block == nil {
+			continue // absent, or not read and so unchanged
+		}
+		off, size := l.offsets[i], l.sizes[i]
+		if len(block) != size || off+int64(size) > int64(len(m.data)) {
+			return fmt.Errorf("%w: track %d no longer fits the image; save it with SaveToFile instead", ErrUnsupported, i)
+		}
+		if dst := m.data[off : off+int64(size)]; &dst[0] != &block[0] {
+			copy(dst, block) // a copied track: a repaired or snapshot one
+		}
+	}
+	if err := syncMapping(m); err != nil {
+		return err
+	}
+	di.setOrigin(m.file.Name())
+	di.Modified = false
+	return nil
+}
@@ -0,0 +1,31 @@
+// file: pkg/diskimg/mmap_other.go
+
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package diskimg
+
+import (
+	"io"
+	"os"
+)
+
+const canMap = false
+
+// mapFile reads the file into memory in place of a mapping.
+func mapFile(f *os.File, size int64, writable bool) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(f, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func unmapFile([]byte) error { return nil }
+
+// syncMapping writes the in-memory copy back to the file.
+func syncMapping(m *mapping) error {
+	if _, err := m.file.WriteAt(m.data, 0); err != nil {
+		return err
+	}
+	return m.file.Sync()
+}
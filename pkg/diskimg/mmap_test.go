@@ -0,0 +1,71 @@
+package diskimg
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A writable mapped disk is changed in place by Sync; a read-only one leaves
+// its file alone.
+func TestOpenMapped(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.WriteFile("A.TXT", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	var image bytes.Buffer
+	if err := di.Save(&image); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "disk.dsk")
+	if err := os.WriteFile(path, image.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ro, err := OpenMapped(path, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ro.WriteFile("B.TXT", []byte("in memory only")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ro.Sync(); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Sync of a read-only mapping: err = %v, want ErrReadOnly", err)
+	}
+	ro.Close()
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, image.Bytes()) {
+		t.Fatal("a read-only mapped disk changed its file")
+	}
+
+	rw, err := OpenMapped(path, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap := rw.Snapshot()
+	if err := rw.WriteFile("A.TXT", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := fs.ReadFile(snap, "A.TXT"); string(data) != "first" {
+		t.Errorf("snapshot of a mapped disk sees A.TXT = %q", data)
+	}
+	if err := rw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	back, err := LoadFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := fs.ReadFile(back, "A.TXT"); string(data) != "second" {
+		t.Errorf("after Sync, A.TXT = %q", data)
+	}
+	if err := back.DiskCheck(); err != nil {
+		t.Errorf("DiskCheck after Sync: %v", err)
+	}
+}
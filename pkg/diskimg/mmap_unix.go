@@ -0,0 +1,37 @@
+// file: pkg/diskimg/mmap_unix.go
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package diskimg
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+const canMap = true
+
+func mapFile(f *os.File, size int64, writable bool) ([]byte, error) {
+	if size == 0 || int64(int(size)) != size {
+		return nil, fmt.Errorf("%w: file cannot be mapped", ErrUnsupported)
+	}
+	prot := syscall.PROT_READ
+	if writable {
+		prot |= syscall.PROT_WRITE
+	}
+	return syscall.Mmap(int(f.Fd()), 0, int(size), prot, syscall.MAP_SHARED)
+}
+
+func unmapFile(data []byte) error {
+	if data == nil {
+		return nil
+	}
+	return syscall.Munmap(data)
+}
+
+// syncMapping flushes the mapped pages; fsync covers them, as the mapping
+// and the file share the page cache.
+func syncMapping(m *mapping) error {
+	return m.file.Sync()
+}
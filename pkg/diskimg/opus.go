@@ -0,0 +1,104 @@
+// file: pkg/diskimg/opus.go
+
+package diskimg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Opus Discovery disks are read from raw sector dumps (.opd/.opu): 40 tracks
+// of eighteen 256-byte sectors, one or two sides (both sides of a cylinder
+// together), numbered as logical sectors from 0. Sector 0 starts with a JR
+// instruction followed by the geometry; the catalogue follows in sectors 1-7
+// as 16-byte entries: a 10-character name and the first and last logical
+// sectors of the file, which is stored contiguously. As on the Microdrive,
+// every file starts with the 9-byte tape header (type, length, two
+// parameters).
+const (
+	OpusSectorSize      = 256
+	OpusSectorsPerTrack = 18
+	OpusTracks          = 40
+
+	opusCatalogSectors = 7
+	opusEntrySize      = 16
+	opusHeaderSize     = 9
+)
+
+// OpusFile is a file on an Opus Discovery disk.
+type OpusFile struct {
+	Name   string
+	Type   byte   // tape file type: FileTypeProgram ... FileTypeCode
+	Param1 uint16 // LINE for a program, load address for code
+	Param2 uint16 // program length without variables
+	Data   []byte // file contents, without the tape header
+}
+
+// OpusImage is an Opus Discovery disk.
+type OpusImage struct {
+	Sides int
+	Files []OpusFile
+}
+
+// IsOpusImage reports whether data looks like a raw Opus Discovery disk: the
+// size of a 40-track disk and a boot sector starting with JR and the Opus
+// geometry.
+func IsOpusImage(data []byte) bool {
+	side := OpusTracks * OpusSectorsPerTrack * OpusSectorSize
+	return (len(data) == side || len(data) == 2*side) &&
+		data[0] == 0x18 && data[2] == OpusTracks && data[3] == OpusSectorsPerTrack
+}
+
+// LoadOpus reads a raw Opus Discovery disk image.
+func LoadOpus(r io.Reader) (*OpusImage, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Opus image: %w", err)
+	}
+	if !IsOpusImage(data) {
+		return nil, fmt.Errorf("%w: not an Opus Discovery disk", ErrCorruptImage)
+	}
+
+	o := &OpusImage{Sides: len(data) / (OpusTracks * OpusSectorsPerTrack * OpusSectorSize)}
+	catalog := data[OpusSectorSize : (1+opusCatalogSectors)*OpusSectorSize]
+	for off := 0; off < len(catalog); off += opusEntrySize {
+		e := catalog[off : off+opusEntrySize]
+		if e[0] == 0x00 {
+			break // end of catalogue
+		}
+		if e[0] == 0xE5 {
+			continue // erased
+		}
+		name := strings.TrimRight(string(e[0:10]), " \x00")
+		first := int(binary.LittleEndian.Uint16(e[10:]))
+		last := int(binary.LittleEndian.Uint16(e[12:]))
+		if first == 0 || last < first || (last+1)*OpusSectorSize > len(data) {
+			return nil, fmt.Errorf("%w: Opus file %q has invalid sectors %d-%d", ErrCorruptImage, name, first, last)
+		}
+		body := data[first*OpusSectorSize : (last+1)*OpusSectorSize]
+		length := int(binary.LittleEndian.Uint16(body[1:]))
+		if body[0] > FileTypeCode || opusHeaderSize+length > len(body) {
+			return nil, fmt.Errorf("%w: Opus file %q has an invalid header", ErrCorruptImage, name)
+		}
+		o.Files = append(o.Files, OpusFile{
+			Name:   name,
+			Type:   body[0],
+			Param1: binary.LittleEndian.Uint16(body[3:]),
+			Param2: binary.LittleEndian.Uint16(body[5:]),
+			Data:   append([]byte(nil), body[opusHeaderSize:opusHeaderSize+length]...),
+		})
+	}
+	return o, nil
+}
+
+// ImportOpus copies the files of an Opus Discovery disk onto the +3 disk, as
+// ImportTape copies those of a tape.
+func (di *DiskImage) ImportOpus(o *OpusImage) error {
+	files := make([]TapeFile, len(o.Files))
+	for i, f := range o.Files {
+		files[i] = TapeFile(f)
+	}
+	return di.ImportTape(files)
+}
@@ -0,0 +1,70 @@
+package diskimg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// opusDisk builds a single-sided Opus Discovery image holding the given tape
+// headers and contents, one file per entry, from logical sector 8.
+func opusDisk(names []string, headers [][]byte, data [][]byte) []byte {
+	img := make([]byte, OpusTracks*OpusSectorsPerTrack*OpusSectorSize)
+	img[0], img[2], img[3] = 0x18, OpusTracks, OpusSectorsPerTrack
+	next := 1 + opusCatalogSectors
+	for i := range names {
+		body := append(append([]byte(nil), headers[i]...), data[i]...)
+		last := next + (len(body)-1)/OpusSectorSize
+		e := img[OpusSectorSize+i*opusEntrySize:]
+		copy(e, bytes.Repeat([]byte{' '}, 10))
+		copy(e, names[i])
+		binary.LittleEndian.PutUint16(e[10:], uint16(next))
+		binary.LittleEndian.PutUint16(e[12:], uint16(last))
+		copy(img[next*OpusSectorSize:], body)
+		next = last + 1
+	}
+	return img
+}
+
+func TestOpusImport(t *testing.T) {
+	prog := []byte{0x00, 0x0A, 0x05, 0x00, 0xF5, 0x22, 0x41, 0x22, 0x0D}
+	code := bytes.Repeat([]byte{0xC9, 0x01}, 300)
+	img := opusDisk(
+		[]string{"boot", "game"},
+		[][]byte{
+			{FileTypeProgram, byte(len(prog)), 0, 10, 0, byte(len(prog)), 0, 0, 0},
+			{FileTypeCode, 0x58, 0x02, 0x00, 0x80, 0x00, 0x80, 0, 0},
+		},
+		[][]byte{prog, code},
+	)
+	if !IsOpusImage(img) {
+		t.Fatal("IsOpusImage = false")
+	}
+
+	o, err := LoadOpus(bytes.NewReader(img))
+	if err != nil {
+		t.Fatalf("LoadOpus: %v", err)
+	}
+	if len(o.Files) != 2 || o.Files[0].Name != "boot" || !bytes.Equal(o.Files[1].Data, code) {
+		t.Fatalf("files = %+v", o.Files)
+	}
+
+	di := NewDiskImage()
+	if err := di.ImportOpus(o); err != nil {
+		t.Fatalf("ImportOpus: %v", err)
+	}
+	header, err := di.ReadHeader("BOOT.BAS")
+	if err != nil {
+		t.Fatalf("ReadHeader: %v", err)
+	}
+	if fileType, _, line, _ := header.GetBasicHeader(); fileType != FileTypeProgram || line != 10 {
+		t.Errorf("BOOT.BAS: type %d LINE %d, want a program with LINE 10", fileType, line)
+	}
+	header, err = di.ReadHeader("GAME.BIN")
+	if err != nil {
+		t.Fatalf("ReadHeader: %v", err)
+	}
+	if fileType, length, addr, _ := header.GetBasicHeader(); fileType != FileTypeCode || addr != 0x8000 || length != 600 {
+		t.Errorf("GAME.BIN: type %d, %d bytes at %d, want 600 bytes of code at 32768", fileType, length, addr)
+	}
+}
@@ -0,0 +1,63 @@
+// file: pkg/diskimg/progress.go
+
+package diskimg
+
+import "io"
+
+// Stage is the operation a ProgressEvent reports on.
+type Stage string
+
+const (
+	StageLoad     Stage = "load"     // reading an image's tracks
+	StageSave     Stage = "save"     // writing an image
+	StageImport   Stage = "import"   // copying a host file onto the disk
+	StageExport   Stage = "export"   // copying a file off the disk
+	StageValidate Stage = "validate" // checking an image's tracks
+)
+
+// ProgressEvent reports how far an operation has got.
+type ProgressEvent struct {
+	Stage Stage
+	File  string // the file being imported or exported, otherwise ""
+	Done  int64  // bytes processed so far
+	Total int64  // bytes to process in all, or -1 if not known
+}
+
+// ProgressFunc is called as an operation makes progress, at least once per
+// track or per 32 KB copied, and once it is complete.
+type ProgressFunc func(ProgressEvent)
+
+// SetProgress sets the function that the disk's long operations report
+// their progress to: ImportFile, ExportFile, Save and SaveToFile, LoadTracks
+// and ValidateFormat. nil stops the reports. A disk loaded with
+// LoadOptions.Progress starts with that function.
+func (di *DiskImage) SetProgress(fn ProgressFunc) {
+	di.progress = fn
+}
+
+// report passes an event to the disk's progress function, if any.
+func (di *DiskImage) report(stage Stage, file string, done, total int64) {
+	if di.progress != nil {
+		if total >= 0 && done > total {
+			done = total
+		}
+		di.progress(ProgressEvent{Stage: stage, File: file, Done: done, Total: total})
+	}
+}
+
+// progressWriter reports the bytes written through it.
+type progressWriter struct {
+	w     io.Writer
+	di    *DiskImage
+	stage Stage
+	file  string
+	done  int64
+	total int64
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.done += int64(n)
+	p.di.report(p.stage, p.file, p.done, p.total)
+	return n, err
+}
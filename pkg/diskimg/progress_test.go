@@ -0,0 +1,75 @@
+package diskimg
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Each stage reports rising progress that ends complete.
+func TestProgress(t *testing.T) {
+	var events []ProgressEvent
+	last := func(stage Stage) ProgressEvent {
+		t.Helper()
+		var e ProgressEvent
+		n := 0
+		for _, ev := range events {
+			if ev.Stage != stage {
+				continue
+			}
+			if ev.Done < e.Done {
+				t.Errorf("%s went back from %d to %d", stage, e.Done, ev.Done)
+			}
+			e = ev
+			n++
+		}
+		if n == 0 {
+			t.Fatalf("no %s events", stage)
+		}
+		if e.Done != e.Total {
+			t.Errorf("%s ended at %d of %d", stage, e.Done, e.Total)
+		}
+		return e
+	}
+
+	var image bytes.Buffer
+	if err := newSpecImage(t, SpecPlus3).Save(&image); err != nil {
+		t.Fatal(err)
+	}
+	record := func(e ProgressEvent) { events = append(events, e) }
+	di, err := LoadWithOptions(bytes.NewReader(image.Bytes()), &LoadOptions{Progress: record})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := last(StageLoad); e.Total != int64(image.Len()) {
+		t.Errorf("load total = %d, want %d", e.Total, image.Len())
+	}
+
+	dir := t.TempDir()
+	host := filepath.Join(dir, "big.bin")
+	if err := os.WriteFile(host, make([]byte, 100000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.ImportFile(host, "BIG.BIN", &ImportOptions{AddHeader: true, FileType: FileTypeCode}); err != nil {
+		t.Fatal(err)
+	}
+	if e := last(StageImport); e.File != "BIG.BIN" || e.Total != 100000+HeaderSize {
+		t.Errorf("import ended with %+v", e)
+	}
+	if err := di.ExportFile("BIG.BIN", filepath.Join(dir, "out.bin"), true); err != nil {
+		t.Fatal(err)
+	}
+	if e := last(StageExport); e.Total != 100000 {
+		t.Errorf("export total = %d, want 100000", e.Total)
+	}
+
+	if err := di.Save(&image); err != nil {
+		t.Fatal(err)
+	}
+	last(StageSave)
+	if err := di.validateTrackData(); err != nil {
+		t.Fatal(err)
+	}
+	last(StageValidate)
+}
@@ -0,0 +1,105 @@
+// file: pkg/diskimg/raw.go
+
+package diskimg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Raw images are plain, headerless sector dumps as used by flash-drive
+// emulators and some emulators: the format's sectors in track order, both
+// sides of a cylinder together, with no track information. A 180K +3 image is
+// 184320 bytes and a 720K image 737280 bytes.
+
+// RawSize returns the size in bytes of a raw image of the format.
+func (s DiskSpec) RawSize() int {
+	return s.TotalTracks() * s.SectorsPerTrack * s.SectorSize
+}
+
+// RawSpec picks the format of a raw image. A disk specification at the start
+// of the boot sector decides it if it matches the image size; otherwise the
+// format is picked from the size. Formats of the same size (the +3 and CPC
+// formats are all 180K) are told apart by the disk type byte, and the +3
+// format is assumed if there is none.
+func RawSpec(data []byte) (DiskSpec, error) {
+	if s, ok := specFromBootSector(data); ok && s.RawSize() == len(data) {
+		return withBootRecord(s, data[:s.SectorSize]), nil
+	}
+	var match []DiskSpec
+	for _, s := range knownSpecs {
+		if s.RawSize() == len(data) {
+			match = append(match, s)
+		}
+	}
+	if len(match) == 0 {
+		return DiskSpec{}, fmt.Errorf("%w: %d bytes is not the size of a raw image", ErrCorruptImage, len(data))
+	}
+	for _, s := range match {
+		if s.diskType() == data[0] {
+			return s, nil
+		}
+	}
+	return match[0], nil
+}
+
+// LoadRaw reads a raw sector image, picking its format with RawSpec.
+func LoadRaw(r io.Reader) (*DiskImage, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read raw image: %w", err)
+	}
+	spec, err := RawSpec(data)
+	if err != nil {
+		return nil, err
+	}
+	return LoadRawWithSpec(bytes.NewReader(data), spec)
+}
+
+// LoadRawWithSpec reads a raw sector image in the given format.
+func LoadRawWithSpec(r io.Reader, spec DiskSpec) (*DiskImage, error) {
+	di, err := NewDiskImageWithSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	sector := make([]byte, spec.SectorSize)
+	for t := 0; t < spec.TotalTracks(); t++ {
+		for s := 0; s < spec.SectorsPerTrack; s++ {
+			if _, err := io.ReadFull(r, sector); err != nil {
+				return nil, fmt.Errorf("%w: raw image too short for the %s format", ErrCorruptImage, spec.Name)
+			}
+			if err := di.SetSectorData(t/spec.Sides, s, t%spec.Sides, sector); err != nil {
+				return nil, err
+			}
+		}
+	}
+	di.loadDirectory()
+	di.Modified = false
+	return di, nil
+}
+
+// SaveRaw writes the disk image as a raw sector image: the sectors of the
+// format's tracks, in track order. Sectors beyond the format (extra tracks or
+// sectors of a physical dump) are not written, and sectors missing from the
+// image are written as format filler.
+func (di *DiskImage) SaveRaw(w io.Writer) error {
+	if err := di.FlushDirectory(); err != nil {
+		return err
+	}
+	spec := di.spec
+	filler := bytes.Repeat([]byte{0xE5}, spec.SectorSize)
+	buf := make([]byte, spec.SectorSize)
+	for t := 0; t < spec.TotalTracks(); t++ {
+		for s := 0; s < spec.SectorsPerTrack; s++ {
+			data := buf
+			if n, err := di.ReadSectorInto(buf, t/spec.Sides, s, t%spec.Sides); err != nil || n != spec.SectorSize {
+				data = filler
+			}
+			if _, err := w.Write(data); err != nil {
+				return fmt.Errorf("failed to write raw image: %w", err)
+			}
+		}
+	}
+	return nil
+}
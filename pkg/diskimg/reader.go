@@ -3,21 +3,66 @@
 package diskimg
 
 import (
+	"bytes"
+	"cmp"
 	"errors"
+	"fmt"
 	"io"
 	"os"
-
-	"github.com/ha1tch/plus3/internal"
+	"slices"
 )
 
+// LoadOptions configures how a DSK image is loaded.
+type LoadOptions struct {
+	// Salvage loads a damaged image instead of rejecting it. Tracks whose
+	// information block has a bad signature are given a fresh one, and sectors
+	// missing from a truncated image are filled with the format filler byte.
+	// Every such repair is counted per track; see DiskImage.Concealments.
+	Salvage bool
+
+	// Fidelity keeps copy-protection metadata exactly as dumped. The sector
+	// layout (duplicated IDs, actual data lengths, weak sector copies) is
+	// always kept; with Fidelity the FDC status bytes of a sector are also
+	// left alone when its data is rewritten, rather than cleared.
+	Fidelity bool
+
+	// Progress, if set, is told how much of the image has been read as its
+	// tracks are loaded, and stays set on the disk (see SetProgress).
+	Progress ProgressFunc
+}
+
+// TrackConcealment records the errors concealed while loading one track in
+// salvage mode.
+type TrackConcealment struct {
+	Track        int // physical track number
+	Side         int // side number
+	BadSignature int // track information blocks replaced (0 or 1)
+	ShortReads   int // sectors missing or truncated, filled with 0xE5
+}
+
+// Total returns the number of errors concealed on the track.
+func (tc TrackConcealment) Total() int {
+	return tc.BadSignature + tc.ShortReads
+}
+
 // LoadFromFile loads a DSK image from a file.
 func LoadFromFile(filename string) (*DiskImage, error) {
+	return LoadFromFileWithOptions(filename, nil)
+}
+
+// LoadFromFileWithOptions loads a DSK image from a file using opts.
+func LoadFromFileWithOptions(filename string, opts *LoadOptions) (*DiskImage, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
-	return Load(file)
+	di, err := LoadWithOptions(file, opts)
+	if err != nil {
+		return nil, err
+	}
+	di.setOrigin(filename)
+	return di, nil
 }
 
 // Load reads a DSK image (standard "MV - CPC" or "EXTENDED CPC") from a reader.
@@ -32,34 +77,78 @@ func LoadFromFile(filename string) (*DiskImage, error) {
 // Real +3 disks (including those written by emulators and CPDRead) are almost
 // always the extended variant, so both must be handled.
 func Load(r io.Reader) (*DiskImage, error) {
+	return LoadWithOptions(r, nil)
+}
+
+// LoadWithOptions reads a DSK image from a reader using opts. A nil opts is
+// the same as Load.
+func LoadWithOptions(r io.Reader, opts *LoadOptions) (*DiskImage, error) {
 	raw, err := io.ReadAll(r)
 	if err != nil {
-		return nil, errors.New("failed to read disk image")
-	}
-	if len(raw) < 256 {
-		return nil, errors.New("disk image too small")
+		return nil, fmt.Errorf("failed to read disk image: %w", err)
 	}
+	return load(bytes.NewReader(raw), int64(len(raw)), opts, false, nil)
+}
 
-	di := &DiskImage{
-		sectorMap: internal.NewSectorMap(),
-		directory: Directory{Entries: make([]DirectoryEntry, MaxDirectoryEntries)},
+// load reads a DSK image of size bytes from r. Unless lazy is set every track
+// is read before it returns; otherwise only the first track and those holding
+// the directory are, and the rest are read from r when first used. m is the
+// mapping r reads, if the image is mapped.
+func load(r io.ReaderAt, size int64, opts *LoadOptions, lazy bool, m *mapping) (*DiskImage, error) {
+	if opts == nil {
+		opts = &LoadOptions{}
+	}
+	if size < 256 {
+		return nil, fmt.Errorf("%w: disk image too small", ErrCorruptImage)
 	}
+	dib, err := readUpTo(r, size, 0, 256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read disk image: %w", err)
+	}
+
+	di := &DiskImage{fidelity: opts.Fidelity, progress: opts.Progress}
 
 	// Parse the 256-byte disc information block.
-	copy(di.Header.Signature[:], raw[0:34])
-	copy(di.Header.Creator[:], raw[34:48])
-	di.Header.TracksNum = raw[48]
-	di.Header.SidesNum = raw[49]
-	di.Header.TrackSize = uint16(raw[50]) | uint16(raw[51])<<8
+	copy(di.Header.Signature[:], dib[0:34])
+	copy(di.Header.Creator[:], dib[34:48])
+	di.Header.TracksNum = dib[48]
+	di.Header.SidesNum = dib[49]
+	di.Header.TrackSize = uint16(dib[50]) | uint16(dib[51])<<8
 
-	extended := string(raw[0:8]) == "EXTENDED"
-	if !extended && string(raw[0:8]) != "MV - CPC" {
-		return nil, errors.New("invalid disk image signature")
+	extended := string(dib[0:8]) == "EXTENDED"
+	if !extended && string(dib[0:8]) != "MV - CPC" {
+		return nil, fmt.Errorf("%w: invalid disk image signature", ErrCorruptImage)
+	}
+
+	// The lowest sector ID of the first track tells the CPC formats apart, and
+	// that sector itself may hold a disk specification.
+	sectorID, sizeCode := 1, byte(2)
+	var boot []byte
+	info, err := readUpTo(r, size, 0x100, 0x100)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read disk image: %w", err)
+	}
+	if len(info) == 0x100 && string(info[0:10]) == "Track-Info" {
+		if ti, err := parseTrackInfo(info); err == nil && len(ti.SectorInfo) > 0 {
+			first := 0
+			for i, si := range ti.SectorInfo {
+				if si.SectorID < ti.SectorInfo[first].SectorID {
+					first = i
+				}
+			}
+			sectorID, sizeCode = int(ti.SectorInfo[first].SectorID), ti.SectorInfo[first].Size
+			off, n := ti.sectorOffset(first)
+			if boot, err = readUpTo(r, size, int64(0x100+off), n); err != nil {
+				return nil, fmt.Errorf("failed to read disk image: %w", err)
+			}
+		}
 	}
 
-	if err := di.validateHeader(extended); err != nil {
+	if err := di.validateHeader(extended, sectorID, sizeCode, boot); err != nil {
 		return nil, err
 	}
+	di.DiskType = di.spec.diskType()
+	di.directory = Directory{Entries: emptyEntries(di.spec.DirEntries())}
 
 	trackCount := int(di.Header.TracksNum) * int(di.Header.SidesNum)
 
@@ -67,9 +156,12 @@ func Load(r io.Reader) (*DiskImage, error) {
 	trackSizes := make([]int, trackCount)
 	if extended {
 		// Per-track size table at offset 0x34, one byte per track (value * 256).
-		table := raw[0x34:]
+		table, err := readUpTo(r, size, 0x34, trackCount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read disk image: %w", err)
+		}
 		if len(table) < trackCount {
-			return nil, errors.New("extended track size table truncated")
+			return nil, fmt.Errorf("%w: extended track size table truncated", ErrCorruptImage)
 		}
 		for i := 0; i < trackCount; i++ {
 			trackSizes[i] = int(table[i]) * 256
@@ -80,67 +172,193 @@ func Load(r io.Reader) (*DiskImage, error) {
 		}
 	}
 
-	totalSectors := trackCount * SectorsPerTrack
-	di.allocation = newSectorAllocation(totalSectors)
+	// Track data starts at offset 0x100; each track block is its table size.
+	// An absent track (extended format) has size 0 and stays nil.
+	l := &lazyTracks{r: r, size: size, sizes: trackSizes, salvage: opts.Salvage, mapped: m}
+	l.offsets = make([]int64, trackCount)
+	l.loaded = make([]bool, trackCount)
+	off := int64(0x100)
+	for i, n := range trackSizes {
+		l.offsets[i] = off
+		off += int64(n)
+		if off > size && n > 0 && !opts.Salvage {
+			return nil, fmt.Errorf("%w: track data extends past end of image", ErrCorruptImage)
+		}
+	}
+
+	di.allocation = newSectorAllocation(di.spec)
 	di.fileAlloc = newFileAllocation(di)
 	di.Tracks = make([][]byte, trackCount)
+	di.lazy = l
+	if m != nil {
+		di.shared = make([]bool, trackCount)
+	}
+	if lazy {
+		if err := di.loadTrack(0); err != nil {
+			return nil, err
+		}
+		for n := 0; n < di.directorySectors(); n++ {
+			track, _, side := di.directorySector(n)
+			if err := di.loadTrack(di.trackIndex(track, side)); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		if err := di.LoadTracks(); err != nil {
+			return nil, err
+		}
+		di.lazy = nil
+	}
 
-	// Track data starts at offset 0x100; each track block is its table size.
-	off := 0x100
-	for i := 0; i < trackCount; i++ {
-		size := trackSizes[i]
-		if size == 0 {
-			// Absent track (extended format) - store an empty placeholder.
-			di.Tracks[i] = nil
-			continue
+	di.loadDirectory()
+	di.Modified = false
+	logger.Debug("loaded disk image", "bytes", size, "container", di.Container(),
+		"format", di.spec.Name, "tracks", di.Header.TracksNum, "sides", di.Header.SidesNum, "lazy", lazy, "mapped", m != nil)
+	return di, nil
+}
+
+// loadTrack reads the track block at idx from the image, if it has not been
+// read yet, conceals its errors in salvage mode and checks its signature.
+func (di *DiskImage) loadTrack(idx int) error {
+	l := di.lazy
+	if l == nil || idx >= len(l.sizes) || l.loaded[idx] || l.sizes[idx] == 0 {
+		return nil
+	}
+	size := l.sizes[idx]
+	if m := l.mapped; m != nil {
+		off := l.offsets[idx]
+		if end := off + int64(size); end <= int64(len(m.data)) && size >= 10 && string(m.data[off:off+10]) == "Track-Info" {
+			// A sound track of a mapped image is used where it lies; a
+			// read-only mapping is copied before it is written.
+			di.Tracks[idx] = m.data[off:end:end]
+			di.shared[idx] = !m.writable
+			l.loaded[idx] = true
+			return nil
 		}
-		if off+size > len(raw) {
-			return nil, errors.New("track data extends past end of image")
+	}
+	track, side := idx/int(di.Header.SidesNum), idx%int(di.Header.SidesNum)
+	tc := TrackConcealment{Track: track, Side: side}
+	block := make([]byte, size)
+	avail, err := readUpTo(l.r, l.size, l.offsets[idx], size)
+	if err != nil {
+		return sectorError("load", track, side, -1, err)
+	}
+	copy(block, avail)
+	if len(avail) < size {
+		if !l.salvage {
+			return sectorError("load", track, side, -1, fmt.Errorf("%w: track data extends past end of image", ErrCorruptImage))
 		}
-		block := make([]byte, size)
-		copy(block, raw[off:off+size])
-		di.Tracks[i] = block
-		off += size
-
-		// Light sanity check: the track information block signature. Match only
-		// the "Track-Info" prefix - the spec specifies "Track-Info\r\n" but real
-		// writers (e.g. some emulators) pad with NULs instead of CR/LF.
-		if size >= 10 && string(block[0:10]) != "Track-Info" {
-			return nil, errors.New("invalid track information block signature")
+		// Truncated image: conceal the missing sectors with format filler.
+		tc.ShortReads = fillShortTrack(di.spec, block, len(avail), track, side)
+	}
+
+	// Light sanity check: the track information block signature. Match only
+	// the "Track-Info" prefix - the spec specifies "Track-Info\r\n" but real
+	// writers (e.g. some emulators) pad with NULs instead of CR/LF.
+	if size >= 10 && string(block[0:10]) != "Track-Info" {
+		if !l.salvage {
+			return sectorError("load", track, side, -1, fmt.Errorf("%w: invalid track information block signature", ErrCorruptImage))
 		}
+		// Replace the damaged information block, keeping the sector data.
+		copy(block, formatTrack(di.spec, track, side)[:min(256, size)])
+		tc.BadSignature = 1
+	}
+	if tc.Total() > 0 {
+		logger.Info("concealed damaged track", "track", track, "side", side,
+			"bad_signature", tc.BadSignature, "short_reads", tc.ShortReads)
+		di.concealments = append(di.concealments, tc)
+	}
+	di.Tracks[idx] = block
+	l.loaded[idx] = true
+	return nil
+}
+
+// readUpTo reads n bytes at off from r, an image of size bytes, or as many
+// as the image holds.
+func readUpTo(r io.ReaderAt, size, off int64, n int) ([]byte, error) {
+	if avail := size - off; avail < int64(n) {
+		n = int(max(avail, 0))
+	}
+	buf := make([]byte, n)
+	if n == 0 {
+		return buf, nil
 	}
+	if _, err := r.ReadAt(buf, off); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return buf, nil
+}
 
-	// Populate the in-memory directory from the disk so file operations
-	// (add/find/delete) see the existing entries and free slots.
+// loadDirectory populates the in-memory directory from the disk so file
+// operations (add/find/delete) see the existing entries and free slots.
+func (di *DiskImage) loadDirectory() {
 	if entries, err := di.GetDirectory(); err == nil {
 		copy(di.directory.Entries, entries)
-		// Reconcile the block allocator with the blocks already occupied by
-		// existing files, so a subsequently added file does not reuse and
-		// overwrite them.
-		di.fileAlloc.markUsedBlocks(di.directory.Entries)
+		di.directory.migrate()
+		di.directory.Reindex()
+		// Rebuild the block and sector allocation from the blocks the
+		// directory gives its files, so free space is what the files leave
+		// and a subsequently added file does not reuse and overwrite them.
+		di.fileAlloc.rebuild(di.directory.Entries)
 	}
+}
 
-	di.Modified = false
-	return di, nil
+// fillShortTrack fills the part of a track block beyond the first avail bytes
+// that the image actually supplied: a truncated information block is rebuilt
+// for the given track and side, and missing sector data is set to the 0xE5
+// format filler. It returns the number of sectors that were missing or
+// truncated.
+func fillShortTrack(spec DiskSpec, block []byte, avail, track, side int) int {
+	for i := avail; i < len(block); i++ {
+		block[i] = 0xE5
+	}
+	if avail < 256 {
+		copy(block, formatTrack(spec, track, side)[:min(256, len(block))])
+	}
+	short := 0
+	for sec := 256; sec < len(block); sec += spec.SectorSize {
+		if sec+spec.SectorSize > avail {
+			short++
+		}
+	}
+	return short
+}
+
+// Concealments returns the per-track errors concealed when the image was
+// loaded in salvage mode, for tracks with at least one concealed error. It is
+// empty for an image loaded normally or one that needed no repair.
+func (di *DiskImage) Concealments() []TrackConcealment {
+	di.LoadTracks() // a lazily loaded disk conceals errors as tracks are read
+	cs := slices.Clone(di.concealments)
+	slices.SortFunc(cs, func(a, b TrackConcealment) int {
+		return cmp.Or(a.Track-b.Track, a.Side-b.Side)
+	})
+	return cs
 }
 
-// validateHeader checks the disc-information block for a plausible +3 disk.
-func (di *DiskImage) validateHeader(extended bool) error {
+// validateHeader checks the disc-information block for a plausible +3 disk
+// and selects the disk's format. A disk specification in the boot sector
+// decides the format when it fits the image, and a PCW boot checksum marks a
+// PCW disk; otherwise the format is picked from the geometry and first sector
+// ID.
+func (di *DiskImage) validateHeader(extended bool, sectorID int, sizeCode byte, boot []byte) error {
 	// The standard +3 logical format is 40 tracks, but real .dsk images carry
 	// physical tracks beyond that (commonly 40-43, up to ~45). Accept the range.
-	if di.Header.TracksNum < TracksPerSide || di.Header.TracksNum > MaxTracksPerSide {
-		return errors.New("invalid number of tracks for +3 format")
-	}
-	if di.Header.SidesNum != SidesPerDisk {
-		return errors.New("invalid number of sides for +3 format")
-	}
-	// For the standard variant the header track size must be the +3 track size;
-	// for the extended variant the header field is 0 and sizes live in the table.
-	if !extended {
-		expected := 256 + BytesPerSector*SectorsPerTrack // track info block + sector data
-		if int(di.Header.TrackSize) != expected {
-			return errors.New("invalid track size for +3 format")
-		}
+	spec, err := specForGeometry(int(di.Header.TracksNum), int(di.Header.SidesNum), sectorID)
+	if s, ok := specFromBootSector(boot); ok && sectorID&0xC0 == 0 &&
+		s.Sides == int(di.Header.SidesNum) && s.TracksPerSide <= int(di.Header.TracksNum) &&
+		s.SectorSize == 128<<min(int(sizeCode), 6) {
+		spec, err = withBootRecord(s, boot), nil
+	}
+	if err != nil {
+		return err
+	}
+	di.spec = spec
+	// For the standard variant the header track size is the size of every
+	// track and must hold the format's track (some writers pad it); for the
+	// extended variant the header field is 0 and sizes live in the table.
+	if !extended && int(di.Header.TrackSize) < spec.TrackSize() {
+		return fmt.Errorf("%w: invalid track size for %s format", ErrCorruptImage, spec.Name)
 	}
 	return nil
 }
@@ -0,0 +1,406 @@
+package diskimg
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io/fs"
+	"testing"
+)
+
+// savedImage returns the bytes of a freshly formatted, saved disk image.
+func savedImage(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := NewDiskImage().Save(&buf); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// A truncated image is rejected normally, but loads in salvage mode with the
+// missing sectors counted against the tracks they belong to.
+func TestSalvageConcealsShortRead(t *testing.T) {
+	image := savedImage(t)
+	trackSize := 256 + SectorsPerTrack*BytesPerSector
+	// Cut the image three sectors into the data area of the last track.
+	cut := 0x100 + (TracksPerSide-1)*trackSize + 256 + 3*BytesPerSector
+	image = image[:cut]
+
+	if _, err := Load(bytes.NewReader(image)); err == nil {
+		t.Fatal("Load accepted a truncated image; want an error")
+	}
+	di, err := LoadWithOptions(bytes.NewReader(image), &LoadOptions{Salvage: true})
+	if err != nil {
+		t.Fatalf("salvage load: %v", err)
+	}
+	stats := di.Concealments()
+	if len(stats) != 1 {
+		t.Fatalf("concealments = %+v, want one track", stats)
+	}
+	if got := stats[0]; got.Track != TracksPerSide-1 || got.ShortReads != SectorsPerTrack-3 || got.BadSignature != 0 {
+		t.Errorf("concealment = %+v, want track %d with %d short reads", got, TracksPerSide-1, SectorsPerTrack-3)
+	}
+}
+
+// A damaged track information block is replaced in salvage mode and counted as
+// a bad signature; the sector data of that track is kept.
+func TestSalvageConcealsBadSignature(t *testing.T) {
+	image := savedImage(t)
+	trackSize := 256 + SectorsPerTrack*BytesPerSector
+	off := 0x100 + 5*trackSize
+	copy(image[off:], "Garbage!!!")
+	image[off+256] = 0x42 // first data byte of the track
+
+	if _, err := Load(bytes.NewReader(image)); err == nil {
+		t.Fatal("Load accepted a bad track signature; want an error")
+	}
+	di, err := LoadWithOptions(bytes.NewReader(image), &LoadOptions{Salvage: true})
+	if err != nil {
+		t.Fatalf("salvage load: %v", err)
+	}
+	stats := di.Concealments()
+	if len(stats) != 1 || stats[0].Track != 5 || stats[0].BadSignature != 1 || stats[0].ShortReads != 0 {
+		t.Fatalf("concealments = %+v, want a bad signature on track 5", stats)
+	}
+	data, err := di.GetSectorData(5, 0, 0)
+	if err != nil {
+		t.Fatalf("GetSectorData: %v", err)
+	}
+	if data[0] != 0x42 {
+		t.Errorf("sector data lost: first byte %#x, want 0x42", data[0])
+	}
+}
+
+// A clean image loaded in salvage mode reports nothing concealed.
+func TestSalvageCleanImage(t *testing.T) {
+	di, err := LoadWithOptions(bytes.NewReader(savedImage(t)), &LoadOptions{Salvage: true})
+	if err != nil {
+		t.Fatalf("salvage load: %v", err)
+	}
+	if stats := di.Concealments(); len(stats) != 0 {
+		t.Errorf("concealments = %+v, want none", stats)
+	}
+}
+
+// An image saved in the extended container loads back as extended, with the
+// same track data as the standard container.
+func TestExtendedContainerRoundTrip(t *testing.T) {
+	di := NewDiskImage()
+	sector := bytes.Repeat([]byte{0x5A}, BytesPerSector)
+	if err := di.SetSectorData(3, 4, 0, sector); err != nil {
+		t.Fatalf("SetSectorData: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := di.SaveContainer(&buf, ContainerExtended); err != nil {
+		t.Fatalf("SaveContainer: %v", err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("EXTENDED CPC DSK File")) {
+		t.Fatalf("signature = %q, want EXTENDED", buf.Bytes()[:21])
+	}
+
+	reloaded, err := Load(&buf)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if reloaded.Container() != ContainerExtended {
+		t.Errorf("container = %v, want extended", reloaded.Container())
+	}
+	got, err := reloaded.GetSectorData(3, 4, 0)
+	if err != nil {
+		t.Fatalf("GetSectorData: %v", err)
+	}
+	if !bytes.Equal(got, sector) {
+		t.Error("sector data differs after an extended round trip")
+	}
+}
+
+// An extended image with an odd track and an absent one round-trips with its
+// per-track size table intact; the standard container pads to the largest.
+func TestExtendedPerTrackSizes(t *testing.T) {
+	di := NewDiskImage()
+	di.Header.TracksNum = TracksPerSide + 2
+	oddTrack := bytes.Repeat([]byte{0x6B}, 256+10*BytesPerSector)
+	copy(oddTrack, "Track-Info\r\n")
+	di.Tracks = append(di.Tracks, oddTrack, nil)
+
+	var buf bytes.Buffer
+	if err := di.SaveContainer(&buf, ContainerExtended); err != nil {
+		t.Fatalf("SaveContainer: %v", err)
+	}
+	image := buf.Bytes()
+	if got := image[0x34+TracksPerSide]; int(got)*256 != len(oddTrack) {
+		t.Errorf("odd track size entry = %#x, want %#x", got, len(oddTrack)/256)
+	}
+	if got := image[0x34+TracksPerSide+1]; got != 0 {
+		t.Errorf("absent track size entry = %#x, want 0", got)
+	}
+
+	loaded, err := Load(bytes.NewReader(image))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !bytes.Equal(loaded.Tracks[TracksPerSide], oddTrack) || loaded.Tracks[TracksPerSide+1] != nil {
+		t.Error("odd or absent track changed across an extended round trip")
+	}
+
+	buf.Reset()
+	if err := loaded.SaveContainer(&buf, ContainerStandard); err != nil {
+		t.Fatalf("SaveContainer: %v", err)
+	}
+	image = buf.Bytes()
+	if got := int(image[0x32]) | int(image[0x33])<<8; got != len(oddTrack) {
+		t.Errorf("standard track size = %d, want %d", got, len(oddTrack))
+	}
+	if want := 0x100 + (TracksPerSide+2)*len(oddTrack); len(image) != want {
+		t.Errorf("standard image size = %d, want %d", len(image), want)
+	}
+}
+
+// A track mixing 256- and 1024-byte sectors loads, and each sector is read at
+// its own size and offset.
+func TestMixedSectorSizes(t *testing.T) {
+	di := NewDiskImage()
+	sizes := []int{256, 1024, 256}
+	block := make([]byte, 256, 256+256+1024+256)
+	copy(block, "Track-Info\r\n")
+	block[0x10], block[0x14], block[0x15] = 39, 2, byte(len(sizes))
+	for i, size := range sizes {
+		si := block[0x18+i*8:]
+		si[0], si[2], si[3] = 39, byte(i+1), sectorSizeCode(size)
+		block = append(block, bytes.Repeat([]byte{byte(0xA0 + i)}, size)...)
+	}
+	di.Tracks[di.trackIndex(39, 0)] = block
+
+	var buf bytes.Buffer
+	if err := di.SaveContainer(&buf, ContainerExtended); err != nil {
+		t.Fatalf("SaveContainer: %v", err)
+	}
+	loaded, err := Load(&buf)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	ti, err := loaded.GetTrackInfo(39, 0)
+	if err != nil {
+		t.Fatalf("GetTrackInfo: %v", err)
+	}
+	if err := ti.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+	for i, size := range sizes {
+		data, err := loaded.GetSectorData(39, i, 0)
+		if err != nil {
+			t.Fatalf("GetSectorData(%d): %v", i, err)
+		}
+		if len(data) != size || data[0] != byte(0xA0+i) || data[size-1] != byte(0xA0+i) {
+			t.Errorf("sector %d: %d bytes starting %#x, want %d bytes of %#x", i, len(data), data[0], size, 0xA0+i)
+		}
+	}
+	if err := loaded.SetSectorData(39, 1, 0, make([]byte, 512)); !errors.Is(err, ErrInvalidSectorSize) {
+		t.Errorf("SetSectorData with the wrong size: err = %v, want ErrInvalidSectorSize", err)
+	}
+}
+
+// protectedTrack returns a track like those of copy-protected dumps: sector 1
+// is weak (three differing reads), sector 2 has a data CRC error and sectors 3
+// and 4 share an ID.
+func protectedTrack(track int) []byte {
+	block := make([]byte, 256)
+	copy(block, "Track-Info\r\n")
+	block[0x10], block[0x14], block[0x15] = byte(track), 2, 5
+	for i, id := range []byte{1, 2, 3, 3, 5} {
+		si := block[0x18+i*8:]
+		si[0], si[2], si[3] = byte(track), id, 2
+		copies := 1
+		if i == 1 {
+			copies = 3
+			si[6], si[7] = byte(3*512&0xFF), byte(3*512>>8)
+		}
+		if i == 2 {
+			si[4], si[5] = 0x20, 0x20 // ST1 DE, ST2 DD
+		}
+		for c := 0; c < copies; c++ {
+			block = append(block, bytes.Repeat([]byte{byte(0x10*i + c)}, 512)...)
+		}
+	}
+	return block
+}
+
+// Weak sectors, FDC status bytes and duplicated IDs survive a load and save;
+// the first copy of a weak sector is its data. A rewritten sector loses its
+// error status unless the image was loaded with Fidelity.
+func TestCopyProtectionRoundTrip(t *testing.T) {
+	di := NewDiskImage()
+	track := protectedTrack(39)
+	di.Tracks[di.trackIndex(39, 0)] = track
+	var buf bytes.Buffer
+	if err := di.SaveContainer(&buf, ContainerExtended); err != nil {
+		t.Fatalf("SaveContainer: %v", err)
+	}
+	image := buf.Bytes()
+
+	for _, fidelity := range []bool{false, true} {
+		loaded, err := LoadWithOptions(bytes.NewReader(image), &LoadOptions{Fidelity: fidelity})
+		if err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+		var out bytes.Buffer
+		if err := loaded.Save(&out); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		if !bytes.Equal(out.Bytes(), image) {
+			t.Errorf("fidelity %v: image changed across a load and save", fidelity)
+		}
+
+		copies, err := loaded.GetSectorCopies(39, 1, 0)
+		if err != nil {
+			t.Fatalf("GetSectorCopies: %v", err)
+		}
+		if len(copies) != 3 || copies[0][0] != 0x10 || copies[2][511] != 0x12 {
+			t.Errorf("weak sector: %d copies", len(copies))
+		}
+		if data, _ := loaded.GetSectorData(39, 1, 0); len(data) != 512 || data[0] != 0x10 {
+			t.Errorf("weak sector data: %d bytes starting %#x, want the first copy", len(data), data[0])
+		}
+		if data, _ := loaded.GetSectorData(39, 4, 0); data[0] != 0x40 {
+			t.Errorf("sector after the weak one starts %#x, want 0x40", data[0])
+		}
+
+		if err := loaded.SetSectorData(39, 2, 0, make([]byte, 512)); err != nil {
+			t.Fatalf("SetSectorData: %v", err)
+		}
+		ti, _ := loaded.GetTrackInfo(39, 0)
+		if kept := ti.SectorInfo[2].Status2 == 0x20; kept != fidelity {
+			t.Errorf("fidelity %v: status after a rewrite = %#x", fidelity, ti.SectorInfo[2].Status2)
+		}
+	}
+}
+
+// Save keeps the container an image was loaded in, and a standard image whose
+// header track size is padded beyond the format's still loads.
+func TestSavePreservesContainer(t *testing.T) {
+	for _, c := range []Container{ContainerStandard, ContainerExtended} {
+		di := NewDiskImage()
+		di.SetContainer(c)
+		var buf bytes.Buffer
+		if err := di.Save(&buf); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		loaded, err := Load(&buf)
+		if err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+		buf.Reset()
+		if err := loaded.Save(&buf); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		if got, _ := Load(&buf); got.Container() != c {
+			t.Errorf("container after load and save = %v, want %v", got.Container(), c)
+		}
+	}
+
+	// Pad every track of a standard image by 256 bytes.
+	image := savedImage(t)
+	trackSize := 256 + SectorsPerTrack*BytesPerSector
+	padded := append([]byte(nil), image[:0x100]...)
+	padded[0x32], padded[0x33] = byte((trackSize+256)&0xFF), byte((trackSize+256)>>8)
+	for off := 0x100; off < len(image); off += trackSize {
+		padded = append(padded, image[off:off+trackSize]...)
+		padded = append(padded, make([]byte, 256)...)
+	}
+	if _, err := Load(bytes.NewReader(padded)); err != nil {
+		t.Errorf("padded standard image: %v", err)
+	}
+}
+
+// Load failures caused by the image contents wrap ErrCorruptImage, so callers
+// can tell a damaged image from an I/O error.
+func TestLoadCorruptWrapsSentinel(t *testing.T) {
+	image := savedImage(t)
+	copy(image, "NOT A DISK")
+	if _, err := Load(bytes.NewReader(image)); !errors.Is(err, ErrCorruptImage) {
+		t.Errorf("bad signature: err = %v, want ErrCorruptImage", err)
+	}
+	if _, err := Load(bytes.NewReader(image[:100])); !errors.Is(err, ErrCorruptImage) {
+		t.Errorf("short image: err = %v, want ErrCorruptImage", err)
+	}
+}
+
+// A raw sector image holds exactly the format's sectors and loads back in the
+// same format with the same data.
+func TestRawRoundTrip(t *testing.T) {
+	for _, spec := range []DiskSpec{SpecPlus3, SpecPlus3DS} {
+		di, err := NewDiskImageWithSpec(spec)
+		if err != nil {
+			t.Fatalf("NewDiskImageWithSpec: %v", err)
+		}
+		side := spec.Sides - 1
+		sector := bytes.Repeat([]byte{0x3C}, spec.SectorSize)
+		if err := di.SetSectorData(7, 5, side, sector); err != nil {
+			t.Fatalf("SetSectorData: %v", err)
+		}
+
+		var buf bytes.Buffer
+		if err := di.SaveRaw(&buf); err != nil {
+			t.Fatalf("SaveRaw: %v", err)
+		}
+		if buf.Len() != spec.RawSize() {
+			t.Errorf("%s: raw size = %d, want %d", spec.Name, buf.Len(), spec.RawSize())
+		}
+		loaded, err := LoadRaw(&buf)
+		if err != nil {
+			t.Fatalf("LoadRaw: %v", err)
+		}
+		if loaded.Spec() != spec {
+			t.Errorf("raw format = %s, want %s", loaded.Spec().Name, spec.Name)
+		}
+		if got, _ := loaded.GetSectorData(7, 5, side); !bytes.Equal(got, sector) {
+			t.Errorf("%s: sector data differs after a raw round trip", spec.Name)
+		}
+	}
+
+	if _, err := LoadRaw(bytes.NewReader(make([]byte, 1000))); !errors.Is(err, ErrCorruptImage) {
+		t.Errorf("odd-sized raw image: err = %v, want ErrCorruptImage", err)
+	}
+}
+
+// Disk images are found and loaded inside a ZIP archive without unpacking it.
+func TestLoadFromZip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range map[string][]byte{
+		"Games/Game (1987).dsk": savedImage(t),
+		"Games/readme.txt":      []byte("not a disk"),
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	images, err := FindImages(zr)
+	if err != nil {
+		t.Fatalf("FindImages: %v", err)
+	}
+	if len(images) != 1 || images[0] != "Games/Game (1987).dsk" {
+		t.Fatalf("FindImages = %q", images)
+	}
+	di, err := LoadFromFS(zr, images[0], nil)
+	if err != nil {
+		t.Fatalf("LoadFromFS: %v", err)
+	}
+	if di.Spec().Name != SpecPlus3.Name {
+		t.Errorf("loaded format %s, want %s", di.Spec().Name, SpecPlus3.Name)
+	}
+	if _, err := LoadFromFS(zr, "Games/missing.dsk", nil); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("LoadFromFS of a missing member: err = %v, want fs.ErrNotExist", err)
+	}
+}
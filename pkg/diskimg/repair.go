@@ -0,0 +1,126 @@
+// file: pkg/diskimg/repair.go
+
+package diskimg
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"slices"
+)
+
+// RepairOptions selects what Repair fixes.
+type RepairOptions struct {
+	// Codes lists the finding codes to repair; empty repairs every warning
+	// and error Repair can fix. Information findings are only acted on when
+	// their code is listed:
+	//
+	//	boot-checksum      set the boot sector checksum, making the disk bootable
+	//	                   (information: only when listed)
+	//	dir-duplicate      remove the duplicate directory entry
+	//	alloc-block-range  drop the block numbers outside the data area
+	//	track-short        pad the track's missing sector data with 0xE5 filler
+	//	track-concealed    accept the errors a salvage load concealed
+	//
+	// The block allocation is rebuilt from the directory after any directory
+	// repair.
+	Codes []string
+}
+
+// Repair fixes the findings of report, a report from Check on this disk (nil
+// runs Check), that opts selects and Repair can fix. It returns the findings
+// it repaired; the rest are left as they are. Save the disk to keep the
+// repairs.
+func (di *DiskImage) Repair(report *ValidationReport, opts RepairOptions) ([]Finding, error) {
+	if report == nil {
+		report = di.Check()
+	}
+	var repaired []Finding
+	dirChanged := false
+	for _, f := range report.Findings {
+		if len(opts.Codes) > 0 && !slices.Contains(opts.Codes, f.Code) {
+			continue
+		}
+		if len(opts.Codes) == 0 && f.Severity == SeverityInfo {
+			continue // not a fault; repaired only on request
+		}
+		var err error
+		switch f.Code {
+		case "boot-checksum":
+			err = di.SetBootable(true)
+		case "dir-duplicate":
+			err = di.repairEntry(f.Entry, func(e *DirectoryEntry) { e.Status = 0xE5 })
+			dirChanged = true
+		case "alloc-block-range":
+			err = di.repairEntry(f.Entry, func(e *DirectoryEntry) {
+				wide := di.spec.WideBlockPointers()
+				blocks := slices.DeleteFunc(e.blockPointers(wide), func(b int) bool { return b >= di.spec.TotalBlocks() })
+				e.setBlockPointers(blocks, wide)
+			})
+			dirChanged = true
+		case "track-short":
+			err = di.padTrack(f.Track, f.Side)
+		case "track-concealed":
+			di.acceptConcealed(f.Track, f.Side)
+		default:
+			continue
+		}
+		if err != nil {
+			return repaired, err
+		}
+		logger.Debug("repaired", "code", f.Code, "finding", f.Message)
+		repaired = append(repaired, f)
+	}
+	if dirChanged {
+		if err := di.FlushDirectory(); err != nil {
+			return repaired, err
+		}
+		di.fileAlloc.rebuild(di.directory.Entries)
+	}
+	if len(repaired) > 0 {
+		di.Modified = true
+	}
+	return repaired, nil
+}
+
+// repairEntry applies fix to the directory entry at index i.
+func (di *DiskImage) repairEntry(i int, fix func(*DirectoryEntry)) error {
+	if i < 0 || i >= len(di.directory.Entries) {
+		return fmt.Errorf("%w: no directory entry %d", fs.ErrInvalid, i)
+	}
+	fix(&di.directory.Entries[i])
+	di.directory.Reindex()
+	return nil
+}
+
+// padTrack fills out a track holding less data than its sectors need with
+// 0xE5 filler.
+func (di *DiskImage) padTrack(track, side int) error {
+	idx := di.trackIndex(track, side)
+	if idx < 0 || idx >= len(di.Tracks) {
+		return ErrInvalidTrack
+	}
+	td, err := di.track(idx)
+	if err != nil {
+		return err
+	}
+	if need := trackDataSize(td); need > len(td) {
+		di.Tracks[idx] = append(td[:len(td):len(td)], bytes.Repeat([]byte{0xE5}, need-len(td))...)
+		if idx < len(di.shared) {
+			di.shared[idx] = false
+		}
+		di.markDirty(idx)
+	}
+	return nil
+}
+
+// acceptConcealed drops the record of the errors concealed on a track, and
+// marks it to be written with its repairs.
+func (di *DiskImage) acceptConcealed(track, side int) {
+	di.concealments = slices.DeleteFunc(di.concealments, func(tc TrackConcealment) bool {
+		return tc.Track == track && tc.Side == side
+	})
+	if idx := di.trackIndex(track, side); idx >= 0 && idx < len(di.Tracks) {
+		di.markDirty(idx)
+	}
+}
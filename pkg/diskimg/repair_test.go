@@ -0,0 +1,71 @@
+package diskimg
+
+import (
+	"slices"
+	"testing"
+)
+
+// Repair fixes what it can of each kind of finding, leaving a disk that
+// checks clean.
+func TestRepair(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.writeRecords("GAME.BIN", make([]byte, 5000)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A boot sector with a specification and code but no checksum.
+	boot, _ := di.GetSectorData(0, 0, 0)
+	copy(boot, SpecPlus3.Specification().Serialize())
+	copy(boot[16:], "\xF3\xC3\x00\x80")
+	if err := di.SetSectorData(0, 0, 0, boot); err != nil {
+		t.Fatal(err)
+	}
+	// A duplicated entry, and a block number past the end of the disk.
+	game, _ := di.directory.FindFile("GAME.BIN")
+	di.directory.Entries[5] = *game
+	game.AllocationBlocks[3] = 250
+	if err := di.FlushDirectory(); err != nil {
+		t.Fatal(err)
+	}
+	// A track missing the end of its last sector.
+	di.Tracks[7] = di.Tracks[7][:len(di.Tracks[7])-100]
+
+	report := di.Check()
+	for _, code := range []string{"boot-checksum", "dir-duplicate", "alloc-block-range", "track-short"} {
+		if len(report.WithCode(code)) == 0 {
+			t.Errorf("no %s finding in %v", code, report.Findings)
+		}
+	}
+
+	fixed, err := di.Repair(report, RepairOptions{Codes: []string{"track-short"}})
+	if err != nil || len(fixed) != 1 {
+		t.Fatalf("Repair(track-short) = %v, %v", fixed, err)
+	}
+	if _, err := di.GetSectorData(7, 8, 0); err != nil {
+		t.Errorf("padded sector: %v", err)
+	}
+
+	if _, err := di.Repair(di.Check(), RepairOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if r := di.Check(); !r.OK() || r.Count(SeverityWarning) != 0 {
+		t.Errorf("after Repair: %v", r.Findings)
+	}
+	// The missing boot checksum is information, left alone unless asked for.
+	if di.Bootable() {
+		t.Error("default Repair made the disk bootable")
+	}
+	fixed, err = di.Repair(nil, RepairOptions{Codes: []string{"boot-checksum"}})
+	if err != nil || len(fixed) != 1 {
+		t.Fatalf("Repair(boot-checksum) = %v, %v", fixed, err)
+	}
+	if !di.Bootable() {
+		t.Error("boot sector not made bootable")
+	}
+	if di.directory.Entries[5].Status != 0xE5 {
+		t.Error("duplicate entry kept")
+	}
+	if blocks := game.blockPointers(false); slices.Contains(blocks, 250) {
+		t.Errorf("GAME.BIN blocks = %v", blocks)
+	}
+}
@@ -0,0 +1,144 @@
+// file: pkg/diskimg/report.go
+
+package diskimg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Severity grades a Finding.
+type Severity int
+
+const (
+	SeverityInfo    Severity = iota // worth knowing, nothing wrong
+	SeverityWarning                 // unusual or repaired, but usable
+	SeverityError                   // the disk is inconsistent; DiskCheck fails
+)
+
+// String returns "info", "warning" or "error".
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return "info"
+}
+
+// MarshalText encodes the severity by name, for JSON.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a severity encoded by MarshalText.
+func (s *Severity) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "info":
+		*s = SeverityInfo
+	case "warning":
+		*s = SeverityWarning
+	case "error":
+		*s = SeverityError
+	default:
+		return fmt.Errorf("unknown severity %q", text)
+	}
+	return nil
+}
+
+// Finding categories.
+const (
+	CategoryBoot       = "boot"       // the boot sector and disk specification
+	CategoryDirectory  = "directory"  // directory entries
+	CategoryAllocation = "allocation" // blocks allocated to files
+	CategoryTrack      = "track"      // track data, as loaded
+	CategoryFile       = "file"       // files' extents, blocks and headers, read by a paranoid check
+)
+
+// Finding is one result of Check. The location fields not relevant to it are
+// -1.
+type Finding struct {
+	Severity Severity `json:"severity"`
+	Category string   `json:"category"`
+	Code     string   `json:"code"` // stable identifier, e.g. "alloc-block-shared"
+	Message  string   `json:"message"`
+	Track    int      `json:"track"`
+	Side     int      `json:"side"`
+	Sector   int      `json:"sector"`
+	Entry    int      `json:"entry"` // directory entry index
+
+	err error // what DiskCheck returns for an error finding
+}
+
+// String describes the finding on one line.
+func (f Finding) String() string {
+	var where []string
+	if f.Track >= 0 {
+		where = append(where, fmt.Sprintf("track %d side %d", f.Track, f.Side))
+	}
+	if f.Sector >= 0 {
+		where = append(where, fmt.Sprintf("sector %d", f.Sector))
+	}
+	if f.Entry >= 0 {
+		where = append(where, fmt.Sprintf("entry %d", f.Entry))
+	}
+	s := fmt.Sprintf("%s [%s] %s", f.Severity, f.Code, f.Message)
+	if len(where) > 0 {
+		s += " (" + strings.Join(where, ", ") + ")"
+	}
+	return s
+}
+
+// ValidationReport holds the findings of Check, in the order found.
+type ValidationReport struct {
+	Findings []Finding `json:"findings"`
+}
+
+// OK reports whether the report has no error findings.
+func (r *ValidationReport) OK() bool {
+	return r.Count(SeverityError) == 0
+}
+
+// Count returns the number of findings of a severity.
+func (r *ValidationReport) Count(s Severity) int {
+	n := 0
+	for _, f := range r.Findings {
+		if f.Severity == s {
+			n++
+		}
+	}
+	return n
+}
+
+// WithCode returns the findings with the given code.
+func (r *ValidationReport) WithCode(code string) []Finding {
+	var out []Finding
+	for _, f := range r.Findings {
+		if f.Code == code {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// Err returns the first error finding as an error wrapping ErrCheckFailed,
+// or nil if there is none.
+func (r *ValidationReport) Err() error {
+	for _, f := range r.Findings {
+		if f.Severity == SeverityError {
+			return fmt.Errorf("%w: %s: %w", ErrCheckFailed, f.Category, f.err)
+		}
+	}
+	return nil
+}
+
+// add records a finding. err is what it amounts to as an error, and gives
+// the message.
+func (r *ValidationReport) add(sev Severity, category, code string, err error) *Finding {
+	r.Findings = append(r.Findings, Finding{
+		Severity: sev, Category: category, Code: code, Message: err.Error(),
+		Track: -1, Side: -1, Sector: -1, Entry: -1, err: err,
+	})
+	return &r.Findings[len(r.Findings)-1]
+}
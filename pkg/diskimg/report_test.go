@@ -0,0 +1,110 @@
+package diskimg
+
+import (
+	"errors"
+	"testing"
+)
+
+// Check reports every finding with its severity, code and location, and
+// DiskCheck fails on the first error among them.
+func TestCheckReport(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	if r := di.Check(); !r.OK() || r.Count(SeverityWarning) != 0 {
+		t.Fatalf("new disk: %v", r.Findings)
+	}
+
+	for _, name := range []string{"ONE.DAT", "TWO.DAT"} {
+		if err := di.writeRecords(name, make([]byte, 3000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	one, _ := di.directory.FindFile("ONE.DAT")
+	two, _ := di.directory.FindFile("TWO.DAT")
+	two.AllocationBlocks[0] = one.AllocationBlocks[0]
+	one.Name[0] = '*'
+	if err := di.FlushDirectory(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := di.Check()
+	if r.OK() {
+		t.Fatalf("shared block not reported: %v", r.Findings)
+	}
+	shared := r.WithCode("alloc-block-shared")
+	if len(shared) != 1 || shared[0].Severity != SeverityError || shared[0].Category != CategoryAllocation ||
+		shared[0].Entry < 0 || di.directory.Entries[shared[0].Entry].GetFilename() != "TWO.DAT" {
+		t.Errorf("alloc-block-shared = %+v", shared)
+	}
+	if bad := r.WithCode("dir-bad-name"); len(bad) != 1 || bad[0].Severity != SeverityWarning || bad[0].Entry < 0 {
+		t.Errorf("dir-bad-name = %+v", bad)
+	}
+	err := di.DiskCheck()
+	if !errors.Is(err, ErrCheckFailed) || !errors.Is(err, ErrCorruptImage) {
+		t.Errorf("DiskCheck = %v, want ErrCheckFailed and ErrCorruptImage", err)
+	}
+	if err.Error() != r.Err().Error() {
+		t.Errorf("DiskCheck = %v, report = %v", err, r.Err())
+	}
+}
+
+// A paranoid check also reads every file, reporting a bad header checksum
+// and a missing extent that the other levels pass over.
+func TestCheckLevels(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.ImportData("game.bin", make([]byte, 300), &ImportOptions{AddHeader: true, FileType: FileTypeCode, LoadAddr: 32768}); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.writeRecords("BIG.DAT", make([]byte, 40000)); err != nil {
+		t.Fatal(err)
+	}
+	if r := di.CheckWithOptions(&CheckOptions{Level: ValidationParanoid}); !r.OK() || r.Count(SeverityWarning) != 0 {
+		t.Fatalf("paranoid check of a sound disk: %v", r.Findings)
+	}
+
+	blocks, _ := di.FileBlocks("GAME.BIN")
+	data, err := di.ReadBlock(blocks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[HeaderSize-1]++
+	if err := di.WriteBlock(blocks[0], data); err != nil {
+		t.Fatal(err)
+	}
+	big, _ := di.directory.FindFile("BIG.DAT")
+	extents := di.directory.fileExtents(big)
+	extents[1].Status = 0xE5
+	if err := di.FlushDirectory(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, level := range []ValidationLevel{ValidationBasic, ValidationStrict} {
+		if r := di.CheckWithOptions(&CheckOptions{Level: level}); len(r.WithCode("file-header-checksum")) != 0 {
+			t.Errorf("%s check read the files: %v", level, r.Findings)
+		}
+	}
+	r := di.CheckWithOptions(&CheckOptions{Level: ValidationParanoid})
+	if f := r.WithCode("file-header-checksum"); len(f) != 1 || f[0].Severity != SeverityWarning || f[0].Category != CategoryFile {
+		t.Errorf("file-header-checksum = %+v", f)
+	}
+	if f := r.WithCode("file-extent-missing"); len(f) != 1 || f[0].Severity != SeverityError || f[0].Entry < 0 {
+		t.Errorf("file-extent-missing = %+v", f)
+	}
+
+	// Check and nil options are strict, reading every track; the zero
+	// CheckOptions is basic.
+	di.Tracks[7] = di.Tracks[7][:len(di.Tracks[7])-100]
+	if len(di.Check().WithCode("track-short")) != 1 || len(di.CheckWithOptions(nil).WithCode("track-short")) != 1 {
+		t.Error("default check did not read the tracks")
+	}
+	if f := di.CheckWithOptions(&CheckOptions{}).WithCode("track-short"); len(f) != 0 {
+		t.Errorf("zero CheckOptions read the tracks: %v", f)
+	}
+
+	var level ValidationLevel
+	if err := level.UnmarshalText([]byte("paranoid")); err != nil || level != ValidationParanoid {
+		t.Errorf("UnmarshalText(paranoid) = %v, %v", level, err)
+	}
+	if err := level.UnmarshalText([]byte("thorough")); err == nil {
+		t.Error("UnmarshalText accepted an unknown level")
+	}
+}
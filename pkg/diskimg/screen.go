@@ -0,0 +1,158 @@
+// file: pkg/diskimg/screen.go
+
+package diskimg
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io/fs"
+)
+
+// ScreenSize is the length of a SCREEN$ file: the 6144-byte bitmap followed
+// by 768 attribute bytes.
+const ScreenSize = 6912
+
+// ScreenPalette is the Spectrum's colours: black, blue, red, magenta, green,
+// cyan, yellow and white, then the same eight BRIGHT.
+var ScreenPalette = color.Palette{
+	color.RGBA{0x00, 0x00, 0x00, 0xFF}, color.RGBA{0x00, 0x00, 0xD7, 0xFF},
+	color.RGBA{0xD7, 0x00, 0x00, 0xFF}, color.RGBA{0xD7, 0x00, 0xD7, 0xFF},
+	color.RGBA{0x00, 0xD7, 0x00, 0xFF}, color.RGBA{0x00, 0xD7, 0xD7, 0xFF},
+	color.RGBA{0xD7, 0xD7, 0x00, 0xFF}, color.RGBA{0xD7, 0xD7, 0xD7, 0xFF},
+	color.RGBA{0x00, 0x00, 0x00, 0xFF}, color.RGBA{0x00, 0x00, 0xFF, 0xFF},
+	color.RGBA{0xFF, 0x00, 0x00, 0xFF}, color.RGBA{0xFF, 0x00, 0xFF, 0xFF},
+	color.RGBA{0x00, 0xFF, 0x00, 0xFF}, color.RGBA{0x00, 0xFF, 0xFF, 0xFF},
+	color.RGBA{0xFF, 0xFF, 0x00, 0xFF}, color.RGBA{0xFF, 0xFF, 0xFF, 0xFF},
+}
+
+// DecodeScreen renders a SCREEN$ (the 6912 bytes of display memory, without a
+// PLUS3DOS header) as a 256x192 image in ScreenPalette. FLASH cells are shown
+// in their unflashed state.
+func DecodeScreen(data []byte) (*image.Paletted, error) {
+	if len(data) != ScreenSize {
+		return nil, fmt.Errorf("%w: not a SCREEN$: %d bytes, want %d", ErrWrongFileType, len(data), ScreenSize)
+	}
+	return decodeScreen(data, false), nil
+}
+
+// decodeScreen renders a SCREEN$ of ScreenSize bytes, with FLASH cells' INK
+// and PAPER swapped if flashed.
+func decodeScreen(data []byte, flashed bool) *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, 256, 192), ScreenPalette)
+	for y := 0; y < 192; y++ {
+		// The bitmap interleaves the rows of each third of the screen.
+		row := (y&0xC0)<<5 | (y&0x07)<<8 | (y&0x38)<<2
+		for col := 0; col < 32; col++ {
+			bits := data[row+col]
+			attr := data[6144+(y/8)*32+col]
+			ink, paper := attr&0x07, attr>>3&0x07
+			if attr&0x40 != 0 {
+				ink, paper = ink+8, paper+8
+			}
+			if flashed && attr&0x80 != 0 {
+				ink, paper = paper, ink
+			}
+			for b := 0; b < 8; b++ {
+				c := paper
+				if bits&(0x80>>b) != 0 {
+					c = ink
+				}
+				img.SetColorIndex(col*8+b, y, c)
+			}
+		}
+	}
+	return img
+}
+
+// FlashDelay is how long the Spectrum shows each FLASH phase, in the
+// hundredths of a second a GIF counts in: 16 frames of its 50Hz display.
+const FlashDelay = 32
+
+// DecodeScreenGIF renders a SCREEN$ as an endlessly looping GIF that shows
+// FLASH cells as the Spectrum does, swapping their INK and PAPER every
+// FlashDelay. A screen without FLASH gives a single frame. Each pixel is drawn
+// scale pixels square, as in ScaleScreen.
+func DecodeScreenGIF(data []byte, scale int) (*gif.GIF, error) {
+	if len(data) != ScreenSize {
+		return nil, fmt.Errorf("%w: not a SCREEN$: %d bytes, want %d", ErrWrongFileType, len(data), ScreenSize)
+	}
+	anim := &gif.GIF{}
+	anim.Image = append(anim.Image, scalePaletted(decodeScreen(data, false), scale))
+	anim.Delay = append(anim.Delay, FlashDelay)
+	for _, attr := range data[6144:] {
+		if attr&0x80 != 0 {
+			anim.Image = append(anim.Image, scalePaletted(decodeScreen(data, true), scale))
+			anim.Delay = append(anim.Delay, FlashDelay)
+			break
+		}
+	}
+	return anim, nil
+}
+
+// scalePaletted returns img with each pixel drawn scale pixels square.
+func scalePaletted(img *image.Paletted, scale int) *image.Paletted {
+	if scale <= 1 {
+		return img
+	}
+	b := img.Bounds()
+	out := image.NewPaletted(image.Rect(0, 0, b.Dx()*scale, b.Dy()*scale), img.Palette)
+	for y := 0; y < out.Bounds().Dy(); y++ {
+		for x := 0; x < out.Bounds().Dx(); x++ {
+			out.SetColorIndex(x, y, img.ColorIndexAt(b.Min.X+x/scale, b.Min.Y+y/scale))
+		}
+	}
+	return out
+}
+
+// ReadScreen renders a SCREEN$ file on the disk: a CODE file of 6912 bytes
+// with a PLUS3DOS header, or a headerless file of exactly 6912 bytes.
+func (di *DiskImage) ReadScreen(name string) (*image.Paletted, error) {
+	data, err := di.readScreenData(name)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeScreen(data)
+}
+
+// ReadScreenGIF renders a SCREEN$ file on the disk, as ReadScreen accepts
+// them, with DecodeScreenGIF.
+func (di *DiskImage) ReadScreenGIF(name string, scale int) (*gif.GIF, error) {
+	data, err := di.readScreenData(name)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeScreenGIF(data, scale)
+}
+
+// readScreenData returns the display bytes of a SCREEN$ file on the disk.
+func (di *DiskImage) readScreenData(name string) ([]byte, error) {
+	header, err := di.ReadHeader(name)
+	if err != nil {
+		return nil, err
+	}
+	if header != nil {
+		if fileType, length, _, _ := header.GetBasicHeader(); fileType != FileTypeCode || length != ScreenSize {
+			return nil, fmt.Errorf("%w: %s is not a SCREEN$", ErrWrongFileType, name)
+		}
+	}
+	return fs.ReadFile(di.HeaderlessFS(), name)
+}
+
+// ScaleScreen returns img as a true-colour image with each pixel drawn as a
+// scale by scale square, as for writing a SCREEN$ out at 2x. A scale below 1
+// is taken as 1.
+func ScaleScreen(img image.Image, scale int) *image.RGBA {
+	scale = max(scale, 1)
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx()*scale, b.Dy()*scale))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			c := image.NewUniform(img.At(b.Min.X+x, b.Min.Y+y))
+			draw.Draw(out, image.Rect(x*scale, y*scale, (x+1)*scale, (y+1)*scale), c, image.Point{}, draw.Src)
+		}
+	}
+	return out
+}
@@ -0,0 +1,110 @@
+package diskimg
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// DecodeScreen follows the Spectrum's interleaved display layout and its
+// attribute colours.
+func TestDecodeScreen(t *testing.T) {
+	data := make([]byte, ScreenSize)
+	data[0x0100] = 0x80           // pixel row 1 of the first cell: leftmost pixel set
+	data[0x0800+31] = 0x01        // first row of the middle third, last cell: rightmost pixel
+	data[6144] = 0x40 | 2<<3 | 1  // first cell: BRIGHT, red paper, blue ink
+	data[6144+8*32+31] = 6<<3 | 4 // middle third, last cell: yellow paper, green ink
+	header, _ := importHeader(ScreenSize, &ImportOptions{AddHeader: true, FileType: FileTypeCode, LoadAddr: 16384})
+
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.writeRecords("PIC.SCR", append(header.toBytes(), data...)); err != nil {
+		t.Fatal(err)
+	}
+	img, err := di.ReadScreen("PIC.SCR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct{ x, y, want int }{
+		{0, 0, 10}, // bright red paper
+		{0, 1, 9},  // bright blue ink
+		{255, 64, 4},
+		{254, 64, 6},
+		{100, 100, 0}, // attribute 0: black paper
+	} {
+		if got := int(img.ColorIndexAt(tc.x, tc.y)); got != tc.want {
+			t.Errorf("pixel (%d,%d) = colour %d, want %d", tc.x, tc.y, got, tc.want)
+		}
+	}
+
+	if err := di.writeRecords("SHORT.SCR", data[:100]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := di.ReadScreen("SHORT.SCR"); err == nil {
+		t.Error("ReadScreen of a 100-byte file succeeded")
+	}
+}
+
+// ScaleScreen keeps the colours and blows each pixel up to a square.
+func TestScaleScreen(t *testing.T) {
+	data := make([]byte, ScreenSize)
+	data[0] = 0x80               // top-left pixel set
+	data[6144] = 0x40 | 1<<3 | 6 // BRIGHT yellow ink on blue
+	img, err := DecodeScreen(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := ScaleScreen(img, 2)
+	if got := out.Bounds().Size(); got != image.Pt(512, 384) {
+		t.Fatalf("size = %v, want 512x384", got)
+	}
+	for _, tc := range []struct {
+		x, y int
+		want color.Color
+	}{
+		{0, 0, ScreenPalette[14]}, {1, 1, ScreenPalette[14]},
+		{2, 0, ScreenPalette[9]}, {3, 1, ScreenPalette[9]},
+		{511, 383, ScreenPalette[0]},
+	} {
+		if got := out.At(tc.x, tc.y); got != tc.want {
+			t.Errorf("pixel (%d,%d) = %v, want %v", tc.x, tc.y, got, tc.want)
+		}
+	}
+	if got := ScaleScreen(img, 0).Bounds().Size(); got != image.Pt(256, 192) {
+		t.Errorf("scale 0: size = %v, want 256x192", got)
+	}
+}
+
+// DecodeScreenGIF adds a second frame with FLASH cells' colours swapped, and
+// only when some cell flashes.
+func TestDecodeScreenGIF(t *testing.T) {
+	data := make([]byte, ScreenSize)
+	data[0] = 0xF0               // first cell, top row: left half INK
+	data[6144] = 0x80 | 7<<3 | 2 // first cell: FLASH, red on white
+	data[6145] = 1<<3 | 6        // second cell: yellow on blue, steady
+	anim, err := DecodeScreenGIF(data, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anim.Image) != 2 || anim.Delay[0] != FlashDelay || anim.Delay[1] != FlashDelay {
+		t.Fatalf("%d frames, delays %v; want 2 of %d", len(anim.Image), anim.Delay, FlashDelay)
+	}
+	for _, tc := range []struct{ frame, x, y, want int }{
+		{0, 0, 0, 2}, {0, 15, 1, 7}, {0, 16, 0, 1},
+		{1, 0, 0, 7}, {1, 15, 1, 2}, {1, 16, 0, 1},
+	} {
+		if got := int(anim.Image[tc.frame].ColorIndexAt(tc.x, tc.y)); got != tc.want {
+			t.Errorf("frame %d pixel (%d,%d) = colour %d, want %d", tc.frame, tc.x, tc.y, got, tc.want)
+		}
+	}
+	if got := anim.Image[0].Bounds().Size(); got != image.Pt(512, 384) {
+		t.Errorf("size = %v, want 512x384", got)
+	}
+
+	data[6144] &^= 0x80
+	if anim, err := DecodeScreenGIF(data, 1); err != nil || len(anim.Image) != 1 {
+		t.Errorf("without FLASH: err %v, want one frame", err)
+	}
+	if _, err := DecodeScreenGIF(data[:100], 1); err == nil {
+		t.Error("DecodeScreenGIF of 100 bytes succeeded")
+	}
+}
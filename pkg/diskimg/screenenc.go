@@ -0,0 +1,282 @@
+// file: pkg/diskimg/screenenc.go
+
+package diskimg
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"  // decoded by ImportImage
+	_ "image/jpeg" // decoded by ImportImage
+	_ "image/png"  // decoded by ImportImage
+	"math"
+	"os"
+)
+
+// Dither selects how EncodeScreen maps each pixel to one of its cell's two
+// colours.
+type Dither int
+
+const (
+	DitherNone      Dither = iota // the nearer of the two colours
+	DitherOrdered                 // a 4x4 Bayer pattern between the two
+	DitherDiffusion               // Floyd-Steinberg error diffusion
+)
+
+// String returns "none", "ordered" or "diffusion".
+func (d Dither) String() string {
+	switch d {
+	case DitherOrdered:
+		return "ordered"
+	case DitherDiffusion:
+		return "diffusion"
+	}
+	return "none"
+}
+
+// ClashStrategy selects how EncodeScreen chooses the INK, PAPER and BRIGHT
+// of each 8x8 cell, which can show only two colours.
+type ClashStrategy int
+
+const (
+	ClashBestPair ClashStrategy = iota // the pair closest to the cell's pixels overall
+	ClashPopular                       // the two palette colours most pixels are nearest to
+)
+
+// String returns "best" or "popular".
+func (c ClashStrategy) String() string {
+	if c == ClashPopular {
+		return "popular"
+	}
+	return "best"
+}
+
+// ScreenEncodeOptions configures EncodeScreen. The zero value picks the best
+// pair for each cell without dithering.
+type ScreenEncodeOptions struct {
+	Dither Dither
+	Clash  ClashStrategy
+}
+
+// rgb is a colour with float components on the 0-255 scale.
+type rgb [3]float64
+
+func (c rgb) sub(d rgb) rgb { return rgb{c[0] - d[0], c[1] - d[1], c[2] - d[2]} }
+
+func (c rgb) dot(d rgb) float64 { return c[0]*d[0] + c[1]*d[1] + c[2]*d[2] }
+
+func (c rgb) dist(d rgb) float64 { e := c.sub(d); return e.dot(e) }
+
+// bayer4 is the 4x4 ordered dither matrix.
+var bayer4 = [4][4]float64{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// EncodeScreen converts img to a SCREEN$: it is scaled to 256x192, each 8x8
+// cell is given an INK and PAPER from the Spectrum palette as opts.Clash
+// says, and each pixel is set to one of the two as opts.Dither says. FLASH is
+// never set.
+func EncodeScreen(img image.Image, opts *ScreenEncodeOptions) []byte {
+	if opts == nil {
+		opts = &ScreenEncodeOptions{}
+	}
+	pix := resampleScreen(img)
+
+	var palette [16]rgb
+	for i, c := range ScreenPalette {
+		r, g, b, _ := c.RGBA()
+		palette[i] = rgb{float64(r >> 8), float64(g >> 8), float64(b >> 8)}
+	}
+
+	// Choose each cell's colours from the undithered image.
+	var attrs [768]byte
+	for cy := 0; cy < 24; cy++ {
+		for cx := 0; cx < 32; cx++ {
+			var cell [64]rgb
+			for i := range cell {
+				cell[i] = pix[cy*8+i/8][cx*8+i%8]
+			}
+			var ink, paper, bright int
+			if opts.Clash == ClashPopular {
+				ink, paper, bright = popularPair(&cell, &palette)
+			} else {
+				ink, paper, bright = bestPair(&cell, &palette)
+			}
+			// Keep the darker colour as INK, as on a freshly cleared screen.
+			if palette[bright*8+ink].dot(rgb{1, 1, 1}) > palette[bright*8+paper].dot(rgb{1, 1, 1}) {
+				ink, paper = paper, ink
+			}
+			attrs[cy*32+cx] = byte(bright<<6 | paper<<3 | ink)
+		}
+	}
+
+	data := make([]byte, ScreenSize)
+	copy(data[6144:], attrs[:])
+	for y := 0; y < 192; y++ {
+		row := (y&0xC0)<<5 | (y&0x07)<<8 | (y&0x38)<<2
+		for x := 0; x < 256; x++ {
+			attr := attrs[(y/8)*32+x/8]
+			bright := int(attr>>6&1) * 8
+			ink, paper := palette[bright+int(attr&7)], palette[bright+int(attr>>3&7)]
+			p := pix[y][x]
+
+			var useInk bool
+			switch opts.Dither {
+			case DitherOrdered:
+				// Place the pixel on the line from INK to PAPER and compare its
+				// position with the pattern's threshold.
+				span := paper.sub(ink)
+				t := 0.0
+				if n := span.dot(span); n > 0 {
+					t = p.sub(ink).dot(span) / n
+				}
+				useInk = t <= (bayer4[y%4][x%4]+0.5)/16
+			default:
+				useInk = p.dist(ink) <= p.dist(paper)
+			}
+			chosen := paper
+			if useInk {
+				chosen = ink
+				data[row+x/8] |= 0x80 >> (x % 8)
+			}
+
+			if opts.Dither == DitherDiffusion {
+				e := p.sub(chosen)
+				spread := func(dx, dy int, w float64) {
+					if x+dx < 0 || x+dx >= 256 || y+dy >= 192 {
+						return
+					}
+					q := &pix[y+dy][x+dx]
+					for i := range q {
+						q[i] += e[i] * w
+					}
+				}
+				spread(1, 0, 7.0/16)
+				spread(-1, 1, 3.0/16)
+				spread(0, 1, 5.0/16)
+				spread(1, 1, 1.0/16)
+			}
+		}
+	}
+	return data
+}
+
+// resampleScreen scales img to 256x192, averaging the source pixels that fall
+// in each screen pixel. Transparent pixels come out black.
+func resampleScreen(img image.Image) *[192][256]rgb {
+	b := img.Bounds()
+	w, h := max(b.Dx(), 1), max(b.Dy(), 1)
+	var pix [192][256]rgb
+	for y := 0; y < 192; y++ {
+		y0 := y * h / 192
+		y1 := max((y+1)*h/192, y0+1)
+		for x := 0; x < 256; x++ {
+			x0 := x * w / 256
+			x1 := max((x+1)*w/256, x0+1)
+			var sum rgb
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					r, g, bl, _ := img.At(b.Min.X+sx, b.Min.Y+sy).RGBA()
+					sum[0] += float64(r >> 8)
+					sum[1] += float64(g >> 8)
+					sum[2] += float64(bl >> 8)
+				}
+			}
+			n := float64((y1 - y0) * (x1 - x0))
+			pix[y][x] = rgb{sum[0] / n, sum[1] / n, sum[2] / n}
+		}
+	}
+	return &pix
+}
+
+// bestPair returns the INK, PAPER and BRIGHT that leave the least total error
+// when each of cell's pixels takes the nearer of the two colours.
+func bestPair(cell *[64]rgb, palette *[16]rgb) (ink, paper, bright int) {
+	var dist [64][16]float64
+	for i, p := range cell {
+		for c := range palette {
+			dist[i][c] = p.dist(palette[c])
+		}
+	}
+	best := -1.0
+	for br := 0; br < 16; br += 8 {
+		for i := br; i < br+8; i++ {
+			for j := i; j < br+8; j++ {
+				var e float64
+				for _, d := range dist {
+					e += math.Min(d[i], d[j])
+				}
+				if best < 0 || e < best {
+					best, ink, paper, bright = e, i-br, j-br, br/8
+				}
+			}
+		}
+	}
+	return ink, paper, bright
+}
+
+// popularPair returns the two colours most of cell's pixels are nearest to,
+// and BRIGHT if more of its coloured pixels are nearest a bright colour.
+func popularPair(cell *[64]rgb, palette *[16]rgb) (ink, paper, bright int) {
+	var counts [16]int
+	for _, p := range cell {
+		nearest := 0
+		for i := 1; i < 16; i++ {
+			if i != 8 && p.dist(palette[i]) < p.dist(palette[nearest]) {
+				nearest = i
+			}
+		}
+		counts[nearest]++
+	}
+	var normal, bri int
+	for i := 1; i < 8; i++ {
+		normal += counts[i]
+		bri += counts[8+i]
+	}
+	if bri > normal {
+		bright = 1
+	}
+
+	// Each colour gets the votes of both its BRIGHT and plain forms.
+	var votes [8]int
+	for i := range votes {
+		votes[i] = counts[i] + counts[8+i]
+	}
+	ink, paper = 0, -1
+	for i := 1; i < 8; i++ {
+		if votes[i] > votes[ink] {
+			ink = i
+		}
+	}
+	for i := 0; i < 8; i++ {
+		if i != ink && (paper < 0 || votes[i] > votes[paper]) {
+			paper = i
+		}
+	}
+	if votes[paper] == 0 {
+		paper = ink
+	}
+	return ink, paper, bright
+}
+
+// ImportImage converts a host PNG, JPEG, GIF or BMP image to a SCREEN$ with
+// EncodeScreen and stores it as NAME.SCR, a CODE file loading at 16384.
+func (di *DiskImage) ImportImage(hostPath string, opts *ScreenEncodeOptions) error {
+	f, err := os.Open(hostPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrWrongFileType, hostPath, err)
+	}
+
+	return di.ImportData(HostName(hostPath, "SCR"), EncodeScreen(img, opts), &ImportOptions{
+		AddHeader: true,
+		FileType:  FileTypeCode,
+		LoadAddr:  16384,
+	})
+}
@@ -0,0 +1,161 @@
+package diskimg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"math/bits"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A picture that is already a SCREEN$ comes back pixel for pixel, whichever
+// way the cells' colours are chosen.
+func TestEncodeScreenRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	data := make([]byte, ScreenSize)
+	rng.Read(data[:6144])
+	for i := 6144; i < ScreenSize; i++ {
+		data[i] = byte(rng.Intn(128))
+	}
+	want, err := DecodeScreen(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, clash := range []ClashStrategy{ClashBestPair, ClashPopular} {
+		got, err := DecodeScreen(EncodeScreen(want, &ScreenEncodeOptions{Clash: clash}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for y := 0; y < 192; y++ {
+			for x := 0; x < 256; x++ {
+				if g, w := got.At(x, y), want.At(x, y); g != w {
+					t.Fatalf("%v: pixel (%d,%d) = %v, want %v", clash, x, y, g, w)
+				}
+			}
+		}
+	}
+}
+
+// A larger picture is scaled down, and a colour between INK and PAPER is
+// dithered into a mix of the two.
+func TestEncodeScreenScaleAndDither(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 512, 384))
+	for y := 0; y < 384; y++ {
+		for x := 0; x < 512; x++ {
+			c := color.RGBA{0xD7, 0, 0, 0xFF} // red on the left
+			if x >= 256 {
+				c = color.RGBA{0x80, 0x80, 0x80, 0xFF} // grey on the right
+			}
+			img.Set(x, y, c)
+		}
+	}
+
+	data := EncodeScreen(img, nil)
+	if attr := data[6144]; attr&0x40 != 0 || attr>>3&7 != 2 || data[0] != 0 {
+		t.Errorf("red cell attribute = %#02x, pixels %#02x, want plain red PAPER", attr, data[0])
+	}
+	for _, d := range []Dither{DitherNone, DitherOrdered, DitherDiffusion} {
+		data := EncodeScreen(img, &ScreenEncodeOptions{Dither: d})
+		set := 0
+		for y := 0; y < 8; y++ {
+			row := y << 8
+			set += bits.OnesCount8(data[row+20]) // a grey cell
+		}
+		attr := data[6144+20]
+		if attr&0x40 != 0 || attr&7 != 0 || attr>>3&7 != 7 {
+			t.Errorf("%v: grey cell attribute = %#02x, want black INK on white PAPER", d, attr)
+		}
+		if d == DitherNone && set != 0 && set != 64 {
+			t.Errorf("%v: %d of 64 grey pixels are INK, want all or none", d, set)
+		}
+		if d != DitherNone && (set < 16 || set > 48) {
+			t.Errorf("%v: %d of 64 grey pixels are INK, want a mix", d, set)
+		}
+	}
+}
+
+// ImportImage reads PNG and BMP files and stores a SCREEN$ loading at 16384.
+func TestImportImage(t *testing.T) {
+	dir := t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 256, 192))
+	for x := 0; x < 256; x++ {
+		for y := 0; y < 192; y++ {
+			img.Set(x, y, color.RGBA{0, 0, 0xFF, 0xFF})
+		}
+	}
+	img.Set(0, 0, color.White)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "picture.png"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "pic.bmp"), testBMP(img), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	di := newSpecImage(t, SpecPlus3)
+	for _, tc := range []struct{ host, name string }{
+		{"picture.png", "PICTURE.SCR"},
+		{"pic.bmp", "PIC.SCR"},
+	} {
+		if err := di.ImportImage(filepath.Join(dir, tc.host), nil); err != nil {
+			t.Fatalf("%s: %v", tc.host, err)
+		}
+		fi, err := di.StatFile(tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.LoadAddress != 16384 || fi.DataLength != ScreenSize {
+			t.Errorf("%s: load address %d, length %d, want 16384, %d", tc.name, fi.LoadAddress, fi.DataLength, ScreenSize)
+		}
+		screen, err := di.ReadScreen(tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := screen.ColorIndexAt(0, 0); got != 15 {
+			t.Errorf("%s: pixel (0,0) = colour %d, want 15", tc.name, got)
+		}
+		if got := screen.ColorIndexAt(100, 100); got != 9 {
+			t.Errorf("%s: pixel (100,100) = colour %d, want 9", tc.name, got)
+		}
+	}
+
+	if err := di.ImportImage(filepath.Join(dir, "picture.png")+"x", nil); err == nil {
+		t.Error("ImportImage of a missing file succeeded")
+	}
+	os.WriteFile(filepath.Join(dir, "junk.png"), []byte("not an image"), 0644)
+	if err := di.ImportImage(filepath.Join(dir, "junk.png"), nil); err == nil {
+		t.Error("ImportImage of a non-image succeeded")
+	}
+}
+
+// testBMP encodes img as a bottom-up 24-bit BMP.
+func testBMP(img *image.RGBA) []byte {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	stride := (w*3 + 3) &^ 3
+	data := make([]byte, 54+stride*h)
+	le := binary.LittleEndian
+	copy(data, "BM")
+	le.PutUint32(data[2:], uint32(len(data)))
+	le.PutUint32(data[10:], 54)
+	le.PutUint32(data[14:], 40)
+	le.PutUint32(data[18:], uint32(w))
+	le.PutUint32(data[22:], uint32(h))
+	le.PutUint16(data[26:], 1)
+	le.PutUint16(data[28:], 24)
+	for y := 0; y < h; y++ {
+		line := data[54+(h-1-y)*stride:]
+		for x := 0; x < w; x++ {
+			c := img.RGBAAt(x, y)
+			line[3*x], line[3*x+1], line[3*x+2] = c.B, c.G, c.R
+		}
+	}
+	return data
+}
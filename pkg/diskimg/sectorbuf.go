@@ -0,0 +1,58 @@
+// file: pkg/diskimg/sectorbuf.go
+
+package diskimg
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ReadSectorInto copies the data of a track/sector/side into buf, as
+// GetSectorData returns it, and returns its size. Reusing buf saves the
+// allocation GetSectorData makes for every sector. A buf too small for the
+// sector is an io.ErrShortBuffer error.
+func (di *DiskImage) ReadSectorInto(buf []byte, track, sector, side int) (int, error) {
+	td, off, size, _, err := di.locateSector(track, sector, side)
+	if err != nil {
+		return 0, sectorError("read", track, side, sector, err)
+	}
+	if len(buf) < size {
+		return 0, sectorError("read", track, side, sector, fmt.Errorf("%w: %d bytes for a %d-byte sector", io.ErrShortBuffer, len(buf), size))
+	}
+	return copy(buf, td[off:off+size]), nil
+}
+
+// SectorView returns the data of a track/sector/side as a slice of the track
+// itself, copying nothing. The view is only for reading, and only until the
+// disk is next changed or closed: it may be part of a read-only mapping of
+// the image file (OpenMapped), or of a track shared with a Snapshot, and a
+// write to the sector may move the track elsewhere. Copy what is to be kept,
+// or use ReadSectorInto.
+func (di *DiskImage) SectorView(track, sector, side int) ([]byte, error) {
+	td, off, size, _, err := di.locateSector(track, sector, side)
+	if err != nil {
+		return nil, sectorError("read", track, side, sector, err)
+	}
+	return td[off : off+size : off+size], nil
+}
+
+// sectorBuffers holds buffers for sectors read only for a moment, as by a
+// read or write of part of a sector of a file.
+var sectorBuffers sync.Pool
+
+// getSectorBuffer returns a buffer of size bytes, from sectorBuffers if it
+// has one big enough. Give it back with putSectorBuffer.
+func getSectorBuffer(size int) *[]byte {
+	if b, ok := sectorBuffers.Get().(*[]byte); ok && cap(*b) >= size {
+		*b = (*b)[:size]
+		return b
+	}
+	b := make([]byte, size)
+	return &b
+}
+
+// putSectorBuffer returns a buffer from getSectorBuffer to sectorBuffers.
+func putSectorBuffer(b *[]byte) {
+	sectorBuffers.Put(b)
+}
@@ -0,0 +1,111 @@
+package diskimg
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+)
+
+func TestReadSectorInto(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	want := bytes.Repeat([]byte{0x5A}, 512)
+	if err := di.SetSectorData(3, 4, 0, want); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	if n, err := di.ReadSectorInto(buf, 3, 4, 0); err != nil || n != 512 || !bytes.Equal(buf[:n], want) {
+		t.Errorf("ReadSectorInto = %d, %v", n, err)
+	}
+	if _, err := di.ReadSectorInto(buf[:100], 3, 4, 0); !errors.Is(err, io.ErrShortBuffer) {
+		t.Errorf("short buffer: err = %v, want io.ErrShortBuffer", err)
+	}
+	if _, err := di.ReadSectorInto(buf, 99, 0, 0); !errors.Is(err, ErrInvalidSector) {
+		t.Errorf("track 99: err = %v, want ErrInvalidSector", err)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { di.ReadSectorInto(buf, 3, 4, 0) }); allocs != 0 {
+		t.Errorf("ReadSectorInto makes %v allocations, want 0", allocs)
+	}
+}
+
+func TestSectorView(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	want := bytes.Repeat([]byte{0xA5}, 512)
+	if err := di.SetSectorData(3, 4, 0, want); err != nil {
+		t.Fatal(err)
+	}
+	view, err := di.SectorView(3, 4, 0)
+	if err != nil || !bytes.Equal(view, want) || cap(view) != 512 {
+		t.Fatalf("SectorView = %d bytes (cap %d), %v", len(view), cap(view), err)
+	}
+	if _, err := di.SectorView(99, 0, 0); !errors.Is(err, ErrInvalidSector) {
+		t.Errorf("track 99: err = %v, want ErrInvalidSector", err)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { di.SectorView(3, 4, 0) }); allocs != 0 {
+		t.Errorf("SectorView makes %v allocations, want 0", allocs)
+	}
+
+	// A snapshot's view is not changed by a write to the disk it was taken of.
+	snap := di.Snapshot()
+	view, _ = snap.SectorView(3, 4, 0)
+	if err := di.SetSectorData(3, 4, 0, make([]byte, 512)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(view, want) {
+		t.Error("the snapshot's view changed with the disk")
+	}
+}
+
+// File reads copy from the track straight into the caller's buffer.
+func TestReadAtAllocs(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	data := bytes.Repeat([]byte("0123456789"), 300)
+	if err := di.writeRecords("DATA.BIN", data); err != nil {
+		t.Fatal(err)
+	}
+	f, err := di.OpenFile("DATA.BIN", os.O_RDONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got := make([]byte, len(data))
+	if allocs := testing.AllocsPerRun(100, func() { f.ReadAt(got, 0) }); allocs != 0 {
+		t.Errorf("ReadAt makes %v allocations, want 0", allocs)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("ReadAt differs from what was written")
+	}
+}
+
+// Reads and writes of parts of sectors, through the pooled buffers, keep the
+// rest of each sector.
+func TestPartialSectorIO(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	data := bytes.Repeat([]byte("0123456789"), 300)
+	if err := di.writeRecords("DATA.BIN", data); err != nil {
+		t.Fatal(err)
+	}
+	f, err := di.OpenFile("DATA.BIN", os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("abc"), 510); err != nil {
+		t.Fatal(err)
+	}
+	copy(data[510:], "abc")
+	got := make([]byte, len(data))
+	for off := 0; off < len(got); off += 7 {
+		if _, err := f.ReadAt(got[off:min(off+7, len(got))], int64(off)); err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("small reads differ from what was written")
+	}
+	f.Close()
+	if all, _ := fs.ReadFile(di, "DATA.BIN"); !bytes.Equal(all, data) {
+		t.Error("the file differs from what was written")
+	}
+}
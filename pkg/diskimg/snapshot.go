@@ -0,0 +1,75 @@
+// file: pkg/diskimg/snapshot.go
+
+package diskimg
+
+import "slices"
+
+// Snapshot returns a copy of the disk that can be changed without affecting
+// it, and the other way round. Track data is shared until one of the two
+// writes to a track through SetSectorData, which copies that track first, so
+// a snapshot costs little more than the directory and allocation maps. Code
+// that writes to Tracks directly must copy the track itself.
+//
+// A disk opened writable with OpenMapped is the exception: its file changes
+// under the tracks, so its snapshot gets a copy of every track.
+func (di *DiskImage) Snapshot() *DiskImage {
+	if di.lazy != nil && di.lazy.mapped != nil && di.lazy.mapped.writable {
+		// A track that fails to read now fails for both later.
+		if err := di.LoadTracks(); err != nil {
+			logger.Warn("snapshot of a mapped disk is missing tracks", "err", err)
+		}
+		c := di.snapshot()
+		for i, t := range c.Tracks {
+			c.Tracks[i] = slices.Clone(t)
+		}
+		c.shared, c.lazy = nil, nil
+		return c
+	}
+	if len(di.shared) != len(di.Tracks) {
+		di.shared = make([]bool, len(di.Tracks))
+	}
+	for i := range di.shared {
+		di.shared[i] = true
+	}
+	return di.snapshot()
+}
+
+// snapshot returns a copy of the disk sharing its track data.
+func (di *DiskImage) snapshot() *DiskImage {
+	c := *di
+	c.Tracks = slices.Clone(di.Tracks)
+	c.shared = slices.Clone(di.shared)
+	c.lazy = di.lazy.clone()
+	c.directory.Entries = slices.Clone(di.directory.Entries)
+	c.directory.Reindex()
+	c.concealments = slices.Clone(di.concealments)
+	c.changes = slices.Clone(di.changes)
+	c.dirty = slices.Clone(di.dirty)
+	if di.allocation != nil {
+		c.allocation = &SectorAllocation{
+			allocated: slices.Clone(di.allocation.allocated),
+			spec:      di.allocation.spec,
+		}
+	}
+	if di.fileAlloc != nil {
+		c.fileAlloc = &FileAllocation{
+			disk:       &c,
+			allocation: c.allocation,
+			blockMap:   slices.Clone(di.fileAlloc.blockMap),
+			freeBlocks: slices.Clone(di.fileAlloc.freeBlocks),
+			limit:      di.fileAlloc.limit,
+		}
+	}
+	return &c
+}
+
+// ownTrack makes the track at idx the disk's own before it is written,
+// copying it if a snapshot shares it.
+func (di *DiskImage) ownTrack(idx int) {
+	if idx < len(di.shared) && di.shared[idx] {
+		if di.Tracks[idx] != nil {
+			di.Tracks[idx] = slices.Clone(di.Tracks[idx])
+		}
+		di.shared[idx] = false
+	}
+}
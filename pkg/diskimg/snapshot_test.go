@@ -0,0 +1,51 @@
+package diskimg
+
+import (
+	"bytes"
+	"io/fs"
+	"testing"
+)
+
+// A snapshot and its disk change independently, and share the tracks neither
+// has written.
+func TestSnapshot(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.WriteFile("A.TXT", []byte("original")); err != nil {
+		t.Fatal(err)
+	}
+	snap := di.Snapshot()
+	if err := snap.WriteFile("A.TXT", []byte("changed in the snapshot")); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.WriteFile("B.TXT", []byte("only on the disk")); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := fs.ReadFile(di, "A.TXT"); string(data) != "original" {
+		t.Errorf("disk A.TXT = %q", data)
+	}
+	if data, _ := fs.ReadFile(snap, "A.TXT"); string(data) != "changed in the snapshot" {
+		t.Errorf("snapshot A.TXT = %q", data)
+	}
+	if _, err := snap.Stat("B.TXT"); err == nil {
+		t.Error("a file written to the disk appears in the snapshot")
+	}
+
+	last := len(di.Tracks) - 1
+	if &di.Tracks[last][0] != &snap.Tracks[last][0] {
+		t.Error("an unwritten track was copied")
+	}
+	if &di.Tracks[1][0] == &snap.Tracks[1][0] {
+		t.Error("a written track is still shared")
+	}
+	if err := snap.DiskCheck(); err != nil {
+		t.Errorf("snapshot DiskCheck: %v", err)
+	}
+	var a, b bytes.Buffer
+	if err := reload(t, snap).Save(&a); err != nil {
+		t.Fatal(err)
+	}
+	if err := snap.Save(&b); err != nil || !bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Errorf("snapshot does not survive a save and load: %v", err)
+	}
+}
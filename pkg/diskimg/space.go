@@ -0,0 +1,57 @@
+// file: pkg/diskimg/space.go
+
+package diskimg
+
+// Space is an amount of disk space. Blocks is in allocation blocks, rounded
+// down where the space is not made of whole blocks.
+type Space struct {
+	Blocks int   `json:"blocks"`
+	Bytes  int64 `json:"bytes"`
+}
+
+// SpaceInfo is how a disk's space is divided. Reserved, Directory, Used and
+// Free add up to Total in bytes.
+type SpaceInfo struct {
+	BlockSize int   `json:"block_size"`
+	Total     Space `json:"total"`     // every sector of every track
+	Reserved  Space `json:"reserved"`  // system tracks and space no block covers
+	System    Space `json:"system"`    // of Reserved, the system-track sectors in use
+	Directory Space `json:"directory"` // the directory blocks
+	Used      Space `json:"used"`      // blocks allocated to files
+	Free      Space `json:"free"`      // blocks free for files
+
+	Entries     int `json:"entries"`      // directory entries, DiskSpec.DirEntries
+	FreeEntries int `json:"free_entries"` // of Entries, those not in use
+}
+
+// SpaceInfo returns how the disk's space is divided between the system
+// tracks, the directory, files and free blocks. System is the part of the
+// system tracks in use, by a boot loader or CP/M among others. Files use whole blocks, so
+// Used is usually more than the sum of the file sizes. A new file may hold
+// less than Free if there are too few free directory entries to address it.
+func (di *DiskImage) SpaceInfo() SpaceInfo {
+	spec := di.spec
+	bs := spec.BlockSize
+	blocks := func(n int) Space { return Space{Blocks: n, Bytes: int64(n) * int64(bs)} }
+
+	fa := di.fileAlloc
+	free := fa.GetFreeBlocks()
+	si := SpaceInfo{
+		BlockSize: bs,
+		Directory: blocks(spec.DirBlocks),
+		Used:      blocks(fa.limit - spec.DirBlocks - free),
+		Free:      blocks(free),
+		Entries:   len(di.directory.Entries),
+	}
+	for i := range di.directory.Entries {
+		if di.directory.Entries[i].IsUnused() {
+			si.FreeEntries++
+		}
+	}
+	si.Total.Bytes = int64(spec.TotalTracks()) * int64(spec.SectorsPerTrack) * int64(spec.SectorSize)
+	si.Total.Blocks = int(si.Total.Bytes / int64(bs))
+	si.Reserved.Bytes = si.Total.Bytes - int64(fa.limit)*int64(bs)
+	si.Reserved.Blocks = int(si.Reserved.Bytes / int64(bs))
+	si.System = di.systemSpace()
+	return si
+}
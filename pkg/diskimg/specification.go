@@ -0,0 +1,147 @@
+// file: pkg/diskimg/specification.go
+
+package diskimg
+
+import "fmt"
+
+// SpecificationSize is the size of a serialised DiskSpecification.
+const SpecificationSize = 10
+
+// DiskSpecification is the +3DOS disk specification, the record at the start
+// of the boot sector by which +3DOS and the PCW learn a disk's format, byte
+// for byte. DiskSpec gives the format it describes.
+type DiskSpecification struct {
+	Format          byte // 0 = +3, 1 = CPC system, 2 = CPC data, 3 = double-sided
+	Sidedness       byte // SidesSingle, SidesAlternate or SidesSuccessive
+	DoubleTrack     bool // for a drive with twice the tracks (bit 7 of the sidedness byte)
+	TracksPerSide   byte
+	SectorsPerTrack byte
+	SectorSizeShift byte // sectors are 128 << SectorSizeShift bytes
+	ReservedTracks  byte
+	BlockShift      byte // blocks are 128 << BlockShift bytes
+	DirBlocks       byte
+	GapRW           byte
+	GapFormat       byte
+
+	// Bootable is whether the whole boot sector, as passed to
+	// ParseDiskSpecification, adds up to a +3 or PCW boot checksum. It is not
+	// serialised.
+	Bootable bool
+}
+
+// ParseDiskSpecification parses the disk specification at the start of boot,
+// the boot sector or at least its first SpecificationSize bytes. A sector
+// whose first byte is above 3, as in an unused 0xE5 sector, holds none.
+func ParseDiskSpecification(boot []byte) (DiskSpecification, error) {
+	switch {
+	case len(boot) < SpecificationSize:
+		return DiskSpecification{}, fmt.Errorf("%w: %d bytes for a disk specification", ErrInvalidSpec, len(boot))
+	case boot[0] > 3:
+		return DiskSpecification{}, fmt.Errorf("%w: no disk specification (format byte %#x)", ErrInvalidSpec, boot[0])
+	case boot[4] > 6 || boot[6] > 7:
+		return DiskSpecification{}, fmt.Errorf("%w: sector size %d, block size %d", ErrInvalidSpec, boot[4], boot[6])
+	}
+	ds := DiskSpecification{
+		Format:          boot[0],
+		Sidedness:       boot[1] & 0x03,
+		DoubleTrack:     boot[1]&0x80 != 0,
+		TracksPerSide:   boot[2],
+		SectorsPerTrack: boot[3],
+		SectorSizeShift: boot[4],
+		ReservedTracks:  boot[5],
+		BlockShift:      boot[6],
+		DirBlocks:       boot[7],
+		GapRW:           boot[8],
+		GapFormat:       boot[9],
+	}
+	if len(boot) >= 128<<ds.SectorSizeShift {
+		var sum byte
+		for _, b := range boot[:128<<ds.SectorSizeShift] {
+			sum += b
+		}
+		for _, s := range []DiskSpec{SpecPlus3, SpecPCW180, SpecPCW720} {
+			ds.Bootable = ds.Bootable || sum == s.BootChecksum()
+		}
+	}
+	return ds, nil
+}
+
+// Serialize returns the specification's SpecificationSize bytes.
+func (ds DiskSpecification) Serialize() []byte {
+	sidedness := ds.Sidedness
+	if ds.DoubleTrack {
+		sidedness |= 0x80
+	}
+	return []byte{
+		ds.Format,
+		sidedness,
+		ds.TracksPerSide,
+		ds.SectorsPerTrack,
+		ds.SectorSizeShift,
+		ds.ReservedTracks,
+		ds.BlockShift,
+		ds.DirBlocks,
+		ds.GapRW,
+		ds.GapFormat,
+	}
+}
+
+// DiskSpec returns the format the specification describes: the preset it
+// matches, or one named "custom". Sectors are numbered from 1, as +3DOS
+// numbers them; the CPC formats are known by their sector IDs instead.
+func (ds DiskSpecification) DiskSpec() (DiskSpec, error) {
+	s := DiskSpec{
+		Name:            "custom",
+		Sides:           1,
+		TracksPerSide:   int(ds.TracksPerSide),
+		SectorsPerTrack: int(ds.SectorsPerTrack),
+		SectorSize:      128 << ds.SectorSizeShift,
+		FirstSectorID:   1,
+		Sidedness:       int(ds.Sidedness),
+		ReservedTracks:  int(ds.ReservedTracks),
+		BlockSize:       128 << ds.BlockShift,
+		DirBlocks:       int(ds.DirBlocks),
+		GapRW:           int(ds.GapRW),
+		GapFormat:       int(ds.GapFormat),
+	}
+	if s.Sidedness != SidesSingle {
+		s.Sides = 2
+	}
+	if err := s.Validate(); err != nil {
+		return DiskSpec{}, err
+	}
+	return withPresetName(s), nil
+}
+
+// Specification returns the disk specification describing the format.
+func (s DiskSpec) Specification() DiskSpecification {
+	return DiskSpecification{
+		Format:          s.diskType(),
+		Sidedness:       byte(s.Sidedness),
+		DoubleTrack:     s.TracksPerSide > MaxTracksPerSide,
+		TracksPerSide:   byte(s.TracksPerSide),
+		SectorsPerTrack: byte(s.SectorsPerTrack),
+		SectorSizeShift: sectorSizeCode(s.SectorSize),
+		ReservedTracks:  byte(s.ReservedTracks),
+		BlockShift:      sectorSizeCode(s.BlockSize), // log2(block size / 128)
+		DirBlocks:       byte(s.DirBlocks),
+		GapRW:           byte(s.GapRW),
+		GapFormat:       byte(s.GapFormat),
+	}
+}
+
+// Specification returns the disk specification in the disk's boot sector.
+// A disk without one, as a standard +3 or CPC disk may be, returns that of
+// its format, with Bootable from the boot sector's checksum all the same.
+func (di *DiskImage) Specification() (DiskSpecification, error) {
+	boot, err := di.GetSectorData(0, 0, 0)
+	if err != nil {
+		return DiskSpecification{}, err
+	}
+	if ds, err := ParseDiskSpecification(boot); err == nil {
+		return ds, nil
+	}
+	ds := di.spec.Specification()
+	ds.Bootable = di.ValidateBootSector() == nil
+	return ds, nil
+}
@@ -0,0 +1,47 @@
+package diskimg
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// Each +3DOS format survives serialising its disk specification and parsing
+// it back, and a disk reports the specification it was created with.
+func TestDiskSpecificationRoundTrip(t *testing.T) {
+	for _, spec := range []DiskSpec{SpecPlus3, SpecPlus3DS, SpecPCW180, SpecPCW720} {
+		ds := spec.Specification()
+		b := ds.Serialize()
+		if len(b) != SpecificationSize {
+			t.Fatalf("%s: %d bytes serialised", spec.Name, len(b))
+		}
+		parsed, err := ParseDiskSpecification(b)
+		if err != nil {
+			t.Fatalf("%s: ParseDiskSpecification: %v", spec.Name, err)
+		}
+		if parsed != ds {
+			t.Errorf("%s: parsed %+v, want %+v", spec.Name, parsed, ds)
+		}
+		if got, err := parsed.DiskSpec(); err != nil || got != withPresetName(spec) {
+			t.Errorf("%s: DiskSpec() = %s, %v", spec.Name, got.Name, err)
+		}
+
+		got, err := newSpecImage(t, spec).Specification()
+		if err != nil {
+			t.Fatalf("%s: Specification: %v", spec.Name, err)
+		}
+		if !bytes.Equal(got.Serialize(), b) || got.Bootable {
+			t.Errorf("%s: disk specification %+v, want %+v", spec.Name, got, ds)
+		}
+	}
+
+	for _, b := range [][]byte{
+		bytes.Repeat([]byte{0xE5}, 512),
+		{0, 0, 40, 9, 7, 1, 3, 2, 0x2A, 0x52},
+		{0, 0, 40},
+	} {
+		if _, err := ParseDiskSpecification(b); !errors.Is(err, ErrInvalidSpec) {
+			t.Errorf("ParseDiskSpecification(% x...) = %v, want ErrInvalidSpec", b[:3], err)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+// file: pkg/diskimg/stamps.go
+
+package diskimg
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Datestamp formats a disk can carry (see DiskImage.Datestamps).
+const (
+	StampsCPM3        = "cpm3"        // CP/M Plus: an SFCB in every fourth directory entry
+	StampsDateStamper = "datestamper" // DateStamper: a !!!TIME&.DAT file
+)
+
+// Directory entry status bytes that do not hold files.
+const (
+	statusLabel = 0x20 // CP/M Plus directory label
+	statusSFCB  = 0x21 // CP/M Plus datestamps of the three entries before it
+)
+
+// Directory label flags (the label's extent byte) that turn stamping on.
+const (
+	labelAccess = 0x40 // the first stamp of an SFCB is the last access
+	labelUpdate = 0x20
+	labelCreate = 0x10
+	labelExists = 0x01
+)
+
+// dateStamperFile is the file in which DateStamper keeps its stamps.
+const dateStamperFile = "!!!TIME&.DAT"
+
+// Stamps holds the datestamps of a file. A stamp the disk does not record is
+// the zero time.
+type Stamps struct {
+	Created  time.Time
+	Accessed time.Time
+	Modified time.Time
+}
+
+// Datestamps returns the format of the datestamps on the disk, StampsCPM3 or
+// StampsDateStamper, or "" if it has none.
+func (di *DiskImage) Datestamps() string {
+	for i := range di.directory.Entries {
+		if di.directory.Entries[i].Status == statusSFCB {
+			return StampsCPM3
+		}
+	}
+	if _, err := di.directory.FindFile(dateStamperFile); err == nil {
+		return StampsDateStamper
+	}
+	return ""
+}
+
+// FileStamps returns the datestamps of a file. On a CP/M Plus disk the first
+// stamp of each file is its creation or, if the directory label says so, its
+// last access; DateStamper records all three.
+func (di *DiskImage) FileStamps(filename string) (Stamps, error) {
+	first, err := di.directory.FindFile(filename)
+	if err != nil {
+		return Stamps{}, err
+	}
+	idx := di.entryIndex(first)
+	switch di.Datestamps() {
+	case StampsCPM3:
+		sfcb, slot := di.sfcb(idx)
+		if sfcb == nil {
+			return Stamps{}, nil
+		}
+		raw := sfcb.bytes()
+		b := raw[1+10*slot:]
+		st := Stamps{Modified: decodeCPMStamp(b[4:8])}
+		if di.labelFlags()&labelAccess != 0 {
+			st.Accessed = decodeCPMStamp(b[0:4])
+		} else {
+			st.Created = decodeCPMStamp(b[0:4])
+		}
+		return st, nil
+	case StampsDateStamper:
+		f, err := di.openFile(dateStamperFile, os.O_RDONLY)
+		if err != nil {
+			return Stamps{}, err
+		}
+		b := make([]byte, 15)
+		if _, err := f.ReadAt(b, int64(idx)*16); err != nil && err != io.EOF {
+			return Stamps{}, fileError("stamp", filename, err)
+		}
+		return Stamps{
+			Created:  decodeBCDStamp(b[0:5]),
+			Accessed: decodeBCDStamp(b[5:10]),
+			Modified: decodeBCDStamp(b[10:15]),
+		}, nil
+	}
+	return Stamps{}, nil
+}
+
+// SetFileStamps sets the datestamps of a file on a CP/M Plus disk and flushes
+// the directory. A zero time leaves its stamp as it is, as does Accessed on
+// a disk stamping creation and Created on one stamping access. Other disks
+// return ErrUnsupported; see EnableDatestamps.
+func (di *DiskImage) SetFileStamps(filename string, st Stamps) error {
+	first, err := di.directory.FindFile(filename)
+	if err != nil {
+		return err
+	}
+	if di.Datestamps() != StampsCPM3 {
+		return fileError("stamp", filename, fmt.Errorf("%w: the disk has no CP/M Plus datestamps", ErrUnsupported))
+	}
+	di.stampEntry(first, st)
+	di.Modified = true
+	return di.FlushDirectory()
+}
+
+// EnableDatestamps prepares the directory for CP/M Plus datestamps: it moves
+// the files out of every fourth entry, which become SFCBs, and adds a
+// directory label turning on creation and update stamps. Files written from
+// then on are stamped. A disk that already has CP/M Plus stamps is left as it
+// is. The directory is flushed.
+func (di *DiskImage) EnableDatestamps() error {
+	if di.Datestamps() == StampsCPM3 {
+		return nil
+	}
+	entries := di.directory.Entries
+	var free, moving []int
+	for i := range entries {
+		switch {
+		case i%4 != 3 && entries[i].IsUnused():
+			free = append(free, i)
+		case i%4 == 3 && !entries[i].IsUnused():
+			moving = append(moving, i)
+		}
+	}
+	need := len(moving)
+	if di.label() == nil {
+		need++
+	}
+	if len(free) < need {
+		return fmt.Errorf("%w: %d free entries needed for datestamps, %d free", ErrDirectoryFull, need, len(free))
+	}
+	for n, i := range moving {
+		entries[free[n]] = entries[i]
+	}
+	di.directory.Reindex()
+	for i := 3; i < len(entries); i += 4 {
+		entries[i] = DirectoryEntry{Status: statusSFCB}
+	}
+
+	label := di.label()
+	if label == nil {
+		label = &entries[free[len(moving)]]
+		*label = newLabel("")
+	}
+	label.Extent |= labelExists | labelCreate | labelUpdate
+
+	logger.Debug("enabled datestamps", "moved", len(moving))
+	di.Modified = true
+	return di.FlushDirectory()
+}
+
+// stampEntry writes st into the SFCB of the file whose first entry is e, if
+// it has one.
+func (di *DiskImage) stampEntry(e *DirectoryEntry, st Stamps) {
+	sfcb, slot := di.sfcb(di.entryIndex(e))
+	if sfcb == nil {
+		return
+	}
+	raw := sfcb.bytes()
+	b := raw[1+10*slot:]
+	first := st.Created
+	if di.labelFlags()&labelAccess != 0 {
+		first = st.Accessed
+	}
+	if !first.IsZero() {
+		encodeCPMStamp(b[0:4], first)
+	}
+	if !st.Modified.IsZero() {
+		encodeCPMStamp(b[4:8], st.Modified)
+	}
+	sfcb.setBytes(raw)
+}
+
+// sfcb returns the SFCB holding the stamps of entry idx and the entry's slot
+// in it, or nil if there is none.
+func (di *DiskImage) sfcb(idx int) (*DirectoryEntry, int) {
+	if idx < 0 || idx%4 == 3 || idx|3 >= len(di.directory.Entries) {
+		return nil, 0
+	}
+	if s := &di.directory.Entries[idx|3]; s.Status == statusSFCB {
+		return s, idx % 4
+	}
+	return nil, 0
+}
+
+// labelFlags returns the flags of the directory label, or 0 if there is none.
+func (di *DiskImage) labelFlags() byte {
+	if l := di.label(); l != nil {
+		return l.Extent
+	}
+	return 0
+}
+
+// entryIndex returns the index of e in the directory, or -1.
+func (di *DiskImage) entryIndex(e *DirectoryEntry) int {
+	for i := range di.directory.Entries {
+		if &di.directory.Entries[i] == e {
+			return i
+		}
+	}
+	return -1
+}
+
+// bytes returns the entry as stored on disk.
+func (de *DirectoryEntry) bytes() [DirectoryEntrySize]byte {
+	var b [DirectoryEntrySize]byte
+	b[0] = de.Status
+	copy(b[1:9], de.Name[:])
+	copy(b[9:12], de.Extension[:])
+	b[12], b[13], b[14], b[15] = de.Extent, de.Reserved1, de.Reserved2, de.RecordCount
+	copy(b[16:], de.AllocationBlocks[:])
+	return b
+}
+
+// setBytes sets the entry from its bytes as stored on disk.
+func (de *DirectoryEntry) setBytes(b [DirectoryEntrySize]byte) {
+	de.Status = b[0]
+	copy(de.Name[:], b[1:9])
+	copy(de.Extension[:], b[9:12])
+	de.Extent, de.Reserved1, de.Reserved2, de.RecordCount = b[12], b[13], b[14], b[15]
+	copy(de.AllocationBlocks[:], b[16:])
+}
+
+// decodeCPMStamp decodes a CP/M Plus stamp: a day count from 1 January 1978
+// as day 1, then the hour and minute in BCD. Day 0 is no stamp.
+func decodeCPMStamp(b []byte) time.Time {
+	days := int(b[0]) | int(b[1])<<8
+	if days == 0 {
+		return time.Time{}
+	}
+	return time.Date(1977, 12, 31+days, fromBCD(b[2]), fromBCD(b[3]), 0, 0, time.Local)
+}
+
+// encodeCPMStamp encodes t, in local time, as a CP/M Plus stamp.
+func encodeCPMStamp(b []byte, t time.Time) {
+	t = t.Local()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	days := int(day.Sub(time.Date(1977, 12, 31, 0, 0, 0, 0, time.UTC)).Hours() / 24)
+	if days < 1 || days > 0xFFFF {
+		days = 0
+	}
+	b[0], b[1] = byte(days), byte(days>>8)
+	b[2], b[3] = toBCD(t.Hour()), toBCD(t.Minute())
+}
+
+// decodeBCDStamp decodes a DateStamper stamp: year, month, day, hour and
+// minute in BCD, years before 78 being in the 2000s. All zero is no stamp.
+func decodeBCDStamp(b []byte) time.Time {
+	month, day := fromBCD(b[1]), fromBCD(b[2])
+	if month < 1 || month > 12 || day < 1 || day > 31 {
+		return time.Time{}
+	}
+	year := 1900 + fromBCD(b[0])
+	if year < 1978 {
+		year += 100
+	}
+	return time.Date(year, time.Month(month), day, fromBCD(b[3]), fromBCD(b[4]), 0, 0, time.Local)
+}
+
+func fromBCD(b byte) int {
+	return int(b>>4)*10 + int(b&0x0F)
+}
+
+func toBCD(n int) byte {
+	return byte(n/10<<4 | n%10)
+}
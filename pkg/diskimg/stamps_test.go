@@ -0,0 +1,87 @@
+package diskimg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+	"time"
+)
+
+// EnableDatestamps moves files out of the SFCB entries, and files written
+// afterwards carry creation and update stamps that survive a reload.
+func TestCPM3Datestamps(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	for i := range 5 {
+		if err := di.writeRecords(fmt.Sprintf("F%d.BIN", i), []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := di.SetFileStamps("F0.BIN", Stamps{Modified: time.Now()}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("SetFileStamps without stamps: err = %v, want ErrUnsupported", err)
+	}
+	if err := di.EnableDatestamps(); err != nil {
+		t.Fatal(err)
+	}
+	if got := di.Datestamps(); got != StampsCPM3 {
+		t.Fatalf("Datestamps() = %q", got)
+	}
+	if files, _ := di.FileEntries(); len(files) != 5 {
+		t.Errorf("%d files after EnableDatestamps, want 5", len(files))
+	}
+	if err := di.DiskCheck(); err != nil {
+		t.Errorf("DiskCheck: %v", err)
+	}
+
+	before := time.Now().Add(-time.Minute)
+	if err := di.writeRecords("NEW.BIN", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Date(2001, 2, 3, 4, 5, 0, 0, time.Local)
+	if err := di.SetFileStamps("F3.BIN", Stamps{Modified: modified}); err != nil {
+		t.Fatal(err)
+	}
+
+	di = reload(t, di)
+	st, err := di.FileStamps("NEW.BIN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Created.Before(before) || st.Modified.Before(before) || !st.Accessed.IsZero() {
+		t.Errorf("NEW.BIN stamps = %+v", st)
+	}
+	if st, _ := di.FileStamps("F3.BIN"); !st.Modified.Equal(modified) {
+		t.Errorf("F3.BIN modified = %v, want %v", st.Modified, modified)
+	}
+	if got, _ := fs.ReadFile(di, "F3.BIN"); !bytes.Equal(got, []byte("data")) {
+		t.Errorf("moved file reads %q", got)
+	}
+}
+
+// A DateStamper disk's stamps are read from !!!TIME&.DAT, 16 bytes per
+// directory entry.
+func TestDateStamperStamps(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.writeRecords("GAME.BIN", []byte("game")); err != nil {
+		t.Fatal(err)
+	}
+	stamps := make([]byte, 128)
+	copy(stamps, "\x95\x12\x31\x23\x59"+"\x00\x00\x00\x00\x00"+"\x03\x07\x04\x12\x30")
+	if err := di.writeRecords(dateStamperFile, stamps); err != nil {
+		t.Fatal(err)
+	}
+	if got := di.Datestamps(); got != StampsDateStamper {
+		t.Fatalf("Datestamps() = %q", got)
+	}
+	st, err := di.FileStamps("GAME.BIN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(1995, 12, 31, 23, 59, 0, 0, time.Local); !st.Created.Equal(want) {
+		t.Errorf("created = %v, want %v", st.Created, want)
+	}
+	if want := time.Date(2003, 7, 4, 12, 30, 0, 0, time.Local); !st.Modified.Equal(want) || !st.Accessed.IsZero() {
+		t.Errorf("stamps = %+v", st)
+	}
+}
@@ -0,0 +1,114 @@
+// file: pkg/diskimg/stat.go
+
+package diskimg
+
+import "os"
+
+// FileInfo describes a file on the disk, as StatFile returns it.
+type FileInfo struct {
+	Name       string
+	Size       int64 // exact length in bytes, PLUS3DOS header included
+	Records    int   // 128-byte records in the directory
+	Extents    int   // directory entries the file takes
+	Blocks     []int // allocation blocks, in file order
+	User       int   // user area, 0-15
+	Attributes FileAttributes
+	Stamps     Stamps // zero times on a disk without datestamps
+
+	// Header is the file's PLUS3DOS header, nil if it has none. HeaderType,
+	// Line and LoadAddress are read from it.
+	Header      *Plus3DosHeader
+	HeaderType  byte   // FileTypeProgram, FileTypeCode, ...
+	Line        int    // a program's autostart LINE, -1 if none
+	LoadAddress int    // a code file's load address, -1 if none
+	DataLength  uint16 // the length of the data after the header
+}
+
+// Headered reports whether the file has a PLUS3DOS header.
+func (fi FileInfo) Headered() bool {
+	return fi.Header != nil
+}
+
+// Catalog returns the files on the disk, system files included, in directory
+// order: one FileInfo for each file however many directory entries it takes.
+func (di *DiskImage) Catalog() ([]FileInfo, error) {
+	var files []FileInfo
+	for e := range di.Files(&FilesOptions{System: true}) {
+		fi, err := di.StatFile(e.GetFilename())
+		if err != nil {
+			return files, err
+		}
+		files = append(files, fi)
+	}
+	return files, nil
+}
+
+// StatFile returns the description of a file. (Stat is the fs.StatFS method,
+// whose fs.FileInfo carries only the name, size and mode.)
+func (di *DiskImage) StatFile(filename string) (FileInfo, error) {
+	f, err := di.OpenFile(filename, os.O_RDONLY)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	defer f.Close()
+
+	fi := FileInfo{
+		Name:        f.entry.GetFilename(),
+		Size:        f.size,
+		Records:     di.fileRecords(f.extents),
+		Extents:     len(f.extents),
+		Blocks:      f.blocks,
+		User:        int(f.entry.Status),
+		Line:        -1,
+		LoadAddress: -1,
+	}
+	fi.Attributes.ReadFromDirectoryEntry(f.entry)
+	if st, err := di.FileStamps(fi.Name); err == nil {
+		fi.Stamps = st
+	}
+	if f.isHeadered {
+		h := *f.header
+		fi.Header = &h
+		var param uint16
+		fi.HeaderType, fi.DataLength, param, _ = h.GetBasicHeader()
+		switch fi.HeaderType {
+		case FileTypeProgram:
+			if param < 0x8000 {
+				fi.Line = int(param)
+			}
+		case FileTypeCode:
+			fi.LoadAddress = int(param)
+		}
+	}
+	return fi, nil
+}
+
+// Extent describes one directory entry of a file, as FileExtents returns it.
+type Extent struct {
+	Entry     int   // position of the entry in the directory
+	Number    int   // extent number
+	Records   int   // 128-byte records in the entry
+	LastBytes int   // bytes used in the last record, 0 for all 128
+	Blocks    []int // allocation blocks, in file order
+}
+
+// FileExtents returns the directory entries of a file, in extent order. A
+// file has one for each 16K or so (see DiskSpec.ExtentMask); FileBlocks
+// gives the blocks of all of them together.
+func (di *DiskImage) FileExtents(filename string) ([]Extent, error) {
+	first, err := di.directory.FindFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var extents []Extent
+	for _, e := range di.directory.fileExtents(first) {
+		extents = append(extents, Extent{
+			Entry:     di.entryIndex(e),
+			Number:    e.extentNumber(),
+			Records:   di.fileRecords([]*DirectoryEntry{e}),
+			LastBytes: int(e.Reserved1),
+			Blocks:    e.blockPointers(di.spec.WideBlockPointers()),
+		})
+	}
+	return extents, nil
+}
@@ -0,0 +1,110 @@
+package diskimg
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// StatFile describes a file from its directory entries and PLUS3DOS header.
+func TestStatFile(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	code := make([]byte, 300)
+	if err := di.ImportData("game.bin", code, &ImportOptions{AddHeader: true, FileType: FileTypeCode, LoadAddr: 32768}); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.writeRecords("NOTES.TXT", []byte("notes")); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.SetFileAttributes("NOTES.TXT", true, false); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := di.StatFile("GAME.BIN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Name != "GAME.BIN" || fi.Size != HeaderSize+300 || fi.Records != 4 || fi.User != 0 {
+		t.Errorf("GAME.BIN = %q, %d bytes, %d records, user %d", fi.Name, fi.Size, fi.Records, fi.User)
+	}
+	if !fi.Headered() || fi.HeaderType != FileTypeCode || fi.LoadAddress != 32768 || fi.Line != -1 || fi.DataLength != 300 {
+		t.Errorf("GAME.BIN header: type %d, load %d, line %d, length %d", fi.HeaderType, fi.LoadAddress, fi.Line, fi.DataLength)
+	}
+
+	fi, err = di.StatFile("notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Headered() || fi.Size != 5 || !fi.Attributes.ReadOnly || fi.LoadAddress != -1 {
+		t.Errorf("NOTES.TXT: headered %v, %d bytes, read-only %v", fi.Headered(), fi.Size, fi.Attributes.ReadOnly)
+	}
+
+	if _, err := di.StatFile("NONE.BIN"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("StatFile of a missing file: err = %v, want ErrFileNotFound", err)
+	}
+}
+
+// Catalog gives one FileInfo per file, a multi-extent file with its total
+// size and every block.
+func TestCatalog(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.writeRecords("BIG.DAT", make([]byte, 40000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.writeRecords("SMALL.DAT", make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	files, err := di.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Catalog = %d files, want 2", len(files))
+	}
+	big := files[0]
+	if big.Name != "BIG.DAT" || big.Size != 40000 || big.Extents != 3 || len(big.Blocks) != 40 {
+		t.Errorf("BIG.DAT = %q, %d bytes, %d extents, %d blocks", big.Name, big.Size, big.Extents, len(big.Blocks))
+	}
+	want, _ := di.FileBlocks("BIG.DAT")
+	if !slices.Equal(big.Blocks, want) {
+		t.Errorf("BIG.DAT blocks = %v, want %v", big.Blocks, want)
+	}
+}
+
+// FileExtents lists a multi-extent file's entries in order, their records and
+// blocks adding up to the whole file's.
+func TestFileExtents(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.writeRecords("SMALL.DAT", make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.writeRecords("BIG.DAT", make([]byte, 40000)); err != nil {
+		t.Fatal(err)
+	}
+	extents, err := di.FileExtents("big.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(extents) != 3 {
+		t.Fatalf("BIG.DAT has %d extents, want 3", len(extents))
+	}
+	var records int
+	var blocks []int
+	for i, e := range extents {
+		if e.Number != i || e.Entry != i+1 {
+			t.Errorf("extent %d: number %d, entry %d", i, e.Number, e.Entry)
+		}
+		records += e.Records
+		blocks = append(blocks, e.Blocks...)
+	}
+	if want, _ := di.FileRecords("BIG.DAT"); records != want {
+		t.Errorf("extent records add up to %d, want %d", records, want)
+	}
+	if want, _ := di.FileBlocks("BIG.DAT"); !slices.Equal(blocks, want) {
+		t.Errorf("extent blocks = %v, want %v", blocks, want)
+	}
+
+	if _, err := di.FileExtents("NONE.DAT"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("FileExtents of a missing file: err = %v, want ErrFileNotFound", err)
+	}
+}
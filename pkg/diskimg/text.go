@@ -0,0 +1,125 @@
+// file: pkg/diskimg/text.go
+
+package diskimg
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"slices"
+	"unicode/utf8"
+)
+
+// TextOptions configures the conversion of text files between the disk's
+// conventions and the host's.
+type TextOptions struct {
+	CR      bool // end lines with CR alone, as the Spectrum prints them, rather than CP/M's CRLF
+	Charset bool // map the Spectrum's £, © and block graphics to and from UTF-8
+}
+
+// textRecord is the CP/M record size a text file is padded to with soft EOFs.
+const textRecord = 128
+
+// softEOF marks the end of a CP/M text file.
+const softEOF = 0x1A
+
+// blockGraphics are the Unicode block elements for the Spectrum's graphics
+// characters 0x80-0x8F, whose bits set the top right, top left, bottom right
+// and bottom left quarters.
+var blockGraphics = []rune(" ▝▘▀▗▐▚▜▖▞▌▛▄▟▙█")
+
+// TextToHost converts a text file from the disk: it ends at the first soft
+// EOF (0x1A), CRLF and CR line endings become LF, and with opts.Charset the
+// Spectrum's £ (0x60), © (0x7F) and block graphics become UTF-8. Other bytes
+// above 0x7F then become the Latin-1 character of the same code, so that
+// TextFromHost gives them back.
+func TextToHost(data []byte, opts *TextOptions) []byte {
+	if opts == nil {
+		opts = &TextOptions{}
+	}
+	if i := bytes.IndexByte(data, softEOF); i >= 0 {
+		data = data[:i]
+	}
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	data = bytes.ReplaceAll(data, []byte("\r"), []byte("\n"))
+	if !opts.Charset {
+		return data
+	}
+
+	var out []byte
+	for _, b := range data {
+		switch {
+		case b == 0x60:
+			out = utf8.AppendRune(out, '£')
+		case b == 0x7F:
+			out = utf8.AppendRune(out, '©')
+		case b >= 0x80 && b <= 0x8F:
+			out = utf8.AppendRune(out, blockGraphics[b-0x80])
+		default:
+			out = utf8.AppendRune(out, rune(b))
+		}
+	}
+	return out
+}
+
+// TextFromHost converts a host text file for the disk, undoing TextToHost:
+// LF and CRLF line endings become CRLF, or CR with opts.CR, and the text is
+// ended with a soft EOF and padded with more to a whole 128-byte record. With
+// opts.Charset, UTF-8 £, © and block elements become the Spectrum's codes,
+// Latin-1 characters their own, and any other character '?'.
+func TextFromHost(data []byte, opts *TextOptions) []byte {
+	if opts == nil {
+		opts = &TextOptions{}
+	}
+	eol := []byte("\r\n")
+	if opts.CR {
+		eol = []byte("\r")
+	}
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	data = bytes.ReplaceAll(data, []byte("\n"), eol)
+
+	if opts.Charset {
+		var out []byte
+		for _, r := range string(data) {
+			switch i := slices.Index(blockGraphics, r); {
+			case r == '£':
+				out = append(out, 0x60)
+			case r == '©':
+				out = append(out, 0x7F)
+			case r != ' ' && i >= 0:
+				out = append(out, 0x80+byte(i))
+			case r <= 0xFF:
+				out = append(out, byte(r))
+			default:
+				out = append(out, '?')
+			}
+		}
+		data = out
+	}
+
+	data = append(data, softEOF)
+	for len(data)%textRecord != 0 {
+		data = append(data, softEOF)
+	}
+	return data
+}
+
+// ImportText stores a host text file converted with TextFromHost, without a
+// PLUS3DOS header, under its host name (8.3, upper-cased).
+func (di *DiskImage) ImportText(hostPath string, opts *TextOptions) error {
+	data, err := os.ReadFile(hostPath)
+	if err != nil {
+		return err
+	}
+	return di.ImportData(HostName(hostPath, ""), TextFromHost(data, opts), nil)
+}
+
+// ExportText writes a text file on the disk to the host converted with
+// TextToHost, leaving out its PLUS3DOS header if it has one.
+func (di *DiskImage) ExportText(diskPath, hostPath string, opts *TextOptions) error {
+	data, err := fs.ReadFile(di.HeaderlessFS(), diskPath)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(hostPath, TextToHost(data, opts), 0644)
+}
@@ -0,0 +1,51 @@
+package diskimg
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Text files convert line endings and the soft EOF, and with Charset the
+// Spectrum's own characters, in both directions.
+func TestTextConversion(t *testing.T) {
+	disk := []byte("one\r\ntwo\rthree \x60\x7F\x83\x8F\xE9\r\n\x1A\x1A junk")
+	if got := string(TextToHost(disk, nil)); got != "one\ntwo\nthree `\x7F\x83\x8F\xE9\n" {
+		t.Errorf("TextToHost = %q", got)
+	}
+	host := TextToHost(disk, &TextOptions{Charset: true})
+	if got, want := string(host), "one\ntwo\nthree £©▀█é\n"; got != want {
+		t.Errorf("TextToHost with Charset = %q, want %q", got, want)
+	}
+
+	back := TextFromHost(host, &TextOptions{Charset: true})
+	want := []byte("one\r\ntwo\r\nthree \x60\x7F\x83\x8F\xE9\r\n")
+	if !bytes.HasPrefix(back, want) || len(back) != textRecord || len(bytes.Trim(back[len(want):], "\x1A")) != 0 {
+		t.Errorf("TextFromHost = %q, want %q padded with soft EOFs to 128 bytes", back, want)
+	}
+	if got := TextFromHost([]byte("a\nb ☃\n"), &TextOptions{CR: true, Charset: true}); !bytes.HasPrefix(got, []byte("a\rb ?\r\x1A")) {
+		t.Errorf("TextFromHost with CR = %q", got)
+	}
+}
+
+// ImportText and ExportText round-trip a host file through the disk.
+func TestImportExportText(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(src, []byte("line 1\nline 2 £5\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	di := newSpecImage(t, SpecPlus3)
+	opts := &TextOptions{Charset: true}
+	if err := di.ImportText(src, opts); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(dir, "out.txt")
+	if err := di.ExportText("NOTES.TXT", dst, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dst); string(got) != "line 1\nline 2 £5\n" {
+		t.Errorf("round trip = %q", got)
+	}
+}
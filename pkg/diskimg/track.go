@@ -54,7 +54,8 @@ func NewTrackInfo(track, side int) *TrackInfo {
 	return ti
 }
 
-// GetTrackInfo returns track information for given track
+// GetTrackInfo returns the track information block of a track, as stored in
+// the image.
 func (di *DiskImage) GetTrackInfo(track, side int) (*TrackInfo, error) {
 	if track < 0 || track >= int(di.Header.TracksNum) {
 		return nil, ErrInvalidTrack
@@ -62,29 +63,133 @@ func (di *DiskImage) GetTrackInfo(track, side int) (*TrackInfo, error) {
 	if side < 0 || side >= int(di.Header.SidesNum) {
 		return nil, ErrInvalidSide
 	}
+	idx := di.trackIndex(track, side)
+	if idx >= len(di.Tracks) {
+		return nil, ErrInvalidTrack
+	}
+	td, err := di.track(idx)
+	if err != nil {
+		return nil, err
+	}
+	if td == nil {
+		return nil, ErrInvalidTrack // absent (unformatted) track
+	}
+	return parseTrackInfo(td)
+}
+
+// parseTrackInfo decodes the track information block at the start of a track
+// block.
+func parseTrackInfo(block []byte) (*TrackInfo, error) {
+	ti, err := decodeTrackInfo(block, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &ti, nil
+}
+
+// decodeTrackInfo is parseTrackInfo decoding the sector information list into
+// sectors if it has room, so that a caller can keep both on the stack.
+func decodeTrackInfo(block []byte, sectors []SectorInfo) (TrackInfo, error) {
+	if len(block) < 256 {
+		return TrackInfo{}, ErrInvalidTrackSignature
+	}
+	ti := TrackInfo{
+		TrackNum:   block[0x10],
+		SideNum:    block[0x11],
+		SectorSize: block[0x14],
+		SectorsNum: block[0x15],
+		GapLength:  block[0x16],
+		FillerByte: block[0x17],
+	}
+	copy(ti.Signature[:], block[0:13])
+	if ti.SectorsNum > 29 { // the sector information list fills the block
+		return TrackInfo{}, ErrInvalidSectorCount
+	}
+	if cap(sectors) >= int(ti.SectorsNum) {
+		ti.SectorInfo = sectors[:ti.SectorsNum]
+	} else {
+		ti.SectorInfo = make([]SectorInfo, ti.SectorsNum)
+	}
+	for i := range ti.SectorInfo {
+		si := block[0x18+i*8:]
+		ti.SectorInfo[i] = SectorInfo{
+			Track:      si[0],
+			Side:       si[1],
+			SectorID:   si[2],
+			Size:       si[3],
+			Status1:    si[4],
+			Status2:    si[5],
+			ActualSize: uint16(si[6]) | uint16(si[7])<<8,
+		}
+	}
+	return ti, nil
+}
 
-	return NewTrackInfo(track, side), nil
+// DataSize returns the number of bytes stored for the sector: the actual data
+// length recorded by an extended image, or else 128 << Size.
+func (si SectorInfo) DataSize() int {
+	if si.ActualSize != 0 {
+		return int(si.ActualSize)
+	}
+	return 128 << min(int(si.Size), 6)
+}
+
+// Copies returns the number of copies of the sector's data in the image. A
+// weak sector, which reads differently each time, is dumped as several reads
+// one after another, its actual data length a multiple of the sector size.
+func (si SectorInfo) Copies() int {
+	size := 128 << min(int(si.Size), 6)
+	if n := int(si.ActualSize); n > size && n%size == 0 {
+		return n / size
+	}
+	return 1
 }
 
-// ValidateTrackInfo verifies track information
+// sectorIndex returns the position on the track of the first sector with the
+// given ID, or -1 if there is none.
+func (ti *TrackInfo) sectorIndex(id int) int {
+	for i, si := range ti.SectorInfo {
+		if int(si.SectorID) == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// sectorOffset returns the offset of the n-th sector's data within the track
+// block and its size. Sectors may differ in size, so the offset is the sum of
+// the sizes of the sectors before it.
+func (ti *TrackInfo) sectorOffset(n int) (offset, size int) {
+	offset = 256
+	for _, si := range ti.SectorInfo[:n] {
+		offset += si.DataSize()
+	}
+	return offset, ti.SectorInfo[n].DataSize()
+}
+
+// Validate verifies track information. Sectors may be of any size the FDC
+// supports (128 to 8192 bytes), and may differ within a track. Sector IDs must
+// be a run of consecutive numbers, in any (interleaved) order.
 func (ti *TrackInfo) Validate() error {
-	if string(ti.Signature[:12]) != "Track-Info\r\n" {
+	if string(ti.Signature[:10]) != "Track-Info" {
 		return ErrInvalidTrackSignature
 	}
 
-	if ti.SectorSize != 2 { // 512 bytes
+	if ti.SectorSize > 6 {
 		return ErrInvalidSectorSize
 	}
 
-	if ti.SectorsNum != SectorsPerTrack {
+	if int(ti.SectorsNum) != len(ti.SectorInfo) {
 		return ErrInvalidSectorCount
 	}
 
-	for i, si := range ti.SectorInfo {
-		if si.Size != 2 {
-			return ErrInvalidSectorSize
-		}
-		if si.ActualSize != BytesPerSector {
+	lowest := 255
+	for _, si := range ti.SectorInfo {
+		lowest = min(lowest, int(si.SectorID))
+	}
+	seen := make(map[uint8]bool, len(ti.SectorInfo))
+	for _, si := range ti.SectorInfo {
+		if si.Size > 6 {
 			return ErrInvalidSectorSize
 		}
 		if si.Track != ti.TrackNum {
@@ -93,9 +198,10 @@ func (ti *TrackInfo) Validate() error {
 		if si.Side != ti.SideNum {
 			return ErrInvalidSide
 		}
-		if si.SectorID != uint8(i+1) {
+		if int(si.SectorID) >= lowest+len(ti.SectorInfo) || seen[si.SectorID] {
 			return ErrInvalidSectorID
 		}
+		seen[si.SectorID] = true
 	}
 
 	return nil
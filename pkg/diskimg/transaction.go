@@ -0,0 +1,56 @@
+// file: pkg/diskimg/transaction.go
+
+package diskimg
+
+// Tx is a transaction on a disk image, begun with DiskImage.Begin. It embeds
+// a private copy of the disk, so every DiskImage method works on it; its
+// changes reach the original disk only on Commit.
+type Tx struct {
+	*DiskImage
+	orig *DiskImage
+}
+
+// Begin starts a transaction on the disk. Until the transaction is committed
+// or rolled back the disk itself should not be changed, as Commit replaces
+// it with the transaction's copy. Files opened through the transaction must
+// be closed before Commit.
+func (di *DiskImage) Begin() *Tx {
+	return &Tx{DiskImage: di.Snapshot(), orig: di}
+}
+
+// Commit applies the transaction's changes to the disk.
+func (tx *Tx) Commit() error {
+	if tx.DiskImage == nil {
+		return ErrTxDone
+	}
+	staged, di := tx.DiskImage, tx.orig
+	di.Header = staged.Header
+	di.Tracks, di.shared = staged.Tracks, staged.shared
+	if di.lazy != nil && staged.lazy != nil {
+		di.lazy.loaded = staged.lazy.loaded
+	}
+	di.Modified = staged.Modified
+	di.DiskType = staged.DiskType
+	di.directory = staged.directory
+	di.allocation = staged.allocation
+	di.fileAlloc = staged.fileAlloc
+	if di.fileAlloc != nil {
+		di.fileAlloc.disk = di
+	}
+	di.concealments = staged.concealments
+	di.changes = staged.changes
+	di.dirty = staged.dirty
+	tx.DiskImage = nil
+	logger.Debug("committed transaction")
+	return nil
+}
+
+// Rollback discards the transaction's changes, leaving the disk as it was
+// when the transaction began.
+func (tx *Tx) Rollback() error {
+	if tx.DiskImage == nil {
+		return ErrTxDone
+	}
+	tx.DiskImage = nil
+	return nil
+}
@@ -0,0 +1,61 @@
+package diskimg
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+)
+
+// A transaction's changes reach the disk on Commit and not before; Rollback
+// leaves the disk as it was.
+func TestTransaction(t *testing.T) {
+	di := newSpecImage(t, SpecPlus3)
+	if err := di.WriteFile("KEEP.TXT", []byte("keep")); err != nil {
+		t.Fatal(err)
+	}
+	free := di.fileSpace()
+
+	tx := di.Begin()
+	if err := tx.WriteFile("NEW.TXT", make([]byte, 5000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.DeleteFile("KEEP.TXT"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := di.Stat("NEW.TXT"); err == nil {
+		t.Error("an uncommitted file is visible on the disk")
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := fs.ReadFile(di, "KEEP.TXT"); err != nil || string(data) != "keep" {
+		t.Errorf("after Rollback, KEEP.TXT = %q, %v", data, err)
+	}
+	if got := di.fileSpace(); got != free {
+		t.Errorf("after Rollback, %d bytes free, want %d", got, free)
+	}
+	if err := tx.Commit(); !errors.Is(err, ErrTxDone) {
+		t.Errorf("Commit after Rollback: err = %v, want ErrTxDone", err)
+	}
+
+	tx = di.Begin()
+	if err := tx.WriteFile("NEW.TXT", make([]byte, 5000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	// The committed disk allocates from its own state, not the transaction's.
+	if err := di.WriteFile("MORE.TXT", make([]byte, 3000)); err != nil {
+		t.Fatal(err)
+	}
+	di = reload(t, di)
+	for name, size := range map[string]int{"KEEP.TXT": 4, "NEW.TXT": 5000, "MORE.TXT": 3000} {
+		if data, err := fs.ReadFile(di, name); err != nil || len(data) != size {
+			t.Errorf("after Commit, %s: %d bytes, %v", name, len(data), err)
+		}
+	}
+	if err := di.DiskCheck(); err != nil {
+		t.Errorf("DiskCheck after Commit: %v", err)
+	}
+}
@@ -0,0 +1,425 @@
+// file: pkg/diskimg/trdos.go
+
+package diskimg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// TR-DOS is the disk system of the Beta Disk interface. A .trd image is a raw
+// dump of a TR-DOS disk: 256-byte sectors, sixteen to a track, both sides of a
+// cylinder together. Track 0 holds the catalogue (eight sectors of sixteen
+// 16-byte entries) and the disk information sector; files occupy consecutive
+// sectors. A .scl image is a compact archive of the same files: a "SINCLAIR"
+// signature, the catalogue entries without their location, the file data and
+// a checksum.
+const (
+	TRDOSSectorSize      = 256
+	TRDOSSectorsPerTrack = 16
+	TRDOSMaxFiles        = 128
+
+	trdosInfoSector = 8    // disk information sector on track 0
+	trdosID         = 0x10 // TR-DOS identification byte
+)
+
+// TR-DOS disk types, from byte 0xE3 of the disk information sector.
+const (
+	TRDOS80DS = 0x16 // 80 tracks, double-sided (640K)
+	TRDOS40DS = 0x17 // 40 tracks, double-sided
+	TRDOS80SS = 0x18 // 80 tracks, single-sided
+	TRDOS40SS = 0x19 // 40 tracks, single-sided
+)
+
+// TRDOSFile is a file on a TR-DOS disk.
+type TRDOSFile struct {
+	Name   string // up to 8 characters
+	Type   byte   // 'B' BASIC, 'C' code, 'D' data array, '#' sequential
+	Start  uint16 // load address; for BASIC the program and variables length
+	Length uint16 // data length; for BASIC the program length without variables
+	Data   []byte // the file's sectors, a multiple of 256 bytes
+}
+
+// Contents returns the file's bytes without sector padding (for BASIC, the
+// program and its variables).
+func (f *TRDOSFile) Contents() []byte {
+	n := int(f.Length)
+	if f.Type == 'B' {
+		n = int(f.Start)
+	}
+	return f.Data[:min(n, len(f.Data))]
+}
+
+// AutostartLine returns the LINE a BASIC program runs from, recorded after the
+// program as 0x80 0xAA and the line number.
+func (f *TRDOSFile) AutostartLine() (uint16, bool) {
+	n := int(f.Start)
+	if f.Type != 'B' || n+4 > len(f.Data) || f.Data[n] != 0x80 || f.Data[n+1] != 0xAA {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint16(f.Data[n+2:]), true
+}
+
+// sectors returns the number of sectors the file occupies.
+func (f *TRDOSFile) sectors() int {
+	return (len(f.Data) + TRDOSSectorSize - 1) / TRDOSSectorSize
+}
+
+// TRDOSImage is a TR-DOS disk: its label, geometry and files.
+type TRDOSImage struct {
+	Label  string
+	Tracks int // tracks per side, 40 or 80
+	Sides  int
+	Files  []TRDOSFile
+}
+
+// NewTRDOSImage returns an empty 80-track, double-sided TR-DOS disk.
+func NewTRDOSImage() *TRDOSImage {
+	return &TRDOSImage{Tracks: 80, Sides: 2}
+}
+
+// diskType returns the disk information type byte for the geometry.
+func (t *TRDOSImage) diskType() byte {
+	switch {
+	case t.Tracks <= 40 && t.Sides == 2:
+		return TRDOS40DS
+	case t.Tracks <= 40:
+		return TRDOS40SS
+	case t.Sides == 2:
+		return TRDOS80DS
+	}
+	return TRDOS80SS
+}
+
+// totalSectors returns the number of sectors on the disk.
+func (t *TRDOSImage) totalSectors() int {
+	return t.Tracks * t.Sides * TRDOSSectorsPerTrack
+}
+
+// LoadTRD reads a .trd image.
+func LoadTRD(r io.Reader) (*TRDOSImage, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TRD image: %w", err)
+	}
+	if len(data) < TRDOSSectorsPerTrack*TRDOSSectorSize {
+		return nil, fmt.Errorf("%w: TRD image too small", ErrCorruptImage)
+	}
+	info := data[trdosInfoSector*TRDOSSectorSize:][:TRDOSSectorSize]
+	if info[0xE7] != trdosID {
+		return nil, fmt.Errorf("%w: no TR-DOS disk information sector", ErrCorruptImage)
+	}
+
+	t := &TRDOSImage{Label: strings.TrimRight(string(info[0xF5:0xFD]), " \x00")}
+	switch info[0xE3] {
+	case TRDOS40DS:
+		t.Tracks, t.Sides = 40, 2
+	case TRDOS40SS:
+		t.Tracks, t.Sides = 40, 1
+	case TRDOS80SS:
+		t.Tracks, t.Sides = 80, 1
+	default:
+		t.Tracks, t.Sides = 80, 2
+	}
+
+	for i := 0; i < TRDOSMaxFiles; i++ {
+		e := data[i*16 : i*16+16]
+		if e[0] == 0x00 {
+			break // end of catalogue
+		}
+		if e[0] == 0x01 {
+			continue // deleted
+		}
+		f := trdosFileFromEntry(e)
+		off := (int(e[15])*TRDOSSectorsPerTrack + int(e[14])) * TRDOSSectorSize
+		size := int(e[13]) * TRDOSSectorSize
+		if off+size > len(data) {
+			return nil, fmt.Errorf("%w: TR-DOS file %q extends past the end of the image", ErrCorruptImage, f.Name)
+		}
+		f.Data = append([]byte(nil), data[off:off+size]...)
+		t.Files = append(t.Files, f)
+	}
+	return t, nil
+}
+
+// trdosFileFromEntry decodes the name, type, start and length of a catalogue
+// (or SCL header) entry.
+func trdosFileFromEntry(e []byte) TRDOSFile {
+	return TRDOSFile{
+		Name:   strings.TrimRight(string(e[0:8]), " \x00"),
+		Type:   e[8],
+		Start:  binary.LittleEndian.Uint16(e[9:]),
+		Length: binary.LittleEndian.Uint16(e[11:]),
+	}
+}
+
+// entry encodes the first 14 bytes of the file's catalogue entry (the SCL
+// header).
+func (f *TRDOSFile) entry() []byte {
+	e := make([]byte, 14)
+	copy(e, fmt.Sprintf("%-8.8s", f.Name))
+	e[8] = f.Type
+	binary.LittleEndian.PutUint16(e[9:], f.Start)
+	binary.LittleEndian.PutUint16(e[11:], f.Length)
+	e[13] = byte(f.sectors())
+	return e
+}
+
+// check reports a file that TR-DOS cannot store.
+func (f *TRDOSFile) check() error {
+	if f.sectors() > 255 {
+		return fmt.Errorf("%w: TR-DOS file %q is larger than 255 sectors", ErrFileTooLarge, f.Name)
+	}
+	return nil
+}
+
+// SaveTRD writes the disk as a .trd image.
+func (t *TRDOSImage) SaveTRD(w io.Writer) error {
+	if len(t.Files) > TRDOSMaxFiles {
+		return fmt.Errorf("%w: TR-DOS holds at most %d files", ErrDirectoryFull, TRDOSMaxFiles)
+	}
+	data := make([]byte, t.totalSectors()*TRDOSSectorSize)
+
+	// Files follow the system track, each starting where the last ended.
+	next := TRDOSSectorsPerTrack
+	for i := range t.Files {
+		f := &t.Files[i]
+		if err := f.check(); err != nil {
+			return err
+		}
+		if (next+f.sectors())*TRDOSSectorSize > len(data) {
+			return fmt.Errorf("%w: %q does not fit on the TR-DOS disk", ErrDiskFull, f.Name)
+		}
+		e := append(f.entry(), byte(next%TRDOSSectorsPerTrack), byte(next/TRDOSSectorsPerTrack))
+		copy(data[i*16:], e)
+		copy(data[next*TRDOSSectorSize:], f.Data)
+		next += f.sectors()
+	}
+
+	info := data[trdosInfoSector*TRDOSSectorSize:][:TRDOSSectorSize]
+	info[0xE1] = byte(next % TRDOSSectorsPerTrack)
+	info[0xE2] = byte(next / TRDOSSectorsPerTrack)
+	info[0xE3] = t.diskType()
+	info[0xE4] = byte(len(t.Files))
+	binary.LittleEndian.PutUint16(info[0xE5:], uint16(t.totalSectors()-next))
+	info[0xE7] = trdosID
+	copy(info[0xEA:0xF3], "         ")
+	copy(info[0xF5:0xFD], fmt.Sprintf("%-8.8s", t.Label))
+
+	_, err := w.Write(data)
+	return err
+}
+
+// LoadSCL reads a .scl archive as an 80-track, double-sided TR-DOS disk.
+func LoadSCL(r io.Reader) (*TRDOSImage, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SCL image: %w", err)
+	}
+	if len(data) < 13 || string(data[:8]) != "SINCLAIR" {
+		return nil, fmt.Errorf("%w: invalid SCL signature", ErrCorruptImage)
+	}
+	var sum uint32
+	for _, b := range data[:len(data)-4] {
+		sum += uint32(b)
+	}
+	if binary.LittleEndian.Uint32(data[len(data)-4:]) != sum {
+		return nil, fmt.Errorf("%w: SCL checksum mismatch", ErrCorruptImage)
+	}
+
+	t := NewTRDOSImage()
+	count := int(data[8])
+	off := 9 + count*14
+	if off > len(data)-4 {
+		return nil, fmt.Errorf("%w: SCL catalogue truncated", ErrCorruptImage)
+	}
+	for i := 0; i < count; i++ {
+		e := data[9+i*14:][:14]
+		f := trdosFileFromEntry(e)
+		size := int(e[13]) * TRDOSSectorSize
+		if off+size > len(data)-4 {
+			return nil, fmt.Errorf("%w: SCL file %q truncated", ErrCorruptImage, f.Name)
+		}
+		f.Data = append([]byte(nil), data[off:off+size]...)
+		off += size
+		t.Files = append(t.Files, f)
+	}
+	return t, nil
+}
+
+// SaveSCL writes the files as a .scl archive.
+func (t *TRDOSImage) SaveSCL(w io.Writer) error {
+	if len(t.Files) > 255 {
+		return fmt.Errorf("%w: an SCL archive holds at most 255 files", ErrDirectoryFull)
+	}
+	var buf bytes.Buffer
+	buf.WriteString("SINCLAIR")
+	buf.WriteByte(byte(len(t.Files)))
+	for i := range t.Files {
+		if err := t.Files[i].check(); err != nil {
+			return err
+		}
+		buf.Write(t.Files[i].entry())
+	}
+	for i := range t.Files {
+		f := &t.Files[i]
+		buf.Write(f.Data)
+		buf.Write(make([]byte, f.sectors()*TRDOSSectorSize-len(f.Data)))
+	}
+	var sum uint32
+	for _, b := range buf.Bytes() {
+		sum += uint32(b)
+	}
+	binary.Write(&buf, binary.LittleEndian, sum)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ImportTRDOS copies the files of a TR-DOS disk onto the +3 disk, mapping
+// their headers: BASIC programs (with their autostart LINE) and code become
+// headered +3DOS files named NAME.BAS and NAME.BIN; other types are copied
+// without a header as NAME.DAT. TR-DOS names are case-sensitive, so several
+// may map to one +3DOS name; as with ImportTape, a name already on the disk or
+// used twice gets a number in place of its last characters.
+func (di *DiskImage) ImportTRDOS(t *TRDOSImage) error {
+	used := make(map[string]bool)
+	for i := range t.Files {
+		if _, err := di.importTRDOSFile(&t.Files[i], used); err != nil {
+			return err
+		}
+	}
+	return di.FlushDirectory()
+}
+
+// Plus3Name returns the name ImportTRDOS gives the file unless it is taken:
+// NAME.BAS for BASIC, NAME.BIN for code and NAME.DAT for anything else.
+func (f *TRDOSFile) Plus3Name() string {
+	return plus3Name(f.Name) + "." + f.plus3Ext()
+}
+
+// plus3Ext returns the +3DOS extension of the file's type.
+func (f *TRDOSFile) plus3Ext() string {
+	switch f.Type {
+	case 'B':
+		return "BAS"
+	case 'C':
+		return "BIN"
+	}
+	return "DAT"
+}
+
+// importTRDOSFile writes one TR-DOS file as ImportTRDOS does, without
+// flushing the directory, and returns its +3DOS name, which is added to used.
+func (di *DiskImage) importTRDOSFile(f *TRDOSFile, used map[string]bool) (string, error) {
+	data := f.Contents()
+	header := NewPlus3DosHeader()
+	var err error
+	switch f.Type {
+	case 'B':
+		line, ok := f.AutostartLine()
+		if !ok {
+			line = 0x8000 // no autostart
+		}
+		err = header.SetBasicHeader(FileTypeProgram, uint16(len(data)), line, f.Length)
+	case 'C':
+		err = header.SetBasicHeader(FileTypeCode, uint16(len(data)), f.Start, 0)
+	default:
+		header = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if header != nil {
+		header.FileLength = uint32(HeaderSize) + uint32(len(data))
+		header.UpdateChecksum()
+	}
+	name, err := di.writeTapeFile(plus3Name(f.Name), f.plus3Ext(), header, data, used)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", f.Name, err)
+	}
+	return name, nil
+}
+
+// plus3Name makes a TR-DOS, Opus or tape name usable as a CP/M file name: upper
+// case, at most eight characters, with characters CP/M reserves replaced by
+// underscores.
+func plus3Name(name string) string {
+	name = NormaliseFilename(name)
+	if name == "" {
+		return "FILE"
+	}
+	return cpmChars(name, 8)
+}
+
+// cpmChars returns the first n characters of s with those CP/M reserves
+// replaced by underscores.
+func cpmChars(s string, n int) string {
+	s = strings.Map(func(r rune) rune {
+		if notCPM(r) {
+			return '_'
+		}
+		return r
+	}, s)
+	return s[:min(len(s), n)]
+}
+
+// ExportTRDOS returns the +3 disk's files as a TR-DOS disk, mapping their
+// headers: BASIC programs become 'B' files with their autostart LINE, code
+// and headerless files 'C' files (headerless ones load at 0) and arrays 'D'
+// files. Names keep the first eight characters of the CP/M name.
+func (di *DiskImage) ExportTRDOS() (*TRDOSImage, error) {
+	t := NewTRDOSImage()
+	for e := range di.Files(&FilesOptions{System: true}) {
+		f, err := di.trdosFile(&e)
+		if err != nil {
+			return nil, err
+		}
+		t.Files = append(t.Files, f)
+	}
+	return t, nil
+}
+
+// trdosFile returns a file on the +3 disk as ExportTRDOS maps it.
+func (di *DiskImage) trdosFile(e *DirectoryEntry) (TRDOSFile, error) {
+	src, err := di.OpenFile(e.GetFilename(), os.O_RDONLY)
+	if err != nil {
+		return TRDOSFile{}, err
+	}
+	defer src.Close()
+	if src.isHeadered {
+		if _, err := src.Seek(HeaderSize, io.SeekStart); err != nil {
+			return TRDOSFile{}, err
+		}
+	}
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return TRDOSFile{}, fmt.Errorf("%s: %w", e.GetFilename(), err)
+	}
+
+	f := TRDOSFile{Name: strings.TrimRight(string(e.Name[:]), " "), Type: 'C', Length: uint16(len(data))}
+	if src.isHeadered {
+		fileType, length, param1, param2 := src.header.GetBasicHeader()
+		data = data[:min(int(length), len(data))]
+		switch fileType {
+		case FileTypeProgram:
+			f.Type, f.Start, f.Length = 'B', uint16(len(data)), param2
+			if param1 < 0x8000 {
+				data = append(data, 0x80, 0xAA, byte(param1), byte(param1>>8))
+			}
+		case FileTypeCode:
+			f.Start, f.Length = param1, uint16(len(data))
+		default:
+			f.Type, f.Length = 'D', uint16(len(data))
+		}
+	}
+	if len(data) > 0xFFFF {
+		return TRDOSFile{}, fmt.Errorf("%w: %s is too large for TR-DOS", ErrFileTooLarge, e.GetFilename())
+	}
+	f.Data = append(data, make([]byte, -len(data)&(TRDOSSectorSize-1))...)
+	return f, nil
+}
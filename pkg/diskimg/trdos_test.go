@@ -0,0 +1,131 @@
+package diskimg
+
+import (
+	"bytes"
+	"testing"
+)
+
+// sampleTRDOS returns a TR-DOS disk with a BASIC program (autostart LINE 10)
+// and a code file.
+func sampleTRDOS() *TRDOSImage {
+	prog := []byte{0x00, 0x0A, 0x05, 0x00, 0xF5, 0x22, 0x41, 0x22, 0x0D}
+	basic := append(append([]byte(nil), prog...), 0x80, 0xAA, 10, 0)
+	code := bytes.Repeat([]byte{0xC9, 0x01}, 300)
+
+	t := NewTRDOSImage()
+	t.Label = "SAMPLE"
+	t.Files = []TRDOSFile{
+		{Name: "boot", Type: 'B', Start: uint16(len(prog)), Length: uint16(len(prog)), Data: pad256(basic)},
+		{Name: "game", Type: 'C', Start: 0x8000, Length: uint16(len(code)), Data: pad256(code)},
+	}
+	return t
+}
+
+func pad256(p []byte) []byte {
+	return append(p, make([]byte, -len(p)&255)...)
+}
+
+func TestTRDAndSCLRoundTrip(t *testing.T) {
+	orig := sampleTRDOS()
+	var trd, scl bytes.Buffer
+	if err := orig.SaveTRD(&trd); err != nil {
+		t.Fatalf("SaveTRD: %v", err)
+	}
+	if trd.Len() != 80*2*16*256 {
+		t.Errorf("TRD size = %d, want 655360", trd.Len())
+	}
+	if err := orig.SaveSCL(&scl); err != nil {
+		t.Fatalf("SaveSCL: %v", err)
+	}
+
+	fromTRD, err := LoadTRD(&trd)
+	if err != nil {
+		t.Fatalf("LoadTRD: %v", err)
+	}
+	fromSCL, err := LoadSCL(&scl)
+	if err != nil {
+		t.Fatalf("LoadSCL: %v", err)
+	}
+	if fromTRD.Label != "SAMPLE" {
+		t.Errorf("label = %q, want SAMPLE", fromTRD.Label)
+	}
+	for _, got := range []*TRDOSImage{fromTRD, fromSCL} {
+		if len(got.Files) != len(orig.Files) {
+			t.Fatalf("%d files, want %d", len(got.Files), len(orig.Files))
+		}
+		for i, f := range got.Files {
+			want := orig.Files[i]
+			if f.Name != want.Name || f.Type != want.Type || f.Start != want.Start ||
+				f.Length != want.Length || !bytes.Equal(f.Data, want.Data) {
+				t.Errorf("file %d = %s %c %d %d, want %s %c %d %d",
+					i, f.Name, f.Type, f.Start, f.Length, want.Name, want.Type, want.Start, want.Length)
+			}
+		}
+	}
+}
+
+// Files copied to a +3 disk get headers mapped from their TR-DOS type, and
+// map back to the same TR-DOS files.
+func TestTRDOSPlus3Conversion(t *testing.T) {
+	orig := sampleTRDOS()
+	di := NewDiskImage()
+	if err := di.ImportTRDOS(orig); err != nil {
+		t.Fatalf("ImportTRDOS: %v", err)
+	}
+
+	header, err := di.ReadHeader("BOOT.BAS")
+	if err != nil {
+		t.Fatalf("ReadHeader: %v", err)
+	}
+	if fileType, _, line, _ := header.GetBasicHeader(); fileType != FileTypeProgram || line != 10 {
+		t.Errorf("BOOT.BAS: type %d LINE %d, want a program with LINE 10", fileType, line)
+	}
+	header, err = di.ReadHeader("GAME.BIN")
+	if err != nil {
+		t.Fatalf("ReadHeader: %v", err)
+	}
+	if fileType, length, addr, _ := header.GetBasicHeader(); fileType != FileTypeCode || addr != 0x8000 || length != 600 {
+		t.Errorf("GAME.BIN: type %d, %d bytes at %d, want 600 bytes of code at 32768", fileType, length, addr)
+	}
+
+	back, err := di.ExportTRDOS()
+	if err != nil {
+		t.Fatalf("ExportTRDOS: %v", err)
+	}
+	if len(back.Files) != 2 {
+		t.Fatalf("%d files, want 2", len(back.Files))
+	}
+	for i, f := range back.Files {
+		want := orig.Files[i]
+		if f.Type != want.Type || f.Start != want.Start || f.Length != want.Length ||
+			!bytes.Equal(f.Contents(), want.Contents()) {
+			t.Errorf("file %d = %c %d %d, want %c %d %d", i, f.Type, f.Start, f.Length, want.Type, want.Start, want.Length)
+		}
+	}
+	if line, ok := back.Files[0].AutostartLine(); !ok || line != 10 {
+		t.Errorf("autostart = %d, %v; want LINE 10", line, ok)
+	}
+}
+
+// TR-DOS names that map to the same +3DOS name are numbered, so no file is
+// lost.
+func TestImportTRDOSNameClash(t *testing.T) {
+	src := NewTRDOSImage()
+	for i, name := range []string{"game", "GAME", "a.b", "a_b"} {
+		src.Files = append(src.Files, TRDOSFile{Name: name, Type: 'C', Start: 0x8000, Length: 1, Data: pad256([]byte{byte(i)})})
+	}
+	di := NewDiskImage()
+	if err := di.ImportTRDOS(src); err != nil {
+		t.Fatalf("ImportTRDOS: %v", err)
+	}
+	for i, name := range []string{"GAME.BIN", "GAME2.BIN", "A_B.BIN", "A_B2.BIN"} {
+		f, err := di.ExportHobeta(name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if data := f.Contents(); !bytes.Equal(data, []byte{byte(i)}) {
+			t.Errorf("%s holds % x, want %02x", name, data, i)
+		}
+	}
+}
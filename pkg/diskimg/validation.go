@@ -4,7 +4,6 @@ package diskimg
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
 )
 
@@ -18,6 +17,9 @@ func (e *ValidationError) Error() string {
 	return fmt.Sprintf("validation error - %s: %s", e.Field, e.Message)
 }
 
+// Unwrap makes every ValidationError match ErrCheckFailed.
+func (e *ValidationError) Unwrap() error { return ErrCheckFailed }
+
 // ValidateFormat performs comprehensive validation of the disk image format
 func (di *DiskImage) ValidateFormat() error {
 	// Validate header
@@ -77,7 +79,7 @@ func (di *DiskImage) validateHeaderFormat() error {
 
 // validateTrackData verifies all track data structures
 func (di *DiskImage) validateTrackData() error {
-	expectedTracks := int(di.Header.TracksNum * di.Header.SidesNum)
+	expectedTracks := int(di.Header.TracksNum) * int(di.Header.SidesNum)
 
 	// Check track array size
 	if len(di.Tracks) != expectedTracks {
@@ -88,33 +90,52 @@ func (di *DiskImage) validateTrackData() error {
 	}
 
 	// Verify each track's data
+	if err := di.LoadTracks(); err != nil {
+		return err
+	}
+	var done, total int64
+	for _, track := range di.Tracks {
+		total += int64(len(track))
+	}
 	for i, track := range di.Tracks {
-		trackNum := i % int(di.Header.TracksNum)
-		side := i / int(di.Header.TracksNum)
+		trackNum := i / int(di.Header.SidesNum)
+		side := i % int(di.Header.SidesNum)
 
-		// Check track size
-		if len(track) != int(di.Header.TrackSize) {
-			return &ValidationError{
-				Field:   fmt.Sprintf("Track[%d]", i),
-				Message: fmt.Sprintf("invalid track size: expected %d, got %d", di.Header.TrackSize, len(track)),
-			}
+		if track == nil {
+			continue // absent (unformatted) track
 		}
 
-		// Verify track can be properly sectored
-		if len(track)%BytesPerSector != 0 {
+		// Check track size (a standard container has one size for every track)
+		if di.Header.TrackSize != 0 && len(track) != int(di.Header.TrackSize) {
 			return &ValidationError{
 				Field:   fmt.Sprintf("Track[%d]", i),
-				Message: "track size is not a multiple of sector size",
+				Message: fmt.Sprintf("invalid track size: expected %d, got %d", di.Header.TrackSize, len(track)),
 			}
 		}
 
 		// Check track information
-		if _, err := di.GetTrackInfo(trackNum, side); err != nil {
+		ti, err := di.GetTrackInfo(trackNum, side)
+		if err == nil {
+			err = ti.Validate()
+		}
+		if err != nil {
 			return &ValidationError{
 				Field:   fmt.Sprintf("Track[%d]Info", i),
 				Message: err.Error(),
 			}
 		}
+
+		// Verify the sectors, whatever their sizes, fit in the track
+		if n := len(ti.SectorInfo); n > 0 {
+			if off, size := ti.sectorOffset(n - 1); off+size > len(track) {
+				return &ValidationError{
+					Field:   fmt.Sprintf("Track[%d]", i),
+					Message: fmt.Sprintf("sector data (%d bytes) exceeds track size %d", off+size, len(track)),
+				}
+			}
+		}
+		done += int64(len(track))
+		di.report(StageValidate, "", done, total)
 	}
 
 	return nil
@@ -123,23 +144,23 @@ func (di *DiskImage) validateTrackData() error {
 // validateDiskParameters checks if disk parameters match +3DOS requirements
 func (di *DiskImage) validateDiskParameters() error {
 	// Validate parameters against +3DOS standard format
-	if di.Header.TracksNum != TracksPerSide {
+	if int(di.Header.TracksNum) != di.spec.TracksPerSide {
 		return &ValidationError{
 			Field: "DiskParameters.TracksNum",
-			Message: fmt.Sprintf("invalid number of tracks for +3DOS format: expected %d, got %d",
-				TracksPerSide, di.Header.TracksNum),
+			Message: fmt.Sprintf("invalid number of tracks for %s format: expected %d, got %d",
+				di.spec.Name, di.spec.TracksPerSide, di.Header.TracksNum),
 		}
 	}
 
-	if di.Header.SidesNum != SidesPerDisk {
+	if int(di.Header.SidesNum) != di.spec.Sides {
 		return &ValidationError{
 			Field: "DiskParameters.SidesNum",
-			Message: fmt.Sprintf("invalid number of sides for +3DOS format: expected %d, got %d",
-				SidesPerDisk, di.Header.SidesNum),
+			Message: fmt.Sprintf("invalid number of sides for %s format: expected %d, got %d",
+				di.spec.Name, di.spec.Sides, di.Header.SidesNum),
 		}
 	}
 
-	expectedTrackSize := 256 + BytesPerSector*SectorsPerTrack
+	expectedTrackSize := di.spec.TrackSize()
 	if int(di.Header.TrackSize) != expectedTrackSize {
 		return &ValidationError{
 			Field: "DiskParameters.TrackSize",
@@ -163,15 +184,16 @@ func (di *DiskImage) ValidateBootSector() error {
 	// Get the boot sector (Track 0, Side 0, Sector 1)
 	bootSector, err := di.GetSectorData(0, 0, 0)
 	if err != nil {
-		return errors.New("failed to read boot sector")
+		return fmt.Errorf("failed to read boot sector: %w", err)
 	}
 
 	// Basic boot sector validation (minimal check)
 	if len(bootSector) != BytesPerSector {
-		return errors.New("invalid boot sector size")
+		return fmt.Errorf("%w: boot sector size %d", ErrInvalidSectorSize, len(bootSector))
 	}
 
-	// Calculate checksum (byte 15 should make sum of all bytes = 3 mod 256)
+	// Calculate checksum (byte 15 should make sum of all bytes = 3 mod 256,
+	// or the PCW checksum on a PCW disk)
 	var sum byte
 	for _, b := range bootSector[:15] {
 		sum += b
@@ -180,8 +202,8 @@ func (di *DiskImage) ValidateBootSector() error {
 		sum += b
 	}
 
-	if sum+bootSector[15] != 3 {
-		return errors.New("invalid boot sector checksum")
+	if sum+bootSector[15] != di.spec.BootChecksum() {
+		return fmt.Errorf("%w: boot sector", ErrInvalidChecksum)
 	}
 
 	return nil
@@ -0,0 +1,139 @@
+// file: pkg/diskimg/wav.go
+
+package diskimg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/ha1tch/zentools/pkg/tap"
+)
+
+// The 48K ROM's SAVE timings, in T-states of its 3.5MHz clock: a pilot tone
+// (longer before a header block than a data block), two sync pulses, then
+// each bit as two pulses, shorter for a 0 than a 1.
+const (
+	romClock      = 3500000
+	pilotPulse    = 2168
+	pilotHeader   = 8063 // pulses
+	pilotData     = 3223 // pulses
+	sync1Pulse    = 667
+	sync2Pulse    = 735
+	zeroPulse     = 855
+	onePulse      = 1710
+	blockPauseMs  = 1000
+	wavSampleRate = 44100
+)
+
+// WAVOptions configures SaveWAV.
+type WAVOptions struct {
+	SampleRate int // samples per second; 0 means 44100
+}
+
+// pulseWriter renders pulses as a square wave of 8-bit samples, the level
+// changing at the end of each pulse.
+type pulseWriter struct {
+	rate    int64
+	tstates int64 // since the start of the tape
+	samples []byte
+	high    bool
+}
+
+// hold keeps the current level for t T-states.
+func (p *pulseWriter) hold(t int) {
+	p.tstates += int64(t)
+	level := byte(0x40)
+	if p.high {
+		level = 0xC0
+	}
+	for end := p.tstates * p.rate / romClock; int64(len(p.samples)) < end; {
+		p.samples = append(p.samples, level)
+	}
+}
+
+// pulse adds a pulse of t T-states and flips the level.
+func (p *pulseWriter) pulse(t int) {
+	p.hold(t)
+	p.high = !p.high
+}
+
+// block adds a TAP block, flag byte to checksum, as the ROM saves it, and the
+// pause after it.
+func (p *pulseWriter) block(b []byte) {
+	pilot := pilotData
+	if b[0] < 0x80 {
+		pilot = pilotHeader
+	}
+	for range pilot {
+		p.pulse(pilotPulse)
+	}
+	p.pulse(sync1Pulse)
+	p.pulse(sync2Pulse)
+	for _, c := range b {
+		for bit := 0x80; bit != 0; bit >>= 1 {
+			t := zeroPulse
+			if int(c)&bit != 0 {
+				t = onePulse
+			}
+			p.pulse(t)
+			p.pulse(t)
+		}
+	}
+	p.high = false
+	p.hold(blockPauseMs * (romClock / 1000))
+}
+
+// SaveWAV writes files as the audio of a tape saved by the ROM, a mono 8-bit
+// WAV file that loads through the ear socket of a real 48K or 128K Spectrum:
+// each file a header block and a data block, with a second's pause after
+// each.
+func SaveWAV(w io.Writer, files []TapeFile, opts *WAVOptions) error {
+	image, err := encodeTAP(files)
+	if err != nil {
+		return err
+	}
+	return TAPToWAV(w, image, opts)
+}
+
+// TAPToWAV writes a TAP image as the audio of SaveWAV, block for block. TAP
+// parsing is delegated to zentools/pkg/tap.
+func TAPToWAV(w io.Writer, image []byte, opts *WAVOptions) error {
+	blocks, err := tap.Decode(image)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptImage, err)
+	}
+	rate := wavSampleRate
+	if opts != nil && opts.SampleRate > 0 {
+		rate = opts.SampleRate
+	}
+	p := &pulseWriter{rate: int64(rate)}
+	for _, b := range blocks {
+		raw := append([]byte{b.Flag}, b.Data...)
+		p.block(append(raw, b.Checksum))
+	}
+	return writeWAV(w, p.samples, rate)
+}
+
+// writeWAV writes samples, 8-bit mono PCM, as a RIFF WAVE file.
+func writeWAV(w io.Writer, samples []byte, rate int) error {
+	le := binary.LittleEndian
+	h := make([]byte, 44)
+	copy(h[0:], "RIFF")
+	le.PutUint32(h[4:], uint32(36+len(samples)))
+	copy(h[8:], "WAVEfmt ")
+	le.PutUint32(h[16:], 16)           // fmt chunk size
+	le.PutUint16(h[20:], 1)            // PCM
+	le.PutUint16(h[22:], 1)            // mono
+	le.PutUint32(h[24:], uint32(rate)) // samples per second
+	le.PutUint32(h[28:], uint32(rate)) // bytes per second
+	le.PutUint16(h[32:], 1)            // bytes per sample
+	le.PutUint16(h[34:], 8)            // bits per sample
+	copy(h[36:], "data")
+	le.PutUint32(h[40:], uint32(len(samples)))
+	if _, err := w.Write(h); err != nil {
+		return err
+	}
+	_, err := w.Write(samples)
+	return err
+}
@@ -0,0 +1,57 @@
+package diskimg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestSaveWAV(t *testing.T) {
+	files := []TapeFile{{Name: "GAME", Type: FileTypeCode, Param1: 32768, Param2: 0x8000, Data: []byte{0x00, 0xFF}}}
+	var buf bytes.Buffer
+	if err := SaveWAV(&buf, files, &WAVOptions{SampleRate: 22050}); err != nil {
+		t.Fatal(err)
+	}
+	wav := buf.Bytes()
+	le := binary.LittleEndian
+	if string(wav[0:4]) != "RIFF" || string(wav[8:16]) != "WAVEfmt " || string(wav[36:40]) != "data" {
+		t.Fatalf("not a WAV file: %q", wav[:44])
+	}
+	if rate := le.Uint32(wav[24:]); rate != 22050 {
+		t.Errorf("sample rate %d, want 22050", rate)
+	}
+	samples := wav[44:]
+	if n := le.Uint32(wav[40:]); int(n) != len(samples) || le.Uint32(wav[4:]) != n+36 {
+		t.Errorf("data size %d, RIFF size %d, for %d samples", n, le.Uint32(wav[4:]), len(samples))
+	}
+
+	// A header block of 19 bytes and a data block of 4, each a pilot, two
+	// sync pulses, two pulses a bit and a second's pause.
+	tstates := 0
+	for _, b := range [][]byte{make([]byte, 19), {0xFF, 0x00, 0xFF, 0x00}} {
+		tstates += sync1Pulse + sync2Pulse + blockPauseMs*(romClock/1000)
+		if b[0] == 0 {
+			tstates += pilotHeader * pilotPulse
+		} else {
+			tstates += pilotData * pilotPulse
+		}
+		for range b {
+			tstates += 16 * zeroPulse // at least
+		}
+	}
+	if want := tstates * 22050 / romClock; len(samples) < want || len(samples) > want+22050 {
+		t.Errorf("%d samples, want about %d", len(samples), want)
+	}
+
+	// The wave starts low and alternates with each pulse.
+	pilot := pilotPulse * 22050 / romClock
+	if samples[0] != 0x40 || samples[pilot+1] != 0xC0 || samples[len(samples)-1] != 0x40 {
+		t.Errorf("levels %#02x %#02x %#02x", samples[0], samples[pilot+1], samples[len(samples)-1])
+	}
+}
+
+func TestTAPToWAVRejectsTruncated(t *testing.T) {
+	if err := TAPToWAV(&bytes.Buffer{}, []byte{0x13, 0x00, 0x00}, nil); err == nil {
+		t.Error("truncated TAP block accepted")
+	}
+}
@@ -3,72 +3,299 @@
 package diskimg
 
 import (
-	"errors"
+	"bytes"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 )
 
-// SaveToFile writes the disk image to a file.
+// SaveOptions configures SaveToFileWithOptions and WriteFileAtomic.
+type SaveOptions struct {
+	// Backup keeps the file being replaced as filename.bak, replacing any
+	// older backup.
+	Backup bool
+
+	// InPlace has SaveToFileWithOptions write only the tracks changed since
+	// the disk was loaded from or last saved to filename, into the file as it
+	// is, which is much faster for a small change to a large image. It is not
+	// atomic: a crash part way leaves some tracks written and others not. The
+	// whole file is replaced as usual if it has changed meanwhile, if the
+	// image's layout has changed (a track resized, added or removed), or with
+	// Backup.
+	InPlace bool
+}
+
+// SaveToFile writes the disk image to a file, replacing it atomically (see
+// WriteFileAtomic).
 func (di *DiskImage) SaveToFile(filename string) error {
-	f, err := os.Create(filename)
+	return di.SaveToFileWithOptions(filename, nil)
+}
+
+// SaveToFileWithOptions is SaveToFile with options.
+func (di *DiskImage) SaveToFileWithOptions(filename string, opts *SaveOptions) error {
+	if opts != nil && opts.InPlace && !opts.Backup {
+		if done, err := di.saveInPlace(filename); done || err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	if err := di.Save(&buf); err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(filename, buf.Bytes(), opts); err != nil {
+		return err
+	}
+	logger.Debug("wrote disk image file", "path", filename, "bytes", buf.Len())
+	di.setOrigin(filename)
+	return nil
+}
+
+// WriteFileAtomic writes data to filename without ever leaving it half
+// written: data goes to a temporary file in the same directory, which is
+// synced and then renamed over filename. If anything fails, filename is left
+// as it was. A new file gets mode 0644; a replaced one keeps its mode.
+func WriteFileAtomic(filename string, data []byte, opts *SaveOptions) (err error) {
+	mode := os.FileMode(0644)
+	info, statErr := os.Stat(filename)
+	if statErr == nil {
+		mode = info.Mode().Perm()
+	}
+
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, "."+base+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(mode); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if opts != nil && opts.Backup && statErr == nil {
+		// A hard link keeps the old contents under the backup name while the
+		// rename below replaces filename; copy where links are unsupported.
+		backup := filename + ".bak"
+		if err = os.Remove(backup); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to replace backup: %w", err)
+		}
+		if err = os.Link(filename, backup); err != nil {
+			logger.Debug("copying backup instead of linking it", "path", backup, "err", err)
+			if err = copyFile(filename, backup, mode); err != nil {
+				return fmt.Errorf("failed to write backup: %w", err)
+			}
+		}
+	}
+	if err = os.Rename(tmp.Name(), filename); err != nil {
+		return err
+	}
+	// Make the rename itself durable; not every system can sync a directory.
+	if d, derr := os.Open(dir); derr == nil {
+		if err := d.Sync(); err != nil {
+			logger.Debug("cannot sync directory", "dir", dir, "err", err)
+		}
+		d.Close()
+	}
+	return nil
+}
+
+// copyFile copies the file src to dst.
+func copyFile(src, dst string, mode os.FileMode) error {
+	data, err := os.ReadFile(src)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	return di.Save(f)
+	return os.WriteFile(dst, data, mode)
 }
 
-// Save writes the disk image as a standard ("MV - CPC") DSK.
+// Container identifies the .dsk file container variant.
+type Container int
+
+const (
+	ContainerStandard Container = iota // "MV - CPCEMU Disk-File": one size for every track
+	ContainerExtended                  // "EXTENDED CPC DSK File": per-track size table
+)
+
+// String returns the conventional name of the container variant.
+func (c Container) String() string {
+	if c == ContainerExtended {
+		return "extended"
+	}
+	return "standard"
+}
+
+// signature returns the disc information block signature of the variant.
+func (c Container) signature() string {
+	if c == ContainerExtended {
+		return "EXTENDED CPC DSK File\r\nDisk-Info\r\n"
+	}
+	return "MV - CPCEMU Disk-File\r\nDisk-Info\r\n"
+}
+
+// Container reports the container variant the image was loaded from, or the
+// one set by SetContainer. A new image is a standard container.
+func (di *DiskImage) Container() Container {
+	if string(di.Header.Signature[:8]) == "EXTENDED" {
+		return ContainerExtended
+	}
+	return ContainerStandard
+}
+
+// SetContainer selects the container variant that Save writes.
+func (di *DiskImage) SetContainer(c Container) {
+	copy(di.Header.Signature[:], c.signature())
+}
+
+// Save writes the disk image as a DSK in its container variant (see
+// Container), so an image keeps the container it was loaded from.
 //
 // The in-memory model stores each track as a complete block (256-byte track
-// information block followed by sector data). For a uniform-geometry +3 disk
-// every track is the same size, so the standard container is sufficient and
-// simplest; tracks are written verbatim from the stored blocks.
+// information block followed by sector data); tracks are written verbatim from
+// the stored blocks.
 func (di *DiskImage) Save(w io.Writer) error {
+	return di.SaveContainer(w, di.Container())
+}
+
+// SaveContainer writes the disk image in the given container variant.
+//
+// The extended container records the size of every track in a table at offset
+// 0x34, so odd tracks from hardware dumps (extra sectors, short tracks) are
+// written at their own size and absent (unformatted) tracks as size 0. The
+// standard container has a single track size: every track is padded to the
+// largest one, and absent tracks are written as formatted empty tracks.
+func (di *DiskImage) SaveContainer(w io.Writer, c Container) error {
 	// Persist the in-memory directory to the directory sectors before writing.
 	if err := di.FlushDirectory(); err != nil {
 		return err
 	}
+	if err := di.LoadTracks(); err != nil {
+		return err
+	}
+	dib, sizes, err := di.containerLayout(c)
+	if err != nil {
+		return err
+	}
+	total := int64(len(dib))
+	for _, size := range sizes {
+		total += int64(size)
+	}
+	if _, err := w.Write(dib); err != nil {
+		return fmt.Errorf("failed to write disc information block: %w", err)
+	}
+	done := int64(len(dib))
+	di.report(StageSave, "", done, total)
 
+	// Track blocks, verbatim apart from padding to the container size.
+	for i, size := range sizes {
+		if size == 0 {
+			continue
+		}
+		if _, err := w.Write(di.containerTrack(i, size)); err != nil {
+			return fmt.Errorf("failed to write track data: %w", err)
+		}
+		done += int64(size)
+		di.report(StageSave, "", done, total)
+	}
+	logger.Debug("saved disk image", "bytes", total, "container", c)
+	return nil
+}
+
+// containerLayout returns the disc information block of the image in
+// container c and the size of each track block after it, a multiple of 256
+// bytes and 0 for a track left out. A track not read yet by a lazily loaded
+// disk keeps the size it has in its image.
+func (di *DiskImage) containerLayout(c Container) ([]byte, []int, error) {
 	trackCount := int(di.Header.TracksNum) * int(di.Header.SidesNum)
-	trackSize := 256 + SectorsPerTrack*BytesPerSector
+	if trackCount > len(di.Tracks) || trackCount > 256-0x34 {
+		return nil, nil, fmt.Errorf("%w: invalid track count %d", ErrCorruptImage, trackCount)
+	}
+
+	// The size of each track block in the container, a multiple of 256 bytes.
+	sizes := make([]int, trackCount)
+	maxSize := di.spec.TrackSize()
+	for i := range sizes {
+		n := di.trackLen(i)
+		if n < 0 {
+			continue // absent
+		}
+		sizes[i] = (n + 255) &^ 255
+		if sizes[i] > 0xFF00 {
+			return nil, nil, fmt.Errorf("%w: track %d is too large for a DSK container: %d bytes", ErrUnsupported, i, n)
+		}
+		maxSize = max(maxSize, sizes[i])
+	}
+	if c == ContainerStandard {
+		for i := range sizes {
+			sizes[i] = maxSize
+		}
+	}
 
 	// Disc information block (256 bytes).
 	dib := make([]byte, 256)
-	copy(dib[0:], "MV - CPCEMU Disk-File\r\nDisk-Info\r\n")
+	copy(dib[0:], c.signature())
 	creator := di.Header.Creator[:]
 	if len(creator) == 0 || creator[0] == 0 {
 		creator = []byte("plus3")
 	}
 	copy(dib[0x22:0x30], creator)
-	dib[0x30] = byte(trackCount)
+	dib[0x30] = di.Header.TracksNum
 	dib[0x31] = di.Header.SidesNum
-	dib[0x32] = byte(trackSize & 0xFF)
-	dib[0x33] = byte(trackSize >> 8)
-	if _, err := w.Write(dib); err != nil {
-		return errors.New("failed to write disc information block")
-	}
-
-	// Track blocks, verbatim.
-	for i := 0; i < trackCount; i++ {
-		block := di.Tracks[i]
-		if block == nil {
-			// Absent track - emit a formatted empty track.
-			block = make([]byte, trackSize)
-			copy(block[0:], "Track-Info\r\n")
-			for j := 256; j < trackSize; j++ {
-				block[j] = 0xE5
-			}
-		}
-		if len(block) != trackSize {
-			// Normalise to the standard track size.
-			nb := make([]byte, trackSize)
-			copy(nb, block)
-			block = nb
+	if c == ContainerExtended {
+		// Header track size is unused; each track's size/256 goes in the table.
+		for i, size := range sizes {
+			dib[0x34+i] = byte(size / 256)
 		}
-		if _, err := w.Write(block); err != nil {
-			return errors.New("failed to write track data")
+	} else {
+		dib[0x32] = byte(maxSize & 0xFF)
+		dib[0x33] = byte(maxSize >> 8)
+	}
+	return dib, sizes, nil
+}
+
+// trackLen returns the length of the track block at idx, or -1 if the track
+// is absent.
+func (di *DiskImage) trackLen(idx int) int {
+	if l := di.lazy; l != nil && !l.loaded[idx] {
+		if l.sizes[idx] == 0 {
+			return -1
 		}
+		return l.sizes[idx]
 	}
-	return nil
+	if di.Tracks[idx] == nil {
+		return -1
+	}
+	return len(di.Tracks[idx])
+}
+
+// containerTrack returns the track block at idx as written to a container
+// with the given track size: padded, or formatted if the track is absent.
+func (di *DiskImage) containerTrack(idx, size int) []byte {
+	block := di.Tracks[idx]
+	if block == nil {
+		// Absent track in a standard container - emit a formatted one.
+		block = formatTrack(di.spec, idx/int(di.Header.SidesNum), idx%int(di.Header.SidesNum))
+	}
+	if len(block) != size {
+		nb := make([]byte, size)
+		copy(nb, block)
+		block = nb
+	}
+	return block
 }
@@ -0,0 +1,307 @@
+// file: pkg/diskimg/z80loader.go
+
+package diskimg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/ha1tch/zentools/pkg/snapshot"
+)
+
+// A 48K snapshot becomes three files, which the +3 Loader menu option runs:
+//
+//	DISK      a BASIC program that loads NAME.LDR and calls it
+//	NAME.LDR  machine code at 0x5E00 that reads NAME.MEM through +3DOS
+//	NAME.MEM  the snapshot's memory, in the order the loader reads it
+//
+// The loader and its stack live in 0x5E00-0x5FFF and +3DOS needs the system
+// variables and paging routines in 0x5B00-0x5DFF, so the snapshot's bytes for
+// 0x5B00-0x5FFF are read into RAM bank 1. The rest of memory is read in place
+// with a short restore routine (the tail) written just below the snapshot's
+// stack pointer. The loader pages in ROM 3 and jumps to the tail, which copies
+// the held-back bytes into place, locks the paging as on a 48K Spectrum and
+// restores the registers.
+const (
+	snaSize = 49179 // a 48K .sna
+
+	loaderAddr = 0x5E00 // where DISK loads NAME.LDR
+	loaderTop  = 0x6000 // the loader's stack grows down from here
+	holdAddr   = 0x5B00 // 0x5B00-0x5FFF is held back in bank 1
+	holdSize   = loaderTop - holdAddr
+
+	sysBANKM   = 0x5B5C // copy of the last value sent to port 0x7FFD
+	sysBANK678 = 0x5B67 // copy of the last value sent to port 0x1FFD
+
+	dosOpen  = 0x0106
+	dosClose = 0x0109
+	dosRead  = 0x0112
+)
+
+// SnapshotOptions configures ImportSnapshot.
+type SnapshotOptions struct {
+	Name string // base name of the loader and memory files; "SNAP" if empty
+}
+
+// snapSegment is a run of memory the loader reads from NAME.MEM.
+type snapSegment struct {
+	addr uint16
+	page byte // RAM bank at 0xC000 while it is read
+	data []byte
+}
+
+// ImportSnapshot writes a 48K .z80 or .sna snapshot to the disk as a program
+// that restores it: a BASIC program named DISK, which the +3 Loader runs, and
+// the files NAME.LDR and NAME.MEM, replacing any files of those names. A
+// snapshot of exactly 49179 bytes is read as .sna, any other as .z80.
+//
+// Memory is restored in full but for the 83 bytes below the stack pointer,
+// where the restore routine runs; the registers, interrupt mode and border
+// are restored as far as +3 BASIC allows. A snapshot for a 128K Spectrum, or
+// whose stack pointer leaves no room for that routine in RAM outside
+// 0x5B00-0x5FFF, is ErrUnsupported.
+func (di *DiskImage) ImportSnapshot(r io.Reader, opts *SnapshotOptions) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s, err := decodeSnapshot(data)
+	if err != nil {
+		return err
+	}
+	name := "SNAP"
+	if opts != nil && opts.Name != "" {
+		name = plus3Name(opts.Name)
+	}
+	ldr, segments, err := snapshotLoader(s, name+".MEM")
+	if err != nil {
+		return err
+	}
+	var mem []byte
+	for _, seg := range segments {
+		mem = append(mem, seg.data...)
+	}
+	basic, err := TokeniseBasic(fmt.Sprintf(
+		"10 CLEAR %d: LOAD %q CODE %d: RANDOMIZE USR %d: PRINT %q\n",
+		loaderAddr-1, name+".LDR", loaderAddr, loaderAddr, name+".MEM not found"))
+	if err != nil {
+		return err
+	}
+
+	if err := di.ImportData(name+".MEM", mem, &ImportOptions{AddHeader: true, FileType: FileTypeCode, LoadAddr: 0x4000}); err != nil {
+		return err
+	}
+	if err := di.ImportData(name+".LDR", ldr, &ImportOptions{AddHeader: true, FileType: FileTypeCode, LoadAddr: loaderAddr}); err != nil {
+		return err
+	}
+	return di.ImportData("DISK", basic, &ImportOptions{AddHeader: true, FileType: FileTypeProgram, Line: 10})
+}
+
+// decodeSnapshot decodes a 48K .sna or .z80 snapshot.
+func decodeSnapshot(data []byte) (*snapshot.MachineState, error) {
+	var s *snapshot.MachineState
+	var err error
+	if len(data) == snaSize {
+		s, err = snapshot.DecodeSNA(data)
+	} else {
+		s, err = snapshot.DecodeZ80(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	if s.Model.Is128KFamily() {
+		return nil, fmt.Errorf("%w: 128K snapshot", ErrUnsupported)
+	}
+	return s, nil
+}
+
+// snapshotLoader returns the loader code for s, which reads the file named
+// memName, and the memory segments that file holds, in the order they are
+// read.
+func snapshotLoader(s *snapshot.MachineState, memName string) ([]byte, []snapSegment, error) {
+	mem := make([]byte, 0x10000)
+	copy(mem[0x4000:], s.Memory.RAM[5][:])
+	copy(mem[0x8000:], s.Memory.RAM[2][:])
+	copy(mem[0xC000:], s.Memory.RAM[0][:])
+	hold := append([]byte(nil), mem[holdAddr:loaderTop]...)
+
+	sp := int(s.CPU.SP)
+	if sp == 0 {
+		sp = 0x10000
+	}
+	n := len(snapshotTail(s, 0, 0))
+	t := sp - n
+	if t < 0x4000 || (t < loaderTop && sp > holdAddr) {
+		return nil, nil, fmt.Errorf("%w: stack pointer %#04x leaves no room to restore the snapshot", ErrUnsupported, s.CPU.SP)
+	}
+	// The bank 1 copy of any part of the tail above 0xC000 must miss the
+	// held-back bytes.
+	buf := 0xC000
+	if t+n > 0xC000 && t < 0xC000+holdSize {
+		buf = 0xE000
+	}
+	copy(mem[t:], snapshotTail(s, uint16(t), uint16(buf)))
+
+	segments := []snapSegment{
+		{0x4000, 0, mem[0x4000:holdAddr]},
+		{loaderTop, 0, mem[loaderTop:0xC000]},
+		{0xC000, 0, mem[0xC000:]},
+		{uint16(buf), 1, hold},
+	}
+	if t+n > 0xC000 {
+		lo := max(t, 0xC000)
+		segments = append(segments, snapSegment{uint16(lo), 1, mem[lo : t+n]})
+	}
+	return snapshotLoaderCode(segments, memName, uint16(t)), segments, nil
+}
+
+// snapshotLoaderCode assembles the loader at loaderAddr. It pages in +3DOS as
+// the +3 manual describes, opens memName and reads segments from it. If the
+// file cannot be opened it returns to BASIC; once memory has been overwritten
+// a failed read resets the machine. Then it pages in ROM 3 and jumps to the
+// tail at tail.
+func snapshotLoaderCode(segments []snapSegment, memName string, tail uint16) []byte {
+	a := &z80asm{org: loaderAddr}
+	a.op(0xF3)                         // di
+	saveSP := a.op16(0xED73, 0)        // ld (saveSP),sp
+	a.op16(0x31, loaderTop)            // ld sp,loaderTop
+	a.op(0xD9, 0xE5, 0xD9)             // exx: push hl: exx (BASIC needs HL')
+	a.op16(0x3A, sysBANKM)             // ld a,(BANKM)
+	a.op(0xF5)                         // push af
+	a.op(0xE6, 0xE8, 0xF6, 0x07)       // and 0xE8: or 7 (RAM 7, ROM bit clear)
+	a.op16(0x01, 0x7FFD)               // ld bc,0x7FFD
+	a.op16(0x32, sysBANKM)             // ld (BANKM),a
+	a.op(0xED, 0x79)                   // out (c),a
+	a.op16(0x3A, sysBANK678)           // ld a,(BANK678)
+	a.op(0xF5)                         // push af
+	a.op(0xF6, 0x04, 0x06, 0x1F)       // or 4 (+3DOS ROM): ld b,0x1F
+	a.op16(0x32, sysBANK678)           // ld (BANK678),a
+	a.op(0xED, 0x79)                   // out (c),a
+	a.op(0xFB)                         // ei
+	a.op16(0x01, 0x0001)               // ld bc: file 0, exclusive read
+	a.op16(0x11, 0x0001)               // ld de: no create, skip the header
+	name := a.op16(0x21, 0)            // ld hl,name
+	a.op16(0xCD, dosOpen)              // call DOS_OPEN
+	opened := a.op(0x38, 0)            // jr c,opened
+	a.op(0xF3, 0xF1)                   // di: pop af
+	a.op16(0x01, 0x1FFD)               // ld bc,0x1FFD
+	a.op16(0x32, sysBANK678)           // ld (BANK678),a
+	a.op(0xED, 0x79, 0xF1, 0x06, 0x7F) // out (c),a: pop af: ld b,0x7F
+	a.op16(0x32, sysBANKM)             // ld (BANKM),a
+	a.op(0xED, 0x79)                   // out (c),a
+	a.op(0xD9, 0xE1, 0xD9)             // exx: pop hl: exx
+	restoreSP := a.op16(0xED7B, 0)     // ld sp,(saveSP)
+	a.op(0xFD, 0x21, 0x3A, 0x5C)       // ld iy,0x5C3A
+	a.op(0xFB, 0xC9)                   // ei: ret
+
+	a.patchJR(opened)
+	var fails []int
+	for _, seg := range segments {
+		a.op16(0x01, uint16(seg.page))         // ld bc: file 0, page
+		a.op16(0x11, uint16(len(seg.data)))    // ld de,length
+		a.op16(0x21, seg.addr)                 // ld hl,address
+		a.op16(0xCD, dosRead)                  // call DOS_READ
+		fails = append(fails, a.op16(0xD2, 0)) // jp nc,fail
+	}
+	a.op(0x06, 0x00)             // ld b,0
+	a.op16(0xCD, dosClose)       // call DOS_CLOSE
+	a.op(0xF3)                   // di
+	a.op16(0x01, 0x1FFD)         // ld bc,0x1FFD
+	a.op(0x3E, 0x04, 0xED, 0x79) // ld a,4: out (c),a
+	a.op(0x06, 0x7F, 0x3E, 0x10) // ld b,0x7F: ld a,0x10 (ROM 3, RAM 0)
+	a.op(0xED, 0x79)             // out (c),a
+	a.op16(0xC3, tail)           // jp tail
+
+	for _, at := range fails {
+		a.patch(at)
+	}
+	a.op(0xF3, 0xAF)                               // di: xor a
+	a.op16(0x01, 0x1FFD)                           // ld bc,0x1FFD
+	a.op(0xED, 0x79, 0x06, 0x7F, 0xED, 0x79, 0xC7) // ROM 0: rst 0
+
+	a.patch(saveSP)
+	a.patch(restoreSP)
+	a.op(0, 0) // saveSP
+	a.patch(name)
+	a.op([]byte(memName)...)
+	a.op(0xFF)
+	return a.code
+}
+
+// snapshotTail assembles the routine, placed at t, that finishes restoring s:
+// it copies the held-back bytes from buf in bank 1 into place, locks the
+// paging and restores the registers. The registers it pops follow the code.
+func snapshotTail(s *snapshot.MachineState, t, buf uint16) []byte {
+	cpu := s.CPU
+	a := &z80asm{org: t}
+	a.op(0x3E, 0x11)                                // ld a,0x11 (RAM 1, ROM 3)
+	a.op16(0x01, 0x7FFD)                            // ld bc,0x7FFD
+	a.op(0xED, 0x79)                                // out (c),a
+	a.op16(0x21, buf)                               // ld hl,buf
+	a.op16(0x11, holdAddr)                          // ld de,holdAddr
+	a.op16(0x01, holdSize)                          // ld bc,holdSize
+	a.op(0xED, 0xB0)                                // ldir
+	a.op(0x3E, 0x30)                                // ld a,0x30 (RAM 0, ROM 3, locked)
+	a.op16(0x01, 0x7FFD)                            // ld bc,0x7FFD
+	a.op(0xED, 0x79)                                // out (c),a
+	a.op(0x3E, cpu.I, 0xED, 0x47)                   // ld i,n
+	a.op(0xED, [3]byte{0x46, 0x56, 0x5E}[cpu.IM%3]) // im n
+	a.op(0x3E, s.IO.Border&7, 0xD3, 0xFE)           // out (0xFE),border
+	regs := a.op16(0x31, 0)                         // ld sp,regs
+	a.op(0xC1, 0xD1, 0xE1, 0xD9)                    // pop bc': pop de': pop hl': exx
+	a.op(0xF1, 0x08)                                // pop af': ex af,af'
+	a.op(0xC1, 0xD1, 0xE1)                          // pop bc: pop de: pop hl
+	a.op(0xDD, 0xE1, 0xFD, 0xE1)                    // pop ix: pop iy
+	// R counts the four instructions from pop af to the jump.
+	a.op(0x3E, cpu.R&0x80|(cpu.R-4)&0x7F, 0xED, 0x4F) // ld r,n
+	a.op(0xF1)                                        // pop af
+	a.op16(0x31, cpu.SP)                              // ld sp,SP
+	if cpu.IFF1 {
+		a.op(0xFB) // ei
+	} else {
+		a.op(0x00) // nop
+	}
+	a.op16(0xC3, cpu.PC) // jp PC
+
+	a.patch(regs)
+	for _, r := range []uint16{cpu.BC_, cpu.DE_, cpu.HL_, cpu.AF_, cpu.BC, cpu.DE, cpu.HL, cpu.IX, cpu.IY, cpu.AF} {
+		a.code = binary.LittleEndian.AppendUint16(a.code, r)
+	}
+	return a.code
+}
+
+// z80asm assembles Z80 code for a fixed origin.
+type z80asm struct {
+	org  uint16
+	code []byte
+}
+
+// op appends the bytes of an instruction and returns the offset of its last
+// byte, for patch or patchJR to fill in.
+func (a *z80asm) op(b ...byte) int {
+	a.code = append(a.code, b...)
+	return len(a.code) - 1
+}
+
+// op16 appends an instruction with a 16-bit operand: a one-byte opcode, or a
+// two-byte one above 0xFF. It returns the offset of the operand.
+func (a *z80asm) op16(opcode uint16, nn uint16) int {
+	if opcode > 0xFF {
+		a.code = append(a.code, byte(opcode>>8))
+	}
+	a.code = append(a.code, byte(opcode))
+	a.code = binary.LittleEndian.AppendUint16(a.code, nn)
+	return len(a.code) - 2
+}
+
+// patch sets the 16-bit operand at offset at to the current address.
+func (a *z80asm) patch(at int) {
+	binary.LittleEndian.PutUint16(a.code[at:], a.org+uint16(len(a.code)))
+}
+
+// patchJR sets the relative jump whose displacement is at offset at to jump
+// to the current address.
+func (a *z80asm) patchJR(at int) {
+	a.code[at] = byte(len(a.code) - (at + 1))
+}
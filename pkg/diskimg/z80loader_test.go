@@ -0,0 +1,395 @@
+package diskimg
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/zentools/pkg/snapshot"
+)
+
+// plus3 runs the loader and restore code ImportSnapshot writes on a +3 with
+// just the instructions they use, answering the +3DOS calls from a file.
+type plus3 struct {
+	t          *testing.T
+	ram        [8][16384]byte
+	p7ffd      byte
+	p1ffd      byte
+	border     byte
+	iff1       bool
+	im         byte
+	pc, sp     uint16
+	a, f       byte
+	bc, de, hl uint16
+	ix, iy     uint16
+	af_, bc_   uint16
+	de_, hl_   uint16
+	i, r       byte
+
+	file  []byte // the file +3DOS opens, header and all
+	name  string // the name it must be opened by
+	pos   int
+	reads int
+}
+
+func (m *plus3) slot(addr uint16) *byte {
+	switch {
+	case addr < 0x4000:
+		m.t.Fatalf("access to ROM at %#04x", addr)
+	case addr < 0x8000:
+		return &m.ram[5][addr-0x4000]
+	case addr < 0xC000:
+		return &m.ram[2][addr-0x8000]
+	}
+	return &m.ram[m.p7ffd&7][addr-0xC000]
+}
+
+func (m *plus3) rd(addr uint16) byte     { return *m.slot(addr) }
+func (m *plus3) wr(addr uint16, b byte)  { *m.slot(addr) = b }
+func (m *plus3) rd16(addr uint16) uint16 { return uint16(m.rd(addr)) | uint16(m.rd(addr+1))<<8 }
+func (m *plus3) wr16(addr, v uint16)     { m.wr(addr, byte(v)); m.wr(addr+1, byte(v>>8)) }
+func (m *plus3) rom() int                { return int(m.p1ffd>>2&1)<<1 | int(m.p7ffd>>4&1) }
+
+func (m *plus3) fetch() byte {
+	b := m.rd(m.pc)
+	m.pc++
+	return b
+}
+
+func (m *plus3) fetch16() uint16 {
+	v := m.rd16(m.pc)
+	m.pc += 2
+	return v
+}
+
+func (m *plus3) push(v uint16) { m.sp -= 2; m.wr16(m.sp, v) }
+func (m *plus3) pop() uint16   { v := m.rd16(m.sp); m.sp += 2; return v }
+func (m *plus3) refresh()      { m.r = m.r&0x80 | (m.r+1)&0x7F }
+func (m *plus3) carry(c bool) {
+	m.f &^= 1
+	if c {
+		m.f |= 1
+	}
+}
+
+func (m *plus3) out(port uint16) {
+	switch {
+	case port == 0x7FFD:
+		if m.p7ffd&0x20 == 0 {
+			m.p7ffd = m.a
+		}
+	case port == 0x1FFD:
+		m.p1ffd = m.a
+	case port&0xFF == 0xFE:
+		m.border = m.a & 7
+	default:
+		m.t.Fatalf("out (%#04x)", port)
+	}
+}
+
+// dos answers a +3DOS call at pc.
+func (m *plus3) dos() {
+	if m.rom() != 2 || m.sp < 0x4000 || m.sp >= 0xC000 {
+		m.t.Fatalf("+3DOS call %#04x with ROM %d, SP %#04x", m.pc, m.rom(), m.sp)
+	}
+	switch m.pc {
+	case dosOpen:
+		var name []byte
+		for a := m.hl; m.rd(a) != 0xFF; a++ {
+			name = append(name, m.rd(a))
+		}
+		ok := m.bc == 0x0001 && m.de == 0x0001 && string(name) == m.name
+		m.carry(ok)
+		m.pos = HeaderSize
+	case dosRead:
+		if m.bc>>8 != 0 || m.pos+int(m.de) > len(m.file) {
+			m.t.Fatalf("DOS_READ file %d, %d bytes at %d of %d", m.bc>>8, m.de, m.pos, len(m.file))
+		}
+		if end := int(m.hl) + int(m.de); int(m.hl) < loaderTop && end > holdAddr {
+			m.t.Fatalf("DOS_READ into %#04x-%#04x", m.hl, end-1)
+		}
+		page := m.p7ffd
+		m.p7ffd = m.p7ffd&^7 | byte(m.bc)
+		for i := range int(m.de) {
+			m.wr(m.hl+uint16(i), m.file[m.pos+i])
+		}
+		m.p7ffd = page
+		m.pos += int(m.de)
+		m.reads++
+		m.carry(true)
+	case dosClose:
+		m.carry(true)
+	default:
+		m.t.Fatalf("+3DOS call %#04x", m.pc)
+	}
+	m.pc = m.pop()
+}
+
+// run executes from pc until it reaches stop.
+func (m *plus3) run(stop uint16) {
+	for steps := 0; m.pc != stop; steps++ {
+		if steps > 10000 {
+			m.t.Fatalf("no return to %#04x", stop)
+		}
+		if m.pc < 0x4000 {
+			m.dos()
+			continue
+		}
+		m.step()
+	}
+}
+
+func (m *plus3) step() {
+	m.refresh()
+	op := m.fetch()
+	switch op {
+	case 0x00:
+	case 0x01:
+		m.bc = m.fetch16()
+	case 0x11:
+		m.de = m.fetch16()
+	case 0x21:
+		m.hl = m.fetch16()
+	case 0x31:
+		m.sp = m.fetch16()
+	case 0x06:
+		m.bc = uint16(m.fetch())<<8 | m.bc&0xFF
+	case 0x08:
+		af := uint16(m.a)<<8 | uint16(m.f)
+		m.a, m.f = byte(m.af_>>8), byte(m.af_)
+		m.af_ = af
+	case 0x32:
+		m.wr(m.fetch16(), m.a)
+	case 0x3A:
+		m.a = m.rd(m.fetch16())
+	case 0x3E:
+		m.a = m.fetch()
+	case 0x38:
+		d := int8(m.fetch())
+		if m.f&1 != 0 {
+			m.pc += uint16(d)
+		}
+	case 0xAF:
+		m.a, m.f = 0, 0x44
+	case 0xE6:
+		m.a &= m.fetch()
+		m.f = 0x10
+	case 0xF6:
+		m.a |= m.fetch()
+		m.f = 0
+	case 0xC1:
+		m.bc = m.pop()
+	case 0xD1:
+		m.de = m.pop()
+	case 0xE1:
+		m.hl = m.pop()
+	case 0xF1:
+		af := m.pop()
+		m.a, m.f = byte(af>>8), byte(af)
+	case 0xE5:
+		m.push(m.hl)
+	case 0xF5:
+		m.push(uint16(m.a)<<8 | uint16(m.f))
+	case 0xC3:
+		m.pc = m.fetch16()
+	case 0xD2:
+		nn := m.fetch16()
+		if m.f&1 == 0 {
+			m.pc = nn
+		}
+	case 0xC7:
+		m.t.Fatalf("reset at %#04x", m.pc-1)
+	case 0xC9:
+		m.pc = m.pop()
+	case 0xCD:
+		nn := m.fetch16()
+		m.push(m.pc)
+		m.pc = nn
+	case 0xD3:
+		m.out(uint16(m.a)<<8 | uint16(m.fetch()))
+	case 0xD9:
+		m.bc, m.bc_ = m.bc_, m.bc
+		m.de, m.de_ = m.de_, m.de
+		m.hl, m.hl_ = m.hl_, m.hl
+	case 0xF3:
+		m.iff1 = false
+	case 0xFB:
+		m.iff1 = true
+	case 0xDD, 0xFD:
+		m.refresh()
+		reg := &m.ix
+		if op == 0xFD {
+			reg = &m.iy
+		}
+		switch m.fetch() {
+		case 0xE1:
+			*reg = m.pop()
+		case 0x21:
+			*reg = m.fetch16()
+		default:
+			m.t.Fatalf("unknown opcode %#02x %#02x at %#04x", op, m.rd(m.pc-1), m.pc-2)
+		}
+	case 0xED:
+		m.refresh()
+		switch ed := m.fetch(); ed {
+		case 0x73:
+			m.wr16(m.fetch16(), m.sp)
+		case 0x7B:
+			m.sp = m.rd16(m.fetch16())
+		case 0x79:
+			m.out(m.bc)
+		case 0xB0:
+			for ; m.bc != 0; m.bc-- {
+				m.wr(m.de, m.rd(m.hl))
+				m.hl++
+				m.de++
+			}
+		case 0x47:
+			m.i = m.a
+		case 0x4F:
+			m.r = m.a
+		case 0x46, 0x56, 0x5E:
+			m.im = map[byte]byte{0x46: 0, 0x56: 1, 0x5E: 2}[ed]
+		default:
+			m.t.Fatalf("unknown opcode ED %#02x at %#04x", ed, m.pc-2)
+		}
+	default:
+		m.t.Fatalf("unknown opcode %#02x at %#04x", op, m.pc-1)
+	}
+}
+
+// basicPlus3 returns a +3 as +3 BASIC leaves it when DISK calls the loader at
+// loaderAddr, with a return address of 0xDEAD on the stack.
+func basicPlus3(t *testing.T, di *DiskImage, name string) *plus3 {
+	t.Helper()
+	ldr, err := fs.ReadFile(di, name+".LDR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := fs.ReadFile(di, name+".MEM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &plus3{t: t, p7ffd: 0x10, p1ffd: 0x04, pc: loaderAddr, sp: 0x5DF0, iy: 0x5C3A, hl_: 0x2758, iff1: true, file: file, name: name + ".MEM"}
+	m.wr(sysBANKM, m.p7ffd)
+	m.wr(sysBANK678, m.p1ffd)
+	for i, b := range ldr[HeaderSize:] {
+		m.wr(loaderAddr+uint16(i), b)
+	}
+	m.push(0xDEAD)
+	return m
+}
+
+// A snapshot on the disk restores the snapshot's memory and registers when
+// its loader runs, with the stack anywhere the restore routine fits.
+func TestImportSnapshot(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, sp := range []uint16{0x8000, 0xC020, 0x0000, 0x5A00} {
+		s := &snapshot.MachineState{Model: snapshot.Model48K}
+		for _, bank := range []int{5, 2, 0} {
+			rng.Read(s.Memory.RAM[bank][:])
+		}
+		s.CPU = snapshot.CPU{
+			AF: 0x1234, BC: 0x2345, DE: 0x3456, HL: 0x4567,
+			AF_: 0x5678, BC_: 0x6789, DE_: 0x789A, HL_: 0x89AB,
+			IX: 0x9ABC, IY: 0xABCD, SP: sp, PC: 0x8123,
+			I: 0x3F, R: 0x85, IFF1: true, IFF2: true, IM: 2,
+		}
+		s.IO.Border = 5
+		image, err := snapshot.EncodeZ80(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := decodeSnapshot(image)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		di := NewDiskImage()
+		if err := di.ImportSnapshot(bytes.NewReader(image), &SnapshotOptions{Name: "game"}); err != nil {
+			t.Fatalf("SP %#04x: ImportSnapshot: %v", sp, err)
+		}
+		text, err := di.ReadBasicText("DISK")
+		if err != nil || !strings.Contains(strings.Join(strings.Fields(text), " "), `LOAD "GAME.LDR" CODE 24064`) {
+			t.Fatalf("SP %#04x: DISK is %q, %v", sp, text, err)
+		}
+		if fi, err := di.StatFile("DISK"); err != nil || fi.Line != 10 {
+			t.Errorf("SP %#04x: DISK autostart %d, %v", sp, fi.Line, err)
+		}
+
+		m := basicPlus3(t, di, "GAME")
+		m.run(want.CPU.PC)
+		cpu := snapshot.CPU{
+			AF: uint16(m.a)<<8 | uint16(m.f), BC: m.bc, DE: m.de, HL: m.hl,
+			AF_: m.af_, BC_: m.bc_, DE_: m.de_, HL_: m.hl_,
+			IX: m.ix, IY: m.iy, SP: m.sp, PC: m.pc,
+			I: m.i, R: m.r, IFF1: m.iff1, IFF2: m.iff1, IM: m.im,
+		}
+		if cpu != want.CPU || m.border != 5 || m.p7ffd != 0x30 || m.p1ffd != 0x04 {
+			t.Errorf("SP %#04x: registers %+v, border %d, paging %#02x %#02x; want %+v",
+				sp, cpu, m.border, m.p7ffd, m.p1ffd, want.CPU)
+		}
+		top := int(want.CPU.SP)
+		if top == 0 {
+			top = 0x10000
+		}
+		tail := top - len(snapshotTail(want, 0, 0))
+		for addr := 0x4000; addr < 0x10000; addr++ {
+			if addr >= tail && addr < top {
+				continue
+			}
+			bank, off := map[int]int{1: 5, 2: 2, 3: 0}[addr>>14], addr&0x3FFF
+			if got := m.rd(uint16(addr)); got != want.Memory.RAM[bank][off] {
+				t.Fatalf("SP %#04x: %#04x holds %#02x, want %#02x", sp, addr, got, want.Memory.RAM[bank][off])
+			}
+		}
+	}
+}
+
+// A loader that cannot open its memory file returns to BASIC as it found it,
+// and a snapshot it cannot restore is refused.
+func TestImportSnapshotFailures(t *testing.T) {
+	s := &snapshot.MachineState{Model: snapshot.Model48K}
+	s.CPU.SP, s.CPU.PC = 0x8000, 0x8000
+	image, err := snapshot.EncodeZ80(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	di := NewDiskImage()
+	if err := di.ImportSnapshot(bytes.NewReader(image), nil); err != nil {
+		t.Fatalf("ImportSnapshot: %v", err)
+	}
+	m := basicPlus3(t, di, "SNAP")
+	m.name = "OTHER.MEM"
+	m.iy = 0
+	m.run(0xDEAD)
+	if m.sp != 0x5DF0 || m.iy != 0x5C3A || m.hl_ != 0x2758 || !m.iff1 || m.reads != 0 ||
+		m.p7ffd != 0x10 || m.p1ffd != 0x04 || m.rd(sysBANKM) != 0x10 || m.rd(sysBANK678) != 0x04 {
+		t.Errorf("returned to BASIC with SP %#04x, IY %#04x, HL' %#04x, paging %#02x %#02x after %d reads",
+			m.sp, m.iy, m.hl_, m.p7ffd, m.p1ffd, m.reads)
+	}
+
+	for _, sp := range []uint16{0x4020, 0x5B10, 0x6010} {
+		s.CPU.SP = sp
+		image, err := snapshot.EncodeZ80(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := di.ImportSnapshot(bytes.NewReader(image), nil); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("SP %#04x: ImportSnapshot = %v, want ErrUnsupported", sp, err)
+		}
+	}
+	s.Model = snapshot.Model128K
+	if image, err = snapshot.EncodeZ80v3(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := di.ImportSnapshot(bytes.NewReader(image), nil); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("128K: ImportSnapshot = %v, want ErrUnsupported", err)
+	}
+	if err := di.ImportSnapshot(strings.NewReader("short"), nil); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("ImportSnapshot(short) = %v, want ErrInvalidSnapshot", err)
+	}
+}